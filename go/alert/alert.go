@@ -0,0 +1,231 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package alert evaluates alert rules over the readings of SDS011
+// sensors.
+//
+// A Rule fires when a metric of a sensor, like its PM2.5 level, stays
+// above a threshold for a while, and resolves when it stays below the
+// threshold, less some hysteresis, for as long. An Engine is fed the
+// readings of every sensor, and returns a Start event when an alert
+// fires and a Resolve event when it resolves.
+package alert
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ryszard/sds011/go/aqi"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// Metrics are the metrics rules can be about, with their values in a
+// reading. The second value is false if the reading doesn't have the
+// metric.
+var Metrics = map[string]func(p *sds011.Point) (float64, bool){
+	"pm25": func(p *sds011.Point) (float64, bool) { return p.PM25, true },
+	"pm10": func(p *sds011.Point) (float64, bool) { return p.PM10, true },
+	"pm1":  func(p *sds011.Point) (float64, bool) { return p.PM1, p.HasPM1 },
+	// aqi is the US AQI of the worse of PM2.5 and PM10, taken from
+	// a single reading rather than the usual 24-hour mean.
+	"aqi": func(p *sds011.Point) (float64, bool) {
+		return math.Max(float64(aqi.PM25(p.PM25)), float64(aqi.PM10(p.PM10))), true
+	},
+}
+
+// A Rule describes when to alert.
+type Rule struct {
+	// Name identifies the rule in the events.
+	Name string
+	// Metric is one of Metrics.
+	Metric string
+	// Threshold is the value the metric has to go above.
+	Threshold float64
+	// Sustain is how long the metric has to stay above the
+	// threshold for the alert to fire, and below it for the alert
+	// to resolve. Zero means a single reading is enough.
+	Sustain time.Duration
+	// Hysteresis is how far below the threshold the metric has to
+	// go for the alert to resolve, so that values hovering around
+	// the threshold don't make it flap.
+	Hysteresis float64
+	// Severity is passed on in the events, like "warning" or
+	// "critical". It defaults to "warning".
+	Severity string
+	// Sensors are the sensors the rule applies to. If it's empty,
+	// it applies to all of them.
+	Sensors []string
+}
+
+// appliesTo returns whether the rule applies to sensor.
+func (r *Rule) appliesTo(sensor string) bool {
+	if len(r.Sensors) == 0 {
+		return true
+	}
+	for _, s := range r.Sensors {
+		if s == sensor {
+			return true
+		}
+	}
+	return false
+}
+
+// Kind is the kind of an event.
+type Kind string
+
+const (
+	Start   Kind = "start"
+	Resolve Kind = "resolve"
+)
+
+// An Event is an alert firing or resolving.
+type Event struct {
+	Kind     Kind   `json:"kind"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Sensor   string `json:"sensor"`
+	Metric   string `json:"metric"`
+	// Threshold is the threshold of the rule, and Value the value
+	// of the metric at Time.
+	Threshold float64   `json:"threshold"`
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+	// Started is when the alert fired. For a Start, it's Time.
+	Started time.Time `json:"started"`
+	// Point is the reading that caused the event.
+	Point sds011.Point `json:"-"`
+}
+
+func (e *Event) String() string {
+	return fmt.Sprintf("%v: %v %v alert %v: %v %v (threshold %v) at %v",
+		e.Sensor, e.Severity, e.Rule, e.Kind, e.Metric, e.Value, e.Threshold, e.Time.Format(time.RFC3339))
+}
+
+// state is the state of a rule for a sensor.
+type state struct {
+	// firing is the Start event of the alert, if it's firing.
+	firing *Event
+	// since is when the metric crossed to the other side of the
+	// threshold from where firing says it should be. It's zero if
+	// it hasn't.
+	since time.Time
+}
+
+// An Engine evaluates rules. It isn't safe for concurrent use.
+type Engine struct {
+	rules []Rule
+	// states are the states of the rules, by sensor.
+	states map[string][]state
+}
+
+// NewEngine returns an engine evaluating rules. The rules must have
+// known metrics.
+func NewEngine(rules ...Rule) (*Engine, error) {
+	e := &Engine{states: make(map[string][]state)}
+	if err := e.SetRules(rules...); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetRules replaces the rules of the engine. The state of a rule with
+// the name of an old one is kept, so an alert that is firing goes on
+// firing, with the new threshold deciding when it resolves. The alerts
+// of rules that are gone are dropped without resolving.
+func (e *Engine) SetRules(rules ...Rule) error {
+	rules = append([]Rule(nil), rules...)
+	for i := range rules {
+		r := &rules[i]
+		if _, ok := Metrics[r.Metric]; !ok {
+			return fmt.Errorf("alert: rule %q: unknown metric %q", r.Name, r.Metric)
+		}
+		if r.Severity == "" {
+			r.Severity = "warning"
+		}
+	}
+	old := make(map[string]int)
+	for i, r := range e.rules {
+		old[r.Name] = i
+	}
+	for sensor, states := range e.states {
+		kept := make([]state, len(rules))
+		for i, r := range rules {
+			if j, ok := old[r.Name]; ok {
+				kept[i] = states[j]
+			}
+		}
+		e.states[sensor] = kept
+	}
+	e.rules = rules
+	return nil
+}
+
+// Add adds a reading of a sensor, and returns the events it causes, if
+// any.
+func (e *Engine) Add(sensor string, p sds011.Point) []Event {
+	states, ok := e.states[sensor]
+	if !ok {
+		states = make([]state, len(e.rules))
+		e.states[sensor] = states
+	}
+	var events []Event
+	for i := range e.rules {
+		r, s := &e.rules[i], &states[i]
+		if !r.appliesTo(sensor) {
+			continue
+		}
+		value, ok := Metrics[r.Metric](&p)
+		if !ok {
+			continue
+		}
+		crossed := value > r.Threshold
+		if s.firing != nil {
+			crossed = value <= r.Threshold-r.Hysteresis
+		}
+		if !crossed {
+			s.since = time.Time{}
+			continue
+		}
+		if s.since.IsZero() {
+			s.since = p.Timestamp
+		}
+		if p.Timestamp.Sub(s.since) < r.Sustain {
+			continue
+		}
+		event := Event{Rule: r.Name, Severity: r.Severity, Sensor: sensor, Metric: r.Metric, Threshold: r.Threshold, Value: value, Time: p.Timestamp, Started: p.Timestamp, Point: p}
+		if s.firing == nil {
+			event.Kind = Start
+			s.firing = &event
+		} else {
+			event.Kind, event.Started = Resolve, s.firing.Started
+			s.firing = nil
+		}
+		s.since = time.Time{}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Active returns the Start events of the alerts of a sensor that are
+// firing.
+func (e *Engine) Active(sensor string) []Event {
+	var events []Event
+	for _, s := range e.states[sensor] {
+		if s.firing != nil {
+			events = append(events, *s.firing)
+		}
+	}
+	return events
+}
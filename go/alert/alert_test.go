@@ -0,0 +1,170 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var t0 = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// at returns a point with PM2.5 pm25 at minutes after t0.
+func at(minutes int, pm25 float64) sds011.Point {
+	return sds011.Point{PM25: pm25, PM10: pm25, Timestamp: t0.Add(time.Duration(minutes) * time.Minute)}
+}
+
+// kinds feeds the points of the kitchen sensor to e, and returns the
+// events they cause, each as when it happened after t0 and its kind,
+// like "10m0s start".
+func kinds(e *Engine, points ...sds011.Point) []string {
+	var got []string
+	for _, p := range points {
+		for _, ev := range e.Add("kitchen", p) {
+			got = append(got, ev.Time.Sub(t0).String()+" "+string(ev.Kind))
+		}
+	}
+	return got
+}
+
+func TestEngine(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rule   Rule
+		points []sds011.Point
+		want   []string
+	}{
+		{
+			name:   "a single reading",
+			rule:   Rule{Name: "pm", Metric: "pm25", Threshold: 35},
+			points: []sds011.Point{at(0, 10), at(1, 40), at(2, 50), at(3, 30)},
+			want:   []string{"1m0s start", "3m0s resolve"},
+		},
+		{
+			name: "at the threshold",
+			rule: Rule{Name: "pm", Metric: "pm25", Threshold: 35},
+			// Going above the threshold fires, getting back to it
+			// resolves.
+			points: []sds011.Point{at(0, 35), at(1, 35.1), at(2, 35)},
+			want:   []string{"1m0s start", "2m0s resolve"},
+		},
+		{
+			name:   "sustained",
+			rule:   Rule{Name: "pm", Metric: "pm25", Threshold: 35, Sustain: 10 * time.Minute},
+			points: []sds011.Point{at(0, 40), at(5, 40), at(10, 40), at(15, 20), at(20, 20), at(25, 20)},
+			want:   []string{"10m0s start", "25m0s resolve"},
+		},
+		{
+			name: "not sustained",
+			rule: Rule{Name: "pm", Metric: "pm25", Threshold: 35, Sustain: 10 * time.Minute},
+			// Dipping below the threshold starts the wait over.
+			points: []sds011.Point{at(0, 40), at(5, 40), at(8, 30), at(10, 40), at(15, 40)},
+			want:   nil,
+		},
+		{
+			name: "hysteresis",
+			rule: Rule{Name: "pm", Metric: "pm25", Threshold: 35, Hysteresis: 5},
+			// Hovering around the threshold doesn't resolve, only
+			// going 5 below it does.
+			points: []sds011.Point{at(0, 36), at(1, 34), at(2, 36), at(3, 31), at(4, 30), at(5, 33), at(6, 36)},
+			want:   []string{"0s start", "4m0s resolve", "6m0s start"},
+		},
+		{
+			name:   "another sensor",
+			rule:   Rule{Name: "pm", Metric: "pm25", Threshold: 35, Sensors: []string{"garden"}},
+			points: []sds011.Point{at(0, 100)},
+			want:   nil,
+		},
+		{
+			name: "no PM1",
+			rule: Rule{Name: "pm", Metric: "pm1", Threshold: 5},
+			// The SDS011 doesn't measure PM1.
+			points: []sds011.Point{at(0, 100)},
+			want:   nil,
+		},
+		{
+			name: "aqi",
+			rule: Rule{Name: "aqi", Metric: "aqi", Threshold: 100},
+			// 35.4 µg/m³ of PM2.5 is an AQI of 100, 35.5 of 101.
+			points: []sds011.Point{at(0, 35.4), at(1, 35.5)},
+			want:   []string{"1m0s start"},
+		},
+	} {
+		e, err := NewEngine(tc.rule)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if got := kinds(e, tc.points...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEngineEvents(t *testing.T) {
+	e, err := NewEngine(Rule{Name: "pm", Metric: "pm25", Threshold: 35})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Add("kitchen", at(0, 40))
+	active := e.Active("kitchen")
+	if len(active) != 1 {
+		t.Fatalf("active: %v, want 1", active)
+	}
+	if a := active[0]; a.Severity != "warning" || a.Value != 40 || a.Threshold != 35 || !a.Started.Equal(t0) {
+		t.Errorf("active: %+v", a)
+	}
+	events := e.Add("kitchen", at(5, 20))
+	if len(events) != 1 {
+		t.Fatalf("events: %v, want 1", events)
+	}
+	if ev := events[0]; ev.Kind != Resolve || !ev.Started.Equal(t0) || !ev.Time.Equal(t0.Add(5*time.Minute)) || ev.Value != 20 {
+		t.Errorf("resolve: %+v", ev)
+	}
+	if active := e.Active("kitchen"); len(active) != 0 {
+		t.Errorf("active after resolving: %v", active)
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	e, err := NewEngine(Rule{Name: "pm", Metric: "pm25", Threshold: 35}, Rule{Name: "gone", Metric: "pm10", Threshold: 35})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(e, at(0, 40)); len(got) != 2 {
+		t.Fatalf("events: %q, want 2", got)
+	}
+	// The firing alert of a rule that stays goes on firing, and
+	// the new threshold decides when it resolves.
+	if err := e.SetRules(Rule{Name: "new", Metric: "pm10", Threshold: 100}, Rule{Name: "pm", Metric: "pm25", Threshold: 20}); err != nil {
+		t.Fatal(err)
+	}
+	active := e.Active("kitchen")
+	if len(active) != 1 || active[0].Rule != "pm" {
+		t.Errorf("active after SetRules: %v, want pm", active)
+	}
+	if got, want := kinds(e, at(1, 30), at(2, 15)), []string{"2m0s resolve"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after SetRules: %q, want %q", got, want)
+	}
+
+	if err := e.SetRules(Rule{Name: "bad", Metric: "co2"}); err == nil {
+		t.Error("SetRules with an unknown metric: no error")
+	}
+	if _, err := NewEngine(Rule{Name: "bad", Metric: "co2"}); err == nil {
+		t.Error("NewEngine with an unknown metric: no error")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aqi converts PM levels to the US EPA Air Quality Index.
+//
+// The index is defined for averages over 24 hours, which are of little
+// use for telling how the air is now. What AirNow and the apps built
+// on it report instead is the NowCast: an average of the last 12
+// hours, weighted towards the recent ones the more the levels change.
+// To get the same values, feed the readings to an Hourly and convert
+// its NowCast:
+//
+//	h := new(aqi.Hourly)
+//	h.Add(point.Timestamp, point.PM25)
+//	if c, ok := aqi.NowCast(h.Averages(time.Now())); ok {
+//		fmt.Println(aqi.PM25(c))
+//	}
+package aqi
+
+import (
+	"math"
+	"time"
+)
+
+// A breakpoint maps the concentrations [lo, hi] linearly to the index
+// values [indexLo, indexHi].
+type breakpoint struct {
+	lo, hi           float64
+	indexLo, indexHi float64
+}
+
+// The EPA breakpoints, as revised in 2024.
+var (
+	pm25Breakpoints = []breakpoint{
+		{0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	}
+	pm10Breakpoints = []breakpoint{
+		{0, 54, 0, 50},
+		{55, 154, 51, 100},
+		{155, 254, 101, 150},
+		{255, 354, 151, 200},
+		{355, 424, 201, 300},
+		{425, 604, 301, 500},
+	}
+)
+
+// index returns the index value of concentration c, rounded to the
+// nearest integer. Concentrations past the last breakpoint are 500.
+func index(breakpoints []breakpoint, c float64) int {
+	for _, b := range breakpoints {
+		if c <= b.hi {
+			return int(math.Round((b.indexHi-b.indexLo)/(b.hi-b.lo)*(c-b.lo) + b.indexLo))
+		}
+	}
+	return 500
+}
+
+// PM25 returns the index value of a PM2.5 concentration in µg/m³,
+// which is truncated to 0.1 µg/m³ first.
+func PM25(c float64) int {
+	return index(pm25Breakpoints, math.Floor(c*10)/10)
+}
+
+// PM10 returns the index value of a PM10 concentration in µg/m³, which
+// is truncated to 1 µg/m³ first.
+func PM10(c float64) int {
+	return index(pm10Breakpoints, math.Floor(c))
+}
+
+// Category is the level of health concern of an index value.
+type Category int
+
+const (
+	Good Category = iota
+	Moderate
+	UnhealthyForSensitiveGroups
+	Unhealthy
+	VeryUnhealthy
+	Hazardous
+)
+
+var categoryNames = []string{
+	"Good",
+	"Moderate",
+	"Unhealthy for Sensitive Groups",
+	"Unhealthy",
+	"Very Unhealthy",
+	"Hazardous",
+}
+
+func (c Category) String() string {
+	if c < 0 || int(c) >= len(categoryNames) {
+		return "Unknown"
+	}
+	return categoryNames[c]
+}
+
+// CategoryOf returns the category of an index value.
+func CategoryOf(index int) Category {
+	switch {
+	case index <= 50:
+		return Good
+	case index <= 100:
+		return Moderate
+	case index <= 150:
+		return UnhealthyForSensitiveGroups
+	case index <= 200:
+		return Unhealthy
+	case index <= 300:
+		return VeryUnhealthy
+	}
+	return Hazardous
+}
+
+// NowCast returns the NowCast of hourly averages, the most recent
+// first, with NaN for the hours without data. Only the first 12 hours
+// are used. As the EPA requires, at least 2 of the 3 most recent hours
+// must have data; if they don't, ok is false.
+func NowCast(hourly []float64) (c float64, ok bool) {
+	if len(hourly) > 12 {
+		hourly = hourly[:12]
+	}
+	var recent int
+	for i := 0; i < 3 && i < len(hourly); i++ {
+		if !math.IsNaN(hourly[i]) {
+			recent++
+		}
+	}
+	if recent < 2 {
+		return 0, false
+	}
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range hourly {
+		if !math.IsNaN(v) {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+	}
+	if max <= 0 {
+		return 0, true
+	}
+	weight := math.Max(min/max, 0.5)
+	var sum, weights float64
+	for i, v := range hourly {
+		if math.IsNaN(v) {
+			continue
+		}
+		w := math.Pow(weight, float64(i))
+		sum += w * v
+		weights += w
+	}
+	return sum / weights, true
+}
+
+// Hourly averages readings over the clock hours, keeping the last 12.
+// The zero value is ready to use. It isn't safe for concurrent use.
+type Hourly struct {
+	// hour is the start of the latest hour, and sums and counts are
+	// of the hours before it, the latest first.
+	hour   time.Time
+	sums   [12]float64
+	counts [12]int
+}
+
+// shift moves the hours so that the latest is the one starting at hour.
+func (h *Hourly) shift(hour time.Time) {
+	n := int(hour.Sub(h.hour) / time.Hour)
+	if h.hour.IsZero() || n >= len(h.sums) {
+		h.sums, h.counts = [12]float64{}, [12]int{}
+	} else if n > 0 {
+		copy(h.sums[n:], h.sums[:len(h.sums)-n])
+		copy(h.counts[n:], h.counts[:len(h.counts)-n])
+		for i := 0; i < n; i++ {
+			h.sums[i], h.counts[i] = 0, 0
+		}
+	}
+	h.hour = hour
+}
+
+// Add adds a reading taken at t. Readings older than 12 hours, or
+// from before the latest reading's hour, may be dropped.
+func (h *Hourly) Add(t time.Time, c float64) {
+	hour := t.Truncate(time.Hour)
+	if hour.After(h.hour) {
+		h.shift(hour)
+	}
+	i := int(h.hour.Sub(hour) / time.Hour)
+	if i >= len(h.sums) {
+		return
+	}
+	h.sums[i] += c
+	h.counts[i]++
+}
+
+// Averages returns the averages of the 12 hours up to the one of now,
+// the most recent first, with NaN for the hours without readings. The
+// hour of now is usually still going on, and its average is of the
+// readings so far.
+func (h *Hourly) Averages(now time.Time) []float64 {
+	averages := make([]float64, len(h.sums))
+	offset := int(now.Truncate(time.Hour).Sub(h.hour) / time.Hour)
+	for i := range averages {
+		averages[i] = math.NaN()
+		if j := i - offset; j >= 0 && j < len(h.sums) && h.counts[j] > 0 {
+			averages[i] = h.sums[j] / float64(h.counts[j])
+		}
+	}
+	return averages
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aqi
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPM25(t *testing.T) {
+	// The breakpoints of the EPA's 2024 revision of the PM NAAQS:
+	// the top of every category, and the bottom of the next one.
+	for _, tc := range []struct {
+		c    float64
+		want int
+	}{
+		{0, 0},
+		{9.0, 50},
+		{9.1, 51},
+		{12.0, 56},
+		{35.4, 100},
+		{35.5, 101},
+		{55.4, 150},
+		{55.5, 151},
+		{125.4, 200},
+		{125.5, 201},
+		{225.4, 300},
+		{225.5, 301},
+		{325.4, 500},
+		{1000, 500},
+		// Truncated to 0.1 µg/m³ first.
+		{9.09, 50},
+		{35.49, 100},
+	} {
+		if got := PM25(tc.c); got != tc.want {
+			t.Errorf("PM25(%v): %v, want %v", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestPM10(t *testing.T) {
+	for _, tc := range []struct {
+		c    float64
+		want int
+	}{
+		{0, 0},
+		{54, 50},
+		{55, 51},
+		{154, 100},
+		{155, 101},
+		{254, 150},
+		{255, 151},
+		{354, 200},
+		{355, 201},
+		{424, 300},
+		{425, 301},
+		{604, 500},
+		{1000, 500},
+		// Truncated to 1 µg/m³ first.
+		{54.9, 50},
+	} {
+		if got := PM10(tc.c); got != tc.want {
+			t.Errorf("PM10(%v): %v, want %v", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestCategoryOf(t *testing.T) {
+	for _, tc := range []struct {
+		index int
+		want  string
+	}{
+		{0, "Good"},
+		{50, "Good"},
+		{51, "Moderate"},
+		{101, "Unhealthy for Sensitive Groups"},
+		{151, "Unhealthy"},
+		{201, "Very Unhealthy"},
+		{300, "Very Unhealthy"},
+		{301, "Hazardous"},
+		{500, "Hazardous"},
+	} {
+		if got := CategoryOf(tc.index).String(); got != tc.want {
+			t.Errorf("CategoryOf(%v): %q, want %q", tc.index, got, tc.want)
+		}
+	}
+}
+
+func TestNowCast(t *testing.T) {
+	nan := math.NaN()
+	// Worked out from the EPA's definition: the weight is the
+	// minimum over the maximum of the 12 hours, but no less than
+	// 0.5, and hour i back counts weight^i.
+	for _, tc := range []struct {
+		name   string
+		hourly []float64
+		want   float64
+		ok     bool
+	}{
+		{"steady", []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10}, 10, true},
+		{"rising", []float64{40, 30}, (40 + 0.75*30) / 1.75, true},
+		{"the weight is at least 0.5", []float64{20, 10, 5}, (20 + 0.5*10 + 0.25*5) / 1.75, true},
+		{"a missing hour", []float64{nan, 10, 20}, (0.5*10 + 0.25*20) / 0.75, true},
+		{"two of the last three hours missing", []float64{nan, 10, nan, 20}, 0, false},
+		{"no data", nil, 0, false},
+		{"all zeros", []float64{0, 0, 0}, 0, true},
+		{"only 12 hours count", []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 1000}, 10, true},
+	} {
+		got, ok := NowCast(tc.hourly)
+		if ok != tc.ok || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%v: NowCast(%v) = %v, %v, want %v, %v", tc.name, tc.hourly, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHourly(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var h Hourly
+	for _, r := range []struct {
+		minutes int
+		c       float64
+	}{
+		{0, 10}, {30, 20}, // 12:00
+		{120, 30}, // 14:00
+		{90, 40},  // 13:00, out of order
+		{-60, 50}, // 11:00, before the latest hour
+		{150, 50}, // 14:00
+	} {
+		h.Add(t0.Add(time.Duration(r.minutes)*time.Minute), r.c)
+	}
+	got := h.Averages(t0.Add(3 * time.Hour))
+	want := []float64{math.NaN(), 40, 40, 15, 50}
+	for i, w := range want {
+		if got[i] != w && !(math.IsNaN(got[i]) && math.IsNaN(w)) {
+			t.Errorf("averages: %v, want %v first", got, want)
+			break
+		}
+	}
+	for _, v := range got[len(want):] {
+		if !math.IsNaN(v) {
+			t.Errorf("averages: %v, want NaNs after %v", got, want)
+			break
+		}
+	}
+
+	// After 12 hours without readings, the old ones are gone.
+	h.Add(t0.Add(14*time.Hour), 5)
+	got = h.Averages(t0.Add(14 * time.Hour))
+	if got[0] != 5 || !math.IsNaN(got[1]) || !math.IsNaN(got[11]) {
+		t.Errorf("averages after 12 hours: %v, want 5 and NaNs", got)
+	}
+}
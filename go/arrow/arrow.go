@@ -0,0 +1,213 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package arrow writes readings as an Arrow IPC stream, which pandas,
+// polars and pyarrow load with the types of the columns intact:
+//
+//	sensor     utf8
+//	timestamp  timestamp[us, tz=UTC]
+//	pm25       double
+//	pm10       double
+//	pm1        double, null unless the sensor measures it
+//
+// Only pm1 is nullable. The stream is a schema, followed by a record
+// batch for every call to Writer.Write, and an end-of-stream marker.
+package arrow
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// The enums of the Arrow schema.
+const (
+	metadataV5 = 4
+
+	headerSchema      = 1
+	headerRecordBatch = 3
+
+	typeFloatingPoint = 3
+	typeUtf8          = 5
+	typeTimestamp     = 10
+
+	precisionDouble = 2
+	unitMicrosecond = 2
+)
+
+// continuation starts every message.
+const continuation = 0xFFFFFFFF
+
+// schema returns the schema message, with metadata as its custom
+// metadata.
+func schema(metadata map[string]string) table {
+	double := table{i16(0, precisionDouble)}
+	column := func(name string, nullable bool, typ uint8, t table) object {
+		return table{str(0, name), boolean(1, nullable), u8(2, typ), ref(3, t), ref(5, vector{})}
+	}
+	fields := vector{
+		column("sensor", false, typeUtf8, table{}),
+		column("timestamp", false, typeTimestamp, table{i16(0, unitMicrosecond), str(1, "UTC")}),
+		column("pm25", false, typeFloatingPoint, double),
+		column("pm10", false, typeFloatingPoint, double),
+		column("pm1", true, typeFloatingPoint, double),
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := vector{}
+	for _, k := range keys {
+		pairs = append(pairs, table{str(0, k), str(1, metadata[k])})
+	}
+	return table{
+		i16(0, metadataV5),
+		u8(1, headerSchema),
+		ref(2, table{ref(1, fields), ref(2, pairs)}),
+		i64(3, 0),
+	}
+}
+
+// body is the body of a record batch: its buffers, each aligned to 8
+// bytes.
+type body struct {
+	data    []byte
+	buffers structs
+}
+
+// add appends a buffer.
+func (b *body) add(buf []byte) {
+	b.buffers = append(b.buffers, [2]int64{int64(len(b.data)), int64(len(buf))})
+	b.data = append(b.data, buf...)
+	for len(b.data)%8 != 0 {
+		b.data = append(b.data, 0)
+	}
+}
+
+// recordBatch returns the record batch message of readings, and its
+// body.
+func recordBatch(readings []*sink.Reading) (table, []byte) {
+	n := len(readings)
+	offsets := make([]byte, 0, 4*(n+1))
+	var sensors []byte
+	timestamps := make([]byte, 0, 8*n)
+	pm25 := make([]byte, 0, 8*n)
+	pm10 := make([]byte, 0, 8*n)
+	pm1 := make([]byte, 0, 8*n)
+	valid := make([]byte, (n+7)/8)
+	nulls := 0
+	for i, r := range readings {
+		offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(sensors)))
+		sensors = append(sensors, r.Sensor...)
+		timestamps = binary.LittleEndian.AppendUint64(timestamps, uint64(r.Timestamp.UnixNano()/1000))
+		pm25 = binary.LittleEndian.AppendUint64(pm25, math.Float64bits(r.PM25))
+		pm10 = binary.LittleEndian.AppendUint64(pm10, math.Float64bits(r.PM10))
+		if r.HasPM1 {
+			valid[i/8] |= 1 << (i % 8)
+			pm1 = binary.LittleEndian.AppendUint64(pm1, math.Float64bits(r.PM1))
+		} else {
+			nulls++
+			pm1 = binary.LittleEndian.AppendUint64(pm1, 0)
+		}
+	}
+	offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(sensors)))
+
+	// Every column has a validity buffer, empty if there are no nulls.
+	var b body
+	b.add(nil)
+	b.add(offsets)
+	b.add(sensors)
+	for _, values := range [][]byte{timestamps, pm25, pm10} {
+		b.add(nil)
+		b.add(values)
+	}
+	if nulls == 0 {
+		valid = nil
+	}
+	b.add(valid)
+	b.add(pm1)
+
+	nodes := structs{{int64(n), 0}, {int64(n), 0}, {int64(n), 0}, {int64(n), 0}, {int64(n), int64(nulls)}}
+	return table{
+		i16(0, metadataV5),
+		u8(1, headerRecordBatch),
+		ref(2, table{i64(0, int64(n)), ref(1, nodes), ref(2, b.buffers)}),
+		i64(3, int64(len(b.data))),
+	}, b.data
+}
+
+// A Writer writes readings to an Arrow IPC stream.
+type Writer struct {
+	w        io.Writer
+	metadata map[string]string
+	started  bool
+}
+
+// NewWriter returns a writer writing to w. metadata, if not nil, is
+// stored in the schema as its custom metadata, for example to say where
+// the readings come from.
+func NewWriter(w io.Writer, metadata map[string]string) *Writer {
+	return &Writer{w: w, metadata: metadata}
+}
+
+// message writes an encapsulated message: its metadata, padded to 8
+// bytes, and its body.
+func (w *Writer) message(metadata table, body []byte) error {
+	fb := finish(metadata)
+	for len(fb)%8 != 0 {
+		fb = append(fb, 0)
+	}
+	prefix := binary.LittleEndian.AppendUint32(nil, continuation)
+	prefix = binary.LittleEndian.AppendUint32(prefix, uint32(len(fb)))
+	for _, b := range [][]byte{prefix, fb, body} {
+		if _, err := w.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start writes the schema, unless it's been written.
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.message(schema(w.metadata), nil)
+}
+
+// Write writes readings as a record batch, after the schema if it's the
+// first.
+func (w *Writer) Write(readings []*sink.Reading) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	return w.message(recordBatch(readings))
+}
+
+// Close writes the end-of-stream marker, after the schema if nothing
+// was written, so that the stream is valid. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	eos := binary.LittleEndian.AppendUint32(nil, continuation)
+	_, err := w.w.Write(append(eos, 0, 0, 0, 0))
+	return err
+}
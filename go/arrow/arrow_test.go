@@ -0,0 +1,367 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// The tests read the stream back following the Arrow columnar format
+// specification (arrow.apache.org/docs/format/Columnar.html) and the
+// FlatBuffers binary format (flatbuffers.dev/internals), rather than
+// with this package's own idea of them.
+
+// flat is a table of a FlatBuffer.
+type flat struct {
+	buf []byte
+	pos int
+}
+
+// root returns the root table of buf.
+func root(buf []byte) flat {
+	return flat{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns where field id is, or 0 if it's absent.
+func (f flat) field(id int) int {
+	vtable := f.pos - int(int32(binary.LittleEndian.Uint32(f.buf[f.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(f.buf[vtable:])) {
+		return 0
+	}
+	if off := int(binary.LittleEndian.Uint16(f.buf[vtable+4+2*id:])); off != 0 {
+		return f.pos + off
+	}
+	return 0
+}
+
+func (f flat) u8(id int) uint8 {
+	if p := f.field(id); p != 0 {
+		return f.buf[p]
+	}
+	return 0
+}
+
+func (f flat) i16(id int) int16 {
+	if p := f.field(id); p != 0 {
+		return int16(binary.LittleEndian.Uint16(f.buf[p:]))
+	}
+	return 0
+}
+
+func (f flat) i64(id int) int64 {
+	if p := f.field(id); p != 0 {
+		return int64(binary.LittleEndian.Uint64(f.buf[p:]))
+	}
+	return 0
+}
+
+// deref returns where the offset of field id points.
+func (f flat) deref(id int) int {
+	p := f.field(id)
+	if p == 0 {
+		return 0
+	}
+	return p + int(binary.LittleEndian.Uint32(f.buf[p:]))
+}
+
+func (f flat) table(id int) flat {
+	return flat{f.buf, f.deref(id)}
+}
+
+func (f flat) str(id int) string {
+	p := f.deref(id)
+	if p == 0 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(f.buf[p:]))
+	return string(f.buf[p+4 : p+4+n])
+}
+
+// tables returns the tables of the vector of field id.
+func (f flat) tables(id int) []flat {
+	p := f.deref(id)
+	if p == 0 {
+		return nil
+	}
+	var tables []flat
+	for i := 0; i < int(binary.LittleEndian.Uint32(f.buf[p:])); i++ {
+		q := p + 4 + 4*i
+		tables = append(tables, flat{f.buf, q + int(binary.LittleEndian.Uint32(f.buf[q:]))})
+	}
+	return tables
+}
+
+// pairs returns the structs of two longs of the vector of field id.
+func (f flat) pairs(id int) [][2]int64 {
+	p := f.deref(id)
+	var pairs [][2]int64
+	for i := 0; i < int(binary.LittleEndian.Uint32(f.buf[p:])); i++ {
+		q := p + 4 + 16*i
+		pairs = append(pairs, [2]int64{int64(binary.LittleEndian.Uint64(f.buf[q:])), int64(binary.LittleEndian.Uint64(f.buf[q+8:]))})
+	}
+	return pairs
+}
+
+// message is an encapsulated message.
+type message struct {
+	meta flat
+	body []byte
+}
+
+// messages splits an IPC stream into its messages, checking the
+// framing and the end-of-stream marker.
+func messages(t *testing.T, stream []byte) []message {
+	t.Helper()
+	var msgs []message
+	for {
+		if len(stream) < 8 || binary.LittleEndian.Uint32(stream) != continuation {
+			t.Fatalf("no continuation marker before %x", stream)
+		}
+		n := int(binary.LittleEndian.Uint32(stream[4:]))
+		if n == 0 {
+			if len(stream) != 8 {
+				t.Errorf("%d bytes after the end-of-stream marker", len(stream)-8)
+			}
+			return msgs
+		}
+		if n%8 != 0 {
+			t.Errorf("metadata of %d bytes, not padded to 8", n)
+		}
+		meta := root(stream[8 : 8+n])
+		if v := meta.i16(0); v != metadataV5 {
+			t.Errorf("metadata version %v, want V5", v)
+		}
+		size := int(meta.i64(3))
+		if size%8 != 0 {
+			t.Errorf("body of %d bytes, not padded to 8", size)
+		}
+		msgs = append(msgs, message{meta, stream[8+n : 8+n+size]})
+		stream = stream[8+n+size:]
+	}
+}
+
+// column is a column of a schema.
+type column struct {
+	name     string
+	nullable bool
+	typ      string
+}
+
+// columns returns the columns of a schema message.
+func columns(meta flat) []column {
+	var cols []column
+	for _, f := range meta.table(2).tables(1) {
+		c := column{name: f.str(0), nullable: f.u8(1) == 1}
+		typ := f.table(3)
+		switch f.u8(2) {
+		case typeUtf8:
+			c.typ = "utf8"
+		case typeFloatingPoint:
+			if typ.i16(0) == precisionDouble {
+				c.typ = "double"
+			}
+		case typeTimestamp:
+			if typ.i16(0) == unitMicrosecond {
+				c.typ = "timestamp[us, tz=" + typ.str(1) + "]"
+			}
+		}
+		cols = append(cols, c)
+	}
+	return cols
+}
+
+func TestSchema(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, map[string]string{"source": "kitchen.csv", "b": "2"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	msgs := messages(t, buf.Bytes())
+	if len(msgs) != 1 {
+		t.Fatalf("%d messages, want just the schema", len(msgs))
+	}
+	meta := msgs[0].meta
+	if typ := meta.u8(1); typ != headerSchema {
+		t.Fatalf("header type %v, want Schema", typ)
+	}
+	want := []column{
+		{"sensor", false, "utf8"},
+		{"timestamp", false, "timestamp[us, tz=UTC]"},
+		{"pm25", false, "double"},
+		{"pm10", false, "double"},
+		{"pm1", true, "double"},
+	}
+	if got := columns(meta); !reflect.DeepEqual(got, want) {
+		t.Errorf("columns: %v, want %v", got, want)
+	}
+	metadata := make(map[string]string)
+	for _, kv := range meta.table(2).tables(2) {
+		metadata[kv.str(0)] = kv.str(1)
+	}
+	if want := map[string]string{"source": "kitchen.csv", "b": "2"}; !reflect.DeepEqual(metadata, want) {
+		t.Errorf("metadata: %v, want %v", metadata, want)
+	}
+}
+
+// batch is a record batch, read back.
+type batch struct {
+	length     int64
+	nullCounts []int64
+	sensors    []string
+	timestamps []int64
+	pm25, pm10 []float64
+	pm1        []float64
+	// valid are the validity bits of pm1, nil if there are no
+	// nulls.
+	valid []bool
+}
+
+func readBatch(t *testing.T, m message) batch {
+	t.Helper()
+	rb := m.meta.table(2)
+	b := batch{length: rb.i64(0)}
+	for _, node := range rb.pairs(1) {
+		if node[0] != b.length {
+			t.Errorf("a column of %d values, want %d", node[0], b.length)
+		}
+		b.nullCounts = append(b.nullCounts, node[1])
+	}
+	buffers := rb.pairs(2)
+	if len(buffers) != 11 {
+		t.Fatalf("%d buffers, want 11", len(buffers))
+	}
+	buffer := func(i int) []byte {
+		if buffers[i][0]%8 != 0 {
+			t.Errorf("buffer %d at %d, not aligned to 8", i, buffers[i][0])
+		}
+		return m.body[buffers[i][0] : buffers[i][0]+buffers[i][1]]
+	}
+	doubles := func(buf []byte) []float64 {
+		var values []float64
+		for i := 0; i < len(buf); i += 8 {
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(buf[i:])))
+		}
+		return values
+	}
+	offsets, data := buffer(1), buffer(2)
+	for i := 0; i < int(b.length); i++ {
+		start, end := binary.LittleEndian.Uint32(offsets[4*i:]), binary.LittleEndian.Uint32(offsets[4*i+4:])
+		b.sensors = append(b.sensors, string(data[start:end]))
+	}
+	ts := buffer(4)
+	for i := 0; i < len(ts); i += 8 {
+		b.timestamps = append(b.timestamps, int64(binary.LittleEndian.Uint64(ts[i:])))
+	}
+	b.pm25, b.pm10, b.pm1 = doubles(buffer(6)), doubles(buffer(8)), doubles(buffer(10))
+	if valid := buffer(9); len(valid) > 0 {
+		for i := 0; i < int(b.length); i++ {
+			b.valid = append(b.valid, valid[i/8]&(1<<(i%8)) != 0)
+		}
+	}
+	return b
+}
+
+func TestRecordBatches(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 123456000, time.UTC)
+	reading := func(sensor string, minutes int, pm25, pm10 float64) *sink.Reading {
+		return &sink.Reading{Sensor: sensor, Point: &sds011.Point{PM25: pm25, PM10: pm10, Timestamp: t0.Add(time.Duration(minutes) * time.Minute)}}
+	}
+	withPM1 := func(r *sink.Reading, pm1 float64) *sink.Reading {
+		r.PM1, r.HasPM1 = pm1, true
+		return r
+	}
+	for _, tc := range []struct {
+		name     string
+		readings []*sink.Reading
+		want     batch
+	}{
+		{
+			name:     "without nulls",
+			readings: []*sink.Reading{withPM1(reading("kitchen", 0, 12.3, 20.1), 0), withPM1(reading("garden", 1, 3, 4), 1.5)},
+			want: batch{
+				length:     2,
+				nullCounts: []int64{0, 0, 0, 0, 0},
+				sensors:    []string{"kitchen", "garden"},
+				timestamps: []int64{t0.UnixMicro(), t0.Add(time.Minute).UnixMicro()},
+				pm25:       []float64{12.3, 3},
+				pm10:       []float64{20.1, 4},
+				pm1:        []float64{0, 1.5},
+			},
+		},
+		{
+			name: "with nulls",
+			// 9 readings, so that the validity bits take 2 bytes.
+			readings: []*sink.Reading{reading("a", 0, 1, 1), reading("b", 1, 2, 2), reading("c", 2, 3, 3), reading("d", 3, 4, 4),
+				reading("e", 4, 5, 5), reading("f", 5, 6, 6), reading("g", 6, 7, 7), reading("h", 7, 8, 8), withPM1(reading("garden", 1, 3, 4), 1.5)},
+			want: batch{
+				length:     9,
+				nullCounts: []int64{0, 0, 0, 0, 8},
+				sensors:    []string{"a", "b", "c", "d", "e", "f", "g", "h", "garden"},
+				timestamps: []int64{t0.UnixMicro(), t0.Add(time.Minute).UnixMicro(), t0.Add(2 * time.Minute).UnixMicro(), t0.Add(3 * time.Minute).UnixMicro(),
+					t0.Add(4 * time.Minute).UnixMicro(), t0.Add(5 * time.Minute).UnixMicro(), t0.Add(6 * time.Minute).UnixMicro(), t0.Add(7 * time.Minute).UnixMicro(), t0.Add(time.Minute).UnixMicro()},
+				pm25:  []float64{1, 2, 3, 4, 5, 6, 7, 8, 3},
+				pm10:  []float64{1, 2, 3, 4, 5, 6, 7, 8, 4},
+				pm1:   []float64{0, 0, 0, 0, 0, 0, 0, 0, 1.5},
+				valid: []bool{false, false, false, false, false, false, false, false, true},
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, nil)
+		if err := w.Write(tc.readings); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		msgs := messages(t, buf.Bytes())
+		if len(msgs) != 2 || msgs[1].meta.u8(1) != headerRecordBatch {
+			t.Fatalf("%v: %d messages, want a schema and a record batch", tc.name, len(msgs))
+		}
+		if got := readBatch(t, msgs[1]); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWriterBatches(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, nil)
+	r := &sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 1, PM10: 2}}
+	for i := 0; i < 3; i++ {
+		if err := w.Write([]*sink.Reading{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var got []uint8
+	for _, m := range messages(t, buf.Bytes()) {
+		got = append(got, m.meta.u8(1))
+	}
+	// The schema comes once, before the record batches.
+	if want := []uint8{headerSchema, headerRecordBatch, headerRecordBatch, headerRecordBatch}; !reflect.DeepEqual(got, want) {
+		t.Errorf("header types: %v, want %v", got, want)
+	}
+}
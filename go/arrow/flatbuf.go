@@ -0,0 +1,176 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package arrow
+
+import "encoding/binary"
+
+// The metadata of Arrow is written as FlatBuffers. Rather than
+// building them back to front, as the FlatBuffers library does, this
+// package describes them as a tree of objects, and writes every object
+// before the ones it refers to, which FlatBuffers allows as long as
+// the offsets point forward. It only knows what this package needs.
+
+// An object is something a table field can refer to.
+type object interface {
+	// write appends the object to b, and returns where the offsets
+	// referring to it have to point.
+	write(b *builder) int
+}
+
+// A field is a field of a table: a scalar, or an object.
+type field struct {
+	id     int
+	scalar []byte
+	object object
+}
+
+func scalar(id int, size int, v uint64) field {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return field{id: id, scalar: b[:size]}
+}
+
+func u8(id int, v uint8) field   { return scalar(id, 1, uint64(v)) }
+func i16(id int, v int16) field  { return scalar(id, 2, uint64(v)) }
+func i64(id int, v int64) field  { return scalar(id, 8, uint64(v)) }
+func ref(id int, o object) field { return field{id: id, object: o} }
+func str(id int, s string) field { return ref(id, flatString(s)) }
+func boolean(id int, v bool) field {
+	if v {
+		return u8(id, 1)
+	}
+	return u8(id, 0)
+}
+
+type builder struct {
+	buf []byte
+}
+
+func (b *builder) align(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *builder) u16(v int) {
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(v))
+}
+
+func (b *builder) u32(v int) {
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(v))
+}
+
+// refer makes the offset at pos point to o, which is written now.
+func (b *builder) refer(pos int, o object) {
+	target := o.write(b)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// table is a table, with its fields in any order.
+type table []field
+
+func (t table) write(b *builder) int {
+	// The fields are laid out after the offset to the vtable, each
+	// aligned to its size, and the table to the largest, 8.
+	offsets := make([]int, len(t))
+	size, last := 4, -1
+	for i, f := range t {
+		n := 4
+		if f.object == nil {
+			n = len(f.scalar)
+		}
+		for size%n != 0 {
+			size++
+		}
+		offsets[i] = size
+		size += n
+		if f.id > last {
+			last = f.id
+		}
+	}
+	b.align(2)
+	vtable := len(b.buf)
+	b.u16(4 + 2*(last+1))
+	b.u16(size)
+	slots := make([]int, last+1)
+	for i, f := range t {
+		slots[f.id] = offsets[i]
+	}
+	for _, offset := range slots {
+		b.u16(offset)
+	}
+	b.align(8)
+	start := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[start:], uint32(start-vtable))
+	for i, f := range t {
+		copy(b.buf[start+offsets[i]:], f.scalar)
+	}
+	for i, f := range t {
+		if f.object != nil {
+			b.refer(start+offsets[i], f.object)
+		}
+	}
+	return start
+}
+
+// flatString is a string.
+type flatString string
+
+func (s flatString) write(b *builder) int {
+	b.align(4)
+	start := len(b.buf)
+	b.u32(len(s))
+	b.buf = append(append(b.buf, s...), 0)
+	return start
+}
+
+// vector is a vector of objects.
+type vector []object
+
+func (v vector) write(b *builder) int {
+	b.align(4)
+	start := len(b.buf)
+	b.u32(len(v))
+	b.buf = append(b.buf, make([]byte, 4*len(v))...)
+	for i, o := range v {
+		b.refer(start+4+4*i, o)
+	}
+	return start
+}
+
+// structs is a vector of structs of two longs, the only kind Arrow
+// needs.
+type structs [][2]int64
+
+func (v structs) write(b *builder) int {
+	// The structs are aligned to 8, after the length.
+	b.align(8)
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	start := len(b.buf)
+	b.u32(len(v))
+	for _, s := range v {
+		b.buf = binary.LittleEndian.AppendUint64(b.buf, uint64(s[0]))
+		b.buf = binary.LittleEndian.AppendUint64(b.buf, uint64(s[1]))
+	}
+	return start
+}
+
+// finish returns the buffer with root as its root table.
+func finish(root table) []byte {
+	b := &builder{buf: make([]byte, 4)}
+	b.refer(0, root)
+	return b.buf
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture records the traffic between a program and an SDS011,
+// and plays it back, so that problems seen in the field can be
+// reproduced without the sensor that caused them.
+//
+// To record a session, wrap the serial port:
+//
+//	f, _ := os.Create("session.capture")
+//	port, _ := sds011.OpenPort("/dev/ttyUSB0")
+//	sensor := sds011.NewSensor(capture.Record(port, f))
+//
+// and to play it back, for example in a test:
+//
+//	events, _ := capture.Load(f)
+//	sensor := sds011.NewSensor(capture.NewReplay(events))
+//
+// A capture is a text file, so it can be pasted into a bug report.
+// After a comment line, every line is an event: the time since the
+// start of the recording in seconds, ">" for bytes written to the
+// sensor or "<" for bytes read from it, and the bytes in hex:
+//
+//	# sds011 capture, started 2017-06-01T12:00:00Z
+//	0.000000 > aab40400000000000000000000ffff02ab
+//	0.011520 < aac0640096003412f8ab
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction is which way bytes went.
+type Direction byte
+
+const (
+	// ToSensor are bytes written to the sensor.
+	ToSensor Direction = '>'
+	// FromSensor are bytes read from the sensor.
+	FromSensor Direction = '<'
+)
+
+// An Event is a chunk of bytes that went over the line.
+type Event struct {
+	// Offset is the time since the start of the recording.
+	Offset    time.Duration
+	Direction Direction
+	Data      []byte
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%.6f %c %x", e.Offset.Seconds(), e.Direction, e.Data)
+}
+
+// A Recorder passes reads and writes on to a port, recording them.
+type Recorder struct {
+	rwc   io.ReadWriteCloser
+	start time.Time
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// Record returns a Recorder that reads from and writes to rwc,
+// recording everything to w.
+func Record(rwc io.ReadWriteCloser, w io.Writer) *Recorder {
+	r := &Recorder{rwc: rwc, w: w, start: time.Now()}
+	_, r.err = fmt.Fprintf(w, "# sds011 capture, started %v\n", r.start.UTC().Format(time.RFC3339))
+	return r
+}
+
+func (r *Recorder) record(dir Direction, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	e := Event{Offset: time.Since(r.start), Direction: dir, Data: b}
+	_, r.err = fmt.Fprintln(r.w, e)
+}
+
+// Read implements io.Reader.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.rwc.Read(b)
+	r.record(FromSensor, b[:n])
+	return n, err
+}
+
+// Write implements io.Writer.
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.rwc.Write(b)
+	r.record(ToSensor, b[:n])
+	return n, err
+}
+
+// Close closes the port. It returns the first error writing the
+// recording, if there was one.
+func (r *Recorder) Close() error {
+	err := r.rwc.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+// Load reads a recording.
+func Load(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 || len(fields[1]) != 1 {
+			return nil, fmt.Errorf("line %d: malformed event %q", line, text)
+		}
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		dir := Direction(fields[1][0])
+		if dir != ToSensor && dir != FromSensor {
+			return nil, fmt.Errorf("line %d: bad direction %q", line, fields[1])
+		}
+		data, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, Event{Offset: time.Duration(seconds * float64(time.Second)), Direction: dir, Data: data})
+	}
+	return events, scanner.Err()
+}
+
+// A Replay is a port that plays back a recording. Reads return the
+// bytes that were read from the sensor, in order, and end with io.EOF.
+// Writes are discarded, unless the replay is strict.
+type Replay struct {
+	// Strict makes writes fail unless they write the same bytes as
+	// were written in the recording.
+	Strict bool
+	// Realtime makes reads wait until the time they happened in the
+	// recording, counting from the first read.
+	Realtime bool
+
+	mu sync.Mutex
+	// reads and writes are the events yet to be replayed; pending
+	// is what's left of the first read event.
+	reads, writes []Event
+	pending       []byte
+	start         time.Time
+}
+
+// NewReplay returns a replay of events.
+func NewReplay(events []Event) *Replay {
+	r := new(Replay)
+	for _, e := range events {
+		if e.Direction == FromSensor {
+			r.reads = append(r.reads, e)
+		} else {
+			r.writes = append(r.writes, e)
+		}
+	}
+	return r
+}
+
+// Read implements io.Reader.
+func (r *Replay) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		if len(r.reads) == 0 {
+			return 0, io.EOF
+		}
+		e := r.reads[0]
+		r.reads = r.reads[1:]
+		if r.Realtime {
+			if r.start.IsZero() {
+				r.start = time.Now().Add(-e.Offset)
+			}
+			time.Sleep(time.Until(r.start.Add(e.Offset)))
+		}
+		r.pending = e.Data
+	}
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Write implements io.Writer.
+func (r *Replay) Write(b []byte) (int, error) {
+	if !r.Strict {
+		return len(b), nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < len(b); {
+		if len(r.writes) == 0 {
+			return i, fmt.Errorf("capture: unexpected write %x", b[i:])
+		}
+		want := r.writes[0].Data
+		n := len(b) - i
+		if n > len(want) {
+			n = len(want)
+		}
+		if got := b[i : i+n]; string(got) != string(want[:n]) {
+			return i, fmt.Errorf("capture: wrote %x, recording has %x", got, want[:n])
+		}
+		if n == len(want) {
+			r.writes = r.writes[1:]
+		} else {
+			r.writes[0].Data = want[n:]
+		}
+		i += n
+	}
+	return len(b), nil
+}
+
+// Done returns an error if some of the recording hasn't been replayed:
+// bytes read from the sensor that weren't read, or, if the replay is
+// strict, bytes written to the sensor that weren't written.
+func (r *Replay) Done() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 || len(r.reads) > 0 {
+		return fmt.Errorf("capture: %d bytes and %d reads left", len(r.pending), len(r.reads))
+	}
+	if r.Strict && len(r.writes) > 0 {
+		return fmt.Errorf("capture: %d writes left, the next is %x", len(r.writes), r.writes[0].Data)
+	}
+	return nil
+}
+
+// Close implements io.Closer.
+func (r *Replay) Close() error {
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// A BoxPlot is a chart of how values are spread in groups, like days:
+// for every group and series, a box from the first to the third
+// quartile, with a line at the median and whiskers to the minimum and
+// the maximum.
+type BoxPlot struct {
+	// Title, if set, is written in the top left corner.
+	Title string
+	// Unit, if set, is written next to the highest value on the
+	// value axis, like "µg/m³".
+	Unit string
+	// Groups are the labels of the groups, along the bottom.
+	Groups []string
+	// Series are drawn side by side in every group.
+	Series []BoxSeries
+}
+
+// A BoxSeries is a series of a box plot.
+type BoxSeries struct {
+	Name  string
+	Color color.RGBA
+	// Values are the values of the series in the groups of the
+	// chart, in order. A group with no values has no box.
+	Values [][]float64
+}
+
+// PNG draws the chart as a PNG image of the given size.
+func (bp *BoxPlot) PNG(w io.Writer, width, height int) error {
+	if err := checkSize(width, height); err != nil {
+		return err
+	}
+	r := newRaster(width, height)
+	bp.draw(r, r.img.Bounds())
+	return png.Encode(w, r.img)
+}
+
+// SVG draws the chart as an SVG document of the given size.
+func (bp *BoxPlot) SVG(w io.Writer, width, height int) error {
+	if err := checkSize(width, height); err != nil {
+		return err
+	}
+	v := newVector(width, height)
+	bp.draw(v, image.Rect(0, 0, width, height))
+	return v.writeTo(w)
+}
+
+// A box are the quartiles and the extremes of some values.
+type box struct {
+	min, q1, median, q3, max float64
+}
+
+func newBox(values []float64) box {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return box{sorted[0], quartile(sorted, 0.25), quartile(sorted, 0.5), quartile(sorted, 0.75), sorted[len(sorted)-1]}
+}
+
+// quartile returns the q quantile of sorted, interpolating between the
+// values around it.
+func quartile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 == len(sorted) {
+		return sorted[i]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// draw draws the chart on cv, which is b in size.
+func (bp *BoxPlot) draw(cv canvas, b image.Rectangle) {
+	max, empty := 0.0, true
+	boxes := make([][]*box, len(bp.Series))
+	for i, s := range bp.Series {
+		boxes[i] = make([]*box, len(bp.Groups))
+		for j := range bp.Groups {
+			if j >= len(s.Values) || len(s.Values[j]) == 0 {
+				continue
+			}
+			bx := newBox(s.Values[j])
+			boxes[i][j], empty = &bx, false
+			if bx.max > max {
+				max = bx.max
+			}
+		}
+	}
+	yMax, yStep := valueScale(max)
+
+	plot := image.Rect(textWidth(valueLabel(yMax))+10, glyphHeight+10, b.Dx()-10, b.Dy()-glyphHeight-8)
+	y := func(v float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*v/yMax)
+	}
+	valueAxis(cv, plot, yMax, yStep, y, bp.Unit)
+
+	if len(bp.Groups) > 0 {
+		slot := float64(plot.Dx()) / float64(len(bp.Groups))
+		// Every label gets the room of the widest one, and some.
+		every := 1
+		for _, g := range bp.Groups {
+			if n := int(float64(textWidth(g)+10)/slot) + 1; n > every {
+				every = n
+			}
+		}
+		width := int(slot * 0.7 / float64(len(bp.Series)+1))
+		if width < 1 {
+			width = 1
+		}
+		for j, g := range bp.Groups {
+			left := plot.Min.X + int(slot*float64(j))
+			center := plot.Min.X + int(slot*(float64(j)+0.5))
+			if j > 0 {
+				cv.vline(left, plot.Min.Y, plot.Max.Y, grid)
+			}
+			if j%every == 0 {
+				cv.text(center-textWidth(g)/2, plot.Max.Y+4, g, muted)
+			}
+			for i, s := range bp.Series {
+				bx := boxes[i][j]
+				if bx == nil {
+					continue
+				}
+				x0, w := center+(2*i-len(bp.Series))*width/2, width
+				if w > 4 {
+					// Leaves some room between the boxes.
+					x0, w = x0+1, w-2
+				}
+				mid := x0 + w/2
+				cv.vline(mid, y(bx.max), y(bx.q3), s.Color)
+				cv.vline(mid, y(bx.q1), y(bx.min), s.Color)
+				cv.hline(x0+w/4, x0+w-w/4, y(bx.max), s.Color)
+				cv.hline(x0+w/4, x0+w-w/4, y(bx.min), s.Color)
+				cv.fill(image.Rect(x0, y(bx.q3), x0+w, y(bx.q1)+1), s.Color)
+				cv.hline(x0, x0+w-1, y(bx.median), background)
+			}
+		}
+	}
+	axes(cv, plot)
+
+	names := make([]string, len(bp.Series))
+	colors := make([]color.RGBA, len(bp.Series))
+	for i, s := range bp.Series {
+		names[i], colors[i] = s.Name, s.Color
+	}
+	header(cv, b, plot, bp.Title, names, colors)
+
+	if empty {
+		noData(cv, plot)
+	}
+}
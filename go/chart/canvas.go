@@ -0,0 +1,139 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strings"
+)
+
+// A canvas is what charts are drawn on: an image, or an SVG document.
+// Coordinates are in pixels, from the top left corner.
+type canvas interface {
+	hline(x0, x1, y int, c color.RGBA)
+	vline(x, y0, y1 int, c color.RGBA)
+	fill(r image.Rectangle, c color.RGBA)
+	// text writes s with its top left corner at (x, y).
+	text(x, y int, s string, c color.RGBA)
+	// path draws a line two pixels wide through points, leaving out
+	// whatever is outside clip. A single point is drawn as a dot.
+	path(points []image.Point, c color.RGBA, clip image.Rectangle)
+}
+
+// raster draws on an image.
+type raster struct {
+	img *image.RGBA
+}
+
+func newRaster(width, height int) *raster {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	return &raster{img}
+}
+
+func (r *raster) hline(x0, x1, y int, c color.RGBA) {
+	for x := x0; x <= x1; x++ {
+		r.img.Set(x, y, c)
+	}
+}
+
+func (r *raster) vline(x, y0, y1 int, c color.RGBA) {
+	for y := y0; y <= y1; y++ {
+		r.img.Set(x, y, c)
+	}
+}
+
+func (r *raster) fill(rect image.Rectangle, c color.RGBA) {
+	draw.Draw(r.img, rect, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func (r *raster) text(x, y int, s string, c color.RGBA) {
+	drawText(r.img, x, y, s, c)
+}
+
+func (r *raster) path(points []image.Point, c color.RGBA, clip image.Rectangle) {
+	img := r.img.SubImage(clip).(*image.RGBA)
+	if len(points) == 1 {
+		img.Set(points[0].X, points[0].Y, c)
+		return
+	}
+	for i := 1; i < len(points); i++ {
+		p, q := points[i-1], points[i]
+		line(img, p.X, p.Y, q.X, q.Y, c)
+		line(img, p.X, p.Y+1, q.X, q.Y+1, c)
+	}
+}
+
+// vector draws an SVG document. The text is set in a monospace font
+// the size of the one of images, so that the layout is the same.
+type vector struct {
+	b     strings.Builder
+	clips int
+}
+
+func newVector(width, height int) *vector {
+	v := new(vector)
+	fmt.Fprintf(&v.b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="%d">`+"\n", width, height, width, height, glyphHeight+3)
+	fmt.Fprintf(&v.b, `<rect width="%d" height="%d" fill="%v"/>`+"\n", width, height, hex(background))
+	return v
+}
+
+func (v *vector) hline(x0, x1, y int, c color.RGBA) {
+	fmt.Fprintf(&v.b, `<path d="M%d %d.5H%d" stroke="%v"/>`+"\n", x0, y, x1+1, hex(c))
+}
+
+func (v *vector) vline(x, y0, y1 int, c color.RGBA) {
+	fmt.Fprintf(&v.b, `<path d="M%d.5 %dV%d" stroke="%v"/>`+"\n", x, y0, y1+1, hex(c))
+}
+
+func (v *vector) fill(r image.Rectangle, c color.RGBA) {
+	fmt.Fprintf(&v.b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%v"/>`+"\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), hex(c))
+}
+
+func (v *vector) text(x, y int, s string, c color.RGBA) {
+	fmt.Fprintf(&v.b, `<text x="%d" y="%d" fill="%v" textLength="%d">%v</text>`+"\n", x, y+glyphHeight, hex(c), textWidth(s), html.EscapeString(s))
+}
+
+func (v *vector) path(points []image.Point, c color.RGBA, clip image.Rectangle) {
+	v.clips++
+	fmt.Fprintf(&v.b, `<clipPath id="c%d"><rect x="%d" y="%d" width="%d" height="%d"/></clipPath>`+"\n", v.clips, clip.Min.X, clip.Min.Y, clip.Dx(), clip.Dy())
+	if len(points) == 1 {
+		fmt.Fprintf(&v.b, `<circle cx="%d" cy="%d" r="1" fill="%v" clip-path="url(#c%d)"/>`+"\n", points[0].X, points[0].Y, hex(c), v.clips)
+		return
+	}
+	fmt.Fprintf(&v.b, `<polyline fill="none" stroke="%v" stroke-width="2" stroke-linejoin="round" clip-path="url(#c%d)" points="`, hex(c), v.clips)
+	for i, p := range points {
+		if i > 0 {
+			v.b.WriteByte(' ')
+		}
+		fmt.Fprintf(&v.b, "%d,%d", p.X, p.Y)
+	}
+	v.b.WriteString("\"/>\n")
+}
+
+func (v *vector) writeTo(w io.Writer) error {
+	_, err := io.WriteString(w, v.b.String()+"</svg>\n")
+	return err
+}
+
+// hex returns c in the #rrggbb notation.
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,341 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chart draws charts of PM levels as PNG images or SVG
+// documents, with nothing but the standard library, for embedding them
+// in wikis, e-ink displays and chat messages:
+//
+//	c := &chart.TimeSeries{
+//		Unit: "µg/m³",
+//		Series: []chart.Series{
+//			{Name: "PM2.5", Color: chart.PM25Color, Points: pm25},
+//			{Name: "PM10", Color: chart.PM10Color, Points: pm10},
+//		},
+//	}
+//	c.PNG(w, 800, 300)
+package chart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The colors of the usual series.
+var (
+	PM25Color = color.RGBA{0xD9, 0x48, 0x0F, 0xFF}
+	PM10Color = color.RGBA{0x1C, 0x7E, 0xD6, 0xFF}
+	PM1Color  = color.RGBA{0x2F, 0x9E, 0x44, 0xFF}
+)
+
+var (
+	background = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	foreground = color.RGBA{0x33, 0x33, 0x33, 0xFF}
+	grid       = color.RGBA{0xE0, 0xE0, 0xE0, 0xFF}
+	muted      = color.RGBA{0x77, 0x77, 0x77, 0xFF}
+)
+
+// A Point is a value at a time.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// A Series is a line on a chart.
+type Series struct {
+	Name   string
+	Color  color.RGBA
+	Points []Point
+}
+
+// A TimeSeries is a chart of one or more series over time.
+type TimeSeries struct {
+	// Title, if set, is written in the top left corner.
+	Title string
+	// Unit, if set, is written next to the highest value on the
+	// value axis, like "µg/m³".
+	Unit   string
+	Series []Series
+	// From and To are the time range of the chart. If they are
+	// zero, the range is that of the points.
+	From, To time.Time
+	// MaxGap, if set, is the longest time between points that are
+	// joined by a line, so that missing data shows as a gap.
+	MaxGap time.Duration
+	// Location is the time zone of the time labels. It defaults to
+	// the local one.
+	Location *time.Location
+}
+
+// checkSize returns an error if a chart would not fit in width by
+// height pixels.
+func checkSize(width, height int) error {
+	if width < 100 || height < 60 {
+		return fmt.Errorf("chart: %dx%d is too small", width, height)
+	}
+	return nil
+}
+
+// PNG draws the chart as a PNG image of the given size.
+func (ts *TimeSeries) PNG(w io.Writer, width, height int) error {
+	if err := checkSize(width, height); err != nil {
+		return err
+	}
+	r := newRaster(width, height)
+	ts.draw(r, r.img.Bounds())
+	return png.Encode(w, r.img)
+}
+
+// SVG draws the chart as an SVG document of the given size.
+func (ts *TimeSeries) SVG(w io.Writer, width, height int) error {
+	if err := checkSize(width, height); err != nil {
+		return err
+	}
+	v := newVector(width, height)
+	ts.draw(v, image.Rect(0, 0, width, height))
+	return v.writeTo(w)
+}
+
+// draw draws the chart on cv, which is b in size.
+func (ts *TimeSeries) draw(cv canvas, b image.Rectangle) {
+	from, to, max := ts.From, ts.To, 0.0
+	for _, s := range ts.Series {
+		for _, p := range s.Points {
+			if ts.From.IsZero() && (from.IsZero() || p.Time.Before(from)) {
+				from = p.Time
+			}
+			if ts.To.IsZero() && p.Time.After(to) {
+				to = p.Time
+			}
+			max = math.Max(max, p.Value)
+		}
+	}
+	if !to.After(from) {
+		to = from.Add(time.Hour)
+	}
+	loc := ts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	yMax, yStep := valueScale(max)
+	plot := image.Rect(textWidth(valueLabel(yMax))+10, glyphHeight+10, b.Dx()-10, b.Dy()-glyphHeight-8)
+	x := func(t time.Time) int {
+		return plot.Min.X + int(float64(plot.Dx())*float64(t.Sub(from))/float64(to.Sub(from)))
+	}
+	y := func(v float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*v/yMax)
+	}
+
+	valueAxis(cv, plot, yMax, yStep, y, ts.Unit)
+	step, layout := timeStep(to.Sub(from), plot.Dx())
+	for t := truncate(from.In(loc), step); !t.After(to); t = t.Add(step) {
+		if t.Before(from) {
+			continue
+		}
+		cv.vline(x(t), plot.Min.Y, plot.Max.Y, grid)
+		label := t.Format(layout)
+		lx := x(t) - textWidth(label)/2
+		if lx >= 0 && lx+textWidth(label) < b.Dx() {
+			cv.text(lx, plot.Max.Y+4, label, muted)
+		}
+	}
+	axes(cv, plot)
+
+	names := make([]string, len(ts.Series))
+	colors := make([]color.RGBA, len(ts.Series))
+	for i, s := range ts.Series {
+		names[i], colors[i] = s.Name, s.Color
+	}
+	header(cv, b, plot, ts.Title, names, colors)
+
+	if !ts.hasPoints() {
+		noData(cv, plot)
+	}
+	for _, s := range ts.Series {
+		var run []image.Point
+		for i, p := range s.Points {
+			if i > 0 && ts.MaxGap > 0 && p.Time.Sub(s.Points[i-1].Time) > ts.MaxGap {
+				cv.path(run, s.Color, plot.Inset(-1))
+				run = nil
+			}
+			run = append(run, image.Pt(x(p.Time), y(p.Value)))
+		}
+		if len(run) > 0 {
+			cv.path(run, s.Color, plot.Inset(-1))
+		}
+	}
+}
+
+// valueAxis draws the grid lines of a value axis from 0 to max, every
+// step, with their labels to the left of plot, and the unit.
+func valueAxis(cv canvas, plot image.Rectangle, max, step float64, y func(float64) int, unit string) {
+	for v := 0.0; v <= max+step/2; v += step {
+		cv.hline(plot.Min.X, plot.Max.X, y(v), grid)
+		label := valueLabel(v)
+		cv.text(plot.Min.X-5-textWidth(label), y(v)-glyphHeight/2, label, muted)
+	}
+	if unit != "" {
+		cv.text(plot.Min.X+3, plot.Min.Y+3, unit, muted)
+	}
+}
+
+func valueLabel(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// valueScale returns the top of a value axis for values up to max, and
+// the step between its labels.
+func valueScale(max float64) (top, step float64) {
+	step = niceStep(math.Max(max, 10) * 1.05 / 5)
+	return math.Ceil(math.Max(max, 10)*1.05/step) * step, step
+}
+
+// axes draws the lines along the left and bottom sides of plot.
+func axes(cv canvas, plot image.Rectangle) {
+	cv.hline(plot.Min.X, plot.Max.X, plot.Max.Y, foreground)
+	cv.vline(plot.Min.X, plot.Min.Y, plot.Max.Y, foreground)
+}
+
+// header writes the title above plot, and a legend of the names and
+// colors in the top right corner of b.
+func header(cv canvas, b, plot image.Rectangle, title string, names []string, colors []color.RGBA) {
+	if title != "" {
+		cv.text(plot.Min.X, 4, title, foreground)
+	}
+	lx := b.Dx() - 10
+	for i := len(names) - 1; i >= 0; i-- {
+		lx -= textWidth(names[i])
+		cv.text(lx, 4, names[i], foreground)
+		lx -= glyphHeight + 4
+		cv.fill(image.Rect(lx, 4, lx+glyphHeight, 4+glyphHeight), colors[i])
+		lx -= 12
+	}
+}
+
+// noData says there is nothing to show in plot.
+func noData(cv canvas, plot image.Rectangle) {
+	msg := "NO DATA"
+	cv.text(plot.Min.X+(plot.Dx()-textWidth(msg))/2, plot.Min.Y+(plot.Dy()-glyphHeight)/2, msg, muted)
+}
+
+func (ts *TimeSeries) hasPoints() bool {
+	for _, s := range ts.Series {
+		if len(s.Points) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// niceStep returns the smallest of 1, 2 or 5 times a power of ten that
+// is at least v.
+func niceStep(v float64) float64 {
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*p >= v {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// timeSteps are the intervals between the labels of the time axis,
+// and the layouts of the labels.
+var timeSteps = []struct {
+	step   time.Duration
+	layout string
+}{
+	{time.Minute, "15:04"},
+	{5 * time.Minute, "15:04"},
+	{15 * time.Minute, "15:04"},
+	{30 * time.Minute, "15:04"},
+	{time.Hour, "15:04"},
+	{2 * time.Hour, "15:04"},
+	{3 * time.Hour, "15:04"},
+	{6 * time.Hour, "15:04"},
+	{12 * time.Hour, "01-02 15:04"},
+	{24 * time.Hour, "01-02"},
+	{2 * 24 * time.Hour, "01-02"},
+	{7 * 24 * time.Hour, "01-02"},
+	{14 * 24 * time.Hour, "01-02"},
+	{28 * 24 * time.Hour, "2006-01-02"},
+	{91 * 24 * time.Hour, "2006-01-02"},
+	{364 * 24 * time.Hour, "2006-01-02"},
+}
+
+// timeStep returns the interval between the labels of a time axis
+// spanning span over width pixels, and the layout of the labels.
+func timeStep(span time.Duration, width int) (time.Duration, string) {
+	for _, s := range timeSteps {
+		labels := int(span / s.step)
+		if labels*(textWidth(s.layout)+20) <= width {
+			return s.step, s.layout
+		}
+	}
+	last := timeSteps[len(timeSteps)-1]
+	return last.step, last.layout
+}
+
+// truncate rounds t down to a multiple of step, counting days and
+// longer steps from local midnight.
+func truncate(t time.Time, step time.Duration) time.Time {
+	if step < 24*time.Hour {
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		return t.Add(shift).Truncate(step).Add(-shift)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// line draws a line from (x0, y0) to (x1, y1), with Bresenham's
+// algorithm.
+func line(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"image"
+	"image/color"
+	"unicode"
+)
+
+// The labels are drawn with a 5x7 pixel font, with a column of space
+// between the glyphs.
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+	advance     = glyphWidth + 1
+)
+
+// glyphs are the columns of the characters, from left to right, with
+// the top row in the lowest bit. Lower case letters are drawn as upper
+// case ones, and unknown characters as '?'.
+var glyphs = map[rune][glyphWidth]byte{
+	' ': {0x00, 0x00, 0x00, 0x00, 0x00},
+	'0': {0x3E, 0x51, 0x49, 0x45, 0x3E},
+	'1': {0x00, 0x42, 0x7F, 0x40, 0x00},
+	'2': {0x42, 0x61, 0x51, 0x49, 0x46},
+	'3': {0x21, 0x41, 0x45, 0x4B, 0x31},
+	'4': {0x18, 0x14, 0x12, 0x7F, 0x10},
+	'5': {0x27, 0x45, 0x45, 0x45, 0x39},
+	'6': {0x3C, 0x4A, 0x49, 0x49, 0x30},
+	'7': {0x01, 0x71, 0x09, 0x05, 0x03},
+	'8': {0x36, 0x49, 0x49, 0x49, 0x36},
+	'9': {0x06, 0x49, 0x49, 0x29, 0x1E},
+	'A': {0x7E, 0x11, 0x11, 0x11, 0x7E},
+	'B': {0x7F, 0x49, 0x49, 0x49, 0x36},
+	'C': {0x3E, 0x41, 0x41, 0x41, 0x22},
+	'D': {0x7F, 0x41, 0x41, 0x22, 0x1C},
+	'E': {0x7F, 0x49, 0x49, 0x49, 0x41},
+	'F': {0x7F, 0x09, 0x09, 0x01, 0x01},
+	'G': {0x3E, 0x41, 0x41, 0x51, 0x32},
+	'H': {0x7F, 0x08, 0x08, 0x08, 0x7F},
+	'I': {0x00, 0x41, 0x7F, 0x41, 0x00},
+	'J': {0x20, 0x40, 0x41, 0x3F, 0x01},
+	'K': {0x7F, 0x08, 0x14, 0x22, 0x41},
+	'L': {0x7F, 0x40, 0x40, 0x40, 0x40},
+	'M': {0x7F, 0x02, 0x04, 0x02, 0x7F},
+	'N': {0x7F, 0x04, 0x08, 0x10, 0x7F},
+	'O': {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	'P': {0x7F, 0x09, 0x09, 0x09, 0x06},
+	'Q': {0x3E, 0x41, 0x51, 0x21, 0x5E},
+	'R': {0x7F, 0x09, 0x19, 0x29, 0x46},
+	'S': {0x46, 0x49, 0x49, 0x49, 0x31},
+	'T': {0x01, 0x01, 0x7F, 0x01, 0x01},
+	'U': {0x3F, 0x40, 0x40, 0x40, 0x3F},
+	'V': {0x1F, 0x20, 0x40, 0x20, 0x1F},
+	'W': {0x7F, 0x20, 0x18, 0x20, 0x7F},
+	'X': {0x63, 0x14, 0x08, 0x14, 0x63},
+	'Y': {0x03, 0x04, 0x78, 0x04, 0x03},
+	'Z': {0x61, 0x51, 0x49, 0x45, 0x43},
+	'.': {0x00, 0x60, 0x60, 0x00, 0x00},
+	',': {0x00, 0x50, 0x30, 0x00, 0x00},
+	':': {0x00, 0x36, 0x36, 0x00, 0x00},
+	'-': {0x08, 0x08, 0x08, 0x08, 0x08},
+	'+': {0x08, 0x08, 0x3E, 0x08, 0x08},
+	'=': {0x14, 0x14, 0x14, 0x14, 0x14},
+	'_': {0x40, 0x40, 0x40, 0x40, 0x40},
+	'/': {0x20, 0x10, 0x08, 0x04, 0x02},
+	'%': {0x23, 0x13, 0x08, 0x64, 0x62},
+	'(': {0x00, 0x1C, 0x22, 0x41, 0x00},
+	')': {0x00, 0x41, 0x22, 0x1C, 0x00},
+	'?': {0x02, 0x01, 0x51, 0x09, 0x06},
+	// µ, as in µg/m³, and ³.
+	'µ': {0x7C, 0x20, 0x40, 0x20, 0x1C},
+	'³': {0x00, 0x15, 0x15, 0x0A, 0x00},
+}
+
+// textWidth returns the width of s in pixels.
+func textWidth(s string) int {
+	n := 0
+	for range s {
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return n*advance - 1
+}
+
+// drawText draws s with its top left corner at (x, y).
+func drawText(img *image.RGBA, x, y int, s string, c color.Color) {
+	for _, r := range s {
+		g, ok := glyphs[r]
+		if !ok {
+			g, ok = glyphs[unicode.ToUpper(r)]
+		}
+		if !ok {
+			g = glyphs['?']
+		}
+		for col, bits := range g {
+			for row := 0; row < glyphHeight; row++ {
+				if bits&(1<<row) != 0 {
+					img.Set(x+col, y+row, c)
+				}
+			}
+		}
+		x += advance
+	}
+}
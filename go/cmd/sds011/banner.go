@@ -0,0 +1,101 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// deviceInfo describes the sensor and its settings, so that the data
+// collected says what produced it.
+type deviceInfo struct {
+	port, deviceID, firmware string
+	mode                     sds011.ReportMode
+	// cycle is the working period in minutes, or nil if the firmware
+	// doesn't have one.
+	cycle *uint8
+}
+
+// queryDeviceInfo asks the sensor about itself. A sleeping sensor is
+// woken up to answer, and put back to sleep.
+func queryDeviceInfo(sensor *sds011.Sensor, port string) (*deviceInfo, error) {
+	awake, err := sensor.IsAwake()
+	if err != nil {
+		return nil, err
+	}
+	if !awake {
+		if err := sensor.Awake(); err != nil {
+			return nil, err
+		}
+		defer sensor.Sleep()
+	}
+	info := &deviceInfo{port: port}
+	if info.deviceID, err = sensor.DeviceID(); err != nil {
+		return nil, err
+	}
+	if info.firmware, err = sensor.Firmware(); err != nil {
+		return nil, err
+	}
+	if info.mode, err = sensor.ReportMode(); err != nil {
+		return nil, err
+	}
+	switch cycle, err := sensor.Cycle(); {
+	case err == nil:
+		info.cycle = &cycle
+	case !errors.Is(err, sds011.ErrUnsupported):
+		return nil, err
+	}
+	return info, nil
+}
+
+// String returns the info as space separated key=value pairs.
+func (info *deviceInfo) String() string {
+	fields := []string{
+		"port=" + info.port,
+		"device_id=" + info.deviceID,
+		"firmware=" + info.firmware,
+		"report_mode=" + info.mode.String(),
+	}
+	if info.cycle != nil {
+		fields = append(fields, fmt.Sprintf("cycle=%d", *info.cycle))
+	}
+	return strings.Join(fields, " ")
+}
+
+// metadata returns the info as the custom metadata of an Arrow stream.
+func (info *deviceInfo) metadata() map[string]string {
+	m := map[string]string{
+		"port":        info.port,
+		"device_id":   info.deviceID,
+		"firmware":    info.firmware,
+		"report_mode": info.mode.String(),
+	}
+	if info.cycle != nil {
+		m["cycle"] = fmt.Sprint(*info.cycle)
+	}
+	return m
+}
+
+// writeBanner writes the info as a comment line, for the top of the
+// output.
+func (info *deviceInfo) writeBanner(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# sds011 %v\n", info)
+	return err
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// The categories of errors.
+const (
+	// checksum is a frame with a bad checksum: noise on the line,
+	// which happens now and then.
+	checksum = "checksum"
+	// desync is when the frames don't make sense: a reply that
+	// doesn't come, or something that isn't a frame.
+	desync = "desync"
+	// timeout is when the sensor doesn't answer in time.
+	timeout = "timeout"
+	// portLost is when the serial port is gone, for example because
+	// the sensor was unplugged.
+	portLost = "port_lost"
+	// other is everything else.
+	other = "other"
+)
+
+// category returns the category of err.
+func category(err error) string {
+	var t interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, wire.ErrChecksum):
+		return checksum
+	case errors.Is(err, wire.ErrFrame), errors.Is(err, sds011.ErrNoReply):
+		return desync
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &t) && t.Timeout():
+		return timeout
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, os.ErrClosed), errors.As(err, new(*os.PathError)):
+		return portLost
+	}
+	return other
+}
+
+// errorLog logs errors, as text or as JSON records, and decides when
+// there were too many to go on.
+type errorLog struct {
+	json   *json.Encoder
+	counts map[string]int
+	// exitOnPortLost is whether to exit when the port is lost, and
+	// maxConsecutive, if positive, how many errors in a row to exit
+	// after.
+	exitOnPortLost bool
+	maxConsecutive int
+	// consecutive is how many errors there were since the last
+	// success.
+	consecutive int
+	// atExit, if set, is called before exiting, to finish the output.
+	atExit func()
+}
+
+func newErrorLog(asJSON bool) *errorLog {
+	l := &errorLog{counts: make(map[string]int)}
+	if asJSON {
+		l.json = json.NewEncoder(os.Stderr)
+	}
+	return l
+}
+
+// errorRecord is how an error is logged as JSON. Count is how many errors
+// of the category there have been, this one included, and Counts the
+// same for all the categories.
+type errorRecord struct {
+	Time      time.Time      `json:"time"`
+	Category  string         `json:"category"`
+	Operation string         `json:"operation"`
+	Error     string         `json:"error"`
+	Count     int            `json:"count"`
+	Counts    map[string]int `json:"counts"`
+}
+
+// log logs err, returned by op, and returns its category. It exits if
+// the port was lost and -exit_on says so, or if this was the
+// -max_consecutive_errors error in a row.
+func (l *errorLog) log(op string, err error) string {
+	c := category(err)
+	l.counts[c]++
+	l.consecutive++
+	if l.json == nil {
+		log.Printf("ERROR: %v: %v", op, err)
+	} else if err := l.json.Encode(&errorRecord{time.Now(), c, op, err.Error(), l.counts[c], l.counts}); err != nil {
+		log.Printf("ERROR: writing the error log: %v", err)
+	}
+	if c == portLost && l.exitOnPortLost {
+		l.exit()
+	}
+	if l.maxConsecutive > 0 && l.consecutive >= l.maxConsecutive {
+		log.Printf("giving up after %d errors in a row", l.consecutive)
+		l.exit()
+	}
+	return c
+}
+
+func (l *errorLog) exit() {
+	if l.atExit != nil {
+		l.atExit()
+	}
+	os.Exit(1)
+}
+
+// ok records a success, which ends a run of errors.
+func (l *errorLog) ok() {
+	l.consecutive = 0
+}
@@ -18,17 +18,47 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/ryszard/sds011/go/capture"
 	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
 )
 
 var (
-	portPath = flag.String("port_path", "/dev/ttyUSB0", "serial port path")
+	portPath    = flag.String("port_path", "/dev/ttyUSB0", "serial port path, or a glob matching exactly one, like /dev/serial/by-id/*1a86*")
+	record      = flag.String("record", "", "if set, record the traffic with the sensor to this file (see package capture)")
+	lowPower    = flag.Duration("low_power", 0, "if set, keep the sensor asleep, and only wake it up to take a reading this often")
+	warmup      = flag.Duration("warmup", 30*time.Second, "in low power mode, how long to let the sensor warm up before reading")
+	samples     = flag.Int("samples", 1, "in low power mode, how many readings, a second apart, to average")
+	jsonErrs    = flag.Bool("json_errors", false, "log errors to stderr as JSON records, with a category: checksum, desync, timeout, port_lost or other")
+	diagnose    = flag.Bool("diagnostics", false, "add the diagnostics columns to every reading")
+	columns     = flag.String("columns", "timestamp,pm25,pm10", "comma separated columns to output, in order")
+	format      = flag.String("format", "csv", "output format: \"csv\", or \"arrow\" for an Arrow IPC stream, with the columns sensor (the port), timestamp, pm25, pm10 and pm1")
+	outPath     = flag.String("output", "-", "file to write the readings to; - means standard output")
+	arrowBatch  = flag.Int("arrow_batch", 60, "with -format=arrow, how many readings to write at a time, as a record batch; what's left is written when sds011 exits")
+	prec        = flag.Int("precision", -1, "decimal places of the PM levels; -1 means as many as needed")
+	monotone    = flag.Bool("monotonic", true, "make the timestamps strictly increasing, at the resolution of the output, by moving readings that would repeat or go back in time a second after the previous one, and dropping those of a sensor that reports more often than once a second that would have to be moved further")
+	stampAt     = flag.String("timestamp", "decoded", "when readings are stamped: when their frame was \"decoded\", or when its \"first_byte\" arrived")
+	wall        = flag.Bool("wall_clock", false, "compute the since column from the wall clock rather than the monotonic clock")
+	readTimeout = flag.Duration("read_timeout", 0, "if set, give up waiting for the sensor after this long without a byte from it, logging a timeout error, so that a wedged sensor is noticed; it should be longer than the time between readings")
+	maxErrs     = flag.Int("max_consecutive_errors", 0, "if positive, exit after this many errors in a row")
+	exitOn      = flag.String("exit_on", "port_lost", "when to exit, besides -max_consecutive_errors: when the port is lost (\"port_lost\"), or \"never\", opening it again once it's back")
+	agree       = flag.Int("consensus", 0, "if more than 1, hold back readings until this many in a row, counting them, agree within -consensus_tolerance, to keep out the spikes that gusts of air cause")
+	agreeAbs    = flag.Float64("consensus_tolerance", 2, "how far apart, in µg/m³, readings that agree may be")
+	agreeRel    = flag.Float64("consensus_relative", 0.1, "how far apart readings that agree may be as a fraction of their mean, if that's more than -consensus_tolerance")
+	banner      = flag.String("banner", "stderr", "where to say which sensor, with what settings, the data comes from, once it's started: \"stderr\", \"output\" (a line starting with # at the top of the output), or \"none\"")
+	seqFile     = flag.String("seq_file", "", "if set, keep the sequence numbers of the seq column in this file, so that they carry on when sds011 is restarted")
+	dedupe      = flag.Duration("dedupe", 0, "if set, drop readings with the same levels as the previous one that come less than this after it, which some firmware sends back-to-back in cycle mode; it should be shorter than the time between readings")
 )
 
 func init() {
@@ -36,7 +66,38 @@ func init() {
 		fmt.Fprint(os.Stderr,
 			`sds011 reads data from the SDS011 sensor and sends them to stdout as CSV.
 
-The columns are: an RFC3339 timestamp, the PM2.5 level, the PM10 level.`)
+The columns are: an RFC3339 timestamp, the PM2.5 level, the PM10 level.
+-columns chooses others, from:
+
+`+columnHelp()+`
+-diagnostics adds discarded, resyncs, checksum and since to the columns.
+
+The seq column numbers the readings, so that consumers at the other
+end of a lossy transport can tell when some were lost or repeated.
+With -seq_file, the numbers carry on when sds011 is restarted.
+
+Timestamps are strictly increasing, unless -monotonic=false: a reading
+that would have the same timestamp as the previous one, or an earlier
+one (as when the clock is set back), is moved a second after it. So as
+not to run ahead of the clock, a reading is moved no more than a
+second past its own time, unless the clock was set back, and is
+dropped if it would have to be.
+
+On start, the sensor's port, ID, firmware, report mode and working
+period are logged to stderr, or with -banner=output written to stdout
+as a line starting with #.
+
+With -low_power, the sensor sleeps between readings, which makes its
+laser last much longer.
+
+-format=arrow writes an Arrow IPC stream instead, for pandas, polars
+or pyarrow, in record batches of -arrow_batch readings. The stream is
+ended when sds011 exits, including on SIGINT and SIGTERM.
+
+Errors are logged to stderr. sds011 exits with status 1 when the port
+is lost, as when the sensor is unplugged, unless -exit_on=never, and
+after -max_consecutive_errors errors in a row, if set, so that a
+supervisor like systemd can restart it.`)
 		fmt.Fprintf(os.Stderr, "\n\nUsage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
@@ -45,18 +106,129 @@ The columns are: an RFC3339 timestamp, the PM2.5 level, the PM10 level.`)
 func main() {
 	flag.Parse()
 
-	sensor, err := sds011.New(*portPath)
+	if *exitOn != "port_lost" && *exitOn != "never" {
+		log.Fatalf("bad -exit_on %q, want port_lost or never", *exitOn)
+	}
+	if *banner != "stderr" && *banner != "output" && *banner != "none" {
+		log.Fatalf("bad -banner %q, want stderr, output or none", *banner)
+	}
+	if *format != "csv" && *format != "arrow" {
+		log.Fatalf("bad -format %q, want csv or arrow", *format)
+	}
+	if *arrowBatch < 1 {
+		log.Fatalf("bad -arrow_batch %v", *arrowBatch)
+	}
+	if *stampAt != "decoded" && *stampAt != "first_byte" {
+		log.Fatalf("bad -timestamp %q, want decoded or first_byte", *stampAt)
+	}
+	if *prec < -1 {
+		log.Fatalf("bad -precision %v", *prec)
+	}
+	if *agreeAbs < 0 || *agreeRel < 0 {
+		log.Fatalf("bad -consensus_tolerance (%v) or -consensus_relative (%v), want them non-negative", *agreeAbs, *agreeRel)
+	}
+	if *lowPower > 0 && *warmup >= *lowPower {
+		log.Fatalf("-warmup (%v) should be shorter than -low_power (%v)", *warmup, *lowPower)
+	}
+	if *samples < 1 {
+		log.Fatalf("bad -samples %v, want at least 1", *samples)
+	}
+	var w io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	p, err := openPort(*portPath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	var rwc io.ReadWriteCloser = p
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		rwc = capture.Record(rwc, f)
+	}
+	sensor := sds011.NewSensor(rwc)
 	defer sensor.Close()
+	sensor.SetTimestamps(sds011.TimestampOptions{FirstByte: *stampAt == "first_byte", WallClock: *wall})
+	sensor.SetWarmup(*warmup)
+	sensor.SetReadTimeout(*readTimeout)
+	errs := newErrorLog(*jsonErrs)
+	errs.exitOnPortLost, errs.maxConsecutive = *exitOn == "port_lost", *maxErrs
+	names := strings.Split(*columns, ",")
+	if *diagnose {
+		names = append(names, diagnosticsColumns...)
+	}
+	out, err := newOutput(sensor, p, names, w)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if out.seq, err = sink.NewSequencer(*seqFile); err != nil {
+		log.Fatalf("-seq_file: %v", err)
+	}
+	errs.atExit = out.close
+	if *format == "arrow" || *seqFile != "" {
+		// The stream has to be ended, and the readings of the last
+		// batch written, and the sequence numbers saved.
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			out.close()
+			os.Exit(0)
+		}()
+	}
+	out.consensus = sds011.Consensus{Readings: *agree, Tolerance: *agreeAbs, Relative: *agreeRel}
+	if *banner != "none" {
+		if info, err := queryDeviceInfo(sensor, p.path); err != nil {
+			errs.log("device info", err)
+		} else if *banner == "stderr" {
+			log.Printf("sensor: %v", info)
+		} else if err := out.banner(info); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *lowPower > 0 {
+		readLowPower(sensor, p, errs, out)
+		return
+	}
 
 	for {
 		point, err := sensor.Get()
 		if err != nil {
-			log.Printf("ERROR: sensor.Get: %v", err)
+			if errs.log("sensor.Get", err) == portLost {
+				p.reopen(errs)
+			}
 			continue
 		}
-		fmt.Fprintf(os.Stdout, "%v,%v,%v\n", point.Timestamp.Format(time.RFC3339), point.PM25, point.PM10)
+		errs.ok()
+		out.write(point)
+	}
+}
+
+// readLowPower wakes the sensor up to take a reading every -low_power,
+// and keeps it asleep otherwise.
+func readLowPower(sensor *sds011.Sensor, p *port, errs *errorLog, out *output) {
+	ticker := time.NewTicker(*lowPower)
+	defer ticker.Stop()
+	for {
+		point, err := sensor.MeasureAverage(context.Background(), *warmup, *samples)
+		if err != nil {
+			if errs.log("sensor.MeasureAverage", err) == portLost {
+				p.reopen(errs)
+			}
+		} else {
+			errs.ok()
+			out.write(point)
+		}
+		<-ticker.C
 	}
 }
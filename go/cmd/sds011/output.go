@@ -0,0 +1,291 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryszard/sds011/go/aqi"
+	"github.com/ryszard/sds011/go/arrow"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// row is what a column is computed from.
+type row struct {
+	point *sds011.Point
+	// since are the sensor's diagnostics since the previous reading,
+	// taken elapsed before this one. elapsed is 0 for the first.
+	since   sds011.Diagnostics
+	elapsed time.Duration
+	// port is the path of the serial port.
+	port string
+	// seq is the sequence number of the reading.
+	seq uint64
+}
+
+// A column is a column of the output.
+type column struct {
+	name, help string
+	value      func(r *row) string
+}
+
+// level formats a PM level with -precision decimal places.
+func level(v float64) string {
+	return strconv.FormatFloat(v, 'f', *prec, 64)
+}
+
+var allColumns = []column{
+	{"timestamp", "the time of the reading, in RFC3339 format", func(r *row) string { return r.point.Timestamp.Format(time.RFC3339) }},
+	{"unix", "the time of the reading, in seconds since the Unix epoch", func(r *row) string { return strconv.FormatInt(r.point.Timestamp.Unix(), 10) }},
+	{"pm25", "the PM2.5 level, in µg/m³", func(r *row) string { return level(r.point.PM25) }},
+	{"pm10", "the PM10 level, in µg/m³", func(r *row) string { return level(r.point.PM10) }},
+	{"pm1", "the PM1.0 level, in µg/m³, for clones that measure it; empty otherwise", func(r *row) string {
+		if !r.point.HasPM1 {
+			return ""
+		}
+		return level(r.point.PM1)
+	}},
+	{"aqi_pm25", "the US AQI of the PM2.5 level (for the reading itself, not a 24-hour mean)", func(r *row) string { return strconv.Itoa(aqi.PM25(r.point.PM25)) }},
+	{"aqi_pm10", "the US AQI of the PM10 level (likewise)", func(r *row) string { return strconv.Itoa(aqi.PM10(r.point.PM10)) }},
+	{"aqi_category", "the AQI category of the higher of the two", func(r *row) string {
+		i := aqi.PM25(r.point.PM25)
+		if j := aqi.PM10(r.point.PM10); j > i {
+			i = j
+		}
+		return aqi.CategoryOf(i).String()
+	}},
+	{"port", "the serial port path", func(r *row) string { return r.port }},
+	{"seq", "the sequence number of the reading, counting from 1, for telling when readings are lost or repeated on the way (see -seq_file)", func(r *row) string { return strconv.FormatUint(r.seq, 10) }},
+	{"since_wake", "seconds since the sensor was woken up, in low power mode; empty otherwise", func(r *row) string {
+		if r.point.Quality.SinceWake == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(r.point.Quality.SinceWake.Seconds(), 'f', 3, 64)
+	}},
+	{"in_warmup", "whether the reading was taken before the sensor warmed up (see -warmup)", func(r *row) string { return strconv.FormatBool(r.point.Quality.InWarmup) }},
+	{"discarded", "frames discarded since the previous reading", func(r *row) string { return strconv.Itoa(r.since.Discarded) }},
+	{"resyncs", "resyncs since the previous reading", func(r *row) string { return strconv.Itoa(r.since.Resyncs) }},
+	{"checksum", "bad checksums since the previous reading", func(r *row) string { return strconv.Itoa(r.since.Checksum) }},
+	{"since", "seconds since the previous reading; empty for the first", func(r *row) string {
+		if r.elapsed == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(r.elapsed.Seconds(), 'f', 3, 64)
+	}},
+}
+
+// diagnosticsColumns are the columns -diagnostics adds.
+var diagnosticsColumns = []string{"discarded", "resyncs", "checksum", "since"}
+
+// columnHelp describes the columns, for the usage message.
+func columnHelp() string {
+	var b strings.Builder
+	for _, c := range allColumns {
+		fmt.Fprintf(&b, "  %-13v %v\n", c.name, c.help)
+	}
+	return b.String()
+}
+
+// output writes readings as CSV, or with -format=arrow as an Arrow
+// stream.
+type output struct {
+	sensor  *sds011.Sensor
+	port    *port
+	columns []column
+	w       io.Writer
+	// seq numbers the readings.
+	seq *sink.Sequencer
+
+	// mu guards the rest, as the output is closed on a signal.
+	mu sync.Mutex
+	// stream is the Arrow stream, once it's started; metadata is what
+	// it starts with, and batch are the readings waiting for the next
+	// record batch.
+	stream   *arrow.Writer
+	metadata map[string]string
+	batch    []*sink.Reading
+	closed   bool
+	// last are the sensor's diagnostics as of the previous reading.
+	last sds011.Diagnostics
+	// previous is the previous reading, as the sensor reported it,
+	// and stamped the timestamp it was output with.
+	previous *sds011.Point
+	stamped  time.Time
+	// consensus holds back readings as -consensus says.
+	consensus sds011.Consensus
+}
+
+func newOutput(sensor *sds011.Sensor, p *port, names []string, w io.Writer) (*output, error) {
+	o := &output{sensor: sensor, port: p, w: w}
+	for _, name := range names {
+		found := false
+		for _, c := range allColumns {
+			if c.name == strings.TrimSpace(name) {
+				o.columns = append(o.columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q; see -help", name)
+		}
+	}
+	return o, nil
+}
+
+// duplicate returns whether point repeats the previous reading
+// back-to-back, and should be dropped as -dedupe says.
+func (o *output) duplicate(point *sds011.Point) bool {
+	p := o.previous
+	return *dedupe > 0 && p != nil &&
+		point.PM25 == p.PM25 && point.PM10 == p.PM10 && point.PM1 == p.PM1 && point.HasPM1 == p.HasPM1 &&
+		point.Timestamp.Sub(p.Timestamp) < *dedupe
+}
+
+// stamp returns the timestamp to output point with: with -monotonic,
+// one that is later than the previous one at the resolution of the
+// output, which is a second. A reading is moved a second after the
+// previous one, but no further ahead of its own time than a second, or
+// than the previous one was when the clock was set back, lest a sensor
+// that reports more often than once a second run ever further ahead of
+// the clock. ok is false for a reading that can't be moved, which is
+// dropped.
+func (o *output) stamp(point *sds011.Point) (t time.Time, ok bool) {
+	t = point.Timestamp
+	if !*monotone || o.previous == nil {
+		return t, true
+	}
+	last := o.stamped.Truncate(time.Second)
+	if t.Truncate(time.Second).After(last) {
+		return t, true
+	}
+	moved := last.Add(time.Second)
+	if t.Before(o.previous.Timestamp) {
+		// The clock was set back.
+		return moved, true
+	}
+	ahead := o.stamped.Sub(o.previous.Timestamp)
+	if ahead < time.Second {
+		ahead = time.Second
+	}
+	if moved.Sub(t) > ahead {
+		return time.Time{}, false
+	}
+	return moved, true
+}
+
+// banner says where the readings come from, at the top of the output.
+func (o *output) banner(info *deviceInfo) error {
+	if *format == "arrow" {
+		o.metadata = info.metadata()
+		return nil
+	}
+	return info.writeBanner(o.w)
+}
+
+func (o *output) write(point *sds011.Point) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	if o.duplicate(point) {
+		log.Printf("dropping a duplicate reading: %v", point)
+		return
+	}
+	if !o.consensus.Add(point) {
+		log.Printf("holding back a reading until it has a consensus: %v", point)
+		return
+	}
+	stamped := *point
+	var ok bool
+	if stamped.Timestamp, ok = o.stamp(point); !ok {
+		log.Printf("dropping a reading in the same second as the previous one: %v", point)
+		return
+	}
+	d := o.sensor.Diagnostics()
+	r := &row{since: d.Sub(o.last), port: o.port.path}
+	if o.previous != nil {
+		// The timestamps -monotonic moves would make for made up
+		// intervals.
+		r.elapsed = point.Timestamp.Sub(o.previous.Timestamp)
+	}
+	o.last, o.previous = d, point
+	o.stamped = stamped.Timestamp
+	reading := &sink.Reading{Sensor: o.port.path, Point: &stamped}
+	// The numbers are kept by -port_path, which stays the same when
+	// a glob matches another port.
+	var err error
+	if reading.Seq, err = o.seq.Next(o.port.pattern); err != nil {
+		log.Printf("-seq_file: %v", err)
+	}
+	if *format == "arrow" {
+		o.batch = append(o.batch, reading)
+		if len(o.batch) >= *arrowBatch {
+			o.flush()
+		}
+		return
+	}
+	r.point, r.seq = &stamped, reading.Seq
+	values := make([]string, len(o.columns))
+	for i, c := range o.columns {
+		values[i] = c.value(r)
+	}
+	fmt.Fprintln(o.w, strings.Join(values, ","))
+}
+
+// flush writes the batch as an Arrow record batch, starting the stream
+// if need be. It must be called with the lock held.
+func (o *output) flush() {
+	if o.stream == nil {
+		o.stream = arrow.NewWriter(o.w, o.metadata)
+	}
+	if len(o.batch) == 0 {
+		return
+	}
+	if err := o.stream.Write(o.batch); err != nil {
+		log.Fatal(err)
+	}
+	o.batch = o.batch[:0]
+}
+
+// close finishes the output: it saves the sequence numbers, and with
+// -format=arrow it writes what's left of the batch and ends the stream.
+// Nothing is written after.
+func (o *output) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	if err := o.seq.Close(); err != nil {
+		log.Printf("-seq_file: %v", err)
+	}
+	if *format != "arrow" {
+		return
+	}
+	o.flush()
+	if err := o.stream.Close(); err != nil {
+		log.Print(err)
+	}
+}
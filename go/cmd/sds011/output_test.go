@@ -0,0 +1,87 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+var t0 = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// stamps writes readings taken at offsets from t0 and returns the unix
+// timestamps they are output with, relative to t0.
+func stamps(t *testing.T, offsets ...time.Duration) []int64 {
+	t.Helper()
+	fake := sds011test.NewFake()
+	sensor := sds011.NewSensor(fake)
+	defer sensor.Close()
+	seq, err := sink.NewSequencer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	o, err := newOutput(sensor, &port{path: "/dev/ttyUSB0"}, []string{"unix"}, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.seq = seq
+	for _, d := range offsets {
+		o.write(&sds011.Point{PM25: 10, PM10: 20, Timestamp: t0.Add(d)})
+	}
+	var got []int64
+	for _, line := range strings.Fields(b.String()) {
+		unix, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, unix-t0.Unix())
+	}
+	return got
+}
+
+func TestMonotonic(t *testing.T) {
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name    string
+		offsets []time.Duration
+		want    []int64
+	}{
+		{"once a second", []time.Duration{0, 1000 * ms, 2000 * ms}, []int64{0, 1, 2}},
+		{"same second", []time.Duration{0, 300 * ms, 1500 * ms}, []int64{0, 1, 2}},
+		// Every reading is moved a second, but no further: the one
+		// that would have to be is dropped, rather than the rest
+		// running ahead of the clock.
+		{"faster than once a second", []time.Duration{0, 900 * ms, 1800 * ms, 2700 * ms, 3600 * ms, 4500 * ms, 5400 * ms, 6300 * ms, 7200 * ms, 8100 * ms, 9000 * ms, 9900 * ms, 10800 * ms},
+			[]int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{"several a second", []time.Duration{0, 200 * ms, 400 * ms, 600 * ms, 800 * ms, 1000 * ms, 1200 * ms, 2100 * ms}, []int64{0, 1, 2, 3}},
+		// Until the clock catches up, readings are moved as far as
+		// it was set back.
+		{"clock set back", []time.Duration{10 * time.Second, 0, time.Second, 1500 * ms, 12 * time.Second, 14 * time.Second}, []int64{10, 11, 12, 13, 14}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stamps(t, tc.offsets...); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("timestamps: %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A port is the serial port of the sensor, which can be opened again
+// after it was lost, for example when the sensor is plugged back in.
+// The sensor talks to it rather than to the port itself, so that it
+// keeps its state and diagnostics across reopening.
+//
+// The port can be given by a glob, like /dev/serial/by-id/*1a86*,
+// which is matched again when it's reopened.
+type port struct {
+	pattern, path string
+	rwc           io.ReadWriteCloser
+}
+
+func openPort(pattern string) (*port, error) {
+	p := &port{pattern: pattern}
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// open opens the port pattern matches.
+func (p *port) open() error {
+	path, err := findPort(p.pattern)
+	if err != nil {
+		return err
+	}
+	rwc, err := sds011.OpenPort(path)
+	if err != nil {
+		return err
+	}
+	if path != p.pattern && path != p.path {
+		log.Printf("%v is %v", p.pattern, path)
+	}
+	p.path, p.rwc = path, rwc
+	return nil
+}
+
+// findPort returns the path of the port pattern names: pattern itself,
+// or, if it's a glob, the one path it matches.
+func findPort(pattern string) (string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern, nil
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", fmt.Errorf("bad port pattern %q: %v", pattern, err)
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no port matches %v", pattern)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%v matches %d ports, want one: %v", pattern, len(matches), strings.Join(matches, ", "))
+}
+
+func (p *port) Read(b []byte) (int, error)  { return p.rwc.Read(b) }
+func (p *port) Write(b []byte) (int, error) { return p.rwc.Write(b) }
+func (p *port) Close() error                { return p.rwc.Close() }
+
+// reopen closes the port, and opens it again, trying every second
+// until it's back or errs gives up.
+func (p *port) reopen(errs *errorLog) {
+	p.rwc.Close()
+	for {
+		time.Sleep(time.Second)
+		if err := p.open(); err != nil {
+			errs.log("opening the port", err)
+			continue
+		}
+		log.Printf("reopened %v", p.path)
+		return
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011_exporter is a Prometheus exporter for the SDS011 sensor. It
+// keeps the sensor in active mode and serves its readings, settings
+// and read statistics on /metrics.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var (
+	portPath      = flag.String("port_path", "/dev/ttyUSB0", "serial port path")
+	name          = flag.String("name", "", "sensor name used in the labels (defaults to port_path)")
+	listenAddress = flag.String("listen_address", ":9184", "address to serve metrics on")
+	refresh       = flag.Duration("refresh", 10*time.Minute, "how often to query the sensor for its settings")
+)
+
+func main() {
+	flag.Parse()
+	if *name == "" {
+		*name = *portPath
+	}
+
+	sensor, err := sds011.New(*portPath)
+	if err != nil {
+		log.Exit(err)
+	}
+	defer sensor.Close()
+	if err := sensor.SetReportMode(sds011.ActiveMode); err != nil {
+		log.Exit(err)
+	}
+
+	m := exporter.NewMetrics()
+	go exporter.Run(context.Background(), sensor, *name, m, *refresh)
+
+	http.Handle("/metrics", m)
+	log.Infof("serving metrics on %v", *listenAddress)
+	log.Exit(http.ListenAndServe(*listenAddress, nil))
+}
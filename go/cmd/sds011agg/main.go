@@ -0,0 +1,210 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011agg summarizes recorded readings by hour or by day: the mean
+// and maximum levels, and the number of hours the hourly mean was over
+// a limit. It reads what the sds011 command and the csv and jsonl sinks
+// of sds011d write.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+var (
+	period    = flag.String("period", "day", `what to summarize over, "hour" or "day"`)
+	format    = flag.String("format", "csv", `output format, "csv" or "json"`)
+	location  = flag.String("location", "Local", "time zone the hours and days are in")
+	pm25Limit = flag.Float64("pm25_limit", 15, "hourly PM2.5 mean counted as an exceedance, in µg/m³")
+	pm10Limit = flag.Float64("pm10_limit", 45, "hourly PM10 mean counted as an exceedance, in µg/m³")
+	maxHold   = flag.Duration("max_hold", 5*time.Minute, "longest a reading counts for in the means")
+	sensor    = flag.String("sensor", "", "name for readings without one")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011agg summarizes recorded readings by hour or by day.
+
+It reads the CSV of the sds011 command, and the CSV and JSON lines of
+the sinks of sds011d, from the files given as arguments (or standard
+input), and writes, for every sensor and period with readings: the
+number of readings, how much of the period they cover, the mean and
+maximum PM2.5 and PM10, and the number of hours their hourly means
+were over the limits.
+
+Usage: sds011agg [flags] [file...]
+`)
+		flag.PrintDefaults()
+	}
+}
+
+// summary is the summary of a sensor's readings over a period.
+type summary struct {
+	Sensor string    `json:"sensor"`
+	Start  time.Time `json:"start"`
+	Count  int       `json:"count"`
+	// Coverage is the fraction of the period the readings stand for.
+	Coverage  float64 `json:"coverage"`
+	PM25Mean  float64 `json:"pm25_mean"`
+	PM25Max   float64 `json:"pm25_max"`
+	PM10Mean  float64 `json:"pm10_mean"`
+	PM10Max   float64 `json:"pm10_max"`
+	PM25Hours int     `json:"pm25_exceedance_hours"`
+	PM10Hours int     `json:"pm10_exceedance_hours"`
+}
+
+// next returns the function giving the start of the period after
+// the one starting at t.
+func next(period string) (func(t time.Time) time.Time, error) {
+	switch period {
+	case "hour":
+		return func(t time.Time) time.Time { return t.Add(time.Hour) }, nil
+	case "day":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }, nil
+	}
+	return nil, fmt.Errorf("unknown period %q", period)
+}
+
+// truncate returns the start of the period t is in.
+func truncate(period string, t time.Time) time.Time {
+	if period == "hour" {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// compute returns the statistics of the points in [start, end).
+func compute(points []sds011.Point, start, end time.Time) stats.Stats {
+	return stats.Compute(points, start.Add(-1), end.Add(-1), *maxHold)
+}
+
+// summarize returns the summaries of the points of a sensor, which are
+// in time order.
+func summarize(name string, points []sds011.Point, advance func(time.Time) time.Time) []*summary {
+	var summaries []*summary
+	for start := truncate(*period, points[0].Timestamp); !start.After(points[len(points)-1].Timestamp); start = advance(start) {
+		end := advance(start)
+		s := compute(points, start, end)
+		if s.Count == 0 {
+			continue
+		}
+		sum := &summary{
+			Sensor: name,
+			Start:  start,
+			Count:  s.Count,
+			// The period is a nanosecond short of its length, see compute.
+			Coverage: math.Round(1000*s.Covered.Seconds()/end.Sub(start).Seconds()) / 1000,
+			PM25Mean: s.PM25.Mean,
+			PM25Max:  s.PM25.Max,
+			PM10Mean: s.PM10.Mean,
+			PM10Max:  s.PM10.Max,
+		}
+		for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+			h := compute(points, hour, hour.Add(time.Hour))
+			if h.Count == 0 {
+				continue
+			}
+			if h.PM25.Mean > *pm25Limit {
+				sum.PM25Hours++
+			}
+			if h.PM10.Mean > *pm10Limit {
+				sum.PM10Hours++
+			}
+		}
+		summaries = append(summaries, sum)
+	}
+	return summaries
+}
+
+func writeCSV(summaries []*summary) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"sensor", "start", "count", "coverage", "pm25_mean", "pm25_max", "pm10_mean", "pm10_max", "pm25_exceedance_hours", "pm10_exceedance_hours"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+	for _, s := range summaries {
+		w.Write([]string{
+			s.Sensor,
+			s.Start.Format(time.RFC3339),
+			strconv.Itoa(s.Count),
+			strconv.FormatFloat(s.Coverage, 'f', 3, 64),
+			f(s.PM25Mean), f(s.PM25Max),
+			f(s.PM10Mean), f(s.PM10Max),
+			strconv.Itoa(s.PM25Hours),
+			strconv.Itoa(s.PM10Hours),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func main() {
+	flag.Parse()
+	advance, err := next(*period)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loc, err := time.LoadLocation(*location)
+	if err != nil {
+		log.Fatal(err)
+	}
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	readings, err := sink.ReadFiles(*sensor, paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var names []string
+	points := make(map[string][]sds011.Point)
+	for _, r := range readings {
+		if _, ok := points[r.Sensor]; !ok {
+			names = append(names, r.Sensor)
+		}
+		p := *r.Point
+		p.Timestamp = p.Timestamp.In(loc)
+		points[r.Sensor] = append(points[r.Sensor], p)
+	}
+	summaries := []*summary{}
+	for _, name := range names {
+		summaries = append(summaries, summarize(name, points[name], advance)...)
+	}
+
+	switch *format {
+	case "csv":
+		err = writeCSV(summaries)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(summaries)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011cal fits the calibration of a sensor to the readings of a
+// reference monitor next to it, and prints it as the calibration
+// config of sds011d.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+var (
+	reference = flag.String("reference", "", "CSV file with the readings of the reference monitor: timestamp, PM2.5, PM10")
+	name      = flag.String("sensor", "", "if set, the name of the sensor to take from the files")
+	interval  = flag.Duration("interval", time.Hour, "length of the intervals the readings are averaged over before fitting; the reference timestamps should be the starts of them")
+	maxHold   = flag.Duration("max_hold", 5*time.Minute, "longest a reading counts for in the means")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011cal fits the calibration of a sensor to a reference monitor.
+
+It averages the readings of the sensor, from the files given as
+arguments (or standard input), over the intervals of the readings of
+the reference, and fits the lines reference = scale * sensor + offset
+to them. It prints the result in the format of the calibration of a
+sensor in the config of sds011d, and how well it fits to standard
+error.
+
+Usage: sds011cal -reference=reference.csv [flags] [file...]
+`)
+		flag.PrintDefaults()
+	}
+}
+
+// Linear is the correction Scale*x + Offset, as in the config of
+// sds011d.
+type Linear struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// Calibration is the calibration of a sensor, as in the config of
+// sds011d.
+type Calibration struct {
+	PM25 Linear `json:"pm25"`
+	PM10 Linear `json:"pm10"`
+}
+
+// report prints how well the correction fits a level.
+func report(name string, pairs []stats.Pair, ag stats.Agreement, fit Linear, level func(*sds011.Point) float64) {
+	// The residuals are what's left of the differences after
+	// correcting the sensor.
+	var residuals float64
+	for i := range pairs {
+		d := level(&pairs[i].B) - (fit.Scale*level(&pairs[i].A) + fit.Offset)
+		residuals += d * d
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d intervals, R² %.3f, RMSD %.2f before and %.2f after\n",
+		name, ag.N, ag.Correlation*ag.Correlation, ag.RMSD, math.Sqrt(residuals/float64(len(pairs))))
+}
+
+func main() {
+	flag.Parse()
+	if *reference == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	readings, err := sink.ReadFiles("", paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var sensor []sds011.Point
+	for _, r := range readings {
+		if *name == "" || r.Sensor == *name {
+			sensor = append(sensor, *r.Point)
+		}
+	}
+	ref, err := sink.ReadFiles("", *reference)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var refPoints []sds011.Point
+	for _, r := range ref {
+		refPoints = append(refPoints, *r.Point)
+	}
+
+	pairs := stats.Align(sensor, refPoints, *interval, *maxHold)
+	c := stats.Compare(pairs)
+	cal := Calibration{
+		PM25: Linear{Scale: c.PM25.Slope, Offset: c.PM25.Intercept},
+		PM10: Linear{Scale: c.PM10.Slope, Offset: c.PM10.Intercept},
+	}
+	if math.IsNaN(cal.PM25.Scale) || math.IsNaN(cal.PM10.Scale) || len(pairs) < 2 {
+		log.Fatalf("not enough intervals with readings of both the sensor and the reference to fit: %d", len(pairs))
+	}
+	report("PM2.5", pairs, c.PM25, cal.PM25, func(p *sds011.Point) float64 { return p.PM25 })
+	report("PM10", pairs, c.PM10, cal.PM10, func(p *sds011.Point) float64 { return p.PM10 })
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]Calibration{"calibration": cal}); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+const (
+	// failureDelay is how long the benchmark waits after an error
+	// before trying again, so that a sensor that doesn't answer, as
+	// when it's unplugged or asleep, isn't flooded with commands.
+	failureDelay = time.Second
+	// maxFailures is how many errors in a row make the benchmark give
+	// up.
+	maxFailures = 10
+)
+
+// failures counts errors, and the errors in a row.
+type failures struct {
+	total, inRow int
+}
+
+// fail counts err, waits failureDelay and returns an error if there
+// were too many in a row.
+func (f *failures) fail(err error) error {
+	f.total++
+	f.inRow++
+	if f.inRow >= maxFailures {
+		return fmt.Errorf("giving up after %d errors in a row: %v", f.inRow, err)
+	}
+	time.Sleep(failureDelay)
+	return nil
+}
+
+// benchmark measures how well the sensor and the line work, for
+// comparing adapters, cables and firmware versions: the round trip
+// latency of commands and how many replies are lost, and the intervals
+// between the measurements of active mode.
+//
+// The sensor is put in active mode for the duration, so that a lost
+// reply is noticed when measurements come instead (see
+// sds011.ErrNoReply), and then put back in the mode it was in. Noticing
+// takes a few seconds, so a benchmark with lost replies runs over.
+// Other errors are followed by a pause, and too many in a row end the
+// benchmark.
+func benchmark(sensor *sds011.Sensor, duration time.Duration) error {
+	mode, err := sensor.ReportMode()
+	if err != nil {
+		return err
+	}
+	if err := sensor.SetReportMode(sds011.ActiveMode); err != nil {
+		return err
+	}
+	defer func() {
+		if mode == sds011.QueryMode {
+			if err := sensor.SetReportMode(sds011.QueryMode); err != nil {
+				fmt.Fprintf(os.Stderr, "restoring query mode: %v\n", err)
+			}
+		}
+	}()
+	start := sensor.Diagnostics()
+
+	var latencies []time.Duration
+	var lost int
+	var failed failures
+	for end := time.Now().Add(duration / 2); time.Now().Before(end); {
+		t := time.Now()
+		_, err := sensor.IsAwake()
+		switch {
+		case err == nil:
+			latencies = append(latencies, time.Since(t))
+			failed.inRow = 0
+		case errors.Is(err, sds011.ErrNoReply):
+			// The measurements that came instead show the
+			// sensor is there.
+			lost++
+			failed.inRow = 0
+		default:
+			if err := failed.fail(err); err != nil {
+				return err
+			}
+		}
+	}
+
+	var intervals []time.Duration
+	var prev time.Time
+	var p sds011.Point
+	for end := time.Now().Add(duration / 2); time.Now().Before(end); {
+		if err := sensor.ReadPoint(&p); err != nil {
+			if err := failed.fail(err); err != nil {
+				return err
+			}
+			continue
+		}
+		failed.inRow = 0
+		if !prev.IsZero() {
+			intervals = append(intervals, p.Timestamp.Sub(prev))
+		}
+		prev = p.Timestamp
+	}
+	d := sensor.Diagnostics().Sub(start)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	commands := len(latencies) + lost + failed.total
+	fmt.Fprintf(w, "commands\t%d\n", commands)
+	if commands > 0 {
+		fmt.Fprintf(w, "lost replies\t%d (%.1f%%)\n", lost, 100*float64(lost)/float64(commands))
+	}
+	fmt.Fprintf(w, "latency\t%v\n", summary(latencies))
+	fmt.Fprintf(w, "measurement interval\t%v\n", summary(intervals))
+	fmt.Fprintf(w, "other errors\t%d\n", failed.total)
+	fmt.Fprintf(w, "frames\t%d\n", d.Frames)
+	fmt.Fprintf(w, "bad checksums\t%d\n", d.Checksum)
+	fmt.Fprintf(w, "resyncs\t%d (%d bytes skipped)\n", d.Resyncs, d.SkippedBytes)
+	return w.Flush()
+}
+
+// summary describes the distribution of ds.
+func summary(ds []time.Duration) string {
+	if len(ds) == 0 {
+		return "-"
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	return fmt.Sprintf("min %v  mean %v  p95 %v  max %v",
+		round(ds[0]), round(sum/time.Duration(len(ds))), round(ds[len(ds)*95/100]), round(ds[len(ds)-1]))
+}
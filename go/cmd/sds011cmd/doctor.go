@@ -0,0 +1,243 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// replyTimeout is how long the doctor waits for the sensor to answer
+// before it gives up on it.
+const replyTimeout = 5 * time.Second
+
+// maxBadFrames is the fraction of frames with bad checksums, or of
+// resyncs, above which the line is too noisy.
+const maxBadFrames = 0.01
+
+// chipsets are the USB serial adapters commonly used with the sensor,
+// by vendor and product ID.
+var chipsets = map[string]string{
+	"1a86:7523": "CH340",
+	"1a86:5523": "CH341",
+	"0403:6001": "FTDI FT232R",
+	"0403:6015": "FTDI FT231X",
+	"10c4:ea60": "Silicon Labs CP210x",
+	"067b:2303": "Prolific PL2303",
+}
+
+// doctor checks, one after the other, what it takes to read the sensor
+// on path, writing what it found to w, and for what's wrong how to fix
+// it: that the port exists and may be opened, what adapter it is, that
+// the sensor answers, its report mode, and how many frames get
+// garbled over duration. It returns whether everything was fine.
+func doctor(path string, w io.Writer, duration time.Duration) bool {
+	d := &checkup{w: w}
+	d.run(path, duration)
+	return !d.failed
+}
+
+// checkup is the state of a doctor run.
+type checkup struct {
+	w      io.Writer
+	failed bool
+}
+
+func (d *checkup) ok(check, format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "ok    %v: %v\n", check, fmt.Sprintf(format, args...))
+}
+
+func (d *checkup) warn(check, found, fix string) {
+	fmt.Fprintf(d.w, "warn  %v: %v\n      %v\n", check, found, fix)
+}
+
+func (d *checkup) fail(check, found, fix string) {
+	d.failed = true
+	fmt.Fprintf(d.w, "FAIL  %v: %v\n      %v\n", check, found, fix)
+}
+
+func (d *checkup) run(path string, duration time.Duration) {
+	info, err := sds011.ResolvePort(path)
+	if err != nil {
+		found, fix := explain(err, "Check -port_path.")
+		if ports, err := sds011.Ports(); err == nil && len(ports) > 0 {
+			var paths []string
+			for _, p := range ports {
+				paths = append(paths, p.Stable())
+			}
+			fix += " Ports it may be on: " + strings.Join(paths, ", ") + "."
+		} else {
+			fix += " No USB serial ports were found: try another cable (some only carry power) or USB socket, and see dmesg."
+		}
+		d.fail("port", found, fix)
+		return
+	}
+	d.ok("port", "%v", info.Device)
+
+	port, err := sds011.OpenPort(path)
+	if err != nil {
+		found, fix := explain(err, "Check that -port_path is the serial port of the sensor.")
+		d.fail("permissions", found, fix)
+		return
+	}
+	d.ok("permissions", "the port opens")
+
+	if chipset := adapter(info.Device); chipset == "" {
+		d.ok("adapter", "unknown")
+	} else if !strings.HasPrefix(chipset, "CH34") {
+		d.warn("adapter", chipset,
+			"The sensor comes with a CH340 adapter. Others work, but if frames get lost or garbled, try the one it came with.")
+	} else {
+		d.ok("adapter", "%v", chipset)
+	}
+
+	sensor := sds011.NewSensor(port)
+	defer sensor.Close()
+	var awake bool
+	if err := d.within(sensor, func() (err error) {
+		awake, err = sensor.IsAwake()
+		return err
+	}); err != nil {
+		d.fail("frames", err.Error(),
+			"Check the wiring: TX of the adapter to RXD of the sensor, RX to TXD, and 5V, with the fan spinning. "+
+				"If another program reads the sensor, like sds011d, stop it.")
+		return
+	}
+	d.ok("frames", "the sensor answers")
+	if !awake {
+		d.warn("sleep", "the sensor is asleep",
+			"It's woken up for the rest of the checks, and put back to sleep after. If nothing wakes it up, sds011cmd reset_defaults does.")
+		if err := d.within(sensor, sensor.Awake); err != nil {
+			d.fail("sleep", err.Error(), "The sensor didn't wake up: unplug it and plug it back in.")
+			return
+		}
+		defer sensor.Sleep()
+	}
+
+	var mode sds011.ReportMode
+	if err := d.within(sensor, func() (err error) {
+		mode, err = sensor.ReportMode()
+		return err
+	}); err != nil {
+		d.fail("report mode", err.Error(), "The sensor stopped answering: check the wiring and the power.")
+		return
+	}
+	if mode == sds011.QueryMode {
+		d.warn("report mode", "query",
+			"The sensor only measures when asked, and sds011 waits for readings forever. sds011cmd reset_defaults puts it back in active mode.")
+	} else {
+		d.ok("report mode", "%v", mode)
+	}
+
+	start := sensor.Diagnostics()
+	for end := time.Now().Add(duration); time.Now().Before(end); {
+		err := d.within(sensor, func() error {
+			if mode == sds011.QueryMode {
+				_, err := sensor.Query()
+				return err
+			}
+			var p sds011.Point
+			return sensor.ReadPoint(&p)
+		})
+		if errors.Is(err, errTimeout) {
+			d.fail("checksums", "measurements stopped coming", "Check the power: the fan needs 5V at up to 80 mA, which a long USB cable or a weak hub may not give.")
+			return
+		}
+		if mode == sds011.QueryMode {
+			time.Sleep(time.Second)
+		}
+	}
+	diag := sensor.Diagnostics().Sub(start)
+	found := fmt.Sprintf("%d frames, %d with bad checksums, %d resyncs", diag.Frames, diag.Checksum, diag.Resyncs)
+	if diag.Frames == 0 || float64(diag.Checksum+diag.Resyncs) > maxBadFrames*float64(diag.Frames) {
+		d.fail("checksums", found,
+			"The line is noisy: use a shorter cable, keep it away from the fan and mains wiring, and try another adapter (sds011cmd benchmark compares them).")
+		return
+	}
+	d.ok("checksums", "%v", found)
+}
+
+// errTimeout is returned by within when the sensor doesn't answer.
+var errTimeout = fmt.Errorf("no answer in %v", replyTimeout)
+
+// attempts is how many times within tries a command, as on a noisy
+// line some replies get garbled.
+const attempts = 3
+
+// within calls f, which talks to the sensor, and returns its error, or
+// errTimeout if it doesn't return in time. Then, as the sensor can't
+// be used anymore, it's closed. Other errors are retried.
+func (d *checkup) within(sensor *sds011.Sensor, f func() error) (err error) {
+	for i := 0; i < attempts; i++ {
+		errc := make(chan error, 1)
+		go func() { errc <- f() }()
+		select {
+		case err = <-errc:
+		case <-time.After(replyTimeout):
+			sensor.Close()
+			return errTimeout
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// explain returns what went wrong in err, and how to fix it: as the
+// *sds011.PortError it may be says, or otherwise fix.
+func explain(err error, fix string) (found, how string) {
+	var pe *sds011.PortError
+	if !errors.As(err, &pe) {
+		return err.Error(), fix
+	}
+	return pe.Err.Error(), strings.ToUpper(pe.Hint[:1]) + pe.Hint[1:] + "."
+}
+
+// adapter returns the chipset of the USB adapter of the serial device,
+// like /dev/ttyUSB0, as its name if it's a known one or its vendor and
+// product ID otherwise, or "" if it can't tell. It only can on Linux,
+// where sysfs has the IDs.
+func adapter(device string) string {
+	// The device of the tty is the USB interface, and the IDs are
+	// those of the USB device it's on, a few directories up.
+	dir, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", filepath.Base(device), "device"))
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < 3 && dir != "/"; i, dir = i+1, filepath.Dir(dir) {
+		vendor, err := os.ReadFile(filepath.Join(dir, "idVendor"))
+		if err != nil {
+			continue
+		}
+		product, err := os.ReadFile(filepath.Join(dir, "idProduct"))
+		if err != nil {
+			return ""
+		}
+		id := strings.TrimSpace(string(vendor)) + ":" + strings.TrimSpace(string(product))
+		if name, ok := chipsets[id]; ok {
+			return fmt.Sprintf("%v (%v)", name, id)
+		}
+		return id
+	}
+	return ""
+}
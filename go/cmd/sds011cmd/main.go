@@ -1,24 +1,109 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	log "github.com/golang/glog"
 	"github.com/ryszard/sds011/go/sds011"
 )
 
-var portPath = flag.String("port_path", "/dev/ttyUSB0", "serial port path")
+var (
+	portPath    = flag.String("port_path", "/dev/ttyUSB0", "serial port path")
+	verify      = flag.Bool("verify", false, "read settings back, and fail if the sensor didn't apply them")
+	readTimeout = flag.Duration("read_timeout", 2*time.Second, "how long to wait for the sensor to answer before giving up; 0 means forever")
+)
 
 func main() {
 	flag.Parse()
 
-	sensor, err := sds011.New(*portPath)
+	if flag.Arg(0) == "ports" {
+		ports, err := sds011.Ports()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range ports {
+			fmt.Printf("%v\t%v\n", p.Path, p.ByID)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "doctor" {
+		// The doctor opens the port itself, to tell what's wrong
+		// when it can't be.
+		flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+		duration := flags.Duration("duration", 10*time.Second, "how long to read measurements for, counting the bad ones")
+		flags.Parse(flag.Args()[1:])
+		if !doctor(*portPath, os.Stdout, *duration) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "monitor" {
+		// The monitor reads the port itself, to see everything that
+		// comes over it.
+		flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+		query := flags.Duration("query", 0, "if set, send a query request this often, for a sensor in query mode")
+		flags.Parse(flag.Args()[1:])
+		port, err := sds011.OpenPort(*portPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer port.Close()
+		if err := monitor(port, os.Stdout, *query); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "soak" {
+		flags := flag.NewFlagSet("soak", flag.ExitOnError)
+		duration := flags.Duration("duration", 24*time.Hour, "how long to run for")
+		timeout := flags.Duration("timeout", 2*time.Second, "how long to wait for a reply before counting it as lost")
+		var o soakOptions
+		flags.DurationVar(&o.Sleep, "sleep", 10*time.Second, "how long the sensor sleeps in every cycle")
+		flags.DurationVar(&o.Warmup, "warmup", 5*time.Second, "how long the sensor is awake in every cycle before it's queried")
+		flags.IntVar(&o.Queries, "queries", 5, "how many queries, a second apart, the sensor gets in every cycle")
+		flags.DurationVar(&o.Period, "report_every", time.Hour, "how often to write a line of the report")
+		flags.Float64Var(&o.MaxLoss, "max_loss", 0.01, "the fraction of lost replies, or of bad frames, above which the sensor fails")
+		flags.Parse(flag.Args()[1:])
+		// Without a timeout, a lost reply to sleep would leave the
+		// test waiting forever.
+		sensor, err := sds011.NewWithOptions(*portPath, sds011.PortOptions{ReadTimeout: *timeout})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sensor.Close()
+		sensor.SetVerify(*verify)
+		// An interrupted test still gets its report.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		passed, err := soak(ctx, sensor, os.Stdout, *duration, o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !passed {
+			sensor.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Without a timeout, a sensor that doesn't answer, as when it's
+	// unplugged, would leave the command waiting forever.
+	sensor, err := sds011.NewWithOptions(*portPath, sds011.PortOptions{ReadTimeout: *readTimeout})
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer sensor.Close()
+	sensor.SetVerify(*verify)
 
 	switch cmd := flag.Arg(0); cmd {
 	case "cycle":
@@ -35,8 +120,57 @@ func main() {
 		if err := sensor.SetCycle(uint8(v)); err != nil {
 			log.Fatal(err)
 		}
+	case "wake_and_read":
+		flags := flag.NewFlagSet("wake_and_read", flag.ExitOnError)
+		warmup := flags.Duration("warmup", 30*time.Second, "how long to let the sensor warm up before reading")
+		sleep := flags.Bool("sleep", true, "put the sensor back to sleep after reading")
+		flags.Parse(flag.Args()[1:])
+		point, err := wakeAndRead(sensor, *warmup, *sleep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v,%v,%v\n", point.Timestamp.Format(time.RFC3339), point.PM25, point.PM10)
+	case "benchmark":
+		flags := flag.NewFlagSet("benchmark", flag.ExitOnError)
+		duration := flags.Duration("duration", time.Minute, "how long to benchmark for: half sending commands, half reading measurements")
+		flags.Parse(flag.Args()[1:])
+		if err := benchmark(sensor, *duration); err != nil {
+			log.Fatal(err)
+		}
+	case "settings":
+		switch flag.Arg(1) {
+		case "export":
+			err = exportSettings(sensor, os.Stdout)
+		case "import":
+			if flag.NArg() != 3 {
+				log.Exit("usage: settings import FILE")
+			}
+			err = importSettings(sensor, flag.Arg(2))
+		default:
+			log.Exitf("unknown settings command %q, want export or import", flag.Arg(1))
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "reset_defaults":
+		if err := resetDefaults(sensor, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 
 	default:
 		log.Errorf("flag.Args: %v", flag.Args())
 	}
 }
+
+// wakeAndRead wakes the sensor up, waits warmup, and takes a reading.
+// If sleep is set, it puts the sensor back to sleep.
+func wakeAndRead(sensor *sds011.Sensor, warmup time.Duration, sleep bool) (*sds011.Point, error) {
+	if sleep {
+		return sensor.MeasureOnce(context.Background(), warmup)
+	}
+	if err := sensor.Awake(); err != nil {
+		return nil, err
+	}
+	time.Sleep(warmup)
+	return sensor.Query()
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestWakeAndRead(t *testing.T) {
+	for _, sleep := range []bool{false, true} {
+		fake := sds011test.NewFake()
+		fake.Awake = false
+		var c commands
+		point, err := wakeAndRead(newSensor(t, fake, &c), time.Millisecond, sleep)
+		if err != nil {
+			t.Fatalf("sleep %v: %v", sleep, err)
+		}
+		if point.PM25 != 10 || point.PM10 != 20 {
+			t.Errorf("sleep %v: %v, want PM2.5 10 and PM10 20", sleep, point)
+		}
+		if fake.Awake == sleep {
+			t.Errorf("sleep %v: left awake %v", sleep, fake.Awake)
+		}
+		if want := []string{"Awake", "Query"}; !sleep && !reflect.DeepEqual([]string(c), want) {
+			t.Errorf("commands %v, want %v", c, want)
+		}
+	}
+}
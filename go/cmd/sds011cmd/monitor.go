@@ -0,0 +1,121 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// commandNames are the names of the commands, as they're printed.
+var commandNames = map[wire.Command]string{
+	wire.ReportMode: "report_mode",
+	wire.Query:      "query",
+	wire.DeviceID:   "device_id",
+	wire.WorkState:  "work_state",
+	wire.Firmware:   "firmware",
+	wire.Cycle:      "cycle",
+}
+
+func commandName(cmd wire.Command) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("command %d", cmd)
+}
+
+// monitor prints every frame that comes over port as hex, with what it
+// decodes to, and bytes between frames as noise, until the port is
+// closed. If query is set, it sends a query request this often, for a
+// sensor in query mode to have something to say; the requests are
+// printed as well.
+func monitor(port io.ReadWriter, w io.Writer, query time.Duration) error {
+	if query > 0 {
+		var b [wire.RequestSize]byte
+		req := wire.NewRequest(wire.Query, wire.Get, 0)
+		req.Encode(&b)
+		go func() {
+			for ; ; time.Sleep(query) {
+				printFrame(w, '>', b[:], "request: query")
+				if _, err := port.Write(b[:]); err != nil {
+					log.Errorf("sending a query: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	scanner := bufio.NewScanner(port)
+	scanner.Split(splitNoise)
+	for scanner.Scan() {
+		b := scanner.Bytes()
+		printFrame(w, '<', b, describeResponse(b))
+	}
+	return scanner.Err()
+}
+
+// splitNoise is like wire.SplitResponses, but returns what's skipped
+// between frames as tokens of its own.
+func splitNoise(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = wire.SplitResponses(data, atEOF)
+	if skipped := advance - len(token); skipped > 0 {
+		return skipped, data[:skipped], nil
+	}
+	return advance, token, err
+}
+
+// describeResponse says what b, which is a frame or noise, is.
+func describeResponse(b []byte) string {
+	var resp wire.Response
+	var checksum string
+	switch err := wire.DecodeResponse(b, &resp); err {
+	case nil:
+		checksum = "checksum ok"
+	case wire.ErrChecksum:
+		checksum = "checksum BAD"
+	default:
+		return "noise"
+	}
+	id := resp.DeviceID()
+	var what string
+	switch {
+	case resp.IsMeasurement():
+		what = fmt.Sprintf("measurement: pm25=%v pm10=%v", resp.PM25(), resp.PM10())
+		if resp.HasPM1() {
+			what += fmt.Sprintf(" pm1=%v", resp.PM1())
+		}
+	case resp.IsReply():
+		what = fmt.Sprintf("reply: %v % x", commandName(resp.ReplyTo()), resp.Data[1:4])
+	default:
+		what = fmt.Sprintf("unknown kind %02x", resp.Kind)
+	}
+	return fmt.Sprintf("%v id=%02x%02x %v", what, id[0], id[1], checksum)
+}
+
+// printFrame prints b, which went in direction dir ('<' from the
+// sensor, '>' to it), and what it is.
+func printFrame(w io.Writer, dir byte, b []byte, what string) {
+	hex := fmt.Sprintf("% x", b)
+	// Requests, the longest frames, are 56 characters.
+	if pad := 56 - len(hex); pad > 0 {
+		hex += strings.Repeat(" ", pad)
+	}
+	fmt.Fprintf(w, "%v %c %v  %v\n", time.Now().Format("15:04:05.000"), dir, hex, what)
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// settings are the settings of a sensor, as "settings export" writes
+// them and "settings import" applies them.
+type settings struct {
+	// DeviceID is the ID of the unit. It's applied only if it
+	// differs, as the ID of a replacement has to be changed to match
+	// configs binding to the old one.
+	DeviceID string `json:"device_id,omitempty"`
+	// ReportMode is "active" or "query".
+	ReportMode string `json:"report_mode"`
+	// Cycle is the working period in minutes, or nil if the firmware
+	// doesn't support it. A file without it leaves the working period
+	// alone.
+	Cycle *uint8 `json:"cycle,omitempty"`
+	// Firmware is only there for reference, and not applied.
+	Firmware string `json:"firmware,omitempty"`
+}
+
+// readSettings reads the settings of the sensor, which has to be
+// awake.
+func readSettings(sensor *sds011.Sensor) (*settings, error) {
+	var s settings
+	var err error
+	if s.Firmware, err = sensor.Firmware(); err != nil {
+		return nil, err
+	}
+	if s.DeviceID, err = sensor.DeviceID(); err != nil {
+		return nil, err
+	}
+	mode, err := sensor.ReportMode()
+	if err != nil {
+		return nil, err
+	}
+	s.ReportMode = mode.String()
+	switch cycle, err := sensor.Cycle(); {
+	case err == nil:
+		s.Cycle = &cycle
+	case !errors.Is(err, sds011.ErrUnsupported):
+		return nil, err
+	}
+	return &s, nil
+}
+
+// wake wakes the sensor up if it's asleep, as asleep it answers
+// nothing else, and returns whether it was awake.
+func wake(sensor *sds011.Sensor) (awake bool, err error) {
+	if awake, err = sensor.IsAwake(); err != nil || awake {
+		return awake, err
+	}
+	if err := sensor.Awake(); err != nil {
+		return false, fmt.Errorf("waking up: %v", err)
+	}
+	return false, nil
+}
+
+// sleepAgain puts the sensor back to sleep if it wasn't awake, setting
+// *err if it fails and *err is nil.
+func sleepAgain(sensor *sds011.Sensor, awake bool, err *error) {
+	if awake {
+		return
+	}
+	if sleepErr := sensor.Sleep(); sleepErr != nil && *err == nil {
+		*err = fmt.Errorf("putting the sensor back to sleep: %v", sleepErr)
+	}
+}
+
+// exportSettings writes the settings of the sensor to w as JSON. A
+// sensor that's asleep is woken up for it, and put back to sleep.
+func exportSettings(sensor *sds011.Sensor, w io.Writer) (err error) {
+	awake, err := wake(sensor)
+	if err != nil {
+		return err
+	}
+	defer sleepAgain(sensor, awake, &err)
+	s, err := readSettings(sensor)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// importSettings applies the settings in the JSON file at path ("-"
+// meaning standard input) to the sensor. Like exportSettings, it wakes
+// up a sensor that's asleep, and puts it back to sleep.
+func importSettings(sensor *sds011.Sensor, path string) (err error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	var s settings
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	if _, err := sds011.ParseReportMode(s.ReportMode); err != nil {
+		return fmt.Errorf("%v: report_mode: %v", path, err)
+	}
+	awake, err := wake(sensor)
+	if err != nil {
+		return err
+	}
+	defer sleepAgain(sensor, awake, &err)
+
+	if s.DeviceID != "" {
+		id, err := sensor.DeviceID()
+		if err != nil {
+			return err
+		}
+		if id != s.DeviceID {
+			if err := sensor.SetDeviceID(s.DeviceID); err != nil {
+				return fmt.Errorf("setting the device ID: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "device ID changed from %v to %v\n", id, s.DeviceID)
+		}
+	}
+	if s.Cycle != nil {
+		if err := sensor.SetCycle(*s.Cycle); err != nil {
+			return fmt.Errorf("setting the working period: %v", err)
+		}
+	}
+	mode, _ := sds011.ParseReportMode(s.ReportMode)
+	return sensor.SetReportMode(mode)
+}
+
+// resetDefaults puts the sensor back the way it comes from the
+// factory, awake, in active mode, and measuring continuously (a
+// working period of 0), writing its settings before and after to w.
+// It's for sensors left asleep, in query mode or in cycle mode by some
+// other program.
+func resetDefaults(sensor *sds011.Sensor, w io.Writer) error {
+	awake, err := wake(sensor)
+	if err != nil {
+		return err
+	}
+	before, err := readSettings(sensor)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "before: awake=%v %v\n", awake, before)
+	if err := sensor.SetCycle(0); err != nil && !errors.Is(err, sds011.ErrUnsupported) {
+		return fmt.Errorf("setting the working period: %v", err)
+	}
+	if err := sensor.SetReportMode(sds011.ActiveMode); err != nil {
+		return fmt.Errorf("setting the report mode: %v", err)
+	}
+	if awake, err = sensor.IsAwake(); err != nil {
+		return err
+	}
+	after, err := readSettings(sensor)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "after: awake=%v %v\n", awake, after)
+	return nil
+}
+
+// String returns the settings as key=value pairs.
+func (s *settings) String() string {
+	cycle := "unsupported"
+	if s.Cycle != nil {
+		cycle = fmt.Sprint(*s.Cycle)
+	}
+	return fmt.Sprintf("report_mode=%v cycle=%v device_id=%v firmware=%v", s.ReportMode, cycle, s.DeviceID, s.Firmware)
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+// commands records the commands a sensor executes.
+type commands []string
+
+func (c *commands) ObserveCommand(command string, start time.Time, duration time.Duration, err error) {
+	*c = append(*c, command)
+}
+
+// newSensor returns a sensor talking to fake, which records its
+// commands in c.
+func newSensor(t *testing.T, fake *sds011test.Fake, c *commands) *sds011.Sensor {
+	t.Helper()
+	// A fake that doesn't answer fails the test, instead of hanging
+	// it.
+	fake.ReadTimeout = 5 * time.Second
+	sensor := sds011.NewSensor(fake)
+	sensor.SetObserver(c)
+	t.Cleanup(sensor.Close)
+	return sensor
+}
+
+// settingsFile writes a settings file with content, and returns its
+// path.
+func settingsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sensor.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExportImport(t *testing.T) {
+	old := sds011test.NewFake()
+	old.ID, old.Cycle = [2]byte{0xBE, 0xEF}, 5
+	var buf bytes.Buffer
+	if err := exportSettings(newSensor(t, old, new(commands)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "device_id": "beef",
+  "report_mode": "query",
+  "cycle": 5,
+  "firmware": "18-11-16"
+}
+`
+	if buf.String() != want {
+		t.Errorf("exportSettings: %s, want %s", buf.String(), want)
+	}
+
+	// The replacement is asleep, in active mode.
+	replacement := sds011test.NewFake()
+	replacement.Awake, replacement.Active = false, true
+	if err := importSettings(newSensor(t, replacement, new(commands)), settingsFile(t, buf.String())); err != nil {
+		t.Fatal(err)
+	}
+	if replacement.ID != old.ID || replacement.Active || replacement.Cycle != 5 {
+		t.Errorf("imported: ID %x, active %v, cycle %v; want beef, query mode and 5", replacement.ID, replacement.Active, replacement.Cycle)
+	}
+	if replacement.Awake {
+		t.Error("the replacement was left awake")
+	}
+
+	// Imported again, the ID is left alone.
+	var c commands
+	if err := importSettings(newSensor(t, replacement, &c), settingsFile(t, buf.String())); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range c {
+		if cmd == "SetDeviceID" {
+			t.Errorf("%v: the device ID was set, though it was the same", c)
+		}
+	}
+}
+
+func TestExportAsleep(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Awake = false
+	var buf bytes.Buffer
+	if err := exportSettings(newSensor(t, fake, new(commands)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"report_mode": "query"`) {
+		t.Errorf("exportSettings: %s, want the report mode", buf.String())
+	}
+	if fake.Awake {
+		t.Error("the sensor was left awake")
+	}
+}
+
+func TestImport(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		// err is what the error should contain, if there's one;
+		// then the sensor shouldn't be sent anything.
+		err string
+		// active and cycle are what the sensor should be left with.
+		active bool
+		cycle  byte
+	}{
+		{"every setting", `{"report_mode": "active", "cycle": 10}`, "", true, 10},
+		{"no cycle", `{"report_mode": "active"}`, "", true, 3},
+		{"unknown field", `{"report_mode": "active", "cylce": 10}`, `unknown field "cylce"`, false, 3},
+		{"bad report mode", `{"report_mode": "passive", "cycle": 10}`, `report_mode: bad report mode "passive"`, false, 3},
+		{"no report mode", `{"cycle": 10}`, `report_mode: bad report mode ""`, false, 3},
+		{"not JSON", `report_mode=active`, "invalid character", false, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := sds011test.NewFake()
+			fake.Cycle = 3
+			var c commands
+			err := importSettings(newSensor(t, fake, &c), settingsFile(t, tc.content))
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("importSettings: %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("importSettings: %v, want an error with %q", err, tc.err)
+			case tc.err != "" && len(c) > 0:
+				t.Errorf("importSettings: %v, and the sensor was sent %v", err, c)
+			}
+			if fake.Active != tc.active || fake.Cycle != tc.cycle {
+				t.Errorf("active %v, cycle %v; want %v and %v", fake.Active, fake.Cycle, tc.active, tc.cycle)
+			}
+		})
+	}
+}
+
+func TestImportFailsAsleep(t *testing.T) {
+	// The device ID is only found to be bad once the sensor is woken
+	// up to compare it; it's put back to sleep all the same.
+	fake := sds011test.NewFake()
+	fake.Awake = false
+	err := importSettings(newSensor(t, fake, new(commands)), settingsFile(t, `{"device_id": "nope", "report_mode": "active"}`))
+	if err == nil || !strings.Contains(err.Error(), `bad device ID "nope"`) {
+		t.Errorf("importSettings: %v, want a bad device ID", err)
+	}
+	if fake.Awake || fake.Active {
+		t.Errorf("awake %v, active %v; want it asleep, in query mode still", fake.Awake, fake.Active)
+	}
+}
+
+func TestImportMissingFile(t *testing.T) {
+	var c commands
+	if err := importSettings(newSensor(t, sds011test.NewFake(), &c), filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("importSettings of a missing file: %v, want it not to exist", err)
+	}
+	if len(c) > 0 {
+		t.Errorf("the sensor was sent %v", c)
+	}
+}
+
+func TestResetDefaults(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Awake, fake.Cycle = false, 5
+	var buf bytes.Buffer
+	if err := resetDefaults(newSensor(t, fake, new(commands)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "before: awake=false report_mode=query cycle=5 device_id=a160 firmware=18-11-16\n" +
+		"after: awake=true report_mode=active cycle=0 device_id=a160 firmware=18-11-16\n"
+	if buf.String() != want {
+		t.Errorf("resetDefaults: %q, want %q", buf.String(), want)
+	}
+	if !fake.Awake || !fake.Active || fake.Cycle != 0 {
+		t.Errorf("awake %v, active %v, cycle %v; want awake, active and 0", fake.Awake, fake.Active, fake.Cycle)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// soakOptions are what the soak test does in every cycle.
+type soakOptions struct {
+	// Sleep is how long the sensor sleeps, and Warmup how long it's
+	// awake before it's queried.
+	Sleep, Warmup time.Duration
+	// Queries is how many queries, a second apart, it gets.
+	Queries int
+	// Period is how often a line of the report is written.
+	Period time.Duration
+	// MaxLoss is the fraction of lost replies, or of bad frames, above
+	// which the sensor doesn't pass.
+	MaxLoss float64
+}
+
+// soakStats are the counts of a stretch of the soak test.
+type soakStats struct {
+	start  time.Time
+	cycles int
+	// latencies, lost and failed are by command: sleep, wake and
+	// query.
+	latencies map[string][]time.Duration
+	lost      map[string]int
+	failed    map[string]int
+	diag      sds011.Diagnostics
+}
+
+func newSoakStats(start time.Time) *soakStats {
+	return &soakStats{
+		start:     start,
+		latencies: make(map[string][]time.Duration),
+		lost:      make(map[string]int),
+		failed:    make(map[string]int),
+	}
+}
+
+// soakCommands are the commands the soak test sends, in order.
+var soakCommands = []string{"sleep", "wake", "query"}
+
+// record counts a command that took d, and ended with err.
+func (s *soakStats) record(cmd string, d time.Duration, err error) {
+	switch {
+	case err == nil:
+		s.latencies[cmd] = append(s.latencies[cmd], d)
+	case errors.Is(err, sds011.ErrNoReply), errors.Is(err, os.ErrDeadlineExceeded):
+		s.lost[cmd]++
+	default:
+		s.failed[cmd]++
+	}
+}
+
+// add adds the counts of o to s.
+func (s *soakStats) add(o *soakStats) {
+	s.cycles += o.cycles
+	for _, cmd := range soakCommands {
+		s.latencies[cmd] = append(s.latencies[cmd], o.latencies[cmd]...)
+		s.lost[cmd] += o.lost[cmd]
+		s.failed[cmd] += o.failed[cmd]
+	}
+	s.diag.Frames += o.diag.Frames
+	s.diag.Discarded += o.diag.Discarded
+	s.diag.Resyncs += o.diag.Resyncs
+	s.diag.SkippedBytes += o.diag.SkippedBytes
+	s.diag.Checksum += o.diag.Checksum
+}
+
+// commands returns the number of commands sent, and of those whose
+// replies were lost.
+func (s *soakStats) commands() (sent, lost int) {
+	for _, cmd := range soakCommands {
+		sent += len(s.latencies[cmd]) + s.lost[cmd] + s.failed[cmd]
+		lost += s.lost[cmd]
+	}
+	return sent, lost
+}
+
+// mean returns the mean latency of cmd, or 0 if it never got a reply.
+func (s *soakStats) mean(cmd string) time.Duration {
+	ds := s.latencies[cmd]
+	if len(ds) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	return (sum / time.Duration(len(ds))).Round(time.Microsecond)
+}
+
+// soak puts the sensor through cycles of sleeping, waking up and being
+// queried until ctx is done or duration has passed, to qualify it and
+// its adapter before they're deployed: it counts the replies that get
+// lost, the commands that fail, and the bad frames, and follows how
+// the latencies drift over time. It writes a line of the report to w
+// every period, and a summary at the end, and returns whether the
+// sensor passed.
+//
+// The sensor is put in query mode for the duration, and then back in
+// the mode it was in.
+func soak(ctx context.Context, sensor *sds011.Sensor, w io.Writer, duration time.Duration, o soakOptions) (bool, error) {
+	mode, err := sensor.ReportMode()
+	if err != nil {
+		return false, err
+	}
+	if err := sensor.SetReportMode(sds011.QueryMode); err != nil {
+		return false, err
+	}
+	defer func() {
+		// The replies may get lost, as they did during the test.
+		if err := retry(sensor.Awake); err != nil {
+			fmt.Fprintf(w, "waking up the sensor: %v\n", err)
+		}
+		if mode == sds011.ActiveMode {
+			if err := retry(func() error { return sensor.SetReportMode(sds011.ActiveMode) }); err != nil {
+				fmt.Fprintf(w, "restoring active mode: %v\n", err)
+			}
+		}
+	}()
+
+	// The lines are written as they come, so the columns have fixed
+	// widths. The latencies are the means of every command.
+	const columns = "%9v %7v %9v %6v %7v %7v %9v %8v %10v %10v %10v\n"
+	fmt.Fprintf(w, columns, "elapsed", "cycles", "commands", "lost", "failed", "frames", "checksums", "resyncs", "sleep", "wake", "query")
+	line := func(s *soakStats, label string) {
+		sent, lost := s.commands()
+		failed := s.failed["sleep"] + s.failed["wake"] + s.failed["query"]
+		fmt.Fprintf(w, columns, label, s.cycles, sent, lost, failed, s.diag.Frames, s.diag.Checksum, s.diag.Resyncs,
+			s.mean("sleep"), s.mean("wake"), s.mean("query"))
+	}
+
+	start := time.Now()
+	total := newSoakStats(start)
+	var periods []*soakStats
+	period := newSoakStats(start)
+	last := sensor.Diagnostics()
+	endPeriod := func(now time.Time) {
+		d := sensor.Diagnostics()
+		period.diag, last = d.Sub(last), d
+		line(period, now.Sub(start).Round(time.Second).String())
+		total.add(period)
+		periods = append(periods, period)
+		period = newSoakStats(now)
+	}
+
+	run := func(s *soakStats, cmd string, f func() error) {
+		t := time.Now()
+		err := f()
+		s.record(cmd, time.Since(t), err)
+	}
+	end := start.Add(duration)
+	for ctx.Err() == nil && time.Now().Before(end) {
+		run(period, "sleep", sensor.Sleep)
+		if !sleepCtx(ctx, o.Sleep) {
+			break
+		}
+		run(period, "wake", sensor.Awake)
+		if !sleepCtx(ctx, o.Warmup) {
+			break
+		}
+		for i := 0; i < o.Queries; i++ {
+			if i > 0 && !sleepCtx(ctx, time.Second) {
+				break
+			}
+			run(period, "query", func() error {
+				_, err := sensor.Query()
+				return err
+			})
+		}
+		period.cycles++
+		if now := time.Now(); now.Sub(period.start) >= o.Period {
+			endPeriod(now)
+		}
+	}
+	if period.cycles > 0 || len(periods) == 0 {
+		endPeriod(time.Now())
+	}
+
+	fmt.Fprintln(w)
+	line(total, "total")
+	fmt.Fprintln(w)
+	sw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, cmd := range soakCommands {
+		fmt.Fprintf(sw, "%v latency\t%v\n", cmd, summary(total.latencies[cmd]))
+	}
+	if len(periods) > 1 {
+		first, final := periods[0], periods[len(periods)-1]
+		fmt.Fprintf(sw, "query latency drift\t%v (mean of the last period, less that of the first)\n", final.mean("query")-first.mean("query"))
+	}
+	sent, lost := total.commands()
+	passed := true
+	if sent > 0 && float64(lost) > o.MaxLoss*float64(sent) {
+		fmt.Fprintf(sw, "FAIL\t%d of %d replies lost\n", lost, sent)
+		passed = false
+	}
+	if bad := total.diag.Checksum + total.diag.Resyncs; total.diag.Frames > 0 && float64(bad) > o.MaxLoss*float64(total.diag.Frames) {
+		fmt.Fprintf(sw, "FAIL\t%d of %d frames bad\n", bad, total.diag.Frames)
+		passed = false
+	}
+	if len(total.latencies["query"]) == 0 {
+		fmt.Fprintf(sw, "FAIL\tno queries answered\n")
+		passed = false
+	}
+	if passed {
+		fmt.Fprintf(sw, "PASS\n")
+	}
+	return passed, sw.Flush()
+}
+
+// retry calls f until it succeeds, up to 3 times, and returns its last
+// error.
+func retry(f func() error) (err error) {
+	for i := 0; i < 3; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// sleepCtx waits d, and returns false if ctx was done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
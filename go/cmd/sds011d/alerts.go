@@ -0,0 +1,180 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/alert"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// maxRecentAlerts is how many events are kept for the API, and queued
+// for the notifiers.
+const maxRecentAlerts = 100
+
+// alerts is a sink that evaluates the alert rules over the readings of
+// the sensors. It keeps the recent events for the API, and sends them
+// to the notifiers.
+type alerts struct {
+	notifiers []notifier
+	// queue holds the events to be sent to the notifiers.
+	queue chan alert.Event
+
+	mu     sync.Mutex
+	engine *alert.Engine
+	// rules is how many rules the engine has.
+	rules int
+	// recent are the latest events, oldest first.
+	recent []alert.Event
+}
+
+func newAlerts(config AlertsConfig) (*alerts, error) {
+	rules, notifiers, err := alertsOf(config)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := alert.NewEngine(rules...)
+	if err != nil {
+		return nil, err
+	}
+	return &alerts{engine: engine, rules: len(rules), notifiers: notifiers, queue: make(chan alert.Event, maxRecentAlerts)}, nil
+}
+
+// alertsOf returns the rules and notifiers config describes.
+func alertsOf(config AlertsConfig) ([]alert.Rule, []notifier, error) {
+	var rules []alert.Rule
+	for _, rc := range config.Rules {
+		rules = append(rules, alert.Rule{
+			Name:       rc.Name,
+			Metric:     rc.Metric,
+			Threshold:  rc.Threshold,
+			Sustain:    rc.Sustain.Duration,
+			Hysteresis: rc.Hysteresis,
+			Severity:   rc.Severity,
+			Sensors:    rc.Sensors,
+		})
+	}
+	// The engine checks the rules.
+	if _, err := alert.NewEngine(rules...); err != nil {
+		return nil, nil, err
+	}
+	var notifiers []notifier
+	for _, nc := range config.Notifiers {
+		n, err := newNotifier(nc)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return rules, notifiers, nil
+}
+
+// set replaces the rules and notifiers, as alertsOf returned them. The
+// alerts that are firing go on firing (see alert.Engine.SetRules).
+func (x *alerts) set(rules []alert.Rule, notifiers []notifier) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.engine.SetRules(rules...); err != nil {
+		// alertsOf checked them.
+		log.Errorf("alert: %v", err)
+		return
+	}
+	x.rules, x.notifiers = len(rules), notifiers
+}
+
+// configured returns whether there are any rules.
+func (x *alerts) configured() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.rules > 0
+}
+
+// Write implements sink.Sink.
+func (x *alerts) Write(r *sink.Reading) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, e := range x.engine.Add(r.Sensor, *r.Point) {
+		log.Infof("alert: %v", &e)
+		x.recent = append(x.recent, e)
+		if len(x.recent) > maxRecentAlerts {
+			x.recent = x.recent[len(x.recent)-maxRecentAlerts:]
+		}
+		if len(x.notifiers) == 0 {
+			continue
+		}
+		select {
+		case x.queue <- e:
+		default:
+			log.Errorf("alert: queue full, not sending %v", &e)
+		}
+	}
+	return nil
+}
+
+// alertsJSON is what the API returns about the alerts of a sensor.
+type alertsJSON struct {
+	// Active are the Start events of the alerts that are firing.
+	Active []alert.Event `json:"active"`
+	// Recent are the latest events, oldest first.
+	Recent []alert.Event `json:"recent"`
+}
+
+// Get returns the alerts of sensor.
+func (x *alerts) Get(sensor string) *alertsJSON {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	j := &alertsJSON{Active: x.engine.Active(sensor), Recent: []alert.Event{}}
+	if j.Active == nil {
+		j.Active = []alert.Event{}
+	}
+	for _, e := range x.recent {
+		if e.Sensor == sensor {
+			j.Recent = append(j.Recent, e)
+		}
+	}
+	return j
+}
+
+// run sends the events to the notifiers until ctx is done.
+func (x *alerts) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-x.queue:
+			x.mu.Lock()
+			notifiers := x.notifiers
+			x.mu.Unlock()
+			for _, n := range notifiers {
+				if err := n.notify(ctx, &e); err != nil {
+					log.Errorf("alert: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Flush implements sink.Sink.
+func (x *alerts) Flush() error {
+	return nil
+}
+
+// Close implements sink.Sink.
+func (x *alerts) Close() error {
+	return nil
+}
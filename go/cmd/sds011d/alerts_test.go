@@ -0,0 +1,95 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/alert"
+)
+
+// notified is a notifier that passes the events on to a channel.
+type notified chan alert.Event
+
+func (n notified) notify(ctx context.Context, e *alert.Event) error {
+	n <- *e
+	return nil
+}
+
+func TestAlertsReload(t *testing.T) {
+	x, err := newAlerts(AlertsConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.configured() {
+		t.Error("configured without rules")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go x.run(ctx)
+
+	// Rules and notifiers can come with a reload.
+	rules, _, err := alertsOf(AlertsConfig{Rules: []AlertRuleConfig{{Name: "pm", Metric: "pm25", Threshold: 35}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := make(notified, 10)
+	x.set(rules, []notifier{n})
+	if !x.configured() {
+		t.Error("not configured after setting rules")
+	}
+	x.Write(reading("kitchen", 0, 40, 40))
+	select {
+	case e := <-n:
+		if e.Kind != alert.Start || e.Rule != "pm" || e.Sensor != "kitchen" {
+			t.Errorf("notified of %v", &e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not notified")
+	}
+
+	// A new threshold leaves the alert firing, and decides when it
+	// resolves.
+	rules, _, err = alertsOf(AlertsConfig{Rules: []AlertRuleConfig{{Name: "pm", Metric: "pm25", Threshold: 20}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.set(rules, []notifier{n})
+	x.Write(reading("kitchen", 1, 30, 30))
+	if j := x.Get("kitchen"); len(j.Active) != 1 || len(j.Recent) != 1 {
+		t.Errorf("after the reload: %d active, %d recent, want 1 and 1", len(j.Active), len(j.Recent))
+	}
+	x.Write(reading("kitchen", 2, 10, 10))
+	if e := <-n; e.Kind != alert.Resolve || !e.Started.Equal(t0) {
+		t.Errorf("notified of %v, want it resolved", &e)
+	}
+	if j := x.Get("kitchen"); len(j.Active) != 0 || len(j.Recent) != 2 {
+		t.Errorf("after resolving: %d active, %d recent, want 0 and 2", len(j.Active), len(j.Recent))
+	}
+	if j := x.Get("garden"); j.Active == nil || j.Recent == nil {
+		t.Error("nil events for a sensor without alerts")
+	}
+
+	// Without rules, there's nothing to alert about.
+	x.set(nil, nil)
+	if x.configured() {
+		t.Error("configured after dropping the rules")
+	}
+	if _, _, err := alertsOf(AlertsConfig{Rules: []AlertRuleConfig{{Name: "co2", Metric: "co2"}}}); err == nil {
+		t.Error("alertsOf with an unknown metric: no error")
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/ryszard/sds011/go/store"
+)
+
+// The HTTP API. All endpoints that concern a single sensor take a
+// "sensor" query parameter, which may be omitted if the daemon
+// manages only one sensor.
+//
+//	GET  /v1/sensors                      names of the sensors
+//	GET  /v1/measurements/latest          the latest measurement
+//	GET  /v1/measurements/averages        rolling averages over the
+//	                                      configured windows
+//	GET  /v1/measurements?from=...&to=...&resolution=...
+//	                                      averaged measurements from the
+//	                                      history; from and to are either
+//	                                      RFC3339 timestamps or durations
+//	                                      like 1h (meaning 1h ago), and
+//	                                      resolution is a duration like 5m
+//	                                      (since is an alias for from)
+//	GET  /v1/sensor                       id, firmware, mode, cycle, awake
+//	POST /v1/sensor/cycle {"minutes": 5}  set the working period
+//	POST /v1/sensor/mode {"mode": "query"}
+//	                                      set the report mode, "active"
+//	                                      or "query"
+//	POST /v1/sensor/sleep                 put the sensor to sleep; with
+//	                                      an interval, it's not measured
+//	                                      until it's woken up
+//	POST /v1/sensor/wake                  wake the sensor up
+//	GET  /v1/stream                       WebSocket pushing every new
+//	                                      measurement (of all sensors,
+//	                                      unless sensor is given)
+//	GET  /v1/events                       the same, as Server-Sent Events
+//	GET  /v1/exceedances                  ongoing and recent exceedances
+//	                                      of the air quality limits
+//	GET  /v1/alerts                       firing and recent alerts (see
+//	                                      alerts.go)
+//	GET  /v1/reports                      recent daily and weekly
+//	                                      reports (see reports.go)
+//	GET  /v1/health                       a heartbeat (see heartbeat.go)
+//	GET  /v1/chart.png?range=24h          a chart of the PM levels (see
+//	                                      chart.go)
+//	GET  /metrics                         Prometheus metrics (see package
+//	                                      exporter)
+//	GET  /                                a dashboard (see ui.go)
+//	     /grafana/...                     a Grafana JSON datasource (see
+//	                                      grafana.go)
+//
+// If the config has a control_token, the POST endpoints require it as
+// a bearer token ("Authorization: Bearer <token>"). Without one, they
+// are open to whoever passes auth, and disabled if there's no auth.
+//
+// Everything is JSON. Errors are returned as {"error": "..."} with an
+// appropriate status code.
+
+// httpError is an error that knows its HTTP status code.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+// apiHandler is an HTTP handler that returns a value to be sent as
+// JSON, or an error.
+type apiHandler func(r *http.Request) (interface{}, error)
+
+func (h apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v, err := h(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// writeError sends err as the response to r, with the status code of
+// an httpError, and 500 for anything else.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	var he *httpError
+	if errors.As(err, &he) {
+		code = he.code
+	}
+	log.V(1).Infof("%v %v: %v", r.Method, r.URL, err)
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("writing response: %v", err)
+	}
+}
+
+// method restricts h to requests with the given method.
+func method(m string, h apiHandler) apiHandler {
+	return func(r *http.Request) (interface{}, error) {
+		if r.Method != m {
+			return nil, &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method)}
+		}
+		return h(r)
+	}
+}
+
+// newAPI returns the handler serving the HTTP API of d.
+func newAPI(d *daemon) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/sensors", method("GET", d.handleSensors))
+	mux.Handle("/v1/measurements/latest", method("GET", d.handleLatest))
+	mux.Handle("/v1/measurements/averages", method("GET", d.handleAverages))
+	mux.Handle("/v1/measurements", method("GET", d.handleMeasurements))
+	mux.Handle("/v1/sensor", method("GET", d.handleSensor))
+	mux.Handle("/v1/sensor/cycle", method("POST", d.control(d.handleSetCycle)))
+	mux.Handle("/v1/sensor/mode", method("POST", d.control(d.handleSetMode)))
+	mux.Handle("/v1/sensor/sleep", method("POST", d.control(d.handleSleep)))
+	mux.Handle("/v1/sensor/wake", method("POST", d.control(d.handleWake)))
+	mux.HandleFunc("/v1/stream", d.handleStream)
+	mux.HandleFunc("/v1/events", d.handleEvents)
+	mux.Handle("/v1/exceedances", method("GET", d.handleExceedances))
+	mux.Handle("/v1/alerts", method("GET", d.handleAlerts))
+	mux.Handle("/v1/reports", method("GET", d.handleReports))
+	mux.Handle("/v1/health", method("GET", d.handleHealth))
+	mux.HandleFunc("/v1/chart.png", d.handleChart)
+	mux.HandleFunc("/v1/export", d.handleExport)
+	mux.Handle("/metrics", d.metrics)
+	mux.HandleFunc("/", d.handleUI)
+	d.grafanaAPI(mux)
+	return d.requireAuth(mux)
+}
+
+// control restricts h to requests authorized to control the sensors.
+func (d *daemon) control(h apiHandler) apiHandler {
+	return func(r *http.Request) (interface{}, error) {
+		if err := d.checkControl(bearerToken(r)); err == errControlDisabled {
+			return nil, &httpError{http.StatusForbidden, err}
+		} else if err != nil {
+			return nil, &httpError{http.StatusUnauthorized, err}
+		}
+		return h(r)
+	}
+}
+
+// sensorParam returns the name of the sensor a request is about.
+func (d *daemon) sensorParam(r *http.Request) (string, error) {
+	name := r.URL.Query().Get("sensor")
+	if name == "" {
+		if len(d.names) != 1 {
+			return "", badRequest("sensor parameter is required")
+		}
+		return d.names[0], nil
+	}
+	if _, ok := d.collectors[name]; !ok {
+		return "", notFound("unknown sensor %q", name)
+	}
+	return name, nil
+}
+
+func (d *daemon) handleExceedances(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if d.exceedances == nil {
+		return nil, notFound("no limits are configured")
+	}
+	return d.exceedances.Get(sensor), nil
+}
+
+func (d *daemon) handleAlerts(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if !d.alerts.configured() {
+		return nil, notFound("no alert rules are configured")
+	}
+	return d.alerts.Get(sensor), nil
+}
+
+func (d *daemon) handleReports(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if d.reports == nil {
+		return nil, notFound("no reports are configured")
+	}
+	return d.reports.Get(sensor), nil
+}
+
+func (d *daemon) handleHealth(r *http.Request) (interface{}, error) {
+	return d.heartbeat.beat(time.Now(), false), nil
+}
+
+func (d *daemon) handleSensors(r *http.Request) (interface{}, error) {
+	return d.names, nil
+}
+
+func (d *daemon) handleLatest(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	reading := d.hub.Latest(sensor)
+	if reading == nil {
+		return nil, notFound("no measurements from %q yet", sensor)
+	}
+	return reading, nil
+}
+
+func (d *daemon) handleAverages(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.averages.Get(sensor), nil
+}
+
+// parseSince parses a point in time given either as an RFC3339
+// timestamp, as a date, which means its local midnight, or as a
+// duration before now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// timeParam returns the value of the time parameter name, or the zero
+// time if it's absent.
+func timeParam(r *http.Request, name string) (time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := parseSince(s)
+	if err != nil {
+		return time.Time{}, badRequest("bad %v: %v", name, err)
+	}
+	return t, nil
+}
+
+func (d *daemon) handleMeasurements(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	from, err := timeParam(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() {
+		if from, err = timeParam(r, "since"); err != nil {
+			return nil, err
+		}
+	}
+	to, err := timeParam(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	var resolution time.Duration
+	if s := r.URL.Query().Get("resolution"); s != "" {
+		if resolution, err = time.ParseDuration(s); err != nil {
+			return nil, badRequest("bad resolution: %v", err)
+		}
+	}
+	return d.query(sensor, from, to, resolution)
+}
+
+// query returns the measurements of sensor between from and to,
+// averaged over resolution, from the store if there is one and from
+// the history otherwise.
+func (d *daemon) query(sensor string, from, to time.Time, resolution time.Duration) ([]*store.Bucket, error) {
+	if d.store != nil {
+		if resolution == 0 {
+			resolution = d.history.resolution
+		}
+		return d.store.Query(sensor, from, to, resolution)
+	}
+	return d.history.Query(sensor, from, to, resolution), nil
+}
+
+// sensorJSON is the JSON representation of a sensor's identity and
+// settings.
+type sensorJSON struct {
+	Name     string `json:"name"`
+	PortPath string `json:"port_path"`
+	// Device is the device the port path leads to, and ByID its
+	// stable link in /dev/serial/by-id, if it has one.
+	Device   string `json:"device,omitempty"`
+	ByID     string `json:"by_id,omitempty"`
+	DeviceID string `json:"device_id"`
+	Firmware string `json:"firmware"`
+	// Mode is "active" or "query".
+	Mode  string `json:"mode"`
+	Cycle uint8  `json:"cycle"`
+	Awake bool   `json:"awake"`
+}
+
+func (d *daemon) handleSensor(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.Info(sensor)
+	if err != nil {
+		return nil, err
+	}
+	mode := "query"
+	if info.Active {
+		mode = "active"
+	}
+	return &sensorJSON{
+		Name:     info.Sensor,
+		PortPath: info.PortPath,
+		Device:   info.Device,
+		ByID:     info.ByID,
+		DeviceID: info.DeviceID,
+		Firmware: info.Firmware,
+		Mode:     mode,
+		Cycle:    info.Cycle,
+		Awake:    info.Awake,
+	}, nil
+}
+
+func (d *daemon) handleSetCycle(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Minutes *int `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, badRequest("bad body: %v", err)
+	}
+	if body.Minutes == nil || *body.Minutes < 0 || *body.Minutes > 30 {
+		return nil, badRequest("minutes should be between 0 and 30")
+	}
+	if err := d.SetCycle(sensor, uint8(*body.Minutes)); err != nil {
+		return nil, err
+	}
+	return map[string]int{"minutes": *body.Minutes}, nil
+}
+
+func (d *daemon) handleSetMode(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, badRequest("bad body: %v", err)
+	}
+	if body.Mode != "active" && body.Mode != "query" {
+		return nil, badRequest(`mode should be "active" or "query"`)
+	}
+	if err := d.SetReportMode(sensor, body.Mode == "active"); err != nil {
+		return nil, err
+	}
+	return map[string]string{"mode": body.Mode}, nil
+}
+
+func (d *daemon) handleSleep(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Sleep(sensor); err != nil {
+		return nil, err
+	}
+	return map[string]bool{"awake": false}, nil
+}
+
+func (d *daemon) handleWake(r *http.Request) (interface{}, error) {
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Wake(sensor); err != nil {
+		return nil, err
+	}
+	return map[string]bool{"awake": true}, nil
+}
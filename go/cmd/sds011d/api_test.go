@@ -0,0 +1,256 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/remote"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// testDaemon returns a daemon with config, whose sensors are attached
+// to fakes, by name, and a server serving its API.
+func testDaemon(t *testing.T, config string) (*daemon, map[string]*sds011test.Fake, *httptest.Server) {
+	t.Helper()
+	c, err := parseConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDaemon(newHub(), newHistory(c.History.Retention.Duration, c.History.Resolution.Duration), nil, c)
+	if d.alerts, err = newAlerts(c.Alerts); err != nil {
+		t.Fatal(err)
+	}
+	fakes := make(map[string]*sds011test.Fake)
+	for _, sc := range c.Sensors {
+		fake := sds011test.NewFake()
+		col := &collector{config: sc, metrics: d.metrics, degradations: d.degradations, sensor: sds011.NewSensor(fake), portPath: sc.PortPath}
+		t.Cleanup(func() { col.sensor.Close() })
+		d.add(col)
+		fakes[sc.Name] = fake
+	}
+	server := httptest.NewServer(newAPI(d))
+	t.Cleanup(server.Close)
+	return d, fakes, server
+}
+
+// call makes a request to server, and returns the status code and the
+// body.
+func call(t *testing.T, server *httptest.Server, method, path, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+const twoSensors = `{
+	"sensors": [
+		{"name": "kitchen", "port_path": "/dev/ttyUSB0"},
+		{"name": "garden", "port_path": "/dev/ttyUSB1"}
+	],
+	"sinks": [{"type": "csv"}]
+}`
+
+func TestAPI(t *testing.T) {
+	d, _, server := testDaemon(t, twoSensors)
+	for i, pm := range []float64{10, 20, 30} {
+		r := &sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: pm, PM10: 2 * pm, Timestamp: t0.Add(time.Duration(i) * time.Minute)}}
+		d.hub.Write(r)
+		d.history.Write(r)
+	}
+	d.averages.Write(&sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 10, PM10: 20, Timestamp: time.Now()}})
+	const day = "from=2024-06-01T00:00:00Z&to=2024-06-02T00:00:00Z"
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		// body is what the body must contain.
+		body string
+	}{
+		{"GET", "/v1/sensors", 200, `["kitchen","garden"]`},
+		{"GET", "/v1/measurements/latest?sensor=kitchen", 200, `"pm25":30,"pm10":60`},
+		{"GET", "/v1/measurements/latest?sensor=garden", 404, `no measurements from \"garden\" yet`},
+		{"GET", "/v1/measurements/latest", 400, "sensor parameter is required"},
+		{"GET", "/v1/measurements/latest?sensor=hall", 404, `unknown sensor \"hall\"`},
+		{"POST", "/v1/measurements/latest?sensor=kitchen", 405, "method POST not allowed"},
+		{"GET", "/v1/measurements?sensor=kitchen&" + day, 200, `"pm25":10,"pm10":20,"samples":1`},
+		{"GET", "/v1/measurements?sensor=kitchen&resolution=1h&" + day, 200, `"pm25":20,"pm10":40,"samples":3`},
+		{"GET", "/v1/measurements?sensor=kitchen&from=yesterday", 400, "bad from"},
+		{"GET", "/v1/measurements?sensor=kitchen&resolution=often", 400, "bad resolution"},
+		{"GET", "/v1/measurements/averages?sensor=kitchen", 200, `"window":"1h"`},
+		{"GET", "/v1/sensor?sensor=kitchen", 200, `"name":"kitchen","port_path":"/dev/ttyUSB0","device_id":"a160","firmware":"18-11-16","mode":"query"`},
+		{"GET", "/v1/exceedances?sensor=kitchen", 404, "no limits are configured"},
+		{"GET", "/v1/alerts?sensor=kitchen", 404, "no alert rules are configured"},
+	} {
+		code, body := call(t, server, tc.method, tc.path, "", "")
+		if code != tc.code || !strings.Contains(body, tc.body) {
+			t.Errorf("%v %v: %v %s, want %v and %s", tc.method, tc.path, code, body, tc.code, tc.body)
+		}
+	}
+}
+
+func TestAPIErrorsAreJSON(t *testing.T) {
+	_, _, server := testDaemon(t, twoSensors)
+	_, body := call(t, server, "GET", "/v1/measurements/latest", "", "")
+	var v struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &v); err != nil || v.Error == "" {
+		t.Errorf("error body %s: %v", body, err)
+	}
+}
+
+func TestControl(t *testing.T) {
+	_, _, server := testDaemon(t, minimalConfig(`, "control_token": "secret"`))
+	for _, tc := range []struct {
+		path, token, body string
+		code              int
+		want              string
+	}{
+		{"/v1/sensor/cycle", "", `{"minutes": 5}`, 401, "unauthorized"},
+		{"/v1/sensor/cycle", "wrong", `{"minutes": 5}`, 401, "unauthorized"},
+		{"/v1/sensor/cycle", "secret", `{"minutes": 31}`, 400, "minutes should be between 0 and 30"},
+		{"/v1/sensor/cycle", "secret", `{}`, 400, "minutes should be between 0 and 30"},
+		{"/v1/sensor/cycle", "secret", `{"minutes": 5}`, 200, `{"minutes":5}`},
+		{"/v1/sensor/mode", "secret", `{"mode": "passive"}`, 400, `mode should be \"active\" or \"query\"`},
+		{"/v1/sensor/mode", "secret", `{"mode": "active"}`, 200, `{"mode":"active"}`},
+		{"/v1/sensor/sleep", "secret", ``, 200, `{"awake":false}`},
+		{"/v1/sensor/wake", "secret", ``, 200, `{"awake":true}`},
+	} {
+		code, body := call(t, server, "POST", tc.path, tc.token, tc.body)
+		if code != tc.code || !strings.Contains(body, tc.want) {
+			t.Errorf("POST %v %s with %q: %v %s, want %v and %s", tc.path, tc.body, tc.token, code, body, tc.code, tc.want)
+		}
+	}
+	// The settings took.
+	code, body := call(t, server, "GET", "/v1/sensor", "", "")
+	if want := `"mode":"active","cycle":5,"awake":true`; code != 200 || !strings.Contains(body, want) {
+		t.Errorf("GET /v1/sensor: %v %s, want 200 and %s", code, body, want)
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	d, _, _ := testDaemon(t, minimalConfig(""))
+	if _, err := d.Latest("hall"); !errors.Is(err, remote.ErrUnknownSensor) {
+		t.Errorf("Latest of an unknown sensor: %v, want %v", err, remote.ErrUnknownSensor)
+	}
+	if err := d.Sleep("hall"); !errors.Is(err, remote.ErrUnknownSensor) {
+		t.Errorf("Sleep of an unknown sensor: %v, want %v", err, remote.ErrUnknownSensor)
+	}
+	if err := d.SetCycle("kitchen", 31); !errors.Is(err, remote.ErrInvalidArgument) {
+		t.Errorf("SetCycle of 31 minutes: %v, want %v", err, remote.ErrInvalidArgument)
+	}
+}
+
+func TestControlDisabled(t *testing.T) {
+	// Without a control token or auth, nobody may control the
+	// sensors.
+	_, _, server := testDaemon(t, minimalConfig(""))
+	code, body := call(t, server, "POST", "/v1/sensor/sleep", "", "")
+	if code != 403 || !strings.Contains(body, "control is disabled") {
+		t.Errorf("POST /v1/sensor/sleep: %v %s, want 403", code, body)
+	}
+}
+
+func TestControlWhileReading(t *testing.T) {
+	d, _, server := testDaemon(t, minimalConfig(`, "control_token": "secret"`))
+	c := d.collectors["kitchen"]
+	// In query mode, the fake sends nothing, so every read waits for
+	// its timeout.
+	c.sensor.SetReadTimeout(200 * time.Millisecond)
+	stop := make(chan struct{})
+	defer close(stop)
+	paused := make(chan struct{})
+	go func() {
+		var p sds011.Point
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			next := func(sensor *sds011.Sensor, point *sds011.Point) error {
+				return c.next(context.Background(), sensor, point)
+			}
+			if c.read(next, &p) == errPaused {
+				close(paused)
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if code, body := call(t, server, "POST", "/v1/sensor/sleep", "secret", ""); code != 200 {
+		t.Fatalf("POST /v1/sensor/sleep: %v %s", code, body)
+	}
+	// It had to wait for the pending read at most.
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("putting the sensor to sleep took %v while it was read", took)
+	}
+	select {
+	case <-paused:
+	case <-time.After(5 * time.Second):
+		t.Error("the reads didn't stop once the sensor was asleep")
+	}
+}
+
+func TestSleepPeriodic(t *testing.T) {
+	d, _, server := testDaemon(t, `{
+		"sensors": [{"name": "kitchen", "port_path": "/dev/ttyUSB0", "interval": "10m", "warmup": "1ms"}],
+		"sinks": [{"type": "csv"}],
+		"control_token": "secret"
+	}`)
+	c := d.collectors["kitchen"]
+	if code, body := call(t, server, "POST", "/v1/sensor/sleep", "secret", ""); code != 200 {
+		t.Fatalf("POST /v1/sensor/sleep: %v %s", code, body)
+	}
+	// The next measurement doesn't wake it up.
+	if _, err := c.measure(context.Background()); err != errPaused {
+		t.Errorf("measure while asleep: %v, want %v", err, errPaused)
+	}
+	if awake, err := c.sensor.IsAwake(); err != nil || awake {
+		t.Errorf("IsAwake: %v, %v, want false", awake, err)
+	}
+	// Until it's woken up.
+	if code, body := call(t, server, "POST", "/v1/sensor/wake", "secret", ""); code != 200 {
+		t.Fatalf("POST /v1/sensor/wake: %v %s", code, body)
+	}
+	if _, err := c.measure(context.Background()); err != nil {
+		t.Errorf("measure once awake: %v", err)
+	}
+}
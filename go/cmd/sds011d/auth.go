@@ -0,0 +1,148 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	// errControlDisabled is returned by control calls when there's
+	// nothing to authenticate them with, as anyone who can reach the
+	// daemon could then change the settings of its sensors.
+	errControlDisabled = errors.New("control is disabled; set control_token or auth in the config")
+)
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Authenticate implements remote.Backend. If the daemon requires
+// authentication, it accepts the configured tokens and the control
+// token.
+func (d *daemon) Authenticate(token string) error {
+	if !d.auth.enabled() {
+		return nil
+	}
+	if d.controlToken != "" && equal(token, d.controlToken) {
+		return nil
+	}
+	for _, t := range d.auth.Tokens {
+		if equal(token, t) {
+			return nil
+		}
+	}
+	return errUnauthorized
+}
+
+// Authorize implements remote.Backend.
+func (d *daemon) Authorize(token string) error {
+	if err := d.Authenticate(token); err != nil {
+		return err
+	}
+	return d.checkControl(token)
+}
+
+// checkControl returns an error if token doesn't allow controlling the
+// sensors: it must be the control token, if there is one. Without one,
+// any caller that passed authentication may control them, and if
+// authentication isn't required either, none may.
+func (d *daemon) checkControl(token string) error {
+	switch {
+	case d.controlToken != "":
+		if equal(token, d.controlToken) {
+			return nil
+		}
+		return errUnauthorized
+	case d.auth.enabled():
+		return nil
+	}
+	return errControlDisabled
+}
+
+// controlEnabled returns whether the sensors may be controlled at all.
+func (d *daemon) controlEnabled() bool {
+	return d.controlToken != "" || d.auth.enabled()
+}
+
+// bearerToken returns the token a request was made with. Browsers
+// can't set headers on WebSocket and EventSource requests, so the
+// token may also be passed in the access_token parameter.
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// authenticated returns whether r is allowed to use the API.
+func (d *daemon) authenticated(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		want, known := d.auth.Users[user]
+		// Compare anyway, so unknown users take as long as known
+		// ones.
+		return equal(password, want) && known
+	}
+	return d.Authenticate(bearerToken(r)) == nil
+}
+
+// requireAuth wraps h so that it rejects requests that aren't
+// authenticated, if the daemon requires authentication.
+func (d *daemon) requireAuth(h http.Handler) http.Handler {
+	if !d.auth.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.authenticated(r) {
+			if len(d.auth.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="sds011d"`)
+			}
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": errUnauthorized.Error()})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// listen listens on address, with TLS if it's configured.
+func listen(address string, config TLSConfig) (net.Listener, error) {
+	tc, err := config.load()
+	if err != nil {
+		return nil, err
+	}
+	if tc == nil {
+		return net.Listen("tcp", address)
+	}
+	return tls.Listen("tcp", address, tc)
+}
+
+// load returns the TLS config of a server, or nil if TLS isn't
+// configured.
+func (config TLSConfig) load() (*tls.Config, error) {
+	if config.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
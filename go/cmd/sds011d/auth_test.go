@@ -0,0 +1,158 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	_, _, server := testDaemon(t, minimalConfig(`,
+		"control_token": "control",
+		"auth": {"tokens": ["reader"], "users": {"alice": "wonderland"}}`))
+	for _, tc := range []struct {
+		name string
+		// prepare sets the credentials of the request.
+		prepare func(r *http.Request)
+		code    int
+	}{
+		{"nothing", func(r *http.Request) {}, 401},
+		{"token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader") }, 200},
+		{"control token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer control") }, 200},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer writer") }, 401},
+		{"access_token", func(r *http.Request) { r.URL.RawQuery = "access_token=reader" }, 200},
+		{"user", func(r *http.Request) { r.SetBasicAuth("alice", "wonderland") }, 200},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("alice", "looking-glass") }, 401},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("bob", "") }, 401},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", server.URL+"/v1/sensors", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.prepare(req)
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.code {
+				t.Errorf("GET /v1/sensors: %v, want %v", resp.StatusCode, tc.code)
+			}
+			// Browsers should ask for a password.
+			if got := resp.Header.Get("WWW-Authenticate"); (resp.StatusCode == 401) != (got != "") {
+				t.Errorf("WWW-Authenticate: %q", got)
+			}
+		})
+	}
+}
+
+func TestAuthControl(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, token string
+		want                error
+	}{
+		{"control token", `, "control_token": "control", "auth": {"tokens": ["reader"]}`, "control", nil},
+		{"reader with a control token", `, "control_token": "control", "auth": {"tokens": ["reader"]}`, "reader", errUnauthorized},
+		{"reader without a control token", `, "auth": {"tokens": ["reader"]}`, "reader", nil},
+		{"stranger", `, "auth": {"tokens": ["reader"]}`, "stranger", errUnauthorized},
+		{"control token without auth", `, "control_token": "control"`, "control", nil},
+		{"no credentials", ``, "", errControlDisabled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, _, _ := testDaemon(t, minimalConfig(tc.config))
+			// Authorize is what the RPC server asks.
+			if err := d.Authorize(tc.token); err != tc.want {
+				t.Errorf("Authorize(%q): %v, want %v", tc.token, err, tc.want)
+			}
+		})
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key
+// to dir, and returns their paths and the certificate.
+func writeCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sds011d"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestListenTLS(t *testing.T) {
+	certFile, keyFile, cert := writeCert(t, t.TempDir())
+	l, err := listen("127.0.0.1:0", TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil {
+		t.Error("the connection isn't over TLS")
+	}
+
+	if _, err := listen("127.0.0.1:0", TLSConfig{CertFile: certFile, KeyFile: certFile}); err == nil {
+		t.Error("listen with a certificate for a key: no error")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+// maxHold is the longest a reading counts for in the averages, so that
+// the last reading before the sensor went quiet doesn't dominate them.
+const maxHold = 5 * time.Minute
+
+// averages is a sink that keeps rolling averages of the readings of
+// every sensor, over each of a set of windows, and reports them to the
+// metrics.
+type averages struct {
+	windows []time.Duration
+	longest time.Duration
+	metrics *exporter.Metrics
+
+	mu sync.Mutex
+	// readings are the readings of every sensor in the longest
+	// window.
+	readings map[string]*stats.Window
+}
+
+// rollingAverage is the average of the readings of a sensor over a
+// window, weighted by time (see package stats).
+type rollingAverage struct {
+	Window string  `json:"window"`
+	PM25   float64 `json:"pm25"`
+	PM10   float64 `json:"pm10"`
+	// Count is the number of readings averaged.
+	Count int `json:"count"`
+	// PM25Stats and PM10Stats describe the distribution of the
+	// readings.
+	PM25Stats stats.Summary `json:"pm25_stats"`
+	PM10Stats stats.Summary `json:"pm10_stats"`
+}
+
+func newAverages(windows []Duration, metrics *exporter.Metrics) *averages {
+	a := &averages{metrics: metrics, readings: make(map[string]*stats.Window)}
+	for _, w := range windows {
+		a.windows = append(a.windows, w.Duration)
+		if w.Duration > a.longest {
+			a.longest = w.Duration
+		}
+	}
+	return a
+}
+
+// windowName returns d formatted without zero units, like "1m" or
+// "1h30m".
+func windowName(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// Write implements sink.Sink.
+func (a *averages) Write(r *sink.Reading) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.readings[r.Sensor]
+	if !ok {
+		w = stats.NewWindow(a.longest)
+		w.MaxHold = maxHold
+		a.readings[r.Sensor] = w
+	}
+	w.Add(*r.Point)
+	for _, avg := range a.compute(r.Sensor, r.Timestamp) {
+		a.metrics.SetAverage(r.Sensor, avg.Window, avg.PM25, avg.PM10)
+	}
+	return nil
+}
+
+// compute returns the averages of sensor as of now. It must be called
+// with the lock held.
+func (a *averages) compute(sensor string, now time.Time) []rollingAverage {
+	avgs := make([]rollingAverage, 0, len(a.windows))
+	readings, ok := a.readings[sensor]
+	if !ok {
+		return avgs
+	}
+	for _, w := range a.windows {
+		s := readings.Stats(now, w)
+		if s.Count == 0 {
+			continue
+		}
+		avgs = append(avgs, rollingAverage{
+			Window:    windowName(w),
+			PM25:      s.PM25.Mean,
+			PM10:      s.PM10.Mean,
+			Count:     s.Count,
+			PM25Stats: s.PM25,
+			PM10Stats: s.PM10,
+		})
+	}
+	return avgs
+}
+
+// Get returns the averages of sensor as of now. Windows without any
+// readings are left out.
+func (a *averages) Get(sensor string) []rollingAverage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.compute(sensor, time.Now())
+}
+
+// Flush implements sink.Sink.
+func (a *averages) Flush() error {
+	return nil
+}
+
+// Close implements sink.Sink.
+func (a *averages) Close() error {
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/chart"
+	"github.com/ryszard/sds011/go/store"
+)
+
+const (
+	defaultChartRange  = 24 * time.Hour
+	defaultChartWidth  = 800
+	defaultChartHeight = 300
+	maxChartSize       = 4000
+)
+
+// chartResolutions are what the measurements of a chart are averaged
+// over: the shortest that gives no more than a point every few
+// pixels.
+var chartResolutions = []time.Duration{
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// handleChart serves a PNG chart of the PM levels of a sensor over
+// the last range (24h by default), width by height pixels (800 by 300
+// by default), from the store if there is one and from the history
+// otherwise.
+func (d *daemon) handleChart(w http.ResponseWriter, r *http.Request) {
+	if err := d.chart(w, r); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (d *daemon) chart(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method)}
+	}
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return err
+	}
+	span := defaultChartRange
+	if s := r.URL.Query().Get("range"); s != "" {
+		if span, err = time.ParseDuration(s); err != nil || span <= 0 {
+			return badRequest("bad range %q", s)
+		}
+	}
+	width, height := defaultChartWidth, defaultChartHeight
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"width", &width}, {"height", &height}} {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		if *p.v, err = strconv.Atoi(s); err != nil || *p.v <= 0 || *p.v > maxChartSize {
+			return badRequest("bad %v %q, want up to %d", p.name, s, maxChartSize)
+		}
+	}
+
+	resolution := chartResolutions[len(chartResolutions)-1]
+	for _, res := range chartResolutions {
+		if span/res <= time.Duration(width/3) {
+			resolution = res
+			break
+		}
+	}
+	to := time.Now()
+	from := to.Add(-span)
+	buckets, err := d.query(sensor, from, to, resolution)
+	if err != nil {
+		return err
+	}
+	pm25 := chart.Series{Name: "PM2.5", Color: chart.PM25Color}
+	pm10 := chart.Series{Name: "PM10", Color: chart.PM10Color}
+	for _, b := range buckets {
+		n := float64(b.Count)
+		pm25.Points = append(pm25.Points, chart.Point{Time: b.Start, Value: b.SumPM25 / n})
+		pm10.Points = append(pm10.Points, chart.Point{Time: b.Start, Value: b.SumPM10 / n})
+	}
+	c := &chart.TimeSeries{
+		Title:  fmt.Sprintf("%v, last %v", sensor, shortDuration(span)),
+		Unit:   "µg/m³",
+		Series: []chart.Series{pm25, pm10},
+		From:   from,
+		To:     to,
+		MaxGap: 3 * medianInterval(buckets),
+	}
+	var buf bytes.Buffer
+	if err := c.PNG(&buf, width, height); err != nil {
+		return badRequest("%v", err)
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// shortDuration formats d without trailing zero units, like 24h
+// rather than 24h0m0s.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// medianInterval returns the median time between buckets, which may be
+// longer than the resolution they were asked for when the store only
+// has coarser ones.
+func medianInterval(buckets []*store.Bucket) time.Duration {
+	if len(buckets) < 2 {
+		return 0
+	}
+	intervals := make([]time.Duration, len(buckets)-1)
+	for i := range intervals {
+		intervals[i] = buckets[i+1].Start.Sub(buckets[i].Start)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals[len(intervals)/2]
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// checkConfig checks the config file at path for -check_config, and
+// writes what it finds to w. Errors are what would keep the daemon
+// from starting, and keys it doesn't know, which are usually typos.
+// Warnings are what it would wait out, like ports that aren't there
+// (yet). It returns the number of errors.
+//
+// The sinks are created and closed, so that their settings are
+// checked by their types, which creates the files of file sinks.
+func checkConfig(path string, w io.Writer) int {
+	errs, warnings := 0, 0
+	report := func(kind, format string, args ...interface{}) {
+		fmt.Fprintf(w, "%v: %v: %v\n", path, kind, fmt.Sprintf(format, args...))
+	}
+	errorf := func(format string, args ...interface{}) {
+		errs++
+		report("error", format, args...)
+	}
+	warnf := func(format string, args ...interface{}) {
+		warnings++
+		report("warning", format, args...)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		errorf("%v", err)
+		return errs
+	}
+	// The settings of the sinks are left to their types, as the
+	// keys they know differ.
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	strict := dec.Decode(new(Config))
+	if strict != nil {
+		errorf("%v", strict)
+	}
+	config := new(Config)
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(config); err != nil {
+		if strict == nil || err.Error() != strict.Error() {
+			errorf("%v", err)
+		}
+		return errs
+	}
+	if err := config.validate(nil); err != nil {
+		errorf("%v", err)
+		return errs
+	}
+
+	for i, sc := range config.Sinks {
+		s, err := sink.New(sc.Type, sc.Options)
+		if err != nil {
+			errorf("sink %d (%v): %v", i, sinkName(sc), err)
+			continue
+		}
+		if err := s.Close(); err != nil {
+			errorf("sink %d (%v): %v", i, sinkName(sc), err)
+		}
+	}
+	for i, ec := range config.Enrichers {
+		en, err := sink.NewEnricher(ec.Type, ec.Options)
+		if err != nil {
+			errorf("enricher %d (%v): %v", i, ec.Type, err)
+			continue
+		}
+		if err := en.Close(); err != nil {
+			errorf("enricher %d (%v): %v", i, ec.Type, err)
+		}
+	}
+	for _, sc := range config.Sensors {
+		c := &collector{config: sc}
+		if _, err := c.findPort(); err != nil {
+			warnf("sensor %q: %v", sc.Name, err)
+		}
+	}
+
+	if errs == 0 {
+		fmt.Fprintf(w, "%v: OK, %d warnings\n", path, warnings)
+	}
+	return errs
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+func TestCheckConfig(t *testing.T) {
+	// Checking the config mustn't touch the sensors.
+	old := openSensor
+	t.Cleanup(func() { openSensor = old })
+	openSensor = func(path string, options sds011.PortOptions) (*sds011.Sensor, error) {
+		t.Errorf("opened %v", path)
+		return old(path, options)
+	}
+
+	dir := t.TempDir()
+	port := filepath.Join(dir, "ttyUSB0")
+	if err := os.WriteFile(port, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "ttyUSB1")
+	for _, tc := range []struct {
+		name   string
+		config string
+		errs   int
+		want   []string
+	}{
+		{
+			"valid",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + port + `"}], "sinks": [{"type": "csv", "path": "-"}]}`,
+			0,
+			[]string{"OK, 0 warnings"},
+		},
+		{
+			"missing port",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + missing + `"}], "sinks": [{"type": "csv", "path": "-"}]}`,
+			0,
+			[]string{`warning: sensor "kitchen"`, "OK, 1 warnings"},
+		},
+		{
+			"duplicate sensor names",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + port + `"}, {"name": "kitchen", "port_path": "` + missing + `"}], "sinks": [{"type": "csv", "path": "-"}]}`,
+			1,
+			[]string{`error: sensor 1: duplicate name "kitchen"`},
+		},
+		{
+			"unknown store backend",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + port + `"}], "sinks": [{"type": "csv", "path": "-"}], "store": {"backend": "postgres", "path": "` + dir + `"}}`,
+			1,
+			[]string{`error: store: unknown backend "postgres"`},
+		},
+		{
+			"store without a path",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + port + `"}], "sinks": [{"type": "csv", "path": "-"}], "store": {"backend": "sqlite"}}`,
+			1,
+			[]string{"error: store: sqlite needs a path"},
+		},
+		{
+			"unknown key",
+			`{"sensors": [{"name": "kitchen", "port_pth": "` + port + `"}], "sinks": [{"type": "csv", "path": "-"}]}`,
+			2,
+			[]string{`error: json: unknown field "port_pth"`, "error: sensor 0"},
+		},
+		{
+			"bad sink",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + port + `"}], "sinks": [{"type": "carrier-pigeon"}]}`,
+			1,
+			[]string{`error: sink 0: unknown type "carrier-pigeon"`},
+		},
+		{
+			"sink that can't be created",
+			`{"sensors": [{"name": "kitchen", "port_path": "` + port + `"}], "sinks": [{"type": "csv", "path": "` + missing + `/readings.csv"}]}`,
+			1,
+			[]string{"error: sink 0 (csv)"},
+		},
+		{
+			"not JSON",
+			`{"sensors": [`,
+			1,
+			[]string{"error: unexpected EOF"},
+		},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "_")+".json")
+		if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		errs := checkConfig(path, &out)
+		if errs != tc.errs {
+			t.Errorf("%v: %d errors, want %d:\n%s", tc.name, errs, tc.errs, out.String())
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(out.String(), path+": "+want) {
+				t.Errorf("%v: no %q in:\n%s", tc.name, want, out.String())
+			}
+		}
+		if tc.errs > 0 && strings.Contains(out.String(), "OK") {
+			t.Errorf("%v: OK despite the errors:\n%s", tc.name, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if errs := checkConfig(filepath.Join(dir, "nope.json"), &out); errs != 1 {
+		t.Errorf("missing file: %d errors, want 1:\n%s", errs, out.String())
+	}
+}
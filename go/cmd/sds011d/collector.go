@@ -0,0 +1,558 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/otlp"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+const (
+	// retryDelay is how long a collector waits after a failed read
+	// before trying again.
+	retryDelay = time.Second
+	// attachDelay is how often a collector looks for its sensor
+	// while it's not attached.
+	attachDelay = 5 * time.Second
+	// reattachAfter is the number of consecutive failed reads after
+	// which a collector reopens the port, in case the adapter got
+	// into a bad state.
+	reattachAfter = 10
+	// pollTimeout bounds the reads of a sensor in active mode, whose
+	// readings may be minutes apart with a working period, so that
+	// commands from the API get their turn in between.
+	pollTimeout = 2 * time.Second
+)
+
+var (
+	errNotAttached = errors.New("sensor not attached")
+	// errPaused is returned by next when the sensor was put to sleep.
+	errPaused = errors.New("sensor is asleep")
+	// errNoReading is returned by next when no reading came within
+	// pollTimeout, which isn't a failure yet.
+	errNoReading = errors.New("no reading yet")
+)
+
+// openSensor opens the port of a sensor. Tests replace it.
+var openSensor = sds011.NewWithOptions
+
+// A collector reads a single sensor according to its config and
+// sends the measurements to out.
+type collector struct {
+	config  SensorConfig
+	out     chan<- *sink.Reading
+	metrics *exporter.Metrics
+	// degradations looks at every raw reading.
+	degradations *degradations
+	// otlp, if not nil, also receives the readings.
+	otlp *otlp.Exporter
+	// observer, if not nil, is set on the sensor whenever it's
+	// attached.
+	observer sds011.Observer
+	// failed is set if the last read failed.
+	failed atomic.Bool
+	// failures counts consecutive failed reads.
+	failures int
+	// lastReading is when the last reading came, or the sensor was
+	// attached.
+	lastReading time.Time
+	// polluted is set while an adaptive sensor measures more often.
+	polluted bool
+	// hinted is set once the stable path of the port was logged.
+	hinted bool
+	// reloaded is the config set by reload, if any. Only the
+	// calibration, labels and adaptive thresholds are taken from it.
+	reloaded atomic.Pointer[SensorConfig]
+	// remoteLabels are the labels a federated sensor has on its peer,
+	// as of the last measurement streamed from it.
+	remoteLabels atomic.Pointer[map[string]string]
+
+	// mu guards the sensor and the state of the collector that
+	// commands from the API change. The sensor serializes its calls
+	// itself, so reads, which may block, are made without mu. The
+	// sensor is nil while it's not attached.
+	mu       sync.Mutex
+	sensor   *sds011.Sensor
+	portPath string
+	// asleep and passive are set when the sensor was put to sleep
+	// or in query mode through the API, so that the read loop
+	// doesn't wait for readings that won't come.
+	asleep, passive bool
+	// port is the open sensor, for close, which can't wait for mu.
+	port atomic.Pointer[sds011.Sensor]
+}
+
+// do calls f with exclusive access to the sensor.
+func (c *collector) do(f func(sensor *sds011.Sensor) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.remote != nil {
+		return errFederated
+	}
+	if c.sensor == nil {
+		return errNotAttached
+	}
+	return f(c.sensor)
+}
+
+// attached returns the sensor, for calls that shouldn't hold c.mu.
+func (c *collector) attached() (*sds011.Sensor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.remote != nil {
+		return nil, errFederated
+	}
+	if c.sensor == nil {
+		return nil, errNotAttached
+	}
+	return c.sensor, nil
+}
+
+// setReportMode sets the report mode of the sensor.
+func (c *collector) setReportMode(mode sds011.ReportMode) error {
+	return c.do(func(s *sds011.Sensor) error { return s.SetReportMode(mode) })
+}
+
+// findPort returns the path of the port the sensor is connected to.
+func (c *collector) findPort() (string, error) {
+	if c.config.USB != nil {
+		return findUSBPort(c.config.USB)
+	}
+	if _, err := sds011.ResolvePort(c.config.PortPath); err != nil {
+		return "", err
+	}
+	return c.config.PortPath, nil
+}
+
+// attach waits for the sensor to show up and opens it. It returns
+// false if ctx was done first.
+func (c *collector) attach(ctx context.Context) bool {
+	var lastErr string
+	for {
+		path, err := c.findPort()
+		var sensor *sds011.Sensor
+		if err == nil {
+			sensor, err = openSensor(path, c.config.Serial.options())
+		}
+		if err == nil {
+			if c.observer != nil {
+				sensor.SetObserver(c.observer)
+			}
+			c.mu.Lock()
+			c.sensor, c.portPath = sensor, path
+			c.asleep, c.passive = false, false
+			c.mu.Unlock()
+			c.port.Store(sensor)
+			c.failures, c.lastReading = 0, time.Now()
+			port := sensor.Port()
+			log.Infof("%v: attached %v (%v)", c.config.Name, path, port.Device)
+			if c.config.USB == nil && port.Stable() != c.config.PortPath && !c.hinted {
+				log.Infof("%v: %v may be renumbered after a reboot; port_path %q always finds this adapter", c.config.Name, path, port.Stable())
+				c.hinted = true
+			}
+			return true
+		}
+		// Don't repeat the same error every few seconds.
+		if err.Error() != lastErr {
+			log.Errorf("%v: waiting for the sensor: %v", c.config.Name, err)
+			lastErr = err.Error()
+		}
+		if !sleep(ctx, attachDelay) {
+			return false
+		}
+	}
+}
+
+// detach closes the sensor.
+func (c *collector) detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sensor == nil {
+		return
+	}
+	c.port.Store(nil)
+	c.sensor.Close()
+	c.sensor = nil
+	log.Infof("%v: detached %v", c.config.Name, c.portPath)
+}
+
+// close closes the sensor's port, unblocking any pending reads.
+func (c *collector) close() {
+	if sensor := c.port.Load(); sensor != nil {
+		sensor.Close()
+	}
+}
+
+// currentPort returns the path of the port of the attached sensor.
+func (c *collector) currentPort() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sensor == nil {
+		return ""
+	}
+	return c.portPath
+}
+
+// lost returns whether the sensor should be reattached: either its
+// port disappeared (or, for USB adapters, moved), or it has failed
+// too many times in a row.
+func (c *collector) lost() bool {
+	if c.failures >= reattachAfter {
+		return true
+	}
+	path, err := c.findPort()
+	return err != nil || path != c.currentPort()
+}
+
+// setLabels passes the current labels of the sensor on to the
+// metrics.
+func (c *collector) setLabels() {
+	labels := c.labels(c.current())
+	c.metrics.SetLabels(c.config.Name, labels)
+	if c.otlp != nil {
+		c.otlp.SetLabels(c.config.Name, labels)
+	}
+}
+
+// labels returns the labels of the sensor in config: those set on
+// its peer for a federated sensor, overridden by the local ones.
+func (c *collector) labels(config *SensorConfig) map[string]string {
+	remote := c.remoteLabels.Load()
+	if remote == nil || len(*remote) == 0 {
+		return config.Labels
+	}
+	labels := make(map[string]string, len(*remote)+len(config.Labels))
+	for k, v := range *remote {
+		labels[k] = v
+	}
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// read takes a single reading with f, recording the outcome in the
+// metrics. f is called without c.mu held.
+func (c *collector) read(f func(sensor *sds011.Sensor, point *sds011.Point) error, point *sds011.Point) error {
+	sensor, err := c.attached()
+	if err == nil {
+		err = f(sensor, point)
+	}
+	if err == errPaused || err == errNoReading {
+		return err
+	}
+	c.failed.Store(err != nil)
+	if err != nil {
+		c.failures++
+		c.metrics.ObserveError(c.config.Name, err)
+		if c.otlp != nil {
+			c.otlp.ObserveError(c.config.Name, err)
+		}
+		return err
+	}
+	c.failures, c.lastReading = 0, time.Now()
+	// The exporters report what the other outputs do, but the
+	// degradations are of the sensor itself.
+	c.degradations.observe(c.config.Name, point)
+	calibrated, _ := c.calibrate(point)
+	c.metrics.Observe(c.config.Name, &calibrated)
+	if c.otlp != nil {
+		c.otlp.Observe(c.config.Name, &calibrated)
+	}
+	return nil
+}
+
+// failing returns whether the last read failed.
+func (c *collector) failing() bool {
+	return c.failed.Load()
+}
+
+// run reads the sensor until ctx is done. If the sensor goes away,
+// it waits for it to come back.
+func (c *collector) run(ctx context.Context) {
+	if c.config.remote != nil {
+		c.runRemote(ctx)
+		return
+	}
+	defer c.detach()
+	for c.attach(ctx) {
+		err := c.do(func(sensor *sds011.Sensor) error {
+			return exporter.Refresh(sensor, c.config.Name, c.metrics)
+		})
+		if err != nil {
+			log.Errorf("%v: %v", c.config.Name, err)
+		}
+		if c.config.Interval.Duration == 0 {
+			c.runActive(ctx)
+		} else {
+			c.runPeriodic(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.detach()
+	}
+}
+
+// runActive puts the sensor in active mode and averages the readings
+// it reports, until ctx is done or the sensor is lost.
+func (c *collector) runActive(ctx context.Context) {
+	if err := c.setReportMode(sds011.ActiveMode); err != nil {
+		log.Errorf("%v: SetReportMode: %v", c.config.Name, err)
+	}
+	timeout := pollTimeout
+	if t := c.config.Serial.ReadTimeout.Duration; t > 0 && t < timeout {
+		timeout = t
+	}
+	if sensor, err := c.attached(); err == nil {
+		sensor.SetReadTimeout(timeout)
+	}
+	// The samples are read in place, so that reading doesn't
+	// allocate.
+	samples := make([]sds011.Point, c.config.Samples)
+	n := 0
+	next := func(sensor *sds011.Sensor, point *sds011.Point) error {
+		return c.next(ctx, sensor, point)
+	}
+	for ctx.Err() == nil {
+		err := c.read(next, &samples[n])
+		if err == errNoReading {
+			continue
+		}
+		if err == errPaused {
+			sleep(ctx, retryDelay)
+			continue
+		}
+		if err != nil {
+			log.Errorf("%v: Get: %v", c.config.Name, err)
+			if c.lost() {
+				return
+			}
+			sleep(ctx, retryDelay)
+			continue
+		}
+		if n++; n < len(samples) {
+			continue
+		}
+		avg := sds011.Average(samples)
+		c.emit(ctx, &avg)
+		n = 0
+	}
+}
+
+// next returns the next reading of a sensor that should be in active
+// mode. If it was switched to query mode through the API, it queries
+// it once a second instead. A read that times out is only a failure
+// once the sensor has been quiet for longer than the read_timeout of
+// its serial config, if it has one. Once ctx is done, it returns
+// errNoReading without reading.
+func (c *collector) next(ctx context.Context, sensor *sds011.Sensor, point *sds011.Point) error {
+	c.mu.Lock()
+	asleep, passive := c.asleep, c.passive
+	c.mu.Unlock()
+	var err error
+	switch {
+	case asleep:
+		return errPaused
+	case passive:
+		// Readings in active mode come once a second; keep the pace.
+		if !sleep(ctx, time.Second) {
+			return errNoReading
+		}
+		err = sensor.QueryPoint(point)
+	default:
+		err = sensor.ReadPoint(point)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		if t := c.config.Serial.ReadTimeout.Duration; t == 0 || time.Since(c.lastReading) < t {
+			return errNoReading
+		}
+	}
+	return err
+}
+
+// runPeriodic keeps the sensor asleep, waking it up every interval
+// to take a measurement, until ctx is done or the sensor is lost.
+func (c *collector) runPeriodic(ctx context.Context) {
+	if err := c.setReportMode(sds011.QueryMode); err != nil {
+		log.Errorf("%v: SetReportMode: %v", c.config.Name, err)
+	}
+	for {
+		start := time.Now()
+		point, err := c.measure(ctx)
+		if err == errPaused {
+			// It was put to sleep through the API; it stays
+			// asleep until it's woken up the same way, and is
+			// measured right after that.
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Errorf("%v: %v", c.config.Name, err)
+			if c.lost() {
+				return
+			}
+		} else {
+			c.adapt(point)
+			c.emit(ctx, point)
+		}
+		if !sleep(ctx, time.Until(start.Add(c.interval()))) {
+			return
+		}
+	}
+}
+
+// interval returns how often the sensor should be measured now.
+func (c *collector) interval() time.Duration {
+	if ac := c.current().Adaptive; c.polluted && ac != nil {
+		return ac.Interval.Duration
+	}
+	return c.config.Interval.Duration
+}
+
+// adapt decides, for an adaptive sensor, whether the air is polluted
+// after point was measured.
+func (c *collector) adapt(point *sds011.Point) {
+	ac := c.current().Adaptive
+	if ac == nil {
+		c.polluted = false
+		return
+	}
+	above := func(level, threshold float64) bool {
+		return threshold > 0 && level > threshold
+	}
+	switch {
+	case !c.polluted && (above(point.PM25, ac.PM25) || above(point.PM10, ac.PM10)):
+		c.polluted = true
+		log.Infof("%v: air polluted (PM2.5 %v, PM10 %v), measuring every %v", c.config.Name, point.PM25, point.PM10, ac.Interval)
+	case c.polluted && !above(point.PM25, ac.PM25*ac.Clear) && !above(point.PM10, ac.PM10*ac.Clear):
+		c.polluted = false
+		log.Infof("%v: air cleared (PM2.5 %v, PM10 %v), measuring every %v", c.config.Name, point.PM25, point.PM10, c.config.Interval)
+	}
+}
+
+// measure wakes the sensor up, waits for it to warm up, takes the
+// configured number of samples and puts the sensor back to sleep, like
+// MeasureAverage, but lets the API in between the commands, and records
+// every sample in the metrics. It returns errPaused if the sensor was
+// put to sleep through the API, before or during the measurement.
+func (c *collector) measure(ctx context.Context) (*sds011.Point, error) {
+	err := c.do(func(sensor *sds011.Sensor) error {
+		if c.asleep {
+			return errPaused
+		}
+		return sensor.Awake()
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.metrics.SetAwake(c.config.Name, true)
+	defer func() {
+		if err := c.do((*sds011.Sensor).Sleep); err != nil {
+			log.Errorf("%v: Sleep: %v", c.config.Name, err)
+			return
+		}
+		c.metrics.SetAwake(c.config.Name, false)
+	}()
+	if !sleep(ctx, c.config.Warmup.Duration) {
+		return nil, ctx.Err()
+	}
+	samples := make([]sds011.Point, c.config.Samples)
+	for i := range samples {
+		if i > 0 && !sleep(ctx, time.Second) {
+			return nil, ctx.Err()
+		}
+		if err := c.read(c.query, &samples[i]); err != nil {
+			return nil, err
+		}
+	}
+	avg := sds011.Average(samples)
+	return &avg, nil
+}
+
+// query queries a sensor that should be awake for a reading.
+func (c *collector) query(sensor *sds011.Sensor, point *sds011.Point) error {
+	c.mu.Lock()
+	asleep := c.asleep
+	c.mu.Unlock()
+	if asleep {
+		return errPaused
+	}
+	return sensor.QueryPoint(point)
+}
+
+func (c *collector) emit(ctx context.Context, point *sds011.Point) {
+	select {
+	case c.out <- c.reading(point):
+	case <-ctx.Done():
+	}
+}
+
+// reading returns the measurement point, with the calibration and
+// labels of the sensor applied.
+func (c *collector) reading(point *sds011.Point) *sink.Reading {
+	calibrated, config := c.calibrate(point)
+	return &sink.Reading{Sensor: c.config.Name, Point: &calibrated, Labels: c.labels(config)}
+}
+
+// current returns the config of the sensor, as last reloaded.
+func (c *collector) current() *SensorConfig {
+	if reloaded := c.reloaded.Load(); reloaded != nil {
+		return reloaded
+	}
+	return &c.config
+}
+
+// calibrate returns point with the calibration of the sensor applied,
+// and the config it was taken from.
+func (c *collector) calibrate(point *sds011.Point) (sds011.Point, *SensorConfig) {
+	config := c.current()
+	cal := config.Calibration
+	calibrated := sds011.Point{
+		PM25:      cal.PM25.apply(point.PM25),
+		PM10:      cal.PM10.apply(point.PM10),
+		HasPM1:    point.HasPM1,
+		Timestamp: point.Timestamp,
+		Quality:   point.Quality,
+	}
+	if point.HasPM1 {
+		calibrated.PM1 = cal.PM1.apply(point.PM1)
+	}
+	return calibrated, config
+}
+
+// sleep waits for d to pass. It returns false if ctx was done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
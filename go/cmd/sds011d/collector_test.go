@@ -0,0 +1,118 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+var t0 = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestReading(t *testing.T) {
+	c := &collector{config: SensorConfig{
+		Name:        "kitchen",
+		Labels:      map[string]string{"room": "kitchen"},
+		Calibration: Calibration{PM25: Linear{Scale: 2}, PM10: Linear{Offset: -5}, PM1: Linear{Offset: 1}},
+	}}
+	point := &sds011.Point{PM25: 10, PM10: 3, PM1: 4, HasPM1: true, Timestamp: t0, Quality: sds011.Quality{InWarmup: true}}
+	r := c.reading(point)
+	want := sds011.Point{PM25: 20, PM10: 0, PM1: 5, HasPM1: true, Timestamp: t0, Quality: sds011.Quality{InWarmup: true}}
+	if r.Sensor != "kitchen" || *r.Point != want || !reflect.DeepEqual(r.Labels, c.config.Labels) {
+		t.Errorf("reading: %v %+v %v, want kitchen %+v %v", r.Sensor, *r.Point, r.Labels, want, c.config.Labels)
+	}
+	// Without PM1.0, its calibration doesn't make one up.
+	if r := c.reading(&sds011.Point{PM25: 10, PM10: 10, Timestamp: t0}); r.HasPM1 || r.PM1 != 0 {
+		t.Errorf("reading without PM1.0: %+v", *r.Point)
+	}
+
+	// A reloaded config replaces the calibration and the labels.
+	reloaded := c.config
+	reloaded.Calibration = Calibration{PM25: Linear{Scale: 3}}
+	reloaded.Labels = map[string]string{"room": "hall"}
+	c.reloaded.Store(&reloaded)
+	r = c.reading(point)
+	if r.PM25 != 30 || r.PM10 != 3 || r.Labels["room"] != "hall" {
+		t.Errorf("reading after a reload: %+v %v, want PM2.5 30, PM10 3, room hall", *r.Point, r.Labels)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	c := &collector{config: SensorConfig{
+		Name:     "kitchen",
+		Interval: Duration{10 * time.Minute},
+		Adaptive: &AdaptiveConfig{Interval: Duration{time.Minute}, PM25: 50, Clear: 0.8},
+	}}
+	for i, tc := range []struct {
+		pm25 float64
+		want time.Duration
+	}{
+		{10, 10 * time.Minute},
+		{51, time.Minute},
+		// Below the threshold, but not below the clear level yet.
+		{45, time.Minute},
+		{39, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	} {
+		c.adapt(&sds011.Point{PM25: tc.pm25})
+		if got := c.interval(); got != tc.want {
+			t.Errorf("%d: interval after PM2.5 %v: %v, want %v", i, tc.pm25, got, tc.want)
+		}
+	}
+
+	// Reloading without the adaptive thresholds goes back to the
+	// interval.
+	c.adapt(&sds011.Point{PM25: 100})
+	reloaded := c.config
+	reloaded.Adaptive = nil
+	c.reloaded.Store(&reloaded)
+	if got := c.interval(); got != 10*time.Minute {
+		t.Errorf("interval after reloading without adaptive: %v, want 10m", got)
+	}
+	c.adapt(&sds011.Point{PM25: 100})
+	if c.polluted {
+		t.Error("polluted without adaptive thresholds")
+	}
+}
+
+func TestRunActiveCancelled(t *testing.T) {
+	d, _, _ := testDaemon(t, minimalConfig(""))
+	c := d.collectors["kitchen"]
+	out := make(chan *sink.Reading, 10)
+	c.out = out
+	// Switched to query mode through the API, it's queried once a
+	// second.
+	c.passive = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.runActive(ctx)
+		close(done)
+	}()
+	<-out
+	// It's waiting to query again now.
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("runActive didn't return once ctx was done")
+	}
+}
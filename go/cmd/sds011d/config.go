@@ -0,0 +1,1038 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ryszard/sds011/go/alert"
+	"github.com/ryszard/sds011/go/exceedance"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// Duration is a time.Duration that is written in the config file as
+// a string, like "30s" or "10m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"30s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Config is the daemon's configuration, as read from the config file.
+type Config struct {
+	Sensors []SensorConfig `json:"sensors"`
+	Sinks   []SinkConfig   `json:"sinks"`
+	// Enrichers add to the readings, in order, before they reach
+	// the sinks.
+	Enrichers []EnricherConfig `json:"enrichers"`
+	// Peers are other daemons whose sensors this one federates: it
+	// reads them over the RPC services of the peers, and treats them
+	// as its own, so that they're stored, served and sent to the
+	// sinks with the rest.
+	Peers []PeerConfig `json:"peers"`
+	// SequenceFile, if set, is where the sequence numbers of the
+	// readings of every sensor are kept, so that they carry on when
+	// the daemon is restarted. Otherwise they start again from 1.
+	SequenceFile string `json:"sequence_file"`
+	// RPCAddress is the TCP address the SDS011 gRPC service (see
+	// package remote) listens on. If it's empty, the service is
+	// disabled.
+	RPCAddress string `json:"rpc_address"`
+	// HTTPAddress is the TCP address the HTTP API listens on. If it's
+	// empty, the API is disabled.
+	HTTPAddress string `json:"http_address"`
+	// ControlToken, if set, is required by the API calls and RPCs
+	// that change the settings of the sensors: as a bearer token in
+	// HTTP requests, and with remote.Client.SetToken over RPC.
+	// Without it, or Auth, those calls are refused.
+	ControlToken string `json:"control_token"`
+	// Auth, if set, protects everything the daemon serves.
+	Auth AuthConfig `json:"auth"`
+	// TLS, if set, makes the HTTP API and the RPC service use TLS.
+	TLS TLSConfig `json:"tls"`
+	// ModbusAddress is the TCP address the Modbus TCP server listens
+	// on. If it's empty, the server is disabled.
+	ModbusAddress string `json:"modbus_address"`
+	// SNMP configures the SNMP agent. If its address is empty, the
+	// agent is disabled.
+	SNMP SNMPConfig `json:"snmp"`
+	// MDNS configures advertising the daemon on the local network.
+	MDNS MDNSConfig `json:"mdns"`
+	// MQTT configures the embedded MQTT broker. If its address is
+	// empty, the broker is disabled.
+	MQTT MQTTConfig `json:"mqtt"`
+	// History configures the in-memory history served by the API.
+	History HistoryConfig `json:"history"`
+	// Store configures the on-disk store. If it's not set, readings
+	// are only kept in memory.
+	Store StoreConfig `json:"store"`
+	// OTLP configures exporting metrics and traces to an
+	// OpenTelemetry collector. If it's not set, nothing is exported.
+	OTLP OTLPConfig `json:"otlp"`
+	// Streams configures the WebSocket and Server-Sent Events
+	// streams.
+	Streams StreamsConfig `json:"streams"`
+	// Averages are the windows the daemon keeps rolling averages
+	// over. They default to 1m and 1h.
+	Averages []Duration `json:"averages"`
+	// Heartbeat configures the reports the daemon makes on its own
+	// health.
+	Heartbeat HeartbeatConfig `json:"heartbeat"`
+	// Exceedances configures detecting when the air quality limits
+	// are exceeded.
+	Exceedances ExceedancesConfig `json:"exceedances"`
+	// Reports configures the daily and weekly summaries of the air
+	// quality.
+	Reports ReportsConfig `json:"reports"`
+	// Alerts configures the alert rules, and where their events
+	// go.
+	Alerts AlertsConfig `json:"alerts"`
+}
+
+// AlertsConfig describes the alert rules evaluated over the readings
+// of the sensors (see package alert), and the notifiers their events
+// are sent to.
+type AlertsConfig struct {
+	// Rules are the rules. If there are none, nothing is checked.
+	Rules []AlertRuleConfig `json:"rules"`
+	// Notifiers are where the events go, besides the API.
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// AlertRuleConfig describes an alert rule (see alert.Rule).
+type AlertRuleConfig struct {
+	// Name identifies the rule. It's required.
+	Name string `json:"name"`
+	// Metric is "pm25", "pm10", "pm1" or "aqi".
+	Metric     string   `json:"metric"`
+	Threshold  float64  `json:"threshold"`
+	Sustain    Duration `json:"sustain"`
+	Hysteresis float64  `json:"hysteresis"`
+	Severity   string   `json:"severity"`
+	// Sensors are the names of the sensors the rule applies to. If
+	// it's empty, it applies to all of them.
+	Sensors []string `json:"sensors"`
+}
+
+// NotifierConfig describes where to send alert events.
+type NotifierConfig struct {
+	// Type is "webhook", "slack", "telegram" or "email".
+	Type string `json:"type"`
+	// URL is where a webhook POSTs the events, as JSON, or the
+	// Slack incoming webhook the messages are posted to. For
+	// Telegram, it's the Bot API, which defaults to
+	// https://api.telegram.org.
+	URL string `json:"url"`
+	// Token and ChatID are the token of the Telegram bot, and the
+	// chat it sends the messages to.
+	Token  string `json:"token"`
+	ChatID string `json:"chat_id"`
+	// Mail describes how to mail the messages.
+	Mail MailConfig `json:"mail"`
+	// Template is a text/template for the messages. It is executed
+	// on the event, with the PM25, PM10, AQI and Category of the
+	// reading that caused it.
+	Template string `json:"template"`
+}
+
+// ExceedancesConfig describes the limits the 24-hour means of the
+// sensors are checked against (see package exceedance).
+type ExceedancesConfig struct {
+	// Limits are the names of the limits: "who2021", "eu" or
+	// "eu2030". If there are none, nothing is checked.
+	Limits []string `json:"limits"`
+	// URL, if set, is where the events are POSTed to, as JSON.
+	URL string `json:"url"`
+}
+
+// ReportsConfig describes the summaries of the readings of the sensors
+// the daemon makes at the end of every day or week (in local time).
+type ReportsConfig struct {
+	// Periods are "daily" and "weekly". If there are none, no
+	// reports are made.
+	Periods []string `json:"periods"`
+	// Limit is the name of the limit the exceedance hours are
+	// counted against: "who2021" (the default), "eu" or "eu2030".
+	Limit string `json:"limit"`
+	// URL, if set, is where the reports are POSTed to, as a JSON
+	// array.
+	URL string `json:"url"`
+	// Mail, if its address is set, describes how to mail the
+	// reports, as an HTML table.
+	Mail MailConfig `json:"mail"`
+}
+
+// MailConfig describes how to send mail.
+type MailConfig struct {
+	// Address is the host:port of the SMTP server.
+	Address string `json:"address"`
+	// Username and Password, if set, are used to log in to the
+	// server.
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// StreamsConfig describes how the streams treat clients that don't
+// keep up. Clients can ask for something else with the buffer and
+// policy parameters.
+type StreamsConfig struct {
+	// Buffer is how many readings a client can fall behind before
+	// its policy kicks in. It defaults to 16.
+	Buffer int `json:"buffer"`
+	// Policy is what happens then: "drop_newest" (the default)
+	// drops new readings, "drop_oldest" drops the oldest buffered
+	// ones, and "disconnect" closes the stream.
+	Policy string `json:"policy"`
+}
+
+// HeartbeatConfig describes how the daemon reports on its health.
+type HeartbeatConfig struct {
+	// Interval is how often to make a heartbeat. It defaults to 1m.
+	Interval Duration `json:"interval"`
+	// URL, if set, is where heartbeats are POSTed to, as JSON.
+	URL string `json:"url"`
+}
+
+// OTLPConfig describes the OpenTelemetry collector the daemon exports
+// to.
+type OTLPConfig struct {
+	// Endpoint is the base URL of the collector's OTLP/HTTP
+	// receiver, for example "http://localhost:4318".
+	Endpoint string `json:"endpoint"`
+	// Interval is how often to export. It defaults to 1m.
+	Interval Duration `json:"interval"`
+	// Headers are added to every export request.
+	Headers map[string]string `json:"headers"`
+}
+
+// AuthConfig lists who may use the daemon's HTTP API and RPC service.
+type AuthConfig struct {
+	// Tokens are accepted as bearer tokens over HTTP, and with
+	// remote.Client.SetToken over RPC.
+	Tokens []string `json:"tokens"`
+	// Users maps user names to passwords accepted with HTTP basic
+	// authentication.
+	Users map[string]string `json:"users"`
+}
+
+// enabled returns whether authentication is required.
+func (a *AuthConfig) enabled() bool {
+	return len(a.Tokens) > 0 || len(a.Users) > 0
+}
+
+// PeerConfig describes another daemon whose sensors are federated.
+type PeerConfig struct {
+	// Name identifies the peer. Its sensors are named
+	// <name>/<sensor>.
+	Name string `json:"name"`
+	// Address is the TCP address of the RPC service of the peer.
+	Address string `json:"address"`
+	// TLS makes the connection use TLS, for peers that do. The
+	// certificate of the peer is checked against CAFile, if it's
+	// set, or the system's roots.
+	TLS    bool   `json:"tls"`
+	CAFile string `json:"ca_file"`
+	// Token is sent with every call, for peers that require one.
+	Token string `json:"token"`
+	// Sensors are the sensors of the peer to federate. If it's
+	// empty, they are all listed from the peer when the config is
+	// loaded, so it has to be up then.
+	Sensors []string `json:"sensors"`
+}
+
+// TLSConfig holds the certificate the daemon serves with.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// MDNSConfig describes how the daemon advertises itself over mDNS.
+type MDNSConfig struct {
+	// Enabled turns the advertisement on. It requires the HTTP API
+	// or the RPC service to be enabled.
+	Enabled bool `json:"enabled"`
+	// Instance is the name of the advertised service, at most 63
+	// bytes long. It defaults to "sds011d on <hostname>". If another
+	// host on the network has it, a number is added, as in
+	// "sds011d on pi (2)".
+	Instance string `json:"instance"`
+}
+
+// SNMPConfig describes the SNMP agent.
+type SNMPConfig struct {
+	// Address is the UDP address the agent listens on, usually
+	// ":161".
+	Address string `json:"address"`
+	// Community is the community string requests must use. It
+	// defaults to "public".
+	Community string `json:"community"`
+	// RootOID is the OID under which the agent's table lives, like
+	// 1.3.6.1.4.1.<enterprise number>.11. It's required, as there's
+	// no OID the table could have for everyone: it has to be under an
+	// enterprise number assigned by IANA, to you or your
+	// organization.
+	RootOID string `json:"root_oid"`
+}
+
+// MQTTConfig describes the embedded MQTT broker, which the daemon
+// publishes its readings to.
+type MQTTConfig struct {
+	// Address is the TCP address the broker listens on, usually
+	// ":1883".
+	Address string `json:"address"`
+	// Topic is what the topics the readings are published to start
+	// with: they're <topic>/<sensor>. It defaults to "sds011".
+	Topic string `json:"topic"`
+	// Discovery, if set, is the prefix under which Home Assistant
+	// looks for discovery configs, usually "homeassistant". The
+	// configs of the PM2.5 and PM10 of every sensor are published
+	// there.
+	Discovery string `json:"discovery"`
+	// Username and Password, if set, are required of the clients.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// StoreConfig describes where and for how long the daemon stores
+// readings.
+type StoreConfig struct {
+	// Backend is "files", which keeps the readings on disk, in
+	// segment files in the directory Path, "bbolt" or "sqlite",
+	// which keep them in a database file at Path, or "memory",
+	// which only keeps them until the daemon exits. It defaults to
+	// "files" if Path is set; otherwise there is no store.
+	Backend string `json:"backend"`
+	// Path is the directory of the files backend, or the database
+	// file of bbolt and sqlite.
+	Path string `json:"path"`
+	// Retention is how long readings are kept. It defaults to 7
+	// days when they are downsampled or kept in memory, and 90
+	// otherwise.
+	Retention Duration `json:"retention"`
+	// Downsample are tiers of averaged readings, kept for longer,
+	// in order of increasing resolution. Only the files backend
+	// has them; unless they are set, it keeps 5 minute averages
+	// for 90 days and hourly ones forever. An empty list turns
+	// downsampling off.
+	Downsample []DownsampleConfig `json:"downsample"`
+}
+
+// DownsampleConfig describes a tier of averaged readings in the store.
+type DownsampleConfig struct {
+	// Resolution is the length of the buckets readings are averaged
+	// into. It should divide a day.
+	Resolution Duration `json:"resolution"`
+	// Retention is how long the averages are kept. Zero means
+	// forever.
+	Retention Duration `json:"retention"`
+}
+
+// HistoryConfig describes how much history the daemon keeps in
+// memory.
+type HistoryConfig struct {
+	// Retention is how long readings are kept. It defaults to 48h.
+	Retention Duration `json:"retention"`
+	// Resolution is the length of the intervals readings are
+	// averaged into. It defaults to 1m.
+	Resolution Duration `json:"resolution"`
+}
+
+// SensorConfig describes a single sensor and how to read it.
+type SensorConfig struct {
+	// Name identifies the sensor in the output. It defaults to the
+	// port path.
+	Name string `json:"name"`
+	// PortPath is the path of the serial port the sensor is
+	// connected to. Either it or USB is required.
+	PortPath string `json:"port_path"`
+	// USB identifies the USB serial adapter the sensor is connected
+	// to, for when its port path isn't stable. If it's set, PortPath
+	// is ignored.
+	USB *USBConfig `json:"usb"`
+	// Serial tunes the serial port, for adapters that need it.
+	Serial SerialConfig `json:"serial"`
+	// Interval is how often to take a measurement. If it's 0 the
+	// sensor is put in active mode and every reading it reports is
+	// used. Otherwise, the sensor is kept asleep between
+	// measurements.
+	Interval Duration `json:"interval"`
+	// Warmup is how long to wait after waking the sensor up before
+	// reading it. The datasheet recommends 30 seconds. It is only
+	// used if Interval is set.
+	Warmup Duration `json:"warmup"`
+	// Samples is the number of consecutive readings averaged into a
+	// single measurement. It defaults to 1.
+	Samples int `json:"samples"`
+	// Adaptive, if set, makes the sensor measure more often while
+	// the air is polluted. It is only used if Interval is set.
+	Adaptive *AdaptiveConfig `json:"adaptive"`
+	// Calibration corrects the measurements of the sensor, for
+	// example to match a reference instrument.
+	Calibration Calibration `json:"calibration"`
+	// Labels are attached to every measurement of the sensor, and
+	// included by the sinks that write JSON. They are also labels of
+	// its Prometheus metrics, and attributes of its OTLP data points.
+	Labels map[string]string `json:"labels"`
+	// Sinks are the names of the sinks measurements of this sensor
+	// go to. If it's empty, they go to all sinks.
+	Sinks []string `json:"sinks"`
+
+	// remote is set for the sensors federated from peers, which
+	// aren't in the config file, but added from Peers.
+	remote *remoteSensor
+}
+
+// USBConfig matches USB serial adapters. The IDs are hexadecimal
+// strings, as printed by lsusb; the SDS011 comes with a CH340
+// adapter, which is 1a86:7523.
+type USBConfig struct {
+	VendorID  string `json:"vendor_id"`
+	ProductID string `json:"product_id"`
+	// Serial is the serial number of the adapter. It is needed to
+	// tell apart several adapters with the same IDs, but not all
+	// adapters have one: the CH340 doesn't. Two sensors can't share a
+	// matcher, so sensors on such adapters need a port_path, like
+	// the /dev/serial/by-path link of the USB socket.
+	Serial string `json:"serial"`
+}
+
+// AdaptiveConfig shortens the interval of a sensor during pollution
+// events, trading the life of its laser for resolution only when it
+// matters. The air is polluted once a measurement is above any of the
+// thresholds, and clears once one is below all of them scaled by
+// Clear, so that levels hovering around a threshold don't make the
+// interval flap.
+type AdaptiveConfig struct {
+	// Interval is how often to take a measurement while the air is
+	// polluted. It must be shorter than the interval of the sensor,
+	// and longer than its warmup.
+	Interval Duration `json:"interval"`
+	// PM25 and PM10 are the thresholds, in µg/m³, as the sensor
+	// measures them, before calibration. Zero means the level isn't
+	// considered.
+	PM25 float64 `json:"pm25"`
+	PM10 float64 `json:"pm10"`
+	// Clear is the fraction of the thresholds the levels have to
+	// fall below for the air to clear. It defaults to 0.8.
+	Clear float64 `json:"clear"`
+}
+
+// SerialConfig tunes the serial port of a sensor (see
+// sds011.PortOptions). The defaults work with most adapters.
+type SerialConfig struct {
+	// InterCharacterTimeout and MinimumReadSize decide when reads
+	// from the port return: once MinimumReadSize bytes have arrived,
+	// or InterCharacterTimeout has passed without a new one.
+	InterCharacterTimeout Duration `json:"inter_character_timeout"`
+	MinimumReadSize       uint     `json:"minimum_read_size"`
+	// RTS and DTR are "on" or "off" to assert or deassert the modem
+	// control lines, and empty to leave them be. They only work on
+	// Linux and macOS.
+	RTS string `json:"rts"`
+	DTR string `json:"dtr"`
+	// ReadTimeout, if set, is how long to wait for the sensor to
+	// send something before the read fails, so that a wedged sensor
+	// is noticed, and reattached after a few failures. It should be
+	// longer than the time between readings.
+	ReadTimeout Duration `json:"read_timeout"`
+	// WriteTimeout, if set, is how long a command may take to be
+	// sent.
+	WriteTimeout Duration `json:"write_timeout"`
+}
+
+// lines are the states of modem control lines, by name.
+var lines = map[string]sds011.Line{"": sds011.LineUnchanged, "on": sds011.LineOn, "off": sds011.LineOff}
+
+// options returns the port options.
+func (sc *SerialConfig) options() sds011.PortOptions {
+	return sds011.PortOptions{
+		InterCharacterTimeout: sc.InterCharacterTimeout.Duration,
+		MinimumReadSize:       sc.MinimumReadSize,
+		RTS:                   lines[sc.RTS],
+		DTR:                   lines[sc.DTR],
+		ReadTimeout:           sc.ReadTimeout.Duration,
+		WriteTimeout:          sc.WriteTimeout.Duration,
+	}
+}
+
+// Calibration describes linear corrections of the PM levels. PM1 only
+// applies to clones that measure PM1.0.
+type Calibration struct {
+	PM25 Linear `json:"pm25"`
+	PM10 Linear `json:"pm10"`
+	PM1  Linear `json:"pm1"`
+}
+
+// Linear is the correction Scale*x + Offset. A Scale of 0 means 1.
+type Linear struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// apply returns x corrected. Negative results are clamped to 0, as
+// there's no such thing as a negative concentration.
+func (l Linear) apply(x float64) float64 {
+	if l.Scale != 0 {
+		x *= l.Scale
+	}
+	if x += l.Offset; x < 0 {
+		return 0
+	}
+	return x
+}
+
+// SinkConfig describes a single output.
+type SinkConfig struct {
+	// Name identifies the sink in the sinks list of sensors. It is
+	// only needed if sensors choose their sinks.
+	Name string `json:"name"`
+	// Type is one of the registered sink types: "csv", "jsonl",
+	// "webhook", or one added by a plugin.
+	Type string `json:"type"`
+	// Spool, if set, keeps the readings that the sink fails to
+	// deliver on disk, and delivers them later. A sink with a spool
+	// needs a name.
+	Spool *SpoolConfig `json:"spool"`
+	// Retry, if set, retries failed writes to the sink. It can't be
+	// combined with a spool, which retries on its own.
+	Retry *RetryConfig `json:"retry"`
+	// Pipeline, if set, transforms the readings before they reach
+	// the sink.
+	Pipeline []PipelineStep `json:"pipeline"`
+	// Options is the whole JSON object describing the sink, which is
+	// passed to its factory. It holds the settings of the sink type,
+	// like the path of the csv and jsonl sinks ("-" means standard
+	// output), and the url of the webhook sink.
+	Options json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (sc *SinkConfig) UnmarshalJSON(b []byte) error {
+	type plain SinkConfig
+	if err := json.Unmarshal(b, (*plain)(sc)); err != nil {
+		return err
+	}
+	sc.Options = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// PipelineStep is a single step of the pipeline of a sink. Exactly one
+// of its fields should be set.
+type PipelineStep struct {
+	// Average replaces the readings of every sensor with their
+	// averages over windows of this length.
+	Average Duration `json:"average"`
+	// Above drops the readings that don't exceed either of the
+	// given levels.
+	Above *Levels `json:"above"`
+	// AQI replaces the PM levels with the US EPA Air Quality Index
+	// values of their NowCast.
+	AQI bool `json:"aqi"`
+	// Labels drops all labels but these.
+	Labels []string `json:"labels"`
+}
+
+// EnricherConfig describes an enricher (see sink.Enricher).
+type EnricherConfig struct {
+	// Type is one of the registered enricher types: "file",
+	// "static", or one added by a plugin.
+	Type string `json:"type"`
+	// Sensors are the names of the sensors whose readings the
+	// enricher adds to. If it's empty, it adds to all of them.
+	Sensors []string `json:"sensors"`
+	// Options is the whole JSON object describing the enricher,
+	// which is passed to its factory, like the path of the file
+	// enricher and the fields of the static one.
+	Options json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (ec *EnricherConfig) UnmarshalJSON(b []byte) error {
+	type plain EnricherConfig
+	if err := json.Unmarshal(b, (*plain)(ec)); err != nil {
+		return err
+	}
+	ec.Options = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// Levels are PM2.5 and PM10 levels, either of which may be left out.
+type Levels struct {
+	PM25 *float64 `json:"pm25"`
+	PM10 *float64 `json:"pm10"`
+}
+
+// RetryConfig describes how a sink retries failed writes. Readings
+// are retried in the background, so a slow sink doesn't hold up the
+// others.
+type RetryConfig struct {
+	// MaxAttempts is how many times a reading is tried before giving
+	// up on it. It defaults to 5.
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff is how long to wait after the first failure. It
+	// doubles after each subsequent one, up to MaxBackoff. They
+	// default to 1s and 1m.
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+	// DeadLetter is a file the readings that couldn't be delivered
+	// are appended to, as JSON, together with the last error. If
+	// it's empty, they are dropped.
+	DeadLetter string `json:"dead_letter"`
+}
+
+// SpoolConfig describes the on-disk queue of a sink.
+type SpoolConfig struct {
+	// Dir is the directory holding the queue. Every sink needs its
+	// own.
+	Dir string `json:"dir"`
+	// MaxReadings is the most readings the queue holds. When it's
+	// full, the oldest readings are dropped. It defaults to 100000,
+	// about three days of readings taken every 3 seconds.
+	MaxReadings int `json:"max_readings"`
+	// RetryInterval is how often to try delivering the queued
+	// readings. It defaults to 30s.
+	RetryInterval Duration `json:"retry_interval"`
+}
+
+const (
+	defaultWarmup            = 30 * time.Second
+	defaultAdaptiveClear     = 0.8
+	defaultHistoryRetention  = 48 * time.Hour
+	defaultHistoryResolution = time.Minute
+	defaultStoreRetention    = 90 * 24 * time.Hour
+	defaultRawRetention      = 7 * 24 * time.Hour
+	defaultOTLPInterval      = time.Minute
+	defaultHeartbeatInterval = time.Minute
+	defaultStreamBuffer      = 16
+	defaultSNMPCommunity     = "public"
+	defaultMQTTTopic         = "sds011"
+	defaultSpoolMaxReadings  = 100000
+	defaultSpoolRetry        = 30 * time.Second
+	defaultRetryAttempts     = 5
+	defaultRetryBackoff      = time.Second
+	defaultRetryMaxBackoff   = time.Minute
+)
+
+// loadConfig reads the config file at path, fills in the defaults
+// and validates it. old is the config in use, if any, which the
+// sensors of unchanged peers are taken from.
+func loadConfig(path string, old *Config) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := new(Config)
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := config.validate(old); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return config, nil
+}
+
+// validate checks the config for errors, filling in the defaults on
+// the way. old is passed on to federate.
+func (config *Config) validate(old *Config) error {
+	if err := config.federate(old); err != nil {
+		return err
+	}
+	if len(config.Sensors) == 0 {
+		return errors.New("no sensors configured")
+	}
+	// The readings published to the MQTT broker are enough for a
+	// small setup.
+	if len(config.Sinks) == 0 && config.MQTT.Address == "" {
+		return errors.New("no sinks configured")
+	}
+	names := make(map[string]bool)
+	for i := range config.Sensors {
+		sc := &config.Sensors[i]
+		switch u := sc.USB; {
+		case sc.remote != nil:
+			// Federated sensors have no port, and are named by
+			// federate.
+		case u != nil:
+			if u.VendorID == "" || u.ProductID == "" {
+				return fmt.Errorf("sensor %d: usb needs vendor_id and product_id", i)
+			}
+			if sc.Name == "" {
+				return fmt.Errorf("sensor %d: name is required with usb", i)
+			}
+			for j := range config.Sensors[:i] {
+				if other := config.Sensors[j].USB; other != nil && u.overlaps(other) {
+					return fmt.Errorf("sensor %d: usb matches the same adapter as sensor %d; set serial on both to tell them apart", i, j)
+				}
+			}
+		case sc.PortPath == "":
+			return fmt.Errorf("sensor %d: port_path or usb is required", i)
+		}
+		if sc.Name == "" {
+			sc.Name = sc.PortPath
+		}
+		if names[sc.Name] {
+			return fmt.Errorf("sensor %d: duplicate name %q", i, sc.Name)
+		}
+		names[sc.Name] = true
+		if sc.Interval.Duration < 0 {
+			return fmt.Errorf("sensor %q: negative interval", sc.Name)
+		}
+		if sc.Warmup.Duration == 0 {
+			sc.Warmup.Duration = defaultWarmup
+		}
+		if sc.Interval.Duration > 0 && sc.Warmup.Duration >= sc.Interval.Duration {
+			return fmt.Errorf("sensor %q: warmup (%v) should be shorter than interval (%v)", sc.Name, sc.Warmup, sc.Interval)
+		}
+		if sc.Samples == 0 {
+			sc.Samples = 1
+		}
+		if sc.Samples < 0 {
+			return fmt.Errorf("sensor %q: bad samples value %v", sc.Name, sc.Samples)
+		}
+		if ac := sc.Adaptive; ac != nil {
+			if sc.Interval.Duration == 0 {
+				return fmt.Errorf("sensor %q: adaptive needs an interval", sc.Name)
+			}
+			if ac.Interval.Duration <= sc.Warmup.Duration || ac.Interval.Duration >= sc.Interval.Duration {
+				return fmt.Errorf("sensor %q: adaptive interval (%v) should be between warmup (%v) and interval (%v)", sc.Name, ac.Interval, sc.Warmup, sc.Interval)
+			}
+			if ac.PM25 < 0 || ac.PM10 < 0 || ac.PM25 == 0 && ac.PM10 == 0 {
+				return fmt.Errorf("sensor %q: adaptive needs a positive pm25 or pm10 threshold", sc.Name)
+			}
+			if ac.Clear == 0 {
+				ac.Clear = defaultAdaptiveClear
+			}
+			if ac.Clear < 0 || ac.Clear > 1 {
+				return fmt.Errorf("sensor %q: bad adaptive clear value %v, want between 0 and 1", sc.Name, ac.Clear)
+			}
+		}
+		if _, ok := lines[sc.Serial.RTS]; !ok {
+			return fmt.Errorf("sensor %q: bad rts %q, want \"on\" or \"off\"", sc.Name, sc.Serial.RTS)
+		}
+		if _, ok := lines[sc.Serial.DTR]; !ok {
+			return fmt.Errorf("sensor %q: bad dtr %q, want \"on\" or \"off\"", sc.Name, sc.Serial.DTR)
+		}
+		if t := sc.Serial.InterCharacterTimeout.Duration; t < 0 || (t > 0 && t < 100*time.Millisecond) {
+			return fmt.Errorf("sensor %q: inter_character_timeout should be at least 100ms", sc.Name)
+		}
+		if sc.Serial.ReadTimeout.Duration < 0 || sc.Serial.WriteTimeout.Duration < 0 {
+			return fmt.Errorf("sensor %q: negative read_timeout or write_timeout", sc.Name)
+		}
+	}
+	sinks := make(map[string]bool)
+	for i, sc := range config.Sinks {
+		if sc.Name != "" {
+			if sinks[sc.Name] {
+				return fmt.Errorf("sink %d: duplicate name %q", i, sc.Name)
+			}
+			sinks[sc.Name] = true
+		}
+		if sp := sc.Spool; sp != nil {
+			if sc.Name == "" || sp.Dir == "" {
+				return fmt.Errorf("sink %d (%v): a spool needs a sink name and a dir", i, sc.Type)
+			}
+			if sp.MaxReadings == 0 {
+				sp.MaxReadings = defaultSpoolMaxReadings
+			}
+			if sp.RetryInterval.Duration == 0 {
+				sp.RetryInterval.Duration = defaultSpoolRetry
+			}
+			if sp.MaxReadings < 0 || sp.RetryInterval.Duration < 0 {
+				return fmt.Errorf("sink %d (%v): bad spool settings", i, sc.Type)
+			}
+		}
+		if rc := sc.Retry; rc != nil {
+			if sc.Spool != nil {
+				return fmt.Errorf("sink %d (%v): a sink can't have both a spool and a retry policy", i, sc.Type)
+			}
+			if rc.MaxAttempts == 0 {
+				rc.MaxAttempts = defaultRetryAttempts
+			}
+			if rc.Backoff.Duration == 0 {
+				rc.Backoff.Duration = defaultRetryBackoff
+			}
+			if rc.MaxBackoff.Duration == 0 {
+				rc.MaxBackoff.Duration = defaultRetryMaxBackoff
+			}
+			if rc.MaxAttempts < 0 || rc.Backoff.Duration < 0 || rc.MaxBackoff.Duration < rc.Backoff.Duration {
+				return fmt.Errorf("sink %d (%v): bad retry settings", i, sc.Type)
+			}
+		}
+		for j, step := range sc.Pipeline {
+			var set int
+			for _, ok := range []bool{step.Average.Duration != 0, step.Above != nil, step.AQI, step.Labels != nil} {
+				if ok {
+					set++
+				}
+			}
+			if set != 1 {
+				return fmt.Errorf("sink %d (%v): pipeline step %d should do exactly one thing", i, sc.Type, j)
+			}
+			if step.Average.Duration < 0 {
+				return fmt.Errorf("sink %d (%v): pipeline step %d: negative average", i, sc.Type, j)
+			}
+			if step.Above != nil && step.Above.PM25 == nil && step.Above.PM10 == nil {
+				return fmt.Errorf("sink %d (%v): pipeline step %d: no levels to be above", i, sc.Type, j)
+			}
+		}
+		if !sink.Registered(sc.Type) {
+			return fmt.Errorf("sink %d: unknown type %q", i, sc.Type)
+		}
+	}
+	for i, ec := range config.Enrichers {
+		if !sink.EnricherRegistered(ec.Type) {
+			return fmt.Errorf("enricher %d: unknown type %q", i, ec.Type)
+		}
+		for _, s := range ec.Sensors {
+			if !names[s] {
+				return fmt.Errorf("enricher %d (%v): unknown sensor %q", i, ec.Type, s)
+			}
+		}
+	}
+	for _, sc := range config.Sensors {
+		for _, name := range sc.Sinks {
+			if !sinks[name] {
+				return fmt.Errorf("sensor %q: unknown sink %q", sc.Name, name)
+			}
+		}
+	}
+	hc := &config.History
+	if hc.Retention.Duration == 0 {
+		hc.Retention.Duration = defaultHistoryRetention
+	}
+	if hc.Resolution.Duration == 0 {
+		hc.Resolution.Duration = defaultHistoryResolution
+	}
+	if hc.Resolution.Duration < 0 || hc.Retention.Duration < hc.Resolution.Duration {
+		return fmt.Errorf("history: retention (%v) should be longer than resolution (%v)", hc.Retention, hc.Resolution)
+	}
+	if sc := &config.Store; sc.Backend == "" && sc.Path != "" {
+		sc.Backend = "files"
+	}
+	if sc := &config.Store; sc.Backend != "" {
+		known := false
+		for _, b := range store.Backends() {
+			known = known || b == sc.Backend
+		}
+		if !known {
+			return fmt.Errorf("store: unknown backend %q, want one of %v", sc.Backend, strings.Join(store.Backends(), ", "))
+		}
+		if sc.Backend != "memory" && sc.Path == "" {
+			return fmt.Errorf("store: %v needs a path", sc.Backend)
+		}
+		if sc.Backend == "memory" && sc.Path != "" {
+			return errors.New("store: memory has no path")
+		}
+		if sc.Backend != "files" && len(sc.Downsample) > 0 {
+			return fmt.Errorf("store: %v has no downsample", sc.Backend)
+		}
+		if sc.Backend == "files" && sc.Downsample == nil {
+			sc.Downsample = []DownsampleConfig{
+				{Resolution: Duration{5 * time.Minute}, Retention: Duration{90 * 24 * time.Hour}},
+				{Resolution: Duration{time.Hour}},
+			}
+		}
+		if sc.Retention.Duration == 0 {
+			sc.Retention.Duration = defaultStoreRetention
+			if sc.Backend == "memory" || len(sc.Downsample) > 0 {
+				sc.Retention.Duration = defaultRawRetention
+			}
+		}
+		if sc.Retention.Duration < 0 {
+			return errors.New("store: negative retention")
+		}
+		for i, tier := range sc.Downsample {
+			res := tier.Resolution.Duration
+			if res <= 0 || (24*time.Hour)%res != 0 {
+				return fmt.Errorf("store: downsample %d: resolution %v doesn't divide a day", i, tier.Resolution)
+			}
+			if i > 0 && res <= sc.Downsample[i-1].Resolution.Duration {
+				return fmt.Errorf("store: downsample %d: resolutions should increase", i)
+			}
+			if tier.Retention.Duration < 0 {
+				return fmt.Errorf("store: downsample %d: negative retention", i)
+			}
+		}
+		// Days are downsampled after they end, so the raw readings
+		// should be kept for a while longer.
+		if len(sc.Downsample) > 0 && sc.Retention.Duration < 48*time.Hour {
+			return errors.New("store: retention should be at least 48h when downsampling")
+		}
+	}
+	if sc := &config.SNMP; sc.Address != "" {
+		if sc.Community == "" {
+			sc.Community = defaultSNMPCommunity
+		}
+		if sc.RootOID == "" {
+			return errors.New("snmp: root_oid is required, under an enterprise number assigned to you")
+		}
+		if _, err := parseOID(sc.RootOID); err != nil {
+			return fmt.Errorf("snmp: %v", err)
+		}
+	}
+	if mc := &config.MQTT; mc.Address != "" {
+		if mc.Topic == "" {
+			mc.Topic = defaultMQTTTopic
+		}
+		if strings.ContainsAny(mc.Topic, "+#") || strings.ContainsAny(mc.Discovery, "+#") {
+			return errors.New("mqtt: topic and discovery can't have wildcards")
+		}
+		if mc.Password != "" && mc.Username == "" {
+			return errors.New("mqtt: password requires a username")
+		}
+	}
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return errors.New("tls: both cert_file and key_file are required")
+	}
+	if config.MDNS.Enabled && config.HTTPAddress == "" && config.RPCAddress == "" {
+		return errors.New("mdns: requires http_address or rpc_address")
+	}
+	if len(config.MDNS.Instance) > dnsMaxLabel {
+		return fmt.Errorf("mdns: instance is longer than %d bytes", dnsMaxLabel)
+	}
+	if oc := &config.OTLP; oc.Endpoint != "" {
+		if oc.Interval.Duration == 0 {
+			oc.Interval.Duration = defaultOTLPInterval
+		}
+		if oc.Interval.Duration < 0 {
+			return errors.New("otlp: negative interval")
+		}
+	}
+	if sc := &config.Streams; sc.Buffer == 0 {
+		sc.Buffer = defaultStreamBuffer
+	} else if sc.Buffer < 0 || sc.Buffer > maxSubscriberBuffer {
+		return fmt.Errorf("streams: buffer should be between 1 and %d", maxSubscriberBuffer)
+	}
+	if config.Streams.Policy == "" {
+		config.Streams.Policy = string(dropNewest)
+	} else if !backpressures[backpressure(config.Streams.Policy)] {
+		return fmt.Errorf("streams: unknown policy %q", config.Streams.Policy)
+	}
+	if config.Averages == nil {
+		config.Averages = []Duration{{time.Minute}, {time.Hour}}
+	}
+	for _, w := range config.Averages {
+		if w.Duration <= 0 {
+			return fmt.Errorf("averages: bad window %v", w)
+		}
+	}
+	if hc := &config.Heartbeat; hc.Interval.Duration == 0 {
+		hc.Interval.Duration = defaultHeartbeatInterval
+	} else if hc.Interval.Duration < 0 {
+		return errors.New("heartbeat: negative interval")
+	}
+	for _, name := range config.Exceedances.Limits {
+		if _, ok := exceedance.Limits[name]; !ok {
+			return fmt.Errorf("exceedances: unknown limit %q", name)
+		}
+	}
+	if err := validateAlerts(&config.Alerts, names); err != nil {
+		return err
+	}
+	rc := &config.Reports
+	for _, period := range rc.Periods {
+		if period != "daily" && period != "weekly" {
+			return fmt.Errorf("reports: unknown period %q", period)
+		}
+	}
+	if rc.Limit == "" {
+		rc.Limit = exceedance.WHO2021.Name
+	}
+	if _, ok := exceedance.Limits[rc.Limit]; !ok {
+		return fmt.Errorf("reports: unknown limit %q", rc.Limit)
+	}
+	if rc.Mail.Address != "" && (rc.Mail.From == "" || len(rc.Mail.To) == 0) {
+		return errors.New("reports: mail needs from and to")
+	}
+	return nil
+}
+
+// validateAlerts checks the alert rules and notifiers. names are the
+// names of the sensors.
+func validateAlerts(ac *AlertsConfig, names map[string]bool) error {
+	rules := make(map[string]bool)
+	for i := range ac.Rules {
+		r := &ac.Rules[i]
+		if r.Name == "" {
+			return fmt.Errorf("alerts: rule %d: name is required", i)
+		}
+		if rules[r.Name] {
+			return fmt.Errorf("alerts: duplicate rule %q", r.Name)
+		}
+		rules[r.Name] = true
+		if _, ok := alert.Metrics[r.Metric]; !ok {
+			return fmt.Errorf("alerts: rule %q: unknown metric %q", r.Name, r.Metric)
+		}
+		if r.Sustain.Duration < 0 || r.Hysteresis < 0 {
+			return fmt.Errorf("alerts: rule %q: negative sustain or hysteresis", r.Name)
+		}
+		for _, s := range r.Sensors {
+			if !names[s] {
+				return fmt.Errorf("alerts: rule %q: unknown sensor %q", r.Name, s)
+			}
+		}
+	}
+	for i, n := range ac.Notifiers {
+		switch n.Type {
+		case "webhook", "slack":
+			if n.URL == "" {
+				return fmt.Errorf("alerts: notifier %d: %v needs a url", i, n.Type)
+			}
+		case "telegram":
+			if n.Token == "" || n.ChatID == "" {
+				return fmt.Errorf("alerts: notifier %d: telegram needs a token and a chat_id", i)
+			}
+		case "email":
+			if n.Mail.Address == "" || n.Mail.From == "" || len(n.Mail.To) == 0 {
+				return fmt.Errorf("alerts: notifier %d: email needs a mail address, from and to", i)
+			}
+		default:
+			return fmt.Errorf("alerts: notifier %d: unknown type %q", i, n.Type)
+		}
+		if _, err := template.New(n.Type).Parse(n.Template); err != nil {
+			return fmt.Errorf("alerts: notifier %d: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseConfig returns the config in text, validated like loadConfig
+// does.
+func parseConfig(text string) (*Config, error) {
+	config := new(Config)
+	if err := json.Unmarshal([]byte(text), config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(nil); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// minimalConfig is the smallest valid config, with extra inserted
+// before the closing brace.
+func minimalConfig(extra string) string {
+	return `{"sensors": [{"name": "kitchen", "port_path": "/dev/ttyUSB0"}], "sinks": [{"type": "csv"}]` + extra + `}`
+}
+
+func TestConfigDefaults(t *testing.T) {
+	config, err := parseConfig(minimalConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := config.Sensors[0]
+	if sc.Samples != 1 || sc.Warmup.Duration != defaultWarmup {
+		t.Errorf("sensor: samples %v, warmup %v, want 1 and %v", sc.Samples, sc.Warmup, defaultWarmup)
+	}
+	if config.History.Retention.Duration != defaultHistoryRetention || config.History.Resolution.Duration != defaultHistoryResolution {
+		t.Errorf("history: %+v, want retention %v and resolution %v", config.History, defaultHistoryRetention, defaultHistoryResolution)
+	}
+	if config.Streams.Buffer != defaultStreamBuffer || config.Streams.Policy != string(dropNewest) {
+		t.Errorf("streams: %+v, want buffer %v and policy %v", config.Streams, defaultStreamBuffer, dropNewest)
+	}
+
+	// A sensor without a name is named after its port.
+	config, err = parseConfig(`{"sensors": [{"port_path": "/dev/ttyUSB0", "interval": "5m"}], "sinks": [{"type": "csv"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc := config.Sensors[0]; sc.Name != "/dev/ttyUSB0" || sc.Interval.Duration != 5*time.Minute {
+		t.Errorf("sensor: name %q, interval %v, want /dev/ttyUSB0 and 5m", sc.Name, sc.Interval)
+	}
+
+	// The files store downsamples, unless told not to; the other
+	// backends keep the raw readings for longer.
+	day := 24 * time.Hour
+	tiers := []DownsampleConfig{{Resolution: Duration{5 * time.Minute}, Retention: Duration{90 * day}}, {Resolution: Duration{time.Hour}}}
+	for _, tc := range []struct {
+		store     string
+		retention time.Duration
+		tiers     []DownsampleConfig
+	}{
+		{`{"path": "/var/lib/sds011d"}`, 7 * day, tiers},
+		{`{"path": "/var/lib/sds011d", "retention": "720h"}`, 30 * day, tiers},
+		{`{"path": "/var/lib/sds011d", "downsample": []}`, 90 * day, []DownsampleConfig{}},
+		{`{"backend": "bbolt", "path": "/var/lib/sds011d.db"}`, 90 * day, nil},
+		{`{"backend": "memory"}`, 7 * day, nil},
+	} {
+		config, err := parseConfig(minimalConfig(`, "store": ` + tc.store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc := config.Store; sc.Retention.Duration != tc.retention || !reflect.DeepEqual(sc.Downsample, tc.tiers) {
+			t.Errorf("store %v: retention %v, downsample %v, want %v and %v", tc.store, sc.Retention, sc.Downsample, tc.retention, tc.tiers)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config, want string
+	}{
+		{`{"sinks": [{"type": "csv"}]}`, "no sensors"},
+		{`{"sensors": [{"port_path": "/dev/ttyUSB0"}]}`, "no sinks"},
+		{`{"sensors": [{"name": "kitchen"}], "sinks": [{"type": "csv"}]}`, "port_path or usb is required"},
+		{`{"sensors": [{"port_path": "/dev/a", "name": "x"}, {"port_path": "/dev/b", "name": "x"}], "sinks": [{"type": "csv"}]}`, `duplicate name "x"`},
+		{`{"sensors": [{"name": "a", "usb": {"vendor_id": "1a86", "product_id": "7523"}}, {"name": "b", "usb": {"vendor_id": "1a86", "product_id": "7523"}}], "sinks": [{"type": "csv"}]}`, "matches the same adapter as sensor 0"},
+		{`{"sensors": [{"name": "a", "usb": {"vendor_id": "1a86", "product_id": "7523", "serial": "A1"}}, {"name": "b", "usb": {"vendor_id": "1a86", "product_id": "7523"}}], "sinks": [{"type": "csv"}]}`, "matches the same adapter as sensor 0"},
+		{`{"sensors": [{"port_path": "/dev/a", "interval": "20s", "warmup": "30s"}], "sinks": [{"type": "csv"}]}`, "warmup (30s) should be shorter than interval (20s)"},
+		{`{"sensors": [{"port_path": "/dev/a", "samples": -1}], "sinks": [{"type": "csv"}]}`, "bad samples value"},
+		{`{"sensors": [{"port_path": "/dev/a", "serial": {"read_timeout": "-1s"}}], "sinks": [{"type": "csv"}]}`, "negative read_timeout"},
+		{`{"sensors": [{"port_path": "/dev/a", "sinks": ["nope"]}], "sinks": [{"type": "csv"}]}`, `unknown sink "nope"`},
+		{`{"sensors": [{"port_path": "/dev/a"}], "sinks": [{"type": "nope"}]}`, `unknown type "nope"`},
+		{minimalConfig(`, "history": {"retention": "1m", "resolution": "5m"}`), "retention (1m0s) should be longer than resolution (5m0s)"},
+		{minimalConfig(`, "streams": {"policy": "nope"}`), `unknown policy "nope"`},
+		{minimalConfig(`, "tls": {"cert_file": "cert.pem"}`), "both cert_file and key_file"},
+		{minimalConfig(`, "snmp": {"address": ":161"}`), "root_oid is required"},
+		{minimalConfig(`, "snmp": {"address": ":161", "root_oid": "1.3.six"}`), "snmp: "},
+		{minimalConfig(`, "http_address": ":8011", "mdns": {"enabled": true, "instance": "` + strings.Repeat("x", 64) + `"}`), "instance is longer than 63 bytes"},
+	} {
+		_, err := parseConfig(tc.config)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want an error with %q", tc.config, err, tc.want)
+		}
+	}
+}
+
+func TestConfigFederate(t *testing.T) {
+	// Nothing listens at the address, so listing the sensors of the
+	// peer fails unless they are taken from the old config.
+	peer := func(address string) string {
+		return `{"peers": [{"name": "attic", "address": "` + address + `"}], "sinks": [{"type": "csv"}]}`
+	}
+	if _, err := parseConfig(peer("127.0.0.1:1")); err == nil || !strings.Contains(err.Error(), "listing its sensors") {
+		t.Fatalf("without an old config: %v, want an error listing the sensors", err)
+	}
+	old := &Config{Peers: []PeerConfig{{Name: "attic", Address: "127.0.0.1:1"}}}
+	for _, s := range []string{"a", "b"} {
+		old.Sensors = append(old.Sensors, SensorConfig{Name: "attic/" + s, remote: &remoteSensor{peer: &old.Peers[0], sensor: s}})
+	}
+
+	config := new(Config)
+	if err := json.Unmarshal([]byte(peer("127.0.0.1:1")), config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.validate(old); err != nil {
+		t.Fatalf("an unchanged peer: %v", err)
+	}
+	var names []string
+	for _, sc := range config.Sensors {
+		names = append(names, sc.Name)
+	}
+	if want := []string{"attic/a", "attic/b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sensors: %v, want %v", names, want)
+	}
+
+	config = new(Config)
+	if err := json.Unmarshal([]byte(peer("127.0.0.1:2")), config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.validate(old); err == nil {
+		t.Errorf("a changed peer: got no error, want its sensors listed again")
+	}
+}
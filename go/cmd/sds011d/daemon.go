@@ -0,0 +1,281 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/remote"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// daemon ties together the collectors, the hub and the history, and
+// is what the remote APIs talk to.
+type daemon struct {
+	names      []string
+	collectors map[string]*collector
+	hub        *hub
+	history    *history
+	streams    StreamsConfig
+	averages   *averages
+	// degradations are the signs of failing sensors.
+	degradations *degradations
+	// exceedances is nil if the daemon doesn't check the limits.
+	exceedances *exceedances
+	// reports is nil if the daemon doesn't make reports.
+	reports *reports
+	// alerts has no rules until some are configured, possibly by a
+	// reload.
+	alerts *alerts
+	// store is nil if the daemon doesn't store readings.
+	store   store.Store
+	metrics *exporter.Metrics
+	// heartbeat reports on the health of the daemon.
+	heartbeat *heartbeater
+	// controlToken, if not empty, is required to control the
+	// sensors.
+	controlToken string
+	// auth, if it has any tokens or users, is required for
+	// everything.
+	auth AuthConfig
+}
+
+func newDaemon(h *hub, hist *history, st store.Store, config *Config) *daemon {
+	metrics := exporter.NewMetrics()
+	d := &daemon{
+		controlToken: config.ControlToken,
+		auth:         config.Auth,
+		collectors:   make(map[string]*collector),
+		hub:          h,
+		history:      hist,
+		store:        st,
+		averages:     newAverages(config.Averages, metrics),
+		degradations: newDegradations(metrics),
+		streams:      config.Streams,
+		metrics:      metrics,
+	}
+	d.heartbeat = newHeartbeater(d, config.Heartbeat)
+	if len(config.Exceedances.Limits) > 0 {
+		d.exceedances = newExceedances(config.Exceedances)
+	}
+	if len(config.Reports.Periods) > 0 {
+		d.reports = newReports(config.Reports)
+	}
+	return d
+}
+
+func (d *daemon) add(c *collector) {
+	d.names = append(d.names, c.config.Name)
+	d.collectors[c.config.Name] = c
+}
+
+// The health of a sensor, as reported by the Modbus and SNMP servers.
+const (
+	statusNoData = 0
+	statusOK     = 1
+	statusFailed = 2
+)
+
+// status returns the health of sensor.
+func (d *daemon) status(sensor string) int {
+	switch {
+	case d.collectors[sensor].failing():
+		return statusFailed
+	case d.hub.Latest(sensor) != nil:
+		return statusOK
+	}
+	return statusNoData
+}
+
+func (d *daemon) collector(sensor string) (*collector, error) {
+	c, ok := d.collectors[sensor]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", remote.ErrUnknownSensor, sensor)
+	}
+	return c, nil
+}
+
+func toMeasurement(r *sink.Reading) *remote.Measurement {
+	return &remote.Measurement{
+		Sensor:    r.Sensor,
+		PM25:      r.PM25,
+		PM10:      r.PM10,
+		Timestamp: r.Timestamp,
+		PM1:       r.PM1,
+		HasPM1:    r.HasPM1,
+		Quality:   r.Quality,
+		Labels:    r.Labels,
+	}
+}
+
+// Sensors implements remote.Backend.
+func (d *daemon) Sensors() []string {
+	return d.names
+}
+
+// Latest implements remote.Backend.
+func (d *daemon) Latest(sensor string) (*remote.Measurement, error) {
+	if _, err := d.collector(sensor); err != nil {
+		return nil, err
+	}
+	r := d.hub.Latest(sensor)
+	if r == nil {
+		return nil, fmt.Errorf("no measurements from %q yet", sensor)
+	}
+	return toMeasurement(r), nil
+}
+
+// Next implements remote.Backend.
+func (d *daemon) Next(ctx context.Context, sensor string, after time.Time) (*remote.Measurement, error) {
+	if _, err := d.collector(sensor); err != nil {
+		return nil, err
+	}
+	// Only the newest reading matters.
+	ch := d.hub.subscribe(subscription{sensor: sensor, policy: dropOldest}, 1)
+	defer d.hub.unsubscribe(ch)
+	if r := d.hub.Latest(sensor); r != nil && r.Timestamp.After(after) {
+		return toMeasurement(r), nil
+	}
+	for {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				return nil, errors.New("shutting down")
+			}
+			if r.Timestamp.After(after) {
+				return toMeasurement(r), nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Info implements remote.Backend.
+func (d *daemon) Info(sensor string) (*remote.Info, error) {
+	c, err := d.collector(sensor)
+	if err != nil {
+		return nil, err
+	}
+	info := &remote.Info{Sensor: sensor, PortPath: c.currentPort()}
+	err = c.do(func(s *sds011.Sensor) (err error) {
+		port := s.Port()
+		info.Device, info.ByID = port.Device, port.ByID
+		if info.DeviceID, err = s.DeviceID(); err != nil {
+			return err
+		}
+		if info.Firmware, err = s.Firmware(); err != nil {
+			return err
+		}
+		mode, err := s.ReportMode()
+		if err != nil {
+			return err
+		}
+		info.Active = mode == sds011.ActiveMode
+		if info.Cycle, err = s.Cycle(); err != nil {
+			return err
+		}
+		info.Awake, err = s.IsAwake()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetCycle implements remote.Backend.
+func (d *daemon) SetCycle(sensor string, minutes uint8) error {
+	c, err := d.collector(sensor)
+	if err != nil {
+		return err
+	}
+	if minutes > 30 {
+		return fmt.Errorf("%w: cycle of %d minutes, want 0 to 30", remote.ErrInvalidArgument, minutes)
+	}
+	err = c.do(func(s *sds011.Sensor) error {
+		return s.SetCycle(minutes)
+	})
+	if err != nil {
+		return err
+	}
+	c.metrics.SetCycle(sensor, minutes)
+	return nil
+}
+
+// Sleep implements remote.Backend.
+func (d *daemon) Sleep(sensor string) error {
+	c, err := d.collector(sensor)
+	if err != nil {
+		return err
+	}
+	err = c.do(func(s *sds011.Sensor) error {
+		if err := s.Sleep(); err != nil {
+			return err
+		}
+		c.asleep = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.metrics.SetAwake(sensor, false)
+	return nil
+}
+
+// Wake implements remote.Backend.
+func (d *daemon) Wake(sensor string) error {
+	c, err := d.collector(sensor)
+	if err != nil {
+		return err
+	}
+	err = c.do(func(s *sds011.Sensor) error {
+		if err := s.Awake(); err != nil {
+			return err
+		}
+		c.asleep = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.metrics.SetAwake(sensor, true)
+	return nil
+}
+
+// SetReportMode implements remote.Backend.
+func (d *daemon) SetReportMode(sensor string, active bool) error {
+	c, err := d.collector(sensor)
+	if err != nil {
+		return err
+	}
+	mode := sds011.QueryMode
+	if active {
+		mode = sds011.ActiveMode
+	}
+	return c.do(func(s *sds011.Sensor) error {
+		if err := s.SetReportMode(mode); err != nil {
+			return err
+		}
+		c.passive = !active
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/degradation"
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// degradations looks for signs that the sensors are failing in their
+// raw readings, before calibration, and reports them in the metrics
+// and the heartbeat.
+type degradations struct {
+	metrics *exporter.Metrics
+
+	mu       sync.Mutex
+	detector *degradation.Detector
+	// problems are the problems each sensor has, for logging the
+	// changes.
+	problems map[string]map[string]bool
+}
+
+func newDegradations(metrics *exporter.Metrics) *degradations {
+	return &degradations{
+		metrics:  metrics,
+		detector: degradation.NewDetector(),
+		problems: make(map[string]map[string]bool),
+	}
+}
+
+// observe looks at a reading of sensor.
+func (x *degradations) observe(sensor string, point *sds011.Point) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.detector.Add(sensor, *point)
+	problems := make(map[string]bool)
+	for _, p := range degradation.Problems {
+		problems[string(p)] = false
+	}
+	for _, f := range x.detector.Findings(sensor) {
+		problems[string(f.Problem)] = true
+		if !x.problems[sensor][string(f.Problem)] {
+			log.Warningf("%v: %v", sensor, &f)
+		}
+	}
+	for p, was := range x.problems[sensor] {
+		if was && !problems[p] {
+			log.Infof("%v: no longer %v", sensor, p)
+		}
+	}
+	x.problems[sensor] = problems
+	x.metrics.SetProblems(sensor, problems)
+}
+
+// Findings returns the problems sensor seems to have.
+func (x *degradations) Findings(sensor string) []degradation.Finding {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.detector.Findings(sensor)
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// enrichment applies the enrichers of the config to the readings, in
+// order.
+type enrichment []*enricher
+
+type enricher struct {
+	sink.Enricher
+	name string
+	// sensors are the sensors the enricher applies to, or nil if it
+	// applies to all of them.
+	sensors map[string]bool
+	// failing is the last error of the enricher, so that a failure
+	// that lasts is logged once rather than for every reading.
+	failing string
+}
+
+// newEnrichment creates the enrichers described by configs.
+func newEnrichment(configs []EnricherConfig) (enrichment, error) {
+	var e enrichment
+	for i, ec := range configs {
+		en, err := sink.NewEnricher(ec.Type, ec.Options)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("enricher %d (%v): %v", i, ec.Type, err)
+		}
+		ed := &enricher{Enricher: en, name: fmt.Sprintf("enricher %d (%v)", i, ec.Type)}
+		if len(ec.Sensors) > 0 {
+			ed.sensors = make(map[string]bool)
+			for _, s := range ec.Sensors {
+				ed.sensors[s] = true
+			}
+		}
+		e = append(e, ed)
+	}
+	return e, nil
+}
+
+// enrich applies the enrichers to r. A reading an enricher fails on
+// is passed on without what it would have added.
+func (e enrichment) enrich(r *sink.Reading) {
+	for _, en := range e {
+		if en.sensors != nil && !en.sensors[r.Sensor] {
+			continue
+		}
+		if err := en.Enrich(r); err != nil {
+			if msg := err.Error(); msg != en.failing {
+				log.Warningf("%v: %v", en.name, err)
+				en.failing = msg
+			}
+		} else if en.failing != "" {
+			log.Infof("%v: working again", en.name)
+			en.failing = ""
+		}
+	}
+}
+
+// Close closes the enrichers.
+func (e enrichment) Close() error {
+	var firstErr error
+	for _, en := range e {
+		if err := en.Enricher.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/exceedance"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// maxRecentExceedances is how many events are kept for the API.
+const maxRecentExceedances = 100
+
+// exceedances is a sink that detects when the 24-hour means of the
+// sensors exceed air quality limits. It keeps the recent events for
+// the API, and POSTs them to an URL if one is configured.
+type exceedances struct {
+	config ExceedancesConfig
+	client *http.Client
+	// queue holds the events to be POSTed.
+	queue chan exceedance.Event
+
+	mu       sync.Mutex
+	detector *exceedance.Detector
+	// recent are the latest events, oldest first.
+	recent []exceedance.Event
+}
+
+func newExceedances(config ExceedancesConfig) *exceedances {
+	var limits []exceedance.Limit
+	for _, name := range config.Limits {
+		limits = append(limits, exceedance.Limits[name])
+	}
+	return &exceedances{
+		config:   config,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan exceedance.Event, maxRecentExceedances),
+		detector: exceedance.NewDetector(limits...),
+	}
+}
+
+// Write implements sink.Sink.
+func (x *exceedances) Write(r *sink.Reading) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, e := range x.detector.Add(r.Sensor, *r.Point) {
+		log.Infof("exceedance: %v", &e)
+		x.recent = append(x.recent, e)
+		if len(x.recent) > maxRecentExceedances {
+			x.recent = x.recent[len(x.recent)-maxRecentExceedances:]
+		}
+		if x.config.URL == "" {
+			continue
+		}
+		select {
+		case x.queue <- e:
+		default:
+			log.Errorf("exceedance: queue full, not sending %v", &e)
+		}
+	}
+	return nil
+}
+
+// exceedancesJSON is what the API returns about the exceedances of a
+// sensor.
+type exceedancesJSON struct {
+	// Active are the ongoing exceedances, with the highest mean so
+	// far.
+	Active []exceedance.Event `json:"active"`
+	// Recent are the latest events, oldest first.
+	Recent []exceedance.Event `json:"recent"`
+}
+
+// Get returns the exceedances of sensor.
+func (x *exceedances) Get(sensor string) *exceedancesJSON {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	j := &exceedancesJSON{Active: x.detector.Active(sensor), Recent: []exceedance.Event{}}
+	if j.Active == nil {
+		j.Active = []exceedance.Event{}
+	}
+	for _, e := range x.recent {
+		if e.Sensor == sensor {
+			j.Recent = append(j.Recent, e)
+		}
+	}
+	return j
+}
+
+// run POSTs the events until ctx is done.
+func (x *exceedances) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-x.queue:
+			if err := x.send(ctx, &e); err != nil {
+				log.Errorf("exceedance: %v", err)
+			}
+		}
+	}
+}
+
+// send POSTs e to the configured URL.
+func (x *exceedances) send(ctx context.Context, e *exceedance.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", x.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v: %v", x.config.URL, resp.Status)
+	}
+	return nil
+}
+
+// Flush implements sink.Sink.
+func (x *exceedances) Flush() error {
+	return nil
+}
+
+// Close implements sink.Sink.
+func (x *exceedances) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// handleExport serves the history of a sensor between the from and
+// to parameters as a file to download, in CSV (the default) or as a
+// JSON array, as the format parameter says. The readings come from
+// the store if there is one, as they were stored, and from the
+// in-memory history, averaged over its resolution, otherwise.
+func (d *daemon) handleExport(w http.ResponseWriter, r *http.Request) {
+	if err := d.export(w, r); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (d *daemon) export(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method)}
+	}
+	sensor, err := d.sensorParam(r)
+	if err != nil {
+		return err
+	}
+	from, err := timeParam(r, "from")
+	if err != nil {
+		return err
+	}
+	to, err := timeParam(r, "to")
+	if err != nil {
+		return err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if !from.Before(to) {
+		return badRequest("from (%v) should be before to (%v)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var enc exportEncoder
+	switch format {
+	case "csv":
+		enc = &csvExport{}
+		w.Header().Set("Content-Type", "text/csv")
+	case "json":
+		enc = &jsonExport{}
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		return badRequest("bad format %q, want csv or json", format)
+	}
+	name := fmt.Sprintf("%v-%v-%v.%v", sensor, from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	// Once the response has started an error can only cut it short.
+	bw := bufio.NewWriter(w)
+	if err := enc.begin(bw); err != nil {
+		return nil
+	}
+	write := func(b *store.Bucket) error { return enc.write(bw, b) }
+	if d.store != nil {
+		err = d.store.Scan(sensor, from, to, func(day []*sink.Reading) error {
+			for _, rd := range day {
+				b := &store.Bucket{Sensor: sensor, Start: rd.Timestamp}
+				b.Add(rd)
+				if err := write(b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	} else {
+		for _, b := range d.history.Query(sensor, from, to, 0) {
+			if err = write(b); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = enc.end(bw)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		log.Errorf("exporting %v: %v", sensor, err)
+	}
+	return nil
+}
+
+// An exportEncoder writes the buckets of an export.
+type exportEncoder interface {
+	begin(w *bufio.Writer) error
+	write(w *bufio.Writer, b *store.Bucket) error
+	end(w *bufio.Writer) error
+}
+
+// csvExport writes a header and a row per bucket: the RFC3339
+// timestamp, the sensor, the PM levels and how many readings they are
+// the mean of.
+type csvExport struct {
+	cw *csv.Writer
+}
+
+func (e *csvExport) begin(w *bufio.Writer) error {
+	e.cw = csv.NewWriter(w)
+	return e.cw.Write([]string{"timestamp", "sensor", "pm25", "pm10", "samples"})
+}
+
+func (e *csvExport) write(w *bufio.Writer, b *store.Bucket) error {
+	n := float64(b.Count)
+	return e.cw.Write([]string{
+		b.Start.Format(time.RFC3339Nano),
+		b.Sensor,
+		strconv.FormatFloat(b.SumPM25/n, 'f', -1, 64),
+		strconv.FormatFloat(b.SumPM10/n, 'f', -1, 64),
+		strconv.Itoa(b.Count),
+	})
+}
+
+func (e *csvExport) end(w *bufio.Writer) error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// jsonExport writes the buckets as a JSON array, like the one
+// /v1/measurements returns, with a bucket per line.
+type jsonExport struct {
+	n int
+}
+
+func (e *jsonExport) begin(w *bufio.Writer) error {
+	_, err := w.WriteString("[")
+	return err
+}
+
+func (e *jsonExport) write(w *bufio.Writer, b *store.Bucket) error {
+	j, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if e.n > 0 {
+		w.WriteString(",")
+	}
+	e.n++
+	w.WriteString("\n")
+	_, err = w.Write(j)
+	return err
+}
+
+func (e *jsonExport) end(w *bufio.Writer) error {
+	_, err := w.WriteString("\n]\n")
+	return err
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/remote"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A remoteSensor is a sensor of a peer, federated as one of the
+// daemon's own.
+type remoteSensor struct {
+	peer *PeerConfig
+	// sensor is its name on the peer.
+	sensor string
+}
+
+// federate adds the sensors of the peers to the config, listing them
+// from the peers that don't say which. The sensors of a peer set up
+// the same way in old, the config in use if it's not nil, are taken
+// from there instead of listing them again.
+func (config *Config) federate(old *Config) error {
+	peers := make(map[string]bool)
+	for i := range config.Peers {
+		p := &config.Peers[i]
+		if p.Name == "" || p.Address == "" {
+			return fmt.Errorf("peer %d: name and address are required", i)
+		}
+		if peers[p.Name] {
+			return fmt.Errorf("peer %d: duplicate name %q", i, p.Name)
+		}
+		peers[p.Name] = true
+		if p.CAFile != "" && !p.TLS {
+			return fmt.Errorf("peer %q: ca_file needs tls", p.Name)
+		}
+		sensors := p.Sensors
+		if len(sensors) == 0 {
+			sensors = old.federated(p)
+		}
+		if len(sensors) == 0 {
+			client, err := p.dial()
+			if err != nil {
+				return fmt.Errorf("peer %q: listing its sensors: %v", p.Name, err)
+			}
+			sensors, err = client.ListSensors()
+			client.Close()
+			if err != nil {
+				return fmt.Errorf("peer %q: listing its sensors: %v", p.Name, err)
+			}
+		}
+		for _, s := range sensors {
+			config.Sensors = append(config.Sensors, SensorConfig{
+				Name:   p.Name + "/" + s,
+				remote: &remoteSensor{peer: p, sensor: s},
+			})
+		}
+	}
+	return nil
+}
+
+// federated returns the sensors federated from a peer set up like p,
+// or nil if there's no such peer. config may be nil.
+func (config *Config) federated(p *PeerConfig) []string {
+	if config == nil {
+		return nil
+	}
+	var sensors []string
+	for _, sc := range config.Sensors {
+		if sc.remote != nil && reflect.DeepEqual(*sc.remote.peer, *p) {
+			sensors = append(sensors, sc.remote.sensor)
+		}
+	}
+	return sensors
+}
+
+// dial connects to the RPC service of the peer.
+func (p *PeerConfig) dial() (*remote.Client, error) {
+	var client *remote.Client
+	var err error
+	if p.TLS {
+		var config tls.Config
+		if p.CAFile != "" {
+			pem, err := os.ReadFile(p.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %v", p.CAFile)
+			}
+		}
+		client, err = remote.DialTLS(p.Address, &config)
+	} else {
+		client, err = remote.Dial(p.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(p.Token)
+	return client, nil
+}
+
+// errFederated is returned for the commands sent to federated sensors,
+// which only their own daemons control.
+var errFederated = errors.New("the sensor is federated from another daemon; control it there")
+
+// runRemote streams the measurements of a federated sensor from its
+// peer until ctx is done, connecting again whenever the connection is
+// lost.
+func (c *collector) runRemote(ctx context.Context) {
+	r := c.config.remote
+	var lastErr string
+	for {
+		err := c.stream(ctx, r)
+		if ctx.Err() != nil {
+			return
+		}
+		c.failed.Store(true)
+		c.metrics.ObserveError(c.config.Name, err)
+		// Don't repeat the same error every few seconds.
+		if err.Error() != lastErr {
+			log.Errorf("%v: %v", c.config.Name, err)
+			lastErr = err.Error()
+		}
+		if !sleep(ctx, attachDelay) {
+			return
+		}
+	}
+}
+
+// stream connects to the peer of r, and passes on the measurements of
+// r until ctx is done or the connection fails.
+func (c *collector) stream(ctx context.Context, r *remoteSensor) error {
+	client, err := r.peer.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	log.Infof("%v: connected to %v", c.config.Name, r.peer.Address)
+	return client.StreamMeasurements(ctx, r.sensor, func(m *remote.Measurement) error {
+		point := &sds011.Point{
+			PM25:      m.PM25,
+			PM10:      m.PM10,
+			Timestamp: m.Timestamp,
+			PM1:       m.PM1,
+			HasPM1:    m.HasPM1,
+			Quality:   m.Quality,
+		}
+		if old := c.remoteLabels.Load(); old == nil || !reflect.DeepEqual(*old, m.Labels) {
+			c.remoteLabels.Store(&m.Labels)
+			c.setLabels()
+		}
+		c.failed.Store(false)
+		c.metrics.Observe(c.config.Name, point)
+		if c.otlp != nil {
+			c.otlp.Observe(c.config.Name, point)
+		}
+		c.emit(ctx, point)
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The Grafana datasource, compatible with the JSON datasource plugin
+// (simpod-json-datasource, and the older grafana-simple-json). Point
+// the datasource at http://host:port/grafana. The metrics are called
+// <sensor>.pm25 and <sensor>.pm10:
+//
+//	GET  /grafana/             health check
+//	POST /grafana/search       the available metrics
+//	POST /grafana/metrics      the same, for newer versions of the
+//	                           plugin
+//	POST /grafana/query        time series of the requested metrics
+//	POST /grafana/annotations  no annotations, always empty
+
+// grafanaQuery is the part of a query request we use.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMS    int64 `json:"intervalMs"`
+	MaxDataPoints int   `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is a time series, with datapoints as [value,
+// milliseconds since the epoch] pairs.
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+func (d *daemon) grafanaAPI(mux *http.ServeMux) {
+	mux.Handle("/grafana/", method("GET", func(r *http.Request) (interface{}, error) {
+		return map[string]string{"status": "ok"}, nil
+	}))
+	mux.Handle("/grafana/search", method("POST", d.handleGrafanaSearch))
+	mux.Handle("/grafana/metrics", method("POST", d.handleGrafanaSearch))
+	mux.Handle("/grafana/query", method("POST", d.handleGrafanaQuery))
+	mux.Handle("/grafana/annotations", method("POST", func(r *http.Request) (interface{}, error) {
+		return []struct{}{}, nil
+	}))
+}
+
+func (d *daemon) handleGrafanaSearch(r *http.Request) (interface{}, error) {
+	targets := []string{}
+	for _, name := range d.names {
+		targets = append(targets, name+".pm25", name+".pm10")
+	}
+	return targets, nil
+}
+
+// parseTarget splits a target into the sensor name and the PM level.
+func (d *daemon) parseTarget(target string) (sensor string, pm10 bool, err error) {
+	i := strings.LastIndex(target, ".")
+	if i < 0 {
+		return "", false, badRequest("bad target %q", target)
+	}
+	sensor = target[:i]
+	if _, ok := d.collectors[sensor]; !ok {
+		return "", false, notFound("unknown sensor %q", sensor)
+	}
+	switch target[i+1:] {
+	case "pm25":
+		return sensor, false, nil
+	case "pm10":
+		return sensor, true, nil
+	}
+	return "", false, badRequest("bad target %q", target)
+}
+
+func (d *daemon) handleGrafanaQuery(r *http.Request) (interface{}, error) {
+	var q grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		return nil, badRequest("bad body: %v", err)
+	}
+	resolution := time.Duration(q.IntervalMS) * time.Millisecond
+	if q.MaxDataPoints > 0 {
+		// Don't return more points than Grafana can draw.
+		if min := q.Range.To.Sub(q.Range.From) / time.Duration(q.MaxDataPoints); resolution < min {
+			resolution = min
+		}
+	}
+	series := []*grafanaSeries{}
+	for _, t := range q.Targets {
+		if t.Target == "" {
+			continue
+		}
+		sensor, pm10, err := d.parseTarget(t.Target)
+		if err != nil {
+			return nil, err
+		}
+		buckets, err := d.query(sensor, q.Range.From, q.Range.To, resolution)
+		if err != nil {
+			return nil, err
+		}
+		s := &grafanaSeries{Target: t.Target, Datapoints: make([][2]float64, 0, len(buckets))}
+		for _, b := range buckets {
+			sum := b.SumPM25
+			if pm10 {
+				sum = b.SumPM10
+			}
+			s.Datapoints = append(s.Datapoints, [2]float64{sum / float64(b.Count), float64(b.Start.UnixNano() / int64(time.Millisecond))})
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/degradation"
+	"github.com/ryszard/sds011/go/exporter"
+)
+
+// A heartbeat reports on the health of the daemon itself, so that a
+// dead daemon can be told apart from a quiet one.
+type heartbeat struct {
+	Time          time.Time      `json:"time"`
+	Host          string         `json:"host"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	Sensors       []sensorHealth `json:"sensors"`
+	// Spools are the number of readings waiting in the spool of
+	// every sink that has one.
+	Spools map[string]int `json:"spools,omitempty"`
+}
+
+// sensorHealth is the part of a heartbeat about a single sensor.
+type sensorHealth struct {
+	Name string `json:"name"`
+	// Status is "ok", "failed" or "no_data".
+	Status string `json:"status"`
+	// LastReadAgeSeconds is how long ago the last successful read
+	// was, or nil if there wasn't one.
+	LastReadAgeSeconds *float64 `json:"last_read_age_seconds"`
+	Reads              uint64   `json:"reads"`
+	Errors             uint64   `json:"errors"`
+	// ErrorRate is the fraction of reads that failed since the
+	// previous heartbeat.
+	ErrorRate float64 `json:"error_rate"`
+	// Problems are the signs that the sensor is failing (see
+	// package degradation).
+	Problems []degradation.Finding `json:"problems,omitempty"`
+}
+
+var statusNames = map[int]string{
+	statusNoData: "no_data",
+	statusOK:     "ok",
+	statusFailed: "failed",
+}
+
+// heartbeater makes a heartbeat every interval, and sends it to an URL
+// if one is configured.
+type heartbeater struct {
+	d      *daemon
+	start  time.Time
+	host   string
+	config HeartbeatConfig
+	client *http.Client
+
+	mu sync.Mutex
+	// prev are the counters of every sensor at the last heartbeat.
+	prev map[string]exporter.Health
+}
+
+func newHeartbeater(d *daemon, config HeartbeatConfig) *heartbeater {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Errorf("heartbeat: %v", err)
+	}
+	start := time.Now()
+	d.metrics.SetStartTime(start)
+	return &heartbeater{
+		d:      d,
+		start:  start,
+		host:   host,
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		prev:   make(map[string]exporter.Health),
+	}
+}
+
+// beat returns a heartbeat for now. The error rates are computed
+// since the last recorded heartbeat, and if record is true, this
+// heartbeat is recorded.
+func (hb *heartbeater) beat(now time.Time, record bool) *heartbeat {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	b := &heartbeat{
+		Time:          now,
+		Host:          hb.host,
+		UptimeSeconds: now.Sub(hb.start).Seconds(),
+		Sensors:       []sensorHealth{},
+	}
+	for _, name := range hb.d.names {
+		h := hb.d.metrics.Health(name)
+		sh := sensorHealth{
+			Name:   name,
+			Status: statusNames[hb.d.status(name)],
+			Reads:  h.Reads,
+			Errors: h.Errors,
+		}
+		if !h.LastSuccess.IsZero() {
+			age := now.Sub(h.LastSuccess).Seconds()
+			sh.LastReadAgeSeconds = &age
+		}
+		prev := hb.prev[name]
+		if reads, errors := h.Reads-prev.Reads, h.Errors-prev.Errors; reads+errors > 0 {
+			sh.ErrorRate = float64(errors) / float64(reads+errors)
+		}
+		if record {
+			hb.prev[name] = h
+		}
+		sh.Problems = hb.d.degradations.Findings(name)
+		b.Sensors = append(b.Sensors, sh)
+	}
+	if spools := hb.d.metrics.SpoolDepths(); len(spools) > 0 {
+		b.Spools = spools
+	}
+	return b
+}
+
+// run makes a heartbeat every interval until ctx is done.
+func (hb *heartbeater) run(ctx context.Context) {
+	ticker := time.NewTicker(hb.config.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b := hb.beat(now, true)
+			log.V(1).Infof("heartbeat: up %.0fs", b.UptimeSeconds)
+			if hb.config.URL == "" {
+				continue
+			}
+			if err := hb.send(ctx, b); err != nil {
+				log.Errorf("heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// send POSTs b to the configured URL.
+func (hb *heartbeater) send(ctx context.Context, b *heartbeat) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", hb.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := hb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v: %v", hb.config.URL, resp.Status)
+	}
+	return nil
+}
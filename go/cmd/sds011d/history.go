@@ -0,0 +1,139 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// ring is a fixed size circular buffer of buckets, oldest first.
+type ring struct {
+	buckets []store.Bucket
+	// start is the index of the oldest bucket.
+	start int
+	n     int
+}
+
+func (r *ring) at(i int) *store.Bucket {
+	return &r.buckets[(r.start+i)%len(r.buckets)]
+}
+
+func (r *ring) push(b store.Bucket) {
+	if r.n < len(r.buckets) {
+		*r.at(r.n) = b
+		r.n++
+		return
+	}
+	r.buckets[r.start] = b
+	r.start = (r.start + 1) % len(r.buckets)
+}
+
+// history is a sink that keeps the readings of the last retention
+// period in memory, averaged into buckets resolution long.
+type history struct {
+	resolution time.Duration
+	size       int
+
+	mu    sync.Mutex
+	rings map[string]*ring
+}
+
+func newHistory(retention, resolution time.Duration) *history {
+	return &history{
+		resolution: resolution,
+		size:       int(retention / resolution),
+		rings:      make(map[string]*ring),
+	}
+}
+
+// Write implements sink.Sink.
+func (h *history) Write(r *sink.Reading) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rg, ok := h.rings[r.Sensor]
+	if !ok {
+		rg = &ring{buckets: make([]store.Bucket, h.size)}
+		h.rings[r.Sensor] = rg
+	}
+	start := r.Timestamp.Truncate(h.resolution)
+	// Readings normally come in order, so the bucket they belong to
+	// is the last one or a new one.
+	for i := rg.n - 1; i >= 0; i-- {
+		b := rg.at(i)
+		if b.Start.Equal(start) {
+			b.Add(r)
+			return nil
+		}
+		if b.Start.Before(start) {
+			if i != rg.n-1 {
+				return fmt.Errorf("history: dropping out of order reading from %v", r.Timestamp)
+			}
+			break
+		}
+	}
+	b := store.Bucket{Sensor: r.Sensor, Start: start}
+	b.Add(r)
+	rg.push(b)
+	return nil
+}
+
+// Flush implements sink.Sink.
+func (h *history) Flush() error {
+	return nil
+}
+
+// Close implements sink.Sink.
+func (h *history) Close() error {
+	return nil
+}
+
+// Query returns the buckets of sensor starting in [from, to), merged
+// into buckets of the given resolution. A resolution finer than the
+// one of the history is the same as the history's resolution. A zero
+// to means now.
+func (h *history) Query(sensor string, from, to time.Time, resolution time.Duration) []*store.Bucket {
+	if resolution < h.resolution {
+		resolution = h.resolution
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := []*store.Bucket{}
+	rg, ok := h.rings[sensor]
+	if !ok {
+		return buckets
+	}
+	var last *store.Bucket
+	for i := 0; i < rg.n; i++ {
+		b := rg.at(i)
+		if b.Start.Before(from) || !b.Start.Before(to) {
+			continue
+		}
+		start := b.Start.Truncate(resolution)
+		if last == nil || !last.Start.Equal(start) {
+			last = &store.Bucket{Sensor: sensor, Start: start}
+			buckets = append(buckets, last)
+		}
+		last.Merge(b)
+	}
+	return buckets
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// reading returns a reading of sensor, minutes after t0.
+func reading(sensor string, minutes float64, pm25, pm10 float64) *sink.Reading {
+	return &sink.Reading{Sensor: sensor, Point: &sds011.Point{PM25: pm25, PM10: pm10, Timestamp: t0.Add(time.Duration(minutes * float64(time.Minute)))}}
+}
+
+// means returns the start, relative to t0, and the mean PM2.5 of
+// buckets.
+func means(buckets []*store.Bucket) [][2]float64 {
+	var got [][2]float64
+	for _, b := range buckets {
+		got = append(got, [2]float64{b.Start.Sub(t0).Minutes(), b.SumPM25 / float64(b.Count)})
+	}
+	return got
+}
+
+func TestHistory(t *testing.T) {
+	h := newHistory(5*time.Minute, time.Minute)
+	for _, r := range []*sink.Reading{
+		reading("kitchen", 0, 10, 0),
+		reading("kitchen", 0.5, 20, 0),
+		reading("kitchen", 1, 30, 0),
+		reading("garden", 1, 100, 0),
+		reading("kitchen", 3, 40, 0),
+	} {
+		if err := h.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end := t0.Add(time.Hour)
+	for _, tc := range []struct {
+		name       string
+		sensor     string
+		from, to   time.Time
+		resolution time.Duration
+		want       [][2]float64
+	}{
+		{"all", "kitchen", t0, end, 0, [][2]float64{{0, 15}, {1, 30}, {3, 40}}},
+		{"other sensor", "garden", t0, end, 0, [][2]float64{{1, 100}}},
+		{"unknown sensor", "hall", t0, end, 0, nil},
+		{"from", "kitchen", t0.Add(time.Minute), end, 0, [][2]float64{{1, 30}, {3, 40}}},
+		{"to is exclusive", "kitchen", t0, t0.Add(3 * time.Minute), 0, [][2]float64{{0, 15}, {1, 30}}},
+		{"merged", "kitchen", t0, end, 2 * time.Minute, [][2]float64{{0, 20}, {2, 40}}},
+		{"merged into one", "kitchen", t0, end, time.Hour, [][2]float64{{0, 25}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := means(h.Query(tc.sensor, tc.from, tc.to, tc.resolution)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Query: %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	h := newHistory(3*time.Minute, time.Minute)
+	for i := 0; i < 5; i++ {
+		h.Write(reading("kitchen", float64(i), float64(i), 0))
+	}
+	// Only the last 3 buckets are kept.
+	got := means(h.Query("kitchen", t0, t0.Add(time.Hour), 0))
+	want := [][2]float64{{2, 2}, {3, 3}, {4, 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query: %v, want %v", got, want)
+	}
+}
+
+func TestHistoryOutOfOrder(t *testing.T) {
+	h := newHistory(5*time.Minute, time.Minute)
+	h.Write(reading("kitchen", 0, 10, 0))
+	h.Write(reading("kitchen", 2, 20, 0))
+	// A reading for the last bucket is fine, but not for an earlier
+	// one.
+	if err := h.Write(reading("kitchen", 2.5, 30, 0)); err != nil {
+		t.Errorf("Write to the last bucket: %v", err)
+	}
+	if err := h.Write(reading("kitchen", 1, 30, 0)); err == nil {
+		t.Error("Write of an out of order reading: no error")
+	}
+}
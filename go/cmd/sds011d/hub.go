@@ -0,0 +1,173 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// maxSubscriberBuffer is the largest buffer a client may ask for.
+const maxSubscriberBuffer = 1024
+
+// A backpressure policy decides what happens when a subscriber falls
+// so far behind that its buffer is full. Whatever it is, a slow
+// subscriber never blocks the others.
+type backpressure string
+
+const (
+	// dropNewest drops the new reading.
+	dropNewest backpressure = "drop_newest"
+	// dropOldest drops the oldest reading in the buffer to make
+	// room for the new one.
+	dropOldest backpressure = "drop_oldest"
+	// disconnect closes the subscription.
+	disconnect backpressure = "disconnect"
+)
+
+var backpressures = map[backpressure]bool{dropNewest: true, dropOldest: true, disconnect: true}
+
+// subscription describes what a subscriber gets.
+type subscription struct {
+	// sensor, if not empty, is the only sensor whose readings are
+	// sent.
+	sensor string
+	policy backpressure
+}
+
+// hub is a sink that remembers the latest reading of every sensor and
+// passes new readings on to its subscribers.
+type hub struct {
+	mu          sync.Mutex
+	latest      map[string]*sink.Reading
+	subscribers map[chan *sink.Reading]subscription
+}
+
+func newHub() *hub {
+	return &hub{
+		latest:      make(map[string]*sink.Reading),
+		subscribers: make(map[chan *sink.Reading]subscription),
+	}
+}
+
+// Write records r as the latest reading of its sensor and sends it to
+// the subscribers. What happens with a subscriber that isn't keeping
+// up depends on its backpressure policy.
+func (h *hub) Write(r *sink.Reading) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest[r.Sensor] = r
+	for ch, sub := range h.subscribers {
+		if sub.sensor != "" && sub.sensor != r.Sensor {
+			continue
+		}
+		select {
+		case ch <- r:
+			continue
+		default:
+		}
+		switch sub.policy {
+		case dropOldest:
+			// Only the subscriber receives from ch, so once
+			// there's room it stays there.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- r:
+			default:
+			}
+		case disconnect:
+			log.V(1).Infof("stream: disconnecting a slow subscriber")
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// Flush implements sink.Sink.
+func (h *hub) Flush() error {
+	return nil
+}
+
+// Close closes all the subscriptions.
+func (h *hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	return nil
+}
+
+// Latest returns the latest reading of sensor, or nil if there's none
+// yet.
+func (h *hub) Latest(sensor string) *sink.Reading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latest[sensor]
+}
+
+// subscribe returns a channel with the given buffer that will receive
+// all new readings matching sub. It is closed by unsubscribe, or by
+// the hub if sub's policy is to disconnect.
+func (h *hub) subscribe(sub subscription, buffer int) chan *sink.Reading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan *sink.Reading, buffer)
+	h.subscribers[ch] = sub
+	return ch
+}
+
+// unsubscribe stops sending readings to ch and closes it.
+func (h *hub) unsubscribe(ch chan *sink.Reading) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// subscribe subscribes to the readings as asked for in the sensor,
+// buffer and policy parameters of r, which default to all sensors and
+// the config of the daemon.
+func (d *daemon) subscribe(r *http.Request) (chan *sink.Reading, error) {
+	q := r.URL.Query()
+	sub := subscription{sensor: q.Get("sensor"), policy: backpressure(d.streams.Policy)}
+	if _, ok := d.collectors[sub.sensor]; sub.sensor != "" && !ok {
+		return nil, notFound("unknown sensor %q", sub.sensor)
+	}
+	if p := q.Get("policy"); p != "" {
+		if sub.policy = backpressure(p); !backpressures[sub.policy] {
+			return nil, badRequest("bad policy %q", p)
+		}
+	}
+	buffer := d.streams.Buffer
+	if b := q.Get("buffer"); b != "" {
+		var err error
+		if buffer, err = strconv.Atoi(b); err != nil || buffer < 1 || buffer > maxSubscriberBuffer {
+			return nil, badRequest("buffer should be between 1 and %d", maxSubscriberBuffer)
+		}
+	}
+	return d.hub.subscribe(sub, buffer), nil
+}
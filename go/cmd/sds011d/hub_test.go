@@ -0,0 +1,124 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// drain returns the PM2.5 levels of the readings buffered in ch, and
+// whether it's closed.
+func drain(ch chan *sink.Reading) (levels []float64, closed bool) {
+	for {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				return levels, true
+			}
+			levels = append(levels, r.PM25)
+		default:
+			return levels, false
+		}
+	}
+}
+
+func TestHubBackpressure(t *testing.T) {
+	for _, tc := range []struct {
+		policy backpressure
+		want   []float64
+		closed bool
+	}{
+		{dropNewest, []float64{1, 2}, false},
+		{dropOldest, []float64{3, 4}, false},
+		{disconnect, []float64{1, 2}, true},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			h := newHub()
+			slow := h.subscribe(subscription{policy: tc.policy}, 2)
+			// A subscriber that keeps up gets everything,
+			// whatever the slow one does.
+			fast := h.subscribe(subscription{policy: dropNewest}, 4)
+			for i := 1; i <= 4; i++ {
+				h.Write(reading("kitchen", float64(i), float64(i), 0))
+			}
+			got, closed := drain(slow)
+			if !reflect.DeepEqual(got, tc.want) || closed != tc.closed {
+				t.Errorf("slow subscriber: %v, closed %v, want %v, closed %v", got, closed, tc.want, tc.closed)
+			}
+			if got, _ := drain(fast); !reflect.DeepEqual(got, []float64{1, 2, 3, 4}) {
+				t.Errorf("fast subscriber: %v, want [1 2 3 4]", got)
+			}
+			if got := h.Latest("kitchen"); got == nil || got.PM25 != 4 {
+				t.Errorf("Latest: %v, want the reading of 4", got)
+			}
+			// Unsubscribing a disconnected subscriber is fine.
+			h.unsubscribe(slow)
+		})
+	}
+}
+
+func TestHubSensor(t *testing.T) {
+	h := newHub()
+	ch := h.subscribe(subscription{sensor: "garden", policy: dropNewest}, 4)
+	h.Write(reading("kitchen", 0, 1, 0))
+	h.Write(reading("garden", 0, 2, 0))
+	if got, _ := drain(ch); !reflect.DeepEqual(got, []float64{2}) {
+		t.Errorf("got %v, want only the garden's [2]", got)
+	}
+	h.Close()
+	if _, closed := drain(ch); !closed {
+		t.Error("closing the hub didn't close the subscription")
+	}
+}
+
+func TestSubscribeParams(t *testing.T) {
+	d, _, _ := testDaemon(t, minimalConfig(`, "streams": {"buffer": 8, "policy": "drop_oldest"}`))
+	for _, tc := range []struct {
+		query  string
+		sub    subscription
+		buffer int
+		err    bool
+	}{
+		{"", subscription{policy: dropOldest}, 8, false},
+		{"sensor=kitchen&policy=disconnect&buffer=2", subscription{sensor: "kitchen", policy: disconnect}, 2, false},
+		{"sensor=hall", subscription{}, 0, true},
+		{"policy=block", subscription{}, 0, true},
+		{"buffer=0", subscription{}, 0, true},
+		{"buffer=100000", subscription{}, 0, true},
+	} {
+		ch, err := d.subscribe(httptest.NewRequest("GET", "/v1/stream?"+tc.query, nil))
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: no error", tc.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.query, err)
+			continue
+		}
+		d.hub.mu.Lock()
+		sub := d.hub.subscribers[ch]
+		d.hub.mu.Unlock()
+		if sub != tc.sub || cap(ch) != tc.buffer {
+			t.Errorf("%q: %+v with a buffer of %d, want %+v and %d", tc.query, sub, cap(ch), tc.sub, tc.buffer)
+		}
+		d.hub.unsubscribe(ch)
+	}
+}
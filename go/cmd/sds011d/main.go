@@ -0,0 +1,315 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011d is a long-running daemon that reads one or more SDS011
+// sensors and sends their measurements to the configured sinks. It is
+// configured with a JSON file, for example:
+//
+//	{
+//	  "sensors": [
+//	    {"name": "living_room", "port_path": "/dev/ttyUSB0",
+//	     "interval": "5m", "warmup": "30s", "samples": 3}
+//	  ],
+//	  "sinks": [
+//	    {"type": "csv", "path": "/var/log/sds011.csv"},
+//	    {"type": "webhook", "url": "http://localhost:8080/readings"}
+//	  ]
+//	}
+//
+// A sensor with no interval is put in active mode and every reading
+// it reports is used (averaged over samples, if set). With an
+// interval, the sensor sleeps between measurements, which extends the
+// life of its laser.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/otlp"
+	"github.com/ryszard/sds011/go/remote"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	configPath  = flag.String("config", "/etc/sds011d.json", "path to the config file")
+	sinkPlugins = flag.String("sink_plugins", "", "comma separated list of Go plugins adding sink and enricher types")
+	checkOnly   = flag.Bool("check_config", false, "check the config file and exit, with status 1 if it has errors")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011d reads data from one or more SDS011 sensors and sends them
+to the sinks defined in its config file.
+
+With -check_config, it only checks the config file: it reports keys it
+doesn't know, bad values and sinks that can't be created as errors, and
+ports that aren't there as warnings, and exits with status 1 if there
+were errors.`)
+		fmt.Fprintf(os.Stderr, "\n\nUsage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *sinkPlugins != "" {
+		for _, path := range strings.Split(*sinkPlugins, ",") {
+			if err := sink.LoadPlugin(path); err != nil {
+				log.Exitf("sink plugin %v: %v", path, err)
+			}
+		}
+	}
+	if *checkOnly {
+		if checkConfig(*configPath, os.Stderr) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	config, err := loadConfig(*configPath, nil)
+	if err != nil {
+		log.Exit(err)
+	}
+
+	h := newHub()
+	hist := newHistory(config.History.Retention.Duration, config.History.Resolution.Duration)
+	common := fanout{h, hist}
+	var st store.Store
+	if config.Store.Backend != "" {
+		if st, err = openStore(&config.Store); err != nil {
+			log.Exitf("store: %v", err)
+		}
+		now := time.Now()
+		for _, sc := range config.Sensors {
+			err := st.Scan(sc.Name, now.Add(-config.History.Retention.Duration), now, func(readings []*sink.Reading) error {
+				for _, r := range readings {
+					hist.Write(r)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("store: loading history of %v: %v", sc.Name, err)
+			}
+		}
+		common = append(common, store.Sink{Store: st})
+	}
+	d := newDaemon(h, hist, st, config)
+	common = append(common, d.averages)
+	if d.exceedances != nil {
+		common = append(common, d.exceedances)
+	}
+	if d.reports != nil {
+		common = append(common, d.reports)
+	}
+	// The alerts are there even without rules, for reloading to add
+	// some.
+	if d.alerts, err = newAlerts(config.Alerts); err != nil {
+		log.Exit(err)
+	}
+	common = append(common, d.alerts)
+	sinks, err := newRouter(config, common, d.metrics)
+	if err != nil {
+		log.Exit(err)
+	}
+	defer sinks.Close()
+	enrichers, err := newEnrichment(config.Enrichers)
+	if err != nil {
+		log.Exit(err)
+	}
+	defer enrichers.Close()
+	seq, err := sink.NewSequencer(config.SequenceFile)
+	if err != nil {
+		log.Exitf("sequence_file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var oe *otlp.Exporter
+	if config.OTLP.Endpoint != "" {
+		oe = otlp.New(config.OTLP.Endpoint, "sds011d", config.OTLP.Headers)
+		go oe.Run(ctx, config.OTLP.Interval.Duration)
+	}
+
+	readings := make(chan *sink.Reading)
+	var wg sync.WaitGroup
+	for _, sc := range config.Sensors {
+		c := &collector{config: sc, out: readings, metrics: d.metrics, degradations: d.degradations}
+		if oe != nil {
+			c.otlp, c.observer = oe, oe.Sensor(sc.Name)
+		}
+		c.setLabels()
+		d.add(c)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx)
+		}()
+	}
+	go d.heartbeat.run(ctx)
+	if d.exceedances != nil {
+		go d.exceedances.run(ctx)
+	}
+	if d.reports != nil {
+		go d.reports.run(ctx)
+	}
+	go d.alerts.run(ctx)
+
+	if (config.RPCAddress != "" || config.HTTPAddress != "") && !d.controlEnabled() {
+		log.Warning("neither control_token nor auth is set, so the sensors can't be controlled over HTTP or RPC")
+	}
+	if config.RPCAddress != "" {
+		// gRPC does TLS itself, as it has to negotiate HTTP/2.
+		tc, err := config.TLS.load()
+		if err != nil {
+			log.Exit(err)
+		}
+		var options []grpc.ServerOption
+		if tc != nil {
+			options = append(options, grpc.Creds(credentials.NewTLS(tc)))
+		}
+		server := grpc.NewServer(options...)
+		remote.Register(server, d)
+		l, err := net.Listen("tcp", config.RPCAddress)
+		if err != nil {
+			log.Exit(err)
+		}
+		log.Infof("serving gRPC on %v", l.Addr())
+		go server.Serve(l)
+		defer server.Stop()
+	}
+
+	if config.HTTPAddress != "" {
+		l, err := listen(config.HTTPAddress, config.TLS)
+		if err != nil {
+			log.Exit(err)
+		}
+		server := &http.Server{Handler: newAPI(d)}
+		go func() {
+			log.Infof("serving HTTP on %v", l.Addr())
+			if err := server.Serve(l); err != http.ErrServerClosed {
+				log.Exit(err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	if config.ModbusAddress != "" {
+		l, err := net.Listen("tcp", config.ModbusAddress)
+		if err != nil {
+			log.Exit(err)
+		}
+		defer l.Close()
+		log.Infof("serving Modbus TCP on %v", l.Addr())
+		go d.serveModbus(l)
+	}
+
+	if sc := config.SNMP; sc.Address != "" {
+		conn, err := net.ListenPacket("udp", sc.Address)
+		if err != nil {
+			log.Exit(err)
+		}
+		defer conn.Close()
+		root, _ := parseOID(sc.RootOID)
+		log.Infof("serving SNMP on %v", conn.LocalAddr())
+		go (&snmpAgent{d: d, community: sc.Community, root: root}).serve(conn)
+	}
+
+	if mc := config.MQTT; mc.Address != "" {
+		l, err := net.Listen("tcp", mc.Address)
+		if err != nil {
+			log.Exit(err)
+		}
+		defer l.Close()
+		broker := newMQTTBroker(mc.Username, mc.Password)
+		if mc.Discovery != "" {
+			broker.discovery(mc.Discovery, mc.Topic, d.names)
+		}
+		log.Infof("serving MQTT on %v", l.Addr())
+		go broker.serve(l)
+		go d.publishReadings(ctx, broker, mc.Topic)
+	}
+
+	if config.MDNS.Enabled {
+		m, err := advertise(config, d.names)
+		if err != nil {
+			log.Exitf("mdns: %v", err)
+		}
+		go func() {
+			if err := m.run(ctx); err != nil {
+				log.Errorf("mdns: %v", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("received %v, shutting down", sig)
+		cancel()
+		// Closing the ports unblocks any pending reads.
+		for _, name := range d.names {
+			d.collectors[name].close()
+		}
+	}()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		current := config
+		for range hangups {
+			var err error
+			if current, err = reload(*configPath, current, d, sinks); err != nil {
+				log.Errorf("not reloading %v: %v", *configPath, err)
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(readings)
+	}()
+
+	for r := range readings {
+		if err := seq.Number(r); err != nil {
+			log.Errorf("sequence_file: %v", err)
+		}
+		enrichers.enrich(r)
+		log.V(2).Infof("%v: %v", r.Sensor, r.Point)
+		sinks.Write(r)
+		if err := sinks.Flush(); err != nil {
+			log.Errorf("flushing sinks: %v", err)
+		}
+	}
+	if err := seq.Close(); err != nil {
+		log.Errorf("sequence_file: %v", err)
+	}
+	log.Flush()
+}
@@ -0,0 +1,739 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/golang/glog"
+)
+
+// The mDNS responder advertises the daemon as a DNS-SD service of
+// type _sds011._tcp. The SRV record points at the HTTP API if it's
+// enabled, and at the RPC service otherwise. The TXT record has the
+// ports of both (as http=8011 and rpc=9011) and the names of the
+// sensors (as sensors=living_room,bedroom), so that clients can
+// find what they need without asking. A TXT string holds at most 255
+// bytes, so in a large setup only the first few sensors are listed,
+// and clients have to ask the API for the rest.
+//
+// Before announcing the service, the responder probes for its
+// instance name, as RFC 6762, section 8, asks. If another host has it
+// already, or is probing for it at the same time and wins the
+// tie-break, it picks another one, like "sds011d on pi (2)", and
+// probes again. It keeps watching for conflicts after that. The host
+// name is that of the machine, whose own responder usually advertises
+// it too, so it isn't probed for.
+
+const (
+	mdnsService = "_sds011._tcp"
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN = 1
+	// dnsCacheFlush marks records that are unique to this host.
+	dnsCacheFlush = 0x8000
+	// dnsUnicastResponse is set in the class of questions that ask
+	// for a unicast response.
+	dnsUnicastResponse = 0x8000
+
+	// dnsMaxLabel and dnsMaxString are the lengths of the longest
+	// label of a name, and of the longest string of a TXT record.
+	dnsMaxLabel  = 63
+	dnsMaxString = 255
+
+	// mdnsHostTTL is used for records that have the host name in
+	// them, mdnsTTL for the others, as recommended by RFC 6762.
+	mdnsHostTTL = 120
+	mdnsTTL     = 4500
+
+	// mdnsProbes is how many probes make sure a name is free.
+	mdnsProbes = 3
+)
+
+// mdnsProbeWait is the time between probes. It is a variable for the
+// tests.
+var mdnsProbeWait = 250 * time.Millisecond
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// A name is a domain name, as its labels.
+type name []string
+
+func (n name) equal(m name) bool {
+	if len(n) != len(m) {
+		return false
+	}
+	for i := range n {
+		if !strings.EqualFold(n[i], m[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitName(s string) name {
+	return name(strings.Split(strings.TrimSuffix(s, "."), "."))
+}
+
+// truncateLabel shortens s to at most n bytes, without cutting a
+// character in half.
+func truncateLabel(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+type dnsQuestion struct {
+	name  name
+	qtype uint16
+	class uint16
+}
+
+type dnsRecord struct {
+	name  name
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+// dnsMessage is a DNS message. Its additional records aren't used.
+type dnsMessage struct {
+	id        uint16
+	response  bool
+	questions []dnsQuestion
+	answers   []dnsRecord
+	// authorities are the records proposed by a probe.
+	authorities []dnsRecord
+}
+
+// mdnsPacket is a message, and where it came from.
+type mdnsPacket struct {
+	msg *dnsMessage
+	src *net.UDPAddr
+}
+
+// mdnsResponder answers mDNS queries about the daemon.
+type mdnsResponder struct {
+	// instance is the full name of the service instance, host is
+	// the host name, both in .local.
+	instance name
+	service  name
+	host     name
+	port     uint16
+	txt      []string
+
+	// base is the instance name asked for, and renames the number
+	// of times it had to be changed because of a conflict.
+	base    string
+	renames int
+}
+
+// newMDNSResponder returns a responder, or an error if a name or a
+// TXT string is too long to be encoded.
+func newMDNSResponder(instance, host string, port uint16, txt []string) (*mdnsResponder, error) {
+	for _, label := range []string{instance, host} {
+		if label == "" || len(label) > dnsMaxLabel {
+			return nil, fmt.Errorf("name %q should have 1 to %d bytes", label, dnsMaxLabel)
+		}
+	}
+	for _, s := range txt {
+		if len(s) > dnsMaxString {
+			return nil, fmt.Errorf("TXT string %q is longer than %d bytes", s, dnsMaxString)
+		}
+	}
+	service := append(splitName(mdnsService), "local")
+	return &mdnsResponder{
+		instance: append(name{instance}, service...),
+		service:  service,
+		host:     name{host, "local"},
+		port:     port,
+		txt:      txt,
+		base:     instance,
+	}, nil
+}
+
+// advertise returns a responder advertising the servers enabled in
+// config, which serve the given sensors.
+func advertise(config *Config, sensors []string) (*mdnsResponder, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	// The host name may be fully qualified.
+	hostname = truncateLabel(strings.SplitN(hostname, ".", 2)[0], dnsMaxLabel)
+	instance := config.MDNS.Instance
+	if instance == "" {
+		instance = truncateLabel("sds011d on "+hostname, dnsMaxLabel)
+	}
+	var (
+		port uint16
+		txt  []string
+	)
+	for _, s := range []struct{ key, address string }{
+		{"rpc", config.RPCAddress},
+		{"http", config.HTTPAddress},
+	} {
+		if s.address == "" {
+			continue
+		}
+		_, p, err := net.SplitHostPort(s.address)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad port in %q", s.address)
+		}
+		// HTTP comes last, so it wins.
+		port = uint16(n)
+		txt = append(txt, s.key+"="+p)
+	}
+	listed := "sensors="
+	for i, s := range sensors {
+		next := listed + s
+		if i > 0 {
+			next = listed + "," + s
+		}
+		if len(next) > dnsMaxString {
+			log.Warningf("mdns: only %d of the %d sensors fit in the TXT record", i, len(sensors))
+			break
+		}
+		listed = next
+	}
+	txt = append(txt, listed)
+	return newMDNSResponder(instance, hostname, port, txt)
+}
+
+// rename picks the next name for the instance, after a conflict.
+func (m *mdnsResponder) rename() {
+	m.renames++
+	suffix := fmt.Sprintf(" (%d)", m.renames+1)
+	m.instance[0] = truncateLabel(m.base, dnsMaxLabel-len(suffix)) + suffix
+}
+
+// records returns the records answering q.
+func (m *mdnsResponder) records(q dnsQuestion) []dnsRecord {
+	var out []dnsRecord
+	anyType := q.qtype == dnsTypeANY
+	switch {
+	case q.name.equal(name{"_services", "_dns-sd", "_udp", "local"}) && (anyType || q.qtype == dnsTypePTR):
+		out = append(out, dnsRecord{q.name, dnsTypePTR, dnsClassIN, mdnsTTL, encodeName(m.service)})
+	case q.name.equal(m.service) && (anyType || q.qtype == dnsTypePTR):
+		out = append(out, m.ptr(mdnsTTL))
+	case q.name.equal(m.instance):
+		if anyType || q.qtype == dnsTypeSRV {
+			out = append(out, m.srv(mdnsHostTTL))
+		}
+		if anyType || q.qtype == dnsTypeTXT {
+			out = append(out, m.txtRecord(mdnsTTL))
+		}
+	case q.name.equal(m.host) && (anyType || q.qtype == dnsTypeA):
+		out = append(out, m.addresses(mdnsHostTTL)...)
+	}
+	return out
+}
+
+func (m *mdnsResponder) ptr(ttl uint32) dnsRecord {
+	return dnsRecord{m.service, dnsTypePTR, dnsClassIN, ttl, encodeName(m.instance)}
+}
+
+func (m *mdnsResponder) srv(ttl uint32) dnsRecord {
+	// Priority, weight, port, target.
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[4:], m.port)
+	return dnsRecord{m.instance, dnsTypeSRV, dnsClassIN | dnsCacheFlush, ttl, append(data, encodeName(m.host)...)}
+}
+
+// txtRecord returns the TXT record. The strings were checked by
+// newMDNSResponder, so their lengths fit in a byte.
+func (m *mdnsResponder) txtRecord(ttl uint32) dnsRecord {
+	var data []byte
+	for _, s := range m.txt {
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	return dnsRecord{m.instance, dnsTypeTXT, dnsClassIN | dnsCacheFlush, ttl, data}
+}
+
+// addresses returns an A record for every IPv4 address of the host,
+// except the loopback ones.
+func (m *mdnsResponder) addresses(ttl uint32) []dnsRecord {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Errorf("mdns: %v", err)
+		return nil
+	}
+	var out []dnsRecord
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			out = append(out, dnsRecord{m.host, dnsTypeA, dnsClassIN | dnsCacheFlush, ttl, []byte(ip4)})
+		}
+	}
+	return out
+}
+
+// all returns every record of the service, which is what gets
+// announced.
+func (m *mdnsResponder) all(ttl, hostTTL uint32) []dnsRecord {
+	out := []dnsRecord{m.ptr(ttl), m.srv(hostTTL), m.txtRecord(ttl)}
+	return append(out, m.addresses(hostTTL)...)
+}
+
+// unique returns the records only this host may have, which are
+// probed for.
+func (m *mdnsResponder) unique() []dnsRecord {
+	out := []dnsRecord{m.srv(mdnsHostTTL), m.txtRecord(mdnsTTL)}
+	for i := range out {
+		out[i].class &^= dnsCacheFlush
+	}
+	return out
+}
+
+// conflicts reports whether msg is a response from another host with
+// records for the instance name.
+func (m *mdnsResponder) conflicts(msg *dnsMessage) bool {
+	if !msg.response {
+		return false
+	}
+	ours := m.unique()
+	for _, r := range msg.answers {
+		if !r.name.equal(m.instance) || (r.rtype != dnsTypeSRV && r.rtype != dnsTypeTXT) {
+			continue
+		}
+		// Our own responses come back to us, and other responders
+		// may repeat them from their caches.
+		mine := false
+		for _, o := range ours {
+			mine = mine || (r.rtype == o.rtype && bytes.Equal(r.data, o.data))
+		}
+		if !mine {
+			return true
+		}
+	}
+	return false
+}
+
+// losesTieBreak reports whether msg is a probe of another host for
+// the instance name that takes precedence over ours. As RFC 6762,
+// section 8.2, describes, the proposed records are sorted and
+// compared, and the lexicographically later set wins.
+func (m *mdnsResponder) losesTieBreak(msg *dnsMessage) bool {
+	if msg.response {
+		return false
+	}
+	var theirs []dnsRecord
+	for _, r := range msg.authorities {
+		if r.name.equal(m.instance) {
+			theirs = append(theirs, r)
+		}
+	}
+	if len(theirs) == 0 {
+		return false
+	}
+	ours := m.unique()
+	sortRecords(ours)
+	sortRecords(theirs)
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if c := compareRecords(ours[i], theirs[i]); c != 0 {
+			return c < 0
+		}
+	}
+	return len(ours) < len(theirs)
+}
+
+// compareRecords compares the class, type and data of two records.
+func compareRecords(a, b dnsRecord) int {
+	switch ac, bc := a.class&^dnsCacheFlush, b.class&^dnsCacheFlush; {
+	case ac != bc:
+		return int(ac) - int(bc)
+	case a.rtype != b.rtype:
+		return int(a.rtype) - int(b.rtype)
+	}
+	return bytes.Compare(a.data, b.data)
+}
+
+func sortRecords(rs []dnsRecord) {
+	sort.Slice(rs, func(i, j int) bool { return compareRecords(rs[i], rs[j]) < 0 })
+}
+
+var (
+	errNameConflict = errors.New("name conflict")
+	errStopped      = errors.New("stopped receiving")
+)
+
+// run answers queries until ctx is done. It probes for the instance
+// name and announces the service when it starts, and says goodbye
+// when it stops.
+func (m *mdnsResponder) run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	send := func(msg *dnsMessage, to *net.UDPAddr) {
+		if _, err := conn.WriteToUDP(msg.encode(), to); err != nil {
+			log.V(1).Infof("mdns: %v", err)
+		}
+	}
+	var readErr error
+	packets := make(chan mdnsPacket)
+	go func() {
+		defer close(packets)
+		readErr = receive(ctx, conn, packets)
+	}()
+
+	for {
+		err := m.probe(ctx, send, packets)
+		if err == nil && ctx.Err() == nil {
+			log.Infof("mdns: advertising %q", m.instance[0])
+			err = m.serve(ctx, send, packets)
+		}
+		switch {
+		case err == errNameConflict:
+			log.Warningf("mdns: another host is advertising %q", m.instance[0])
+			m.rename()
+		case err == errStopped:
+			return readErr
+		default:
+			return err
+		}
+	}
+}
+
+// receive sends the messages arriving on conn to out, until reading
+// fails or ctx is done.
+func receive(ctx context.Context, conn *net.UDPConn, out chan<- mdnsPacket) error {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		msg, err := parseMessage(buf[:n])
+		if err != nil {
+			log.V(2).Infof("mdns %v: %v", src, err)
+			continue
+		}
+		select {
+		case out <- mdnsPacket{msg, src}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// probe makes sure no other host uses the instance name, renaming it
+// until one is free. It returns nil once it is, or ctx is done.
+func (m *mdnsResponder) probe(ctx context.Context, send func(*dnsMessage, *net.UDPAddr), in <-chan mdnsPacket) error {
+	// A random delay keeps hosts that start together, like after a
+	// power cut, from probing in lockstep.
+	next := time.After(time.Duration(rand.Int63n(int64(mdnsProbeWait))))
+	for sent := 0; ; {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-next:
+			if sent == mdnsProbes {
+				return nil
+			}
+			send(&dnsMessage{
+				questions:   []dnsQuestion{{m.instance, dnsTypeANY, dnsClassIN | dnsUnicastResponse}},
+				authorities: m.unique(),
+			}, mdnsGroup)
+			sent++
+			next = time.After(mdnsProbeWait)
+		case p, ok := <-in:
+			if !ok {
+				return errStopped
+			}
+			switch {
+			case m.conflicts(p.msg):
+				log.Infof("mdns: %q is taken", m.instance[0])
+				m.rename()
+				sent, next = 0, time.After(mdnsProbeWait)
+			case m.losesTieBreak(p.msg):
+				// The winner probes again; so do we, after a
+				// second, in case it gave up.
+				sent, next = 0, time.After(4*mdnsProbeWait)
+			}
+		}
+	}
+}
+
+// serve announces the service and answers queries until ctx is done,
+// or it sees another host advertising the instance name, when it
+// returns errNameConflict.
+func (m *mdnsResponder) serve(ctx context.Context, send func(*dnsMessage, *net.UDPAddr), in <-chan mdnsPacket) error {
+	announce := time.After(0)
+	for announced := 0; ; {
+		select {
+		case <-ctx.Done():
+			// Records with a TTL of 0 tell everybody to forget us.
+			send(&dnsMessage{response: true, answers: m.all(0, 0)}, mdnsGroup)
+			return nil
+		case <-announce:
+			send(&dnsMessage{response: true, answers: m.all(mdnsTTL, mdnsHostTTL)}, mdnsGroup)
+			// RFC 6762 asks for at least two announcements, a
+			// second apart.
+			if announced++; announced < 2 {
+				announce = time.After(time.Second)
+			}
+		case p, ok := <-in:
+			if !ok {
+				return errStopped
+			}
+			if m.conflicts(p.msg) {
+				send(&dnsMessage{response: true, answers: []dnsRecord{m.ptr(0), m.srv(0), m.txtRecord(0)}}, mdnsGroup)
+				return errNameConflict
+			}
+			if msg, to := m.answer(p); msg != nil {
+				send(msg, to)
+			}
+		}
+	}
+}
+
+// answer returns the response to the query in p, and where to send
+// it, or nil if there is nothing to say.
+func (m *mdnsResponder) answer(p mdnsPacket) (*dnsMessage, *net.UDPAddr) {
+	if p.msg.response {
+		return nil, nil
+	}
+	resp := &dnsMessage{response: true}
+	unicast := false
+	for _, q := range p.msg.questions {
+		resp.answers = append(resp.answers, m.records(q)...)
+		unicast = unicast || q.class&dnsUnicastResponse != 0
+	}
+	if len(resp.answers) == 0 {
+		return nil, nil
+	}
+	switch {
+	case p.src.Port != mdnsGroup.Port:
+		// A legacy resolver, which expects a plain DNS response
+		// with the questions repeated, and doesn't know about
+		// cache flushing.
+		resp.id = p.msg.id
+		for _, q := range p.msg.questions {
+			resp.questions = append(resp.questions, dnsQuestion{q.name, q.qtype, q.class &^ dnsUnicastResponse})
+		}
+		for i := range resp.answers {
+			resp.answers[i].class &^= dnsCacheFlush
+		}
+		return resp, p.src
+	case unicast:
+		return resp, p.src
+	}
+	return resp, mdnsGroup
+}
+
+// encode returns msg in wire format, without name compression.
+func (msg *dnsMessage) encode() []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], msg.id)
+	if msg.response {
+		// QR and AA.
+		binary.BigEndian.PutUint16(b[2:], 0x8400)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(msg.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(msg.answers)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(msg.authorities)))
+	for _, q := range msg.questions {
+		b = append(b, encodeName(q.name)...)
+		b = binary.BigEndian.AppendUint16(b, q.qtype)
+		b = binary.BigEndian.AppendUint16(b, q.class)
+	}
+	for _, rs := range [][]dnsRecord{msg.answers, msg.authorities} {
+		for _, r := range rs {
+			b = append(b, encodeName(r.name)...)
+			b = binary.BigEndian.AppendUint16(b, r.rtype)
+			b = binary.BigEndian.AppendUint16(b, r.class)
+			b = binary.BigEndian.AppendUint32(b, r.ttl)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(r.data)))
+			b = append(b, r.data...)
+		}
+	}
+	return b
+}
+
+// encodeName encodes n, whose labels are no longer than dnsMaxLabel:
+// the names of the responder are checked by newMDNSResponder, and
+// those of questions were parsed from a message.
+func encodeName(n name) []byte {
+	var out []byte
+	for _, label := range n {
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
+
+var errBadMessage = errors.New("malformed message")
+
+// parseMessage parses a message, up to its authority records. The
+// names in PTR and SRV records are decompressed, so that their data
+// can be compared.
+func parseMessage(b []byte) (*dnsMessage, error) {
+	if len(b) < 12 {
+		return nil, errBadMessage
+	}
+	msg := &dnsMessage{
+		id:       binary.BigEndian.Uint16(b[0:]),
+		response: binary.BigEndian.Uint16(b[2:])&0x8000 != 0,
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(b[4:])); i++ {
+		n, next, err := parseName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errBadMessage
+		}
+		msg.questions = append(msg.questions, dnsQuestion{
+			name:  n,
+			qtype: binary.BigEndian.Uint16(b[next:]),
+			class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+	for _, section := range []struct {
+		count int
+		out   *[]dnsRecord
+	}{
+		{int(binary.BigEndian.Uint16(b[6:])), &msg.answers},
+		{int(binary.BigEndian.Uint16(b[8:])), &msg.authorities},
+	} {
+		for i := 0; i < section.count; i++ {
+			r, next, err := parseRecord(b, off)
+			if err != nil {
+				return nil, err
+			}
+			*section.out = append(*section.out, r)
+			off = next
+		}
+	}
+	return msg, nil
+}
+
+// parseRecord parses the record starting at off in msg, and returns
+// it with the offset right after it.
+func parseRecord(msg []byte, off int) (dnsRecord, int, error) {
+	n, off, err := parseName(msg, off)
+	if err != nil {
+		return dnsRecord{}, 0, err
+	}
+	if off+10 > len(msg) {
+		return dnsRecord{}, 0, errBadMessage
+	}
+	r := dnsRecord{
+		name:  n,
+		rtype: binary.BigEndian.Uint16(msg[off:]),
+		class: binary.BigEndian.Uint16(msg[off+2:]),
+		ttl:   binary.BigEndian.Uint32(msg[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	end := off + length
+	if end > len(msg) {
+		return dnsRecord{}, 0, errBadMessage
+	}
+	// The names in the data may point anywhere in msg, but must
+	// start inside the data.
+	var prefix int
+	switch r.rtype {
+	case dnsTypePTR:
+		prefix = 0
+	case dnsTypeSRV:
+		prefix = 6
+	default:
+		r.data = append([]byte(nil), msg[off:end]...)
+		return r, end, nil
+	}
+	if off+prefix >= end {
+		return dnsRecord{}, 0, errBadMessage
+	}
+	target, _, err := parseName(msg, off+prefix)
+	if err != nil {
+		return dnsRecord{}, 0, err
+	}
+	r.data = append(append([]byte(nil), msg[off:off+prefix]...), encodeName(target)...)
+	return r, end, nil
+}
+
+// parseName parses the name starting at off in msg, following
+// compression pointers, and returns it with the offset right after
+// it.
+func parseName(msg []byte, off int) (name, int, error) {
+	var n name
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errBadMessage
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return n, end, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return nil, 0, errBadMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case length > dnsMaxLabel:
+			// The other label types are obsolete.
+			return nil, 0, errBadMessage
+		default:
+			if off+1+length > len(msg) {
+				return nil, 0, errBadMessage
+			}
+			n = append(n, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
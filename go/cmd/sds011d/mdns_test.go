@@ -0,0 +1,394 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testResponder(t *testing.T) *mdnsResponder {
+	t.Helper()
+	m, err := newMDNSResponder("sds011d on pi", "pi", 8011, []string{"http=8011", "sensors=kitchen,garden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDNSMessageRoundTrip(t *testing.T) {
+	m := testResponder(t)
+	for _, msg := range []*dnsMessage{
+		{
+			id:        7,
+			questions: []dnsQuestion{{m.service, dnsTypePTR, dnsClassIN}, {m.instance, dnsTypeANY, dnsClassIN | dnsUnicastResponse}},
+		},
+		{
+			questions:   []dnsQuestion{{m.instance, dnsTypeANY, dnsClassIN | dnsUnicastResponse}},
+			authorities: m.unique(),
+		},
+		{
+			response: true,
+			answers: []dnsRecord{
+				m.ptr(mdnsTTL), m.srv(mdnsHostTTL), m.txtRecord(mdnsTTL),
+				{m.host, dnsTypeA, dnsClassIN | dnsCacheFlush, mdnsHostTTL, []byte{192, 168, 1, 2}},
+			},
+		},
+	} {
+		got, err := parseMessage(msg.encode())
+		if err != nil {
+			t.Errorf("%+v: %v", msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("round trip:\n got %+v\nwant %+v", got, msg)
+		}
+	}
+}
+
+func TestParseMessageCompressed(t *testing.T) {
+	// A response with a PTR record for _sds011._tcp.local, pointing
+	// at "other._sds011._tcp.local" with the service compressed, and
+	// an SRV record whose name and target are compressed.
+	msg := []byte{0, 0, 0x84, 0, 0, 0, 0, 2, 0, 0, 0, 0}
+	service := len(msg)
+	msg = append(msg, encodeName(splitName(mdnsService+".local"))...)
+	msg = append(msg, 0, dnsTypePTR, 0, dnsClassIN, 0, 0, 0x11, 0x94)
+	ptrData := []byte{5, 'o', 't', 'h', 'e', 'r', 0xc0, byte(service)}
+	msg = append(msg, 0, byte(len(ptrData)))
+	instance := len(msg)
+	msg = append(msg, ptrData...)
+	msg = append(msg, 0xc0, byte(instance), 0, dnsTypeSRV, 0x80, dnsClassIN, 0, 0, 0, 120)
+	srvData := []byte{0, 0, 0, 0, 0x1f, 0x4b, 2, 'p', 'i', 0xc0, byte(service + 1 + len("_sds011") + 1 + len("_tcp"))}
+	msg = append(msg, 0, byte(len(srvData)))
+	msg = append(msg, srvData...)
+
+	got, err := parseMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := name{"other", "_sds011", "_tcp", "local"}
+	want := &dnsMessage{
+		response: true,
+		answers: []dnsRecord{
+			{splitName(mdnsService + ".local"), dnsTypePTR, dnsClassIN, mdnsTTL, encodeName(other)},
+			{other, dnsTypeSRV, dnsClassIN | dnsCacheFlush, mdnsHostTTL, append([]byte{0, 0, 0, 0, 0x1f, 0x4b}, encodeName(name{"pi", "local"})...)},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMessage:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	header := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+	}{
+		{"short header", header[:11]},
+		{"no question", header},
+		{"truncated label", append(header, 5, 'a', 'b')},
+		{"no type", append(header, 1, 'a', 0, 0, 1)},
+		{"pointer loop", append(header, 0xc0, 12, 0, 1, 0, 1)},
+		{"pointer out of the message", append(header, 0xc0, 0xff, 0, 1, 0, 1)},
+		{"obsolete label type", append(header, 0x41, 'a', 0, 0, 1, 0, 1)},
+		{"truncated record", []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 'a', 0, 0, 1, 0, 1, 0, 0, 0, 1, 0, 4, 1, 2}},
+		{"empty PTR", []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 'a', 0, 0, dnsTypePTR, 0, 1, 0, 0, 0, 1, 0, 0}},
+	} {
+		if _, err := parseMessage(tc.msg); err == nil {
+			t.Errorf("%v: no error", tc.name)
+		}
+	}
+}
+
+func TestMDNSRecords(t *testing.T) {
+	m := testResponder(t)
+	types := func(rs []dnsRecord) []uint16 {
+		var out []uint16
+		for _, r := range rs {
+			out = append(out, r.rtype)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		q    dnsQuestion
+		want []uint16
+	}{
+		{dnsQuestion{name{"_services", "_dns-sd", "_udp", "local"}, dnsTypePTR, dnsClassIN}, []uint16{dnsTypePTR}},
+		{dnsQuestion{name{"_SDS011", "_tcp", "local"}, dnsTypePTR, dnsClassIN}, []uint16{dnsTypePTR}},
+		{dnsQuestion{m.instance, dnsTypeANY, dnsClassIN}, []uint16{dnsTypeSRV, dnsTypeTXT}},
+		{dnsQuestion{m.instance, dnsTypeTXT, dnsClassIN}, []uint16{dnsTypeTXT}},
+		{dnsQuestion{m.instance, dnsTypeA, dnsClassIN}, nil},
+		{dnsQuestion{name{"_http", "_tcp", "local"}, dnsTypePTR, dnsClassIN}, nil},
+	} {
+		if got := types(m.records(tc.q)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v type %v: %v, want %v", tc.q.name, tc.q.qtype, got, tc.want)
+		}
+	}
+	txt := m.txtRecord(mdnsTTL).data
+	want := []byte("\x09http=8011\x16sensors=kitchen,garden")
+	if !bytes.Equal(txt, want) {
+		t.Errorf("TXT data %q, want %q", txt, want)
+	}
+}
+
+func TestNewMDNSResponderLimits(t *testing.T) {
+	long := strings.Repeat("x", dnsMaxLabel+1)
+	for _, tc := range []struct {
+		instance, host string
+		txt            []string
+	}{
+		{long, "pi", nil},
+		{"", "pi", nil},
+		{"sds011d", long, nil},
+		{"sds011d", "pi", []string{strings.Repeat("x", dnsMaxString+1)}},
+	} {
+		if _, err := newMDNSResponder(tc.instance, tc.host, 8011, tc.txt); err == nil {
+			t.Errorf("newMDNSResponder(%q, %q, %q): no error", tc.instance, tc.host, tc.txt)
+		}
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	var sensors []string
+	for i := 0; i < 40; i++ {
+		sensors = append(sensors, fmt.Sprintf("sensor%03d", i))
+	}
+	config := &Config{HTTPAddress: ":8011", RPCAddress: ":9011"}
+	m, err := advertise(config, sensors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.port != 8011 {
+		t.Errorf("port %v, want 8011", m.port)
+	}
+	listed := m.txt[len(m.txt)-1]
+	if len(listed) > dnsMaxString || !strings.HasPrefix("sensors="+strings.Join(sensors, ","), listed+",") {
+		t.Errorf("TXT %q: want whole names, up to %d bytes", listed, dnsMaxString)
+	}
+	if !strings.HasPrefix(m.instance[0], "sds011d on ") || len(m.instance[0]) > dnsMaxLabel {
+		t.Errorf("instance %q", m.instance[0])
+	}
+}
+
+func TestMDNSRename(t *testing.T) {
+	m := testResponder(t)
+	m.rename()
+	if got, want := m.instance[0], "sds011d on pi (2)"; got != want {
+		t.Errorf("renamed to %q, want %q", got, want)
+	}
+	m.rename()
+	if got, want := m.instance[0], "sds011d on pi (3)"; got != want {
+		t.Errorf("renamed to %q, want %q", got, want)
+	}
+	if !m.ptr(0).name.equal(m.service) || !bytes.Contains(m.ptr(0).data, []byte("(3)")) {
+		t.Errorf("PTR %q doesn't point at the new name", m.ptr(0).data)
+	}
+
+	// A long name is cut, not in the middle of a character, to make
+	// room for the number.
+	m, err := newMDNSResponder(strings.Repeat("ż", 31)+"x", "pi", 8011, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.rename()
+	if got, want := m.instance[0], strings.Repeat("ż", 29)+" (2)"; got != want {
+		t.Errorf("renamed to %q, want %q", got, want)
+	}
+}
+
+func TestMDNSConflicts(t *testing.T) {
+	m := testResponder(t)
+	other := testResponder(t)
+	other.port = 8012
+	for _, tc := range []struct {
+		name string
+		msg  *dnsMessage
+		want bool
+	}{
+		{"our own announcement", &dnsMessage{response: true, answers: m.all(mdnsTTL, mdnsHostTTL)}, false},
+		{"our own goodbye", &dnsMessage{response: true, answers: m.all(0, 0)}, false},
+		{"another host", &dnsMessage{response: true, answers: []dnsRecord{other.srv(mdnsHostTTL)}}, true},
+		{"another name", &dnsMessage{response: true, answers: []dnsRecord{testRenamed(t, other).srv(mdnsHostTTL)}}, false},
+		{"a probe", &dnsMessage{authorities: other.unique()}, false},
+	} {
+		if got := m.conflicts(tc.msg); got != tc.want {
+			t.Errorf("%v: conflicts %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func testRenamed(t *testing.T, m *mdnsResponder) *mdnsResponder {
+	t.Helper()
+	r, err := newMDNSResponder(m.instance[0], m.host[0], m.port, m.txt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.rename()
+	return r
+}
+
+func TestMDNSTieBreak(t *testing.T) {
+	m := testResponder(t)
+	// The TXT records are the same, and the SRV records, which sort
+	// after them, differ in the port.
+	earlier, later := testResponder(t), testResponder(t)
+	earlier.port, later.port = m.port-1, m.port+1
+	probe := func(r *mdnsResponder) *dnsMessage {
+		return &dnsMessage{questions: []dnsQuestion{{r.instance, dnsTypeANY, dnsClassIN}}, authorities: r.unique()}
+	}
+	for _, tc := range []struct {
+		name string
+		msg  *dnsMessage
+		want bool
+	}{
+		{"our own probe", probe(m), false},
+		{"an earlier probe", probe(earlier), false},
+		{"a later probe", probe(later), true},
+		{"a probe for another name", probe(testRenamed(t, later)), false},
+		{"a response", &dnsMessage{response: true, authorities: later.unique()}, false},
+	} {
+		if got := m.losesTieBreak(tc.msg); got != tc.want {
+			t.Errorf("%v: losesTieBreak %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// sent is a message sent by a responder.
+type sent struct {
+	msg *dnsMessage
+	to  *net.UDPAddr
+}
+
+// runMDNS runs f, which probes or serves, feeding it in and collecting
+// what it sends. It returns a channel with the result of f.
+func runMDNS(t *testing.T, ctx context.Context, f func(context.Context, func(*dnsMessage, *net.UDPAddr), <-chan mdnsPacket) error, in <-chan mdnsPacket) (<-chan sent, <-chan error) {
+	t.Helper()
+	out := make(chan sent, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- f(ctx, func(msg *dnsMessage, to *net.UDPAddr) { out <- sent{msg, to} }, in)
+	}()
+	return out, done
+}
+
+func receiveSent(t *testing.T, out <-chan sent) sent {
+	t.Helper()
+	select {
+	case s := <-out:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing sent")
+	}
+	panic("unreachable")
+}
+
+func TestMDNSProbe(t *testing.T) {
+	defer func(old time.Duration) { mdnsProbeWait = old }(mdnsProbeWait)
+	mdnsProbeWait = 10 * time.Millisecond
+
+	m := testResponder(t)
+	other := testResponder(t)
+	other.port = 8012
+	in := make(chan mdnsPacket)
+	out, done := runMDNS(t, context.Background(), m.probe, in)
+
+	s := receiveSent(t, out)
+	if s.msg.response || !s.msg.questions[0].name.equal(name{"sds011d on pi", "_sds011", "_tcp", "local"}) || len(s.msg.authorities) != 2 || s.to != mdnsGroup {
+		t.Fatalf("first probe: %+v to %v", s.msg, s.to)
+	}
+	// Somebody has the name already.
+	in <- mdnsPacket{&dnsMessage{response: true, answers: []dnsRecord{other.srv(mdnsHostTTL)}}, mdnsGroup}
+	for i := 0; i < mdnsProbes; {
+		s := receiveSent(t, out)
+		switch got := s.msg.questions[0].name[0]; got {
+		case "sds011d on pi":
+			// Sent before the response arrived.
+			if i > 0 {
+				t.Errorf("probe for %q after the conflict", got)
+			}
+		case "sds011d on pi (2)":
+			i++
+		default:
+			t.Fatalf("probe for %q", got)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("probe: %v", err)
+	}
+	if len(out) != 0 {
+		t.Errorf("%d more probes, want %d", len(out), mdnsProbes)
+	}
+}
+
+func TestMDNSServe(t *testing.T) {
+	m := testResponder(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan mdnsPacket)
+	out, done := runMDNS(t, ctx, m.serve, in)
+
+	if s := receiveSent(t, out); !s.msg.response || len(s.msg.answers) < 3 || s.msg.answers[0].ttl != mdnsTTL {
+		t.Errorf("announcement: %+v", s.msg)
+	}
+
+	// A legacy resolver gets a plain DNS response.
+	legacy := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 3), Port: 40000}
+	in <- mdnsPacket{&dnsMessage{id: 9, questions: []dnsQuestion{{m.instance, dnsTypeSRV, dnsClassIN}}}, legacy}
+	for {
+		s := receiveSent(t, out)
+		if s.to == mdnsGroup {
+			// The second announcement.
+			continue
+		}
+		if s.to != legacy || s.msg.id != 9 || len(s.msg.questions) != 1 || len(s.msg.answers) != 1 || s.msg.answers[0].class != dnsClassIN {
+			t.Errorf("legacy response: %+v to %v", s.msg, s.to)
+		}
+		break
+	}
+
+	// The name turns out to be taken after all.
+	other := testResponder(t)
+	other.port = 8012
+	in <- mdnsPacket{&dnsMessage{response: true, answers: []dnsRecord{other.txtRecord(mdnsTTL), other.srv(mdnsHostTTL)}}, mdnsGroup}
+	if err := <-done; err != errNameConflict {
+		t.Errorf("serve: %v, want %v", err, errNameConflict)
+	}
+}
+
+func TestMDNSServeGoodbye(t *testing.T) {
+	m := testResponder(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan mdnsPacket)
+	out, done := runMDNS(t, ctx, m.serve, in)
+	receiveSent(t, out)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	for len(out) > 0 {
+		if s := <-out; s.msg.answers[0].ttl == 0 {
+			return
+		}
+	}
+	t.Error("no goodbye")
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// The Modbus TCP server. Every sensor gets a block of
+// modbusBlockSize registers, in the order the sensors appear in the
+// config, so the first sensor starts at address 0, the second at
+// address 10 and so on:
+//
+//	+0  PM2.5 in tenths of µg/m³
+//	+1  PM10 in tenths of µg/m³
+//	+2  status: 0 no measurement yet, 1 ok, 2 the last read failed
+//	+3  age of the latest measurement in seconds (65535 if older)
+//
+// The registers can be read both as holding registers (function 3)
+// and as input registers (function 4). The unit identifier is
+// ignored. The remaining registers of every block are reserved and
+// read as 0.
+
+const (
+	modbusBlockSize = 10
+
+	modbusReadHoldingRegisters = 0x03
+	modbusReadInputRegisters   = 0x04
+
+	modbusIllegalFunction    = 0x01
+	modbusIllegalDataAddress = 0x02
+	modbusIllegalDataValue   = 0x03
+
+	// modbusMaxRegisters is the most registers a single request may
+	// read.
+	modbusMaxRegisters = 125
+)
+
+// serveModbus accepts Modbus TCP connections on l and answers them
+// with the measurements known to d.
+func (d *daemon) serveModbus(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.V(1).Infof("modbus: %v", err)
+			return
+		}
+		go d.handleModbus(conn)
+	}
+}
+
+func (d *daemon) handleModbus(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		// The MBAP header: transaction id, protocol id, length of
+		// the rest and unit id.
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				log.V(1).Infof("modbus %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			log.V(1).Infof("modbus %v: bad header % x", conn.RemoteAddr(), header)
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(r, pdu); err != nil {
+			log.V(1).Infof("modbus %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+		resp := d.modbusResponse(pdu)
+		out := make([]byte, 7, 7+len(resp))
+		copy(out, header[:4])
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(resp)+1))
+		out[6] = header[6]
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			log.V(1).Infof("modbus %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func modbusException(function, code byte) []byte {
+	return []byte{function | 0x80, code}
+}
+
+// modbusResponse returns the response PDU to the request pdu.
+func (d *daemon) modbusResponse(pdu []byte) []byte {
+	function := pdu[0]
+	if function != modbusReadHoldingRegisters && function != modbusReadInputRegisters {
+		return modbusException(function, modbusIllegalFunction)
+	}
+	if len(pdu) != 5 {
+		return modbusException(function, modbusIllegalDataValue)
+	}
+	start := int(binary.BigEndian.Uint16(pdu[1:3]))
+	count := int(binary.BigEndian.Uint16(pdu[3:5]))
+	if count < 1 || count > modbusMaxRegisters {
+		return modbusException(function, modbusIllegalDataValue)
+	}
+	if start+count > len(d.names)*modbusBlockSize {
+		return modbusException(function, modbusIllegalDataAddress)
+	}
+	resp := make([]byte, 2, 2+2*count)
+	resp[0], resp[1] = function, byte(2*count)
+	now := time.Now()
+	for addr := start; addr < start+count; addr++ {
+		resp = binary.BigEndian.AppendUint16(resp, d.modbusRegister(addr, now))
+	}
+	return resp
+}
+
+// modbusRegister returns the value of the register at addr.
+func (d *daemon) modbusRegister(addr int, now time.Time) uint16 {
+	name := d.names[addr/modbusBlockSize]
+	latest := d.hub.Latest(name)
+	switch addr % modbusBlockSize {
+	case 0:
+		if latest != nil {
+			return modbusValue(latest.PM25 * 10)
+		}
+	case 1:
+		if latest != nil {
+			return modbusValue(latest.PM10 * 10)
+		}
+	case 2:
+		return uint16(d.status(name))
+	case 3:
+		if latest != nil {
+			return modbusValue(now.Sub(latest.Timestamp).Seconds())
+		}
+		return math.MaxUint16
+	}
+	return 0
+}
+
+// modbusValue rounds v to fit in a register.
+func modbusValue(v float64) uint16 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= math.MaxUint16:
+		return math.MaxUint16
+	}
+	return uint16(math.Round(v))
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// modbusTestConn connects to the Modbus server of d over a pipe.
+func modbusTestConn(t *testing.T, d *daemon) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.handleModbus(server)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+// modbusFrame returns a frame with the MBAP header of transaction id
+// and unit 0xff, and pdu.
+func modbusFrame(id uint16, pdu []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pdu)+1))
+	return append(append(b, 0xff), pdu...)
+}
+
+func modbusRead(function byte, start, count uint16) []byte {
+	pdu := []byte{function}
+	pdu = binary.BigEndian.AppendUint16(pdu, start)
+	return binary.BigEndian.AppendUint16(pdu, count)
+}
+
+// modbusRegisters returns the response PDU with values.
+func modbusRegisters(function byte, values ...uint16) []byte {
+	pdu := []byte{function, byte(2 * len(values))}
+	for _, v := range values {
+		pdu = binary.BigEndian.AppendUint16(pdu, v)
+	}
+	return pdu
+}
+
+func TestModbus(t *testing.T) {
+	d, _, _ := testDaemon(t, twoSensors)
+	d.hub.Write(&sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 12.34, PM10: 56.78, Timestamp: time.Now().Add(-5 * time.Second)}})
+	kitchen := []uint16{123, 568, statusOK, 5, 0, 0, 0, 0, 0, 0}
+	garden := []uint16{0, 0, statusNoData, math.MaxUint16, 0, 0, 0, 0, 0, 0}
+	conn := modbusTestConn(t, d)
+	for i, tc := range []struct {
+		name string
+		pdu  []byte
+		want []byte
+	}{
+		{"holding registers", modbusRead(modbusReadHoldingRegisters, 0, 4), modbusRegisters(modbusReadHoldingRegisters, kitchen[:4]...)},
+		{"input registers", modbusRead(modbusReadInputRegisters, 0, 4), modbusRegisters(modbusReadInputRegisters, kitchen[:4]...)},
+		{"second sensor", modbusRead(modbusReadInputRegisters, 10, 4), modbusRegisters(modbusReadInputRegisters, garden[:4]...)},
+		{"every register", modbusRead(modbusReadHoldingRegisters, 0, 20), modbusRegisters(modbusReadHoldingRegisters, append(kitchen, garden...)...)},
+		{"across blocks", modbusRead(modbusReadHoldingRegisters, 9, 2), modbusRegisters(modbusReadHoldingRegisters, 0, 0)},
+		{"write single register", []byte{0x06, 0, 0, 0, 1}, []byte{0x86, modbusIllegalFunction}},
+		{"unknown function", []byte{0x2b}, []byte{0xab, modbusIllegalFunction}},
+		{"past the last sensor", modbusRead(modbusReadHoldingRegisters, 20, 1), []byte{0x83, modbusIllegalDataAddress}},
+		{"ending past the last sensor", modbusRead(modbusReadInputRegisters, 15, 6), []byte{0x84, modbusIllegalDataAddress}},
+		{"far past the last sensor", modbusRead(modbusReadInputRegisters, 0xffff, 1), []byte{0x84, modbusIllegalDataAddress}},
+		{"no registers", modbusRead(modbusReadHoldingRegisters, 0, 0), []byte{0x83, modbusIllegalDataValue}},
+		{"too many registers", modbusRead(modbusReadHoldingRegisters, 0, modbusMaxRegisters+1), []byte{0x83, modbusIllegalDataValue}},
+		{"short request", []byte{modbusReadHoldingRegisters, 0, 0, 0}, []byte{0x83, modbusIllegalDataValue}},
+		{"long request", append(modbusRead(modbusReadHoldingRegisters, 0, 1), 0), []byte{0x83, modbusIllegalDataValue}},
+	} {
+		id := uint16(0x100 + i)
+		if _, err := conn.Write(modbusFrame(id, tc.pdu)); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		want := modbusFrame(id, tc.want)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: % x, want % x", tc.name, got, want)
+		}
+	}
+}
+
+func TestModbusBadFrames(t *testing.T) {
+	d, _, _ := testDaemon(t, twoSensors)
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"no function", modbusFrame(1, nil)},
+		{"length of 0", []byte{0, 1, 0, 0, 0, 0, 0xff}},
+		{"oversized", append([]byte{0, 1, 0, 0, 0x01, 0x00, 0xff}, make([]byte, 255)...)},
+		{"not Modbus", append([]byte{0, 1, 0, 1, 0, 6, 0xff}, modbusRead(modbusReadHoldingRegisters, 0, 1)...)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := modbusTestConn(t, d)
+			go conn.Write(tc.frame)
+			if b, err := io.ReadAll(conn); len(b) > 0 || err != nil {
+				t.Errorf("read % x, %v; want the connection closed without an answer", b, err)
+			}
+		})
+	}
+}
+
+func TestModbusValue(t *testing.T) {
+	for _, tc := range []struct {
+		v    float64
+		want uint16
+	}{
+		{-1, 0},
+		{0, 0},
+		{123.4, 123},
+		{123.5, 124},
+		{65534.6, 65535},
+		{1e9, 65535},
+	} {
+		if got := modbusValue(tc.v); got != tc.want {
+			t.Errorf("modbusValue(%v): %v, want %v", tc.v, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,522 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// The embedded MQTT broker, for setups too small to run one of their
+// own. It speaks MQTT 3.1.1 (and 3.1), and delivers everything at QoS
+// 0: the QoS clients ask for is acknowledged, but not kept to. It has
+// no persistent sessions, wills or bridges; it's meant for a few
+// clients on the local network, like Home Assistant.
+//
+// The daemon publishes the readings of every sensor to it, retained,
+// as JSON objects like those of the jsonl sink, to <topic>/<sensor>.
+
+// The types of MQTT control packets.
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttPubrec      = 5
+	mqttPubrel      = 6
+	mqttPubcomp     = 7
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+// The return codes of CONNACK.
+const (
+	mqttAccepted       = 0
+	mqttBadProtocol    = 1
+	mqttBadCredentials = 4
+)
+
+const (
+	// mqttMaxPacket is the size of the largest packet accepted.
+	mqttMaxPacket = 256 * 1024
+	// mqttClientQueue is how many packets may wait to be sent to a
+	// client before new ones are dropped.
+	mqttClientQueue = 256
+	// mqttIdleTimeout is how long a client that didn't ask for a
+	// keep alive may be silent.
+	mqttIdleTimeout = 5 * time.Minute
+)
+
+// mqttMessage is a message published to the broker.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttBroker routes messages between its clients, and keeps the
+// retained ones.
+type mqttBroker struct {
+	// username and password, if set, are required of clients.
+	username, password string
+
+	mu       sync.Mutex
+	clients  map[*mqttClient]bool
+	retained map[string][]byte
+}
+
+func newMQTTBroker(username, password string) *mqttBroker {
+	return &mqttBroker{
+		username: username,
+		password: password,
+		clients:  make(map[*mqttClient]bool),
+		retained: make(map[string][]byte),
+	}
+}
+
+// mqttClient is a connection to the broker.
+type mqttClient struct {
+	conn net.Conn
+	// out are the packets to send; it's closed when the client is
+	// removed.
+	out chan []byte
+
+	// filters are the topic filters the client subscribed to, guarded
+	// by the broker's lock.
+	filters map[string]bool
+}
+
+// serve accepts MQTT connections on l.
+func (b *mqttBroker) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.V(1).Infof("mqtt: %v", err)
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+// publish sends a message to the clients subscribed to its topic, and,
+// if retain is set, keeps it for those that subscribe later. A retained
+// message with an empty payload removes the one kept for its topic.
+func (b *mqttBroker) publish(m mqttMessage, retain bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if retain {
+		if len(m.payload) == 0 {
+			delete(b.retained, m.topic)
+		} else {
+			b.retained[m.topic] = m.payload
+		}
+	}
+	packet := mqttPublishPacket(m, false)
+	for c := range b.clients {
+		for filter := range c.filters {
+			if mqttMatch(filter, m.topic) {
+				c.send(packet)
+				break
+			}
+		}
+	}
+}
+
+// send queues packet for the client, dropping it if the client isn't
+// keeping up. It must be called with the broker's lock held.
+func (c *mqttClient) send(packet []byte) {
+	select {
+	case c.out <- packet:
+	default:
+		log.V(1).Infof("mqtt %v: dropping a message for a slow client", c.conn.RemoteAddr())
+	}
+}
+
+// mqttMatch returns whether topic matches filter, in which + matches a
+// level and a final # any number of them. Topics starting with $ are
+// only matched by filters starting with $ too.
+func mqttMatch(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") != strings.HasPrefix(filter, "$") {
+		return false
+	}
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// mqttPacket returns a packet with the given first byte and the rest.
+func mqttPacket(header byte, rest []byte) []byte {
+	p := []byte{header}
+	n := len(rest)
+	for {
+		digit := byte(n % 128)
+		if n /= 128; n > 0 {
+			digit |= 0x80
+		}
+		p = append(p, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, rest...)
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func mqttPublishPacket(m mqttMessage, retain bool) []byte {
+	header := byte(mqttPublish << 4)
+	if retain {
+		header |= 1
+	}
+	return mqttPacket(header, append(mqttString(nil, m.topic), m.payload...))
+}
+
+// readMQTTPacket reads a packet, returning its first byte and the rest.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(digit&0x7f) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("bad remaining length")
+		}
+	}
+	if n > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", n)
+	}
+	rest := make([]byte, n)
+	_, err = io.ReadFull(r, rest)
+	return header, rest, err
+}
+
+// mqttReader reads the fields of a packet.
+type mqttReader struct {
+	b   []byte
+	err error
+}
+
+func (r *mqttReader) bytes(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		r.err = errors.New("malformed packet")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *mqttReader) u8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *mqttReader) u16() int {
+	if b := r.bytes(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *mqttReader) string() string {
+	return string(r.bytes(r.u16()))
+}
+
+// handle serves a client until it disconnects.
+func (b *mqttBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	keepAlive, err := b.connect(conn, r)
+	if err != nil {
+		log.V(1).Infof("mqtt %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	c := &mqttClient{conn: conn, out: make(chan []byte, mqttClientQueue), filters: make(map[string]bool)}
+	b.mu.Lock()
+	b.clients[c] = true
+	b.mu.Unlock()
+	defer b.remove(c)
+	go func() {
+		for packet := range c.out {
+			if _, err := conn.Write(packet); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	for {
+		// A client that's silent for half as long again as it said
+		// it would be is gone.
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(mqttIdleTimeout))
+		}
+		header, rest, err := readMQTTPacket(r)
+		if err != nil {
+			if err != io.EOF {
+				log.V(1).Infof("mqtt %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if header>>4 == mqttDisconnect {
+			return
+		}
+		if err := b.handlePacket(c, header, rest); err != nil {
+			log.V(1).Infof("mqtt %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// connect reads the CONNECT packet, and answers it. It returns the keep
+// alive the client asked for.
+func (b *mqttBroker) connect(conn net.Conn, r *bufio.Reader) (time.Duration, error) {
+	header, rest, err := readMQTTPacket(r)
+	if err != nil {
+		return 0, err
+	}
+	if header>>4 != mqttConnect {
+		return 0, fmt.Errorf("expected CONNECT, got packet type %d", header>>4)
+	}
+	p := &mqttReader{b: rest}
+	protocol, level, flags, keepAlive := p.string(), p.u8(), p.u8(), p.u16()
+	p.string() // The client ID.
+	if flags&0x04 != 0 {
+		p.string() // The will topic and message.
+		p.string()
+	}
+	var username, password string
+	if flags&0x80 != 0 {
+		username = p.string()
+	}
+	if flags&0x40 != 0 {
+		password = p.string()
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	code := byte(mqttAccepted)
+	switch {
+	case !(protocol == "MQTT" && level == 4) && !(protocol == "MQIsdp" && level == 3):
+		code = mqttBadProtocol
+	case b.username != "" && (subtle.ConstantTimeCompare([]byte(username), []byte(b.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(b.password)) != 1):
+		code = mqttBadCredentials
+	}
+	if _, err := conn.Write(mqttPacket(mqttConnack<<4, []byte{0, code})); err != nil {
+		return 0, err
+	}
+	if code != mqttAccepted {
+		return 0, fmt.Errorf("refused the connection of %q, with code %d", username, code)
+	}
+	return time.Duration(keepAlive) * time.Second, nil
+}
+
+// handlePacket handles a packet other than CONNECT and DISCONNECT.
+func (b *mqttBroker) handlePacket(c *mqttClient, header byte, rest []byte) error {
+	p := &mqttReader{b: rest}
+	switch header >> 4 {
+	case mqttPublish:
+		qos, retain := header>>1&3, header&1 != 0
+		topic := p.string()
+		var id []byte
+		if qos > 0 {
+			id = p.bytes(2)
+		}
+		if p.err != nil {
+			return p.err
+		}
+		if strings.ContainsAny(topic, "+#") || qos > 2 {
+			return fmt.Errorf("bad PUBLISH to %q", topic)
+		}
+		b.publish(mqttMessage{topic, p.b}, retain)
+		switch qos {
+		case 1:
+			b.reply(c, mqttPacket(mqttPuback<<4, id))
+		case 2:
+			b.reply(c, mqttPacket(mqttPubrec<<4, id))
+		}
+	case mqttPubrel:
+		b.reply(c, mqttPacket(mqttPubcomp<<4, p.bytes(2)))
+	case mqttSubscribe:
+		id := p.bytes(2)
+		var filters []string
+		for p.err == nil && len(p.b) > 0 {
+			filters = append(filters, p.string())
+			p.u8() // The QoS asked for.
+		}
+		if p.err != nil || len(filters) == 0 {
+			return errors.New("malformed SUBSCRIBE")
+		}
+		b.subscribe(c, id, filters)
+	case mqttUnsubscribe:
+		id := p.bytes(2)
+		b.mu.Lock()
+		for p.err == nil && len(p.b) > 0 {
+			delete(c.filters, p.string())
+		}
+		b.mu.Unlock()
+		b.reply(c, mqttPacket(mqttUnsuback<<4, id))
+	case mqttPingreq:
+		b.reply(c, mqttPacket(mqttPingresp<<4, nil))
+	case mqttPuback, mqttPubrec, mqttPubcomp:
+		// Nothing is sent at QoS 1 or 2, so there's nothing to
+		// acknowledge.
+	default:
+		return fmt.Errorf("unexpected packet type %d", header>>4)
+	}
+	return p.err
+}
+
+// subscribe subscribes c to filters, acknowledging them all at QoS 0,
+// and sends it the retained messages they match.
+func (b *mqttBroker) subscribe(c *mqttClient, id []byte, filters []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	codes := make([]byte, len(filters))
+	for i, f := range filters {
+		if !mqttValidFilter(f) {
+			codes[i] = 0x80
+			continue
+		}
+		c.filters[f] = true
+	}
+	c.send(mqttPacket(mqttSuback<<4, append(id, codes...)))
+	for topic, payload := range b.retained {
+		for i, f := range filters {
+			if codes[i] == 0 && mqttMatch(f, topic) {
+				c.send(mqttPublishPacket(mqttMessage{topic, payload}, true))
+				break
+			}
+		}
+	}
+}
+
+// mqttValidFilter returns whether f is a valid topic filter: # only as
+// the last level, and wildcards only as whole levels.
+func mqttValidFilter(f string) bool {
+	if f == "" {
+		return false
+	}
+	levels := strings.Split(f, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 || level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *mqttBroker) reply(c *mqttClient, packet []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c.send(packet)
+}
+
+// remove disconnects c.
+func (b *mqttBroker) remove(c *mqttClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clients[c] {
+		delete(b.clients, c)
+		close(c.out)
+	}
+}
+
+// objectID makes a sensor name fit in a Home Assistant object ID.
+var objectID = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// discovery publishes, retained, the configs with which Home Assistant
+// discovers the PM2.5 and PM10 of every sensor, under prefix.
+func (b *mqttBroker) discovery(prefix, topic string, names []string) {
+	for _, name := range names {
+		for _, level := range []struct{ key, label string }{{"pm25", "PM2.5"}, {"pm10", "PM10"}} {
+			id := "sds011_" + objectID.ReplaceAllString(name, "_") + "_" + level.key
+			config, _ := json.Marshal(map[string]string{
+				"name":                name + " " + level.label,
+				"unique_id":           id,
+				"state_topic":         topic + "/" + name,
+				"value_template":      "{{ value_json." + level.key + " }}",
+				"unit_of_measurement": "µg/m³",
+				"device_class":        level.key,
+				"state_class":         "measurement",
+			})
+			b.publish(mqttMessage{prefix + "/sensor/" + id + "/config", config}, true)
+		}
+	}
+}
+
+// publishReadings publishes the readings of d to the broker, retained,
+// until ctx is done.
+func (d *daemon) publishReadings(ctx context.Context, b *mqttBroker, topic string) {
+	ch := d.hub.subscribe(subscription{policy: dropOldest}, defaultStreamBuffer)
+	defer d.hub.unsubscribe(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r, ok := <-ch:
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(r)
+			if err != nil {
+				log.Errorf("mqtt: %v", err)
+				continue
+			}
+			b.publish(mqttMessage{topic + "/" + r.Sensor, payload}, true)
+		}
+	}
+}
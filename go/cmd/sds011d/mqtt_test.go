@@ -0,0 +1,360 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// mqttConnectPacket returns a CONNECT of protocol and level, with the
+// username and password if they're set.
+func mqttConnectPacket(protocol string, level byte, username, password string) []byte {
+	var flags byte = 0x02 // Clean session.
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	b := mqttString(nil, protocol)
+	b = append(b, level, flags, 0, 60)
+	b = mqttString(b, "test")
+	if username != "" {
+		b = mqttString(b, username)
+	}
+	if password != "" {
+		b = mqttString(b, password)
+	}
+	return mqttPacket(mqttConnect<<4, b)
+}
+
+// mqttTestConn is the client end of a connection to a broker.
+type mqttTestConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialMQTT connects to b over a pipe, without sending anything.
+func dialMQTT(t *testing.T, b *mqttBroker) *mqttTestConn {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		b.handle(server)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return &mqttTestConn{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+// connectMQTT connects to b, and checks that the broker accepts it.
+func connectMQTT(t *testing.T, b *mqttBroker, username, password string) *mqttTestConn {
+	t.Helper()
+	c := dialMQTT(t, b)
+	c.write(mqttConnectPacket("MQTT", 4, username, password))
+	if rest := c.expect(mqttConnack << 4); !bytes.Equal(rest, []byte{0, mqttAccepted}) {
+		t.Fatalf("CONNACK % x, want 00 00", rest)
+	}
+	return c
+}
+
+func (c *mqttTestConn) write(packet []byte) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write(packet); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads a packet, checks its first byte and returns the rest.
+func (c *mqttTestConn) expect(header byte) []byte {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, rest, err := readMQTTPacket(c.r)
+	if err != nil {
+		c.t.Fatalf("expecting a packet %#02x: %v", header, err)
+	}
+	if got != header {
+		c.t.Fatalf("got packet %#02x (% x), want %#02x", got, rest, header)
+	}
+	return rest
+}
+
+// expectPublish reads a PUBLISH, and checks its retain flag, topic and
+// payload.
+func (c *mqttTestConn) expectPublish(retain bool, topic, payload string) {
+	c.t.Helper()
+	header := byte(mqttPublish << 4)
+	if retain {
+		header |= 1
+	}
+	rest := c.expect(header)
+	p := &mqttReader{b: rest}
+	if got := p.string(); got != topic || string(p.b) != payload {
+		c.t.Errorf("PUBLISH of %q to %q, want %q to %q", p.b, got, payload, topic)
+	}
+}
+
+// expectClosed checks that the broker closes the connection.
+func (c *mqttTestConn) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if header, rest, err := readMQTTPacket(c.r); err != io.EOF {
+		c.t.Errorf("got packet %#02x (% x), %v; want the connection closed", header, rest, err)
+	}
+}
+
+// subscribe subscribes to filters, asking for QoS 1, and returns the
+// return codes of the SUBACK.
+func (c *mqttTestConn) subscribe(id uint16, filters ...string) []byte {
+	c.t.Helper()
+	b := []byte{byte(id >> 8), byte(id)}
+	for _, f := range filters {
+		b = append(mqttString(b, f), 1)
+	}
+	c.write(mqttPacket(mqttSubscribe<<4|2, b))
+	rest := c.expect(mqttSuback << 4)
+	if len(rest) < 2 || rest[0] != byte(id>>8) || rest[1] != byte(id) {
+		c.t.Fatalf("SUBACK % x, want packet ID %04x", rest, id)
+	}
+	return rest[2:]
+}
+
+func TestMQTTConnect(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		username, password string // Those the broker requires.
+		packet             []byte
+		want               byte
+	}{
+		{"anonymous", "", "", mqttConnectPacket("MQTT", 4, "", ""), mqttAccepted},
+		{"MQTT 3.1", "", "", mqttConnectPacket("MQIsdp", 3, "", ""), mqttAccepted},
+		{"credentials not required", "", "", mqttConnectPacket("MQTT", 4, "ha", "secret"), mqttAccepted},
+		{"good credentials", "ha", "secret", mqttConnectPacket("MQTT", 4, "ha", "secret"), mqttAccepted},
+		{"bad password", "ha", "secret", mqttConnectPacket("MQTT", 4, "ha", "guess"), mqttBadCredentials},
+		{"bad username", "ha", "secret", mqttConnectPacket("MQTT", 4, "root", "secret"), mqttBadCredentials},
+		{"no password", "ha", "secret", mqttConnectPacket("MQTT", 4, "ha", ""), mqttBadCredentials},
+		{"no credentials", "ha", "secret", mqttConnectPacket("MQTT", 4, "", ""), mqttBadCredentials},
+		{"MQTT 5", "", "", mqttConnectPacket("MQTT", 5, "", ""), mqttBadProtocol},
+		{"bad protocol name", "", "", mqttConnectPacket("MQTX", 4, "", ""), mqttBadProtocol},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialMQTT(t, newMQTTBroker(tc.username, tc.password))
+			c.write(tc.packet)
+			if rest := c.expect(mqttConnack << 4); !bytes.Equal(rest, []byte{0, tc.want}) {
+				t.Fatalf("CONNACK % x, want 00 %02x", rest, tc.want)
+			}
+			if tc.want != mqttAccepted {
+				c.expectClosed()
+				return
+			}
+			c.write(mqttPacket(mqttPingreq<<4, nil))
+			c.expect(mqttPingresp << 4)
+		})
+	}
+}
+
+func TestMQTTConnectFirst(t *testing.T) {
+	c := dialMQTT(t, newMQTTBroker("", ""))
+	c.write(mqttPacket(mqttPingreq<<4, nil))
+	c.expectClosed()
+}
+
+func TestMQTTMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"sds011/balcony", "sds011/balcony", true},
+		{"sds011/balcony", "sds011/kitchen", false},
+		{"sds011/balcony", "sds011", false},
+		{"sds011", "sds011/balcony", false},
+		{"sds011/+", "sds011/balcony", true},
+		{"sds011/+", "sds011", false},
+		{"sds011/+", "sds011/balcony/pm25", false},
+		{"+/balcony", "sds011/balcony", true},
+		{"+/+", "/balcony", true},
+		{"sds011/+/pm25", "sds011/balcony/pm25", true},
+		{"sds011/+/pm25", "sds011/balcony/pm10", false},
+		{"sds011/#", "sds011/balcony", true},
+		{"sds011/#", "sds011/balcony/pm25", true},
+		{"sds011/#", "sds011", true},
+		{"sds011/#", "homeassistant/sensor", false},
+		{"#", "sds011/balcony", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	} {
+		if got := mqttMatch(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("mqttMatch(%q, %q): %v, want %v", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestMQTTRetained(t *testing.T) {
+	b := newMQTTBroker("", "")
+	b.publish(mqttMessage{"sds011/balcony", []byte(`{"pm25": 10}`)}, true)
+	b.publish(mqttMessage{"sds011/kitchen", []byte(`{"pm25": 20}`)}, true)
+	b.publish(mqttMessage{"sds011/kitchen", nil}, true)
+	b.publish(mqttMessage{"sds011/garden", []byte(`{"pm25": 30}`)}, false)
+	b.publish(mqttMessage{"homeassistant/status", []byte("online")}, true)
+
+	// A client that subscribes later gets what was retained, and only
+	// that, flagged as retained.
+	c := connectMQTT(t, b, "", "")
+	if codes := c.subscribe(1, "sds011/+"); !bytes.Equal(codes, []byte{0}) {
+		t.Errorf("SUBACK codes % x, want 00", codes)
+	}
+	c.expectPublish(true, "sds011/balcony", `{"pm25": 10}`)
+
+	// What's published after it subscribed isn't flagged, whether it's
+	// retained or not.
+	b.publish(mqttMessage{"homeassistant/status", []byte("offline")}, true)
+	b.publish(mqttMessage{"sds011/kitchen", []byte(`{"pm25": 21}`)}, true)
+	c.expectPublish(false, "sds011/kitchen", `{"pm25": 21}`)
+
+	// Nor is what another client publishes.
+	other := connectMQTT(t, b, "", "")
+	other.write(mqttPublishPacket(mqttMessage{"sds011/garden", []byte(`{"pm25": 31}`)}, true))
+	c.expectPublish(false, "sds011/garden", `{"pm25": 31}`)
+
+	// Both are retained now.
+	late := connectMQTT(t, b, "", "")
+	late.subscribe(2, "sds011/kitchen", "sds011/garden")
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rest := late.expect(mqttPublish<<4 | 1)
+		p := &mqttReader{b: rest}
+		got[p.string()+" "+string(p.b)] = true
+	}
+	if !got[`sds011/kitchen {"pm25": 21}`] || !got[`sds011/garden {"pm25": 31}`] {
+		t.Errorf("retained messages %v, want those of the kitchen and garden", got)
+	}
+}
+
+func TestMQTTQoS1(t *testing.T) {
+	b := newMQTTBroker("", "")
+	c := connectMQTT(t, b, "", "")
+
+	// Subscriptions at QoS 1 are granted at QoS 0; bad filters are
+	// refused, without refusing the rest.
+	codes := c.subscribe(0x1234, "sds011/#", "sds011/#/pm25", "sds011/bal+", "sds011/+/pm25")
+	if want := []byte{0, 0x80, 0x80, 0}; !bytes.Equal(codes, want) {
+		t.Errorf("SUBACK codes % x, want % x", codes, want)
+	}
+
+	// A PUBLISH at QoS 1 is acknowledged with its packet ID, and
+	// delivered at QoS 0.
+	rest := append(mqttString(nil, "sds011/balcony/pm25"), 0xab, 0xcd)
+	rest = append(rest, "10.5"...)
+	c.write(mqttPacket(mqttPublish<<4|1<<1, rest))
+	var puback, publish bool
+	for i := 0; i < 2; i++ {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		header, rest, err := readMQTTPacket(c.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch header {
+		case mqttPuback << 4:
+			puback = bytes.Equal(rest, []byte{0xab, 0xcd})
+		case mqttPublish << 4:
+			p := &mqttReader{b: rest}
+			publish = p.string() == "sds011/balcony/pm25" && string(p.b) == "10.5"
+		default:
+			t.Fatalf("got packet %#02x (% x), want PUBACK and PUBLISH", header, rest)
+		}
+	}
+	if !puback || !publish {
+		t.Errorf("PUBACK with the packet ID: %v, PUBLISH at QoS 0: %v; want both", puback, publish)
+	}
+
+	// Unsubscribing is acknowledged, and stops delivery.
+	c.write(mqttPacket(mqttUnsubscribe<<4|2, mqttString([]byte{0, 7}, "sds011/#")))
+	if rest := c.expect(mqttUnsuback << 4); !bytes.Equal(rest, []byte{0, 7}) {
+		t.Errorf("UNSUBACK % x, want 00 07", rest)
+	}
+	c.write(mqttPacket(mqttUnsubscribe<<4|2, mqttString([]byte{0, 8}, "sds011/+/pm25")))
+	c.expect(mqttUnsuback << 4)
+	b.publish(mqttMessage{"sds011/balcony/pm25", []byte("11")}, false)
+	c.write(mqttPacket(mqttPingreq<<4, nil))
+	c.expect(mqttPingresp << 4)
+}
+
+func TestMQTTMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"remaining length of five bytes", []byte{mqttPingreq << 4, 0x80, 0x80, 0x80, 0x80, 0x01}},
+		{"remaining length too large", []byte{mqttPublish << 4, 0xff, 0xff, 0xff, 0x7f}},
+		{"truncated PUBLISH", mqttPacket(mqttPublish<<4, []byte{0, 10, 's'})},
+		{"PUBLISH to a wildcard", mqttPacket(mqttPublish<<4, mqttString(nil, "sds011/+"))},
+		{"PUBLISH at QoS 3", mqttPacket(mqttPublish<<4|3<<1, append(mqttString(nil, "sds011"), 0, 1))},
+		{"SUBSCRIBE without filters", mqttPacket(mqttSubscribe<<4|2, []byte{0, 1})},
+		{"truncated SUBSCRIBE", mqttPacket(mqttSubscribe<<4|2, []byte{0, 1, 0, 8, 's'})},
+		{"second CONNECT", mqttConnectPacket("MQTT", 4, "", "")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := connectMQTT(t, newMQTTBroker("", ""), "", "")
+			c.write(tc.packet)
+			c.expectClosed()
+		})
+	}
+}
+
+func TestMQTTMalformedConnect(t *testing.T) {
+	// The flags say there's a password, but there isn't.
+	noPassword := append(mqttString(nil, "MQTT"), 4, 0xc2, 0, 60)
+	noPassword = mqttString(mqttString(noPassword, "test"), "ha")
+	for _, tc := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"remaining length of five bytes", []byte{mqttConnect << 4, 0x80, 0x80, 0x80, 0x80, 0x01}},
+		{"truncated", mqttPacket(mqttConnect<<4, mqttString(nil, "MQTT"))},
+		{"missing password", mqttPacket(mqttConnect<<4, noPassword)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialMQTT(t, newMQTTBroker("ha", "secret"))
+			c.write(tc.packet)
+			c.expectClosed()
+		})
+	}
+}
+
+func TestMQTTPacketLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152} {
+		packet := mqttPacket(mqttPublish<<4, make([]byte, n))
+		header, rest, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)))
+		switch {
+		case n > mqttMaxPacket:
+			if err == nil {
+				t.Errorf("%d bytes: no error, want one for a packet too large", n)
+			}
+		case err != nil || header != mqttPublish<<4 || len(rest) != n:
+			t.Errorf("%d bytes: %#02x, %d bytes, %v", n, header, len(rest), err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ryszard/sds011/go/alert"
+	"github.com/ryszard/sds011/go/aqi"
+)
+
+// defaultTelegramAPI is where the Telegram Bot API is.
+const defaultTelegramAPI = "https://api.telegram.org"
+
+// mailTimeout is how long mailing a message may take, from connecting
+// to the SMTP server to it accepting the message.
+const mailTimeout = 30 * time.Second
+
+// defaultAlertTemplate is the message notifiers send, unless they have
+// a template of their own.
+const defaultAlertTemplate = `{{.Sensor}}: {{.Severity}} alert {{.Rule}} {{if eq .Kind "start"}}fired{{else}}resolved{{end}}, ` +
+	`{{.Metric}} {{printf "%.1f" .Value}} (threshold {{.Threshold}}). ` +
+	`PM2.5 {{printf "%.1f" .PM25}} µg/m³, PM10 {{printf "%.1f" .PM10}} µg/m³, AQI {{.AQI}} ({{.Category}}).`
+
+// alertMessage is what the templates of the notifiers are executed on:
+// the event, and the reading that caused it.
+type alertMessage struct {
+	*alert.Event
+	PM25, PM10 float64
+	// AQI is the US AQI of the worse of PM2.5 and PM10, and Category
+	// its category, like "Moderate".
+	AQI      int
+	Category string
+}
+
+func newAlertMessage(e *alert.Event) *alertMessage {
+	index := int(math.Max(float64(aqi.PM25(e.Point.PM25)), float64(aqi.PM10(e.Point.PM10))))
+	return &alertMessage{
+		Event:    e,
+		PM25:     e.Point.PM25,
+		PM10:     e.Point.PM10,
+		AQI:      index,
+		Category: aqi.CategoryOf(index).String(),
+	}
+}
+
+// A notifier tells someone about alert events.
+type notifier interface {
+	notify(ctx context.Context, e *alert.Event) error
+}
+
+// newNotifier returns the notifier described by config.
+func newNotifier(config NotifierConfig) (notifier, error) {
+	text := config.Template
+	if text == "" {
+		text = defaultAlertTemplate
+	}
+	tmpl, err := template.New(config.Type).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("alerts: %v template: %v", config.Type, err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch config.Type {
+	case "webhook":
+		return &webhook{url: config.URL, template: tmpl, client: client}, nil
+	case "slack":
+		return &slack{url: config.URL, template: tmpl, client: client}, nil
+	case "telegram":
+		api := config.URL
+		if api == "" {
+			api = defaultTelegramAPI
+		}
+		return &telegram{api: api, token: config.Token, chatID: config.ChatID, template: tmpl, client: client}, nil
+	case "email":
+		return &email{config: config.Mail, template: tmpl}, nil
+	}
+	return nil, fmt.Errorf("alerts: unknown notifier type %q", config.Type)
+}
+
+// message returns the text of the message about e.
+func message(tmpl *template.Template, e *alert.Event) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, newAlertMessage(e)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// postJSON POSTs v to url, as JSON.
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v: %v", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// webhook POSTs the events to an URL, as JSON, with the message in
+// the message field.
+type webhook struct {
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+func (w *webhook) notify(ctx context.Context, e *alert.Event) error {
+	text, err := message(w.template, e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, w.client, w.url, struct {
+		*alert.Event
+		Message string `json:"message"`
+	}{e, text})
+}
+
+// slack posts the messages to a Slack incoming webhook.
+type slack struct {
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+func (s *slack) notify(ctx context.Context, e *alert.Event) error {
+	text, err := message(s.template, e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.client, s.url, map[string]string{"text": text})
+}
+
+// telegram sends the messages to a chat, as a Telegram bot.
+type telegram struct {
+	// api is the URL of the Bot API.
+	api, token, chatID string
+	template           *template.Template
+	client             *http.Client
+}
+
+func (t *telegram) notify(ctx context.Context, e *alert.Event) error {
+	text, err := message(t.template, e)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimSuffix(t.api, "/"), t.token)
+	if err := postJSON(ctx, t.client, url, map[string]string{"chat_id": t.chatID, "text": text}); err != nil {
+		// Don't log the token, which is part of the URL.
+		return fmt.Errorf("telegram: %v", strings.ReplaceAll(err.Error(), t.token, "<token>"))
+	}
+	return nil
+}
+
+// email mails the messages.
+type email struct {
+	config   MailConfig
+	template *template.Template
+}
+
+func (m *email) notify(ctx context.Context, e *alert.Event) error {
+	text, err := message(m.template, e)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Air quality alert %v %v: %v", e.Rule, e.Kind, e.Sensor)
+	return sendMail(ctx, m.config, subject, "text/plain", []byte(text))
+}
+
+// headerValue returns s without line breaks, which would end the
+// header it's the value of and start another.
+func headerValue(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
+
+// sendMail mails body, of the given content type, as configured by
+// mc. It gives up when ctx is done, or after mailTimeout.
+func sendMail(ctx context.Context, mc MailConfig, subject, contentType string, body []byte) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", headerValue(mc.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", headerValue(strings.Join(mc.To, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", headerValue(subject))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s; charset=UTF-8\r\n\r\n", contentType)
+	msg.Write(body)
+
+	host, _, err := net.SplitHostPort(mc.Address)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", mc.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Being cancelled stops the exchange with the server too.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	// This is what smtp.SendMail does, on conn.
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if mc.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", mc.Username, mc.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(mc.From); err != nil {
+		return err
+	}
+	for _, to := range mc.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/alert"
+)
+
+// smtpServer is an SMTP server that accepts one message, and sends
+// what it got on the returned channel. If mute, it never says
+// anything.
+func smtpServer(t *testing.T, mute bool) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if mute {
+			// Wait for the client to give up.
+			conn.Read(make([]byte, 1))
+			return
+		}
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 ok")
+			case "DATA":
+				reply("354 go on")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				got <- data.String()
+				reply("250 ok")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 " + cmd)
+			}
+		}
+	}()
+	return l.Addr().String(), got
+}
+
+func TestHeaderValue(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"kitchen", "kitchen"},
+		{"kitchen\r\nBcc: someone@example.com", "kitchen Bcc: someone@example.com"},
+		{"a\nb\rc", "a b c"},
+	} {
+		if got := headerValue(tc.in); got != tc.want {
+			t.Errorf("headerValue(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestEmailNotify(t *testing.T) {
+	addr, got := smtpServer(t, false)
+	n, err := newNotifier(NotifierConfig{Type: "email", Mail: MailConfig{Address: addr, From: "sds011d@example.com", To: []string{"me@example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &alert.Event{Kind: alert.Start, Rule: "high\r\nBcc: someone@example.com", Sensor: "kitchen", Metric: "pm25"}
+	if err := n.notify(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-got
+	if !strings.Contains(msg, "Subject: Air quality alert high Bcc: someone@example.com start: kitchen\r\n") {
+		t.Errorf("no Subject in one line in:\n%s", msg)
+	}
+	if header, _, _ := strings.Cut(msg, "\r\n\r\n"); strings.Contains(header, "\r\nBcc:") {
+		t.Errorf("a Bcc header got in:\n%s", msg)
+	}
+}
+
+func TestSendMailCancelled(t *testing.T) {
+	addr, _ := smtpServer(t, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- sendMail(ctx, MailConfig{Address: addr, From: "a@example.com", To: []string{"b@example.com"}}, "subject", "text/plain", nil)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("sendMail to a server that doesn't answer: no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sendMail didn't give up when its context was done")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/ryszard/sds011/go/aqi"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// newPipeline returns a sink that applies steps to the readings,
+// in order, before writing them to next.
+func newPipeline(next sink.Sink, steps []PipelineStep) sink.Sink {
+	for i := len(steps) - 1; i >= 0; i-- {
+		switch step := steps[i]; {
+		case step.Average.Duration > 0:
+			next = &averager{next: next, window: step.Average.Duration, windows: make(map[string]*window)}
+		case step.Above != nil:
+			next = &threshold{next: next, above: *step.Above}
+		case step.AQI:
+			next = &nowcaster{next: next, hours: make(map[string]*[2]aqi.Hourly)}
+		case step.Labels != nil:
+			next = &mapper{next: next, f: keepLabels(step.Labels)}
+		}
+	}
+	return next
+}
+
+// A mapper changes every reading before passing it on.
+type mapper struct {
+	next sink.Sink
+	f    func(r *sink.Reading) *sink.Reading
+}
+
+func (m *mapper) Write(r *sink.Reading) error { return m.next.Write(m.f(r)) }
+func (m *mapper) Flush() error                { return m.next.Flush() }
+func (m *mapper) Close() error                { return m.next.Close() }
+
+// keepLabels returns a function that drops all but the given labels.
+func keepLabels(keep []string) func(r *sink.Reading) *sink.Reading {
+	return func(r *sink.Reading) *sink.Reading {
+		labels := make(map[string]string)
+		for _, k := range keep {
+			if v, ok := r.Labels[k]; ok {
+				labels[k] = v
+			}
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
+		return &sink.Reading{Sensor: r.Sensor, Point: r.Point, Seq: r.Seq, Labels: labels, Fields: r.Fields}
+	}
+}
+
+// A nowcaster replaces the PM levels of the readings with the US EPA
+// Air Quality Index values of their NowCast (see package aqi), so that
+// they match what AirNow reports. Until a sensor has readings from two
+// of the last three hours, the index is of the average of the current
+// hour.
+type nowcaster struct {
+	next  sink.Sink
+	hours map[string]*[2]aqi.Hourly
+}
+
+func (n *nowcaster) Write(r *sink.Reading) error {
+	h, ok := n.hours[r.Sensor]
+	if !ok {
+		h = new([2]aqi.Hourly)
+		n.hours[r.Sensor] = h
+	}
+	h[0].Add(r.Timestamp, r.PM25)
+	h[1].Add(r.Timestamp, r.PM10)
+	p := *r.Point
+	p.PM25 = float64(aqi.PM25(nowcast(&h[0], r.Timestamp)))
+	p.PM10 = float64(aqi.PM10(nowcast(&h[1], r.Timestamp)))
+	return n.next.Write(&sink.Reading{Sensor: r.Sensor, Point: &p, Seq: r.Seq, Labels: r.Labels, Fields: r.Fields})
+}
+
+func (n *nowcaster) Flush() error { return n.next.Flush() }
+func (n *nowcaster) Close() error { return n.next.Close() }
+
+// nowcast returns the NowCast of h as of now, or the average of the
+// hour of now if there isn't enough data for it.
+func nowcast(h *aqi.Hourly, now time.Time) float64 {
+	hourly := h.Averages(now)
+	if c, ok := aqi.NowCast(hourly); ok {
+		return c
+	}
+	return hourly[0]
+}
+
+// A threshold passes on only the readings that exceed a level.
+type threshold struct {
+	next  sink.Sink
+	above Levels
+}
+
+func (t *threshold) Write(r *sink.Reading) error {
+	if (t.above.PM25 != nil && r.PM25 > *t.above.PM25) || (t.above.PM10 != nil && r.PM10 > *t.above.PM10) {
+		return t.next.Write(r)
+	}
+	return nil
+}
+
+func (t *threshold) Flush() error { return t.next.Flush() }
+func (t *threshold) Close() error { return t.next.Close() }
+
+// An averager replaces the readings of every sensor with their
+// averages over windows aligned to the clock: a 5 minute window starts
+// at :00, :05, and so on. The average, timestamped with the start of
+// the window and numbered as its last reading, is passed on when the first reading after the window
+// arrives, or when the averager is closed.
+type averager struct {
+	next    sink.Sink
+	window  time.Duration
+	windows map[string]*window
+}
+
+// window is the window of a sensor being averaged.
+type window struct {
+	start      time.Time
+	sum25      float64
+	sum10      float64
+	count      int
+	lastSeq    uint64
+	lastLabels map[string]string
+	lastFields map[string]float64
+}
+
+func (w *window) reading(sensor string) *sink.Reading {
+	return &sink.Reading{
+		Sensor: sensor,
+		Point: &sds011.Point{
+			PM25:      w.sum25 / float64(w.count),
+			PM10:      w.sum10 / float64(w.count),
+			Timestamp: w.start,
+		},
+		Seq:    w.lastSeq,
+		Labels: w.lastLabels,
+		Fields: w.lastFields,
+	}
+}
+
+func (a *averager) Write(r *sink.Reading) error {
+	start := r.Timestamp.Truncate(a.window)
+	w := a.windows[r.Sensor]
+	var err error
+	if w != nil && !w.start.Equal(start) {
+		err = a.next.Write(w.reading(r.Sensor))
+		w = nil
+	}
+	if w == nil {
+		w = &window{start: start}
+		a.windows[r.Sensor] = w
+	}
+	w.sum25 += r.PM25
+	w.sum10 += r.PM10
+	w.count++
+	w.lastSeq, w.lastLabels, w.lastFields = r.Seq, r.Labels, r.Fields
+	return err
+}
+
+// Flush flushes the next sink. The windows are only passed on once
+// they are complete.
+func (a *averager) Flush() error { return a.next.Flush() }
+
+// Close passes on the incomplete windows and closes the next sink.
+func (a *averager) Close() error {
+	var firstErr error
+	for sensor, w := range a.windows {
+		if err := a.next.Write(w.reading(sensor)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(a.windows, sensor)
+	}
+	if err := a.next.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// collect is a sink keeping what's written to it.
+type collect struct {
+	readings []*sink.Reading
+	closed   bool
+}
+
+func (c *collect) Write(r *sink.Reading) error {
+	c.readings = append(c.readings, r)
+	return nil
+}
+
+func (c *collect) Flush() error { return nil }
+
+func (c *collect) Close() error {
+	c.closed = true
+	return nil
+}
+
+// passed is what the tests check of a reading: its minute after t0,
+// levels, sequence number and labels.
+type passed struct {
+	Minute     float64
+	PM25, PM10 float64
+	Seq        uint64
+	Labels     map[string]string
+}
+
+func passedOf(readings []*sink.Reading) []passed {
+	var got []passed
+	for _, r := range readings {
+		got = append(got, passed{r.Timestamp.Sub(t0).Minutes(), r.PM25, r.PM10, r.Seq, r.Labels})
+	}
+	return got
+}
+
+func TestPipeline(t *testing.T) {
+	labels := map[string]string{"room": "kitchen", "floor": "1"}
+	in := func(minute, pm25, pm10 float64, seq uint64) *sink.Reading {
+		r := reading("kitchen", minute, pm25, pm10)
+		r.Seq, r.Labels = seq, labels
+		return r
+	}
+	readings := []*sink.Reading{in(0, 10, 20, 1), in(2, 30, 40, 2), in(5, 50, 60, 3), in(7, 70, 80, 4), in(11, 5, 5, 5)}
+	for _, tc := range []struct {
+		name  string
+		steps string
+		want  []passed
+	}{
+		{
+			name:  "nothing",
+			steps: `[]`,
+			want: []passed{
+				{0, 10, 20, 1, labels}, {2, 30, 40, 2, labels}, {5, 50, 60, 3, labels}, {7, 70, 80, 4, labels}, {11, 5, 5, 5, labels},
+			},
+		},
+		{
+			// The last window is passed on when the pipeline is
+			// closed.
+			name:  "average",
+			steps: `[{"average": "5m"}]`,
+			want:  []passed{{0, 20, 30, 2, labels}, {5, 60, 70, 4, labels}, {10, 5, 5, 5, labels}},
+		},
+		{
+			name:  "above",
+			steps: `[{"above": {"pm25": 30}}]`,
+			want:  []passed{{5, 50, 60, 3, labels}, {7, 70, 80, 4, labels}},
+		},
+		{
+			name:  "above either",
+			steps: `[{"above": {"pm25": 60, "pm10": 50}}]`,
+			want:  []passed{{5, 50, 60, 3, labels}, {7, 70, 80, 4, labels}},
+		},
+		{
+			name:  "labels",
+			steps: `[{"above": {"pm25": 60}}, {"labels": ["room", "building"]}]`,
+			want:  []passed{{7, 70, 80, 4, map[string]string{"room": "kitchen"}}},
+		},
+		{
+			name:  "no labels left",
+			steps: `[{"above": {"pm25": 60}}, {"labels": ["building"]}]`,
+			want:  []passed{{7, 70, 80, 4, nil}},
+		},
+		{
+			// The steps apply in order: averaging first lets
+			// the first window through.
+			name:  "average then above",
+			steps: `[{"average": "5m"}, {"above": {"pm25": 15}}]`,
+			want:  []passed{{0, 20, 30, 2, labels}, {5, 60, 70, 4, labels}},
+		},
+		{
+			name:  "above then average",
+			steps: `[{"above": {"pm25": 15}}, {"average": "5m"}]`,
+			want:  []passed{{0, 30, 40, 2, labels}, {5, 60, 70, 4, labels}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var steps []PipelineStep
+			if err := json.Unmarshal([]byte(tc.steps), &steps); err != nil {
+				t.Fatal(err)
+			}
+			out := new(collect)
+			p := newPipeline(out, steps)
+			for _, r := range readings {
+				if err := p.Write(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := passedOf(out.readings); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if !out.closed {
+				t.Error("the sink wasn't closed")
+			}
+		})
+	}
+}
+
+func TestPipelineAverageSensors(t *testing.T) {
+	out := new(collect)
+	p := newPipeline(out, []PipelineStep{{Average: Duration{time.Hour}}})
+	p.Write(reading("kitchen", 0, 10, 10))
+	p.Write(reading("garden", 1, 50, 50))
+	p.Write(reading("kitchen", 2, 20, 20))
+	p.Close()
+	// Every sensor has a window of its own.
+	got := make(map[string]float64)
+	for _, r := range out.readings {
+		got[r.Sensor] = r.PM25
+	}
+	if want := map[string]float64{"kitchen": 15, "garden": 50}; !reflect.DeepEqual(got, want) {
+		t.Errorf("averages: %v, want %v", got, want)
+	}
+}
+
+func TestPipelineAQI(t *testing.T) {
+	out := new(collect)
+	p := newPipeline(out, []PipelineStep{{AQI: true}})
+	// Without readings from two of the last three hours, the index
+	// is of the average of this hour: 35.4 µg/m³ of PM2.5 and 154
+	// µg/m³ of PM10 are both at the top of Moderate.
+	p.Write(reading("kitchen", 0, 35.4, 154))
+	if r := out.readings[0]; r.PM25 != 100 || r.PM10 != 100 {
+		t.Errorf("AQI: PM2.5 %v, PM10 %v, want 100 and 100", r.PM25, r.PM10)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+
+	log "github.com/golang/glog"
+)
+
+// fixedParts returns a copy of config without the parts that can be
+// changed by reloading it: the sinks, the alerts, and the calibration,
+// labels, sinks and adaptive thresholds of every sensor.
+func fixedParts(config *Config) *Config {
+	fixed := *config
+	fixed.Sinks = nil
+	fixed.Alerts = AlertsConfig{}
+	fixed.Sensors = make([]SensorConfig, len(config.Sensors))
+	for i, sc := range config.Sensors {
+		sc.Calibration, sc.Labels, sc.Sinks, sc.Adaptive = Calibration{}, nil, nil, nil
+		fixed.Sensors[i] = sc
+	}
+	return &fixed
+}
+
+// reload reads the config file again and applies it to the running
+// daemon, without touching the sensors. Only the parts fixedParts
+// leaves out can change; if anything else did, the new config is
+// rejected as a whole. It returns the config in use afterwards.
+func reload(path string, old *Config, d *daemon, sinks *router) (*Config, error) {
+	config, err := loadConfig(path, old)
+	if err != nil {
+		return old, err
+	}
+	if !reflect.DeepEqual(fixedParts(old), fixedParts(config)) {
+		return old, errors.New("only sinks, alerts, calibration, labels and adaptive thresholds can change without a restart")
+	}
+	rules, notifiers, err := alertsOf(config.Alerts)
+	if err != nil {
+		return old, err
+	}
+	if err := sinks.reload(config); err != nil {
+		if restoreErr := sinks.reload(old); restoreErr != nil {
+			log.Errorf("restoring the old sinks: %v", restoreErr)
+		}
+		return old, err
+	}
+	for i := range config.Sensors {
+		sc := config.Sensors[i]
+		c := d.collectors[sc.Name]
+		c.reloaded.Store(&sc)
+		c.setLabels()
+	}
+	d.alerts.set(rules, notifiers)
+	log.Infof("reloaded %v", path)
+	return config, nil
+}
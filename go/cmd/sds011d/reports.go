@@ -0,0 +1,385 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/aqi"
+	"github.com/ryszard/sds011/go/exceedance"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+// maxRecentReports is how many reports are kept for the API.
+const maxRecentReports = 100
+
+// reportHours is how long the hourly summaries are kept for: long
+// enough for a weekly report.
+const reportHours = 8 * 24 * time.Hour
+
+// summary is what the reports keep of an hour of readings of a
+// sensor.
+type summary struct {
+	start      time.Time
+	covered    time.Duration
+	pm25, pm10 stats.Summary
+}
+
+// reportSensor holds the readings of a sensor for the reports.
+type reportSensor struct {
+	// start is the start of the hour being collected, and points
+	// are its points.
+	start  time.Time
+	points []sds011.Point
+	// hours are the summaries of the past hours, oldest first.
+	hours []summary
+}
+
+// finish summarizes the hour being collected, if it ended by now.
+func (s *reportSensor) finish(now time.Time) {
+	end := s.start.Add(time.Hour)
+	if len(s.points) == 0 || end.After(now) {
+		return
+	}
+	st := stats.Compute(s.points, s.start.Add(-1), end.Add(-1), maxHold)
+	s.hours = append(s.hours, summary{start: s.start, covered: st.Covered, pm25: st.PM25, pm10: st.PM10})
+	s.points = s.points[:0]
+	i := 0
+	for i < len(s.hours) && now.Sub(s.hours[i].start) > reportHours {
+		i++
+	}
+	s.hours = s.hours[i:]
+}
+
+// levelReport is the summary of a level in a report.
+type levelReport struct {
+	// Mean is the mean of the hourly means, weighted by how much of
+	// the hours the readings cover.
+	Mean float64 `json:"mean"`
+	// Max is the highest reading.
+	Max float64 `json:"max"`
+	// ExceedanceHours is the number of hours with a mean over the
+	// limit.
+	ExceedanceHours int `json:"exceedance_hours"`
+}
+
+// report summarizes the readings of a sensor over a day or a week.
+type report struct {
+	Sensor string    `json:"sensor"`
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Hours is the number of hours with readings.
+	Hours int         `json:"hours"`
+	PM25  levelReport `json:"pm25"`
+	PM10  levelReport `json:"pm10"`
+	// Limit is the name of the limit of the exceedance hours.
+	Limit string `json:"limit"`
+	// Categories are the numbers of hours in each AQI category,
+	// going by the higher of the indexes of the hourly means.
+	Categories map[string]int `json:"aqi_categories"`
+}
+
+// periodStart returns the start of the period ("daily" or "weekly")
+// that t is in. Weeks start on Monday.
+func periodStart(period string, t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == "weekly" {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// periodEnd returns the end of the period that starts at start.
+func periodEnd(period string, start time.Time) time.Time {
+	if period == "weekly" {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// reports is a sink that summarizes the readings of the sensors every
+// day or week. It keeps the recent reports for the API, and POSTs
+// them to an URL or mails them if so configured.
+type reports struct {
+	config ReportsConfig
+	limit  exceedance.Limit
+	client *http.Client
+
+	mu      sync.Mutex
+	sensors map[string]*reportSensor
+	// recent are the latest reports, oldest first.
+	recent []*report
+}
+
+func newReports(config ReportsConfig) *reports {
+	return &reports{
+		config:  config,
+		limit:   exceedance.Limits[config.Limit],
+		client:  &http.Client{Timeout: 10 * time.Second},
+		sensors: make(map[string]*reportSensor),
+	}
+}
+
+// Write implements sink.Sink.
+func (x *reports) Write(r *sink.Reading) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	s, ok := x.sensors[r.Sensor]
+	if !ok {
+		s = new(reportSensor)
+		x.sensors[r.Sensor] = s
+	}
+	if hour := r.Timestamp.Truncate(time.Hour); !hour.Equal(s.start) {
+		s.finish(r.Timestamp)
+		s.start = hour
+		s.points = s.points[:0]
+	}
+	s.points = append(s.points, *r.Point)
+	return nil
+}
+
+// summarize returns the report of the named sensor over the period
+// from start to end, or nil if there were no readings.
+func (x *reports) summarize(name string, s *reportSensor, period string, start, end time.Time) *report {
+	r := &report{
+		Sensor:     name,
+		Period:     period,
+		Start:      start,
+		End:        end,
+		Limit:      x.limit.Name,
+		Categories: make(map[string]int),
+	}
+	var weight float64
+	for _, h := range s.hours {
+		if h.start.Before(start) || !h.start.Before(end) {
+			continue
+		}
+		r.Hours++
+		w := h.covered.Seconds()
+		weight += w
+		r.PM25.Mean += w * h.pm25.Mean
+		r.PM10.Mean += w * h.pm10.Mean
+		if h.pm25.Max > r.PM25.Max {
+			r.PM25.Max = h.pm25.Max
+		}
+		if h.pm10.Max > r.PM10.Max {
+			r.PM10.Max = h.pm10.Max
+		}
+		if x.limit.PM25 > 0 && h.pm25.Mean > x.limit.PM25 {
+			r.PM25.ExceedanceHours++
+		}
+		if x.limit.PM10 > 0 && h.pm10.Mean > x.limit.PM10 {
+			r.PM10.ExceedanceHours++
+		}
+		index := aqi.PM25(h.pm25.Mean)
+		if i := aqi.PM10(h.pm10.Mean); i > index {
+			index = i
+		}
+		r.Categories[aqi.CategoryOf(index).String()]++
+	}
+	if r.Hours == 0 {
+		return nil
+	}
+	if weight > 0 {
+		r.PM25.Mean /= weight
+		r.PM10.Mean /= weight
+	}
+	return r
+}
+
+// compile returns the reports of the periods that end at end.
+func (x *reports) compile(end time.Time) []*report {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var names []string
+	for name, s := range x.sensors {
+		s.finish(end)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var made []*report
+	for _, period := range x.config.Periods {
+		start := periodStart(period, end.Add(-1))
+		if !periodEnd(period, start).Equal(end) {
+			continue
+		}
+		for _, name := range names {
+			if r := x.summarize(name, x.sensors[name], period, start, end); r != nil {
+				made = append(made, r)
+			}
+		}
+	}
+	x.recent = append(x.recent, made...)
+	if len(x.recent) > maxRecentReports {
+		x.recent = x.recent[len(x.recent)-maxRecentReports:]
+	}
+	return made
+}
+
+// Get returns the recent reports of sensor, oldest first.
+func (x *reports) Get(sensor string) []*report {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	rs := []*report{}
+	for _, r := range x.recent {
+		if r.Sensor == sensor {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// next returns when the next period ends after now.
+func (x *reports) next(now time.Time) time.Time {
+	var next time.Time
+	for _, period := range x.config.Periods {
+		end := periodEnd(period, periodStart(period, now))
+		if next.IsZero() || end.Before(next) {
+			next = end
+		}
+	}
+	return next
+}
+
+// run makes the reports at the end of every period, and sends them,
+// until ctx is done.
+func (x *reports) run(ctx context.Context) {
+	for {
+		end := x.next(time.Now())
+		timer := time.NewTimer(time.Until(end))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		made := x.compile(end)
+		// Days and weeks can end together, and their reports are
+		// sent separately.
+		for _, period := range x.config.Periods {
+			var rs []*report
+			for _, r := range made {
+				if r.Period == period {
+					rs = append(rs, r)
+				}
+			}
+			if len(rs) > 0 {
+				x.send(ctx, rs)
+			}
+		}
+	}
+}
+
+// send sends the reports of a period where they're configured to go.
+func (x *reports) send(ctx context.Context, rs []*report) {
+	log.Infof("reports: %d %s reports", len(rs), rs[0].Period)
+	if x.config.URL != "" {
+		if err := x.post(ctx, rs); err != nil {
+			log.Errorf("reports: %v", err)
+		}
+	}
+	if x.config.Mail.Address != "" {
+		if err := x.mail(ctx, rs); err != nil {
+			log.Errorf("reports: mailing: %v", err)
+		}
+	}
+}
+
+// post POSTs the reports to the configured URL, as a JSON array.
+func (x *reports) post(ctx context.Context, made []*report) error {
+	body, err := json.Marshal(made)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", x.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v: %v", x.config.URL, resp.Status)
+	}
+	return nil
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"categories": func() []string {
+		var names []string
+		for c := aqi.Good; c <= aqi.Hazardous; c++ {
+			names = append(names, c.String())
+		}
+		return names
+	},
+	"date": func(t time.Time) string { return t.Format("Mon 2 Jan 2006") },
+	// last returns the last day of a period ending at end.
+	"last": func(end time.Time) time.Time { return end.AddDate(0, 0, -1) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<body>
+<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th rowspan="2">Sensor</th><th rowspan="2">Period</th><th rowspan="2">Hours</th>
+<th colspan="3">PM2.5 (µg/m³)</th><th colspan="3">PM10 (µg/m³)</th><th colspan="6">Hours by AQI category</th></tr>
+<tr><th>mean</th><th>max</th><th>hours over</th><th>mean</th><th>max</th><th>hours over</th>
+{{- range categories}}<th>{{.}}</th>{{end}}</tr>
+{{- range .}}
+<tr><td>{{.Sensor}}</td><td>{{date .Start}}{{if eq .Period "weekly"}} to {{date (last .End)}}{{end}}</td><td>{{.Hours}}</td>
+<td>{{printf "%.1f" .PM25.Mean}}</td><td>{{printf "%.1f" .PM25.Max}}</td><td>{{.PM25.ExceedanceHours}}</td>
+<td>{{printf "%.1f" .PM10.Mean}}</td><td>{{printf "%.1f" .PM10.Max}}</td><td>{{.PM10.ExceedanceHours}}</td>
+{{- $r := .}}{{range categories}}<td>{{index $r.Categories .}}</td>{{end}}</tr>
+{{- end}}
+</table>
+<p>Hours over are the hours with a mean over the {{with index . 0}}{{.Limit}}{{end}} limits.</p>
+</body>
+</html>
+`))
+
+// mail mails the reports, as an HTML table.
+func (x *reports) mail(ctx context.Context, made []*report) error {
+	var body bytes.Buffer
+	if err := reportTemplate.Execute(&body, made); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Air quality, %s report for %s", made[0].Period, made[0].Start.Format("2 Jan 2006"))
+	return sendMail(ctx, x.config.Mail, subject, "text/html", body.Bytes())
+}
+
+// Flush implements sink.Sink.
+func (x *reports) Flush() error {
+	return nil
+}
+
+// Close implements sink.Sink.
+func (x *reports) Close() error {
+	return nil
+}
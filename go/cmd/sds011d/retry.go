@@ -0,0 +1,155 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// retryQueue is how many readings a retrying sink holds in memory.
+// Past that, new readings go straight to the dead letter file.
+const retryQueue = 1024
+
+var errQueueFull = errors.New("retry queue full")
+
+// A retrySink writes readings to another sink in the background,
+// retrying failed writes with exponential backoff. Readings that
+// can't be written are appended to a dead letter file.
+type retrySink struct {
+	next   sink.Sink
+	name   string
+	config *RetryConfig
+
+	queue chan *sink.Reading
+	// stop is closed to make the pending retries give up.
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu         sync.Mutex
+	deadLetter io.WriteCloser
+}
+
+func newRetrySink(next sink.Sink, name string, config *RetryConfig) (*retrySink, error) {
+	s := &retrySink{
+		next:   next,
+		name:   name,
+		config: config,
+		queue:  make(chan *sink.Reading, retryQueue),
+		stop:   make(chan struct{}),
+	}
+	if config.DeadLetter != "" {
+		w, err := sink.OpenOutput(config.DeadLetter)
+		if err != nil {
+			return nil, err
+		}
+		s.deadLetter = w
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// Write queues r for writing.
+func (s *retrySink) Write(r *sink.Reading) error {
+	select {
+	case s.queue <- r:
+		return nil
+	default:
+		s.bury(r, errQueueFull)
+		return errQueueFull
+	}
+}
+
+func (s *retrySink) run() {
+	defer s.wg.Done()
+	for r := range s.queue {
+		s.write(r)
+	}
+}
+
+// write tries to write r until it succeeds, it runs out of attempts,
+// or the sink is closed.
+func (s *retrySink) write(r *sink.Reading) {
+	backoff := s.config.Backoff.Duration
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = s.next.Write(r); err == nil {
+			return
+		}
+		if attempt >= s.config.MaxAttempts {
+			break
+		}
+		log.Warningf("sink %v: attempt %d: %v", s.name, attempt, err)
+		select {
+		case <-s.stop:
+			s.bury(r, err)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.config.MaxBackoff.Duration {
+			backoff = s.config.MaxBackoff.Duration
+		}
+	}
+	s.bury(r, err)
+}
+
+// bury writes r to the dead letter file, or drops it if there's none.
+func (s *retrySink) bury(r *sink.Reading, err error) {
+	if s.deadLetter == nil {
+		log.Errorf("sink %v: dropping %v: %v", s.name, r.Point, err)
+		return
+	}
+	log.Errorf("sink %v: %v; writing to %v", s.name, err, s.config.DeadLetter)
+	b, jsonErr := json.Marshal(struct {
+		Error   string        `json:"error"`
+		Reading *sink.Reading `json:"reading"`
+	}{err.Error(), r})
+	if jsonErr != nil {
+		log.Errorf("sink %v: %v", s.name, jsonErr)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.deadLetter.Write(append(b, '\n')); err != nil {
+		log.Errorf("sink %v: dead letter: %v", s.name, err)
+	}
+}
+
+// Flush does nothing: the readings are flushed as they are written.
+func (s *retrySink) Flush() error {
+	return nil
+}
+
+// Close gives every queued reading one last try, without waiting,
+// and closes the sink.
+func (s *retrySink) Close() error {
+	close(s.stop)
+	close(s.queue)
+	s.wg.Wait()
+	err := s.next.Close()
+	if s.deadLetter != nil {
+		if dlErr := s.deadLetter.Close(); err == nil {
+			err = dlErr
+		}
+	}
+	return err
+}
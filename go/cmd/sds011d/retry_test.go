@@ -0,0 +1,110 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRetrySink(t *testing.T, next *fakeSink, config RetryConfig) *retrySink {
+	t.Helper()
+	s, err := newRetrySink(next, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRetry(t *testing.T) {
+	next := &fakeSink{failFirst: 2}
+	s := newTestRetrySink(t, next, RetryConfig{MaxAttempts: 3, Backoff: Duration{time.Millisecond}, MaxBackoff: Duration{time.Millisecond}})
+	for i := 1; i <= 3; i++ {
+		if err := s.Write(reading("kitchen", float64(i), float64(i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first reading makes it on the third attempt, and holds up
+	// the others until then.
+	waitForLevels(t, next, []float64{1, 2, 3})
+	s.Close()
+	if next.attempts != 5 {
+		t.Errorf("%d attempts, want 5", next.attempts)
+	}
+}
+
+// deadLetters returns the errors and PM2.5 levels in the dead letter
+// file at path.
+func deadLetters(t *testing.T, path string) (errs []string, levels []float64) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var v struct {
+			Error   string `json:"error"`
+			Reading struct {
+				PM25 float64 `json:"pm25"`
+			} `json:"reading"`
+		}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		errs, levels = append(errs, v.Error), append(levels, v.Reading.PM25)
+	}
+	return errs, levels
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	next := &fakeSink{down: true}
+	s := newTestRetrySink(t, next, RetryConfig{MaxAttempts: 2, Backoff: Duration{time.Millisecond}, MaxBackoff: Duration{time.Millisecond}, DeadLetter: path})
+	s.Write(reading("kitchen", 1, 1, 0))
+	s.Write(reading("kitchen", 2, 2, 0))
+	// Closing waits for the pending writes to give up.
+	s.Close()
+	errs, levels := deadLetters(t, path)
+	if len(levels) != 2 || levels[0] != 1 || levels[1] != 2 || errs[0] != errDown.Error() {
+		t.Errorf("dead letters: %v %v, want readings 1 and 2 failing with %q", errs, levels, errDown)
+	}
+	if !next.closed {
+		t.Error("the sink wasn't closed")
+	}
+}
+
+func TestRetryGivesUpOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	next := &fakeSink{down: true}
+	s := newTestRetrySink(t, next, RetryConfig{MaxAttempts: 100, Backoff: Duration{time.Hour}, MaxBackoff: Duration{time.Hour}, DeadLetter: path})
+	s.Write(reading("kitchen", 1, 1, 0))
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the backoff")
+	}
+	if _, levels := deadLetters(t, path); len(levels) != 1 || levels[0] != 1 {
+		t.Errorf("dead letters: %v, want reading 1", levels)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// newSink returns the sink described by config. If it has a spool,
+// its state is reported to metrics.
+func newSink(config SinkConfig, metrics *exporter.Metrics) (sink.Sink, error) {
+	s, err := sink.New(config.Type, config.Options)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case config.Spool != nil:
+		if s, err = openSpool(s, config.Name, config.Spool, metrics); err != nil {
+			return nil, err
+		}
+	case config.Retry != nil:
+		if s, err = newRetrySink(s, sinkName(config), config.Retry); err != nil {
+			return nil, err
+		}
+	}
+	return newPipeline(s, config.Pipeline), nil
+}
+
+// sinkName returns the name of the sink for the logs.
+func sinkName(config SinkConfig) string {
+	if config.Name != "" {
+		return config.Name
+	}
+	return config.Type
+}
+
+// fanout writes each reading to all of its sinks. An error in one
+// sink doesn't stop the others from getting the reading.
+type fanout []sink.Sink
+
+func (f fanout) Write(r *sink.Reading) error {
+	var failed int
+	for _, s := range f {
+		if err := s.Write(r); err != nil {
+			log.Errorf("sink %T: %v", s, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sinks failed", failed, len(f))
+	}
+	return nil
+}
+
+func (f fanout) Flush() error {
+	var firstErr error
+	for _, s := range f {
+		if err := s.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanout) Close() error {
+	var firstErr error
+	for _, s := range f {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// router sends the readings of every sensor to the sinks chosen for
+// it in the config.
+type router struct {
+	// common get all readings.
+	common  fanout
+	metrics *exporter.Metrics
+
+	// mu protects the sinks, which change when the config is
+	// reloaded.
+	mu sync.Mutex
+	// sinks are all the configured sinks, routes the ones chosen by
+	// each sensor.
+	sinks  fanout
+	routes map[string]fanout
+}
+
+// newRouter returns a router for the sinks and sensors in config,
+// which sends all readings to common as well.
+func newRouter(config *Config, common fanout, metrics *exporter.Metrics) (*router, error) {
+	r := &router{common: common, metrics: metrics}
+	if err := r.reload(config); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload replaces the sinks and routes with the ones in config. The
+// old sinks are closed first, as the new ones may use the same files.
+// If any of the new sinks can't be created, the router is left with
+// no sinks.
+func (r *router) reload(config *Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.sinks.Close(); err != nil {
+		log.Errorf("closing sinks: %v", err)
+	}
+	r.sinks, r.routes = nil, nil
+
+	var sinks fanout
+	routes := make(map[string]fanout)
+	named := make(map[string]sink.Sink)
+	for _, sc := range config.Sinks {
+		s, err := newSink(sc, r.metrics)
+		if err != nil {
+			sinks.Close()
+			return fmt.Errorf("sink %v: %v", sc.Type, err)
+		}
+		sinks = append(sinks, s)
+		if sc.Name != "" {
+			named[sc.Name] = s
+		}
+	}
+	for _, sc := range config.Sensors {
+		if len(sc.Sinks) == 0 {
+			routes[sc.Name] = sinks
+			continue
+		}
+		var route fanout
+		for _, name := range sc.Sinks {
+			route = append(route, named[name])
+		}
+		routes[sc.Name] = route
+	}
+	r.sinks, r.routes = sinks, routes
+	return nil
+}
+
+func (r *router) Write(rd *sink.Reading) error {
+	err := r.common.Write(rd)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if routeErr := r.routes[rd.Sensor].Write(rd); err == nil {
+		err = routeErr
+	}
+	return err
+}
+
+func (r *router) Flush() error {
+	err := r.common.Flush()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sinksErr := r.sinks.Flush(); err == nil {
+		err = sinksErr
+	}
+	return err
+}
+
+func (r *router) Close() error {
+	err := r.common.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sinksErr := r.sinks.Close(); err == nil {
+		err = sinksErr
+	}
+	return err
+}
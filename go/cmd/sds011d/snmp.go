@@ -0,0 +1,485 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// The SNMP agent. It answers SNMPv1 and SNMPv2c Get, GetNext and
+// GetBulk requests (so snmpget and snmpwalk work), for a single table
+// under the configured root OID, indexed by the position of the sensor
+// in the config, starting at 1:
+//
+//	<root>.1.1.1.<i>  sds011Name         OCTET STRING
+//	<root>.1.1.2.<i>  sds011PM25         Gauge32, tenths of µg/m³
+//	<root>.1.1.3.<i>  sds011PM10         Gauge32, tenths of µg/m³
+//	<root>.1.1.4.<i>  sds011Status       INTEGER: 0 no measurement yet,
+//	                                     1 ok, 2 the last read failed
+//	<root>.1.1.5.<i>  sds011LastReadAge  INTEGER, seconds since the
+//	                                     latest measurement, -1 if none
+//
+// Requests with a wrong community are dropped. Set requests are
+// refused.
+
+const (
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	berGauge32     = 0x42
+
+	snmpGetRequest     = 0xa0
+	snmpGetNextRequest = 0xa1
+	snmpResponse       = 0xa2
+	snmpGetBulkRequest = 0xa5
+
+	// Exceptions, in place of a value, in SNMPv2c.
+	snmpNoSuchObject = 0x80
+	snmpEndOfMibView = 0x82
+
+	snmpNoSuchName = 2
+	snmpGenErr     = 5
+
+	// snmpMaxVarbinds bounds the size of a GetBulk response.
+	snmpMaxVarbinds = 64
+)
+
+// oid is an object identifier.
+type oid []int
+
+func parseOID(s string) (oid, error) {
+	var o oid
+	for _, part := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad OID %q", s)
+		}
+		o = append(o, n)
+	}
+	// The first arc is 0, 1 or 2, and under 0 and 1 there are only
+	// 40 arcs, as the first two are encoded together.
+	if len(o) < 2 || o[0] > 2 || (o[0] < 2 && o[1] >= 40) {
+		return nil, fmt.Errorf("bad OID %q", s)
+	}
+	return o, nil
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// less returns whether o comes before p in lexicographic order.
+func (o oid) less(p oid) bool {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		if o[i] != p[i] {
+			return o[i] < p[i]
+		}
+	}
+	return len(o) < len(p)
+}
+
+func (o oid) equal(p oid) bool {
+	return !o.less(p) && !p.less(o)
+}
+
+func (o oid) append(ns ...int) oid {
+	return append(append(oid(nil), o...), ns...)
+}
+
+// snmpVar is a variable binding: an OID with its BER encoded value.
+type snmpVar struct {
+	oid   oid
+	value []byte
+}
+
+// snmpAgent serves the measurements known to a daemon over SNMP.
+type snmpAgent struct {
+	d         *daemon
+	community string
+	root      oid
+}
+
+// mib returns all the variables, sorted by OID.
+func (a *snmpAgent) mib() []snmpVar {
+	entry := a.root.append(1, 1)
+	now := time.Now()
+	var vars []snmpVar
+	for i, name := range a.d.names {
+		index := i + 1
+		latest := a.d.hub.Latest(name)
+		age := int64(-1)
+		var pm25, pm10 uint32
+		if latest != nil {
+			age = int64(now.Sub(latest.Timestamp) / time.Second)
+			pm25, pm10 = gauge32(latest.PM25*10), gauge32(latest.PM10*10)
+		}
+		vars = append(vars,
+			snmpVar{entry.append(1, index), berTLV(berOctetString, []byte(name))},
+			snmpVar{entry.append(2, index), berTLV(berGauge32, berUint(uint64(pm25)))},
+			snmpVar{entry.append(3, index), berTLV(berGauge32, berUint(uint64(pm10)))},
+			snmpVar{entry.append(4, index), berTLV(berInteger, berInt(int64(a.d.status(name))))},
+			snmpVar{entry.append(5, index), berTLV(berInteger, berInt(age))},
+		)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].oid.less(vars[j].oid) })
+	return vars
+}
+
+func gauge32(v float64) uint32 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(math.Round(v))
+}
+
+// serve answers requests arriving on conn.
+func (a *snmpAgent) serve(conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.V(1).Infof("snmp: %v", err)
+			return
+		}
+		resp, err := a.handle(buf[:n])
+		if err != nil {
+			log.V(1).Infof("snmp %v: %v", addr, err)
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			log.V(1).Infof("snmp %v: %v", addr, err)
+		}
+	}
+}
+
+var errBadPacket = errors.New("malformed packet")
+
+// handle returns the response to the request packet.
+func (a *snmpAgent) handle(packet []byte) ([]byte, error) {
+	tag, message, _, err := berRead(packet)
+	if err != nil || tag != berSequence {
+		return nil, errBadPacket
+	}
+	tag, v, message, err := berRead(message)
+	if err != nil || tag != berInteger {
+		return nil, errBadPacket
+	}
+	version := berParseInt(v)
+	if version != snmpVersion1 && version != snmpVersion2c {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	tag, community, message, err := berRead(message)
+	if err != nil || tag != berOctetString {
+		return nil, errBadPacket
+	}
+	if string(community) != a.community {
+		return nil, fmt.Errorf("wrong community %q", community)
+	}
+	pduType, pdu, _, err := berRead(message)
+	if err != nil {
+		return nil, errBadPacket
+	}
+	var fields [3]int64
+	for i := range fields {
+		tag, v, pdu, err = berRead(pdu)
+		if err != nil || tag != berInteger {
+			return nil, errBadPacket
+		}
+		fields[i] = berParseInt(v)
+	}
+	requestID := fields[0]
+	tag, list, _, err := berRead(pdu)
+	if err != nil || tag != berSequence {
+		return nil, errBadPacket
+	}
+	var oids []oid
+	for len(list) > 0 {
+		var vb []byte
+		tag, vb, list, err = berRead(list)
+		if err != nil || tag != berSequence {
+			return nil, errBadPacket
+		}
+		tag, v, _, err = berRead(vb)
+		if err != nil || tag != berOID {
+			return nil, errBadPacket
+		}
+		o, err := berParseOID(v)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, o)
+	}
+
+	mib := a.mib()
+	var (
+		vars        []snmpVar
+		errorStatus int64
+		errorIndex  int64
+	)
+	switch pduType {
+	case snmpGetRequest:
+		for i, o := range oids {
+			v, ok := get(mib, o)
+			if !ok {
+				if version == snmpVersion1 {
+					errorStatus, errorIndex = snmpNoSuchName, int64(i+1)
+					break
+				}
+				v = snmpVar{o, berTLV(snmpNoSuchObject, nil)}
+			}
+			vars = append(vars, v)
+		}
+	case snmpGetNextRequest:
+		for i, o := range oids {
+			v, ok := next(mib, o)
+			if !ok {
+				if version == snmpVersion1 {
+					errorStatus, errorIndex = snmpNoSuchName, int64(i+1)
+					break
+				}
+				v = snmpVar{o, berTLV(snmpEndOfMibView, nil)}
+			}
+			vars = append(vars, v)
+		}
+	case snmpGetBulkRequest:
+		if version == snmpVersion1 {
+			return nil, errors.New("GetBulk in SNMPv1")
+		}
+		nonRepeaters, maxRepetitions := int(fields[1]), int(fields[2])
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if nonRepeaters > len(oids) {
+			nonRepeaters = len(oids)
+		}
+		for _, o := range oids[:nonRepeaters] {
+			v, ok := next(mib, o)
+			if !ok {
+				v = snmpVar{o, berTLV(snmpEndOfMibView, nil)}
+			}
+			vars = append(vars, v)
+		}
+		// The repetitions go row by row, one variable of each
+		// repeater in turn, which is how snmptable reads the columns
+		// of a table side by side (RFC 3416, 4.2.3).
+		repeaters := append([]oid(nil), oids[nonRepeaters:]...)
+		for r := 0; r < maxRepetitions && len(repeaters) > 0 && len(vars)+len(repeaters) <= snmpMaxVarbinds; r++ {
+			ended := 0
+			for j, o := range repeaters {
+				v, ok := next(mib, o)
+				if !ok {
+					v = snmpVar{o, berTLV(snmpEndOfMibView, nil)}
+					ended++
+				}
+				vars = append(vars, v)
+				repeaters[j] = v.oid
+			}
+			if ended == len(repeaters) {
+				break
+			}
+		}
+	default:
+		// Set requests and anything else we don't understand.
+		errorStatus, errorIndex = snmpGenErr, 0
+		for _, o := range oids {
+			vars = append(vars, snmpVar{o, berTLV(berNull, nil)})
+		}
+	}
+	if errorStatus != 0 && pduType != snmpGetBulkRequest {
+		// The response to a failed request repeats the request's
+		// variables.
+		vars = vars[:0]
+		for _, o := range oids {
+			vars = append(vars, snmpVar{o, berTLV(berNull, nil)})
+		}
+	}
+
+	var varbinds []byte
+	for _, v := range vars {
+		varbinds = append(varbinds, berTLV(berSequence, append(berTLV(berOID, berOIDBytes(v.oid)), v.value...))...)
+	}
+	var body []byte
+	body = append(body, berTLV(berInteger, berInt(requestID))...)
+	body = append(body, berTLV(berInteger, berInt(errorStatus))...)
+	body = append(body, berTLV(berInteger, berInt(errorIndex))...)
+	body = append(body, berTLV(berSequence, varbinds)...)
+
+	var resp []byte
+	resp = append(resp, berTLV(berInteger, berInt(version))...)
+	resp = append(resp, berTLV(berOctetString, community)...)
+	resp = append(resp, berTLV(snmpResponse, body)...)
+	return berTLV(berSequence, resp), nil
+}
+
+// get returns the variable with the given OID.
+func get(mib []snmpVar, o oid) (snmpVar, bool) {
+	i := sort.Search(len(mib), func(i int) bool { return !mib[i].oid.less(o) })
+	if i < len(mib) && mib[i].oid.equal(o) {
+		return mib[i], true
+	}
+	return snmpVar{}, false
+}
+
+// next returns the first variable after the given OID.
+func next(mib []snmpVar, o oid) (snmpVar, bool) {
+	i := sort.Search(len(mib), func(i int) bool { return o.less(mib[i].oid) })
+	if i < len(mib) {
+		return mib[i], true
+	}
+	return snmpVar{}, false
+}
+
+// berRead splits b into the tag and contents of its first element,
+// and the rest.
+func berRead(b []byte) (tag byte, contents, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBadPacket
+	}
+	tag, b = b[0], b[1:]
+	length := int(b[0])
+	b = b[1:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(b) < n {
+			return 0, nil, nil, errBadPacket
+		}
+		length = 0
+		for _, c := range b[:n] {
+			length = length<<8 | int(c)
+		}
+		b = b[n:]
+	}
+	if len(b) < length {
+		return 0, nil, nil, errBadPacket
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// berTLV encodes an element.
+func berTLV(tag byte, contents []byte) []byte {
+	out := []byte{tag}
+	switch n := len(contents); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, contents...)
+}
+
+func berParseInt(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+// berInt encodes v as a two's complement integer, in as few bytes as
+// possible.
+func berInt(v int64) []byte {
+	var out []byte
+	for {
+		out = append([]byte{byte(v)}, out...)
+		if (v < 0x80 && v >= -0x80) || len(out) == 8 {
+			return out
+		}
+		v >>= 8
+	}
+}
+
+// berUint encodes v as an unsigned integer, as used by Gauge32.
+func berUint(v uint64) []byte {
+	out := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		out = append([]byte{byte(v)}, out...)
+	}
+	if out[0]&0x80 != 0 {
+		out = append([]byte{0}, out...)
+	}
+	return out
+}
+
+// berParseOID decodes an OID. Its first subidentifier packs the
+// first two arcs as 40*X+Y, where X is at most 2, and Y is only
+// bounded for X below 2.
+func berParseOID(b []byte) (oid, error) {
+	var subids []int
+	n := 0
+	for i, c := range b {
+		if n > math.MaxInt32>>7 {
+			return nil, errBadPacket
+		}
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			subids = append(subids, n)
+			n = 0
+		} else if i == len(b)-1 {
+			// Truncated in the middle of a subidentifier.
+			return nil, errBadPacket
+		}
+	}
+	if len(subids) == 0 {
+		return nil, errBadPacket
+	}
+	var o oid
+	switch first := subids[0]; {
+	case first < 40:
+		o = oid{0, first}
+	case first < 80:
+		o = oid{1, first - 40}
+	default:
+		o = oid{2, first - 80}
+	}
+	return append(o, subids[1:]...), nil
+}
+
+func berOIDBytes(o oid) []byte {
+	var out []byte
+	for _, n := range append([]int{o[0]*40 + o[1]}, o[2:]...) {
+		enc := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			enc = append([]byte{byte(n&0x7f | 0x80)}, enc...)
+		}
+		out = append(out, enc...)
+	}
+	return out
+}
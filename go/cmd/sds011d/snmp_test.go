@@ -0,0 +1,362 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+func TestBERInt(t *testing.T) {
+	for _, tc := range []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{0x7f}},
+		{128, []byte{0, 0x80}},
+		{256, []byte{1, 0}},
+		{-1, []byte{0xff}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+		{math.MaxInt32, []byte{0x7f, 0xff, 0xff, 0xff}},
+		{math.MinInt64, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		got := berInt(tc.v)
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("berInt(%v): % x, want % x", tc.v, got, tc.want)
+		}
+		if v := berParseInt(got); v != tc.v {
+			t.Errorf("berParseInt(% x): %v, want %v", got, v, tc.v)
+		}
+	}
+	for v, want := range map[uint64][]byte{
+		0:              {0},
+		127:            {0x7f},
+		128:            {0, 0x80},
+		math.MaxUint32: {0, 0xff, 0xff, 0xff, 0xff},
+	} {
+		if got := berUint(v); !bytes.Equal(got, want) {
+			t.Errorf("berUint(%v): % x, want % x", v, got, want)
+		}
+	}
+}
+
+func TestBERTLV(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, 1000} {
+		contents := bytes.Repeat([]byte{'x'}, n)
+		b := append(berTLV(berOctetString, contents), 0xaa)
+		tag, got, rest, err := berRead(b)
+		if err != nil || tag != berOctetString || !bytes.Equal(got, contents) || !bytes.Equal(rest, []byte{0xaa}) {
+			t.Errorf("%v bytes: tag %#x, %v bytes, rest % x, %v", n, tag, len(got), rest, err)
+		}
+	}
+	for _, b := range [][]byte{
+		nil,
+		{berInteger},
+		{berInteger, 2, 1},
+		{berOctetString, 0x80},
+		{berOctetString, 0x84, 0, 0, 0, 1, 'x'},
+		{berOctetString, 0x82, 1},
+	} {
+		if _, _, _, err := berRead(b); err == nil {
+			t.Errorf("berRead(% x): no error", b)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	for _, tc := range []struct {
+		oid   string
+		bytes []byte
+	}{
+		{"1.3.6.1.4.1.99999.11", []byte{0x2b, 6, 1, 4, 1, 0x86, 0x8d, 0x1f, 11}},
+		{"0.39", []byte{39}},
+		{"1.0", []byte{40}},
+		{"2.0", []byte{80}},
+		// The first byte is at least 120, and the first arc is still
+		// 2.
+		{"2.40", []byte{120}},
+		{"2.47.1", []byte{127, 1}},
+		// The example of X.690, where the first subidentifier takes
+		// two bytes.
+		{"2.999.3", []byte{0x88, 0x37, 3}},
+	} {
+		o, err := parseOID(tc.oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := berOIDBytes(o); !bytes.Equal(got, tc.bytes) {
+			t.Errorf("berOIDBytes(%v): % x, want % x", o, got, tc.bytes)
+		}
+		got, err := berParseOID(tc.bytes)
+		if err != nil || !got.equal(o) {
+			t.Errorf("berParseOID(% x): %v, %v, want %v", tc.bytes, got, err, o)
+		}
+	}
+	for _, b := range [][]byte{nil, {0x2b, 0x86}, {0x2b, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}} {
+		if o, err := berParseOID(b); err == nil {
+			t.Errorf("berParseOID(% x): %v, want an error", b, o)
+		}
+	}
+	for _, s := range []string{"1", "3.1", "1.40", "1.3.x", "1.-3"} {
+		if _, err := parseOID(s); err == nil {
+			t.Errorf("parseOID(%q): no error", s)
+		}
+	}
+}
+
+// snmpRequest encodes a request. a and b are the error status and
+// index fields, or non-repeaters and max-repetitions for GetBulk.
+func snmpRequest(version int64, community string, pduType byte, a, b int64, oids ...oid) []byte {
+	var varbinds []byte
+	for _, o := range oids {
+		varbinds = append(varbinds, berTLV(berSequence, append(berTLV(berOID, berOIDBytes(o)), berTLV(berNull, nil)...))...)
+	}
+	var pdu []byte
+	pdu = append(pdu, berTLV(berInteger, berInt(42))...)
+	pdu = append(pdu, berTLV(berInteger, berInt(a))...)
+	pdu = append(pdu, berTLV(berInteger, berInt(b))...)
+	pdu = append(pdu, berTLV(berSequence, varbinds)...)
+	var message []byte
+	message = append(message, berTLV(berInteger, berInt(version))...)
+	message = append(message, berTLV(berOctetString, []byte(community))...)
+	message = append(message, berTLV(pduType, pdu)...)
+	return berTLV(berSequence, message)
+}
+
+// snmpResult is a decoded response.
+type snmpResult struct {
+	errorStatus, errorIndex int64
+	oids                    []string
+	// values are the tags of the values, and their contents as
+	// strings or integers.
+	values []string
+}
+
+func parseSNMPResponse(t *testing.T, b []byte) snmpResult {
+	t.Helper()
+	read := func(b []byte, want byte) ([]byte, []byte) {
+		t.Helper()
+		tag, v, rest, err := berRead(b)
+		if err != nil || tag != want {
+			t.Fatalf("response % x: tag %#x, %v, want tag %#x", b, tag, err, want)
+		}
+		return v, rest
+	}
+	message, _ := read(b, berSequence)
+	_, message = read(message, berInteger)
+	_, message = read(message, berOctetString)
+	pdu, _ := read(message, snmpResponse)
+	id, pdu := read(pdu, berInteger)
+	if berParseInt(id) != 42 {
+		t.Errorf("request id %v, want 42", berParseInt(id))
+	}
+	var r snmpResult
+	v, pdu := read(pdu, berInteger)
+	r.errorStatus = berParseInt(v)
+	v, pdu = read(pdu, berInteger)
+	r.errorIndex = berParseInt(v)
+	list, _ := read(pdu, berSequence)
+	for len(list) > 0 {
+		var vb []byte
+		vb, list = read(list, berSequence)
+		v, vb := read(vb, berOID)
+		o, err := berParseOID(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.oids = append(r.oids, o.String())
+		tag, v, _, err := berRead(vb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch tag {
+		case berOctetString:
+			r.values = append(r.values, string(v))
+		case berInteger, berGauge32:
+			r.values = append(r.values, oid{int(berParseInt(v))}.String())
+		case snmpNoSuchObject:
+			r.values = append(r.values, "noSuchObject")
+		case snmpEndOfMibView:
+			r.values = append(r.values, "endOfMibView")
+		case berNull:
+			r.values = append(r.values, "null")
+		default:
+			t.Fatalf("unexpected tag %#x", tag)
+		}
+	}
+	return r
+}
+
+// testRootOID is under 32473, the enterprise number reserved for
+// documentation by RFC 5612.
+const testRootOID = "1.3.6.1.4.1.32473.11"
+
+// testAgent returns an agent for a daemon with two sensors, of which
+// only kitchen has measured.
+func testAgent(t *testing.T) *snmpAgent {
+	t.Helper()
+	d, _, _ := testDaemon(t, twoSensors)
+	d.hub.Write(&sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 12.3, PM10: 45.6, Timestamp: time.Now()}})
+	root, err := parseOID(testRootOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &snmpAgent{d: d, community: "public", root: root}
+}
+
+// col returns the OID of column c of the table, for sensor i, or of
+// the column itself if i is 0.
+func col(c, i int) oid {
+	o, _ := parseOID(testRootOID)
+	o = o.append(1, 1, c)
+	if i > 0 {
+		o = o.append(i)
+	}
+	return o
+}
+
+func oidStrings(oids ...oid) []string {
+	var s []string
+	for _, o := range oids {
+		s = append(s, o.String())
+	}
+	return s
+}
+
+func TestSNMP(t *testing.T) {
+	a := testAgent(t)
+	root := a.root
+	last := col(5, 2)
+	for _, tc := range []struct {
+		name    string
+		request []byte
+		want    snmpResult
+	}{
+		{
+			"get",
+			snmpRequest(snmpVersion2c, "public", snmpGetRequest, 0, 0, col(1, 1), col(2, 1), col(3, 1), col(4, 1), col(4, 2), col(5, 2)),
+			snmpResult{oids: oidStrings(col(1, 1), col(2, 1), col(3, 1), col(4, 1), col(4, 2), col(5, 2)), values: []string{"kitchen", "123", "456", "1", "0", "-1"}},
+		},
+		{
+			"get missing, v2c",
+			snmpRequest(snmpVersion2c, "public", snmpGetRequest, 0, 0, col(1, 1), col(1, 3)),
+			snmpResult{oids: oidStrings(col(1, 1), col(1, 3)), values: []string{"kitchen", "noSuchObject"}},
+		},
+		{
+			"get missing, v1",
+			snmpRequest(snmpVersion1, "public", snmpGetRequest, 0, 0, col(1, 1), col(1, 3)),
+			snmpResult{errorStatus: snmpNoSuchName, errorIndex: 2, oids: oidStrings(col(1, 1), col(1, 3)), values: []string{"null", "null"}},
+		},
+		{
+			"getnext",
+			snmpRequest(snmpVersion2c, "public", snmpGetNextRequest, 0, 0, root, col(1, 2), col(2, 0)),
+			snmpResult{oids: oidStrings(col(1, 1), col(2, 1), col(2, 1)), values: []string{"kitchen", "123", "123"}},
+		},
+		{
+			"getnext past the end, v2c",
+			snmpRequest(snmpVersion2c, "public", snmpGetNextRequest, 0, 0, last),
+			snmpResult{oids: oidStrings(last), values: []string{"endOfMibView"}},
+		},
+		{
+			"getnext past the end, v1",
+			snmpRequest(snmpVersion1, "public", snmpGetNextRequest, 0, 0, root, last),
+			snmpResult{errorStatus: snmpNoSuchName, errorIndex: 2, oids: oidStrings(root, last), values: []string{"null", "null"}},
+		},
+		{
+			// One non-repeater, and two columns walked side by side,
+			// a row at a time.
+			"getbulk",
+			snmpRequest(snmpVersion2c, "public", snmpGetBulkRequest, 1, 3, col(4, 0), col(1, 0), col(2, 0)),
+			snmpResult{
+				oids:   oidStrings(col(4, 1), col(1, 1), col(2, 1), col(1, 2), col(2, 2), col(2, 1), col(3, 1)),
+				values: []string{"1", "kitchen", "123", "garden", "0", "123", "456"},
+			},
+		},
+		{
+			// The walk stops once every repeater has run out.
+			"getbulk past the end",
+			snmpRequest(snmpVersion2c, "public", snmpGetBulkRequest, 0, 10, col(5, 1), col(5, 2)),
+			snmpResult{
+				oids:   oidStrings(col(5, 2), col(5, 2), col(5, 2), col(5, 2)),
+				values: []string{"-1", "endOfMibView", "endOfMibView", "endOfMibView"},
+			},
+		},
+		{
+			"getbulk with too many non-repeaters",
+			snmpRequest(snmpVersion2c, "public", snmpGetBulkRequest, 5, 10, col(1, 0)),
+			snmpResult{oids: oidStrings(col(1, 1)), values: []string{"kitchen"}},
+		},
+		{
+			"set",
+			snmpRequest(snmpVersion2c, "public", 0xa3, 0, 0, col(1, 1)),
+			snmpResult{errorStatus: snmpGenErr, oids: oidStrings(col(1, 1)), values: []string{"null"}},
+		},
+	} {
+		resp, err := a.handle(tc.request)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if got := parseSNMPResponse(t, resp); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v:\n got %+v\nwant %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSNMPGetBulkBounded(t *testing.T) {
+	a := testAgent(t)
+	var oids []oid
+	for i := 0; i < 30; i++ {
+		oids = append(oids, a.root)
+	}
+	resp, err := a.handle(snmpRequest(snmpVersion2c, "public", snmpGetBulkRequest, 0, 1000, oids...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whole rows only, as many as fit.
+	if n := len(parseSNMPResponse(t, resp).oids); n != 60 {
+		t.Errorf("%v variables, want 60", n)
+	}
+}
+
+func TestSNMPErrors(t *testing.T) {
+	a := testAgent(t)
+	get := snmpRequest(snmpVersion2c, "public", snmpGetRequest, 0, 0, col(1, 1))
+	for _, tc := range []struct {
+		name    string
+		request []byte
+		want    string
+	}{
+		{"wrong community", snmpRequest(snmpVersion2c, "private", snmpGetRequest, 0, 0, col(1, 1)), "wrong community"},
+		{"SNMPv3", snmpRequest(3, "public", snmpGetRequest, 0, 0, col(1, 1)), "unsupported version 3"},
+		{"GetBulk in SNMPv1", snmpRequest(snmpVersion1, "public", snmpGetBulkRequest, 0, 1, col(1, 1)), "GetBulk in SNMPv1"},
+		{"truncated", get[:len(get)-1], "malformed"},
+		{"empty", nil, "malformed"},
+		{"not a sequence", append([]byte{berInteger}, get[1:]...), "malformed"},
+	} {
+		if _, err := a.handle(tc.request); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: %v, want an error with %q", tc.name, err, tc.want)
+		}
+	}
+}
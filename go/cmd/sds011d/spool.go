@@ -0,0 +1,260 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// A spool is a sink that passes readings on to another sink, keeping
+// the ones it fails to deliver in a queue on disk. While the queue
+// isn't empty, new readings join it too, so that they are delivered
+// in order.
+//
+// The queue is the file "queue" in the spool's directory, with one
+// reading as JSON per line. The file "head" holds the offset of the
+// first reading that hasn't been delivered yet. Once everything is
+// delivered, the queue is truncated.
+type spool struct {
+	next    sink.Sink
+	name    string
+	config  *SpoolConfig
+	metrics *exporter.Metrics
+
+	mu       sync.Mutex
+	queue    *os.File
+	headPath string
+	head     int64
+	depth    int
+
+	// wake is signalled when a reading is queued.
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func openSpool(next sink.Sink, name string, config *SpoolConfig, metrics *exporter.Metrics) (*spool, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	queue, err := os.OpenFile(filepath.Join(config.Dir, "queue"), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		next:     next,
+		name:     name,
+		config:   config,
+		metrics:  metrics,
+		queue:    queue,
+		headPath: filepath.Join(config.Dir, "head"),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if b, err := os.ReadFile(s.headPath); err == nil {
+		if s.head, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			queue.Close()
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		queue.Close()
+		return nil, err
+	}
+	// Count what's left from the last run.
+	if _, err := queue.Seek(s.head, io.SeekStart); err != nil {
+		queue.Close()
+		return nil, err
+	}
+	scanner := bufio.NewScanner(queue)
+	for scanner.Scan() {
+		s.depth++
+	}
+	if err := scanner.Err(); err != nil {
+		queue.Close()
+		return nil, err
+	}
+	if s.depth > 0 {
+		log.Infof("sink %v: %d readings waiting in the spool", name, s.depth)
+	}
+	metrics.SetSpoolDepth(name, s.depth)
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// Write delivers r right away if nothing is queued, and queues it
+// otherwise, or if the delivery fails.
+func (s *spool) Write(r *sink.Reading) error {
+	s.mu.Lock()
+	empty := s.depth == 0
+	s.mu.Unlock()
+	if empty {
+		err := s.next.Write(r)
+		if err == nil {
+			return nil
+		}
+		log.Errorf("sink %v: %v; spooling", s.name, err)
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.queue.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.depth++
+	for s.depth > s.config.MaxReadings {
+		_, n, err := s.readAt(s.head)
+		if err != nil {
+			return err
+		}
+		if err := s.advance(n); err != nil {
+			return err
+		}
+		s.metrics.ObserveSpoolDrop(s.name)
+	}
+	s.metrics.SetSpoolDepth(s.name, s.depth)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// readAt returns the line starting at off, without the newline, and
+// its length with the newline. It must be called with the lock held.
+func (s *spool) readAt(off int64) ([]byte, int64, error) {
+	var line []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.queue.ReadAt(buf, off+int64(len(line)))
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			line = append(line, buf[:i]...)
+			return line, int64(len(line) + 1), nil
+		}
+		line = append(line, buf[:n]...)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, 0, err
+		}
+	}
+}
+
+// advance removes the first n bytes of the queue. It must be called
+// with the lock held.
+func (s *spool) advance(n int64) error {
+	s.head += n
+	s.depth--
+	if s.depth == 0 {
+		if err := s.queue.Truncate(0); err != nil {
+			return err
+		}
+		s.head = 0
+	}
+	return os.WriteFile(s.headPath, []byte(strconv.FormatInt(s.head, 10)), 0644)
+}
+
+// run delivers the queued readings until the spool is closed.
+func (s *spool) run() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		depth, head := s.depth, s.head
+		var (
+			line []byte
+			n    int64
+			err  error
+		)
+		if depth > 0 {
+			line, n, err = s.readAt(head)
+		}
+		s.mu.Unlock()
+
+		wait := s.wake
+		var retry <-chan time.Time
+		if depth > 0 {
+			if err == nil {
+				err = s.deliver(line)
+			}
+			if err == nil {
+				s.mu.Lock()
+				// Unless Write dropped the reading in the
+				// meantime.
+				if s.head == head {
+					err = s.advance(n)
+				}
+				s.metrics.SetSpoolDepth(s.name, s.depth)
+				s.mu.Unlock()
+				if err == nil {
+					continue
+				}
+			}
+			log.Errorf("sink %v: %v", s.name, err)
+			wait, retry = nil, time.After(s.config.RetryInterval.Duration)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-wait:
+		case <-retry:
+		}
+	}
+}
+
+func (s *spool) deliver(line []byte) error {
+	r := new(sink.Reading)
+	if err := json.Unmarshal(line, r); err != nil {
+		// There's no point in trying again.
+		log.Errorf("sink %v: dropping bad spooled reading %q: %v", s.name, line, err)
+		return nil
+	}
+	return s.next.Write(r)
+}
+
+// Flush flushes the underlying sink.
+func (s *spool) Flush() error {
+	return s.next.Flush()
+}
+
+// Close stops delivering the queued readings, which stay on disk for
+// the next run, and closes the underlying sink.
+func (s *spool) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	err := s.queue.Close()
+	if nextErr := s.next.Close(); err == nil {
+		err = nextErr
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/exporter"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+var errDown = errors.New("down")
+
+// fakeSink records the readings written to it, by PM2.5 level, and
+// fails while it's down, and for its first failFirst writes.
+type fakeSink struct {
+	mu        sync.Mutex
+	down      bool
+	failFirst int
+	written   []float64
+	attempts  int
+	closed    bool
+}
+
+func (s *fakeSink) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *fakeSink) Write(r *sink.Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.down || s.attempts <= s.failFirst {
+		return errDown
+	}
+	s.written = append(s.written, r.PM25)
+	return nil
+}
+
+func (s *fakeSink) Flush() error { return nil }
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// levels returns the PM2.5 levels of the readings written so far.
+func (s *fakeSink) levels() []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]float64(nil), s.written...)
+}
+
+// waitForLevels waits until s got want.
+func waitForLevels(t *testing.T, s *fakeSink, want []float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := s.levels()
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("written: %v, want %v", got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func openTestSpool(t *testing.T, next sink.Sink, config SpoolConfig) *spool {
+	t.Helper()
+	s, err := openSpool(next, "test", &config, exporter.NewMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *spool) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+func TestSpoolOrder(t *testing.T) {
+	next := new(fakeSink)
+	s := openTestSpool(t, next, SpoolConfig{Dir: t.TempDir(), MaxReadings: 100, RetryInterval: Duration{10 * time.Millisecond}})
+	defer s.Close()
+	write := func(pm25 float64) {
+		t.Helper()
+		if err := s.Write(reading("kitchen", pm25, pm25, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1)
+	next.setDown(true)
+	write(2)
+	write(3)
+	if got := s.queued(); got != 2 {
+		t.Errorf("spooled %d readings, want 2", got)
+	}
+	next.setDown(false)
+	// New readings queue up behind the spooled ones.
+	write(4)
+	waitForLevels(t, next, []float64{1, 2, 3, 4})
+	if got := s.queued(); got != 0 {
+		t.Errorf("%d readings left in the spool", got)
+	}
+}
+
+func TestSpoolRestart(t *testing.T) {
+	dir := t.TempDir()
+	next := &fakeSink{down: true}
+	s := openTestSpool(t, next, SpoolConfig{Dir: dir, MaxReadings: 100, RetryInterval: Duration{time.Hour}})
+	for i := 1; i <= 3; i++ {
+		s.Write(reading("kitchen", float64(i), float64(i), 0))
+	}
+	s.Close()
+
+	// What's left is delivered by the next run.
+	next = new(fakeSink)
+	s = openTestSpool(t, next, SpoolConfig{Dir: dir, MaxReadings: 100, RetryInterval: Duration{10 * time.Millisecond}})
+	defer s.Close()
+	waitForLevels(t, next, []float64{1, 2, 3})
+}
+
+func TestSpoolFull(t *testing.T) {
+	next := &fakeSink{down: true}
+	s := openTestSpool(t, next, SpoolConfig{Dir: t.TempDir(), MaxReadings: 2, RetryInterval: Duration{10 * time.Millisecond}})
+	defer s.Close()
+	for i := 1; i <= 4; i++ {
+		s.Write(reading("kitchen", float64(i), float64(i), 0))
+	}
+	// The oldest readings make room for the new ones.
+	next.setDown(false)
+	waitForLevels(t, next, []float64{3, 4})
+}
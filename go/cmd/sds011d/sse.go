@@ -0,0 +1,80 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// sseKeepalive is how often a comment is sent on an otherwise idle
+// event stream, so that proxies don't time it out.
+const sseKeepalive = 30 * time.Second
+
+// handleEvents pushes every new reading as a Server-Sent Event named
+// "measurement", whose data is the reading as JSON. It takes the same
+// parameters as handleStream.
+func (d *daemon) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("method %v not allowed", r.Method)})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return
+	}
+	ch, err := d.subscribe(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer d.hub.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case reading, ok := <-ch:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(reading)
+			if err != nil {
+				log.Errorf("events: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: measurement\ndata: %s\n\n", b); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// subscribers returns how many subscriptions the hub of d has.
+func subscribers(d *daemon) int {
+	d.hub.mu.Lock()
+	defer d.hub.mu.Unlock()
+	return len(d.hub.subscribers)
+}
+
+func TestEvents(t *testing.T) {
+	d, _, server := testDaemon(t, twoSensors)
+	before := subscribers(d)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/v1/events?sensor=kitchen", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %v, want 200", resp.Status)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type %q, want text/event-stream", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control %q, want no-cache", got)
+	}
+
+	// The headers are only sent once the handler has subscribed, so
+	// these aren't missed. The reading of the garden isn't sent.
+	d.hub.Write(&sink.Reading{Sensor: "garden", Point: &sds011.Point{PM25: 1, PM10: 2, Timestamp: t0}})
+	d.hub.Write(&sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 10.5, PM10: 20, Timestamp: t0}})
+	d.hub.Write(&sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 11, PM10: 21, Timestamp: t0.Add(time.Second)}})
+	r := bufio.NewReader(resp.Body)
+	for _, want := range []float64{10.5, 11} {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading an event: %v", err)
+			}
+			if line == "\n" {
+				break
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) != 2 || lines[0] != "event: measurement\n" || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("event %q, want an event line and a data line", lines)
+		}
+		var reading map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &reading); err != nil {
+			t.Fatalf("%q: %v", lines[1], err)
+		}
+		if reading["sensor"] != "kitchen" || reading["pm25"] != want {
+			t.Errorf("event %q, want PM2.5 %v of the kitchen", lines[1], want)
+		}
+	}
+
+	// When the client goes away, the handler returns, and gives up its
+	// subscription.
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); subscribers(d) != before; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers after the client went away, want %d", subscribers(d), before)
+		}
+	}
+}
+
+func TestEventsErrors(t *testing.T) {
+	d, _, server := testDaemon(t, twoSensors)
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{"POST", "/v1/events", http.StatusMethodNotAllowed},
+		{"GET", "/v1/events?sensor=hall", http.StatusNotFound},
+		{"GET", "/v1/events?policy=block", http.StatusBadRequest},
+	} {
+		if code, body := call(t, server, tc.method, tc.path, "", ""); code != tc.code || !strings.Contains(body, `"error"`) {
+			t.Errorf("%v %v: %v %v, want %v with an error", tc.method, tc.path, code, body, tc.code)
+		}
+	}
+	if n := subscribers(d); n != 0 {
+		t.Errorf("%d subscribers left, want 0", n)
+	}
+}
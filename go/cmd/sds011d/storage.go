@@ -0,0 +1,78 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// pruneInterval is how often the store is pruned.
+const pruneInterval = time.Hour
+
+// options returns the config of the store.
+func (sc *StoreConfig) options() *store.Config {
+	config := &store.Config{Backend: sc.Backend, Path: sc.Path, Retention: sc.Retention.Duration}
+	for _, tier := range sc.Downsample {
+		config.Downsample = append(config.Downsample, store.Tier{Resolution: tier.Resolution.Duration, Retention: tier.Retention.Duration})
+	}
+	return config
+}
+
+// openStore opens the store config describes, and prunes it every
+// pruneInterval until it's closed.
+func openStore(config *StoreConfig) (store.Store, error) {
+	st, err := store.Open(config.options())
+	if err != nil {
+		return nil, err
+	}
+	if err := st.Prune(time.Now()); err != nil {
+		st.Close()
+		return nil, err
+	}
+	ps := &prunedStore{Store: st, done: make(chan struct{})}
+	go ps.run()
+	return ps, nil
+}
+
+// prunedStore is a store pruned in the background.
+type prunedStore struct {
+	store.Store
+	done chan struct{}
+	once sync.Once
+}
+
+func (ps *prunedStore) run() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ps.done:
+			return
+		case now := <-ticker.C:
+			if err := ps.Prune(now); err != nil {
+				log.Errorf("store: %v", err)
+			}
+		}
+	}
+}
+
+func (ps *prunedStore) Close() error {
+	ps.once.Do(func() { close(ps.done) })
+	return ps.Store.Close()
+}
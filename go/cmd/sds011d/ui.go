@@ -0,0 +1,43 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+)
+
+// uiPage is a dashboard showing the live readings, the history and the
+// settings of the sensors, with buttons to control them. It only uses
+// the API, so it needs nothing but a browser.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// handleUI serves the dashboard on /, and a 404 for anything else the
+// API doesn't handle.
+func (d *daemon) handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, r, notFound("%v not found", r.URL.Path))
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, r, &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method)})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sysTTY is where Linux lists the serial ports. For USB adapters,
+// /sys/class/tty/ttyUSB0/device is a link into the sysfs directory of
+// the USB device, which has the idVendor, idProduct and serial
+// attributes: directly under it for CDC ACM adapters (ttyACM0), and
+// one more level down for USB serial converters like the CH340
+// (ttyUSB0). It is a variable for the tests.
+var sysTTY = "/sys/class/tty"
+
+var (
+	errNoDevice        = errors.New("no matching USB device")
+	errAmbiguousDevice = errors.New("several USB devices match; set the serial of the adapter, or use port_path")
+)
+
+// usbAttribute returns the value of a sysfs attribute of the USB
+// device dir, or "" if it doesn't have it.
+func usbAttribute(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// usbDevice returns the sysfs directory of the USB device dev belongs
+// to, or "" if it isn't a USB device.
+func usbDevice(dev string) string {
+	for dir := filepath.Dir(dev); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if usbAttribute(dir, "idVendor") != "" {
+			return dir
+		}
+	}
+	return ""
+}
+
+// overlaps reports whether m and other can match the same adapter.
+func (m *USBConfig) overlaps(other *USBConfig) bool {
+	return strings.EqualFold(m.VendorID, other.VendorID) &&
+		strings.EqualFold(m.ProductID, other.ProductID) &&
+		(m.Serial == "" || other.Serial == "" || m.Serial == other.Serial)
+}
+
+// findUSBPort returns the path of the serial port belonging to the
+// USB device that matches m. It is an error if several ports match,
+// as picking one of them could give a sensor the port of another.
+func findUSBPort(m *USBConfig) (string, error) {
+	ttys, err := os.ReadDir(sysTTY)
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, tty := range ttys {
+		dev, err := filepath.EvalSymlinks(filepath.Join(sysTTY, tty.Name(), "device"))
+		if err != nil {
+			// Not backed by a device, like the virtual consoles.
+			continue
+		}
+		dir := usbDevice(dev)
+		if dir == "" {
+			continue
+		}
+		if !strings.EqualFold(usbAttribute(dir, "idVendor"), m.VendorID) ||
+			!strings.EqualFold(usbAttribute(dir, "idProduct"), m.ProductID) {
+			continue
+		}
+		if m.Serial != "" && usbAttribute(dir, "serial") != m.Serial {
+			continue
+		}
+		found = append(found, filepath.Join("/dev", tty.Name()))
+	}
+	switch len(found) {
+	case 0:
+		return "", errNoDevice
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("%v: %v", strings.Join(found, ", "), errAmbiguousDevice)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs lays out a /sys with the given USB adapters, keyed by the
+// name of their tty, and points sysTTY at it.
+func fakeSysfs(t *testing.T, adapters map[string]USBConfig) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("making links needs privileges on Windows")
+	}
+	root := t.TempDir()
+	class := filepath.Join(root, "class", "tty")
+	mkdir := func(dir string) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, value string) {
+		if err := os.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		mkdir(filepath.Join(class, name))
+		if err := os.Symlink(target, filepath.Join(class, name, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	i := 0
+	for name, a := range adapters {
+		i++
+		usb := filepath.Join(root, "devices", "usb1", "1-"+string(rune('0'+i)))
+		iface := filepath.Join(usb, filepath.Base(usb)+":1.0")
+		dev := iface
+		// USB serial converters have a device of their own under the
+		// interface; CDC ACM adapters don't.
+		if strings.HasPrefix(name, "ttyUSB") {
+			dev = filepath.Join(iface, name)
+		}
+		mkdir(dev)
+		write(filepath.Join(usb, "idVendor"), a.VendorID)
+		write(filepath.Join(usb, "idProduct"), a.ProductID)
+		if a.Serial != "" {
+			write(filepath.Join(usb, "serial"), a.Serial)
+		}
+		link(dev, name)
+	}
+	// A built-in port, which isn't a USB device, and a virtual
+	// console, which isn't a device at all.
+	platform := filepath.Join(root, "devices", "platform", "serial8250", "tty", "ttyS0")
+	mkdir(platform)
+	link(platform, "ttyS0")
+	mkdir(filepath.Join(class, "tty1"))
+
+	old := sysTTY
+	t.Cleanup(func() { sysTTY = old })
+	sysTTY = class
+}
+
+func TestFindUSBPort(t *testing.T) {
+	fakeSysfs(t, map[string]USBConfig{
+		"ttyUSB0": {VendorID: "1a86", ProductID: "7523"},
+		"ttyACM0": {VendorID: "2341", ProductID: "0043", Serial: "A1"},
+		"ttyACM1": {VendorID: "2341", ProductID: "0043", Serial: "B2"},
+	})
+	for _, tc := range []struct {
+		m       USBConfig
+		want    string
+		wantErr error
+	}{
+		{m: USBConfig{VendorID: "1a86", ProductID: "7523"}, want: "/dev/ttyUSB0"},
+		{m: USBConfig{VendorID: "1A86", ProductID: "7523"}, want: "/dev/ttyUSB0"},
+		{m: USBConfig{VendorID: "2341", ProductID: "0043", Serial: "B2"}, want: "/dev/ttyACM1"},
+		{m: USBConfig{VendorID: "2341", ProductID: "0043", Serial: "C3"}, wantErr: errNoDevice},
+		{m: USBConfig{VendorID: "1a86", ProductID: "5523"}, wantErr: errNoDevice},
+		{m: USBConfig{VendorID: "2341", ProductID: "0043"}, wantErr: errAmbiguousDevice},
+	} {
+		got, err := findUSBPort(&tc.m)
+		if tc.wantErr != nil {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr.Error()) {
+				t.Errorf("findUSBPort(%+v): %q, %v, want error %v", tc.m, got, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("findUSBPort(%+v): %q, %v, want %q", tc.m, got, err, tc.want)
+		}
+	}
+}
+
+func TestFindUSBPortAmbiguous(t *testing.T) {
+	// Two CH340 adapters, which have no serial number.
+	fakeSysfs(t, map[string]USBConfig{
+		"ttyUSB0": {VendorID: "1a86", ProductID: "7523"},
+		"ttyUSB1": {VendorID: "1a86", ProductID: "7523"},
+	})
+	_, err := findUSBPort(&USBConfig{VendorID: "1a86", ProductID: "7523"})
+	if err == nil || !strings.Contains(err.Error(), "/dev/ttyUSB0, /dev/ttyUSB1") {
+		t.Errorf("findUSBPort: %v, want an error naming both ports", err)
+	}
+}
+
+func TestUSBConfigOverlaps(t *testing.T) {
+	ch340 := USBConfig{VendorID: "1a86", ProductID: "7523"}
+	for _, tc := range []struct {
+		a, b USBConfig
+		want bool
+	}{
+		{ch340, ch340, true},
+		{ch340, USBConfig{VendorID: "1A86", ProductID: "7523"}, true},
+		{ch340, USBConfig{VendorID: "1a86", ProductID: "7523", Serial: "A1"}, true},
+		{USBConfig{VendorID: "1a86", ProductID: "7523", Serial: "A1"}, USBConfig{VendorID: "1a86", ProductID: "7523", Serial: "A1"}, true},
+		{USBConfig{VendorID: "1a86", ProductID: "7523", Serial: "A1"}, USBConfig{VendorID: "1a86", ProductID: "7523", Serial: "B2"}, false},
+		{ch340, USBConfig{VendorID: "2341", ProductID: "7523"}, false},
+	} {
+		if got := tc.a.overlaps(&tc.b); got != tc.want {
+			t.Errorf("%+v overlaps %+v: %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if got := tc.b.overlaps(&tc.a); got != tc.want {
+			t.Errorf("%+v overlaps %+v: %v, want %v", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// This is just enough of RFC 6455 to push messages to browsers: the
+// server side of the handshake, unfragmented frames, and the control
+// frames.
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+
+	// wsGUID is the magic value from RFC 6455, section 1.3.
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// wsMaxPayload is the largest frame we accept from clients. They
+	// have no reason to send us anything but control frames.
+	wsMaxPayload = 4096
+
+	// wsPingInterval is how often the server pings the clients.
+	wsPingInterval = 30 * time.Second
+	// wsPongTimeout is how long a client has to respond before it is
+	// considered dead.
+	wsPongTimeout = 2 * wsPingInterval
+)
+
+// wsConn is a server side WebSocket connection.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// mu serializes writes.
+	mu sync.Mutex
+}
+
+// upgradeWebSocket performs the WebSocket handshake and hijacks the
+// connection. If it fails, there is nothing left to send: a bad
+// handshake is answered with an error, and a connection that was
+// already hijacked is closed.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if err := checkHandshake(r); err != nil {
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		writeError(w, r, err)
+		return nil, err
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		err := errors.New("websocket: connection can't be hijacked")
+		writeError(w, r, err)
+		return nil, err
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		writeError(w, r, err)
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", wsAccept(r.Header.Get("Sec-WebSocket-Key")))
+	if err := rw.Flush(); err != nil {
+		log.V(1).Infof("websocket %v: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// checkHandshake returns an error if r isn't a WebSocket handshake we
+// can accept.
+func checkHandshake(r *http.Request) error {
+	if r.Method != "GET" {
+		return &httpError{http.StatusMethodNotAllowed, errors.New("websocket: method should be GET")}
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return badRequest("websocket: not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return badRequest("websocket: unsupported version")
+	}
+	if r.Header.Get("Sec-WebSocket-Key") == "" {
+		return badRequest("websocket: missing key")
+	}
+	return nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept that answers key.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains returns true if the comma separated header contains
+// token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame sends a single unfragmented frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsPongTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads a single frame sent by the client.
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("websocket: client frame not masked")
+	}
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > wsMaxPayload {
+		return 0, nil, fmt.Errorf("websocket: frame too large (%d bytes)", n)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop handles the frames sent by the client, answering pings
+// and keeping the connection alive while pongs keep coming. It
+// returns when the client goes away or closes the connection.
+func (c *wsConn) readLoop() {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			log.V(1).Infof("websocket %v: %v", c.conn.RemoteAddr(), err)
+			return
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return
+			}
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// handleStream pushes every new reading to a WebSocket client, as a
+// JSON text message. The optional sensor parameter restricts the
+// stream to a single sensor, and buffer and policy set how the
+// client's backpressure is handled (see hub.go).
+func (d *daemon) handleStream(w http.ResponseWriter, r *http.Request) {
+	ch, err := d.subscribe(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer d.hub.unsubscribe(ch)
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.readLoop()
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case reading, ok := <-ch:
+			if !ok {
+				conn.writeFrame(wsClose, nil)
+				return
+			}
+			b, err := json.Marshal(reading)
+			if err != nil {
+				log.Errorf("websocket: %v", err)
+				continue
+			}
+			if err := conn.writeFrame(wsText, b); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+func TestWSAccept(t *testing.T) {
+	// The example from RFC 6455, section 1.3.
+	if got, want := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("wsAccept: %q, want %q", got, want)
+	}
+}
+
+// wsClientFrame returns a frame as a client sends it, masked.
+func wsClientFrame(opcode byte, payload []byte) []byte {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// dialWebSocket makes the handshake with the server at path, and
+// returns the connection.
+func dialWebSocket(t *testing.T, server *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %v, want %v", resp.Status, http.StatusSwitchingProtocols)
+	}
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("Sec-WebSocket-Accept: %q, want %q", got, want)
+	}
+	return conn, r
+}
+
+// readServerFrame reads an unmasked, unfragmented frame.
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		t.Fatalf("frame header % x: want FIN set and no mask", header)
+	}
+	n := int(header[1])
+	if n == 126 {
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			t.Fatal(err)
+		}
+		n = int(b[0])<<8 | int(b[1])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestWebSocketStream(t *testing.T) {
+	d, _, server := testDaemon(t, minimalConfig(""))
+	conn, r := dialWebSocket(t, server, "/v1/stream")
+
+	// Readings are sent as text frames. The server subscribed before
+	// it answered the handshake, so none is missed.
+	d.hub.Write(&sink.Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 10.5, PM10: 20, Timestamp: t0}})
+	opcode, payload := readServerFrame(t, r)
+	if opcode != wsText {
+		t.Fatalf("opcode %#x, want text", opcode)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("%q: %v", payload, err)
+	}
+	if got["sensor"] != "kitchen" || got["pm25"] != 10.5 {
+		t.Errorf("reading %s, want PM2.5 10.5 of the kitchen", payload)
+	}
+
+	// Masked pings are answered with the same payload.
+	if _, err := conn.Write(wsClientFrame(wsPing, []byte("are you there?"))); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, payload := readServerFrame(t, r); opcode != wsPong || string(payload) != "are you there?" {
+		t.Errorf("%#x %q, want a pong with the payload of the ping", opcode, payload)
+	}
+
+	// A close is echoed, and the server hangs up.
+	closing := []byte{0x03, 0xe8, 'b', 'y', 'e'}
+	if _, err := conn.Write(wsClientFrame(wsClose, closing)); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, payload := readServerFrame(t, r); opcode != wsClose || !bytes.Equal(payload, closing) {
+		t.Errorf("%#x % x, want a close with % x", opcode, payload, closing)
+	}
+	if b, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("read %#x, %v after the close; want EOF", b, err)
+	}
+}
+
+func TestWebSocketLargeFrame(t *testing.T) {
+	_, _, server := testDaemon(t, minimalConfig(""))
+	conn, r := dialWebSocket(t, server, "/v1/stream")
+	payload := bytes.Repeat([]byte("x"), 300)
+	if _, err := conn.Write(wsClientFrame(wsPing, payload)); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, got := readServerFrame(t, r); opcode != wsPong || !bytes.Equal(got, payload) {
+		t.Errorf("%#x with %d bytes, want a pong with the %d of the ping", opcode, len(got), len(payload))
+	}
+}
+
+func TestWebSocketUnmasked(t *testing.T) {
+	_, _, server := testDaemon(t, minimalConfig(""))
+	conn, r := dialWebSocket(t, server, "/v1/stream")
+	frame := wsClientFrame(wsPing, []byte("hi"))
+	frame[1] &^= 0x80
+	if _, err := conn.Write(append(frame[:2], "hi"...)); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("read %#x, %v; want the connection closed", b, err)
+	}
+}
+
+func TestWebSocketRejected(t *testing.T) {
+	_, _, server := testDaemon(t, minimalConfig(""))
+	upgrade := map[string]string{
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+	}
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		header  map[string]string
+		code    int
+		version bool // Whether the supported version is sent back.
+	}{
+		{"not an upgrade", "GET", "/v1/stream", map[string]string{"Connection": "keep-alive", "Upgrade": ""}, http.StatusBadRequest, false},
+		{"upgrade to something else", "GET", "/v1/stream", map[string]string{"Upgrade": "h2c"}, http.StatusBadRequest, false},
+		{"old version", "GET", "/v1/stream", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusBadRequest, true},
+		{"no key", "GET", "/v1/stream", map[string]string{"Sec-WebSocket-Key": ""}, http.StatusBadRequest, false},
+		{"POST", "POST", "/v1/stream", nil, http.StatusMethodNotAllowed, false},
+		{"unknown sensor", "GET", "/v1/stream?sensor=hall", nil, http.StatusNotFound, false},
+	} {
+		req, err := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range upgrade {
+			req.Header.Set(k, v)
+		}
+		// An empty value removes the header.
+		for k, v := range tc.header {
+			if v == "" {
+				req.Header.Del(k)
+			} else {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]string
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.code || err != nil || body["error"] == "" {
+			t.Errorf("%v: %v with %v, %v; want %v with an error", tc.name, resp.Status, body, err, tc.code)
+		}
+		if got := resp.Header.Get("Sec-WebSocket-Version") == "13"; got != tc.version {
+			t.Errorf("%v: Sec-WebSocket-Version %q", tc.name, resp.Header.Get("Sec-WebSocket-Version"))
+		}
+	}
+}
+
+// brokenHijacker is a ResponseWriter whose connection goes away as soon
+// as it's hijacked.
+type brokenHijacker struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h *brokenHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	client, server := net.Pipe()
+	client.Close()
+	h.conn = server
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func TestUpgradeWebSocketBrokenConn(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/stream", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := &brokenHijacker{ResponseRecorder: httptest.NewRecorder()}
+	if conn, err := upgradeWebSocket(w, r); err == nil {
+		conn.Close()
+		t.Fatal("upgradeWebSocket: no error")
+	}
+	// Nothing may be written to a hijacked ResponseWriter.
+	if w.Body.Len() > 0 || len(w.Header()) > 0 {
+		t.Errorf("wrote %v %q to the ResponseWriter after hijacking", w.Header(), w.Body)
+	}
+	if _, err := w.conn.Write([]byte{0}); err != io.ErrClosedPipe {
+		t.Errorf("writing to the hijacked connection: %v, want it closed", err)
+	}
+}
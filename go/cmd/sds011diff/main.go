@@ -0,0 +1,195 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011diff compares the readings of two sensors, usually co-located
+// to check that a new unit agrees with the one it replaces.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+var (
+	nameA    = flag.String("a", "", "if set, the name of the sensor to take as A from the files")
+	nameB    = flag.String("b", "", "if set, the name of the sensor to take as B from the files")
+	ports    = flag.String("ports", "", "if set, the serial ports of A and B, separated by a comma, to read instead of files")
+	duration = flag.Duration("duration", time.Hour, "how long to read the sensors for, with -ports")
+	interval = flag.Duration("interval", time.Minute, "length of the intervals the readings are averaged over before comparing")
+	maxHold  = flag.Duration("max_hold", 5*time.Minute, "longest a reading counts for in the means")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011diff compares the readings of two sensors, A and B.
+
+It averages the readings of both over the same intervals, and reports
+how B agrees with A: the mean difference (bias), root mean square
+difference, correlation, the least squares line B = slope * A +
+intercept, and how much the difference changes in a day (drift).
+
+The readings are in two files, like those written by the sds011
+command or the sinks of sds011d:
+
+	sds011diff old.csv new.csv
+
+or in any number of files, told apart by sensor name:
+
+	sds011diff -a=old -b=new readings.jsonl
+
+or come from the sensors themselves:
+
+	sds011diff -ports=/dev/ttyUSB0,/dev/ttyUSB1 -duration=24h
+
+Usage: sds011diff [flags] [file...]
+`)
+		flag.PrintDefaults()
+	}
+}
+
+// points returns the points of the readings of the named sensor, or
+// of all of them if name is empty.
+func points(readings []*sink.Reading, name string) []sds011.Point {
+	var ps []sds011.Point
+	for _, r := range readings {
+		if name == "" || r.Sensor == name {
+			ps = append(ps, *r.Point)
+		}
+	}
+	return ps
+}
+
+func fromFiles(paths []string) (a, b []sds011.Point, err error) {
+	switch {
+	case *nameA != "" && *nameB != "":
+		readings, err := sink.ReadFiles("", paths...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return points(readings, *nameA), points(readings, *nameB), nil
+	case len(paths) == 2:
+		readingsA, err := sink.ReadFiles("", paths[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		readingsB, err := sink.ReadFiles("", paths[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		return points(readingsA, *nameA), points(readingsB, *nameB), nil
+	}
+	return nil, nil, fmt.Errorf("need two files, or -a and -b")
+}
+
+// read returns what the sensor at path reads in the given time.
+func read(path string, d time.Duration) ([]sds011.Point, error) {
+	sensor, err := sds011.New(path)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(d)
+	// Closing the sensor stops a Get that's waiting.
+	timer := time.AfterFunc(d, func() { sensor.Close() })
+	defer timer.Stop()
+
+	var ps []sds011.Point
+	for time.Now().Before(deadline) {
+		point, err := sensor.Get()
+		if err != nil {
+			if time.Now().Before(deadline) {
+				log.Printf("%v: %v", path, err)
+			}
+			continue
+		}
+		ps = append(ps, *point)
+	}
+	return ps, nil
+}
+
+func fromPorts(ports string, d time.Duration) (a, b []sds011.Point, err error) {
+	paths := strings.Split(ports, ",")
+	if len(paths) != 2 {
+		return nil, nil, fmt.Errorf("-ports must have two ports, not %d", len(paths))
+	}
+	var (
+		wg         sync.WaitGroup
+		errA, errB error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a, errA = read(paths[0], d)
+	}()
+	go func() {
+		defer wg.Done()
+		b, errB = read(paths[1], d)
+	}()
+	wg.Wait()
+	if errA != nil {
+		return nil, nil, errA
+	}
+	return a, b, errB
+}
+
+func main() {
+	flag.Parse()
+	if *interval <= 0 {
+		log.Fatalf("bad -interval %v, want it positive", *interval)
+	}
+	var (
+		a, b []sds011.Point
+		err  error
+	)
+	if *ports != "" {
+		a, b, err = fromPorts(*ports, *duration)
+	} else {
+		a, b, err = fromFiles(flag.Args())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pairs := stats.Align(a, b, *interval, *maxHold)
+	if len(pairs) == 0 {
+		log.Fatalf("no intervals with readings of both A (%d readings) and B (%d readings)", len(a), len(b))
+	}
+	c := stats.Compare(pairs)
+	fmt.Printf("%d intervals of %v, from %v to %v\n\n", len(pairs), *interval,
+		pairs[0].Start.Format(time.RFC3339), pairs[len(pairs)-1].Start.Add(*interval).Format(time.RFC3339))
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "\tPM2.5\tPM10\t\n")
+	row := func(name string, v func(*stats.Agreement) float64) {
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t\n", name, v(&c.PM25), v(&c.PM10))
+	}
+	row("mean A", func(ag *stats.Agreement) float64 { return ag.MeanA })
+	row("mean B", func(ag *stats.Agreement) float64 { return ag.MeanB })
+	row("bias (B - A)", func(ag *stats.Agreement) float64 { return ag.Bias })
+	row("RMSD", func(ag *stats.Agreement) float64 { return ag.RMSD })
+	row("correlation", func(ag *stats.Agreement) float64 { return ag.Correlation })
+	row("slope", func(ag *stats.Agreement) float64 { return ag.Slope })
+	row("intercept", func(ag *stats.Agreement) float64 { return ag.Intercept })
+	row("drift per day", func(ag *stats.Agreement) float64 { return ag.Drift })
+	w.Flush()
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011import loads recorded readings into a sink or the store of
+// sds011d, keeping their timestamps, so that the history logged before
+// the daemon (or before a new sink) doesn't stay behind in files.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+var (
+	sinkConfig  = flag.String("sink", "", `the sink to load the readings into, as in the config of sds011d, like {"type": "jsonl", "path": "all.jsonl"}`)
+	storeFlag   = flag.String("store", "", "the store of sds011d to load the readings into, as BACKEND:PATH, like sqlite:/var/lib/sds011d/readings.db")
+	sinkPlugins = flag.String("sink_plugins", "", "comma separated list of Go plugins adding sink types")
+	sensor      = flag.String("sensor", "", "name for readings without one")
+	columns     = flag.String("columns", "", "if set, the -columns of the sds011 command the files were written with")
+	from        = flag.String("from", "", "if set, skip readings before this time, in RFC3339 format")
+	until       = flag.String("until", "", "if set, skip readings from this time on, in RFC3339 format, like when the sink started getting them")
+	batch       = flag.Int("batch", 1000, "how many readings to write before flushing the sink")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011import loads recorded readings into a sink or a store.
+
+It reads the CSV (or TSV) of the sds011 command, and the CSV and JSON
+lines of the sinks of sds011d, from the files given as arguments (or
+standard input), and writes them to the sink given by -sink, of any
+type the daemon has, including those of -sink_plugins, or to the
+store of the daemon given by -store, so that its API and charts have
+them too.
+
+Usage: sds011import [flags] [file...]
+`)
+		flag.PrintDefaults()
+	}
+}
+
+// parseTime parses the value of a time flag, which may be empty.
+func parseTime(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("-%v: %v", name, err)
+	}
+	return t, nil
+}
+
+// importer writes readings to a sink.
+type importer struct {
+	sink        sink.Sink
+	from, until time.Time
+	// sensor and columns configure the readers, as the flags of the
+	// same names do, and batch is how many readings to write
+	// between flushes of the sink.
+	sensor  string
+	columns []string
+	batch   int
+	// written and skipped are the numbers of readings written and
+	// left out for their time.
+	written, skipped int
+}
+
+// load writes the readings in the file at path ("-" meaning standard
+// input) to the sink.
+func (im *importer) load(path string) error {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	return im.read(path, f)
+}
+
+// read writes the readings read from f to the sink. path is used in
+// errors.
+func (im *importer) read(path string, f io.Reader) error {
+	r := sink.NewReader(f)
+	r.Sensor = im.sensor
+	r.Columns = im.columns
+	for {
+		reading, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		if (!im.from.IsZero() && reading.Timestamp.Before(im.from)) || (!im.until.IsZero() && !reading.Timestamp.Before(im.until)) {
+			im.skipped++
+			continue
+		}
+		if err := im.sink.Write(reading); err != nil {
+			return fmt.Errorf("%v: writing %v: %v", path, reading.Point, err)
+		}
+		im.written++
+		if im.written%im.batch == 0 {
+			if err := im.sink.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// openStore returns a sink writing to the store described by value,
+// a backend and a path separated by a colon.
+func openStore(value string) (sink.Sink, error) {
+	backend, path, ok := strings.Cut(value, ":")
+	if !ok || path == "" {
+		return nil, fmt.Errorf("-store: %q is not BACKEND:PATH", value)
+	}
+	if backend == "memory" {
+		return nil, errors.New("-store: the memory backend doesn't outlive sds011import")
+	}
+	// The store isn't pruned or downsampled here: sds011d does that
+	// when it opens it, with the retention of its config.
+	st, err := store.Open(&store.Config{Backend: backend, Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("-store: %v", err)
+	}
+	return store.Sink{Store: st}, nil
+}
+
+// openSink returns the sink described by config, as in the config of
+// sds011d.
+func openSink(config string) (sink.Sink, error) {
+	var c struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		return nil, fmt.Errorf("-sink: %v", err)
+	}
+	s, err := sink.New(c.Type, json.RawMessage(config))
+	if err != nil {
+		return nil, fmt.Errorf("-sink: %v (known types: %v)", err, strings.Join(sink.Types(), ", "))
+	}
+	return s, nil
+}
+
+func main() {
+	flag.Parse()
+	if *sinkPlugins != "" {
+		for _, path := range strings.Split(*sinkPlugins, ",") {
+			if err := sink.LoadPlugin(path); err != nil {
+				log.Fatalf("sink plugin %v: %v", path, err)
+			}
+		}
+	}
+	if (*sinkConfig == "") == (*storeFlag == "") {
+		log.Fatal("one of -sink and -store is required")
+	}
+	if *batch <= 0 {
+		log.Fatal("-batch must be positive")
+	}
+	im := &importer{sensor: *sensor, batch: *batch}
+	if *columns != "" {
+		im.columns = strings.Split(*columns, ",")
+	}
+	var err error
+	if im.from, err = parseTime("from", *from); err != nil {
+		log.Fatal(err)
+	}
+	if im.until, err = parseTime("until", *until); err != nil {
+		log.Fatal(err)
+	}
+	if *storeFlag != "" {
+		im.sink, err = openStore(*storeFlag)
+	} else {
+		im.sink, err = openSink(*sinkConfig)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		if err = im.load(path); err != nil {
+			break
+		}
+	}
+	if cerr := im.sink.Close(); err == nil {
+		err = cerr
+	}
+	log.Printf("imported %d readings, skipped %d", im.written, im.skipped)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sink"
+	"github.com/ryszard/sds011/go/store"
+)
+
+// recorder is a sink that keeps what is written to it.
+type recorder struct {
+	readings []string
+	flushes  int
+}
+
+func (r *recorder) Write(reading *sink.Reading) error {
+	r.readings = append(r.readings, fmt.Sprintf("%v %v %v %v", reading.Sensor, reading.Timestamp.UTC().Format(time.RFC3339), reading.PM25, reading.PM10))
+	return nil
+}
+
+func (r *recorder) Flush() error { r.flushes++; return nil }
+func (r *recorder) Close() error { return nil }
+
+func TestRead(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		columns []string
+		want    []string
+	}{
+		{
+			"sds011 CSV",
+			"2024-06-01T12:00:00Z,10.5,20\n# a comment\n\n2024-06-01T12:01:00Z,11,21.5\n",
+			nil,
+			[]string{"balcony 2024-06-01T12:00:00Z 10.5 20", "balcony 2024-06-01T12:01:00Z 11 21.5"},
+		},
+		{
+			"sds011 TSV with columns",
+			"1717243200\t10.5\t20\t7\n",
+			[]string{"unix", "pm25", "pm10", "pm1"},
+			[]string{"balcony 2024-06-01T12:00:00Z 10.5 20"},
+		},
+		{
+			"csv sink",
+			"2024-06-01T12:00:00Z,kitchen,10.5,20\n",
+			nil,
+			[]string{"kitchen 2024-06-01T12:00:00Z 10.5 20"},
+		},
+		{
+			"jsonl sink",
+			`{"sensor": "kitchen", "timestamp": "2024-06-01T12:00:00Z", "pm25": 10.5, "pm10": 20}` + "\n" +
+				`{"timestamp": "2024-06-01T12:01:00Z", "pm25": 11, "pm10": 21}` + "\n",
+			nil,
+			[]string{"kitchen 2024-06-01T12:00:00Z 10.5 20", "balcony 2024-06-01T12:01:00Z 11 21"},
+		},
+	} {
+		rec := new(recorder)
+		im := &importer{sink: rec, sensor: "balcony", columns: tc.columns, batch: 1}
+		if err := im.read(tc.name, strings.NewReader(tc.input)); err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rec.readings, tc.want) {
+			t.Errorf("%v: %q, want %q", tc.name, rec.readings, tc.want)
+		}
+		if rec.flushes != len(tc.want) {
+			t.Errorf("%v: %d flushes, want one per reading", tc.name, rec.flushes)
+		}
+	}
+}
+
+func TestReadSkips(t *testing.T) {
+	rec := new(recorder)
+	im := &importer{
+		sink:   rec,
+		sensor: "balcony",
+		from:   time.Date(2024, 6, 1, 12, 1, 0, 0, time.UTC),
+		until:  time.Date(2024, 6, 1, 12, 3, 0, 0, time.UTC),
+		batch:  1000,
+	}
+	var input string
+	for i := 0; i < 4; i++ {
+		input += fmt.Sprintf("2024-06-01T12:%02d:00Z,%d,%d\n", i, i, i)
+	}
+	if err := im.read("input", strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if im.written != 2 || im.skipped != 2 || len(rec.readings) != 2 || rec.flushes != 0 {
+		t.Errorf("written %d, skipped %d, %q, %d flushes; want 2, 2, the 2nd and 3rd, 0", im.written, im.skipped, rec.readings, rec.flushes)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, input := range []string{
+		"2024-06-01T12:00:00Z,10.5\n",
+		"yesterday,10.5,20\n",
+		"2024-06-01T12:00:00Z,lots,20\n",
+		`{"sensor": "kitchen", "pm25": }` + "\n",
+	} {
+		im := &importer{sink: new(recorder), batch: 1}
+		if err := im.read("input", strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "input: line 1") {
+			t.Errorf("%q: %v, want an error on line 1", input, err)
+		}
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	input := `2024-06-01T12:00:00Z,kitchen,10.5,20
+2024-06-01T12:01:00Z,kitchen,11,21
+{"sensor": "garden", "timestamp": "2024-06-01T12:00:00Z", "pm25": 30, "pm10": 40}
+2024-06-02T12:00:00Z,kitchen,12,22
+`
+	for _, backend := range []string{"files", "bbolt", "sqlite"} {
+		t.Run(backend, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store")
+			s, err := openStore(backend + ":" + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			im := &importer{sink: s, batch: 2}
+			if err := im.read("input", strings.NewReader(input)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The daemon finds them where it looks.
+			st, err := store.Open(&store.Config{Backend: backend, Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			for sensor, want := range map[string][]string{
+				"kitchen": {"2024-06-01T12:00:00Z 10.5 20", "2024-06-01T12:01:00Z 11 21", "2024-06-02T12:00:00Z 12 22"},
+				"garden":  {"2024-06-01T12:00:00Z 30 40"},
+			} {
+				var got []string
+				err := st.Scan(sensor, time.Time{}, time.Time{}, func(rs []*sink.Reading) error {
+					for _, r := range rs {
+						got = append(got, fmt.Sprintf("%v %v %v", r.Timestamp.UTC().Format(time.RFC3339), r.PM25, r.PM10))
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%v: %q, want %q", sensor, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenStoreErrors(t *testing.T) {
+	for value, want := range map[string]string{
+		"sqlite":            "not BACKEND:PATH",
+		"sqlite:":           "not BACKEND:PATH",
+		"memory:x":          "memory backend",
+		"influxdb:/var/lib": `unknown backend "influxdb"`,
+	} {
+		if _, err := openStore(value); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("openStore(%q): %v, want an error with %q", value, err, want)
+		}
+	}
+}
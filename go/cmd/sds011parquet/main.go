@@ -0,0 +1,104 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011parquet converts recorded readings to Parquet files, one for
+// every day or month.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ryszard/sds011/go/parquet"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+var (
+	out       = flag.String("out", ".", "directory to write the files to")
+	partition = flag.String("partition", "day", `what to put in a file, "day", "month" or "all"`)
+	sensor    = flag.String("sensor", "", "name for readings without one")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011parquet converts recorded readings to Parquet files.
+
+It reads the CSV of the sds011 command, and the CSV and JSON lines of
+the sinks of sds011d, from the files given as arguments (or standard
+input), and writes the readings of every day (UTC) to a file called
+after it, like 2017-03-12.parquet, or of every month, like
+2017-03.parquet. Existing files are overwritten.
+
+Usage: sds011parquet [flags] [file...]
+`)
+		flag.PrintDefaults()
+	}
+}
+
+// layouts are the layouts of the names of the files, by partition.
+var layouts = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"all":   "readings",
+}
+
+func write(path string, readings []*sink.Reading) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := parquet.Write(f, readings); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	flag.Parse()
+	layout, ok := layouts[*partition]
+	if !ok {
+		log.Fatalf("unknown partition %q", *partition)
+	}
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	readings, err := sink.ReadFiles(*sensor, paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	// The readings are in time order, so every file's are together.
+	for len(readings) > 0 {
+		name := readings[0].Timestamp.UTC().Format(layout)
+		n := 1
+		for n < len(readings) && readings[n].Timestamp.UTC().Format(layout) == name {
+			n++
+		}
+		path := filepath.Join(*out, name+".parquet")
+		if err := write(path, readings[:n]); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %d readings to %s", n, path)
+		readings = readings[n:]
+	}
+}
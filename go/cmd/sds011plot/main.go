@@ -0,0 +1,314 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011plot draws charts of recorded readings, as PNG images or SVG
+// documents: the PM levels over time, or box plots of how they were
+// spread every day. It reads what the sds011 command and the csv and
+// jsonl sinks of sds011d write.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/chart"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+var (
+	kind       = flag.String("kind", "timeseries", `what to draw: "timeseries" or "boxplot" (one box a day)`)
+	output     = flag.String("output", "-", "file to write the chart to; - for standard output")
+	format     = flag.String("format", "", `"png" or "svg"; by default, the extension of -output, or png`)
+	width      = flag.Int("width", 800, "width of the chart, in pixels")
+	height     = flag.Int("height", 300, "height of the chart, in pixels")
+	title      = flag.String("title", "", "title of the chart; by default, the sensor and the dates")
+	pollutants = flag.String("pollutants", "pm25,pm10", "comma separated list of what to draw: pm25, pm10, pm1")
+	resolution = flag.Duration("resolution", 0, "what time series are averaged over; by default, enough for a point every few pixels")
+	location   = flag.String("location", "Local", "time zone of the time labels and the days")
+	sensor     = flag.String("sensor", "", "name for readings without one")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011plot draws charts of recorded readings.
+
+It reads the CSV of the sds011 command, and the CSV and JSON lines of
+the sinks of sds011d, from the files given as arguments (or standard
+input), and draws either the PM levels over time, or box plots of
+their spread every day: boxes from the first to the third quartile,
+with a line at the median and whiskers to the minimum and maximum.
+Readings of several sensors are drawn side by side.
+
+Usage: sds011plot [flags] [file...]
+`)
+		flag.PrintDefaults()
+	}
+}
+
+// A pollutant is what can be drawn of a reading.
+type pollutant struct {
+	name  string
+	color color.RGBA
+	level func(p *sds011.Point) (float64, bool)
+}
+
+var allPollutants = map[string]pollutant{
+	"pm25": {"PM2.5", chart.PM25Color, func(p *sds011.Point) (float64, bool) { return p.PM25, true }},
+	"pm10": {"PM10", chart.PM10Color, func(p *sds011.Point) (float64, bool) { return p.PM10, true }},
+	"pm1":  {"PM1.0", chart.PM1Color, func(p *sds011.Point) (float64, bool) { return p.PM1, p.HasPM1 }},
+}
+
+// palette are the colors of the series when there is more than one
+// sensor.
+var palette = []color.RGBA{
+	chart.PM25Color, chart.PM10Color, chart.PM1Color,
+	{0x8E, 0x44, 0xAD, 0xFF}, {0xC2, 0x9D, 0x0B, 0xFF}, {0x16, 0xA0, 0x85, 0xFF},
+	{0x7F, 0x8C, 0x8D, 0xFF}, {0xE8, 0x43, 0x93, 0xFF},
+}
+
+// resolutions are what time series are averaged over by default: the
+// shortest that gives no more than a point every few pixels.
+var resolutions = []time.Duration{
+	time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour,
+}
+
+// A line is what is drawn of a pollutant of a sensor.
+type line struct {
+	name   string
+	color  color.RGBA
+	points []chart.Point
+}
+
+// lines returns the lines of the readings, one per sensor and
+// pollutant.
+func lines(readings []*sink.Reading, selected []pollutant) []*line {
+	var names []string
+	points := make(map[string][]*sds011.Point)
+	for _, r := range readings {
+		if _, ok := points[r.Sensor]; !ok {
+			names = append(names, r.Sensor)
+		}
+		points[r.Sensor] = append(points[r.Sensor], r.Point)
+	}
+	var ls []*line
+	for _, name := range names {
+		for _, p := range selected {
+			l := &line{name: p.name, color: p.color}
+			if len(names) > 1 {
+				l.name = name + " " + p.name
+				l.color = palette[len(ls)%len(palette)]
+			}
+			for _, point := range points[name] {
+				if v, ok := p.level(point); ok {
+					l.points = append(l.points, chart.Point{Time: point.Timestamp, Value: v})
+				}
+			}
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+// average returns the means of points over consecutive periods of
+// length res, stamped with their starts.
+func average(points []chart.Point, res time.Duration) []chart.Point {
+	var means []chart.Point
+	var sum float64
+	var n int
+	for i, p := range points {
+		start := p.Time.Truncate(res)
+		sum, n = sum+p.Value, n+1
+		if i+1 == len(points) || !points[i+1].Time.Truncate(res).Equal(start) {
+			means = append(means, chart.Point{Time: start, Value: sum / float64(n)})
+			sum, n = 0, 0
+		}
+	}
+	return means
+}
+
+// medianInterval returns the median time between points.
+func medianInterval(points []chart.Point) time.Duration {
+	if len(points) < 2 {
+		return 0
+	}
+	intervals := make([]time.Duration, len(points)-1)
+	for i := range intervals {
+		intervals[i] = points[i+1].Time.Sub(points[i].Time)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals[len(intervals)/2]
+}
+
+func timeSeries(ls []*line, from, to time.Time, loc *time.Location) *chart.TimeSeries {
+	res := *resolution
+	if res == 0 {
+		res = resolutions[len(resolutions)-1]
+		for _, r := range resolutions {
+			if to.Sub(from)/r <= time.Duration(*width/3) {
+				res = r
+				break
+			}
+		}
+	}
+	c := &chart.TimeSeries{Unit: "µg/m³", Location: loc}
+	for _, l := range ls {
+		s := chart.Series{Name: l.name, Color: l.color, Points: average(l.points, res)}
+		if gap := 3 * medianInterval(s.Points); gap > c.MaxGap {
+			c.MaxGap = gap
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c
+}
+
+func boxPlot(ls []*line, from, to time.Time, loc *time.Location) *chart.BoxPlot {
+	layout := "01-02"
+	if from.In(loc).Year() != to.In(loc).Year() {
+		layout = "2006-01-02"
+	}
+	first := from.In(loc)
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	var days []time.Time
+	for d := first; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	c := &chart.BoxPlot{Unit: "µg/m³"}
+	for _, d := range days {
+		c.Groups = append(c.Groups, d.Format(layout))
+	}
+	for _, l := range ls {
+		s := chart.BoxSeries{Name: l.name, Color: l.color, Values: make([][]float64, len(days))}
+		for _, p := range l.points {
+			// Days are not all 24 hours long, so this counts them.
+			i := sort.Search(len(days), func(i int) bool { return days[i].After(p.Time) }) - 1
+			s.Values[i] = append(s.Values[i], p.Value)
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c
+}
+
+// defaultTitle returns the title of a chart of readings from the
+// sensors between from and to.
+func defaultTitle(sensors map[string]bool, from, to time.Time, loc *time.Location) string {
+	var names []string
+	for name := range sensors {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	dates := from.In(loc).Format("2006-01-02")
+	if last := to.In(loc).Format("2006-01-02"); last != dates {
+		dates += " to " + last
+	}
+	if len(names) == 0 {
+		return dates
+	}
+	return strings.Join(names, ", ") + ", " + dates
+}
+
+func main() {
+	flag.Parse()
+	loc, err := time.LoadLocation(*location)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := *format
+	if f == "" {
+		f = strings.TrimPrefix(strings.ToLower(filepath.Ext(*output)), ".")
+		if f != "svg" {
+			f = "png"
+		}
+	}
+	if f != "png" && f != "svg" {
+		log.Fatalf("unknown format %q", f)
+	}
+	var selected []pollutant
+	for _, name := range strings.Split(*pollutants, ",") {
+		p, ok := allPollutants[strings.TrimSpace(name)]
+		if !ok {
+			log.Fatalf("unknown pollutant %q", name)
+		}
+		selected = append(selected, p)
+	}
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	readings, err := sink.ReadFiles(*sensor, paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(readings) == 0 {
+		log.Fatal("no readings")
+	}
+	from, to := readings[0].Timestamp, readings[len(readings)-1].Timestamp
+	sensors := make(map[string]bool)
+	for _, r := range readings {
+		sensors[r.Sensor] = true
+	}
+	t := *title
+	if t == "" {
+		t = defaultTitle(sensors, from, to, loc)
+	}
+
+	var c interface {
+		PNG(w io.Writer, width, height int) error
+		SVG(w io.Writer, width, height int) error
+	}
+	switch *kind {
+	case "timeseries":
+		ts := timeSeries(lines(readings, selected), from, to, loc)
+		ts.Title = t
+		c = ts
+	case "boxplot":
+		bp := boxPlot(lines(readings, selected), from, to, loc)
+		bp.Title = t
+		c = bp
+	default:
+		log.Fatalf("unknown kind %q", *kind)
+	}
+	var buf bytes.Buffer
+	if f == "svg" {
+		err = c.SVG(&buf, *width, *height)
+	} else {
+		err = c.PNG(&buf, *width, *height)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = os.WriteFile(*output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
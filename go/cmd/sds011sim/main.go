@@ -0,0 +1,115 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sds011sim emulates an SDS011 sensor on a pseudo-terminal, so that
+// the commands and the daemon can be tried out and tested without the
+// hardware:
+//
+//	$ sds011sim -link /tmp/ttySDS011 -pm25 12 -noise 0.1 &
+//	$ sds011 -port_path /tmp/ttySDS011
+//
+// It implements the report mode, query, device ID, sleep and work,
+// firmware and working period commands, and can inject faults:
+// dropped frames, bad checksums and garbage on the line. With -pm1, it
+// emulates the clones that also measure PM1.0.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+var (
+	link     = flag.String("link", "", "if set, a symlink to the pseudo-terminal to create")
+	deviceID = flag.String("device_id", "a160", "device ID, as 4 hex digits")
+	firmware = flag.String("firmware", "18-11-16", "firmware version, as yy-mm-dd")
+	query    = flag.Bool("query", false, "start in query mode, instead of active mode")
+	pm1      = flag.Float64("pm1", 0, "mean PM1.0 level, in µg/m³; if set, extended measurements are sent, like some clones do")
+	pm25     = flag.Float64("pm25", 10, "mean PM2.5 level, in µg/m³")
+	pm10     = flag.Float64("pm10", 20, "mean PM10 level, in µg/m³")
+	noise    = flag.Float64("noise", 0.1, "standard deviation of the levels, relative to the mean")
+	minute   = flag.Duration("minute", time.Minute, "length of a minute of the working period")
+	drop     = flag.Float64("drop", 0, "probability of a frame being dropped")
+	corrupt  = flag.Float64("corrupt", 0, "probability of a frame having a bad checksum")
+	garbage  = flag.Float64("garbage", 0, "probability of garbage being sent before a frame")
+	seed     = flag.Int64("seed", 0, "random seed; 0 means the current time")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr,
+			`sds011sim emulates an SDS011 sensor on a pseudo-terminal, and prints its path.`)
+		fmt.Fprintf(os.Stderr, "\n\nUsage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	s := &simulator{
+		pm1:    *pm1,
+		pm25:   *pm25,
+		pm10:   *pm10,
+		noise:  *noise,
+		minute: *minute,
+		faults: faults{Drop: *drop, Corrupt: *corrupt, Garbage: *garbage},
+		active: !*query,
+		awake:  true,
+	}
+	id, err := hex.DecodeString(*deviceID)
+	if err != nil || len(id) != 2 {
+		log.Exitf("bad device ID %q", *deviceID)
+	}
+	copy(s.id[:], id)
+	var yy, mm, dd byte
+	if _, err := fmt.Sscanf(*firmware, "%d-%d-%d", &yy, &mm, &dd); err != nil {
+		log.Exitf("bad firmware %q: %v", *firmware, err)
+	}
+	s.firmware = [3]byte{yy, mm, dd}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	s.rand = rand.New(rand.NewSource(*seed))
+
+	master, slave, err := openPTY()
+	if err != nil {
+		log.Exit(err)
+	}
+	defer slave.Close()
+	s.rw = master
+	fmt.Println(slave.Name())
+	if *link != "" {
+		os.Remove(*link)
+		if err := os.Symlink(slave.Name(), *link); err != nil {
+			log.Exit(err)
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			os.Remove(*link)
+			os.Exit(0)
+		}()
+	}
+	s.run()
+}
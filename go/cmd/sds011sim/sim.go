@@ -0,0 +1,229 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// faults are the probabilities of things going wrong with a frame the
+// sensor sends.
+type faults struct {
+	// Drop is the probability of the frame not being sent at all.
+	Drop float64
+	// Corrupt is the probability of the frame having a bad checksum.
+	Corrupt float64
+	// Garbage is the probability of a few random bytes being sent
+	// before the frame.
+	Garbage float64
+}
+
+// simulator emulates an SDS011 talking over rw.
+type simulator struct {
+	rw   io.ReadWriter
+	rand *rand.Rand
+
+	// pm25 and pm10 are the mean levels the sensor measures, and
+	// noise the standard deviation of the measurements, relative to
+	// the mean. If pm1 isn't zero, the sensor is a clone that sends
+	// extended measurements, with PM1.0 levels.
+	pm1, pm25, pm10, noise float64
+	faults                 faults
+	// minute is how long a minute of the working period is, so that
+	// cycles can be tested without waiting for them.
+	minute time.Duration
+
+	id       [2]byte
+	firmware [3]byte
+	active   bool
+	awake    bool
+	cycle    byte
+}
+
+// readRequests sends the valid requests read from r to requests, and
+// closes it when r is done.
+func readRequests(r io.Reader, requests chan<- wire.Request) {
+	defer close(requests)
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("reading: %v", err)
+			}
+			return
+		}
+		if b != wire.Header {
+			continue
+		}
+		if next, err := br.Peek(1); err != nil || next[0] != wire.RequestMarker {
+			continue
+		}
+		var frame [wire.RequestSize]byte
+		frame[0] = b
+		if _, err := io.ReadFull(br, frame[1:]); err != nil {
+			return
+		}
+		var req wire.Request
+		if err := wire.DecodeRequest(frame[:], &req); err != nil {
+			log.Warningf("ignoring malformed request % x: %v", frame, err)
+			continue
+		}
+		requests <- req
+	}
+}
+
+// run answers requests and reports measurements until rw is closed.
+func (s *simulator) run() {
+	requests := make(chan wire.Request)
+	go readRequests(s.rw, requests)
+	next := time.Now().Add(s.period())
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case req, ok := <-requests:
+			timer.Stop()
+			if !ok {
+				return
+			}
+			cycle := s.cycle
+			s.handle(&req)
+			if s.cycle != cycle {
+				next = time.Now().Add(s.period())
+			}
+		case <-timer.C:
+			if s.active && s.awake {
+				s.send(s.measurement())
+			}
+			next = next.Add(s.period())
+		}
+	}
+}
+
+// period is how often the sensor reports in active mode.
+func (s *simulator) period() time.Duration {
+	if s.cycle == 0 {
+		return time.Second
+	}
+	return time.Duration(s.cycle) * s.minute
+}
+
+// handle answers a request.
+func (s *simulator) handle(req *wire.Request) {
+	cmd, set, value := req.Command, req.Mode == wire.Set, req.Data[0]
+	if req.DeviceID != wire.Broadcast && req.DeviceID != s.id {
+		log.V(1).Infof("ignoring request for device %x", req.DeviceID)
+		return
+	}
+	// A sleeping sensor only listens to being woken up.
+	if !s.awake && cmd != wire.WorkState {
+		log.V(1).Infof("asleep, ignoring command %d", cmd)
+		return
+	}
+	log.V(1).Infof("command %d set %v value %d", cmd, set, value)
+	switch cmd {
+	case wire.ReportMode:
+		if set {
+			s.active = value == 0
+		}
+		s.reply(cmd, boolByte(set), boolByte(!s.active), 0)
+	case wire.Query:
+		s.send(s.measurement())
+	case wire.DeviceID:
+		// Asking for the new ID 0000 is treated as a query, which is
+		// how sds011.Sensor.DeviceID asks.
+		if newID := [2]byte{req.Data[9], req.Data[10]}; newID != [2]byte{} {
+			s.id = newID
+		}
+		s.reply(cmd, 0, 0, 0)
+	case wire.WorkState:
+		if set {
+			s.awake = value == 1
+		}
+		s.reply(cmd, boolByte(set), boolByte(s.awake), 0)
+	case wire.Firmware:
+		s.reply(cmd, s.firmware[0], s.firmware[1], s.firmware[2])
+	case wire.Cycle:
+		if set && value <= 30 {
+			s.cycle = value
+		}
+		s.reply(cmd, boolByte(set), s.cycle, 0)
+	default:
+		log.Warningf("unknown command %d", cmd)
+	}
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// reply sends a reply to a command.
+func (s *simulator) reply(cmd wire.Command, a, b, c byte) {
+	s.send(frame(wire.NewReply(cmd, a, b, c, s.id)))
+}
+
+// measurement returns a measurement frame.
+func (s *simulator) measurement() []byte {
+	resp := wire.NewMeasurement(0, 0, s.id)
+	binary.LittleEndian.PutUint16(resp.Data[0:2], s.level(s.pm25))
+	binary.LittleEndian.PutUint16(resp.Data[2:4], s.level(s.pm10))
+	if s.pm1 > 0 {
+		resp.Kind = wire.ExtendedMeasurement
+		binary.LittleEndian.PutUint16(resp.Data[6:8], s.level(s.pm1))
+	}
+	return frame(resp)
+}
+
+// level returns a noisy measurement of mean, in tenths of µg/m³.
+func (s *simulator) level(mean float64) uint16 {
+	v := mean * (1 + s.noise*s.rand.NormFloat64())
+	return uint16(math.Round(math.Max(0, math.Min(999.9, v)) * 10))
+}
+
+func frame(resp wire.Response) []byte {
+	return resp.Append(nil)
+}
+
+// send writes a frame, injecting faults.
+func (s *simulator) send(b []byte) {
+	if s.rand.Float64() < s.faults.Drop {
+		log.V(1).Infof("fault: dropping % x", b)
+		return
+	}
+	if s.rand.Float64() < s.faults.Corrupt {
+		log.V(1).Infof("fault: corrupting % x", b)
+		b[len(b)-2]++
+	}
+	if s.rand.Float64() < s.faults.Garbage {
+		garbage := make([]byte, 1+s.rand.Intn(5))
+		s.rand.Read(garbage)
+		log.V(1).Infof("fault: garbage % x", garbage)
+		b = append(garbage, b...)
+	}
+	if _, err := s.rw.Write(b); err != nil {
+		log.Errorf("writing: %v", err)
+	}
+}
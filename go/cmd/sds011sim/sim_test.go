@@ -0,0 +1,194 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// newSimulator returns a noiseless simulator in query mode, with the
+// defaults of the flags.
+func newSimulator() *simulator {
+	return &simulator{
+		rand:     rand.New(rand.NewSource(1)),
+		pm25:     10,
+		pm10:     20,
+		minute:   time.Minute,
+		id:       [2]byte{0xA1, 0x60},
+		firmware: [3]byte{18, 11, 16},
+		awake:    true,
+	}
+}
+
+// connect runs s over a pipe, and returns a sensor talking to it.
+func connect(t *testing.T, s *simulator) *sds011.Sensor {
+	t.Helper()
+	port, rw := net.Pipe()
+	s.rw = rw
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+	sensor := sds011.NewSensor(port)
+	sensor.SetReadTimeout(5 * time.Second)
+	t.Cleanup(func() {
+		sensor.Close()
+		<-done
+	})
+	return sensor
+}
+
+func TestSimulator(t *testing.T) {
+	s := newSimulator()
+	// A level a hair under a tenth is sent rounded, not truncated.
+	a := 0.7
+	s.pm25, s.pm10, s.pm1 = a+0.6, 45.6, 3
+	sensor := connect(t, s)
+
+	if fw, err := sensor.Firmware(); err != nil || fw != "18-11-16" {
+		t.Errorf("Firmware: %q, %v; want 18-11-16", fw, err)
+	}
+	if id, err := sensor.DeviceID(); err != nil || id != "a160" {
+		t.Errorf("DeviceID: %q, %v; want a160", id, err)
+	}
+	if mode, err := sensor.ReportMode(); err != nil || mode != sds011.QueryMode {
+		t.Errorf("ReportMode: %v, %v; want query", mode, err)
+	}
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PM25 != 1.3 || p.PM10 != 45.6 || !p.HasPM1 || p.PM1 != 3 {
+		t.Errorf("Query: %v, want PM1.0 3, PM2.5 1.3 and PM10 45.6", p)
+	}
+
+	if err := sensor.SetCycle(5); err != nil {
+		t.Error(err)
+	}
+	if cycle, err := sensor.Cycle(); err != nil || cycle != 5 {
+		t.Errorf("Cycle: %v, %v; want 5", cycle, err)
+	}
+	if err := sensor.SetDeviceID("beef"); err != nil {
+		t.Error(err)
+	}
+	if id, err := sensor.DeviceID(); err != nil || id != "beef" {
+		t.Errorf("DeviceID: %q, %v; want beef", id, err)
+	}
+	if err := sensor.Sleep(); err != nil {
+		t.Error(err)
+	}
+	if awake, err := sensor.IsAwake(); err != nil || awake {
+		t.Errorf("IsAwake: %v, %v; want asleep", awake, err)
+	}
+	if err := sensor.Awake(); err != nil {
+		t.Error(err)
+	}
+	if awake, err := sensor.IsAwake(); err != nil || !awake {
+		t.Errorf("IsAwake: %v, %v; want awake", awake, err)
+	}
+}
+
+func TestSimulatorActive(t *testing.T) {
+	s := newSimulator()
+	s.active, s.cycle, s.minute = true, 1, 10*time.Millisecond
+	sensor := connect(t, s)
+	for i := 0; i < 3; i++ {
+		p, err := sensor.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.PM25 != 10 || p.PM10 != 20 || p.HasPM1 {
+			t.Errorf("Get: %v, want PM2.5 10 and PM10 20", p)
+		}
+	}
+}
+
+func TestSimulatorIgnores(t *testing.T) {
+	s := newSimulator()
+	var out bytes.Buffer
+	s.rw = &out
+	other := wire.NewRequest(wire.Firmware, wire.Get, 0)
+	other.DeviceID = [2]byte{0xBE, 0xEF}
+	s.handle(&other)
+	s.awake = false
+	query := wire.NewRequest(wire.Query, wire.Get, 0)
+	s.handle(&query)
+	badCycle := wire.NewRequest(wire.Cycle, wire.Set, 31)
+	s.awake = true
+	s.handle(&badCycle)
+	var r wire.Response
+	if err := wire.DecodeResponse(out.Bytes(), &r); err != nil || r.ReplyTo() != wire.Cycle || r.Data[2] != 0 {
+		t.Errorf("sent % x, want only a reply keeping the cycle at 0", out.Bytes())
+	}
+}
+
+func TestSimulatorFaults(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		faults faults
+		check  func(b []byte) bool
+	}{
+		{"none", faults{}, func(b []byte) bool {
+			var r wire.Response
+			return wire.DecodeResponse(b, &r) == nil
+		}},
+		{"drop", faults{Drop: 1}, func(b []byte) bool { return len(b) == 0 }},
+		{"corrupt", faults{Corrupt: 1}, func(b []byte) bool {
+			var r wire.Response
+			return wire.DecodeResponse(b, &r) == wire.ErrChecksum
+		}},
+		{"garbage", faults{Garbage: 1}, func(b []byte) bool {
+			n := len(b) - wire.ResponseSize
+			var r wire.Response
+			return n >= 1 && n <= 5 && wire.DecodeResponse(b[n:], &r) == nil
+		}},
+	} {
+		s := newSimulator()
+		s.faults = tc.faults
+		var out bytes.Buffer
+		s.rw = &out
+		s.send(s.measurement())
+		if !tc.check(out.Bytes()) {
+			t.Errorf("%v: sent % x", tc.name, out.Bytes())
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	s := newSimulator()
+	a := 0.7
+	for _, tc := range []struct {
+		mean float64
+		want uint16
+	}{
+		{0, 0},
+		{12.3, 123},
+		{a + 0.6, 13},
+		{-5, 0},
+		{2000, 9999},
+	} {
+		if got := s.level(tc.mean); got != tc.want {
+			t.Errorf("level(%v): %v, want %v", tc.mean, got, tc.want)
+		}
+	}
+}
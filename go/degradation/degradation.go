@@ -0,0 +1,240 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package degradation detects the ways SDS011 sensors typically fail
+// from their readings:
+//
+//   - Stuck: the same levels over and over, as when the firmware or the
+//     laser hangs.
+//   - Zeros: nothing but zeros, as when the fan has stopped and no air
+//     goes through the sensor.
+//   - Drift: the lowest levels of the day rising from day to day, as
+//     when the optics get fouled with dust.
+//
+// A Detector looks at the readings of any number of sensors:
+//
+//	d := degradation.NewDetector()
+//	for {
+//		point, err := sensor.Get()
+//		...
+//		d.Add("balcony", *point)
+//		for _, f := range d.Findings("balcony") {
+//			log.Printf("balcony: %v", &f)
+//		}
+//	}
+//
+// The heuristics are just that: a sensor in a clean room can read
+// zeros, and a baseline can rise because the air got worse. Findings
+// are reasons to have a look at the sensor, not proof it's broken.
+package degradation
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+// A Problem is a failure mode.
+type Problem string
+
+const (
+	Stuck Problem = "stuck"
+	Zeros Problem = "zeros"
+	Drift Problem = "drift"
+)
+
+// Problems are all the problems a Detector looks for.
+var Problems = []Problem{Stuck, Zeros, Drift}
+
+// A Finding is a problem a sensor seems to have.
+type Finding struct {
+	Problem Problem `json:"problem"`
+	// Since is when the problem started showing.
+	Since time.Time `json:"since"`
+	// Detail describes what was seen.
+	Detail string `json:"detail"`
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s since %v: %s", f.Problem, f.Since.Format(time.RFC3339), f.Detail)
+}
+
+// A Detector looks for problems in the readings of sensors. The zero
+// value isn't usable; use NewDetector. It isn't safe for concurrent
+// use.
+type Detector struct {
+	// StuckReadings and StuckFor are how many identical readings in a
+	// row, for how long, make a sensor stuck.
+	StuckReadings int
+	StuckFor      time.Duration
+	// ZeroReadings and ZeroFor are how many readings of zero in a
+	// row, for how long, make a sensor read zeros.
+	ZeroReadings int
+	ZeroFor      time.Duration
+	// BaselineDays is how many days of baselines, the lowest hourly
+	// mean PM2.5 of the day, are kept, and MinBaselineDays how many
+	// are needed to tell whether they're drifting.
+	BaselineDays    int
+	MinBaselineDays int
+	// MaxBaselineRise is how fast, in µg/m³ a day, the baseline can
+	// rise before it's considered drift.
+	MaxBaselineRise float64
+
+	sensors map[string]*sensor
+}
+
+// NewDetector returns a detector with the default settings.
+func NewDetector() *Detector {
+	return &Detector{
+		StuckReadings:   30,
+		StuckFor:        15 * time.Minute,
+		ZeroReadings:    10,
+		ZeroFor:         10 * time.Minute,
+		BaselineDays:    30,
+		MinBaselineDays: 14,
+		MaxBaselineRise: 0.3,
+		sensors:         make(map[string]*sensor),
+	}
+}
+
+// baseline is the baseline of a day.
+type baseline struct {
+	day   time.Time
+	level float64
+}
+
+// sensor is what a detector knows about a sensor.
+type sensor struct {
+	last sds011.Point
+	// same is how many readings in a row were the same as last,
+	// since sameSince.
+	same      int
+	sameSince time.Time
+	// zeros is how many readings in a row were zeros, since
+	// zerosSince.
+	zeros      int
+	zerosSince time.Time
+
+	// hour is the start of the hour being collected, and hourSum and
+	// hourCount the sum and number of its PM2.5 readings.
+	hour      time.Time
+	hourSum   float64
+	hourCount int
+	// day is the start of the day being collected, dayMin the lowest
+	// hourly mean of it so far, and dayHours its number of hours.
+	day       time.Time
+	dayMin    float64
+	dayHours  int
+	baselines []baseline
+}
+
+// Add adds a reading of the named sensor. Readings must come in time
+// order.
+func (d *Detector) Add(name string, p sds011.Point) {
+	s, ok := d.sensors[name]
+	if !ok {
+		s = &sensor{dayMin: math.Inf(1)}
+		d.sensors[name] = s
+	}
+
+	switch {
+	case p.PM25 == 0 && p.PM10 == 0:
+		if s.zeros == 0 {
+			s.zerosSince = p.Timestamp
+		}
+		s.zeros++
+		s.same = 0
+	case s.same > 0 && p.PM25 == s.last.PM25 && p.PM10 == s.last.PM10:
+		s.same++
+		s.zeros = 0
+	default:
+		s.same, s.sameSince = 1, p.Timestamp
+		s.zeros = 0
+	}
+	s.last = p
+
+	if hour := p.Timestamp.Truncate(time.Hour); !hour.Equal(s.hour) {
+		d.finishHour(s, hour)
+	}
+	s.hourSum += p.PM25
+	s.hourCount++
+}
+
+// finishHour adds the hour being collected to the baseline of its
+// day, and starts collecting the hour starting at next.
+func (d *Detector) finishHour(s *sensor, next time.Time) {
+	if s.hourCount > 0 {
+		if mean := s.hourSum / float64(s.hourCount); mean < s.dayMin {
+			s.dayMin = mean
+		}
+		s.dayHours++
+	}
+	s.hour, s.hourSum, s.hourCount = next, 0, 0
+
+	day := next.Truncate(24 * time.Hour)
+	if day.Equal(s.day) {
+		return
+	}
+	// Days with few hours of readings have no meaningful minimum.
+	if s.dayHours >= 12 {
+		s.baselines = append(s.baselines, baseline{s.day, s.dayMin})
+		if len(s.baselines) > d.BaselineDays {
+			s.baselines = s.baselines[len(s.baselines)-d.BaselineDays:]
+		}
+	}
+	s.day, s.dayMin, s.dayHours = day, math.Inf(1), 0
+}
+
+// Findings returns the problems the named sensor seems to have now.
+func (d *Detector) Findings(name string) []Finding {
+	s, ok := d.sensors[name]
+	if !ok {
+		return nil
+	}
+	var findings []Finding
+	if s.same >= d.StuckReadings && s.last.Timestamp.Sub(s.sameSince) >= d.StuckFor {
+		findings = append(findings, Finding{
+			Problem: Stuck,
+			Since:   s.sameSince,
+			Detail:  fmt.Sprintf("%d readings of PM2.5 %v and PM10 %v in a row", s.same, s.last.PM25, s.last.PM10),
+		})
+	}
+	if s.zeros >= d.ZeroReadings && s.last.Timestamp.Sub(s.zerosSince) >= d.ZeroFor {
+		findings = append(findings, Finding{
+			Problem: Zeros,
+			Since:   s.zerosSince,
+			Detail:  fmt.Sprintf("%d readings of zero in a row", s.zeros),
+		})
+	}
+	if n := len(s.baselines); n >= d.MinBaselineDays {
+		days := make([]float64, n)
+		levels := make([]float64, n)
+		for i, b := range s.baselines {
+			days[i] = b.day.Sub(s.baselines[0].day).Hours() / 24
+			levels[i] = b.level
+		}
+		if slope, _, _ := stats.Fit(days, levels); slope > d.MaxBaselineRise {
+			findings = append(findings, Finding{
+				Problem: Drift,
+				Since:   s.baselines[0].day,
+				Detail: fmt.Sprintf("the daily minimum of PM2.5 rose from %.1f to %.1f µg/m³ in %d days, %.2f µg/m³ a day",
+					levels[0], levels[n-1], int(days[n-1]), slope),
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package degradation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// minutely returns n readings a minute apart from t0, with the levels
+// level returns for each.
+func minutely(n int, level func(i int) (pm25, pm10 float64)) []sds011.Point {
+	var points []sds011.Point
+	for i := 0; i < n; i++ {
+		pm25, pm10 := level(i)
+		points = append(points, sds011.Point{PM25: pm25, PM10: pm10, Timestamp: t0.Add(time.Duration(i) * time.Minute)})
+	}
+	return points
+}
+
+// daily returns hourly readings from t0 for a number of days, whose
+// PM2.5 is 10 µg/m³ over the baseline of the day, but for one hour a
+// day at the baseline.
+func daily(days int, baseline func(day int) float64) []sds011.Point {
+	var points []sds011.Point
+	for day := 0; day < days; day++ {
+		for hour := 0; hour < 24; hour++ {
+			pm25 := baseline(day) + 10
+			if hour == 3 {
+				pm25 = baseline(day)
+			}
+			points = append(points, sds011.Point{PM25: pm25, PM10: 2 * pm25, Timestamp: t0.Add(time.Duration(24*day+hour) * time.Hour)})
+		}
+	}
+	return points
+}
+
+func TestDetector(t *testing.T) {
+	same := func(int) (float64, float64) { return 12.3, 20.1 }
+	zero := func(int) (float64, float64) { return 0, 0 }
+	for _, tc := range []struct {
+		name   string
+		points []sds011.Point
+		want   []Problem
+	}{
+		{"stuck", minutely(30, same), []Problem{Stuck}},
+		{"not stuck for long enough", minutely(29, same), nil},
+		{"varying", minutely(60, func(i int) (float64, float64) { return float64(i % 7), 10 }), nil},
+		{"too few of the same", minutely(60, func(i int) (float64, float64) {
+			if i < 40 {
+				return float64(i), 10
+			}
+			return 5, 10
+		}), nil},
+		{"zeros", minutely(11, zero), []Problem{Zeros}},
+		{"zeros for too short", minutely(10, zero), nil},
+		{"only PM2.5 zero", minutely(11, func(i int) (float64, float64) { return 0, float64(i) }), nil},
+		// 15 days give 14 baselines: the last day isn't over.
+		{"drift", daily(15, func(day int) float64 { return 2 + 0.5*float64(day) }), []Problem{Drift}},
+		{"slow drift", daily(15, func(day int) float64 { return 2 + 0.1*float64(day) }), nil},
+		{"too few days", daily(14, func(day int) float64 { return 2 + 0.5*float64(day) }), nil},
+		{"falling", daily(15, func(day int) float64 { return 20 - 0.5*float64(day) }), nil},
+	} {
+		d := NewDetector()
+		for _, p := range tc.points {
+			d.Add("kitchen", p)
+		}
+		var got []Problem
+		for _, f := range d.Findings("kitchen") {
+			got = append(got, f.Problem)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFindings(t *testing.T) {
+	d := NewDetector()
+	for _, p := range minutely(20, func(i int) (float64, float64) { return float64(i / 5), 10 }) {
+		d.Add("kitchen", p)
+	}
+	for _, p := range minutely(20, func(int) (float64, float64) { return 0, 0 }) {
+		p.Timestamp = p.Timestamp.Add(20 * time.Minute)
+		d.Add("kitchen", p)
+	}
+	findings := d.Findings("kitchen")
+	if len(findings) != 1 {
+		t.Fatalf("findings: %v, want 1", findings)
+	}
+	want := Finding{Problem: Zeros, Since: t0.Add(20 * time.Minute), Detail: "20 readings of zero in a row"}
+	if findings[0] != want {
+		t.Errorf("finding: %+v, want %+v", findings[0], want)
+	}
+
+	d = NewDetector()
+	for _, p := range daily(15, func(day int) float64 { return 2 + 0.5*float64(day) }) {
+		d.Add("kitchen", p)
+	}
+	findings = d.Findings("kitchen")
+	want = Finding{Problem: Drift, Since: t0, Detail: "the daily minimum of PM2.5 rose from 2.0 to 8.5 µg/m³ in 13 days, 0.50 µg/m³ a day"}
+	if len(findings) != 1 || findings[0] != want {
+		t.Errorf("findings: %+v, want %+v", findings, want)
+	}
+
+	if findings := d.Findings("garden"); findings != nil {
+		t.Errorf("findings of an unknown sensor: %v", findings)
+	}
+}
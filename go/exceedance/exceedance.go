@@ -0,0 +1,218 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exceedance detects when the 24-hour mean PM levels exceed
+// the limits set by the WHO or the EU.
+//
+// A Detector is fed the readings of every sensor, and returns events
+// as exceedances happen: a Start when the mean goes over a limit, a
+// Peak once it has clearly turned down from its highest value, and an
+// End when it's back under the limit.
+package exceedance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/stats"
+)
+
+// A Limit is a set of 24-hour mean limits, in µg/m³. A zero limit
+// means there is none for that pollutant.
+type Limit struct {
+	Name string
+	PM25 float64
+	PM10 float64
+}
+
+var (
+	// WHO2021 are the 24-hour air quality guideline levels of the
+	// WHO Global Air Quality Guidelines of 2021.
+	WHO2021 = Limit{Name: "who2021", PM25: 15, PM10: 45}
+	// EU is the daily PM10 limit value of Directive 2008/50/EC. The
+	// directive has no daily limit for PM2.5.
+	EU = Limit{Name: "eu", PM10: 50}
+	// EU2030 are the daily limit values of Directive (EU) 2024/2881,
+	// which apply from 2030.
+	EU2030 = Limit{Name: "eu2030", PM25: 25, PM10: 45}
+)
+
+// Limits are the known limits, by name.
+var Limits = map[string]Limit{
+	WHO2021.Name: WHO2021,
+	EU.Name:      EU,
+	EU2030.Name:  EU2030,
+}
+
+// Kind is the kind of an event.
+type Kind string
+
+const (
+	Start Kind = "start"
+	Peak  Kind = "peak"
+	End   Kind = "end"
+)
+
+// An Event is a change in an exceedance.
+type Event struct {
+	Kind   Kind   `json:"kind"`
+	Sensor string `json:"sensor"`
+	// Limit is the name of the limit, and Pollutant "pm25" or
+	// "pm10".
+	Limit     string  `json:"limit"`
+	Pollutant string  `json:"pollutant"`
+	Threshold float64 `json:"threshold"`
+	// Mean is the 24-hour mean at Time. For a Peak, that's the
+	// highest mean of the exceedance so far.
+	Mean float64   `json:"mean"`
+	Time time.Time `json:"time"`
+	// Started is when the exceedance started.
+	Started time.Time `json:"started"`
+}
+
+func (e *Event) String() string {
+	return fmt.Sprintf("%v: %v of the %v %v limit: 24h mean %.1f µg/m³ (limit %v) at %v",
+		e.Sensor, e.Kind, e.Limit, e.Pollutant, e.Mean, e.Threshold, e.Time.Format(time.RFC3339))
+}
+
+const (
+	// window is what the means are computed over.
+	window = 24 * time.Hour
+	// peakDrop is how far the mean has to fall from its highest
+	// value for that to be the peak.
+	peakDrop = 0.05
+)
+
+// A Detector detects exceedances. It isn't safe for concurrent use.
+type Detector struct {
+	// MinCoverage is the fraction of the 24 hours the readings must
+	// stand for for the mean to count, as in the 75% data capture
+	// the EU requires. It defaults to 0.75.
+	MinCoverage float64
+	// MaxHold is the longest a reading stands for (see package
+	// stats). It defaults to 5 minutes.
+	MaxHold time.Duration
+	// Interval is how often the means are computed. It defaults to
+	// a minute.
+	Interval time.Duration
+
+	limits  []Limit
+	sensors map[string]*sensor
+}
+
+type sensor struct {
+	window *stats.Window
+	last   time.Time
+	// active are the ongoing exceedances, by limit and pollutant.
+	active map[[2]string]*exceedance
+}
+
+// exceedance is an ongoing exceedance.
+type exceedance struct {
+	// peak is a Peak event for the highest mean so far.
+	peak Event
+	// reported is whether peak has been reported.
+	reported bool
+}
+
+// NewDetector returns a detector of exceedances of limits.
+func NewDetector(limits ...Limit) *Detector {
+	return &Detector{
+		MinCoverage: 0.75,
+		MaxHold:     5 * time.Minute,
+		Interval:    time.Minute,
+		limits:      limits,
+		sensors:     make(map[string]*sensor),
+	}
+}
+
+// Add adds a reading of a sensor, and returns the events it causes, if
+// any.
+func (d *Detector) Add(name string, p sds011.Point) []Event {
+	s, ok := d.sensors[name]
+	if !ok {
+		s = &sensor{window: stats.NewWindow(window), active: make(map[[2]string]*exceedance)}
+		s.window.MaxHold = d.MaxHold
+		d.sensors[name] = s
+	}
+	s.window.Add(p)
+	if p.Timestamp.Sub(s.last) < d.Interval {
+		return nil
+	}
+	s.last = p.Timestamp
+	st := s.window.Stats(p.Timestamp, window)
+	if float64(st.Covered)/float64(window) < d.MinCoverage {
+		return nil
+	}
+	var events []Event
+	for _, l := range d.limits {
+		events = s.check(events, name, l.Name, "pm25", l.PM25, st.PM25.Mean, p.Timestamp)
+		events = s.check(events, name, l.Name, "pm10", l.PM10, st.PM10.Mean, p.Timestamp)
+	}
+	return events
+}
+
+// check compares mean to a limit, appending the events that causes to
+// events.
+func (s *sensor) check(events []Event, name, limit, pollutant string, threshold, mean float64, now time.Time) []Event {
+	if threshold == 0 {
+		return events
+	}
+	key := [2]string{limit, pollutant}
+	x, ok := s.active[key]
+	event := Event{Sensor: name, Limit: limit, Pollutant: pollutant, Threshold: threshold, Mean: mean, Time: now, Started: now}
+	switch {
+	case !ok && mean > threshold:
+		x = &exceedance{peak: event}
+		x.peak.Kind = Peak
+		s.active[key] = x
+		event.Kind = Start
+		return append(events, event)
+	case !ok:
+		return events
+	case mean <= threshold:
+		delete(s.active, key)
+		if !x.reported {
+			events = append(events, x.peak)
+		}
+		event.Kind, event.Started = End, x.peak.Started
+		return append(events, event)
+	case mean > x.peak.Mean:
+		// A new high, which is the peak once the mean falls from it.
+		x.peak.Mean, x.peak.Time, x.reported = mean, now, false
+	case !x.reported && mean < x.peak.Mean*(1-peakDrop):
+		x.reported = true
+		return append(events, x.peak)
+	}
+	return events
+}
+
+// Active returns the ongoing exceedances of a sensor, each as a Peak
+// event for its highest mean so far.
+func (d *Detector) Active(name string) []Event {
+	s, ok := d.sensors[name]
+	if !ok {
+		return nil
+	}
+	var events []Event
+	for _, l := range d.limits {
+		for _, pollutant := range []string{"pm25", "pm10"} {
+			if x, ok := s.active[[2]string{l.Name, pollutant}]; ok {
+				events = append(events, x.peak)
+			}
+		}
+	}
+	return events
+}
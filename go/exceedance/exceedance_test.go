@@ -0,0 +1,136 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exceedance
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// hourly returns a reading of PM10 for every hour from t0, with the
+// levels of the runs, in turn: each is a level and how many hours it
+// lasts.
+func hourly(runs ...[2]float64) []sds011.Point {
+	var points []sds011.Point
+	for _, r := range runs {
+		for i := 0; i < int(r[1]); i++ {
+			points = append(points, sds011.Point{PM10: r[0], Timestamp: t0.Add(time.Duration(len(points)) * time.Hour)})
+		}
+	}
+	return points
+}
+
+// events feeds points to d, and returns the events, each as the hours
+// after t0 of its Time, its kind and its mean.
+func events(d *Detector, points []sds011.Point) []string {
+	var got []string
+	for _, p := range points {
+		for _, e := range d.Add("kitchen", p) {
+			got = append(got, fmt.Sprintf("%v %v %.1f", e.Time.Sub(t0).Hours(), e.Kind, e.Mean))
+		}
+	}
+	return got
+}
+
+// newHourlyDetector returns a detector of the EU limit, measuring every
+// hour.
+func newHourlyDetector() *Detector {
+	d := NewDetector(EU)
+	d.MaxHold, d.Interval = time.Hour, time.Hour
+	return d
+}
+
+func TestDetector(t *testing.T) {
+	// Every reading stands for an hour, so the mean at an hour is
+	// that of the 23 before it, as the reading at the hour stands
+	// for no time yet.
+	for _, tc := range []struct {
+		name   string
+		points []sds011.Point
+		want   []string
+	}{
+		{
+			name:   "under the limit",
+			points: hourly([2]float64{40, 48}),
+			want:   nil,
+		},
+		{
+			name: "an episode",
+			// 12 hours of 100 µg/m³ take the mean over 50 after 4
+			// of them, up to (11*40 + 12*100) / 23 = 71.3, which
+			// falls by 5% once 2 of them are out of the window,
+			// and is under 50 again with 3 left.
+			points: hourly([2]float64{40, 30}, [2]float64{100, 12}, [2]float64{40, 30}),
+			want:   []string{"34 start 50.4", "42 peak 71.3", "62 end 47.8"},
+		},
+		{
+			name: "not enough data",
+			// Less than 18 of the 24 hours are covered.
+			points: hourly([2]float64{100, 18}),
+			want:   nil,
+		},
+		{
+			name: "ended before the peak was clear",
+			// The peak is reported with the end.
+			points: hourly([2]float64{40, 30}, [2]float64{100, 4}, [2]float64{0, 1}, [2]float64{40, 10}),
+			want:   []string{"34 start 50.4", "34 peak 50.4", "35 end 48.7"},
+		},
+	} {
+		if got := events(newHourlyDetector(), tc.points); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDetectorEvents(t *testing.T) {
+	d := newHourlyDetector()
+	var all []Event
+	for _, p := range hourly([2]float64{40, 30}, [2]float64{100, 12}, [2]float64{40, 30}) {
+		all = append(all, d.Add("kitchen", p)...)
+		if p.Timestamp.Equal(t0.Add(40 * time.Hour)) {
+			active := d.Active("kitchen")
+			if len(active) != 1 || active[0].Mean <= 50 || !active[0].Started.Equal(t0.Add(34*time.Hour)) {
+				t.Errorf("active during the episode: %v", active)
+			}
+		}
+	}
+	if len(all) != 3 {
+		t.Fatalf("events: %v, want 3", all)
+	}
+	start, peak, end := all[0], all[1], all[2]
+	for _, e := range all {
+		if e.Sensor != "kitchen" || e.Limit != "eu" || e.Pollutant != "pm10" || e.Threshold != 50 || !e.Started.Equal(start.Time) {
+			t.Errorf("%v: %+v", e.Kind, e)
+		}
+	}
+	if !peak.Time.Equal(t0.Add(42 * time.Hour)) {
+		t.Errorf("peak at %v, want when the mean was highest", peak.Time)
+	}
+	if end.Time.Before(peak.Time) {
+		t.Errorf("end at %v, before the peak", end.Time)
+	}
+	if active := d.Active("kitchen"); len(active) != 0 {
+		t.Errorf("active after the end: %v", active)
+	}
+	if active := d.Active("garden"); active != nil {
+		t.Errorf("active for an unknown sensor: %v", active)
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exporter exposes the readings and the health of SDS011
+// sensors as Prometheus metrics. Metrics collects them and serves
+// them over HTTP in the Prometheus text format, and Run keeps it fed
+// from a sensor:
+//
+//	m := exporter.NewMetrics()
+//	go exporter.Run(ctx, sensor, "living_room", m, 10*time.Minute)
+//	http.Handle("/metrics", m)
+//
+// The exported metrics, all labeled with the sensor name, are:
+//
+//	sds011_pm25_micrograms_per_cubic_meter   latest PM2.5 reading
+//	sds011_pm10_micrograms_per_cubic_meter   latest PM10 reading
+//	sds011_info{device_id,firmware}          always 1
+//	sds011_cycle_minutes                     working period, 0 is continuous
+//	sds011_awake                             1 if awake, 0 if sleeping
+//	sds011_reads_total                       successful reads
+//	sds011_read_errors_total                 failed reads
+//	sds011_last_success_timestamp_seconds    time of the last successful read
+//
+// If they are set with SetAverage, there are also rolling averages,
+// labeled with the window they are taken over:
+//
+//	sds011_pm25_average_micrograms_per_cubic_meter{window}
+//	sds011_pm10_average_micrograms_per_cubic_meter{window}
+//
+// and if they are set with SetProblems, the signs of failure (see
+// package degradation), labeled with the problem:
+//
+//	sds011_problem{problem}                  1 if the sensor seems to
+//	                                         have the problem
+//
+// Every metric of a sensor also has the labels set with SetLabels,
+// like location or floor.
+//
+// The daemon also reports when it started, in
+// sds011_start_time_seconds, and on the spools of its sinks, labeled
+// with the sink name:
+//
+//	sds011_spool_depth                       readings waiting to be sent
+//	sds011_spool_dropped_total               readings dropped from a full
+//	                                         spool
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// state is what is known about a single sensor.
+type state struct {
+	pm25, pm10  float64
+	hasReading  bool
+	deviceID    string
+	firmware    string
+	hasInfo     bool
+	cycle       uint8
+	hasCycle    bool
+	awake       bool
+	hasAwake    bool
+	reads       uint64
+	errors      uint64
+	lastSuccess time.Time
+	// averages are the averages of the readings, by window.
+	averages map[string][2]float64
+	// problems are whether the sensor has each problem.
+	problems map[string]bool
+	// labels are the extra labels of the sensor, ready to go after
+	// its name, like `,location="kitchen"`.
+	labels string
+}
+
+// spoolState is what is known about the spool of a sink.
+type spoolState struct {
+	depth   int
+	dropped uint64
+}
+
+// Metrics holds the metrics of a set of sensors. It is safe for
+// concurrent use.
+type Metrics struct {
+	mu      sync.Mutex
+	sensors map[string]*state
+	spools  map[string]*spoolState
+	start   time.Time
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{sensors: make(map[string]*state), spools: make(map[string]*spoolState)}
+}
+
+func (m *Metrics) spool(sink string) *spoolState {
+	s, ok := m.spools[sink]
+	if !ok {
+		s = new(spoolState)
+		m.spools[sink] = s
+	}
+	return s
+}
+
+// SetSpoolDepth records the number of readings waiting in the spool
+// of a sink.
+func (m *Metrics) SetSpoolDepth(sink string, depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spool(sink).depth = depth
+}
+
+// ObserveSpoolDrop records a reading dropped because the spool of a
+// sink was full.
+func (m *Metrics) ObserveSpoolDrop(sink string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spool(sink).dropped++
+}
+
+// SpoolDepths returns the number of readings waiting in the spool of
+// every sink that has one.
+func (m *Metrics) SpoolDepths() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	depths := make(map[string]int, len(m.spools))
+	for sink, s := range m.spools {
+		depths[sink] = s.depth
+	}
+	return depths
+}
+
+// SetStartTime records when the process reading the sensors started.
+func (m *Metrics) SetStartTime(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.start = t
+}
+
+// Health is what the metrics know about the health of a sensor.
+type Health struct {
+	Reads, Errors uint64
+	// LastSuccess is zero if there was no successful read yet.
+	LastSuccess time.Time
+}
+
+// Health returns the health of sensor.
+func (m *Metrics) Health(sensor string) Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(sensor)
+	return Health{Reads: s.reads, Errors: s.errors, LastSuccess: s.lastSuccess}
+}
+
+// get returns the state of sensor, creating it if necessary. It must
+// be called with the lock held.
+func (m *Metrics) get(sensor string) *state {
+	s, ok := m.sensors[sensor]
+	if !ok {
+		s = new(state)
+		m.sensors[sensor] = s
+	}
+	return s
+}
+
+// Observe records a successful reading.
+func (m *Metrics) Observe(sensor string, point *sds011.Point) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(sensor)
+	s.pm25, s.pm10, s.hasReading = point.PM25, point.PM10, true
+	s.reads++
+	s.lastSuccess = point.Timestamp
+}
+
+// SetAverage records the average PM2.5 and PM10 levels over the
+// window, which is a duration like "1m" or "1h".
+func (m *Metrics) SetAverage(sensor, window string, pm25, pm10 float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(sensor)
+	if s.averages == nil {
+		s.averages = make(map[string][2]float64)
+	}
+	s.averages[window] = [2]float64{pm25, pm10}
+}
+
+// SetProblems records whether the sensor seems to have each of the
+// problems, like "stuck" (see package degradation).
+func (m *Metrics) SetProblems(sensor string, problems map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(sensor).problems = problems
+}
+
+// reserved are the label names the metrics use themselves.
+var reserved = map[string]bool{"sensor": true, "window": true, "problem": true, "device_id": true, "firmware": true}
+
+// SetLabels sets extra labels to add to every metric of the sensor,
+// replacing the ones set before. Characters that can't be in label
+// names are replaced with underscores, and labels whose names the
+// metrics use themselves, or another label got first, are dropped.
+func (m *Metrics) SetLabels(sensor string, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	seen := make(map[string]bool)
+	for _, name := range names {
+		label := labelName(name)
+		if reserved[label] || seen[label] {
+			log.Warningf("%v: ignoring label %q, which is already used", sensor, name)
+			continue
+		}
+		seen[label] = true
+		fmt.Fprintf(&b, ",%s=%s", label, quote(labels[name]))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(sensor).labels = b.String()
+}
+
+// labelName returns name with the characters that can't be in a label
+// name replaced with underscores.
+func labelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		letter := c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// ObserveError records a failed reading.
+func (m *Metrics) ObserveError(sensor string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(sensor).errors++
+}
+
+// SetInfo records the identity of the sensor.
+func (m *Metrics) SetInfo(sensor, deviceID, firmware string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(sensor)
+	s.deviceID, s.firmware, s.hasInfo = deviceID, firmware, true
+}
+
+// SetCycle records the working period of the sensor.
+func (m *Metrics) SetCycle(sensor string, minutes uint8) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(sensor)
+	s.cycle, s.hasCycle = minutes, true
+}
+
+// SetAwake records whether the sensor is awake.
+func (m *Metrics) SetAwake(sensor string, awake bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(sensor)
+	s.awake, s.hasAwake = awake, true
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.sensors))
+	for name := range m.sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	family := func(name, typ, help string, value func(sensor string, s *state) (labels string, v float64, ok bool)) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, sensor := range names {
+			labels, v, ok := value(sensor, m.sensors[sensor])
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&buf, "%s{sensor=%s%s%s} %v\n", name, quote(sensor), m.sensors[sensor].labels, labels, v)
+		}
+	}
+	family("sds011_pm25_micrograms_per_cubic_meter", "gauge", "Latest PM2.5 reading.", func(_ string, s *state) (string, float64, bool) {
+		return "", s.pm25, s.hasReading
+	})
+	family("sds011_pm10_micrograms_per_cubic_meter", "gauge", "Latest PM10 reading.", func(_ string, s *state) (string, float64, bool) {
+		return "", s.pm10, s.hasReading
+	})
+	averages := func(name, help string, i int) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, sensor := range names {
+			s := m.sensors[sensor]
+			windows := make([]string, 0, len(s.averages))
+			for window := range s.averages {
+				windows = append(windows, window)
+			}
+			sort.Strings(windows)
+			for _, window := range windows {
+				fmt.Fprintf(&buf, "%s{sensor=%s%s,window=%s} %v\n", name, quote(sensor), s.labels, quote(window), s.averages[window][i])
+			}
+		}
+	}
+	averages("sds011_pm25_average_micrograms_per_cubic_meter", "Rolling average of the PM2.5 readings.", 0)
+	averages("sds011_pm10_average_micrograms_per_cubic_meter", "Rolling average of the PM10 readings.", 1)
+	fmt.Fprintf(&buf, "# HELP sds011_problem 1 if the sensor seems to have the problem, 0 if not.\n# TYPE sds011_problem gauge\n")
+	for _, sensor := range names {
+		s := m.sensors[sensor]
+		problems := make([]string, 0, len(s.problems))
+		for problem := range s.problems {
+			problems = append(problems, problem)
+		}
+		sort.Strings(problems)
+		for _, problem := range problems {
+			fmt.Fprintf(&buf, "sds011_problem{sensor=%s%s,problem=%s} %v\n", quote(sensor), s.labels, quote(problem), boolToFloat(s.problems[problem]))
+		}
+	}
+	family("sds011_info", "gauge", "Identity of the sensor.", func(_ string, s *state) (string, float64, bool) {
+		return fmt.Sprintf(",device_id=%s,firmware=%s", quote(s.deviceID), quote(s.firmware)), 1, s.hasInfo
+	})
+	family("sds011_cycle_minutes", "gauge", "Working period of the sensor, 0 meaning continuous operation.", func(_ string, s *state) (string, float64, bool) {
+		return "", float64(s.cycle), s.hasCycle
+	})
+	family("sds011_awake", "gauge", "1 if the sensor is awake, 0 if it's sleeping.", func(_ string, s *state) (string, float64, bool) {
+		return "", boolToFloat(s.awake), s.hasAwake
+	})
+	family("sds011_reads_total", "counter", "Successful reads.", func(_ string, s *state) (string, float64, bool) {
+		return "", float64(s.reads), true
+	})
+	family("sds011_read_errors_total", "counter", "Failed reads.", func(_ string, s *state) (string, float64, bool) {
+		return "", float64(s.errors), true
+	})
+	family("sds011_last_success_timestamp_seconds", "gauge", "Time of the last successful read.", func(_ string, s *state) (string, float64, bool) {
+		return "", float64(s.lastSuccess.UnixNano()) / 1e9, !s.lastSuccess.IsZero()
+	})
+
+	if !m.start.IsZero() {
+		fmt.Fprintf(&buf, "# HELP sds011_start_time_seconds Start time of the process.\n# TYPE sds011_start_time_seconds gauge\nsds011_start_time_seconds %v\n", float64(m.start.UnixNano())/1e9)
+	}
+	if len(m.spools) > 0 {
+		sinks := make([]string, 0, len(m.spools))
+		for sink := range m.spools {
+			sinks = append(sinks, sink)
+		}
+		sort.Strings(sinks)
+		fmt.Fprintf(&buf, "# HELP sds011_spool_depth Readings waiting in the spool of a sink.\n# TYPE sds011_spool_depth gauge\n")
+		for _, sink := range sinks {
+			fmt.Fprintf(&buf, "sds011_spool_depth{sink=%s} %v\n", quote(sink), m.spools[sink].depth)
+		}
+		fmt.Fprintf(&buf, "# HELP sds011_spool_dropped_total Readings dropped because the spool of a sink was full.\n# TYPE sds011_spool_dropped_total counter\n")
+		for _, sink := range sinks {
+			fmt.Fprintf(&buf, "sds011_spool_dropped_total{sink=%s} %v\n", quote(sink), m.spools[sink].dropped)
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns s as a quoted label value.
+func quote(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// Refresh queries sensor for its identity and settings, and records
+// them in m.
+func Refresh(sensor *sds011.Sensor, name string, m *Metrics) error {
+	deviceID, err := sensor.DeviceID()
+	if err != nil {
+		return err
+	}
+	firmware, err := sensor.Firmware()
+	if err != nil {
+		return err
+	}
+	m.SetInfo(name, deviceID, firmware)
+	cycle, err := sensor.Cycle()
+	if err != nil {
+		return err
+	}
+	m.SetCycle(name, cycle)
+	awake, err := sensor.IsAwake()
+	if err != nil {
+		return err
+	}
+	m.SetAwake(name, awake)
+	return nil
+}
+
+// Run reads sensor, which should be in active mode, recording
+// everything in m under name, until ctx is done. Every refresh it
+// also queries the sensor for its identity and settings.
+func Run(ctx context.Context, sensor *sds011.Sensor, name string, m *Metrics, refresh time.Duration) {
+	var lastRefresh time.Time
+	var point sds011.Point
+	for ctx.Err() == nil {
+		if time.Since(lastRefresh) >= refresh {
+			if err := Refresh(sensor, name, m); err != nil {
+				log.Errorf("%v: %v", name, err)
+			}
+			lastRefresh = time.Now()
+		}
+		if err := sensor.ReadPoint(&point); err != nil {
+			log.Errorf("%v: %v", name, err)
+			m.ObserveError(name, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		m.Observe(name, &point)
+	}
+}
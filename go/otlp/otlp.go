@@ -0,0 +1,451 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp sends the readings of SDS011 sensors, and spans for
+// the commands they execute, to an OpenTelemetry collector. It speaks
+// OTLP over HTTP with the JSON encoding, so it needs nothing beyond
+// the standard library:
+//
+//	e := otlp.New("http://collector:4318", "sds011d", nil)
+//	sensor.SetObserver(e.Sensor("living_room"))
+//	go e.Run(ctx, time.Minute)
+//	...
+//	e.Observe("living_room", point)
+//
+// The metrics are:
+//
+//	sds011.pm25         gauge, µg/m³
+//	sds011.pm10         gauge, µg/m³
+//	sds011.reads        counter of successful reads
+//	sds011.read_errors  counter of failed reads
+//	sds011.commands     counter of executed commands, by command and
+//	                    status
+//
+// all with a sensor attribute. Every command is also exported as a
+// span named after it.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// maxPendingSpans bounds the number of spans kept between exports.
+// If the collector is unreachable for long, the oldest spans are
+// dropped.
+const maxPendingSpans = 1024
+
+type sensorState struct {
+	pm25, pm10 float64
+	hasReading bool
+	readTime   time.Time
+	reads      int64
+	errors     int64
+	// commands counts the executed commands, by command and status.
+	commands map[[2]string]int64
+	// labels are the extra attributes of the sensor, as key, value
+	// pairs.
+	labels []string
+}
+
+// attrs returns the attributes of the sensor's data points, followed
+// by kv.
+func (s *sensorState) attrs(name string, kv ...string) []attribute {
+	a := append([]string{"sensor", name}, s.labels...)
+	return attrs(append(a, kv...)...)
+}
+
+type span struct {
+	sensor  string
+	command string
+	start   time.Time
+	end     time.Time
+	err     error
+}
+
+// Exporter collects readings and commands, and periodically exports
+// them to an OpenTelemetry collector.
+type Exporter struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	client   *http.Client
+	start    time.Time
+
+	mu      sync.Mutex
+	sensors map[string]*sensorState
+	spans   []span
+}
+
+// New returns an exporter sending data to the collector at endpoint
+// (for example "http://localhost:4318"), as the given service. The
+// headers are added to every request, which is how most hosted
+// collectors expect to be authenticated.
+func New(endpoint, service string, headers map[string]string) *Exporter {
+	return &Exporter{
+		endpoint: endpoint,
+		service:  service,
+		headers:  headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		start:    time.Now(),
+		sensors:  make(map[string]*sensorState),
+	}
+}
+
+func (e *Exporter) get(sensor string) *sensorState {
+	s, ok := e.sensors[sensor]
+	if !ok {
+		s = &sensorState{commands: make(map[[2]string]int64)}
+		e.sensors[sensor] = s
+	}
+	return s
+}
+
+// Observe records a successful reading.
+func (e *Exporter) Observe(sensor string, point *sds011.Point) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.get(sensor)
+	s.pm25, s.pm10, s.readTime, s.hasReading = point.PM25, point.PM10, point.Timestamp, true
+	s.reads++
+}
+
+// SetLabels sets extra attributes to add to every data point of the
+// sensor, replacing the ones set before.
+func (e *Exporter) SetLabels(sensor string, labels map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		if k != "sensor" {
+			kv = append(kv, k, labels[k])
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.get(sensor).labels = kv
+}
+
+// ObserveError records a failed reading.
+func (e *Exporter) ObserveError(sensor string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.get(sensor).errors++
+}
+
+// Sensor returns an observer that records the commands of the named
+// sensor, to be passed to sds011.Sensor.SetObserver.
+func (e *Exporter) Sensor(name string) sds011.Observer {
+	return sensorObserver{e, name}
+}
+
+type sensorObserver struct {
+	e    *Exporter
+	name string
+}
+
+func (o sensorObserver) ObserveCommand(command string, start time.Time, duration time.Duration, err error) {
+	o.e.mu.Lock()
+	defer o.e.mu.Unlock()
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	o.e.get(o.name).commands[[2]string{command, status}]++
+	if len(o.e.spans) == maxPendingSpans {
+		o.e.spans = o.e.spans[1:]
+	}
+	o.e.spans = append(o.e.spans, span{o.name, command, start, start.Add(duration), err})
+}
+
+// Run exports everything every interval, until ctx is done.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Export(); err != nil {
+				log.Errorf("otlp: %v", err)
+			}
+		}
+	}
+}
+
+// Export sends the current metrics and the spans collected since the
+// last export to the collector.
+func (e *Exporter) Export() error {
+	metrics, spans := e.snapshot()
+	if err := e.post("/v1/metrics", metrics); err != nil {
+		return err
+	}
+	if spans == nil {
+		return nil
+	}
+	return e.post("/v1/traces", spans)
+}
+
+func (e *Exporter) post(path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.endpoint+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %v: %v", path, resp.Status)
+	}
+	return nil
+}
+
+// The types below are the parts of the OTLP JSON encoding we need.
+// Note that 64 bit integers are encoded as strings.
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func attrs(kv ...string) []attribute {
+	var a []attribute
+	for i := 0; i < len(kv); i += 2 {
+		a = append(a, attribute{kv[i], attributeValue{kv[i+1]}})
+	}
+	return a
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type dataPoint struct {
+	Attributes        []attribute `json:"attributes"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string      `json:"timeUnixNano"`
+	AsDouble          *float64    `json:"asDouble,omitempty"`
+	AsInt             string      `json:"asInt,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+// aggregationCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const aggregationCumulative = 2
+
+type metric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Gauge *gauge `json:"gauge,omitempty"`
+	Sum   *sum   `json:"sum,omitempty"`
+}
+
+type metricsRequest struct {
+	ResourceMetrics []struct {
+		Resource     resource `json:"resource"`
+		ScopeMetrics []struct {
+			Scope   scope    `json:"scope"`
+			Metrics []metric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+const (
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+type tracesRequest struct {
+	ResourceSpans []struct {
+		Resource   resource `json:"resource"`
+		ScopeSpans []struct {
+			Scope scope      `json:"scope"`
+			Spans []spanJSON `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+const scopeName = "github.com/ryszard/sds011/go/otlp"
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// snapshot returns the export requests for the current state, and
+// forgets the pending spans. The traces request is nil if there are
+// no spans.
+func (e *Exporter) snapshot() (*metricsRequest, *tracesRequest) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := nanos(time.Now())
+	start := nanos(e.start)
+
+	names := make([]string, 0, len(e.sensors))
+	for name := range e.sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pm25 := &gauge{}
+	pm10 := &gauge{}
+	reads := &sum{AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+	readErrors := &sum{AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+	commands := &sum{AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+	for _, name := range names {
+		s := e.sensors[name]
+		if s.hasReading {
+			v25, v10 := s.pm25, s.pm10
+			pm25.DataPoints = append(pm25.DataPoints, dataPoint{Attributes: s.attrs(name), TimeUnixNano: nanos(s.readTime), AsDouble: &v25})
+			pm10.DataPoints = append(pm10.DataPoints, dataPoint{Attributes: s.attrs(name), TimeUnixNano: nanos(s.readTime), AsDouble: &v10})
+		}
+		reads.DataPoints = append(reads.DataPoints, dataPoint{Attributes: s.attrs(name), StartTimeUnixNano: start, TimeUnixNano: now, AsInt: strconv.FormatInt(s.reads, 10)})
+		readErrors.DataPoints = append(readErrors.DataPoints, dataPoint{Attributes: s.attrs(name), StartTimeUnixNano: start, TimeUnixNano: now, AsInt: strconv.FormatInt(s.errors, 10)})
+		for key, n := range s.commands {
+			commands.DataPoints = append(commands.DataPoints, dataPoint{
+				Attributes:        s.attrs(name, "command", key[0], "status", key[1]),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				AsInt:             strconv.FormatInt(n, 10),
+			})
+		}
+	}
+
+	res := resource{attrs("service.name", e.service)}
+	mr := new(metricsRequest)
+	mr.ResourceMetrics = make([]struct {
+		Resource     resource `json:"resource"`
+		ScopeMetrics []struct {
+			Scope   scope    `json:"scope"`
+			Metrics []metric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	}, 1)
+	mr.ResourceMetrics[0].Resource = res
+	mr.ResourceMetrics[0].ScopeMetrics = make([]struct {
+		Scope   scope    `json:"scope"`
+		Metrics []metric `json:"metrics"`
+	}, 1)
+	mr.ResourceMetrics[0].ScopeMetrics[0].Scope = scope{scopeName}
+	mr.ResourceMetrics[0].ScopeMetrics[0].Metrics = []metric{
+		{Name: "sds011.pm25", Unit: "ug/m3", Gauge: pm25},
+		{Name: "sds011.pm10", Unit: "ug/m3", Gauge: pm10},
+		{Name: "sds011.reads", Unit: "1", Sum: reads},
+		{Name: "sds011.read_errors", Unit: "1", Sum: readErrors},
+		{Name: "sds011.commands", Unit: "1", Sum: commands},
+	}
+
+	if len(e.spans) == 0 {
+		return mr, nil
+	}
+	spans := make([]spanJSON, 0, len(e.spans))
+	for _, s := range e.spans {
+		sj := spanJSON{
+			TraceID:           randomHex(16),
+			SpanID:            randomHex(8),
+			Name:              "sds011." + s.command,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: nanos(s.start),
+			EndTimeUnixNano:   nanos(s.end),
+			Attributes:        attrs("sensor", s.sensor),
+		}
+		sj.Status.Code = statusOK
+		if s.err != nil {
+			sj.Status.Code = statusError
+			sj.Status.Message = s.err.Error()
+		}
+		spans = append(spans, sj)
+	}
+	e.spans = nil
+
+	tr := new(tracesRequest)
+	tr.ResourceSpans = make([]struct {
+		Resource   resource `json:"resource"`
+		ScopeSpans []struct {
+			Scope scope      `json:"scope"`
+			Spans []spanJSON `json:"spans"`
+		} `json:"scopeSpans"`
+	}, 1)
+	tr.ResourceSpans[0].Resource = res
+	tr.ResourceSpans[0].ScopeSpans = make([]struct {
+		Scope scope      `json:"scope"`
+		Spans []spanJSON `json:"spans"`
+	}, 1)
+	tr.ResourceSpans[0].ScopeSpans[0].Scope = scope{scopeName}
+	tr.ResourceSpans[0].ScopeSpans[0].Spans = spans
+	return mr, tr
+}
@@ -0,0 +1,285 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parquet writes readings as Parquet files, which pandas,
+// DuckDB, Spark and friends load much faster than CSV. The files have
+// one row per reading, with the columns
+//
+//	sensor     string
+//	timestamp  timestamp (microseconds, UTC)
+//	pm25       double
+//	pm10       double
+//
+// all required, stored plain and uncompressed, in one row group. The
+// row group has the minimum and maximum of every column, so that
+// readers can skip the files they don't need.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+const magic = "PAR1"
+
+// The physical types of Parquet.
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// The converted types of Parquet.
+const (
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+)
+
+// column is a column chunk, with its values plain encoded.
+type column struct {
+	name string
+	typ  int32
+	// converted is the converted type, or -1 if there's none.
+	converted int32
+	data      bytes.Buffer
+	min, max  []byte
+}
+
+// schema writes the schema element of the column.
+func (c *column) schema(e *encoder) {
+	e.begin(0)
+	e.i32(1, c.typ)
+	e.i32(3, 0) // required
+	e.string(4, c.name)
+	if c.converted < 0 {
+		e.end()
+		return
+	}
+	e.i32(6, c.converted)
+	e.begin(10) // logical type
+	switch c.converted {
+	case convertedUTF8:
+		e.begin(1) // string
+		e.end()
+	case convertedTimestampMicros:
+		e.begin(8) // timestamp
+		e.bool(1, true)
+		e.begin(2) // unit
+		e.begin(2) // microseconds
+		e.end()
+		e.end()
+		e.end()
+	}
+	e.end()
+	e.end()
+}
+
+// pageHeader returns the header of the data page of the column.
+func (c *column) pageHeader(n int) []byte {
+	e := new(encoder)
+	e.begin(0)
+	e.i32(1, 0) // data page
+	e.i32(2, int32(c.data.Len()))
+	e.i32(3, int32(c.data.Len()))
+	e.begin(5)
+	e.i32(1, int32(n))
+	e.i32(2, 0) // plain
+	e.i32(3, 3) // RLE, for the (absent) definition levels
+	e.i32(4, 3) // and repetition levels
+	e.end()
+	e.end()
+	return e.buf.Bytes()
+}
+
+// chunk writes the column chunk metadata of the column, whose page
+// starts at offset and takes size bytes with its header.
+func (c *column) chunk(e *encoder, n int, offset, size int64) {
+	e.begin(0)
+	e.i64(2, offset)
+	e.begin(3)
+	e.i32(1, c.typ)
+	e.list(2, typeI32, 1)
+	e.zigzag(0) // plain
+	e.list(3, typeBinary, 1)
+	e.bytes([]byte(c.name))
+	e.i32(4, 0) // uncompressed
+	e.i64(5, int64(n))
+	e.i64(6, size)
+	e.i64(7, size)
+	e.i64(9, offset)
+	if c.min != nil {
+		e.begin(12)
+		e.i64(3, 0) // nulls
+		e.binary(5, c.max)
+		e.binary(6, c.min)
+		e.end()
+	}
+	e.end()
+	e.end()
+}
+
+func int64Bytes(v int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func doubleBytes(v float64) []byte {
+	return int64Bytes(int64(math.Float64bits(v)))
+}
+
+// columns returns the columns of readings.
+func columns(readings []*sink.Reading) []*column {
+	sensor := &column{name: "sensor", typ: typeByteArray, converted: convertedUTF8}
+	timestamp := &column{name: "timestamp", typ: typeInt64, converted: convertedTimestampMicros}
+	pm25 := &column{name: "pm25", typ: typeDouble, converted: -1}
+	pm10 := &column{name: "pm10", typ: typeDouble, converted: -1}
+
+	var minSensor, maxSensor string
+	var minTime, maxTime int64
+	minPM25, maxPM25 := math.Inf(1), math.Inf(-1)
+	minPM10, maxPM10 := math.Inf(1), math.Inf(-1)
+	var b [8]byte
+	for i, r := range readings {
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(r.Sensor)))
+		sensor.data.Write(b[:4])
+		sensor.data.WriteString(r.Sensor)
+
+		t := r.Timestamp.UnixNano() / 1000
+		binary.LittleEndian.PutUint64(b[:], uint64(t))
+		timestamp.data.Write(b[:])
+
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(r.PM25))
+		pm25.data.Write(b[:])
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(r.PM10))
+		pm10.data.Write(b[:])
+
+		if i == 0 || r.Sensor < minSensor {
+			minSensor = r.Sensor
+		}
+		if i == 0 || r.Sensor > maxSensor {
+			maxSensor = r.Sensor
+		}
+		if i == 0 || t < minTime {
+			minTime = t
+		}
+		if i == 0 || t > maxTime {
+			maxTime = t
+		}
+		// NaNs fail both comparisons, and are left out.
+		if r.PM25 < minPM25 {
+			minPM25 = r.PM25
+		}
+		if r.PM25 > maxPM25 {
+			maxPM25 = r.PM25
+		}
+		if r.PM10 < minPM10 {
+			minPM10 = r.PM10
+		}
+		if r.PM10 > maxPM10 {
+			maxPM10 = r.PM10
+		}
+	}
+	if len(readings) > 0 {
+		sensor.min, sensor.max = []byte(minSensor), []byte(maxSensor)
+		timestamp.min, timestamp.max = int64Bytes(minTime), int64Bytes(maxTime)
+	}
+	if minPM25 <= maxPM25 {
+		pm25.min, pm25.max = doubleBytes(minPM25), doubleBytes(maxPM25)
+	}
+	if minPM10 <= maxPM10 {
+		pm10.min, pm10.max = doubleBytes(minPM10), doubleBytes(maxPM10)
+	}
+	return []*column{sensor, timestamp, pm25, pm10}
+}
+
+// Write writes readings to w as a Parquet file.
+func Write(w io.Writer, readings []*sink.Reading) error {
+	cols := columns(readings)
+	n := len(readings)
+
+	// The column chunks, each a single data page.
+	offsets := make([]int64, len(cols))
+	sizes := make([]int64, len(cols))
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	offset := int64(len(magic))
+	for i, c := range cols {
+		header := c.pageHeader(n)
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(c.data.Bytes()); err != nil {
+			return err
+		}
+		offsets[i] = offset
+		sizes[i] = int64(len(header) + c.data.Len())
+		offset += sizes[i]
+	}
+
+	// The file metadata.
+	e := new(encoder)
+	e.begin(0)
+	e.i32(1, 1) // version
+	e.list(2, typeStruct, len(cols)+1)
+	e.begin(0)
+	e.string(4, "schema")
+	e.i32(5, int32(len(cols)))
+	e.end()
+	for _, c := range cols {
+		c.schema(e)
+	}
+	e.i64(3, int64(n))
+	if n > 0 {
+		e.list(4, typeStruct, 1)
+		e.begin(0)
+		e.list(1, typeStruct, len(cols))
+		var total int64
+		for i, c := range cols {
+			c.chunk(e, n, offsets[i], sizes[i])
+			total += sizes[i]
+		}
+		e.i64(2, total)
+		e.i64(3, int64(n))
+		e.end()
+	} else {
+		e.list(4, typeStruct, 0)
+	}
+	e.string(6, "sds011")
+	// The minimums and maximums are in the order of the types.
+	e.list(7, typeStruct, len(cols))
+	for range cols {
+		e.begin(0)
+		e.begin(1)
+		e.end()
+		e.end()
+	}
+	e.end()
+
+	if _, err := w.Write(e.buf.Bytes()); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(e.buf.Len()))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, magic)
+	return err
+}
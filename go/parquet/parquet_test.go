@@ -0,0 +1,296 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// The tests read the files back following the Parquet format
+// (github.com/apache/parquet-format, parquet.thrift) and the Thrift
+// compact protocol specification (thrift-compact-protocol.md), rather
+// than with this package's own idea of them.
+
+// decoder reads the Thrift compact protocol into maps from field IDs
+// to values: bools, int64s, []bytes, []anys and structs.
+type decoder struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+type structure map[int16]any
+
+func (d *decoder) byte() byte {
+	if d.pos >= len(d.buf) {
+		d.t.Fatalf("reading past the end of %d bytes", len(d.buf))
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) varint() uint64 {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.t.Fatalf("bad varint at %d", d.pos)
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) zigzag() int64 {
+	v := d.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *decoder) value(typ byte) any {
+	switch typ {
+	case typeTrue:
+		return true
+	case typeFalse:
+		return false
+	case 3: // byte
+		return int64(int8(d.byte()))
+	case 4, typeI32, typeI64:
+		return d.zigzag()
+	case 7: // double
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf[d.pos:]))
+		d.pos += 8
+		return v
+	case typeBinary:
+		n := int(d.varint())
+		b := d.buf[d.pos : d.pos+n]
+		d.pos += n
+		return b
+	case typeList:
+		header := d.byte()
+		n, elem := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(d.varint())
+		}
+		list := []any{}
+		for i := 0; i < n; i++ {
+			if elem == typeTrue || elem == typeFalse {
+				list = append(list, d.byte() == 1)
+				continue
+			}
+			list = append(list, d.value(elem))
+		}
+		return list
+	case typeStruct:
+		return d.structure()
+	}
+	d.t.Fatalf("unknown type %d at %d", typ, d.pos)
+	return nil
+}
+
+func (d *decoder) structure() structure {
+	s := make(structure)
+	var last int16
+	for {
+		b := d.byte()
+		if b == 0 {
+			return s
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(d.zigzag())
+		}
+		s[id] = d.value(b & 0x0f)
+		last = id
+	}
+}
+
+// file is a Parquet file, read back.
+type file struct {
+	meta  structure
+	bytes []byte
+}
+
+func readFile(t *testing.T, b []byte) file {
+	t.Helper()
+	if len(b) < 12 || string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		t.Fatalf("no magic in %q", b)
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	d := &decoder{t: t, buf: b[len(b)-8-n : len(b)-8]}
+	meta := d.structure()
+	if d.pos != n {
+		t.Errorf("file metadata of %d bytes, but %d long", d.pos, n)
+	}
+	return file{meta, b}
+}
+
+// schema returns the schema of the file, as name, type, repetition
+// and converted type.
+func (f file) schema() [][4]any {
+	var schema [][4]any
+	for _, e := range f.meta[2].([]any) {
+		e := e.(structure)
+		schema = append(schema, [4]any{string(e[4].([]byte)), e[1], e[3], e[6]})
+	}
+	return schema
+}
+
+// values returns the values of the data page of the column chunk c.
+func (f file) values(t *testing.T, c structure) []any {
+	md := c[3].(structure)
+	d := &decoder{t: t, buf: f.bytes, pos: int(md[9].(int64))}
+	header := d.structure()
+	if header[1] != int64(0) {
+		t.Fatalf("page type %v, want a data page", header[1])
+	}
+	size := int(header[3].(int64))
+	if header[2] != int64(size) {
+		t.Errorf("page of %v bytes compressed, %v uncompressed", header[3], header[2])
+	}
+	n := int(header[5].(structure)[1].(int64))
+	if n != int(md[5].(int64)) {
+		t.Errorf("page of %d values, chunk of %v", n, md[5])
+	}
+	if end := d.pos + size - int(md[9].(int64)); int64(end) != md[6].(int64) {
+		t.Errorf("page ends %d bytes into the chunk, which is %v", end, md[6])
+	}
+	data := f.bytes[d.pos : d.pos+size]
+	var values []any
+	for i := 0; i < n; i++ {
+		switch md[1] {
+		case int64(typeByteArray):
+			l := int(binary.LittleEndian.Uint32(data))
+			values, data = append(values, string(data[4:4+l])), data[4+l:]
+		case int64(typeInt64):
+			values, data = append(values, int64(binary.LittleEndian.Uint64(data))), data[8:]
+		case int64(typeDouble):
+			values, data = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data))), data[8:]
+		}
+	}
+	return values
+}
+
+func TestWrite(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	readings := []*sink.Reading{
+		{Sensor: "kitchen", Point: &sds011.Point{PM25: 12.3, PM10: 20.1, Timestamp: t0}},
+		{Sensor: "garden", Point: &sds011.Point{PM25: 3, PM10: 40, Timestamp: t0.Add(time.Minute)}},
+		{Sensor: "kitchen", Point: &sds011.Point{PM25: math.NaN(), PM10: 15, Timestamp: t0.Add(-time.Minute)}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, readings); err != nil {
+		t.Fatal(err)
+	}
+	f := readFile(t, buf.Bytes())
+	if f.meta[1] != int64(1) || f.meta[3] != int64(3) || string(f.meta[6].([]byte)) != "sds011" {
+		t.Errorf("version %v, rows %v, created by %q", f.meta[1], f.meta[3], f.meta[6])
+	}
+	required := int64(0)
+	want := [][4]any{
+		{"schema", nil, nil, nil},
+		{"sensor", int64(typeByteArray), required, int64(convertedUTF8)},
+		{"timestamp", int64(typeInt64), required, int64(convertedTimestampMicros)},
+		{"pm25", int64(typeDouble), required, nil},
+		{"pm10", int64(typeDouble), required, nil},
+	}
+	if got := f.schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema: %v, want %v", got, want)
+	}
+
+	groups := f.meta[4].([]any)
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(groups))
+	}
+	group := groups[0].(structure)
+	if group[3] != int64(3) {
+		t.Errorf("row group of %v rows, want 3", group[3])
+	}
+	chunks := group[1].([]any)
+	micros := t0.UnixMicro()
+	for i, tc := range []struct {
+		values   []any
+		min, max []byte
+	}{
+		{[]any{"kitchen", "garden", "kitchen"}, []byte("garden"), []byte("kitchen")},
+		{[]any{micros, micros + 60e6, micros - 60e6}, int64Bytes(micros - 60e6), int64Bytes(micros + 60e6)},
+		// NaNs aren't in the statistics.
+		{[]any{12.3, 3.0, math.NaN()}, doubleBytes(3), doubleBytes(12.3)},
+		{[]any{20.1, 40.0, 15.0}, doubleBytes(15), doubleBytes(40)},
+	} {
+		c := chunks[i].(structure)
+		md := c[3].(structure)
+		name := string(md[3].([]any)[0].([]byte))
+		if md[4] != int64(0) {
+			t.Errorf("%v: codec %v, want uncompressed", name, md[4])
+		}
+		// Compared as text, as NaN isn't equal to itself.
+		got := f.values(t, c)
+		if fmt.Sprint(got) != fmt.Sprint(tc.values) {
+			t.Errorf("%v: %v, want %v", name, got, tc.values)
+		}
+		stats := md[12].(structure)
+		if !bytes.Equal(stats[6].([]byte), tc.min) || !bytes.Equal(stats[5].([]byte), tc.max) {
+			t.Errorf("%v: min %x, max %x, want %x and %x", name, stats[6], stats[5], tc.min, tc.max)
+		}
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	f := readFile(t, buf.Bytes())
+	if f.meta[3] != int64(0) || len(f.meta[4].([]any)) != 0 {
+		t.Errorf("rows %v, row groups %v, want none", f.meta[3], f.meta[4])
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	// The examples of the Thrift compact protocol specification.
+	for _, tc := range []struct {
+		name  string
+		write func(e *encoder)
+		want  []byte
+	}{
+		{"zigzag 0", func(e *encoder) { e.zigzag(0) }, []byte{0}},
+		{"zigzag -1", func(e *encoder) { e.zigzag(-1) }, []byte{1}},
+		{"zigzag 1", func(e *encoder) { e.zigzag(1) }, []byte{2}},
+		{"zigzag -2", func(e *encoder) { e.zigzag(-2) }, []byte{3}},
+		{"zigzag 2147483647", func(e *encoder) { e.zigzag(2147483647) }, []byte{0xfe, 0xff, 0xff, 0xff, 0x0f}},
+		// The field ID delta and the type share a byte.
+		{"short field header", func(e *encoder) { e.i32(1, 3) }, []byte{0x15, 6}},
+		// Past a delta of 15, the ID comes after the type.
+		{"long field header", func(e *encoder) { e.i32(16, 1) }, []byte{0x05, 32, 2}},
+		{"bool", func(e *encoder) { e.bool(1, true); e.bool(2, false) }, []byte{0x11, 0x12}},
+		{"binary", func(e *encoder) { e.string(1, "ab") }, []byte{0x18, 2, 'a', 'b'}},
+		{"short list", func(e *encoder) { e.list(1, typeI32, 2); e.zigzag(1); e.zigzag(2) }, []byte{0x19, 0x25, 2, 4}},
+		{"long list", func(e *encoder) { e.list(1, typeI32, 15) }, []byte{0x19, 0xf5, 15}},
+		{"nested struct", func(e *encoder) { e.begin(2); e.i32(1, 0); e.end(); e.i32(3, 0) }, []byte{0x2c, 0x15, 0, 0, 0x15, 0}},
+	} {
+		e := new(encoder)
+		tc.write(e)
+		if got := e.buf.Bytes(); !bytes.Equal(got, tc.want) {
+			t.Errorf("%v: % x, want % x", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// The types of the fields of the Thrift compact protocol.
+const (
+	typeTrue   = 1
+	typeFalse  = 2
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+// encoder writes the Thrift compact protocol, in which the metadata
+// of Parquet files is written. It only knows what this package needs.
+type encoder struct {
+	buf bytes.Buffer
+	// last is the ID of the last field of the struct being written,
+	// and stack the IDs for the structs it's in.
+	last  int16
+	stack []int16
+}
+
+func (e *encoder) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (e *encoder) zigzag(v int64) {
+	e.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (e *encoder) field(id int16, typ byte) {
+	if delta := id - e.last; delta > 0 && delta <= 15 {
+		e.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		e.buf.WriteByte(typ)
+		e.zigzag(int64(id))
+	}
+	e.last = id
+}
+
+func (e *encoder) i32(id int16, v int32) {
+	e.field(id, typeI32)
+	e.zigzag(int64(v))
+}
+
+func (e *encoder) i64(id int16, v int64) {
+	e.field(id, typeI64)
+	e.zigzag(v)
+}
+
+func (e *encoder) bool(id int16, v bool) {
+	if v {
+		e.field(id, typeTrue)
+	} else {
+		e.field(id, typeFalse)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.varint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) binary(id int16, b []byte) {
+	e.field(id, typeBinary)
+	e.bytes(b)
+}
+
+func (e *encoder) string(id int16, s string) {
+	e.binary(id, []byte(s))
+}
+
+// begin starts a struct: the value of field id, or, if id is 0, the
+// top level struct or an element of a list.
+func (e *encoder) begin(id int16) {
+	if id != 0 {
+		e.field(id, typeStruct)
+	}
+	e.stack = append(e.stack, e.last)
+	e.last = 0
+}
+
+// end ends the struct begin started.
+func (e *encoder) end() {
+	e.buf.WriteByte(0)
+	e.last = e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+}
+
+// list starts the value of field id, a list of n elements of type
+// typ. The elements are written next.
+func (e *encoder) list(id int16, typ byte, n int) {
+	e.field(id, typeList)
+	if n < 15 {
+		e.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		e.buf.WriteByte(0xf0 | typ)
+		e.varint(uint64(n))
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote gives access to the sensors managed by sds011d from
+// other machines. The daemon serves the SDS011 gRPC service, defined
+// in remotepb/remote.proto, and Client calls it, so that a remote
+// sensor can be used almost as if it were local:
+//
+//	client, err := remote.Dial("pi:9011")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer client.Close()
+//	m, err := client.GetMeasurement("living_room")
+//
+// Programs in other languages can generate a client from the .proto
+// file. If the daemon uses TLS, connect with DialTLS instead, and if
+// it requires a token, pass it to Client.SetToken.
+package remote
+
+// The code in remotepb is generated from remote.proto with protoc
+// 29.3, protoc-gen-go v1.36.9 and protoc-gen-go-grpc v1.5.1. To
+// regenerate it, install those and run go generate:
+//
+//	go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.36.9
+//	go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+//
+//go:generate protoc -I remotepb --go_out=remotepb --go_opt=paths=source_relative --go-grpc_out=remotepb --go-grpc_opt=paths=source_relative remote.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ryszard/sds011/go/remote/remotepb"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A Measurement is a single reading taken by one of the daemon's
+// sensors.
+type Measurement struct {
+	Sensor    string
+	PM25      float64
+	PM10      float64
+	Timestamp time.Time
+	// PM1 is the PM1.0 level, set only if HasPM1 is true.
+	PM1     float64
+	HasPM1  bool
+	Quality sds011.Quality
+	// Labels are the labels configured for the sensor.
+	Labels map[string]string
+}
+
+// Info describes a sensor and its current settings.
+type Info struct {
+	Sensor   string
+	PortPath string
+	// Device is the device the port path leads to, like
+	// /dev/ttyUSB0, and ByID its link in /dev/serial/by-id, which
+	// stays the same when ports are renumbered, if it has one.
+	Device   string
+	ByID     string
+	DeviceID string
+	Firmware string
+	// Active is true if the sensor is in active report mode, false
+	// if it's in query mode.
+	Active bool
+	// Cycle is the sensor's working period in minutes, 0 meaning
+	// continuous operation.
+	Cycle uint8
+	Awake bool
+}
+
+// Errors a Backend returns, possibly wrapped, so that the service
+// tells them apart from failures of the sensors. Errors that are, or
+// wrap, os.ErrDeadlineExceeded are taken to be a sensor that didn't
+// answer in time.
+var (
+	// ErrUnknownSensor is returned for a sensor the backend doesn't
+	// have.
+	ErrUnknownSensor = errors.New("unknown sensor")
+	// ErrInvalidArgument is returned for a request that can't be
+	// carried out as it is, like a cycle longer than 30 minutes.
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// A Backend is what the service needs from the daemon.
+type Backend interface {
+	// Sensors returns the names of the available sensors.
+	Sensors() []string
+	// Latest returns the most recent measurement of sensor.
+	Latest(sensor string) (*Measurement, error)
+	// Next returns the first measurement of sensor taken after the
+	// given time, blocking until it becomes available or ctx is
+	// done.
+	Next(ctx context.Context, sensor string, after time.Time) (*Measurement, error)
+	// Info queries sensor for its identity and settings.
+	Info(sensor string) (*Info, error)
+	SetCycle(sensor string, minutes uint8) error
+	// SetReportMode puts sensor in active report mode if active is
+	// true, and in query mode otherwise.
+	SetReportMode(sensor string, active bool) error
+	Sleep(sensor string) error
+	Wake(sensor string) error
+	// Authenticate returns an error if token doesn't give access to
+	// the service. It is called before every call.
+	Authenticate(token string) error
+	// Authorize returns an error if token doesn't allow changing the
+	// settings of the sensors. It is called before every call that
+	// does.
+	Authorize(token string) error
+}
+
+// tokenKey is the metadata key of the token, which is sent as
+// "Bearer <token>", like the HTTP Authorization header.
+const tokenKey = "authorization"
+
+// service exposes a Backend over gRPC.
+type service struct {
+	remotepb.UnimplementedSDS011Server
+	backend Backend
+}
+
+// Register registers a service for backend with server.
+func Register(server *grpc.Server, backend Backend) {
+	remotepb.RegisterSDS011Server(server, &service{backend: backend})
+}
+
+// token returns the token of the call in ctx, if it has one.
+func token(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(tokenKey) {
+		if t, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+func (s *service) authenticate(ctx context.Context) error {
+	if err := s.backend.Authenticate(token(ctx)); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+func (s *service) authorize(ctx context.Context) error {
+	if err := s.authenticate(ctx); err != nil {
+		return err
+	}
+	if err := s.backend.Authorize(token(ctx)); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// toStatus returns err as a gRPC status error, with the code of the
+// errors of a Backend, or of a context error.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, ErrUnknownSensor):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if st := status.FromContextError(err); st.Code() != codes.Unknown {
+		return st.Err()
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+func toProto(m *Measurement) *remotepb.Measurement {
+	q := &remotepb.Quality{
+		Resynced:        m.Quality.Resynced,
+		ChecksumRetries: int32(m.Quality.ChecksumRetries),
+		InWarmup:        m.Quality.InWarmup,
+	}
+	if m.Quality.SinceWake != 0 {
+		q.SinceWake = durationpb.New(m.Quality.SinceWake)
+	}
+	return &remotepb.Measurement{
+		Sensor:    m.Sensor,
+		Pm25:      m.PM25,
+		Pm10:      m.PM10,
+		Timestamp: timestamppb.New(m.Timestamp),
+		Pm1:       m.PM1,
+		HasPm1:    m.HasPM1,
+		Quality:   q,
+		Labels:    m.Labels,
+	}
+}
+
+func fromProto(m *remotepb.Measurement) *Measurement {
+	q := m.GetQuality()
+	return &Measurement{
+		Sensor:    m.Sensor,
+		PM25:      m.Pm25,
+		PM10:      m.Pm10,
+		Timestamp: m.Timestamp.AsTime(),
+		PM1:       m.Pm1,
+		HasPM1:    m.HasPm1,
+		Quality: sds011.Quality{
+			Resynced:        q.GetResynced(),
+			ChecksumRetries: int(q.GetChecksumRetries()),
+			// A nil Duration is 0, for unknown.
+			SinceWake: q.GetSinceWake().AsDuration(),
+			InWarmup:  q.GetInWarmup(),
+		},
+		Labels: m.Labels,
+	}
+}
+
+func (s *service) ListSensors(ctx context.Context, req *remotepb.ListSensorsRequest) (*remotepb.ListSensorsResponse, error) {
+	if err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return &remotepb.ListSensorsResponse{Sensors: s.backend.Sensors()}, nil
+}
+
+func (s *service) GetMeasurement(ctx context.Context, req *remotepb.SensorRequest) (*remotepb.Measurement, error) {
+	if err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	m, err := s.backend.Latest(req.Sensor)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(m), nil
+}
+
+func (s *service) StreamMeasurements(req *remotepb.SensorRequest, stream grpc.ServerStreamingServer[remotepb.Measurement]) error {
+	ctx := stream.Context()
+	if err := s.authenticate(ctx); err != nil {
+		return err
+	}
+	after := time.Now()
+	for {
+		m, err := s.backend.Next(ctx, req.Sensor, after)
+		if err != nil {
+			return toStatus(err)
+		}
+		if err := stream.Send(toProto(m)); err != nil {
+			return err
+		}
+		after = m.Timestamp
+	}
+}
+
+func (s *service) GetInfo(ctx context.Context, req *remotepb.SensorRequest) (*remotepb.Info, error) {
+	if err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	info, err := s.backend.Info(req.Sensor)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &remotepb.Info{
+		Sensor:   info.Sensor,
+		PortPath: info.PortPath,
+		Device:   info.Device,
+		ById:     info.ByID,
+		DeviceId: info.DeviceID,
+		Firmware: info.Firmware,
+		Active:   info.Active,
+		Cycle:    uint32(info.Cycle),
+		Awake:    info.Awake,
+	}, nil
+}
+
+func (s *service) SetCycle(ctx context.Context, req *remotepb.SetCycleRequest) (*remotepb.Empty, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.Minutes > 30 {
+		return nil, status.Error(codes.InvalidArgument, "cycle should be between 0 and 30 minutes")
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.SetCycle(req.Sensor, uint8(req.Minutes)))
+}
+
+func (s *service) SetReportMode(ctx context.Context, req *remotepb.SetReportModeRequest) (*remotepb.Empty, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.SetReportMode(req.Sensor, req.Active))
+}
+
+func (s *service) Sleep(ctx context.Context, req *remotepb.SensorRequest) (*remotepb.Empty, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.Sleep(req.Sensor))
+}
+
+func (s *service) Wake(ctx context.Context, req *remotepb.SensorRequest) (*remotepb.Empty, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.Wake(req.Sensor))
+}
+
+// Client is a client of the SDS011 service.
+type Client struct {
+	conn  *grpc.ClientConn
+	c     remotepb.SDS011Client
+	token string
+}
+
+// Dial returns a client of the service at the given TCP address. It
+// connects on the first call, and again when the connection is lost.
+func Dial(address string) (*Client, error) {
+	return dial(address, insecure.NewCredentials())
+}
+
+// DialTLS is like Dial, but connects over TLS.
+func DialTLS(address string, config *tls.Config) (*Client, error) {
+	return dial(address, credentials.NewTLS(config))
+}
+
+func dial(address string, creds credentials.TransportCredentials) (*Client, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, c: remotepb.NewSDS011Client(conn)}, nil
+}
+
+// Close closes the connection.
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+// SetToken sets the token sent with every call, for daemons that
+// require authentication or protect the calls that control the
+// sensors.
+func (client *Client) SetToken(token string) {
+	client.token = token
+}
+
+// context returns ctx with the token, if there is one.
+func (client *Client) context(ctx context.Context) context.Context {
+	if client.token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, tokenKey, "Bearer "+client.token)
+}
+
+// ListSensors returns the names of the sensors managed by the daemon.
+func (client *Client) ListSensors() ([]string, error) {
+	resp, err := client.c.ListSensors(client.context(context.Background()), &remotepb.ListSensorsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Sensors, nil
+}
+
+// GetMeasurement returns the latest measurement of sensor.
+func (client *Client) GetMeasurement(sensor string) (*Measurement, error) {
+	m, err := client.c.GetMeasurement(client.context(context.Background()), &remotepb.SensorRequest{Sensor: sensor})
+	if err != nil {
+		return nil, err
+	}
+	return fromProto(m), nil
+}
+
+// StreamMeasurements calls f with every new measurement of sensor,
+// until ctx is done or f returns an error.
+func (client *Client) StreamMeasurements(ctx context.Context, sensor string, f func(*Measurement) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.c.StreamMeasurements(client.context(ctx), &remotepb.SensorRequest{Sensor: sensor})
+	if err != nil {
+		return err
+	}
+	for {
+		m, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := f(fromProto(m)); err != nil {
+			return err
+		}
+	}
+}
+
+// GetInfo returns the identity and settings of sensor.
+func (client *Client) GetInfo(sensor string) (*Info, error) {
+	info, err := client.c.GetInfo(client.context(context.Background()), &remotepb.SensorRequest{Sensor: sensor})
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		Sensor:   info.Sensor,
+		PortPath: info.PortPath,
+		Device:   info.Device,
+		ByID:     info.ById,
+		DeviceID: info.DeviceId,
+		Firmware: info.Firmware,
+		Active:   info.Active,
+		Cycle:    uint8(info.Cycle),
+		Awake:    info.Awake,
+	}, nil
+}
+
+// SetCycle sets the working period of sensor, in minutes.
+func (client *Client) SetCycle(sensor string, minutes uint8) error {
+	if minutes > 30 {
+		// As the service would answer.
+		return status.Error(codes.InvalidArgument, "cycle should be between 0 and 30 minutes")
+	}
+	_, err := client.c.SetCycle(client.context(context.Background()), &remotepb.SetCycleRequest{Sensor: sensor, Minutes: uint32(minutes)})
+	return err
+}
+
+// SetReportMode puts sensor in active report mode if active is true,
+// and in query mode otherwise.
+func (client *Client) SetReportMode(sensor string, active bool) error {
+	_, err := client.c.SetReportMode(client.context(context.Background()), &remotepb.SetReportModeRequest{Sensor: sensor, Active: active})
+	return err
+}
+
+// Sleep puts sensor to sleep.
+func (client *Client) Sleep(sensor string) error {
+	_, err := client.c.Sleep(client.context(context.Background()), &remotepb.SensorRequest{Sensor: sensor})
+	return err
+}
+
+// Wake wakes sensor up.
+func (client *Client) Wake(sensor string) error {
+	_, err := client.c.Wake(client.context(context.Background()), &remotepb.SensorRequest{Sensor: sensor})
+	return err
+}
@@ -0,0 +1,259 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ryszard/sds011/go/remote/remotepb"
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var t0 = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeBackend has one sensor, "kitchen", whose measurements come one
+// a millisecond. Anyone with the token "reader" may read them, and
+// only "admin" may control it.
+type fakeBackend struct {
+	mu    sync.Mutex
+	cycle uint8
+}
+
+func (b *fakeBackend) Sensors() []string { return []string{"kitchen"} }
+
+func (b *fakeBackend) measurement(sensor string, i int) (*Measurement, error) {
+	if sensor != "kitchen" {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSensor, sensor)
+	}
+	return &Measurement{Sensor: sensor, PM25: float64(i), PM10: float64(2 * i), Timestamp: t0.Add(time.Duration(i) * time.Minute)}, nil
+}
+
+// Latest returns a measurement with all the fields set, to check
+// that they make it over the wire.
+func (b *fakeBackend) Latest(sensor string) (*Measurement, error) {
+	m, err := b.measurement(sensor, 1)
+	if err != nil {
+		return nil, err
+	}
+	m.PM1, m.HasPM1 = 0.5, true
+	m.Quality = sds011.Quality{Resynced: true, ChecksumRetries: 2, SinceWake: 20 * time.Second, InWarmup: true}
+	m.Labels = map[string]string{"room": "kitchen"}
+	return m, nil
+}
+
+func (b *fakeBackend) Next(ctx context.Context, sensor string, after time.Time) (*Measurement, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Millisecond):
+	}
+	i := 1
+	if after.After(t0) {
+		i = int(after.Sub(t0)/time.Minute) + 1
+	}
+	return b.measurement(sensor, i)
+}
+
+// Info times out for "attic", as if it didn't answer.
+func (b *fakeBackend) Info(sensor string) (*Info, error) {
+	if sensor == "attic" {
+		return nil, fmt.Errorf("DeviceID: %w", os.ErrDeadlineExceeded)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &Info{Sensor: sensor, PortPath: "/dev/ttyUSB0", DeviceID: "a160", Firmware: "18-11-16", Active: true, Cycle: b.cycle, Awake: true}, nil
+}
+
+func (b *fakeBackend) SetCycle(sensor string, minutes uint8) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cycle = minutes
+	return nil
+}
+
+func (b *fakeBackend) SetReportMode(sensor string, active bool) error { return nil }
+func (b *fakeBackend) Sleep(sensor string) error                      { return nil }
+func (b *fakeBackend) Wake(sensor string) error                       { return nil }
+
+func (b *fakeBackend) Authenticate(token string) error {
+	if token != "reader" && token != "admin" {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
+func (b *fakeBackend) Authorize(token string) error {
+	if token != "admin" {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
+// serve serves backend, and returns a client of it with token.
+func serve(t *testing.T, backend Backend, token string) *Client {
+	t.Helper()
+	server := grpc.NewServer()
+	Register(server, backend)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetToken(token)
+	return client
+}
+
+func TestClient(t *testing.T) {
+	client := serve(t, &fakeBackend{}, "admin")
+	sensors, err := client.ListSensors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kitchen"}; !reflect.DeepEqual(sensors, want) {
+		t.Errorf("ListSensors: %v, want %v", sensors, want)
+	}
+	m, err := client.GetMeasurement("kitchen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Measurement{
+		Sensor:    "kitchen",
+		PM25:      1,
+		PM10:      2,
+		Timestamp: t0.Add(time.Minute),
+		PM1:       0.5,
+		HasPM1:    true,
+		Quality:   sds011.Quality{Resynced: true, ChecksumRetries: 2, SinceWake: 20 * time.Second, InWarmup: true},
+		Labels:    map[string]string{"room": "kitchen"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("GetMeasurement: %+v, want %+v", m, want)
+	}
+	if _, err := client.GetMeasurement("hall"); status.Code(err) != codes.NotFound {
+		t.Errorf("GetMeasurement of an unknown sensor: %v, want %v", err, codes.NotFound)
+	}
+	if err := client.SetCycle("kitchen", 5); err != nil {
+		t.Fatal(err)
+	}
+	info, err := client.GetInfo("kitchen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Info{Sensor: "kitchen", PortPath: "/dev/ttyUSB0", DeviceID: "a160", Firmware: "18-11-16", Active: true, Cycle: 5, Awake: true}); !reflect.DeepEqual(info, want) {
+		t.Errorf("GetInfo: %+v, want %+v", info, want)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	client := serve(t, &fakeBackend{}, "reader")
+	var got []float64
+	done := errors.New("done")
+	err := client.StreamMeasurements(context.Background(), "kitchen", func(m *Measurement) error {
+		got = append(got, m.PM25)
+		if len(got) == 3 {
+			return done
+		}
+		return nil
+	})
+	if err != done {
+		t.Fatalf("StreamMeasurements: %v, want %v", err, done)
+	}
+	// The stream starts at the time of the call, after the fake's
+	// measurements, and goes on a minute at a time.
+	for i := 1; i < len(got); i++ {
+		if got[i] != got[i-1]+1 {
+			t.Errorf("StreamMeasurements: %v, want consecutive measurements", got)
+			break
+		}
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	backend := &fakeBackend{}
+	for _, tc := range []struct {
+		token      string
+		read, ctrl codes.Code
+	}{
+		{"admin", codes.OK, codes.OK},
+		{"reader", codes.OK, codes.PermissionDenied},
+		{"", codes.Unauthenticated, codes.Unauthenticated},
+		{"wrong", codes.Unauthenticated, codes.Unauthenticated},
+	} {
+		client := serve(t, backend, tc.token)
+		if _, err := client.ListSensors(); status.Code(err) != tc.read {
+			t.Errorf("token %q: ListSensors: %v, want %v", tc.token, err, tc.read)
+		}
+		if err := client.Sleep("kitchen"); status.Code(err) != tc.ctrl {
+			t.Errorf("token %q: Sleep: %v, want %v", tc.token, err, tc.ctrl)
+		}
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tc := range []struct {
+		err  error
+		want codes.Code
+	}{
+		{nil, codes.OK},
+		{fmt.Errorf("%w %q", ErrUnknownSensor, "hall"), codes.NotFound},
+		{fmt.Errorf("%w: cycle of 31 minutes", ErrInvalidArgument), codes.InvalidArgument},
+		{fmt.Errorf("reading: %w", os.ErrDeadlineExceeded), codes.DeadlineExceeded},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{ctx.Err(), codes.Canceled},
+		{errors.New("sds011: no reply"), codes.Unknown},
+	} {
+		if got := status.Code(toStatus(tc.err)); got != tc.want {
+			t.Errorf("toStatus(%v): %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	client := serve(t, &fakeBackend{}, "admin")
+	if err := client.SetCycle("kitchen", 31); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SetCycle of 31 minutes: %v, want %v", err, codes.InvalidArgument)
+	}
+	// Clients in other languages don't check it first.
+	req := &remotepb.SetCycleRequest{Sensor: "kitchen", Minutes: 31}
+	if _, err := client.c.SetCycle(client.context(context.Background()), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SetCycle RPC of 31 minutes: %v, want %v", err, codes.InvalidArgument)
+	}
+	if err := client.StreamMeasurements(context.Background(), "hall", func(*Measurement) error { return nil }); status.Code(err) != codes.NotFound {
+		t.Errorf("StreamMeasurements of an unknown sensor: %v, want %v", err, codes.NotFound)
+	}
+	if _, err := client.GetInfo("attic"); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("GetInfo of a sensor that doesn't answer: %v, want %v", err, codes.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"errors"
+	"os"
+)
+
+// SetAutoWake makes the sensor wake itself up for commands that time
+// out because it's asleep: when a command or a query times out, and
+// the work state says the sensor is sleeping, it's woken up, the
+// command is tried again, and the sensor is put back to sleep.
+//
+// Only reads that time out can tell a sleeping sensor from a busy
+// line, so this needs a port with a read timeout. A query answered
+// this way is of a sensor that has just woken up, before its fan has
+// spun up; use MeasureAverage to warm it up first.
+func (sensor *Sensor) SetAutoWake(autoWake bool) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.autoWake = autoWake
+}
+
+// isTimeout returns whether err is a read that timed out.
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &t) && t.Timeout()
+}
+
+// retryAwake handles the error err of an operation as SetAutoWake
+// says: if it applies, it wakes the sensor up, calls retry, and puts
+// the sensor back to sleep. It returns the error of retry, or err if
+// it didn't apply.
+func (sensor *Sensor) retryAwake(err error, retry func() error) error {
+	if !sensor.autoWake || sensor.waking || !isTimeout(err) {
+		return err
+	}
+	sensor.waking = true
+	defer func() { sensor.waking = false }()
+	awake, stateErr := sensor.isAwake()
+	if stateErr != nil || awake {
+		return err
+	}
+	if err := sensor.setAwake(true); err != nil {
+		return err
+	}
+	err = retry()
+	if sleepErr := sensor.setAwake(false); err == nil {
+		err = sleepErr
+	}
+	return err
+}
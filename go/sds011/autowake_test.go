@@ -0,0 +1,53 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+// asleep returns a fake that is asleep, and whose reads time out.
+func asleep() *sds011test.Fake {
+	fake := sds011test.NewFake()
+	fake.Awake = false
+	fake.ReadTimeout = 20 * time.Millisecond
+	return fake
+}
+
+func TestAutoWake(t *testing.T) {
+	fake := asleep()
+	sensor := NewSensor(fake)
+	sensor.SetAutoWake(true)
+	if id, err := sensor.DeviceID(); err != nil || id != "a160" {
+		t.Errorf("DeviceID: %q, %v, want a160", id, err)
+	}
+	if p, err := sensor.Query(); err != nil || p.PM25 != 10 {
+		t.Errorf("Query: %v, %v, want PM2.5 10", p, err)
+	}
+	if fake.Awake {
+		t.Errorf("the sensor was left awake")
+	}
+}
+
+func TestAutoWakeOff(t *testing.T) {
+	sensor := NewSensor(asleep())
+	if _, err := sensor.DeviceID(); !isTimeout(err) {
+		t.Errorf("DeviceID: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"testing"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+// measurement is PM2.5 10.3 µg/m³, PM10 23.4 µg/m³, from sensor A160.
+var measurement = []byte{0xaa, 0xc0, 0x67, 0x00, 0xea, 0x00, 0xa1, 0x60, 0x52, 0xab}
+
+// loop is a port that sends the same bytes over and over, and ignores
+// what's written to it.
+type loop struct {
+	b []byte
+	i int
+}
+
+func (l *loop) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		c := copy(b[n:], l.b[l.i:])
+		n += c
+		l.i = (l.i + c) % len(l.b)
+	}
+	return n, nil
+}
+
+func (l *loop) Write(b []byte) (int, error) { return len(b), nil }
+
+func (l *loop) Close() error { return nil }
+
+func TestReadPointAllocs(t *testing.T) {
+	sensor := NewSensor(&loop{b: measurement})
+	var p Point
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := sensor.ReadPoint(&p); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("ReadPoint: %v allocations, want 0", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { DecodePoint(measurement, &p) }); allocs != 0 {
+		t.Errorf("DecodePoint: %v allocations, want 0", allocs)
+	}
+	if p.PM25 != 10.3 || p.PM10 != 23.4 {
+		t.Errorf("ReadPoint: %v, want PM2.5 10.3 and PM10 23.4", &p)
+	}
+}
+
+func TestQueryPointAllocs(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(sensor *Sensor)
+	}{
+		{"default", func(*Sensor) {}},
+		{"first byte", func(sensor *Sensor) { sensor.SetTimestamps(TimestampOptions{FirstByte: true, WallClock: true}) }},
+		{"bound", func(sensor *Sensor) { sensor.bound, sensor.id = true, [2]byte{0xa1, 0x60} }},
+	} {
+		sensor := NewSensor(&loop{b: measurement})
+		tc.setup(sensor)
+		var p Point
+		for name, read := range map[string]func() error{
+			"ReadPoint":  func() error { return sensor.ReadPoint(&p) },
+			"QueryPoint": func() error { return sensor.QueryPoint(&p) },
+		} {
+			allocs := testing.AllocsPerRun(100, func() {
+				if err := read(); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("%v, %v: %v allocations, want 0", tc.name, name, allocs)
+			}
+		}
+	}
+}
+
+func TestDecodePoint(t *testing.T) {
+	var p Point
+	if err := DecodePoint(measurement, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.PM25 != 10.3 || p.PM10 != 23.4 {
+		t.Errorf("DecodePoint: %v, want PM2.5 10.3 and PM10 23.4", &p)
+	}
+	reply := []byte{0xaa, 0xc5, 0x07, 0x12, 0x0b, 0x10, 0xa1, 0x60, 0x35, 0xab}
+	for _, frame := range [][]byte{reply, measurement[:9], measurement[1:]} {
+		if err := DecodePoint(frame, &p); err == nil {
+			t.Errorf("DecodePoint(% x): no error", frame)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	sensor := NewSensor(&loop{b: measurement})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := sensor.Get(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPoint(b *testing.B) {
+	sensor := NewSensor(&loop{b: measurement})
+	var p Point
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := sensor.ReadPoint(&p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPointResync(b *testing.B) {
+	// Every frame is preceded by junk, including a false header.
+	sensor := NewSensor(&loop{b: append([]byte{0x01, 0xaa, 0x02}, measurement...)})
+	var p Point
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := sensor.ReadPoint(&p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	sensor := NewSensor(&loop{b: measurement})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := sensor.Query(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueryPoint(b *testing.B) {
+	sensor := NewSensor(&loop{b: measurement})
+	var p Point
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := sensor.QueryPoint(&p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePoint(b *testing.B) {
+	var p Point
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := DecodePoint(measurement, &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExtendedMeasurement(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.PM1 = 4.5
+	sensor := NewSensor(fake)
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasPM1 || p.PM1 != 4.5 || p.PM25 != 10 || p.PM10 != 20 {
+		t.Errorf("Query: %v, want PM1.0 4.5, PM2.5 10 and PM10 20", p)
+	}
+
+	// PM2.5 10.3 µg/m³, PM10 23.4 µg/m³, PM1.0 5.6 µg/m³, from sensor A160.
+	extended := []byte{0xaa, 0xc1, 0x67, 0x00, 0xea, 0x00, 0xa1, 0x60, 0x38, 0x00, 0x8a, 0xab}
+	var q Point
+	if err := DecodePoint(extended, &q); err != nil {
+		t.Fatal(err)
+	}
+	if !q.HasPM1 || q.PM1 != 5.6 || q.PM25 != 10.3 || q.PM10 != 23.4 {
+		t.Errorf("DecodePoint: %v, want PM1.0 5.6, PM2.5 10.3 and PM10 23.4", &q)
+	}
+	if err := DecodePoint(measurement, &q); err != nil || q.HasPM1 {
+		t.Errorf("DecodePoint(% x): %v, %v; want no PM1.0", measurement, &q, err)
+	}
+	// A measurement with an extended measurement's size isn't one.
+	long := append([]byte{0xaa, 0xc0}, extended[2:]...)
+	if err := DecodePoint(long, &q); err == nil {
+		t.Errorf("DecodePoint(% x): no error", long)
+	}
+}
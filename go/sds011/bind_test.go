@@ -0,0 +1,120 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryszard/sds011/go/capture"
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// frameFrom returns a frame with the given command byte and data, and
+// the device ID id.
+func frameFrom(cmd byte, data [4]byte, id [2]byte) []byte {
+	resp := wire.Response{Kind: cmd, Data: [8]byte{data[0], data[1], data[2], data[3], id[0], id[1]}}
+	return resp.Append(nil)
+}
+
+func TestBindSkipsOtherUnits(t *testing.T) {
+	ours, theirs := [2]byte{0xA1, 0x60}, [2]byte{0xB2, 0xB2}
+	var data []byte
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandWorkState), 0, workStateSleeping}, theirs)...)
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandWorkState), 0, workStateMeasuring}, ours)...)
+	data = append(data, frameFrom(0xC0, [4]byte{1, 0, 2, 0}, theirs)...)
+	data = append(data, frameFrom(0xC0, [4]byte{100, 0, 200, 0}, ours)...)
+
+	sensor := NewSensor(replay(data))
+	if err := sensor.Bind("a160"); err != nil {
+		t.Fatal(err)
+	}
+	awake, err := sensor.IsAwake()
+	if err != nil {
+		t.Fatalf("IsAwake: %v", err)
+	}
+	if !awake {
+		t.Error("IsAwake: took the reply of another unit")
+	}
+	p, err := sensor.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.PM25 != 10 || p.PM10 != 20 {
+		t.Errorf("Get: %v, want PM2.5 10 and PM10 20", p)
+	}
+}
+
+func TestBindAddressesCommands(t *testing.T) {
+	var buf bytes.Buffer
+	sensor := NewSensor(capture.Record(sds011test.NewFake(), &buf))
+	if err := sensor.Bind("a160"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := sensor.DeviceID()
+	if err != nil {
+		t.Fatalf("DeviceID: %v", err)
+	}
+	if id != "a160" {
+		t.Errorf("DeviceID: %q, want %q", id, "a160")
+	}
+	events, err := capture.Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Direction == capture.ToSensor && (e.Data[15] != 0xA1 || e.Data[16] != 0x60) {
+			t.Errorf("request not addressed to a160: % x", e.Data)
+		}
+	}
+}
+
+func TestBindBadID(t *testing.T) {
+	sensor := NewSensor(sds011test.NewFake())
+	for _, id := range []string{"a1", "a1600", "zzzz"} {
+		if err := sensor.Bind(id); err == nil {
+			t.Errorf("Bind(%q): no error", id)
+		}
+	}
+}
+
+func TestSetDeviceID(t *testing.T) {
+	for _, bound := range []bool{false, true} {
+		fake := sds011test.NewFake()
+		sensor := NewSensor(fake)
+		if bound {
+			if err := sensor.Bind("a160"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sensor.SetDeviceID("BEEF"); err != nil {
+			t.Fatalf("bound %v: SetDeviceID: %v", bound, err)
+		}
+		if fake.ID != [2]byte{0xBE, 0xEF} {
+			t.Errorf("bound %v: the fake's ID is % x, want be ef", bound, fake.ID)
+		}
+		id, err := sensor.DeviceID()
+		if err != nil {
+			t.Fatalf("bound %v: DeviceID: %v", bound, err)
+		}
+		if id != "beef" {
+			t.Errorf("bound %v: DeviceID: %q, want %q", bound, id, "beef")
+		}
+	}
+	if err := NewSensor(sds011test.NewFake()).SetDeviceID("a1"); err == nil {
+		t.Error("SetDeviceID(\"a1\"): no error")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"testing"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+// withOldFirmware makes firmware older than 15-01-01 lack the working
+// period, for as long as the test runs.
+func withOldFirmware(t *testing.T) {
+	saved := firmwares
+	firmwares = []firmwareCapabilities{
+		{"15-01-01", saved[0].capabilities},
+		{"00-00-00", Capabilities{}},
+	}
+	t.Cleanup(func() { firmwares = saved })
+}
+
+func TestCapabilities(t *testing.T) {
+	withOldFirmware(t)
+	for _, tt := range []struct {
+		firmware [3]byte
+		want     Capabilities
+	}{
+		{[3]byte{18, 11, 16}, Capabilities{Firmware: "18-11-16", WorkingPeriod: true, PersistentSettings: true}},
+		{[3]byte{15, 1, 1}, Capabilities{Firmware: "15-01-01", WorkingPeriod: true, PersistentSettings: true}},
+		{[3]byte{14, 12, 31}, Capabilities{Firmware: "14-12-31"}},
+	} {
+		fake := sds011test.NewFake()
+		fake.Firmware = tt.firmware
+		sensor := NewSensor(fake)
+		got, err := sensor.Capabilities()
+		if err != nil {
+			t.Fatalf("%v: Capabilities: %v", tt.firmware, err)
+		}
+		if got != tt.want {
+			t.Errorf("%v: Capabilities: %+v, want %+v", tt.firmware, got, tt.want)
+		}
+	}
+}
+
+func TestCapabilitiesKnown(t *testing.T) {
+	// No firmware is known to lack anything.
+	fake := sds011test.NewFake()
+	fake.Firmware = [3]byte{14, 12, 31}
+	got, err := NewSensor(fake).Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Capabilities{Firmware: "14-12-31", WorkingPeriod: true, PersistentSettings: true}); got != want {
+		t.Errorf("Capabilities: %+v, want %+v", got, want)
+	}
+}
+
+func TestCapabilitiesUnsupported(t *testing.T) {
+	withOldFirmware(t)
+	fake := sds011test.NewFake()
+	fake.Firmware = [3]byte{14, 12, 31}
+	sensor := NewSensor(fake)
+	// The firmware version is asked for on the first command that
+	// depends on it, without calling Capabilities first.
+	if err := sensor.SetCycle(5); err != ErrUnsupported {
+		t.Errorf("SetCycle: %v, want %v", err, ErrUnsupported)
+	}
+	if _, err := sensor.Cycle(); err != ErrUnsupported {
+		t.Errorf("Cycle: %v, want %v", err, ErrUnsupported)
+	}
+	if fake.Cycle != 0 {
+		t.Errorf("the working period was set to %v", fake.Cycle)
+	}
+}
+
+func TestConfirmReportModeUnsupported(t *testing.T) {
+	withOldFirmware(t)
+	for _, awake := range []bool{true, false} {
+		fake := sds011test.NewFake()
+		fake.Firmware = [3]byte{14, 12, 31}
+		fake.Awake = awake
+		sensor := NewSensor(fake)
+		if err := sensor.ConfirmReportMode(QueryMode); err != ErrUnsupported {
+			t.Errorf("awake %v: ConfirmReportMode: %v, want %v", awake, err, ErrUnsupported)
+		}
+		if fake.Awake != awake {
+			t.Errorf("awake %v: the sensor was left awake %v", awake, fake.Awake)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import "math"
+
+// A Consensus holds readings back until they agree with the ones right
+// before them, which keeps out the spikes that a gust of air through
+// the sensor causes, at the cost of holding back readings for a while
+// after one, and when the levels change quickly.
+//
+// The zero value accepts every reading.
+type Consensus struct {
+	// Readings is how many readings in a row, the new one included,
+	// have to agree for it to be accepted.
+	Readings int
+	// Tolerance is how far apart in µg/m³ readings that agree may
+	// be, and Relative the same as a fraction of their mean. The
+	// larger of the two counts. If both are zero, readings only
+	// agree if they're equal.
+	Tolerance, Relative float64
+
+	recent []Point
+}
+
+// Add adds a reading, and returns whether it's accepted.
+func (c *Consensus) Add(p *Point) bool {
+	if c.Readings <= 1 {
+		return true
+	}
+	if len(c.recent) == c.Readings {
+		copy(c.recent, c.recent[1:])
+		c.recent = c.recent[:len(c.recent)-1]
+	}
+	c.recent = append(c.recent, *p)
+	if len(c.recent) < c.Readings {
+		return false
+	}
+	hasPM1 := true
+	for _, q := range c.recent {
+		hasPM1 = hasPM1 && q.HasPM1
+	}
+	return c.agree(func(q *Point) float64 { return q.PM25 }) &&
+		c.agree(func(q *Point) float64 { return q.PM10 }) &&
+		(!hasPM1 || c.agree(func(q *Point) float64 { return q.PM1 }))
+}
+
+// agree returns whether a level of the recent readings agrees.
+func (c *Consensus) agree(level func(*Point) float64) bool {
+	min, max, sum := math.Inf(1), math.Inf(-1), 0.0
+	for i := range c.recent {
+		v := level(&c.recent[i])
+		min, max, sum = math.Min(min, v), math.Max(max, v), sum+v
+	}
+	mean := sum / float64(len(c.recent))
+	return max-min <= math.Max(c.Tolerance, c.Relative*mean)
+}
+
+// Reset forgets the readings added so far, as after a gap in them.
+func (c *Consensus) Reset() {
+	c.recent = c.recent[:0]
+}
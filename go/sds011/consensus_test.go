@@ -0,0 +1,51 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import "testing"
+
+func TestConsensus(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		consensus Consensus
+		pm25      []float64
+		want      []bool
+	}{
+		{"off", Consensus{}, []float64{10, 50, 10}, []bool{true, true, true}},
+		{"steady", Consensus{Readings: 3, Tolerance: 2}, []float64{10, 11, 12, 11}, []bool{false, false, true, true}},
+		{"spike", Consensus{Readings: 3, Tolerance: 2}, []float64{10, 11, 60, 11, 10, 11}, []bool{false, false, false, false, false, true}},
+		{"step", Consensus{Readings: 2, Tolerance: 2}, []float64{10, 40, 41, 40}, []bool{false, false, true, true}},
+		{"relative", Consensus{Readings: 2, Tolerance: 2, Relative: 0.1}, []float64{100, 108, 130}, []bool{false, true, false}},
+	} {
+		for i, pm25 := range tc.pm25 {
+			p := &Point{PM25: pm25, PM10: pm25}
+			if got := tc.consensus.Add(p); got != tc.want[i] {
+				t.Errorf("%v: reading %d (%v): Add returned %v, want %v", tc.name, i, pm25, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestConsensusPM10(t *testing.T) {
+	c := Consensus{Readings: 2, Tolerance: 2}
+	c.Add(&Point{PM25: 10, PM10: 20})
+	if c.Add(&Point{PM25: 10, PM10: 40}) {
+		t.Errorf("Add accepted a reading whose PM10 doesn't agree")
+	}
+	c.Reset()
+	if c.Add(&Point{PM25: 10, PM10: 40}) {
+		t.Errorf("Add accepted the first reading after Reset")
+	}
+}
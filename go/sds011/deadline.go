@@ -0,0 +1,172 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadlines puts timeouts on the reads and writes of a port. Serial
+// ports can't have deadlines (go-serial opens them blocking), so a
+// read that times out is left running in the background, and what it
+// reads is returned by the next one: no bytes are lost.
+type deadlines struct {
+	rwc io.ReadWriteCloser
+
+	mu           sync.Mutex
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// reads are the results of the background reads, once one was
+	// started, and pending what's left of the last one.
+	reads   chan readResult
+	pending []byte
+	// writing is closed when the background write, if there is one,
+	// is done.
+	writing chan struct{}
+}
+
+type readResult struct {
+	b   []byte
+	err error
+}
+
+// timeouts returns the timeouts of reads and writes.
+func (d *deadlines) timeouts() (read, write time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.readTimeout, d.writeTimeout
+}
+
+// Read is like the Read of the port, but fails with
+// os.ErrDeadlineExceeded if nothing arrives within the read timeout.
+// Reads aren't concurrent, as the sensor's frame reader is their only
+// caller.
+func (d *deadlines) Read(b []byte) (int, error) {
+	if len(d.pending) > 0 {
+		n := copy(b, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+	timeout, _ := d.timeouts()
+	if d.reads == nil {
+		if timeout == 0 {
+			return d.rwc.Read(b)
+		}
+		d.reads = make(chan readResult, 1)
+		go d.read(len(b))
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case r := <-d.reads:
+		if r.err != nil {
+			// The reading stops at errors, so that closing the
+			// port ends it; the next read starts it again.
+			d.reads = nil
+		} else {
+			go d.read(len(b))
+		}
+		n := copy(b, r.b)
+		d.pending = r.b[n:]
+		return n, r.err
+	case <-expired:
+		return 0, fmt.Errorf("sds011: no data in %v: %w", timeout, os.ErrDeadlineExceeded)
+	}
+}
+
+// read reads up to n bytes in the background.
+func (d *deadlines) read(n int) {
+	b := make([]byte, n)
+	n, err := d.rwc.Read(b)
+	d.reads <- readResult{b[:n], err}
+}
+
+// Write is like the Write of the port, but fails with
+// os.ErrDeadlineExceeded if it takes longer than the write timeout.
+// A write that times out goes on in the background, and the next one
+// waits for it.
+func (d *deadlines) Write(b []byte) (int, error) {
+	_, timeout := d.timeouts()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	if d.writing != nil {
+		select {
+		case <-d.writing:
+			d.writing = nil
+		case <-expired:
+			return 0, fmt.Errorf("sds011: write still blocked after %v: %w", timeout, os.ErrDeadlineExceeded)
+		}
+	}
+	if timeout == 0 {
+		return d.rwc.Write(b)
+	}
+	// The write may outlive the call, and the caller reuse b.
+	b = append([]byte(nil), b...)
+	done := make(chan struct{})
+	var n int
+	var err error
+	go func() {
+		defer close(done)
+		n, err = d.rwc.Write(b)
+	}()
+	select {
+	case <-done:
+		return n, err
+	case <-expired:
+		d.writing = done
+		return 0, fmt.Errorf("sds011: write blocked for %v: %w", timeout, os.ErrDeadlineExceeded)
+	}
+}
+
+func (d *deadlines) Close() error {
+	return d.rwc.Close()
+}
+
+// SetReadTimeout makes the sensor give up waiting for a frame after d
+// without a byte from the port, as when the sensor is wedged or was
+// unplugged in a way the port doesn't notice, and return an error for
+// which errors.Is(err, os.ErrDeadlineExceeded) is true. The sensor can
+// be used again after. Zero, the default, means waiting forever.
+//
+// In active mode the sensor sends a measurement every second, or less
+// often with a working period (see SetCycle), so the timeout should be
+// longer than that. SetReadTimeout may be called at any time.
+func (sensor *Sensor) SetReadTimeout(d time.Duration) {
+	sensor.deadlines.mu.Lock()
+	defer sensor.deadlines.mu.Unlock()
+	sensor.deadlines.readTimeout = d
+}
+
+// SetWriteTimeout makes sending a command to the sensor fail with an
+// error for which errors.Is(err, os.ErrDeadlineExceeded) is true if
+// the port doesn't take it within d, as when the adapter is wedged.
+// Zero, the default, means waiting forever.
+func (sensor *Sensor) SetWriteTimeout(d time.Duration) {
+	sensor.deadlines.mu.Lock()
+	defer sensor.deadlines.mu.Unlock()
+	sensor.deadlines.writeTimeout = d
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestReadTimeout(t *testing.T) {
+	fake := sds011test.NewFake()
+	sensor := NewSensor(fake)
+	defer sensor.Close()
+	sensor.SetReadTimeout(20 * time.Millisecond)
+	// In query mode, the fake never sends measurements by itself.
+	if _, err := sensor.Get(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Get in query mode: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatalf("Query after a timeout: %v", err)
+	}
+	if p.PM25 != 10 || p.PM10 != 20 {
+		t.Errorf("Query: %v, want PM2.5 10 and PM10 20", p)
+	}
+}
+
+func TestReadTimeoutLateReply(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Cycle = 5
+	fake.Delay = 100 * time.Millisecond
+	fake.Faults = []sds011test.Fault{sds011test.Delay}
+	sensor := NewSensor(fake)
+	defer sensor.Close()
+	sensor.SetReadTimeout(20 * time.Millisecond)
+	if _, err := sensor.Cycle(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Cycle with a late reply: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	// The late reply isn't lost, but read by the next command.
+	sensor.SetReadTimeout(time.Second)
+	if cycle, err := sensor.Cycle(); err != nil || cycle != 5 {
+		t.Errorf("Cycle after a timeout: %v, %v, want 5", cycle, err)
+	}
+	if cycle, err := sensor.Cycle(); err != nil || cycle != 5 {
+		t.Errorf("Cycle: %v, %v, want 5", cycle, err)
+	}
+}
+
+// stuckWriter is a port whose writes block until it's released.
+type stuckWriter struct {
+	*sds011test.Fake
+	release chan struct{}
+}
+
+func (w *stuckWriter) Write(b []byte) (int, error) {
+	<-w.release
+	return w.Fake.Write(b)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	port := &stuckWriter{sds011test.NewFake(), make(chan struct{})}
+	sensor := NewSensor(port)
+	defer sensor.Close()
+	sensor.SetWriteTimeout(20 * time.Millisecond)
+	if err := sensor.Awake(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Awake with a stuck port: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if err := sensor.Awake(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Awake behind a stuck write: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	close(port.release)
+	if _, err := sensor.Firmware(); err != nil {
+		t.Errorf("Firmware once the port is unstuck: %v", err)
+	}
+}
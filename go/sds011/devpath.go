@@ -0,0 +1,135 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// serialByID is where udev links the serial ports, under names made of
+// the vendor, model and serial number of their adapters.
+var serialByID = "/dev/serial/by-id"
+
+// A PortInfo identifies the serial port of a sensor.
+type PortInfo struct {
+	// Path is the path the port was opened by.
+	Path string
+	// Device is the device Path resolves to, following symlinks,
+	// like /dev/ttyUSB0.
+	Device string
+	// ByID is the link to Device in /dev/serial/by-id, or "" if
+	// there isn't one. Unlike Device, it stays the same when the
+	// ports are renumbered, as after a reboot or when another
+	// adapter is plugged in first.
+	ByID string
+}
+
+// Stable returns the path that finds the port even after it's
+// renumbered: ByID if there is one, and Path otherwise.
+func (p PortInfo) Stable() string {
+	if p.ByID != "" {
+		return p.ByID
+	}
+	return p.Path
+}
+
+// comPort matches the names of Windows serial ports, like COM3, which
+// may be given in the device namespace, like \\.\COM10.
+var comPort = regexp.MustCompile(`(?i)^(\\\\\.\\)?(COM[0-9]+)$`)
+
+// ResolvePort returns the identity of the port at path, which may be
+// a device, like /dev/ttyUSB0 or, on macOS, /dev/cu.usbserial-1410, a
+// link to one, like those in /dev/serial/by-id, or on Windows a COM
+// port, like COM3. If it doesn't exist, the error is a *PortError.
+func ResolvePort(path string) (PortInfo, error) {
+	info := PortInfo{Path: path}
+	if runtime.GOOS == "windows" {
+		// COM ports aren't files; whether they exist is only
+		// known once they're opened.
+		if m := comPort.FindStringSubmatch(path); m != nil {
+			info.Device = strings.ToUpper(m[2])
+			return info, nil
+		}
+	}
+	device, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return info, portError(path, err)
+	}
+	if info.Device, err = filepath.Abs(device); err != nil {
+		return info, err
+	}
+	if filepath.Dir(path) == serialByID {
+		info.ByID = path
+		return info, nil
+	}
+	links, err := os.ReadDir(serialByID)
+	if err != nil {
+		// Not Linux, or no adapter udev knows.
+		return info, nil
+	}
+	for _, l := range links {
+		link := filepath.Join(serialByID, l.Name())
+		if target, err := filepath.EvalSymlinks(link); err == nil && target == info.Device {
+			info.ByID = link
+			break
+		}
+	}
+	return info, nil
+}
+
+// Ports returns the serial ports a sensor may be attached to: on Linux
+// the USB serial ports, /dev/ttyUSB*; on macOS the callout devices of
+// USB serial adapters, like /dev/cu.usbserial-1410 or, for the CH340
+// of the SDS011, /dev/cu.wchusbserial1410; and on Windows the COM
+// ports, from the registry.
+func Ports() ([]PortInfo, error) {
+	paths, err := portPaths()
+	if err != nil {
+		return nil, err
+	}
+	var ports []PortInfo
+	for _, path := range paths {
+		// A port that's gone since it was listed isn't an error.
+		if info, err := ResolvePort(path); err == nil {
+			ports = append(ports, info)
+		}
+	}
+	return ports, nil
+}
+
+// globPorts returns the paths matching any of patterns, sorted.
+func globPorts(patterns ...string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Port returns the identity of the port the sensor was opened on by
+// New or NewWithOptions. It's zero for sensors made with NewSensor.
+func (sensor *Sensor) Port() PortInfo {
+	return sensor.port
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolvePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("making links needs privileges on Windows")
+	}
+	// The temporary directory may be behind a link itself, as on
+	// macOS.
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"dev", "by-id"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	device := filepath.Join(dir, "dev", "ttyUSB0")
+	other := filepath.Join(dir, "dev", "ttyUSB1")
+	for _, path := range []string{device, other} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Like udev, with a relative link.
+	byID := filepath.Join(dir, "by-id", "usb-1a86_USB_Serial-if00-port0")
+	if err := os.Symlink("../dev/ttyUSB0", byID); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { serialByID = old }(serialByID)
+	serialByID = filepath.Join(dir, "by-id")
+
+	for _, tc := range []struct {
+		path string
+		want PortInfo
+	}{
+		{device, PortInfo{Path: device, Device: device, ByID: byID}},
+		{byID, PortInfo{Path: byID, Device: device, ByID: byID}},
+		{other, PortInfo{Path: other, Device: other}},
+	} {
+		got, err := ResolvePort(tc.path)
+		if err != nil {
+			t.Errorf("ResolvePort(%q): %v", tc.path, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ResolvePort(%q): %+v, want %+v", tc.path, got, tc.want)
+		}
+	}
+	if stable := (PortInfo{Path: other}).Stable(); stable != other {
+		t.Errorf("Stable: %q, want %q", stable, other)
+	}
+	if _, err := ResolvePort(filepath.Join(dir, "dev", "ttyUSB2")); err == nil {
+		t.Error("ResolvePort of a missing port: no error")
+	}
+}
+
+func TestCOMPort(t *testing.T) {
+	for name, want := range map[string]string{
+		"COM3":      "COM3",
+		"com4":      "com4",
+		`\\.\COM10`: "COM10",
+		"COM":       "",
+		"COMX":      "",
+		"/dev/COM3": "",
+	} {
+		got := ""
+		if m := comPort.FindStringSubmatch(name); m != nil {
+			got = m[2]
+		}
+		if got != want {
+			t.Errorf("%q: port %q, want %q", name, got, want)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		return
+	}
+	if info, err := ResolvePort("com3"); err != nil || info != (PortInfo{Path: "com3", Device: "COM3"}) {
+		t.Errorf("ResolvePort(com3): %+v, %v", info, err)
+	}
+}
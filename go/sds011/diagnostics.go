@@ -0,0 +1,55 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+// Diagnostics count what a sensor has read, for telling how reliable
+// the line is. The counts are since the sensor was created; to get them
+// for a period, subtract.
+type Diagnostics struct {
+	// Frames is the number of frames read.
+	Frames int
+	// Discarded is the number of frames skipped, as they weren't
+	// what was being read: measurements while waiting for a reply,
+	// replies to other commands, and frames from other units when
+	// the sensor is bound.
+	Discarded int
+	// Resyncs is the number of times the sensor lost track of where
+	// frames start, and skipped bytes to find the next one.
+	// SkippedBytes is how many bytes that took.
+	Resyncs      int
+	SkippedBytes int
+	// Checksum is the number of frames with bad checksums.
+	Checksum int
+}
+
+// Sub returns the counts of d since those of old.
+func (d Diagnostics) Sub(old Diagnostics) Diagnostics {
+	return Diagnostics{
+		Frames:       d.Frames - old.Frames,
+		Discarded:    d.Discarded - old.Discarded,
+		Resyncs:      d.Resyncs - old.Resyncs,
+		SkippedBytes: d.SkippedBytes - old.SkippedBytes,
+		Checksum:     d.Checksum - old.Checksum,
+	}
+}
+
+// Diagnostics returns the diagnostics of the sensor.
+func (sensor *Sensor) Diagnostics() Diagnostics {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	d := sensor.frames.diag
+	d.Discarded = sensor.discarded
+	return d
+}
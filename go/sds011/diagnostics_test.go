@@ -0,0 +1,49 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	ours, theirs := [2]byte{0xA1, 0x60}, [2]byte{0xB2, 0xB2}
+	var data []byte
+	data = append(data, 0x01, 0x02)
+	data = append(data, frameFrom(0xC0, [4]byte{1, 0, 2, 0}, theirs)...)
+	data = append(data, frameFrom(0xC0, [4]byte{100, 0, 200, 0}, ours)...)
+	bad := frameFrom(0xC0, [4]byte{100, 0, 200, 0}, ours)
+	bad[8]++
+	data = append(data, bad...)
+
+	sensor := NewSensor(replay(data))
+	if err := sensor.Bind("a160"); err != nil {
+		t.Fatal(err)
+	}
+	var p Point
+	if err := sensor.ReadPoint(&p); err != nil {
+		t.Fatal(err)
+	}
+	if err := sensor.ReadPoint(&p); err == nil {
+		t.Fatal("no error for a bad checksum")
+	}
+	want := Diagnostics{Frames: 3, Discarded: 1, Resyncs: 1, SkippedBytes: 2, Checksum: 1}
+	if got := sensor.Diagnostics(); got != want {
+		t.Errorf("Diagnostics: %+v, want %+v", got, want)
+	}
+	if got := want.Sub(Diagnostics{Frames: 1, Checksum: 1}); got != (Diagnostics{Frames: 2, Discarded: 1, Resyncs: 1, SkippedBytes: 2}) {
+		t.Errorf("Sub: %+v", got)
+	}
+}
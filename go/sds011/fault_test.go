@@ -0,0 +1,117 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestFaultCorrupt(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Faults = []sds011test.Fault{sds011test.Corrupt}
+	sensor := NewSensor(fake)
+	if _, err := sensor.Query(); err == nil {
+		t.Error("Query with a bad checksum: no error")
+	}
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatalf("Query after a bad checksum: %v", err)
+	}
+	if p.PM25 != 10 || p.PM10 != 20 {
+		t.Errorf("Query: %v, want PM2.5 10 and PM10 20", p)
+	}
+}
+
+func TestFaultTruncate(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Active = true
+	fake.Faults = []sds011test.Fault{sds011test.Truncate, sds011test.Truncate}
+	sensor := NewSensor(fake)
+	// The sensor resyncs on the first whole frame.
+	p, err := sensor.Get()
+	if err != nil {
+		t.Fatalf("Get after truncated frames: %v", err)
+	}
+	if p.PM25 != 10 || p.PM10 != 20 {
+		t.Errorf("Get: %v, want PM2.5 10 and PM10 20", p)
+	}
+}
+
+func TestFaultTruncateCommand(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Faults = []sds011test.Fault{sds011test.Truncate}
+	sensor := NewSensor(fake)
+	// Nothing follows the half of the reply, so Sleep waits until the
+	// port is closed.
+	time.AfterFunc(10*time.Millisecond, func() { fake.Close() })
+	if err := sensor.Sleep(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Sleep with a truncated reply: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Delay = 50 * time.Millisecond
+	fake.Faults = []sds011test.Fault{sds011test.Delay}
+	sensor := NewSensor(fake)
+	start := time.Now()
+	cycle, err := sensor.Cycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycle != 0 {
+		t.Errorf("Cycle: %v, want 0", cycle)
+	}
+	if elapsed := time.Since(start); elapsed < fake.Delay {
+		t.Errorf("Cycle took %v, less than the delay", elapsed)
+	}
+}
+
+func TestFaultSpurious(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Faults = []sds011test.Fault{sds011test.Spurious, sds011test.Spurious}
+	sensor := NewSensor(fake)
+	firmware, err := sensor.Firmware()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firmware != "18-11-16" {
+		t.Errorf("Firmware: %q, want 18-11-16", firmware)
+	}
+	id, err := sensor.DeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "a160" {
+		t.Errorf("DeviceID: %q, want a160", id)
+	}
+}
+
+func TestFaultEOF(t *testing.T) {
+	fake := sds011test.NewFake()
+	// The first frame answers SetCycle asking for the firmware version.
+	fake.Faults = []sds011test.Fault{sds011test.None, sds011test.None, sds011test.EOF}
+	sensor := NewSensor(fake)
+	if err := sensor.SetCycle(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sensor.Cycle(); err != io.EOF {
+		t.Errorf("Cycle after EOF: %v, want %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// frameReader reads responses from the wire. If it loses track of
+// where frames start, because a byte was dropped or there was noise on
+// the line, it skips ahead to the next thing that looks like a frame.
+type frameReader struct {
+	r *bufio.Reader
+	// diag counts what was read. Only Frames, Resyncs, SkippedBytes
+	// and Checksum are used.
+	diag Diagnostics
+	// arrivals knows when the bytes arrived, if it's enabled, and
+	// start is then when the first byte of the last frame did.
+	arrivals *arrivals
+	start    time.Time
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	a := &arrivals{r: r}
+	return &frameReader{r: bufio.NewReaderSize(a, 2*wire.ExtendedSize), arrivals: a}
+}
+
+// next reads the next frame into resp. A frame with a bad checksum is
+// read, but an error is returned.
+func (fr *frameReader) next(resp *wire.Response) error {
+	skipped := 0
+	defer func() {
+		if skipped > 0 {
+			fr.diag.Resyncs++
+			fr.diag.SkippedBytes += skipped
+			log.V(2).Infof("skipped %d bytes looking for a frame", skipped)
+		}
+	}()
+	for {
+		b, err := fr.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && skipped > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if fr.arrivals.enabled {
+			// The byte read is the one before what is buffered.
+			fr.start = fr.arrivals.at(fr.arrivals.total - int64(fr.r.Buffered()) - 1)
+		}
+		if b != wire.Header {
+			skipped++
+			continue
+		}
+		size := wire.ResponseSize
+		if kind, err := fr.r.Peek(1); err == nil {
+			size = wire.Size(kind[0])
+		}
+		rest, err := fr.r.Peek(size - 1)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if rest[size-2] != wire.Tail {
+			// The header was a coincidence; the frame might start
+			// in what we peeked at.
+			skipped++
+			continue
+		}
+		var frame [wire.ExtendedSize]byte
+		frame[0] = b
+		copy(frame[1:], rest)
+		fr.r.Discard(size - 1)
+		fr.diag.Frames++
+		if err := wire.DecodeResponse(frame[:size], resp); err != nil {
+			if err == wire.ErrChecksum {
+				fr.diag.Checksum++
+			}
+			return frameError(err, frame, size)
+		}
+		return nil
+	}
+}
+
+// frameError wraps err with the bytes of the frame. It takes frame by
+// value so that it's copied to the heap only when there's an error.
+func frameError(err error, frame [wire.ExtendedSize]byte, size int) error {
+	return fmt.Errorf("%w: % x", err, frame[:size])
+}
+
+// ErrWrongFrame is returned when reading from a frame what it doesn't
+// have, like the firmware version from a reply to another command.
+var ErrWrongFrame = errors.New("sds011: wrong kind of frame")
+
+// A Frame is a frame sent by the sensor: a *MeasurementFrame or a
+// *ReplyFrame.
+type Frame interface {
+	// DeviceID returns the device ID of the unit that sent the
+	// frame, as 4 hex digits.
+	DeviceID() string
+	// Response returns the frame as the wire package decodes it.
+	Response() wire.Response
+	frame()
+}
+
+// ParseFrame decodes a frame as the sensor sends it on the wire, from
+// the 0xAA header to the 0xAB tail. It returns an error wrapping
+// wire.ErrFrame or wire.ErrChecksum if it isn't a good frame.
+func ParseFrame(b []byte) (Frame, error) {
+	var resp wire.Response
+	if err := wire.DecodeResponse(b, &resp); err != nil {
+		return nil, fmt.Errorf("%w: % x", err, b)
+	}
+	return FrameOf(resp)
+}
+
+// FrameOf returns the frame of resp, as it's passed to the function
+// set with SetReplyHandler, for example. It returns an error wrapping
+// ErrWrongFrame if resp is of a kind the sensor doesn't send.
+func FrameOf(resp wire.Response) (Frame, error) {
+	switch {
+	case resp.IsMeasurement():
+		return &MeasurementFrame{resp}, nil
+	case resp.IsReply():
+		return &ReplyFrame{resp}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown kind %#x", ErrWrongFrame, resp.Kind)
+}
+
+// deviceID formats the ID of the unit that sent resp.
+func deviceID(resp *wire.Response) string {
+	return fmt.Sprintf("%02x%02x", resp.Data[4], resp.Data[5])
+}
+
+// A MeasurementFrame is a measurement, plain or extended with the
+// PM1.0 level of some clones.
+type MeasurementFrame struct {
+	resp wire.Response
+}
+
+func (*MeasurementFrame) frame() {}
+
+// DeviceID returns the device ID of the unit that measured.
+func (m *MeasurementFrame) DeviceID() string { return deviceID(&m.resp) }
+
+// Response returns the measurement as the wire package decodes it.
+func (m *MeasurementFrame) Response() wire.Response { return m.resp }
+
+// PM25 returns the PM2.5 level, in µg/m³.
+func (m *MeasurementFrame) PM25() float64 { return m.resp.PM25() }
+
+// PM10 returns the PM10 level, in µg/m³.
+func (m *MeasurementFrame) PM10() float64 { return m.resp.PM10() }
+
+// PM1 returns the PM1.0 level, in µg/m³, and whether there is one,
+// which is only in extended measurements.
+func (m *MeasurementFrame) PM1() (float64, bool) {
+	if !m.resp.HasPM1() {
+		return 0, false
+	}
+	return m.resp.PM1(), true
+}
+
+// read sets the levels of point.
+func (m *MeasurementFrame) read(point *Point) {
+	point.PM25, point.PM10 = m.PM25(), m.PM10()
+	point.PM1, point.HasPM1 = m.PM1()
+}
+
+// A ReplyFrame is the reply of the sensor to a command. What it holds
+// depends on the command; the accessors for the others return an
+// error wrapping ErrWrongFrame.
+type ReplyFrame struct {
+	resp wire.Response
+}
+
+func (*ReplyFrame) frame() {}
+
+// DeviceID returns the device ID of the unit that replied. It's the
+// only thing a reply to the device ID command holds.
+func (r *ReplyFrame) DeviceID() string { return deviceID(&r.resp) }
+
+// Response returns the reply as the wire package decodes it.
+func (r *ReplyFrame) Response() wire.Response { return r.resp }
+
+// Command returns the command this is a reply to.
+func (r *ReplyFrame) Command() wire.Command { return r.resp.ReplyTo() }
+
+// check returns an error if this isn't a reply to cmd.
+func (r *ReplyFrame) check(cmd command) error {
+	if got := r.Command(); got != cmd {
+		return fmt.Errorf("%w: a reply to command %d, not %d", ErrWrongFrame, got, cmd)
+	}
+	return nil
+}
+
+// Firmware returns the firmware version, as a date (yy-mm-dd).
+func (r *ReplyFrame) Firmware() (string, error) {
+	if err := r.check(commandFirmware); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%02d-%02d-%02d", r.resp.Data[1], r.resp.Data[2], r.resp.Data[3]), nil
+}
+
+// ReportMode returns the report mode.
+func (r *ReplyFrame) ReportMode() (ReportMode, error) {
+	if err := r.check(commandReportMode); err != nil {
+		return 0, err
+	}
+	if r.resp.Data[2] == reportModeActive {
+		return ActiveMode, nil
+	}
+	return QueryMode, nil
+}
+
+// Cycle returns the cycle length in minutes, or 0 if the sensor
+// measures continuously.
+func (r *ReplyFrame) Cycle() (uint8, error) {
+	if err := r.check(commandCycle); err != nil {
+		return 0, err
+	}
+	return r.resp.Data[2], nil
+}
+
+// Awake returns whether the sensor is awake, as opposed to asleep.
+func (r *ReplyFrame) Awake() (bool, error) {
+	if err := r.check(commandWorkState); err != nil {
+		return false, err
+	}
+	return r.resp.Data[2] == workStateMeasuring, nil
+}
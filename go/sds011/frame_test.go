@@ -0,0 +1,192 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryszard/sds011/go/capture"
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// recorded returns, for every recording in testdata, everything that
+// was read from the sensor.
+func recorded(tb testing.TB) [][]byte {
+	var paths []string
+	for _, pattern := range []string{"testdata/*.capture", "testdata/golden/*.capture"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		paths = append(paths, matches...)
+	}
+	var streams [][]byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		events, err := capture.Load(f)
+		f.Close()
+		if err != nil {
+			tb.Fatalf("%v: %v", path, err)
+		}
+		var stream []byte
+		for _, e := range events {
+			if e.Direction == capture.FromSensor {
+				stream = append(stream, e.Data...)
+			}
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// replay returns a port from which data can be read.
+func replay(data []byte) *capture.Replay {
+	return capture.NewReplay([]capture.Event{{Direction: capture.FromSensor, Data: data}})
+}
+
+func TestFrameReaderDroppedByte(t *testing.T) {
+	id := [2]byte{0xA1, 0x60}
+	first := frameFrom(0xC0, [4]byte{1, 0, 2, 0}, id)
+	var data []byte
+	// The first frame lost a byte of its data on the wire.
+	data = append(data, first[:4]...)
+	data = append(data, first[5:]...)
+	data = append(data, frameFrom(0xC0, [4]byte{100, 0, 200, 0}, id)...)
+	data = append(data, frameFrom(0xC0, [4]byte{150, 0, 250, 0}, id)...)
+
+	sensor := NewSensor(replay(data))
+	for _, want := range [][2]float64{{10, 20}, {15, 25}} {
+		var p Point
+		if err := sensor.ReadPoint(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.PM25 != want[0] || p.PM10 != want[1] {
+			t.Errorf("read %v, want PM2.5 %v and PM10 %v", p, want[0], want[1])
+		}
+	}
+	if d := sensor.Diagnostics(); d.Frames != 2 || d.Resyncs != 1 || d.SkippedBytes != len(first)-1 {
+		t.Errorf("Diagnostics: %+v, want 2 frames and %d bytes skipped in 1 resync", d, len(first)-1)
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	id := [2]byte{0xA1, 0x60}
+	m := wire.NewExtendedMeasurement(1.5, 10.3, 23.4, id)
+	f, err := ParseFrame(m.Append(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	measurement, ok := f.(*MeasurementFrame)
+	if !ok {
+		t.Fatalf("ParseFrame of a measurement: %T", f)
+	}
+	if pm1, ok := measurement.PM1(); measurement.PM25() != 10.3 || measurement.PM10() != 23.4 || pm1 != 1.5 || !ok {
+		t.Errorf("measurement: PM2.5 %v, PM10 %v, PM1.0 %v %v", measurement.PM25(), measurement.PM10(), pm1, ok)
+	}
+	if got := measurement.DeviceID(); got != "a160" {
+		t.Errorf("DeviceID: %q, want a160", got)
+	}
+
+	r := wire.NewReply(commandFirmware, 18, 11, 16, id)
+	if f, err = ParseFrame(r.Append(nil)); err != nil {
+		t.Fatal(err)
+	}
+	reply, ok := f.(*ReplyFrame)
+	if !ok {
+		t.Fatalf("ParseFrame of a reply: %T", f)
+	}
+	if firmware, err := reply.Firmware(); firmware != "18-11-16" || err != nil {
+		t.Errorf("Firmware: %q, %v", firmware, err)
+	}
+	// The accessors for other commands fail rather than make up
+	// values.
+	if _, err := reply.Cycle(); !errors.Is(err, ErrWrongFrame) {
+		t.Errorf("Cycle of a firmware reply: %v, want ErrWrongFrame", err)
+	}
+	if _, err := reply.ReportMode(); !errors.Is(err, ErrWrongFrame) {
+		t.Errorf("ReportMode of a firmware reply: %v, want ErrWrongFrame", err)
+	}
+	if _, err := reply.Awake(); !errors.Is(err, ErrWrongFrame) {
+		t.Errorf("Awake of a firmware reply: %v, want ErrWrongFrame", err)
+	}
+
+	bad := r.Append(nil)
+	bad[3]++
+	if _, err := ParseFrame(bad); !errors.Is(err, wire.ErrChecksum) {
+		t.Errorf("ParseFrame with a bad checksum: %v", err)
+	}
+	if _, err := ParseFrame(bad[1:]); !errors.Is(err, wire.ErrFrame) {
+		t.Errorf("ParseFrame of a partial frame: %v", err)
+	}
+	if _, err := FrameOf(wire.Response{Kind: 0xC9}); !errors.Is(err, ErrWrongFrame) {
+		t.Errorf("FrameOf of an unknown kind: %v", err)
+	}
+}
+
+func FuzzFrameReader(f *testing.F) {
+	for _, stream := range recorded(f) {
+		f.Add(stream)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fr := newFrameReader(bytes.NewReader(data))
+		for {
+			resp := new(wire.Response)
+			err := fr.next(resp)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil && !errors.Is(err, wire.ErrChecksum) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			frame := resp.Append(nil)
+			// A frame with a bad checksum is encoded with the right
+			// one, so only the rest has to match.
+			want := frame
+			if err != nil {
+				want = frame[:len(frame)-2]
+			}
+			if !bytes.Contains(data, want) {
+				t.Fatalf("frame % x is not in the input", frame)
+			}
+		}
+	})
+}
+
+func FuzzCommands(f *testing.F) {
+	for _, stream := range recorded(f) {
+		f.Add(stream)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sensor := NewSensor(replay(data))
+		// Whatever the sensor sends, the commands may fail, but
+		// mustn't panic.
+		sensor.ReportMode()
+		sensor.DeviceID()
+		sensor.Firmware()
+		sensor.Cycle()
+		sensor.IsAwake()
+		sensor.SetCycle(5)
+		sensor.SetReportMode(QueryMode)
+		sensor.Sleep()
+	})
+}
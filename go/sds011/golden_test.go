@@ -0,0 +1,106 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ryszard/sds011/go/capture"
+)
+
+// The golden files in testdata/golden are the exchanges of every
+// command with a sensor: the request the library must send, byte for
+// byte, and what the sensor answers.
+var golden = []struct {
+	file string
+	run  func(*Sensor) (interface{}, error)
+	want interface{}
+}{
+	{"report_mode", func(s *Sensor) (interface{}, error) { return s.ReportMode() }, QueryMode},
+	{"report_mode_active", func(s *Sensor) (interface{}, error) { return nil, s.SetReportMode(ActiveMode) }, nil},
+	{"report_mode_query", func(s *Sensor) (interface{}, error) { return nil, s.SetReportMode(QueryMode) }, nil},
+	{"query", func(s *Sensor) (interface{}, error) { return pm(s.Query()) }, [2]float64{8.5, 15.6}},
+	{"get", func(s *Sensor) (interface{}, error) { return pm(s.Get()) }, [2]float64{10.3, 23.4}},
+	{"device_id", func(s *Sensor) (interface{}, error) { return s.DeviceID() }, "a160"},
+	{"firmware", func(s *Sensor) (interface{}, error) { return s.Firmware() }, "18-11-16"},
+	{"cycle", func(s *Sensor) (interface{}, error) { return s.Cycle() }, uint8(5)},
+	{"cycle_set", func(s *Sensor) (interface{}, error) { return nil, s.SetCycle(5) }, nil},
+	{"cycle_set_continuous", func(s *Sensor) (interface{}, error) { return nil, s.SetCycle(0) }, nil},
+	{"work_state", func(s *Sensor) (interface{}, error) { return s.IsAwake() }, true},
+	{"sleep", func(s *Sensor) (interface{}, error) { return nil, s.Sleep() }, nil},
+	{"awake", func(s *Sensor) (interface{}, error) { return nil, s.Awake() }, nil},
+}
+
+// pm returns the levels of a point, which unlike its timestamp can be
+// compared.
+func pm(p *Point, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return [2]float64{p.PM25, p.PM10}, nil
+}
+
+func loadCapture(t *testing.T, path string) []capture.Event {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := capture.Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range golden {
+		t.Run(tc.file, func(t *testing.T) {
+			replay := capture.NewReplay(loadCapture(t, filepath.Join("testdata", "golden", tc.file+".capture")))
+			replay.Strict = true
+			got, err := tc.run(NewSensor(replay))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if err := replay.Done(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestGoldenFiles checks that every golden file is tested.
+func TestGoldenFiles(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "golden", "*.capture"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tested := make(map[string]bool)
+	for _, tc := range golden {
+		tested[tc.file] = true
+	}
+	for _, path := range paths {
+		if name := filepath.Base(path); !tested[name[:len(name)-len(".capture")]] {
+			t.Errorf("%v isn't tested", path)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build hardware
+
+package sds011
+
+// The hardware conformance harness runs a script of commands against
+// a real sensor and reports which of them the sensor handles as the
+// library expects:
+//
+//	go test -tags=hardware -run=Hardware -v ./go/sds011 -port=/dev/ttyUSB0
+//
+// With -report, the results are also written as JSON, so that the
+// behavior of different units and firmware versions can be compared.
+// The sensor's settings are restored at the end.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	hardwarePort    = flag.String("port", "/dev/ttyUSB0", "serial port of the sensor to test")
+	hardwareReport  = flag.String("report", "", "if set, write the conformance matrix to this file as JSON")
+	hardwareTimeout = flag.Duration("step_timeout", 10*time.Second, "how long each step may take")
+)
+
+// conformance is the result of running the script against a sensor.
+type conformance struct {
+	Port     string       `json:"port"`
+	DeviceID string       `json:"device_id"`
+	Firmware string       `json:"firmware"`
+	Time     time.Time    `json:"time"`
+	Steps    []stepResult `json:"steps"`
+}
+
+type stepResult struct {
+	Step     string  `json:"step"`
+	OK       bool    `json:"ok"`
+	Detail   string  `json:"detail,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// harness runs steps against a sensor, reopening it after a step times
+// out.
+type harness struct {
+	t      *testing.T
+	sensor *Sensor
+	result conformance
+}
+
+func (h *harness) open() {
+	sensor, err := New(*hardwarePort)
+	if err != nil {
+		h.t.Fatalf("opening %v: %v", *hardwarePort, err)
+	}
+	h.sensor = sensor
+}
+
+// step runs f, which returns a description of what it saw, and records
+// the outcome.
+func (h *harness) step(name string, f func(s *Sensor) (string, error)) bool {
+	type outcome struct {
+		detail string
+		err    error
+	}
+	start := time.Now()
+	ch := make(chan outcome, 1)
+	go func() {
+		detail, err := f(h.sensor)
+		ch <- outcome{detail, err}
+	}()
+	var o outcome
+	select {
+	case o = <-ch:
+	case <-time.After(*hardwareTimeout):
+		// Closing makes f return; the sensor needs reopening.
+		h.sensor.Close()
+		<-ch
+		o.err = fmt.Errorf("timed out after %v", *hardwareTimeout)
+		h.open()
+	}
+	r := stepResult{Step: name, OK: o.err == nil, Detail: o.detail, Duration: time.Since(start).Seconds()}
+	if o.err != nil {
+		r.Detail = o.err.Error()
+	}
+	h.result.Steps = append(h.result.Steps, r)
+	return r.OK
+}
+
+// expect returns an error if got isn't want.
+func expect(what string, got, want interface{}) (string, error) {
+	if got != want {
+		return "", fmt.Errorf("%v: got %v, want %v", what, got, want)
+	}
+	return fmt.Sprint(got), nil
+}
+
+func plausible(p *Point) (string, error) {
+	if p.PM25 < 0 || p.PM25 > 999.9 || p.PM10 < 0 || p.PM10 > 999.9 {
+		return "", fmt.Errorf("implausible reading: %v", p)
+	}
+	return p.String(), nil
+}
+
+func TestHardware(t *testing.T) {
+	h := &harness{t: t, result: conformance{Port: *hardwarePort, Time: time.Now()}}
+	h.open()
+	defer func() { h.sensor.Close() }()
+
+	// The settings to restore.
+	var (
+		mode  ReportMode
+		cycle uint8
+	)
+	h.step("awake", func(s *Sensor) (string, error) { return "", s.Awake() })
+	h.step("is awake", func(s *Sensor) (string, error) {
+		awake, err := s.IsAwake()
+		if err != nil {
+			return "", err
+		}
+		return expect("awake", awake, true)
+	})
+	h.step("device id", func(s *Sensor) (id string, err error) {
+		h.result.DeviceID, err = s.DeviceID()
+		return h.result.DeviceID, err
+	})
+	h.step("firmware", func(s *Sensor) (string, error) {
+		firmware, err := s.Firmware()
+		if err != nil {
+			return "", err
+		}
+		if _, err := time.Parse("06-01-02", firmware); err != nil {
+			return "", fmt.Errorf("firmware %q isn't a date", firmware)
+		}
+		h.result.Firmware = firmware
+		return firmware, nil
+	})
+	h.step("report mode", func(s *Sensor) (string, error) {
+		var err error
+		mode, err = s.ReportMode()
+		return mode.String(), err
+	})
+	h.step("cycle", func(s *Sensor) (string, error) {
+		var err error
+		cycle, err = s.Cycle()
+		return fmt.Sprintf("%v minutes", cycle), err
+	})
+
+	// Query mode.
+	h.step("set query mode", func(s *Sensor) (string, error) { return "", s.SetReportMode(QueryMode) })
+	h.step("report mode is query", func(s *Sensor) (string, error) {
+		mode, err := s.ReportMode()
+		if err != nil {
+			return "", err
+		}
+		return expect("report mode", mode, QueryMode)
+	})
+	for i := 1; i <= 3; i++ {
+		h.step(fmt.Sprintf("query %d", i), func(s *Sensor) (string, error) {
+			p, err := s.Query()
+			if err != nil {
+				return "", err
+			}
+			return plausible(p)
+		})
+	}
+
+	// The working period.
+	h.step("set cycle 1", func(s *Sensor) (string, error) { return "", s.SetCycle(1) })
+	h.step("cycle is 1", func(s *Sensor) (string, error) {
+		c, err := s.Cycle()
+		if err != nil {
+			return "", err
+		}
+		return expect("cycle", c, uint8(1))
+	})
+	h.step("set cycle 0", func(s *Sensor) (string, error) { return "", s.SetCycle(0) })
+
+	// Active mode, reporting every second.
+	h.step("set active mode", func(s *Sensor) (string, error) { return "", s.SetReportMode(ActiveMode) })
+	for i := 1; i <= 3; i++ {
+		h.step(fmt.Sprintf("get %d", i), func(s *Sensor) (string, error) {
+			p, err := s.Get()
+			if err != nil {
+				return "", err
+			}
+			return plausible(p)
+		})
+	}
+
+	// Sleep.
+	h.step("set query mode again", func(s *Sensor) (string, error) { return "", s.SetReportMode(QueryMode) })
+	h.step("sleep", func(s *Sensor) (string, error) { return "", s.Sleep() })
+	h.step("is asleep", func(s *Sensor) (string, error) {
+		awake, err := s.IsAwake()
+		if err != nil {
+			return "", err
+		}
+		return expect("awake", awake, false)
+	})
+	h.step("wake up", func(s *Sensor) (string, error) { return "", s.Awake() })
+
+	// Restore the settings.
+	h.step("restore cycle", func(s *Sensor) (string, error) { return "", s.SetCycle(cycle) })
+	if mode == ActiveMode {
+		h.step("restore active mode", func(s *Sensor) (string, error) { return "", s.SetReportMode(ActiveMode) })
+	}
+
+	report(t, &h.result)
+}
+
+// report prints the conformance matrix, and writes it to -report.
+func report(t *testing.T, result *conformance) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sensor %v, firmware %v, on %v\n", result.DeviceID, result.Firmware, result.Port)
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tOK\tTIME\tDETAIL")
+	for _, r := range result.Steps {
+		fmt.Fprintf(w, "%v\t%v\t%.3fs\t%v\n", r.Step, r.OK, r.Duration, r.Detail)
+		if !r.OK {
+			t.Errorf("%v: %v", r.Step, r.Detail)
+		}
+	}
+	w.Flush()
+	t.Log("\n" + b.String())
+
+	if *hardwareReport == "" {
+		return
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(*hardwareReport, append(data, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MeasureOnce wakes the sensor up, waits warmup for the readings to
+// settle, takes one reading and puts the sensor back to sleep. Taking
+// readings this way now and then, instead of leaving the sensor on,
+// saves power and makes the laser last much longer. Nova recommends a
+// warmup of 30 seconds.
+func (sensor *Sensor) MeasureOnce(ctx context.Context, warmup time.Duration) (*Point, error) {
+	return sensor.MeasureAverage(ctx, warmup, 1)
+}
+
+// MeasureAverage is like MeasureOnce, but takes n readings, a second
+// apart, and returns their Average.
+func (sensor *Sensor) MeasureAverage(ctx context.Context, warmup time.Duration, n int) (point *Point, err error) {
+	if n < 1 {
+		return nil, errors.New("sds011: no readings to average")
+	}
+	if err := sensor.Awake(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if sleepErr := sensor.Sleep(); err == nil {
+			err = sleepErr
+		}
+		if err != nil {
+			point = nil
+		}
+	}()
+	if err := sleep(ctx, warmup); err != nil {
+		return nil, err
+	}
+	points := make([]Point, n)
+	for i := range points {
+		if i > 0 {
+			if err := sleep(ctx, time.Second); err != nil {
+				return nil, err
+			}
+		}
+		if err := sensor.QueryPoint(&points[i]); err != nil {
+			return nil, err
+		}
+	}
+	avg := Average(points)
+	return &avg, nil
+}
+
+// Average returns the average of points, which mustn't be empty, as
+// MeasureAverage takes it: the mean levels, with the timestamp of the
+// last point and the worst quality: resynced if any was, the checksum
+// retries of all, and the time since the wake of the first. It has a
+// PM1.0 level only if every point does.
+func Average(points []Point) Point {
+	avg := Point{Timestamp: points[len(points)-1].Timestamp, HasPM1: true, Quality: points[0].Quality}
+	for i, p := range points {
+		if i > 0 {
+			avg.Quality = avg.Quality.Worse(p.Quality)
+		}
+		avg.PM25 += p.PM25
+		avg.PM10 += p.PM10
+		avg.PM1 += p.PM1
+		avg.HasPM1 = avg.HasPM1 && p.HasPM1
+	}
+	avg.PM25 /= float64(len(points))
+	avg.PM10 /= float64(len(points))
+	if avg.PM1 /= float64(len(points)); !avg.HasPM1 {
+		avg.PM1 = 0
+	}
+	return avg
+}
+
+// sleep waits for d to pass, or ctx to be done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestMeasureAverage(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Awake = false
+	p, err := NewSensor(fake).MeasureAverage(context.Background(), time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PM25 != 10 || p.PM10 != 20 {
+		t.Errorf("MeasureAverage: %v, want PM2.5 10 and PM10 20", p)
+	}
+	if fake.Awake {
+		t.Error("the sensor wasn't put back to sleep")
+	}
+}
+
+func TestMeasureAveragePM1(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.PM1 = 5
+	p, err := NewSensor(fake).MeasureAverage(context.Background(), time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasPM1 || p.PM1 != 5 {
+		t.Errorf("MeasureAverage: %v, want PM1.0 5", p)
+	}
+}
+
+func TestAverage(t *testing.T) {
+	t1 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Second)
+	for _, tc := range []struct {
+		name   string
+		points []Point
+		want   Point
+	}{
+		{
+			"one",
+			[]Point{{PM25: 10, PM10: 20, PM1: 5, HasPM1: true, Timestamp: t1}},
+			Point{PM25: 10, PM10: 20, PM1: 5, HasPM1: true, Timestamp: t1},
+		},
+		{
+			"all with PM1.0",
+			[]Point{
+				{PM25: 10, PM10: 20, PM1: 4, HasPM1: true, Timestamp: t1},
+				{PM25: 20, PM10: 30, PM1: 6, HasPM1: true, Timestamp: t2},
+			},
+			Point{PM25: 15, PM10: 25, PM1: 5, HasPM1: true, Timestamp: t2},
+		},
+		{
+			"mixed, last with PM1.0",
+			[]Point{
+				{PM25: 10, PM10: 20, Timestamp: t1},
+				{PM25: 20, PM10: 30, PM1: 6, HasPM1: true, Timestamp: t2},
+			},
+			Point{PM25: 15, PM10: 25, Timestamp: t2},
+		},
+		{
+			"mixed, first with PM1.0",
+			[]Point{
+				{PM25: 10, PM10: 20, PM1: 4, HasPM1: true, Timestamp: t1},
+				{PM25: 20, PM10: 30, Timestamp: t2},
+			},
+			Point{PM25: 15, PM10: 25, Timestamp: t2},
+		},
+		{
+			"worst quality",
+			[]Point{
+				{Quality: Quality{SinceWake: 2 * time.Second, ChecksumRetries: 1}},
+				{Quality: Quality{SinceWake: 3 * time.Second, Resynced: true}},
+			},
+			Point{Quality: Quality{SinceWake: 2 * time.Second, ChecksumRetries: 1, Resynced: true}},
+		},
+	} {
+		if got := Average(tc.points); got != tc.want {
+			t.Errorf("%v: %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMeasureOnceCanceled(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Awake = false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if p, err := NewSensor(fake).MeasureOnce(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("MeasureOnce: %v, %v; want %v", p, err, context.Canceled)
+	}
+	if fake.Awake {
+		t.Error("the sensor wasn't put back to sleep")
+	}
+}
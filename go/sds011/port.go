@@ -0,0 +1,86 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// A Line is the state to put a modem control line of the serial port
+// in.
+type Line int
+
+const (
+	// LineUnchanged leaves the line as the driver set it.
+	LineUnchanged Line = iota
+	// LineOn asserts the line.
+	LineOn
+	// LineOff deasserts the line.
+	LineOff
+)
+
+// PortOptions tune the serial port, for adapters that don't deliver
+// frames promptly with the defaults, as some cheap CH340 ones don't.
+// The zero value is the defaults.
+type PortOptions struct {
+	// InterCharacterTimeout and MinimumReadSize decide when reads
+	// from the port return: once MinimumReadSize bytes have arrived,
+	// or InterCharacterTimeout has passed without a new one (see
+	// the VMIN and VTIME of termios). The timeout is rounded to
+	// tenths of a second. If both are zero, MinimumReadSize is 4 and
+	// there is no timeout.
+	InterCharacterTimeout time.Duration
+	MinimumReadSize       uint
+	// RTS and DTR are the states to put the modem control lines in,
+	// which some adapters need to power the sensor or pass data. They
+	// can only be changed on Linux and macOS.
+	RTS, DTR Line
+	// ReadTimeout and WriteTimeout are set on the sensor by
+	// NewWithOptions (see Sensor.SetReadTimeout and SetWriteTimeout).
+	// They don't change the port, and OpenPortWithOptions ignores
+	// them.
+	ReadTimeout, WriteTimeout time.Duration
+}
+
+// OpenPortWithOptions is like OpenPort, but tunes the port with
+// options.
+func OpenPortWithOptions(portPath string, options PortOptions) (io.ReadWriteCloser, error) {
+	o := serial.OpenOptions{
+		PortName:              portPath,
+		BaudRate:              9600,
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: uint(options.InterCharacterTimeout / time.Millisecond),
+		MinimumReadSize:       options.MinimumReadSize,
+	}
+	if o.InterCharacterTimeout == 0 && o.MinimumReadSize == 0 {
+		o.MinimumReadSize = 4
+	}
+	port, err := serial.Open(o)
+	if err != nil {
+		return nil, portError(portPath, err)
+	}
+	if options.RTS != LineUnchanged || options.DTR != LineUnchanged {
+		if err := setLines(port, options.RTS, options.DTR); err != nil {
+			port.Close()
+			return nil, fmt.Errorf("setting the modem control lines of %v: %v", portPath, err)
+		}
+	}
+	return port, nil
+}
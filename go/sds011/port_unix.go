@@ -0,0 +1,58 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+
+package sds011
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// setLines sets the modem control lines of port, which has to be a
+// file.
+func setLines(port io.ReadWriteCloser, rts, dtr Line) error {
+	f, ok := port.(*os.File)
+	if !ok {
+		return errors.New("not a file")
+	}
+	var on, off int32
+	for _, l := range []struct {
+		line Line
+		bit  int32
+	}{{rts, syscall.TIOCM_RTS}, {dtr, syscall.TIOCM_DTR}} {
+		switch l.line {
+		case LineOn:
+			on |= l.bit
+		case LineOff:
+			off |= l.bit
+		}
+	}
+	for _, req := range []struct {
+		op   uintptr
+		bits int32
+	}{{syscall.TIOCMBIS, on}, {syscall.TIOCMBIC, off}} {
+		if req.bits == 0 {
+			continue
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req.op, uintptr(unsafe.Pointer(&req.bits))); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/user"
+	"path/filepath"
+	"runtime"
+)
+
+// A PortError is returned when the serial port of a sensor can't be
+// found or opened for one of the usual reasons, like its adapter not
+// being plugged in or the user not being allowed to open it. Hint says
+// how to fix it, for programs to show to their users.
+//
+// It wraps the error of the system, so errors.Is(err, fs.ErrNotExist)
+// and errors.Is(err, fs.ErrPermission) tell the reasons apart.
+type PortError struct {
+	// Path is the path of the port.
+	Path string
+	// Err is the error of the system.
+	Err error
+	// Hint is how to fix it, like "add yourself to the dialout
+	// group".
+	Hint string
+}
+
+func (e *PortError) Error() string {
+	return fmt.Sprintf("%v; %v", e.Err, e.Hint)
+}
+
+func (e *PortError) Unwrap() error {
+	return e.Err
+}
+
+// portError returns err, from finding or opening the port at path, as
+// a *PortError if there's a hint for it, and as it is otherwise.
+func portError(path string, err error) error {
+	var hint string
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		hint = notExistHint(path)
+	case errors.Is(err, fs.ErrPermission):
+		hint = permissionHint(path)
+	default:
+		return err
+	}
+	return &PortError{Path: path, Err: err, Hint: hint}
+}
+
+func notExistHint(path string) string {
+	switch {
+	case runtime.GOOS == "windows":
+		return "check that the sensor is plugged in, and which COM port it's on under Ports in the Device Manager"
+	case runtime.GOOS == "darwin":
+		return "check that the sensor is plugged in, and which port it's on with ls /dev/cu.*"
+	case filepath.Dir(path) == serialByID:
+		return fmt.Sprintf("no adapter with this ID is plugged in: check the cable, and ls %v for the one that is", serialByID)
+	}
+	return fmt.Sprintf("check that the sensor is plugged in; ports get renumbered, as after a reboot, but their links in %v don't", serialByID)
+}
+
+func permissionHint(path string) string {
+	switch runtime.GOOS {
+	case "windows":
+		return "another program has the port open: close it"
+	case "linux":
+		// Serial ports belong to dialout, or to uucp on some
+		// distributions, like Arch.
+		for _, name := range []string{"dialout", "uucp"} {
+			group, err := user.LookupGroup(name)
+			if err != nil {
+				continue
+			}
+			if inGroup(group.Gid) {
+				return fmt.Sprintf("you are in the %v group, but not in this session: log out and back in, or run newgrp %v", name, name)
+			}
+			return fmt.Sprintf("add yourself to the %v group, which serial ports belong to, with sudo usermod -aG %v $USER, then log out and back in", name, name)
+		}
+	}
+	return fmt.Sprintf("check who may open the port with ls -l %v", path)
+}
+
+// inGroup returns whether the current user is in the group gid.
+func inGroup(gid string) bool {
+	u, err := user.Current()
+	if err != nil {
+		return false
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, g := range gids {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
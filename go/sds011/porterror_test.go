@@ -0,0 +1,53 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPortError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ttyUSB0")
+	for name, open := range map[string]func() error{
+		"ResolvePort": func() error { _, err := ResolvePort(path); return err },
+		"OpenPort":    func() error { _, err := OpenPort(path); return err },
+	} {
+		err := open()
+		var pe *PortError
+		if !errors.As(err, &pe) {
+			t.Errorf("%v of a missing port: %v, want a *PortError", name, err)
+			continue
+		}
+		if pe.Path != path || pe.Hint == "" || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%v of a missing port: %+v, want one for %v, with a hint, that is fs.ErrNotExist", name, pe, path)
+		}
+		if !strings.Contains(err.Error(), pe.Hint) {
+			t.Errorf("%v: %q doesn't say the hint, %q", name, err, pe.Hint)
+		}
+	}
+
+	denied := &fs.PathError{Op: "open", Path: path, Err: fs.ErrPermission}
+	if err := portError(path, denied); !errors.Is(err, fs.ErrPermission) || err == error(denied) {
+		t.Errorf("portError(%v): %v, want a *PortError", denied, err)
+	}
+	other := errors.New("other")
+	if err := portError(path, other); err != other {
+		t.Errorf("portError(%v): %v, want it as it is", other, err)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+// portPaths returns the callout devices, /dev/cu.*, rather than the
+// dial-in ones, /dev/tty.*, which are for answering modems.
+func portPaths() ([]string, error) {
+	return globPorts("/dev/cu.usbserial*", "/dev/cu.wchusbserial*", "/dev/cu.usbmodem*")
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"sort"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// regEnumValue isn't in package syscall.
+var regEnumValue = syscall.NewLazyDLL("advapi32.dll").NewProc("RegEnumValueW")
+
+const errorNoMoreItems syscall.Errno = 259
+
+// portPaths returns the COM ports listed under
+// HKEY_LOCAL_MACHINE\HARDWARE\DEVICEMAP\SERIALCOMM, in the order of
+// their numbers.
+func portPaths() ([]string, error) {
+	var key syscall.Handle
+	name, err := syscall.UTF16PtrFromString(`HARDWARE\DEVICEMAP\SERIALCOMM`)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, name, 0, syscall.KEY_READ, &key); err != nil {
+		if err == syscall.ERROR_FILE_NOT_FOUND {
+			// There are no serial ports.
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.RegCloseKey(key)
+	var paths []string
+	for i := 0; ; i++ {
+		var value, data [256]uint16
+		valueLen, dataLen := uint32(len(value)), uint32(2*len(data))
+		var typ uint32
+		r, _, _ := regEnumValue.Call(uintptr(key), uintptr(i),
+			uintptr(unsafe.Pointer(&value[0])), uintptr(unsafe.Pointer(&valueLen)), 0,
+			uintptr(unsafe.Pointer(&typ)), uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&dataLen)))
+		if errno := syscall.Errno(r); errno == errorNoMoreItems {
+			break
+		} else if errno != 0 {
+			return nil, errno
+		}
+		if typ == syscall.REG_SZ {
+			paths = append(paths, syscall.UTF16ToString(data[:dataLen/2]))
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return comNumber(paths[i]) < comNumber(paths[j]) })
+	return paths, nil
+}
+
+// comNumber returns the number of a COM port, like 3 for COM3.
+func comNumber(port string) int {
+	if m := comPort.FindStringSubmatch(port); m != nil {
+		n, _ := strconv.Atoi(m[2][3:])
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import "time"
+
+// DefaultWarmup is how long a sensor is taken to need to warm up after
+// waking, unless SetWarmup says otherwise. It's what Nova recommends.
+const DefaultWarmup = 30 * time.Second
+
+// Quality says how far a point can be trusted, so that readings that
+// are suspect can be weighted or left out without being lost.
+type Quality struct {
+	// Resynced is whether the sensor lost track of where frames start
+	// since the previous point, and skipped bytes to find this one.
+	Resynced bool
+	// ChecksumRetries is how many frames with a bad checksum were
+	// read since the previous point.
+	ChecksumRetries int
+	// SinceWake is how long the sensor had been awake, if it was
+	// woken up by this Sensor; otherwise it's 0, for unknown.
+	SinceWake time.Duration
+	// InWarmup is whether the point was read less than the warmup
+	// (see SetWarmup) after the sensor woke up, before its readings
+	// settled.
+	InWarmup bool
+}
+
+// SetWarmup sets how long the sensor needs to warm up after waking,
+// which decides the InWarmup of the quality of the points read.
+func (sensor *Sensor) SetWarmup(warmup time.Duration) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.warmup = warmup
+}
+
+// woke records whether the sensor was woken up, or put to sleep, now.
+func (sensor *Sensor) woke(awake bool) {
+	if !awake {
+		sensor.wokeAt = time.Time{}
+	} else if sensor.wokeAt.IsZero() {
+		sensor.wokeAt = time.Now()
+	}
+}
+
+// quality returns the quality of a point stamped t, decoded from the
+// last frame read.
+func (sensor *Sensor) quality(t time.Time) Quality {
+	d := sensor.frames.diag
+	since := d.Sub(sensor.lastPoint)
+	sensor.lastPoint = d
+	q := Quality{Resynced: since.Resyncs > 0, ChecksumRetries: since.Checksum}
+	if !sensor.wokeAt.IsZero() {
+		warmup := sensor.warmup
+		if warmup == 0 {
+			warmup = DefaultWarmup
+		}
+		q.SinceWake = t.Sub(sensor.wokeAt)
+		q.InWarmup = q.SinceWake < warmup
+	}
+	return q
+}
+
+// Worse returns the quality of an average of points of qualities q and
+// o: the worse of the two.
+func (q Quality) Worse(o Quality) Quality {
+	q.Resynced = q.Resynced || o.Resynced
+	q.ChecksumRetries += o.ChecksumRetries
+	if o.SinceWake < q.SinceWake {
+		q.SinceWake = o.SinceWake
+	}
+	q.InWarmup = q.InWarmup || o.InWarmup
+	return q
+}
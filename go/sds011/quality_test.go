@@ -0,0 +1,86 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestQualityErrors(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Faults = []sds011test.Fault{sds011test.Corrupt, sds011test.None, sds011test.Truncate, sds011test.None}
+	fake.ReadTimeout = 20 * time.Millisecond
+	sensor := NewSensor(fake)
+	query := func() *Point {
+		t.Helper()
+		p, err := sensor.Query()
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		return p
+	}
+	if _, err := sensor.Query(); err == nil {
+		t.Fatalf("Query of a corrupt frame: no error")
+	}
+	if got, want := query().Quality, (Quality{ChecksumRetries: 1}); got != want {
+		t.Errorf("after a bad checksum: quality %+v, want %+v", got, want)
+	}
+	if _, err := sensor.Query(); err == nil {
+		t.Fatalf("Query of a truncated frame: no error")
+	}
+	if got, want := query().Quality, (Quality{Resynced: true}); got != want {
+		t.Errorf("after a truncated frame: quality %+v, want %+v", got, want)
+	}
+	if got := query().Quality; got != (Quality{}) {
+		t.Errorf("quality %+v, want none of it suspect", got)
+	}
+}
+
+func TestQualityWarmup(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Awake = false
+	sensor := NewSensor(fake)
+	if err := sensor.Awake(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := p.Quality; q.SinceWake <= 0 || q.SinceWake > DefaultWarmup || !q.InWarmup {
+		t.Errorf("right after waking: quality %+v, want it in the warmup", q)
+	}
+	sensor.SetWarmup(time.Nanosecond)
+	if p, err = sensor.Query(); err != nil {
+		t.Fatal(err)
+	}
+	if q := p.Quality; q.InWarmup {
+		t.Errorf("after the warmup: quality %+v, want it out of the warmup", q)
+	}
+}
+
+func TestQualityUnknownWake(t *testing.T) {
+	sensor := NewSensor(sds011test.NewFake())
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := p.Quality; q.SinceWake != 0 || q.InWarmup {
+		t.Errorf("quality %+v, want the time since waking unknown", q)
+	}
+}
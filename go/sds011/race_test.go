@@ -0,0 +1,248 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+// These tests pin down the concurrency contract of Sensor: calls made
+// at the same time take turns, and Close may come at any time and
+// makes the pending call return. Run them with -race.
+
+// waitFor polls cond until it's true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// async runs f in a goroutine, and returns a channel with its error.
+func async(f func() error) <-chan error {
+	ch := make(chan error, 1)
+	go func() { ch <- f() }()
+	return ch
+}
+
+// wait returns the error from ch, failing if there is none soon.
+func wait(t *testing.T, what string, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%v didn't return", what)
+		return nil
+	}
+}
+
+func TestDelayedReply(t *testing.T) {
+	clock := sds011test.NewFakeClock(time.Unix(0, 0))
+	fake := sds011test.NewFake()
+	fake.Clock = clock
+	fake.Delay = time.Minute
+	fake.Faults = []sds011test.Fault{sds011test.Delay}
+	sensor := NewSensor(fake)
+
+	done := async(sensor.Sleep)
+	waitFor(t, "the reply to be delayed", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute - time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Sleep returned before the reply: %v", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := wait(t, "Sleep", done); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseDuringDelayedReply(t *testing.T) {
+	clock := sds011test.NewFakeClock(time.Unix(0, 0))
+	fake := sds011test.NewFake()
+	fake.Clock = clock
+	fake.Delay = time.Hour
+	fake.Faults = []sds011test.Fault{sds011test.Delay}
+	sensor := NewSensor(fake)
+
+	done := async(sensor.Sleep)
+	waitFor(t, "the reply to be delayed", func() bool { return clock.Waiters() == 1 })
+	sensor.Close()
+	if err := wait(t, "Sleep", done); err == nil {
+		t.Error("Sleep on a closed sensor: no error")
+	}
+}
+
+func TestCloseDuringGet(t *testing.T) {
+	// In query mode, nothing comes unless asked for.
+	sensor := NewSensor(sds011test.NewFake())
+	done := async(func() error {
+		_, err := sensor.Get()
+		return err
+	})
+	time.Sleep(10 * time.Millisecond)
+	sensor.Close()
+	if err := wait(t, "Get", done); err == nil {
+		t.Error("Get on a closed sensor: no error")
+	}
+}
+
+func TestCloseDuringQuery(t *testing.T) {
+	fake := sds011test.NewFake()
+	// The reply to the query never comes.
+	fake.Faults = []sds011test.Fault{sds011test.Truncate}
+	sensor := NewSensor(fake)
+	done := async(func() error {
+		_, err := sensor.Query()
+		return err
+	})
+	time.Sleep(10 * time.Millisecond)
+	sensor.Close()
+	if err := wait(t, "Query", done); err == nil {
+		t.Error("Query on a closed sensor: no error")
+	}
+}
+
+func TestCloseWhileBusy(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Active = true
+	sensor := NewSensor(fake)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var calls int
+	go func() {
+		defer wg.Done()
+		for {
+			var err error
+			switch calls % 4 {
+			case 0:
+				_, err = sensor.Get()
+			case 1:
+				_, err = sensor.Query()
+			case 2:
+				err = sensor.Sleep()
+			case 3:
+				err = sensor.Awake()
+			}
+			if err != nil {
+				return
+			}
+			calls++
+		}
+	}()
+	waitFor(t, "some calls", func() bool { return fake.Frames() > 100 })
+	sensor.Close()
+	// Closing twice is fine.
+	sensor.Close()
+	wg.Wait()
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	fake := sds011test.NewFake()
+	// Slow replies give the calls time to overlap.
+	fake.Delay = 100 * time.Microsecond
+	for i := 0; i < 1000; i++ {
+		fake.Faults = append(fake.Faults, sds011test.Delay)
+	}
+	sensor := NewSensor(fake)
+	defer sensor.Close()
+	// Verifying makes every setter a sequence of two commands, which
+	// must not be split by the others.
+	sensor.SetVerify(true)
+	calls := []func(i int) error{
+		func(int) error {
+			_, err := sensor.Query()
+			return err
+		},
+		func(i int) error { return sensor.SetCycle(uint8(i % 30)) },
+		func(i int) error { return sensor.SetCycle(uint8(30 - i%30)) },
+		func(int) error {
+			_, err := sensor.Cycle()
+			return err
+		},
+		func(int) error {
+			_, err := sensor.DeviceID()
+			return err
+		},
+		func(int) error { return sensor.Awake() },
+	}
+	errs := make(chan error, len(calls))
+	for _, call := range calls {
+		call := call
+		go func() {
+			for i := 0; i < 50; i++ {
+				if err := call(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for range calls {
+		if err := wait(t, "the calls", errs); err != nil {
+			t.Error(err)
+		}
+	}
+	if d := sensor.Diagnostics(); d.Discarded != 0 {
+		t.Errorf("%d frames discarded, want none", d.Discarded)
+	}
+}
+
+func TestStreamWithCalls(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Active = true
+	sensor := NewSensor(fake)
+	stream := sensor.Stream(context.Background(), StreamOptions{Overflow: Latest})
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		for range stream.C {
+		}
+	}()
+	// The calls take turns with the reads of the stream, and Sleep
+	// leaves it waiting for a measurement that won't come.
+	done := async(func() error {
+		for i := 0; i < 20; i++ {
+			if _, err := sensor.Query(); err != nil {
+				return err
+			}
+		}
+		return sensor.Sleep()
+	})
+	if err := wait(t, "the calls", done); err != nil {
+		t.Fatal(err)
+	}
+	sensor.Close()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't end after Close")
+	}
+	if stream.Err() == nil {
+		t.Error("the stream of a closed sensor ended without an error")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+func TestGetSkipsReplies(t *testing.T) {
+	ours, theirs := [2]byte{0xA1, 0x60}, [2]byte{0xB2, 0xB2}
+	extended := wire.NewExtendedMeasurement(1.5, 2.5, 3.5, ours)
+	var data []byte
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandWorkState), 1, workStateMeasuring}, ours)...)
+	data = append(data, frameFrom(0xC0, [4]byte{100, 0, 200, 0}, ours)...)
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandCycle), 1, 5}, ours)...)
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandCycle), 1, 7}, theirs)...)
+	data = extended.Append(data)
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandFirmware), 18, 11, 16}, ours)...)
+
+	for _, bound := range []bool{false, true} {
+		sensor := NewSensor(replay(data))
+		if bound {
+			if err := sensor.Bind("a160"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var replies []wire.Command
+		sensor.SetReplyHandler(func(reply wire.Response) {
+			replies = append(replies, reply.ReplyTo())
+		})
+
+		var points []Point
+		for {
+			p, err := sensor.Get()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("bound %v: Get: %v", bound, err)
+			}
+			points = append(points, *p)
+		}
+		if len(points) != 2 || points[0].PM25 != 10 || points[0].PM10 != 20 || points[0].HasPM1 ||
+			points[1].PM25 != 2.5 || points[1].PM10 != 3.5 || !points[1].HasPM1 || points[1].PM1 != 1.5 {
+			t.Errorf("bound %v: points %+v, want the two measurements", bound, points)
+		}
+		// A reply from another unit is only surfaced if the sensor
+		// isn't bound.
+		want := []wire.Command{commandWorkState, commandCycle, commandCycle, commandFirmware}
+		if bound {
+			want = []wire.Command{commandWorkState, commandCycle, commandFirmware}
+		}
+		if len(replies) != len(want) {
+			t.Fatalf("bound %v: replies to %v, want %v", bound, replies, want)
+		}
+		for i := range want {
+			if replies[i] != want[i] {
+				t.Errorf("bound %v: replies to %v, want %v", bound, replies, want)
+				break
+			}
+		}
+		if got := sensor.Diagnostics().Discarded; got != 4 {
+			t.Errorf("bound %v: %d frames discarded, want 4", bound, got)
+		}
+	}
+}
+
+func TestQuerySkipsStrayReply(t *testing.T) {
+	var data []byte
+	data = append(data, frameFrom(0xC5, [4]byte{byte(commandReportMode), 1, reportModeQuery}, [2]byte{1, 2})...)
+	data = append(data, frameFrom(0xC0, [4]byte{42, 0, 84, 0}, [2]byte{1, 2})...)
+
+	sensor := NewSensor(replay(data))
+	p, err := sensor.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PM25 != 4.2 || p.PM10 != 8.4 {
+		t.Errorf("Query: %v, want PM2.5 4.2 and PM10 8.4", p)
+	}
+}
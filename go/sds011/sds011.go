@@ -17,303 +17,446 @@
 package sds011
 
 import (
-	"bytes"
-	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
-	"github.com/jacobsa/go-serial/serial"
+	"github.com/ryszard/sds011/go/sds011/wire"
 )
 
-type command byte
-type mode byte
+type command = wire.Command
+type mode = wire.Mode
 
 const (
-	commandReportMode command = 2
-	commandQuery      command = 4
-	commandDeviceID   command = 5
-	commandWorkState  command = 6
-	commandFirmware   command = 7
-	commandCycle      command = 8
+	commandReportMode = wire.ReportMode
+	commandQuery      = wire.Query
+	commandDeviceID   = wire.DeviceID
+	commandWorkState  = wire.WorkState
+	commandFirmware   = wire.Firmware
+	commandCycle      = wire.Cycle
 
-	modeGet mode = 0
-	modeSet mode = 1
+	modeGet = wire.Get
+	modeSet = wire.Set
 
-	reportModeActive byte = 0
-	reportModeQuery  byte = 1
+	reportModeActive = wire.ReportActive
+	reportModeQuery  = wire.ReportQuery
 
-	workStateSleeping  byte = 0
-	workStateMeasuring byte = 1
+	workStateSleeping  = wire.Sleeping
+	workStateMeasuring = wire.Measuring
 )
 
-// response is what we get on the wire from the sensor. Its meaning
-// depends on what it is a reply to.
-type response struct {
-	Header   byte // always 0xAA
-	Command  byte // 0xC0 if in active mode, if reply 0xC5
-	Data     [6]byte
-	CheckSum byte
-	Tail     byte // always 0xAB
-}
-
-// IsReply returns true if this response is a reply to a command (as
-// opposed to measurements).
-func (resp *response) IsReply() bool {
-	return resp.Command == 0xC5
+// A Point represents a single reading from the sensor.
+type Point struct {
+	PM25      float64
+	PM10      float64
+	Timestamp time.Time
+	// PM1 is the PM1.0 reading, if HasPM1 is true. Only some clones
+	// of the SDS011 measure it; the original doesn't.
+	PM1    float64
+	HasPM1 bool
+	// Quality says how far the reading can be trusted.
+	Quality Quality
 }
 
-// PM25 returns the sensor's PM2.5 reading. It will panic if this
-// isn't a reply containing the readings.
-func (resp *response) PM25() float64 {
-	if resp.IsReply() {
-		panic(fmt.Sprintf("access to field that doesn't work with this type of response %#v", resp))
+func (point *Point) String() string {
+	if point.HasPM1 {
+		return fmt.Sprintf("PM1.0: %v μg/m³ PM2.5: %v μg/m³ PM10: %v μg/m³", point.PM1, point.PM25, point.PM10)
 	}
-	return float64(binary.LittleEndian.Uint16(resp.Data[0:2])) / 10.0
+	return fmt.Sprintf("PM2.5: %v μg/m³ PM10: %v μg/m³", point.PM25, point.PM10)
 }
 
-// PM10 returns the sensor's PM10 reading. It will panic if this isn't
-// a reply containing the readings.
-func (resp *response) PM10() float64 {
-	if resp.IsReply() {
-		panic(fmt.Sprintf("access to field that doesn't work with this type of response %#v", resp))
+// Sensor represents an SDS011 sensor.
+//
+// A Sensor is safe for concurrent use. Each call has the sensor to
+// itself until it returns: its request and reply, or the measurement
+// it reads, don't interleave with those of calls made at the same time
+// from other goroutines, which wait their turn. So Get and ReadPoint
+// keep other calls waiting until a measurement comes, which in query
+// mode it doesn't unless asked for, or until the read times out (see
+// SetReadTimeout). A sequence of calls, like the one MeasureAverage
+// makes, may have calls from other goroutines in between.
+//
+// Close, Tee, Port, SetReadTimeout and SetWriteTimeout don't wait.
+// Close makes a pending call return with an error.
+type Sensor struct {
+	rwc       io.ReadWriteCloser
+	tap       *tap
+	deadlines *deadlines
+	frames    *frameReader
+
+	// mu is held by calls for as long as they talk to the sensor, and
+	// guards the rest.
+	mu       sync.Mutex
+	observer Observer
+	// req and resp are reused for every request and response, so
+	// that talking to the sensor doesn't allocate.
+	req  [wire.RequestSize]byte
+	resp wire.Response
+	// capabilities are nil until the firmware version is known.
+	capabilities *Capabilities
+	// verify is whether setters read the setting back.
+	verify bool
+	// stamps say how points are stamped.
+	stamps TimestampOptions
+	// discarded is how many frames were skipped, as they weren't
+	// what was being read.
+	discarded int
+	// onReply, if not nil, is called with the frames ReadPoint skips
+	// because they aren't measurements.
+	onReply func(reply wire.Response)
+	// id is the device ID of the unit the sensor is bound to, if
+	// bound is set.
+	id    [2]byte
+	bound bool
+	// port is the identity of the port, if the sensor opened it.
+	port PortInfo
+	// autoWake is whether commands wake a sleeping sensor up, and
+	// waking whether one is doing that.
+	autoWake, waking bool
+	// warmup is how long the sensor needs to warm up, or 0 for
+	// DefaultWarmup; wokeAt is when it was woken up, if it's known to
+	// be awake since; and lastPoint are the diagnostics as of the
+	// last point read.
+	warmup    time.Duration
+	wokeAt    time.Time
+	lastPoint Diagnostics
+}
+
+// Bind binds the sensor to the unit with the given device ID, as
+// returned by DeviceID, for when several units share a line: commands
+// are addressed to that unit only, and replies and measurements from
+// other units are skipped. An empty id unbinds the sensor.
+func (sensor *Sensor) Bind(id string) error {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	if id == "" {
+		sensor.bound = false
+		return nil
+	}
+	b, err := parseDeviceID(id)
+	if err != nil {
+		return err
 	}
-
-	return float64(binary.LittleEndian.Uint16(resp.Data[2:4])) / 10.0
+	sensor.id, sensor.bound = b, true
+	return nil
 }
 
-func (resp *response) checkMatches(cmd command) {
-	if resp.Data[0] != byte(cmd) {
-		panic(fmt.Sprintf("access to field that doesn't work with this type of response %#v", resp))
+// parseDeviceID parses a device ID as DeviceID returns it.
+func parseDeviceID(id string) ([2]byte, error) {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != 2 {
+		return [2]byte{}, fmt.Errorf("bad device ID %q, want 4 hex digits", id)
 	}
+	return [2]byte{b[0], b[1]}, nil
 }
 
-// ID returns the response's device ID.
-func (resp *response) ID() uint16 {
-	if resp.IsReply() {
-		panic(fmt.Sprintf("access to field that doesn't work with this type of response %#v", resp))
+// foreign returns true if the sensor is bound, and resp comes from
+// another unit.
+func (sensor *Sensor) foreign(resp *wire.Response) bool {
+	if sensor.bound && (resp.Data[4] != sensor.id[0] || resp.Data[5] != sensor.id[1]) {
+		if log.V(2) {
+			log.Infof("skipping a frame from device %02x%02x, bound to %02x%02x", resp.Data[4], resp.Data[5], sensor.id[0], sensor.id[1])
+		}
+		return true
 	}
-
-	return binary.LittleEndian.Uint16(resp.Data[4:6])
+	return false
 }
 
-// Firmware returns the version of firmware, as a date (yy-mm-dd). It
-// will panic if this is the wrong kind of response.
-func (resp *response) Firmware() string {
-	resp.checkMatches(commandFirmware)
-	return fmt.Sprintf("%02d-%02d-%02d", resp.Data[1], resp.Data[2], resp.Data[3])
+func (sensor *Sensor) send(cmd command, mod mode, data byte) error {
+	return sensor.sendRequest(wire.NewRequest(cmd, mod, data))
 }
 
-// DeviceID returns the device id. It will panic if this is the
-func (resp *response) DeviceID() string {
-	resp.checkMatches(commandDeviceID)
-	return fmt.Sprintf("%02d%02d", resp.Data[1], resp.Data[2])
+func (sensor *Sensor) sendRequest(req wire.Request) error {
+	if sensor.bound {
+		req.DeviceID = sensor.id
+	}
+	req.Encode(&sensor.req)
+	if log.V(6) {
+		log.Infof("sending bytes: %#v", sensor.req)
+	}
+	_, err := sensor.rwc.Write(sensor.req[:])
+	return err
 }
 
-func (resp *response) ReportMode() byte {
-	resp.checkMatches(commandReportMode)
-	return resp.Data[2]
+// receive reads one response from the wire. The response is only
+// valid until the next one is received.
+func (sensor *Sensor) receive() (*wire.Response, error) {
+	if err := sensor.frames.next(&sensor.resp); err != nil {
+		return nil, err
+	}
+	return &sensor.resp, nil
 }
 
-func (resp *response) Cycle() uint8 {
-	resp.checkMatches(commandCycle)
-	return resp.Data[2]
+// receiveReply reads the reply to cmd, skipping measurements, replies
+// to other commands, and replies from other units if the sensor is
+// bound.
+func (sensor *Sensor) receiveReply(cmd command) (ReplyFrame, error) {
+	for i := 0; i < 10; i++ {
+		resp, err := sensor.receive()
+		if err != nil {
+			return ReplyFrame{}, err
+		}
+		switch {
+		case !resp.IsReply():
+			log.V(6).Infof("received data, but not a reply: %#v", resp)
+		case resp.Data[0] != byte(cmd):
+			log.V(6).Infof("received a reply to command %d: %#v", resp.Data[0], resp)
+		case sensor.foreign(resp):
+		default:
+			return ReplyFrame{*resp}, nil
+		}
+		sensor.discarded++
+	}
+	return ReplyFrame{}, ErrNoReply
 }
 
-func (resp *response) WorkState() byte {
-	resp.checkMatches(commandWorkState)
-	return resp.Data[2]
-}
+// ErrNoReply is returned when the sensor sends other frames instead of
+// the reply to a command.
+var ErrNoReply = errors.New("sds011: no reply")
 
-type request struct {
-	Header     byte     // 1 always 0xAA
-	SendMarker byte     // 2 always 0xB4
-	Command    byte     // 3 command
-	Mode       byte     // 4 getting 0, setting 1
-	Data       [11]byte // 5-15
-	DeviceID   [2]byte  // 16-17 0xFFFF for all device IDs.
-	CheckSum   byte     // 18 See makeRequest
-	Tail       byte     // 19 always 0xAB
+// An Observer is notified about the commands executed by a sensor,
+// for example to collect metrics or traces.
+type Observer interface {
+	// ObserveCommand is called after a command completes. err is
+	// nil if it succeeded.
+	ObserveCommand(command string, start time.Time, duration time.Duration, err error)
 }
 
-func makeRequest(cmd command, mod mode, value byte) *request {
-	data := [11]byte{}
-	data[0] = value
+// SetObserver makes the sensor report the commands it executes to o.
+// Passing nil stops reporting. o is called while the command still has
+// the sensor to itself, so it mustn't call the sensor's methods.
+func (sensor *Sensor) SetObserver(o Observer) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.observer = o
+}
 
-	req := &request{
-		Header:     0xAA,
-		SendMarker: 0xB4,
-		Command:    byte(cmd),
-		Mode:       byte(mod),
-		Data:       data,
-		DeviceID:   [2]byte{0xFF, 0xFF},
-		Tail:       0xAB,
-	}
-	checksum := int(req.Command) + int(req.Mode)
-	for _, v := range data {
-		checksum += int(v)
+// observe reports a command to the observer, if there is one.
+func (sensor *Sensor) observe(name string, start time.Time, err error) {
+	if sensor.observer != nil {
+		sensor.observer.ObserveCommand(name, start, time.Since(start), err)
 	}
-	for _, v := range req.DeviceID {
-		checksum += int(v)
-	}
-	req.CheckSum = byte(checksum % 256)
-	return req
 }
 
-// IsCorrect returns nil if the responses checksum matches, an error
-// otherwise.
-func (resp *response) IsCorrect() error {
-
-	var checkSum byte
-	for i := 0; i < 6; i++ {
-		checkSum += resp.Data[i]
+// command sends a command to the sensor and waits for its reply.
+func (sensor *Sensor) command(name string, cmd command, mod mode, data byte) (reply ReplyFrame, err error) {
+	start := time.Now()
+	defer func() { sensor.observe(name, start, err) }()
+	reply, err = sensor.exchange(cmd, mod, data)
+	if err != nil && cmd != commandWorkState {
+		err = sensor.retryAwake(err, func() error {
+			var err error
+			reply, err = sensor.exchange(cmd, mod, data)
+			return err
+		})
 	}
-
-	if checkSum != resp.CheckSum {
-		return fmt.Errorf("bad checksum: %#v", resp)
+	if err != nil {
+		return ReplyFrame{}, err
 	}
-	return nil
+	log.V(6).Infof("%v response: %#v", name, reply.resp)
+	return reply, nil
 }
 
-// A Point represents a single reading from the sensor.
-type Point struct {
-	PM25      float64
-	PM10      float64
-	Timestamp time.Time
+// exchange sends a command to the sensor and receives its reply.
+func (sensor *Sensor) exchange(cmd command, mod mode, data byte) (ReplyFrame, error) {
+	if err := sensor.send(cmd, mod, data); err != nil {
+		return ReplyFrame{}, err
+	}
+	return sensor.receiveReply(cmd)
 }
 
-func (point *Point) String() string {
-	return fmt.Sprintf("PM2.5: %v μg/m³ PM10: %v μg/m³", point.PM25, point.PM10)
-}
+// A ReportMode is how the sensor reports its measurements.
+type ReportMode int
 
-// Sensor represents an SDS011 sensor.
-type Sensor struct {
-	rwc io.ReadWriteCloser
-}
+const (
+	// ActiveMode makes the sensor report every measurement on its
+	// own, to be read with Get.
+	ActiveMode ReportMode = iota
+	// QueryMode makes the sensor measure only when asked, with
+	// Query.
+	QueryMode
+)
 
-func (sensor *Sensor) send(cmd command, mod mode, data byte) error {
-	b := new(bytes.Buffer)
-	if err := binary.Write(b, binary.LittleEndian, makeRequest(cmd, mod, data)); err != nil {
-		return err
+func (mode ReportMode) String() string {
+	switch mode {
+	case ActiveMode:
+		return "active"
+	case QueryMode:
+		return "query"
 	}
-	log.V(6).Infof("sending bytes: %#v", b.Bytes())
-	_, err := sensor.rwc.Write(b.Bytes())
-	return err
+	return fmt.Sprintf("ReportMode(%d)", int(mode))
 }
 
-// receive reads one response from the wire.
-func (sensor *Sensor) receive() (*response, error) {
-	data := new(response)
-	if err := binary.Read(sensor.rwc, binary.LittleEndian, data); err != nil {
-		return nil, err
-	}
-	if err := data.IsCorrect(); err != nil {
-		return nil, err
+// ParseReportMode parses a report mode as String formats it.
+func ParseReportMode(s string) (ReportMode, error) {
+	switch s {
+	case "active":
+		return ActiveMode, nil
+	case "query":
+		return QueryMode, nil
 	}
-	return data, nil
+	return 0, fmt.Errorf("bad report mode %q, want active or query", s)
 }
 
-func (sensor *Sensor) receiveReply() (*response, error) {
-	// FIXME(ryszard): This should support timeouts.
-	for i := 0; i < 10; i++ {
-		resp, err := sensor.receive()
-		if err != nil {
-			return nil, err
-		}
-		if resp.IsReply() {
-			return resp, nil
-		}
-		log.V(6).Infof("received data, but not a reply: %#v", resp)
-	}
-	return nil, errors.New("no reply")
-
+// ReportMode returns the report mode of the sensor.
+func (sensor *Sensor) ReportMode() (ReportMode, error) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.reportMode()
 }
 
-// ReportMode returns true if the device is in active mode, false if
-// in query mode.
-func (sensor *Sensor) ReportMode() (bool, error) {
-	if err := sensor.send(commandReportMode, modeGet, 0); err != nil {
-		return false, err
-	}
-	data, err := sensor.receiveReply()
+func (sensor *Sensor) reportMode() (ReportMode, error) {
+	reply, err := sensor.command("ReportMode", commandReportMode, modeGet, 0)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	log.V(6).Infof("ReportMode response: %#v", data)
-	return data.ReportMode() == reportModeActive, nil
+	return reply.ReportMode()
 }
 
-// MakeActive makes the sensor actively report its measurements.
-func (sensor *Sensor) MakeActive() error {
-	if err := sensor.send(commandReportMode, modeSet, reportModeActive); err != nil {
-		return err
+// SetReportMode sets the report mode of the sensor. Only some
+// firmware keeps it across sleeping (see ConfirmReportMode).
+func (sensor *Sensor) SetReportMode(mode ReportMode) error {
+	data := byte(reportModeActive)
+	switch mode {
+	case ActiveMode:
+	case QueryMode:
+		data = reportModeQuery
+	default:
+		return fmt.Errorf("sds011: bad report mode %v", mode)
 	}
-	data, err := sensor.receiveReply()
-	if err != nil {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	if _, err := sensor.command("SetReportMode", commandReportMode, modeSet, data); err != nil {
 		return err
 	}
-	log.V(6).Infof("MakeActive: %#v", data)
-	return nil
+	return sensor.verifyReportMode(mode)
 }
 
-// MakePassive stop the sensor from actively reporting its
-// measurements. You will need to send a Query command.
-func (sensor *Sensor) MakePassive() error {
-	log.V(6).Infof("make passive")
-	if err := sensor.send(commandReportMode, modeSet, reportModeQuery); err != nil {
+// ConfirmReportMode checks that the sensor is in the report mode mode,
+// and stays in it across a sleep: it puts the sensor to sleep, wakes
+// it up and reads the mode back, returning a *MismatchError if it
+// changed. A sensor that was asleep is put back to sleep afterwards.
+// It returns ErrUnsupported for firmware that doesn't keep its settings.
+func (sensor *Sensor) ConfirmReportMode(mode ReportMode) error {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	awake, err := sensor.isAwake()
+	if err != nil {
+		return err
+	}
+	if awake {
+		if err := sensor.supports(persistentSettings); err != nil {
+			return err
+		}
+		if err := sensor.setAwake(false); err != nil {
+			return err
+		}
+	}
+	if err := sensor.setAwake(true); err != nil {
 		return err
 	}
-	data, err := sensor.receiveReply()
+	if !awake {
+		// Asleep, it couldn't be asked for its firmware version.
+		if err := sensor.supports(persistentSettings); err != nil {
+			sensor.setAwake(false)
+			return err
+		}
+	}
+	got, err := sensor.reportMode()
 	if err != nil {
 		return err
 	}
-	log.V(6).Infof("MakePassive response: %#v", data)
-	return nil
+	if !awake {
+		if err := sensor.setAwake(false); err != nil {
+			return err
+		}
+	}
+	return check("report mode", mode.String(), got.String())
 }
 
 // DeviceID returns the sensor's device ID.
 func (sensor *Sensor) DeviceID() (string, error) {
-	if err := sensor.send(commandDeviceID, modeGet, 0); err != nil {
-		return "", err
-	}
-	data, err := sensor.receiveReply()
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	reply, err := sensor.command("DeviceID", commandDeviceID, modeGet, 0)
 	if err != nil {
 		return "", err
 	}
-	log.V(6).Infof("DeviceID: %#v", data)
-	return data.DeviceID(), nil
+	return reply.DeviceID(), nil
+}
 
+// SetDeviceID changes the device ID of the unit to id, 4 hex digits
+// like those DeviceID returns, for example to give a replacement the
+// ID of the unit it replaces. A sensor bound to the unit (see Bind)
+// stays bound to it, under its new ID.
+func (sensor *Sensor) SetDeviceID(id string) (err error) {
+	newID, err := parseDeviceID(id)
+	if err != nil {
+		return err
+	}
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	start := time.Now()
+	defer func() { sensor.observe("SetDeviceID", start, err) }()
+	req := wire.NewRequest(commandDeviceID, modeGet, 0)
+	req.Data[9], req.Data[10] = newID[0], newID[1]
+	if err := sensor.sendRequest(req); err != nil {
+		return err
+	}
+	// The reply already comes from the new ID.
+	oldID := sensor.id
+	sensor.id = newID
+	reply, err := sensor.receiveReply(commandDeviceID)
+	if err != nil {
+		sensor.id = oldID
+		return err
+	}
+	return check("device ID", fmt.Sprintf("%02x%02x", newID[0], newID[1]), reply.DeviceID())
 }
 
 // Firmware returns the firmware version (a yy-mm-dd date).
 func (sensor *Sensor) Firmware() (string, error) {
-	if err := sensor.send(commandFirmware, modeGet, 0); err != nil {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.firmware()
+}
+
+func (sensor *Sensor) firmware() (string, error) {
+	reply, err := sensor.command("Firmware", commandFirmware, modeGet, 0)
+	if err != nil {
 		return "", err
 	}
-	data, err := sensor.receiveReply()
+	firmware, err := reply.Firmware()
 	if err != nil {
 		return "", err
 	}
-	log.V(6).Infof("Firmare: %#v", data)
-	return data.Firmware(), nil
-
+	c := capabilitiesOf(firmware)
+	sensor.capabilities = &c
+	return firmware, nil
 }
 
 // Cycle returns the current cycle length in minutes. If it's 0 it
 // means that cycle is not set, and the sensor is streaming data
 // continuously.
 func (sensor *Sensor) Cycle() (uint8, error) {
-	if err := sensor.send(commandCycle, modeGet, 0); err != nil {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.cycle()
+}
+
+func (sensor *Sensor) cycle() (uint8, error) {
+	if err := sensor.supports(workingPeriod); err != nil {
 		return 0, err
 	}
-	data, err := sensor.receiveReply()
+	reply, err := sensor.command("Cycle", commandCycle, modeGet, 0)
 	if err != nil {
 		return 0, err
 	}
-	log.V(6).Infof("Cycle: %#v", data)
-	return data.Cycle(), nil
+	return reply.Cycle()
 }
 
 // SetCycle sets the cycle length. The value is the cycle's length in
@@ -323,62 +466,93 @@ func (sensor *Sensor) SetCycle(value uint8) error {
 	if value < 0 || value > 30 {
 		return fmt.Errorf("duty cycle: bad value %v. Should be between 0 and 30.", value)
 	}
-	if err := sensor.send(commandCycle, modeSet, value); err != nil {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	if err := sensor.supports(workingPeriod); err != nil {
 		return err
 	}
-	data, err := sensor.receiveReply()
-	if err != nil {
+	if _, err := sensor.command("SetCycle", commandCycle, modeSet, value); err != nil {
 		return err
 	}
-	log.V(6).Infof("SetCycle: %#v", data)
-	return nil
+	return sensor.verifyCycle(value)
 }
 
 // Query returns one reading.
 func (sensor *Sensor) Query() (*Point, error) {
-	if err := sensor.send(commandQuery, modeGet, 0); err != nil {
+	point := new(Point)
+	if err := sensor.QueryPoint(point); err != nil {
 		return nil, err
 	}
-	return sensor.Get()
+	return point, nil
+}
+
+// QueryPoint is like Query, but reads the measurement into point,
+// without allocating, like ReadPoint.
+func (sensor *Sensor) QueryPoint(point *Point) (err error) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	start := time.Now()
+	defer func() { sensor.observe("Query", start, err) }()
+	if err := sensor.queryPoint(point); err != nil {
+		return sensor.retryAwake(err, func() error { return sensor.queryPoint(point) })
+	}
+	return nil
+}
+
+func (sensor *Sensor) queryPoint(point *Point) error {
+	if err := sensor.send(commandQuery, modeGet, 0); err != nil {
+		return err
+	}
+	return sensor.readPoint(point)
 }
 
 // IsAwake returns true if the sensor is awake.
 func (sensor *Sensor) IsAwake() (bool, error) {
-	if err := sensor.send(commandWorkState, modeGet, 0); err != nil {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.isAwake()
+}
+
+func (sensor *Sensor) isAwake() (bool, error) {
+	reply, err := sensor.command("IsAwake", commandWorkState, modeGet, 0)
+	if err != nil {
 		return false, err
 	}
-	data, err := sensor.receiveReply()
+	awake, err := reply.Awake()
 	if err != nil {
 		return false, err
 	}
-	log.V(6).Infof("IsAwake WorkState: %#v", data)
-	return data.WorkState() == workStateMeasuring, nil
+	if !awake {
+		sensor.woke(false)
+	}
+	return awake, nil
 }
 
 // Awake awakes the sensor if it is in sleep mode.
 func (sensor *Sensor) Awake() error {
-	if err := sensor.send(commandWorkState, modeSet, workStateMeasuring); err != nil {
-		return err
-	}
-	data, err := sensor.receiveReply()
-	if err != nil {
-		return err
-	}
-	log.V(6).Infof("Awake WorkState: %#v", data)
-	return nil
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.setAwake(true)
 }
 
 // Sleep puts the sensor to sleep.
 func (sensor *Sensor) Sleep() error {
-	if err := sensor.send(commandWorkState, modeSet, workStateSleeping); err != nil {
-		return err
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.setAwake(false)
+}
+
+// setAwake wakes the sensor up or puts it to sleep.
+func (sensor *Sensor) setAwake(awake bool) error {
+	name, state := "Sleep", workStateSleeping
+	if awake {
+		name, state = "Awake", workStateMeasuring
 	}
-	data, err := sensor.receiveReply()
-	if err != nil {
+	if _, err := sensor.command(name, commandWorkState, modeSet, state); err != nil {
 		return err
 	}
-	log.V(6).Infof("WorkState: %#v", data)
-	return nil
+	sensor.woke(awake)
+	return sensor.verifyAwake(awake)
 }
 
 // Close closes the underlying serial port.
@@ -389,36 +563,130 @@ func (sensor *Sensor) Close() {
 // New returns a sensor that will read data from serial port for which
 // the path was provided. It is the responsibility of the caller to
 // close the sensor.
+//
+// The path is resolved first, and the device it leads to and its
+// stable name in /dev/serial/by-id, if it has one, are available from
+// Port.
 func New(portPath string) (*Sensor, error) {
-	options := serial.OpenOptions{
-		PortName:        portPath,
-		BaudRate:        9600,
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: 4,
-	}
+	return NewWithOptions(portPath, PortOptions{})
+}
 
-	port, err := serial.Open(options)
+// NewWithOptions is like New, but opens the port with options.
+func NewWithOptions(portPath string, options PortOptions) (*Sensor, error) {
+	info, err := ResolvePort(portPath)
 	if err != nil {
 		return nil, err
 	}
-	return NewSensor(port), nil
+	port, err := OpenPortWithOptions(portPath, options)
+	if err != nil {
+		return nil, err
+	}
+	sensor := NewSensor(port)
+	sensor.port = info
+	sensor.SetReadTimeout(options.ReadTimeout)
+	sensor.SetWriteTimeout(options.WriteTimeout)
+	return sensor, nil
+}
+
+// OpenPort opens the serial port for which the path was provided with
+// the settings the SDS011 uses, for use with NewSensor. If it doesn't
+// exist or may not be opened, the error is a *PortError.
+func OpenPort(portPath string) (io.ReadWriteCloser, error) {
+	return OpenPortWithOptions(portPath, PortOptions{})
 }
 
 // NewSensor returns a sensor that will read its data from the provided
 // read-write-closer.
 func NewSensor(rwc io.ReadWriteCloser) *Sensor {
-	return &Sensor{rwc: rwc}
+	d := &deadlines{rwc: rwc}
+	t := &tap{rwc: d}
+	return &Sensor{rwc: t, tap: t, deadlines: d, frames: newFrameReader(t)}
 }
 
 // Get will read one measurement. It will block until data is
 // available. It only makes sense to call read if the sensor is in
 // active mode.
 func (sensor *Sensor) Get() (point *Point, err error) {
+	point = new(Point)
+	if err := sensor.ReadPoint(point); err != nil {
+		return nil, err
+	}
+	return point, nil
+}
+
+// ReadPoint is like Get, but reads the measurement into point. Unlike
+// Get it doesn't allocate, so it's better suited for reading at a high
+// rate.
+//
+// Frames that aren't measurements, like replies to commands that came
+// too late, are skipped (see SetReplyHandler).
+func (sensor *Sensor) ReadPoint(point *Point) error {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.readPoint(point)
+}
+
+func (sensor *Sensor) readPoint(point *Point) error {
 	data, err := sensor.receive()
+	for err == nil && sensor.skip(data) {
+		sensor.discarded++
+		data, err = sensor.receive()
+	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if log.V(6) {
+		log.Infof("Query data: %#v", *data)
+	}
+	m := MeasurementFrame{*data}
+	m.read(point)
+	point.Timestamp = sensor.stamp()
+	point.Quality = sensor.quality(point.Timestamp)
+	return nil
+}
+
+// skip returns true if resp isn't a measurement of the unit the
+// sensor is bound to, if any, and should be skipped by ReadPoint.
+func (sensor *Sensor) skip(resp *wire.Response) bool {
+	if sensor.foreign(resp) {
+		return true
 	}
-	log.V(6).Infof("Query data: %#v", data)
-	return &Point{PM25: data.PM25(), PM10: data.PM10(), Timestamp: time.Now()}, nil
+	if resp.IsMeasurement() {
+		return false
+	}
+	if log.V(2) {
+		log.Infof("skipping a frame that isn't a measurement: % x", resp.Append(nil))
+	}
+	if sensor.onReply != nil {
+		sensor.onReply(*resp)
+	}
+	return true
+}
+
+// SetReplyHandler makes Get and ReadPoint call f with every frame they
+// skip because it isn't a measurement, which is normally a reply to a
+// command that came too late, or that was sent by another program.
+// FrameOf makes a *ReplyFrame of it. Passing nil stops it. Like an
+// Observer, f mustn't call the sensor's methods.
+func (sensor *Sensor) SetReplyHandler(f func(reply wire.Response)) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.onReply = f
+}
+
+// DecodePoint decodes a measurement as the sensor sends it on the wire,
+// 10 bytes from the 0xAA header to the 0xAB tail, or 12 for the
+// extended measurements of clones that measure PM1.0, into point. It
+// doesn't touch point's timestamp.
+func DecodePoint(frame []byte, point *Point) error {
+	var resp wire.Response
+	if err := wire.DecodeResponse(frame, &resp); err != nil {
+		return fmt.Errorf("%w: % x", err, frame)
+	}
+	if !resp.IsMeasurement() {
+		return fmt.Errorf("not a measurement: % x", frame)
+	}
+	point.PM25, point.PM10 = resp.PM25(), resp.PM10()
+	point.PM1, point.HasPM1 = resp.PM1(), resp.HasPM1()
+	return nil
 }
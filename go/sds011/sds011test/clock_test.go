@@ -0,0 +1,54 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011test
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFakeClock(t0)
+	now := c.After(0)
+	second, minute := c.After(time.Second), c.After(time.Minute)
+	if got := <-now; !got.Equal(t0) {
+		t.Errorf("After(0): %v, want %v", got, t0)
+	}
+	if c.Waiters() != 2 {
+		t.Errorf("%d waiters, want 2", c.Waiters())
+	}
+	c.Advance(30 * time.Second)
+	select {
+	case got := <-second:
+		if want := t0.Add(30 * time.Second); !got.Equal(want) {
+			t.Errorf("After(1s): %v, want %v", got, want)
+		}
+	default:
+		t.Error("After(1s) didn't fire 30s later")
+	}
+	select {
+	case got := <-minute:
+		t.Errorf("After(1m) fired at %v", got)
+	default:
+	}
+	if c.Waiters() != 1 || !c.Now().Equal(t0.Add(30*time.Second)) {
+		t.Errorf("%d waiters at %v, want 1 at %v", c.Waiters(), c.Now(), t0.Add(30*time.Second))
+	}
+	c.Advance(30 * time.Second)
+	if got := <-minute; !got.Equal(t0.Add(time.Minute)) {
+		t.Errorf("After(1m): %v", got)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sds011test provides a fake SDS011 for testing code that
+// talks to one.
+//
+// The fake is a port for sds011.NewSensor. It answers commands the way
+// a sensor does, always measures the same levels, and can be told to
+// misbehave on particular frames, so that tests are deterministic:
+//
+//	fake := sds011test.NewFake()
+//	fake.Faults = []sds011test.Fault{sds011test.None, sds011test.Corrupt}
+//	sensor := sds011.NewSensor(fake)
+//	sensor.SetReportMode(sds011.QueryMode) // the reply is fine
+//	sensor.Query()                         // but this gets a bad checksum
+package sds011test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// A Fault is a way a frame sent by the fake can go wrong.
+type Fault int
+
+const (
+	// None means the frame is sent as it should be.
+	None Fault = iota
+	// Corrupt sends the frame with a bad checksum.
+	Corrupt
+	// Truncate sends only the first half of the frame.
+	Truncate
+	// Delay sends the frame after the fake's Delay.
+	Delay
+	// Spurious sends a reply to some other command before the frame.
+	Spurious
+	// EOF hangs up instead of sending the frame: from then on, reads
+	// return io.EOF.
+	EOF
+)
+
+// Fake is a fake SDS011. Its fields may be changed before it's first
+// used.
+type Fake struct {
+	// ID is the device ID.
+	ID [2]byte
+	// Firmware is the firmware version: year, month and day.
+	Firmware [3]byte
+	// PM25 and PM10 are the levels the fake measures, in µg/m³.
+	PM25, PM10 float64
+	// PM1 is the PM1.0 level, in µg/m³. If it isn't zero, the fake
+	// is a clone that sends extended measurements.
+	PM1 float64
+	// Active is whether the fake is in active mode, in which it
+	// sends a measurement whenever it's read from and has nothing
+	// else to send.
+	Active bool
+	// Awake is whether the fake is measuring.
+	Awake bool
+	// Cycle is the working period, in minutes.
+	Cycle byte
+	// IgnoreSettings makes the fake acknowledge settings without
+	// applying them, as some firmware versions do.
+	IgnoreSettings bool
+	// ForgetReportMode makes the fake go back to active mode when
+	// it wakes up, as some firmware versions do.
+	ForgetReportMode bool
+
+	// Faults are the faults of the frames the fake sends, in order:
+	// Faults[0] applies to the first frame, and so on. Frames beyond
+	// the end are sent as they should be. Spurious replies don't
+	// count as frames.
+	Faults []Fault
+	// Delay is how long frames with the Delay fault are held back.
+	Delay time.Duration
+	// ReadTimeout, if set, makes reads that wait longer than it for
+	// something to send fail with os.ErrDeadlineExceeded, like
+	// those of a port with a deadline.
+	ReadTimeout time.Duration
+	// Clock is what delays are measured with. If it's nil, they
+	// are real time.
+	Clock Clock
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// done is closed when the fake is.
+	done chan struct{}
+	// in are the bytes written to the fake that aren't a full
+	// request yet, and out are the chunks to be read from it.
+	in     []byte
+	out    []chunk
+	frames int
+	eof    bool
+	closed bool
+}
+
+// chunk is something to be read from the fake.
+type chunk struct {
+	data  []byte
+	delay time.Duration
+}
+
+// NewFake returns an awake fake in query mode, with ID A160 and
+// firmware 18-11-16, measuring PM2.5 10 µg/m³ and PM10 20 µg/m³.
+func NewFake() *Fake {
+	return &Fake{
+		ID:       [2]byte{0xA1, 0x60},
+		Firmware: [3]byte{18, 11, 16},
+		PM25:     10,
+		PM10:     20,
+		Awake:    true,
+	}
+}
+
+// init must be called with the lock held.
+func (f *Fake) init() {
+	if f.cond == nil {
+		f.cond = sync.NewCond(&f.mu)
+		f.done = make(chan struct{})
+	}
+}
+
+// Write implements io.Writer. Complete requests are answered at once;
+// anything that isn't a request is ignored.
+func (f *Fake) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.init()
+	if f.closed {
+		return 0, errors.New("sds011test: write to closed fake")
+	}
+	f.in = append(f.in, b...)
+	for {
+		for len(f.in) > 0 && f.in[0] != wire.Header {
+			f.in = f.in[1:]
+		}
+		if len(f.in) < wire.RequestSize {
+			break
+		}
+		var req wire.Request
+		if wire.DecodeRequest(f.in[:wire.RequestSize], &req) != nil {
+			f.in = f.in[1:]
+			continue
+		}
+		f.in = f.in[wire.RequestSize:]
+		f.handle(&req)
+	}
+	f.cond.Broadcast()
+	return len(b), nil
+}
+
+// handle answers a request. It must be called with the lock held.
+func (f *Fake) handle(req *wire.Request) {
+	cmd, set, value := req.Command, req.Mode == wire.Set, req.Data[0]
+	apply := set && !f.IgnoreSettings
+	if req.DeviceID != wire.Broadcast && req.DeviceID != f.ID {
+		return
+	}
+	if !f.Awake && cmd != wire.WorkState {
+		return
+	}
+	switch cmd {
+	case wire.ReportMode:
+		if apply {
+			f.Active = value == 0
+		}
+		f.reply(cmd, boolByte(set), boolByte(!f.Active), 0)
+	case wire.Query:
+		f.send(f.measurement())
+	case wire.DeviceID:
+		if newID := [2]byte{req.Data[9], req.Data[10]}; newID != [2]byte{} {
+			f.ID = newID
+		}
+		f.reply(cmd, 0, 0, 0)
+	case wire.WorkState:
+		if apply {
+			if value == 1 && !f.Awake && f.ForgetReportMode {
+				f.Active = true
+			}
+			f.Awake = value == 1
+		}
+		f.reply(cmd, boolByte(set), boolByte(f.Awake), 0)
+	case wire.Firmware:
+		f.reply(cmd, f.Firmware[0], f.Firmware[1], f.Firmware[2])
+	case wire.Cycle:
+		if apply && value <= 30 {
+			f.Cycle = value
+		}
+		f.reply(cmd, boolByte(set), f.Cycle, 0)
+	}
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (f *Fake) reply(cmd wire.Command, a, b, c byte) {
+	f.send(frame(wire.NewReply(cmd, a, b, c, f.ID)))
+}
+
+func (f *Fake) measurement() []byte {
+	if f.PM1 != 0 {
+		return frame(wire.NewExtendedMeasurement(f.PM1, f.PM25, f.PM10, f.ID))
+	}
+	return frame(wire.NewMeasurement(f.PM25, f.PM10, f.ID))
+}
+
+func frame(resp wire.Response) []byte {
+	return resp.Append(nil)
+}
+
+// send queues a frame to be read, applying its fault. It must be
+// called with the lock held.
+func (f *Fake) send(b []byte) {
+	fault := None
+	if f.frames < len(f.Faults) {
+		fault = f.Faults[f.frames]
+	}
+	f.frames++
+	c := chunk{data: b}
+	switch fault {
+	case Corrupt:
+		b[len(b)-2]++
+	case Truncate:
+		c.data = b[:len(b)/2]
+	case Delay:
+		c.delay = f.Delay
+	case Spurious:
+		other := wire.Firmware
+		if b[1] == wire.Reply && wire.Command(b[2]) == wire.Firmware {
+			other = wire.WorkState
+		}
+		f.out = append(f.out, chunk{data: frame(wire.NewReply(other, 0, 1, 0, f.ID))})
+	case EOF:
+		f.eof = true
+		return
+	}
+	f.out = append(f.out, c)
+}
+
+// Frames returns the number of frames the fake has sent, not counting
+// spurious replies.
+func (f *Fake) Frames() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.frames
+}
+
+// Read implements io.Reader. If there's nothing to read, it blocks
+// until there is, or the fake is closed. It may be called concurrently
+// with Close.
+func (f *Fake) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.init()
+	expired := false
+	if f.ReadTimeout > 0 {
+		t := time.AfterFunc(f.ReadTimeout, func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			expired = true
+			f.cond.Broadcast()
+		})
+		defer t.Stop()
+	}
+	for {
+		if len(f.out) > 0 {
+			break
+		}
+		if f.eof || f.closed {
+			return 0, io.EOF
+		}
+		if expired {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if f.Active && f.Awake {
+			f.send(f.measurement())
+			continue
+		}
+		f.cond.Wait()
+	}
+	if delay := f.out[0].delay; delay > 0 {
+		f.out[0].delay = 0
+		clock := f.Clock
+		if clock == nil {
+			clock = realClock{}
+		}
+		f.mu.Unlock()
+		select {
+		case <-clock.After(delay):
+		case <-f.done:
+		}
+		f.mu.Lock()
+		if f.closed {
+			return 0, io.EOF
+		}
+	}
+	c := &f.out[0]
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	if len(c.data) == 0 {
+		f.out = f.out[1:]
+	}
+	return n, nil
+}
+
+// Close implements io.Closer. Blocked reads return io.EOF.
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.init()
+	if !f.closed {
+		f.closed = true
+		close(f.done)
+	}
+	f.cond.Broadcast()
+	return nil
+}
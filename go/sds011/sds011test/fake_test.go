@@ -0,0 +1,211 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011test
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011/wire"
+)
+
+// request writes req to f.
+func request(t *testing.T, f *Fake, req wire.Request) {
+	t.Helper()
+	var b [wire.RequestSize]byte
+	req.Encode(&b)
+	if _, err := f.Write(b[:]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exchange sends req to f, and returns what it answers with. It
+// expects a single frame.
+func exchange(t *testing.T, f *Fake, req wire.Request) wire.Response {
+	t.Helper()
+	request(t, f, req)
+	return readResponse(t, f)
+}
+
+func readResponse(t *testing.T, f *Fake) wire.Response {
+	t.Helper()
+	buf := make([]byte, wire.ExtendedSize)
+	n, err := f.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r wire.Response
+	if err := wire.DecodeResponse(buf[:n], &r); err != nil {
+		t.Fatalf("% x: %v", buf[:n], err)
+	}
+	return r
+}
+
+// nothingToRead checks that f has nothing to send.
+func nothingToRead(t *testing.T, f *Fake) {
+	t.Helper()
+	f.ReadTimeout = 10 * time.Millisecond
+	defer func() { f.ReadTimeout = 0 }()
+	b := make([]byte, wire.ExtendedSize)
+	if n, err := f.Read(b); err != os.ErrDeadlineExceeded {
+		t.Errorf("read % x, %v; want nothing", b[:n], err)
+	}
+}
+
+func TestFakeCommands(t *testing.T) {
+	f := NewFake()
+	for _, tc := range []struct {
+		name    string
+		req     wire.Request
+		a, b, c byte
+	}{
+		{"get report mode", wire.NewRequest(wire.ReportMode, wire.Get, 0), 0, wire.ReportQuery, 0},
+		{"set active mode", wire.NewRequest(wire.ReportMode, wire.Set, wire.ReportActive), 1, wire.ReportActive, 0},
+		{"set query mode", wire.NewRequest(wire.ReportMode, wire.Set, wire.ReportQuery), 1, wire.ReportQuery, 0},
+		{"firmware", wire.NewRequest(wire.Firmware, wire.Get, 0), 18, 11, 16},
+		{"set cycle", wire.NewRequest(wire.Cycle, wire.Set, 5), 1, 5, 0},
+		{"bad cycle", wire.NewRequest(wire.Cycle, wire.Set, 31), 1, 5, 0},
+		{"get cycle", wire.NewRequest(wire.Cycle, wire.Get, 0), 0, 5, 0},
+		{"get work state", wire.NewRequest(wire.WorkState, wire.Get, 0), 0, wire.Measuring, 0},
+	} {
+		r := exchange(t, f, tc.req)
+		if !r.IsReply() || r.ReplyTo() != tc.req.Command || r.DeviceID() != f.ID {
+			t.Errorf("%v: %+v, want a reply by %x", tc.name, r, f.ID)
+			continue
+		}
+		if got := [3]byte{r.Data[1], r.Data[2], r.Data[3]}; got != [3]byte{tc.a, tc.b, tc.c} {
+			t.Errorf("%v: % x, want % x", tc.name, got, []byte{tc.a, tc.b, tc.c})
+		}
+	}
+	if f.Frames() != 8 {
+		t.Errorf("%d frames, want 8", f.Frames())
+	}
+}
+
+func TestFakeMeasurement(t *testing.T) {
+	f := NewFake()
+	a := 0.7
+	f.PM25 = a + 0.6
+	r := exchange(t, f, wire.NewRequest(wire.Query, wire.Get, 0))
+	if !r.IsMeasurement() || r.HasPM1() || r.PM25() != 1.3 || r.PM10() != 20 {
+		t.Errorf("%+v, want a measurement of PM2.5 1.3 and PM10 20", r)
+	}
+
+	// A fake with a PM1.0 level is a clone, sending extended
+	// measurements.
+	f = NewFake()
+	f.PM1 = 4.2
+	r = exchange(t, f, wire.NewRequest(wire.Query, wire.Get, 0))
+	if !r.HasPM1() || r.PM1() != 4.2 || r.PM25() != 10 {
+		t.Errorf("%+v, want an extended measurement of PM1.0 4.2", r)
+	}
+}
+
+func TestFakeDeviceID(t *testing.T) {
+	f := NewFake()
+	other := wire.NewRequest(wire.Query, wire.Get, 0)
+	other.DeviceID = [2]byte{0xBE, 0xEF}
+	request(t, f, other)
+	nothingToRead(t, f)
+
+	set := wire.NewRequest(wire.DeviceID, wire.Set, 0)
+	set.DeviceID = f.ID
+	set.Data[9], set.Data[10] = 0xBE, 0xEF
+	if r := exchange(t, f, set); r.DeviceID() != [2]byte{0xBE, 0xEF} {
+		t.Errorf("reply by %x, want beef", r.DeviceID())
+	}
+	if r := exchange(t, f, other); !r.IsMeasurement() {
+		t.Errorf("%+v, want a measurement", r)
+	}
+}
+
+func TestFakeSleeping(t *testing.T) {
+	f := NewFake()
+	f.Awake, f.Active = false, true
+	f.ForgetReportMode = true
+
+	// A sleeping fake only answers the work state command, and
+	// doesn't report.
+	for _, cmd := range []wire.Command{wire.Query, wire.Firmware, wire.ReportMode} {
+		request(t, f, wire.NewRequest(cmd, wire.Get, 0))
+	}
+	nothingToRead(t, f)
+
+	// Put in query mode while asleep, it wakes up in active mode, as
+	// firmware that forgets its report mode does.
+	f.Active = false
+	if r := exchange(t, f, wire.NewRequest(wire.WorkState, wire.Set, wire.Measuring)); r.Data[2] != wire.Measuring {
+		t.Errorf("% x, want it measuring", r.Data)
+	}
+	if r := readResponse(t, f); !r.IsMeasurement() {
+		t.Errorf("%+v, want a measurement reported in active mode", r)
+	}
+}
+
+func TestFakeIgnoreSettings(t *testing.T) {
+	f := NewFake()
+	f.IgnoreSettings = true
+	if r := exchange(t, f, wire.NewRequest(wire.Cycle, wire.Set, 5)); r.Data[1] != 1 || r.Data[2] != 0 {
+		t.Errorf("% x, want the setting acknowledged, and the cycle still 0", r.Data)
+	}
+	if f.Cycle != 0 {
+		t.Errorf("cycle %d, want 0", f.Cycle)
+	}
+}
+
+func TestFakeClose(t *testing.T) {
+	f := NewFake()
+	errs := make(chan error)
+	go func() {
+		_, err := f.Read(make([]byte, 1))
+		errs <- err
+	}()
+	f.Close()
+	if err := <-errs; err != io.EOF {
+		t.Errorf("blocked Read: %v, want EOF", err)
+	}
+	if _, err := f.Write([]byte{wire.Header}); err == nil {
+		t.Error("Write after Close: no error")
+	}
+}
+
+func TestFakeDelay(t *testing.T) {
+	f := NewFake()
+	clock := NewFakeClock(time.Unix(0, 0))
+	f.Clock, f.Delay = clock, time.Minute
+	f.Faults = []Fault{Delay}
+	request(t, f, wire.NewRequest(wire.Query, wire.Get, 0))
+	got := make(chan []byte)
+	go func() {
+		b := make([]byte, wire.ExtendedSize)
+		n, _ := f.Read(b)
+		got <- b[:n]
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case b := <-got:
+		t.Fatalf("read % x before the delay", b)
+	default:
+	}
+	clock.Advance(time.Minute)
+	var r wire.Response
+	if b := <-got; wire.DecodeResponse(b, &r) != nil || !r.IsMeasurement() {
+		t.Errorf("read % x, want a measurement", b)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"context"
+	"sync"
+)
+
+// An Overflow is what a stream does with a new reading when its
+// buffer is full.
+type Overflow int
+
+const (
+	// Block waits for the consumer to make room, which stops reading
+	// from the sensor in the meantime.
+	Block Overflow = iota
+	// DropOldest drops the oldest buffered reading.
+	DropOldest
+	// Latest drops all the buffered readings, so that the consumer
+	// gets the latest one next.
+	Latest
+)
+
+// StreamOptions configure a stream.
+type StreamOptions struct {
+	// Buffer is how many readings the stream buffers for a consumer
+	// that is behind. Zero means 1.
+	Buffer int
+	// Overflow is what happens when the buffer is full.
+	Overflow Overflow
+	// Consensus, if its Readings are set, holds back readings that
+	// don't agree with the ones before them (see Consensus). They
+	// are counted by Suppressed.
+	Consensus Consensus
+}
+
+// A Stream sends the readings of a sensor in active mode to a channel.
+type Stream struct {
+	// C receives the readings. It's closed when the stream ends.
+	C <-chan Point
+
+	mu                  sync.Mutex
+	err                 error
+	dropped, suppressed int
+}
+
+// Stream starts reading measurements in the background, and sends them
+// to the returned stream's channel. The sensor should be in active
+// mode. Other calls may be made while the stream runs; they take turns
+// with its reads, and the measurements that come while a command waits
+// for its reply are skipped.
+//
+// The stream ends when reading fails, for example because the sensor
+// was closed, or when ctx is done. As a pending read can't be
+// interrupted, the latter only takes effect with the next reading.
+func (sensor *Sensor) Stream(ctx context.Context, options StreamOptions) *Stream {
+	if options.Buffer <= 0 {
+		options.Buffer = 1
+	}
+	c := make(chan Point, options.Buffer)
+	s := &Stream{C: c}
+	// The stream has a consensus of its own.
+	consensus := options.Consensus
+	consensus.recent = nil
+	go s.run(ctx, sensor, c, options.Overflow, &consensus)
+	return s
+}
+
+func (s *Stream) run(ctx context.Context, sensor *Sensor, c chan Point, overflow Overflow, consensus *Consensus) {
+	defer close(c)
+	var p Point
+	for {
+		if err := sensor.ReadPoint(&p); err != nil {
+			s.setErr(err)
+			return
+		}
+		if ctx.Err() != nil {
+			s.setErr(ctx.Err())
+			return
+		}
+		if !consensus.Add(&p) {
+			s.mu.Lock()
+			s.suppressed++
+			s.mu.Unlock()
+			continue
+		}
+		select {
+		case c <- p:
+			continue
+		default:
+		}
+		switch overflow {
+		case Block:
+			select {
+			case c <- p:
+			case <-ctx.Done():
+				s.setErr(ctx.Err())
+				return
+			}
+			continue
+		case DropOldest:
+			s.drop(c, 1)
+		case Latest:
+			s.drop(c, len(c))
+		}
+		// Only the stream sends to c, so once there's room it stays
+		// there.
+		c <- p
+	}
+}
+
+// drop drops up to n buffered readings. The consumer may take some of
+// them first.
+func (s *Stream) drop(c chan Point, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-c:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		default:
+			return
+		}
+	}
+}
+
+func (s *Stream) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Err returns the error that ended the stream, once C is closed.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Suppressed returns how many readings were held back because they
+// didn't agree with the ones before them (see StreamOptions).
+func (s *Stream) Suppressed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.suppressed
+}
+
+// Dropped returns how many readings were dropped because the consumer
+// didn't keep up.
+func (s *Stream) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
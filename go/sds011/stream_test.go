@@ -0,0 +1,78 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestStreamBlock(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Active = true
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSensor(fake).Stream(ctx, StreamOptions{Buffer: 2})
+	for i := 0; i < 5; i++ {
+		if p := <-s.C; p.PM25 != 10 || p.PM10 != 20 {
+			t.Errorf("reading %d: %v, want PM2.5 10 and PM10 20", i, &p)
+		}
+	}
+	cancel()
+	for range s.C {
+	}
+	if err := s.Err(); err != context.Canceled {
+		t.Errorf("Err: %v, want %v", err, context.Canceled)
+	}
+	if n := s.Dropped(); n != 0 {
+		t.Errorf("Dropped: %d, want 0", n)
+	}
+}
+
+func TestStreamOverflow(t *testing.T) {
+	for _, overflow := range []Overflow{DropOldest, Latest} {
+		fake := sds011test.NewFake()
+		fake.Active = true
+		sensor := NewSensor(fake)
+		s := sensor.Stream(context.Background(), StreamOptions{Buffer: 3, Overflow: overflow})
+		// The fake measures as fast as it's read from, so a consumer
+		// that isn't reading is behind at once.
+		waitFor(t, "dropped readings", func() bool { return s.Dropped() > 10 })
+		if n := len(s.C); n > 3 {
+			t.Errorf("overflow %v: %d buffered readings, want at most 3", overflow, n)
+		}
+		sensor.Close()
+		for range s.C {
+		}
+		if s.Err() == nil {
+			t.Errorf("overflow %v: no error after the sensor was closed", overflow)
+		}
+	}
+}
+
+func TestStreamConsensus(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.Active = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewSensor(fake).Stream(ctx, StreamOptions{Consensus: Consensus{Readings: 3}})
+	<-s.C
+	// The fake always measures the same, so only the first two
+	// readings lack a consensus.
+	if n := s.Suppressed(); n != 2 {
+		t.Errorf("Suppressed: %d, want 2", n)
+	}
+}
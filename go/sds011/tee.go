@@ -0,0 +1,85 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/capture"
+)
+
+// tap is the sensor's end of the line. It copies the bytes that go
+// over it to a writer, if there is one.
+type tap struct {
+	rwc io.ReadWriteCloser
+
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+}
+
+func (t *tap) Read(b []byte) (int, error) {
+	n, err := t.rwc.Read(b)
+	t.record(capture.FromSensor, b[:n])
+	return n, err
+}
+
+func (t *tap) Write(b []byte) (int, error) {
+	n, err := t.rwc.Write(b)
+	t.record(capture.ToSensor, b[:n])
+	return n, err
+}
+
+func (t *tap) Close() error {
+	return t.rwc.Close()
+}
+
+func (t *tap) record(dir capture.Direction, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		return
+	}
+	e := capture.Event{Offset: time.Since(t.start), Direction: dir, Data: b}
+	if _, err := fmt.Fprintln(t.w, e); err != nil {
+		log.Warningf("tee: %v; no longer teeing", err)
+		t.w = nil
+	}
+}
+
+// Tee copies all the bytes written to and read from the sensor from
+// now on to w, as a recording in the format of package capture, for
+// example to attach to a bug report. Passing nil stops it, as does an
+// error writing to w. Tee may be called at any time.
+func (sensor *Sensor) Tee(w io.Writer) {
+	t := sensor.tap
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w, t.start = w, time.Now()
+	if w == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(w, "# sds011 capture, started %v\n", t.start.UTC().Format(time.RFC3339)); err != nil {
+		log.Warningf("tee: %v; not teeing", err)
+		t.w = nil
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryszard/sds011/go/capture"
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestTee(t *testing.T) {
+	sensor := NewSensor(sds011test.NewFake())
+	if _, err := sensor.Firmware(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	sensor.Tee(&buf)
+	if _, err := sensor.Query(); err != nil {
+		t.Fatal(err)
+	}
+	sensor.Tee(nil)
+	if _, err := sensor.Query(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := capture.Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var to, from []byte
+	for _, e := range events {
+		if e.Direction == capture.ToSensor {
+			to = append(to, e.Data...)
+		} else {
+			from = append(from, e.Data...)
+		}
+	}
+	// Only the first query is teed.
+	if len(to) != 19 || to[2] != byte(commandQuery) {
+		t.Errorf("teed writes: % x, want one query", to)
+	}
+	if len(from) != 10 || from[1] != 0xc0 {
+		t.Errorf("teed reads: % x, want one measurement", from)
+	}
+}
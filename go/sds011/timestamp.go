@@ -0,0 +1,111 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"io"
+	"time"
+)
+
+// TimestampOptions say how the points read from a sensor are stamped.
+// The zero value stamps them with the time they were decoded.
+type TimestampOptions struct {
+	// FirstByte stamps points with the time the first byte of their
+	// frame arrived, rather than the time the frame was decoded. On
+	// a slow line, or a port that waits for more bytes before
+	// returning a read (see PortOptions), the two can be
+	// milliseconds apart, which matters when correlating sensors.
+	FirstByte bool
+	// WallClock strips the monotonic clock reading from the
+	// timestamps, so that the intervals between them are computed
+	// from the wall clock, like those of timestamps that were
+	// stored and read back, or come from other machines. By default
+	// they are computed from the monotonic clock, which isn't
+	// affected by the wall clock being set.
+	WallClock bool
+}
+
+// SetTimestamps sets how the points read from then on are stamped.
+func (sensor *Sensor) SetTimestamps(opts TimestampOptions) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.stamps = opts
+	sensor.frames.arrivals.enabled = opts.FirstByte
+}
+
+// stamp returns the timestamp for a point decoded from the last frame
+// read.
+func (sensor *Sensor) stamp() time.Time {
+	t := time.Now()
+	if sensor.stamps.FirstByte && !sensor.frames.start.IsZero() {
+		t = sensor.frames.start
+	}
+	if sensor.stamps.WallClock {
+		t = t.Round(0)
+	}
+	return t
+}
+
+// maxArrivals is how many reads arrivals keeps track of. Every read
+// returns at least a byte, so it's enough for the buffer of a
+// frameReader.
+const maxArrivals = 32
+
+// arrivals is a reader that, if enabled, remembers when the bytes it
+// read arrived, so that the time of the first byte of a frame is known
+// even though it was buffered.
+type arrivals struct {
+	r       io.Reader
+	enabled bool
+	// total is how many bytes were read so far.
+	total int64
+	// reads are the ends (as offsets in what was read) and times of
+	// the latest reads, in a ring of n starting at head.
+	reads   [maxArrivals]arrival
+	head, n int
+}
+
+type arrival struct {
+	end int64
+	at  time.Time
+}
+
+func (a *arrivals) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.total += int64(n)
+		if a.enabled {
+			if a.n == maxArrivals {
+				a.head, a.n = (a.head+1)%maxArrivals, a.n-1
+			}
+			a.reads[(a.head+a.n)%maxArrivals] = arrival{a.total, time.Now()}
+			a.n++
+		}
+	}
+	return n, err
+}
+
+// at returns when the byte at offset arrived, or the zero time if
+// that isn't known. Reads that ended before offset are forgotten.
+func (a *arrivals) at(offset int64) time.Time {
+	for a.n > 0 {
+		r := a.reads[a.head]
+		if r.end > offset {
+			return r.at
+		}
+		a.head, a.n = (a.head+1)%maxArrivals, a.n-1
+	}
+	return time.Time{}
+}
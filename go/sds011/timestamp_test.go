@@ -0,0 +1,76 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trickle is a port whose reads return the chunks sent to it.
+type trickle chan []byte
+
+func (t trickle) Read(p []byte) (int, error) {
+	chunk, ok := <-t
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, chunk), nil
+}
+
+func (t trickle) Write(p []byte) (int, error) { return len(p), nil }
+func (t trickle) Close() error                { return nil }
+
+func TestTimestamps(t *testing.T) {
+	frame := frameFrom(0xC0, [4]byte{100, 0, 200, 0}, [2]byte{0xA1, 0x60})
+	for _, opts := range []TimestampOptions{{}, {FirstByte: true}, {FirstByte: true, WallClock: true}} {
+		port := make(trickle)
+		sensor := NewSensor(port)
+		sensor.SetTimestamps(opts)
+		var sent [3]time.Time
+		go func() {
+			// The first frame comes in two halves, and the second
+			// with the end of the first.
+			sent[0] = time.Now()
+			port <- frame[:4]
+			time.Sleep(50 * time.Millisecond)
+			sent[1] = time.Now()
+			port <- append(frame[4:len(frame):len(frame)], frame...)
+			close(port)
+		}()
+		var first, second Point
+		if err := sensor.ReadPoint(&first); err != nil {
+			t.Fatal(err)
+		}
+		if err := sensor.ReadPoint(&second); err != nil {
+			t.Fatal(err)
+		}
+		if opts.FirstByte {
+			if first.Timestamp.Before(sent[0]) || !first.Timestamp.Before(sent[1]) {
+				t.Errorf("%+v: first frame stamped %v, want between %v and %v", opts, first.Timestamp, sent[0], sent[1])
+			}
+		} else if first.Timestamp.Before(sent[1]) {
+			t.Errorf("%+v: first frame stamped %v, before it was complete at %v", opts, first.Timestamp, sent[1])
+		}
+		if second.Timestamp.Before(sent[1]) {
+			t.Errorf("%+v: second frame stamped %v, before it was sent at %v", opts, second.Timestamp, sent[1])
+		}
+		if monotonic := strings.Contains(first.Timestamp.String(), "m="); monotonic == opts.WallClock {
+			t.Errorf("%+v: timestamp %v has a monotonic clock reading: %v", opts, first.Timestamp, monotonic)
+		}
+	}
+}
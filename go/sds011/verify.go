@@ -0,0 +1,87 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"fmt"
+)
+
+// A MismatchError is returned by a setter that verifies the setting
+// (see SetVerify), when the sensor acknowledged it but reading it
+// back shows that it wasn't applied.
+type MismatchError struct {
+	// Setting is what was set: "report mode", "working period",
+	// "work state" or "device ID". Want is what it was set to, and
+	// Got what it is.
+	Setting   string
+	Want, Got string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("sds011: %v set to %v, but it's %v", e.Setting, e.Want, e.Got)
+}
+
+// SetVerify makes the setters, SetReportMode, SetCycle, Awake and
+// Sleep, read the setting back after the sensor acknowledges it, and
+// return a *MismatchError if it wasn't applied. Some firmware versions
+// acknowledge settings they ignore. Verifying takes one more command
+// per setter.
+func (sensor *Sensor) SetVerify(verify bool) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.verify = verify
+}
+
+// check returns a *MismatchError if got isn't want.
+func check(setting string, want, got string) error {
+	if got != want {
+		return &MismatchError{Setting: setting, Want: want, Got: got}
+	}
+	return nil
+}
+
+func (sensor *Sensor) verifyReportMode(mode ReportMode) error {
+	if !sensor.verify {
+		return nil
+	}
+	got, err := sensor.reportMode()
+	if err != nil {
+		return err
+	}
+	return check("report mode", mode.String(), got.String())
+}
+
+func (sensor *Sensor) verifyCycle(minutes uint8) error {
+	if !sensor.verify {
+		return nil
+	}
+	got, err := sensor.cycle()
+	if err != nil {
+		return err
+	}
+	return check("working period", fmt.Sprint(minutes), fmt.Sprint(got))
+}
+
+func (sensor *Sensor) verifyAwake(awake bool) error {
+	if !sensor.verify {
+		return nil
+	}
+	got, err := sensor.isAwake()
+	if err != nil {
+		return err
+	}
+	name := map[bool]string{true: "measuring", false: "sleeping"}
+	return check("work state", name[awake], name[got])
+}
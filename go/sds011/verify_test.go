@@ -0,0 +1,103 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sds011
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ryszard/sds011/go/sds011/sds011test"
+)
+
+func TestVerify(t *testing.T) {
+	fake := sds011test.NewFake()
+	sensor := NewSensor(fake)
+	sensor.SetVerify(true)
+	for name, set := range map[string]func() error{
+		"SetReportMode active": func() error { return sensor.SetReportMode(ActiveMode) },
+		"SetReportMode query":  func() error { return sensor.SetReportMode(QueryMode) },
+		"SetCycle":             func() error { return sensor.SetCycle(5) },
+		"Sleep":                sensor.Sleep,
+		"Awake":                sensor.Awake,
+	} {
+		if err := set(); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	fake := sds011test.NewFake()
+	fake.IgnoreSettings = true
+	sensor := NewSensor(fake)
+	if err := sensor.SetCycle(5); err != nil {
+		t.Fatalf("SetCycle without verifying: %v", err)
+	}
+	sensor.SetVerify(true)
+	err := sensor.SetCycle(5)
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("SetCycle: %v, want a *MismatchError", err)
+	}
+	if want := (MismatchError{"working period", "5", "0"}); *mismatch != want {
+		t.Errorf("SetCycle: %+v, want %+v", *mismatch, want)
+	}
+	if err := sensor.Sleep(); !errors.As(err, &mismatch) || mismatch.Got != "measuring" {
+		t.Errorf("Sleep: %v, want a mismatch", err)
+	}
+}
+
+func TestConfirmReportMode(t *testing.T) {
+	fake := sds011test.NewFake()
+	sensor := NewSensor(fake)
+	if err := sensor.SetReportMode(QueryMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := sensor.ConfirmReportMode(QueryMode); err != nil {
+		t.Errorf("ConfirmReportMode: %v", err)
+	}
+	if !fake.Awake {
+		t.Errorf("the sensor was left asleep")
+	}
+
+	fake.ForgetReportMode = true
+	err := sensor.ConfirmReportMode(QueryMode)
+	if m, ok := err.(*MismatchError); !ok || m.Want != "query" || m.Got != "active" {
+		t.Errorf("ConfirmReportMode with firmware that forgets the mode: %v, want a mismatch", err)
+	}
+
+	// A sleeping sensor is put back to sleep.
+	fake.ForgetReportMode = false
+	if err := sensor.Sleep(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sensor.ConfirmReportMode(ActiveMode); err != nil {
+		t.Errorf("ConfirmReportMode: %v", err)
+	}
+	if fake.Awake {
+		t.Errorf("the sensor was left awake")
+	}
+}
+
+func TestParseReportMode(t *testing.T) {
+	for _, mode := range []ReportMode{ActiveMode, QueryMode} {
+		if got, err := ParseReportMode(mode.String()); err != nil || got != mode {
+			t.Errorf("ParseReportMode(%q) = %v, %v, want %v", mode, got, err, mode)
+		}
+	}
+	if _, err := ParseReportMode("passive"); err == nil {
+		t.Errorf("ParseReportMode(\"passive\") succeeded")
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wire encodes and decodes the frames of the SDS011 protocol.
+// It does no I/O, so that simulators, packet analyzers and test rigs
+// can use it as well as package sds011.
+//
+// The host sends 19 byte requests:
+//
+//	AA B4 <command> <mode> <data: 11 bytes> <device ID: 2 bytes> <checksum> AB
+//
+// and the sensor sends 10 byte responses, measurements (C0) and
+// replies to commands (C5):
+//
+//	AA C0 <PM2.5: 2 bytes> <PM10: 2 bytes> <device ID: 2 bytes> <checksum> AB
+//	AA C5 <command> <3 bytes> <device ID: 2 bytes> <checksum> AB
+//
+// Some clones also measure PM1.0, and send it in longer, extended
+// measurements (C1), with the level after the device ID:
+//
+//	AA C1 <PM2.5: 2 bytes> <PM10: 2 bytes> <device ID: 2 bytes> <PM1.0: 2 bytes> <checksum> AB
+//
+// Checksums are the sum of the bytes from the data (the command, for
+// requests) to the last before the checksum. Numbers are little
+// endian.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// Header starts every frame.
+	Header byte = 0xAA
+	// Tail ends every frame.
+	Tail byte = 0xAB
+	// RequestMarker follows the header of requests.
+	RequestMarker byte = 0xB4
+
+	// RequestSize is the size of a request on the wire.
+	RequestSize = 19
+	// ResponseSize is the size of a response on the wire, other
+	// than an extended measurement.
+	ResponseSize = 10
+	// ExtendedSize is the size of an extended measurement on the
+	// wire, the largest response there is.
+	ExtendedSize = 12
+)
+
+// The kinds of responses, which follow their header.
+const (
+	Measurement         byte = 0xC0
+	ExtendedMeasurement byte = 0xC1
+	Reply               byte = 0xC5
+)
+
+// Size returns the size on the wire of a response of the given kind.
+func Size(kind byte) int {
+	if kind == ExtendedMeasurement {
+		return ExtendedSize
+	}
+	return ResponseSize
+}
+
+// A Command is something the host asks the sensor to do.
+type Command byte
+
+const (
+	ReportMode Command = 2
+	Query      Command = 4
+	DeviceID   Command = 5
+	WorkState  Command = 6
+	Firmware   Command = 7
+	Cycle      Command = 8
+)
+
+// A Mode is whether a request gets or sets a setting.
+type Mode byte
+
+const (
+	Get Mode = 0
+	Set Mode = 1
+)
+
+// The values of the report mode and the work state, in requests and
+// replies.
+const (
+	ReportActive byte = 0
+	ReportQuery  byte = 1
+
+	Sleeping  byte = 0
+	Measuring byte = 1
+)
+
+// Broadcast is the device ID addressing requests to all sensors.
+var Broadcast = [2]byte{0xFF, 0xFF}
+
+var (
+	// ErrFrame is returned when decoding something that isn't a
+	// frame: it's of the wrong size, or its header or tail are
+	// wrong.
+	ErrFrame = errors.New("wire: not a frame")
+	// ErrChecksum is returned when decoding a frame whose checksum
+	// doesn't match its contents.
+	ErrChecksum = errors.New("wire: bad checksum")
+)
+
+// Checksum returns the checksum of b.
+func Checksum(b []byte) byte {
+	var sum byte
+	for _, v := range b {
+		sum += v
+	}
+	return sum
+}
+
+// A Request is a request from the host to the sensor.
+type Request struct {
+	Command Command
+	Mode    Mode
+	// Data is the data of the command. For commands that set a
+	// value, the value is Data[0]; setting the device ID takes the
+	// new one in Data[9:11].
+	Data [11]byte
+	// DeviceID is the sensor the request is for, or Broadcast.
+	DeviceID [2]byte
+}
+
+// NewRequest returns a request to all sensors.
+func NewRequest(cmd Command, mode Mode, value byte) Request {
+	return Request{Command: cmd, Mode: mode, Data: [11]byte{value}, DeviceID: Broadcast}
+}
+
+// Encode writes the request to b as it goes on the wire.
+func (r *Request) Encode(b *[RequestSize]byte) {
+	b[0], b[1], b[2], b[3] = Header, RequestMarker, byte(r.Command), byte(r.Mode)
+	copy(b[4:15], r.Data[:])
+	b[15], b[16] = r.DeviceID[0], r.DeviceID[1]
+	b[17], b[18] = Checksum(b[2:17]), Tail
+}
+
+// DecodeRequest decodes a request as it goes on the wire into r. If
+// the checksum is bad, r is decoded but ErrChecksum is returned.
+func DecodeRequest(b []byte, r *Request) error {
+	if len(b) != RequestSize || b[0] != Header || b[1] != RequestMarker || b[RequestSize-1] != Tail {
+		return ErrFrame
+	}
+	r.Command, r.Mode = Command(b[2]), Mode(b[3])
+	copy(r.Data[:], b[4:15])
+	r.DeviceID = [2]byte{b[15], b[16]}
+	if Checksum(b[2:17]) != b[17] {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// A Response is a frame sent by the sensor: a measurement or a reply.
+type Response struct {
+	// Kind is Measurement, ExtendedMeasurement or Reply.
+	Kind byte
+	// Data is what's between the kind and the checksum. Only
+	// extended measurements use Data[6:8].
+	Data [8]byte
+}
+
+// NewMeasurement returns a measurement of the given levels, in
+// µg/m³, by the sensor with the given ID. The levels are rounded to
+// the nearest tenth, and clamped to what fits: 0 to 6553.5 µg/m³.
+func NewMeasurement(pm25, pm10 float64, id [2]byte) Response {
+	r := Response{Kind: Measurement}
+	binary.LittleEndian.PutUint16(r.Data[0:2], tenths(pm25))
+	binary.LittleEndian.PutUint16(r.Data[2:4], tenths(pm10))
+	r.Data[4], r.Data[5] = id[0], id[1]
+	return r
+}
+
+// NewExtendedMeasurement returns an extended measurement of the given
+// levels, in µg/m³, by the sensor with the given ID, rounded and
+// clamped like those of NewMeasurement.
+func NewExtendedMeasurement(pm1, pm25, pm10 float64, id [2]byte) Response {
+	r := NewMeasurement(pm25, pm10, id)
+	r.Kind = ExtendedMeasurement
+	binary.LittleEndian.PutUint16(r.Data[6:8], tenths(pm1))
+	return r
+}
+
+// tenths returns a level in µg/m³ as the tenths of µg/m³ that go on
+// the wire. It rounds, as truncating would turn levels a hair under a
+// tenth, like the 1.2999999999999998 that 0.7+0.6 comes to, into the
+// tenth below.
+func tenths(level float64) uint16 {
+	v := math.Round(level * 10)
+	switch {
+	case !(v > 0): // NaN too.
+		return 0
+	case v >= math.MaxUint16:
+		return math.MaxUint16
+	}
+	return uint16(v)
+}
+
+// NewReply returns a reply to cmd, with the given values, by the
+// sensor with the given ID.
+func NewReply(cmd Command, a, b, c byte, id [2]byte) Response {
+	return Response{Kind: Reply, Data: [8]byte{byte(cmd), a, b, c, id[0], id[1]}}
+}
+
+// IsReply returns true if the response is a reply to a command, as
+// opposed to a measurement.
+func (r *Response) IsReply() bool {
+	return r.Kind == Reply
+}
+
+// IsMeasurement returns true if the response is a measurement,
+// extended or not.
+func (r *Response) IsMeasurement() bool {
+	return r.Kind == Measurement || r.Kind == ExtendedMeasurement
+}
+
+// HasPM1 returns true if the response is an extended measurement,
+// which has a PM1.0 level.
+func (r *Response) HasPM1() bool {
+	return r.Kind == ExtendedMeasurement
+}
+
+// PM1 returns the PM1.0 level of an extended measurement, in µg/m³.
+func (r *Response) PM1() float64 {
+	return float64(binary.LittleEndian.Uint16(r.Data[6:8])) / 10
+}
+
+// PM25 returns the PM2.5 level of a measurement, in µg/m³.
+func (r *Response) PM25() float64 {
+	return float64(binary.LittleEndian.Uint16(r.Data[0:2])) / 10
+}
+
+// PM10 returns the PM10 level of a measurement, in µg/m³.
+func (r *Response) PM10() float64 {
+	return float64(binary.LittleEndian.Uint16(r.Data[2:4])) / 10
+}
+
+// ReplyTo returns the command a reply is a reply to.
+func (r *Response) ReplyTo() Command {
+	return Command(r.Data[0])
+}
+
+// DeviceID returns the ID of the sensor that sent the response.
+func (r *Response) DeviceID() [2]byte {
+	return [2]byte{r.Data[4], r.Data[5]}
+}
+
+// Size returns the size of the response on the wire.
+func (r *Response) Size() int {
+	return Size(r.Kind)
+}
+
+// Append appends the response to b as it goes on the wire, and returns
+// the extended slice.
+func (r *Response) Append(b []byte) []byte {
+	data := r.Data[:r.Size()-4]
+	b = append(b, Header, r.Kind)
+	b = append(b, data...)
+	return append(b, Checksum(data), Tail)
+}
+
+// DecodeResponse decodes a response as it goes on the wire into r. If
+// the checksum is bad, r is decoded but ErrChecksum is returned.
+func DecodeResponse(b []byte, r *Response) error {
+	if len(b) < 2 || len(b) != Size(b[1]) || b[0] != Header || b[len(b)-1] != Tail {
+		return ErrFrame
+	}
+	n := len(b) - 2
+	r.Kind, r.Data = b[1], [8]byte{}
+	copy(r.Data[:], b[2:n])
+	if Checksum(b[2:n]) != b[n] {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// SplitResponses is a bufio.SplitFunc that splits what the sensor
+// sends into response frames, skipping whatever is between them, like
+// noise on the line or the rest of a frame whose start was lost. The
+// frames aren't checked beyond their header and tail; DecodeResponse
+// does that.
+func SplitResponses(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for {
+		i := bytes.IndexByte(data[advance:], Header)
+		if i < 0 {
+			return len(data), nil, nil
+		}
+		advance += i
+		size := ResponseSize
+		if len(data)-advance > 1 {
+			size = Size(data[advance+1])
+		}
+		if len(data)-advance < size {
+			if atEOF {
+				return len(data), nil, nil
+			}
+			return advance, nil, nil
+		}
+		if data[advance+size-1] == Tail {
+			return advance + size, data[advance : advance+size], nil
+		}
+		// The header was a coincidence.
+		advance++
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func frame(r Response) []byte {
+	return r.Append(nil)
+}
+
+func TestMeasurementRoundTrip(t *testing.T) {
+	id := [2]byte{0xA1, 0x60}
+	// Levels a hair under a tenth, as sums and averages come to: in
+	// float64, 0.7+0.6 is 1.2999999999999998, and 3*0.1 is
+	// 0.30000000000000004.
+	a, b := 0.7, 0.1
+	for _, tc := range []struct {
+		pm1, pm25, pm10       float64
+		want1, want25, want10 float64
+	}{
+		{0, 12.3, 45.6, 0, 12.3, 45.6},
+		{0, 0.1, 999.9, 0, 0.1, 999.9},
+		{3 * b, a + 0.6, 10 * (a + 0.6), 0.3, 1.3, 13},
+		{4.2, 12.34, 12.36, 4.2, 12.3, 12.4},
+		{0, 0.04, 0.06, 0, 0, 0.1},
+		// Clamped to what fits.
+		{-1, -0.01, 7000, 0, 0, 6553.5},
+		{math.Inf(1), math.NaN(), math.Inf(-1), 6553.5, 0, 0},
+	} {
+		for _, extended := range []bool{false, true} {
+			r := NewMeasurement(tc.pm25, tc.pm10, id)
+			if extended {
+				r = NewExtendedMeasurement(tc.pm1, tc.pm25, tc.pm10, id)
+			}
+			b := r.Append(nil)
+			if len(b) != r.Size() {
+				t.Errorf("%v: %d bytes, want %d", r, len(b), r.Size())
+			}
+			var got Response
+			if err := DecodeResponse(b, &got); err != nil {
+				t.Errorf("DecodeResponse(% x): %v", b, err)
+				continue
+			}
+			if got.PM25() != tc.want25 || got.PM10() != tc.want10 || got.DeviceID() != id {
+				t.Errorf("%v, %v: PM2.5 %v, PM10 %v, ID %x; want %v, %v, %x", tc.pm25, tc.pm10, got.PM25(), got.PM10(), got.DeviceID(), tc.want25, tc.want10, id)
+			}
+			if !got.IsMeasurement() || got.IsReply() || got.HasPM1() != extended {
+				t.Errorf("% x: measurement %v, reply %v, PM1.0 %v", b, got.IsMeasurement(), got.IsReply(), got.HasPM1())
+			}
+			if extended && got.PM1() != tc.want1 {
+				t.Errorf("%v: PM1.0 %v, want %v", tc.pm1, got.PM1(), tc.want1)
+			}
+		}
+	}
+}
+
+func TestMeasurementBytes(t *testing.T) {
+	// 12.3 and 45.6 µg/m³ are 123 (7B 00) and 456 (C8 01) tenths.
+	r := NewMeasurement(12.3, 45.6, [2]byte{0xA1, 0x60})
+	want := []byte{0xAA, 0xC0, 0x7B, 0x00, 0xC8, 0x01, 0xA1, 0x60, 0x45, 0xAB}
+	if got := r.Append(nil); !bytes.Equal(got, want) {
+		t.Errorf("NewMeasurement: % x, want % x", got, want)
+	}
+}
+
+func TestReplyRoundTrip(t *testing.T) {
+	r := NewReply(Firmware, 18, 11, 16, [2]byte{0xA1, 0x60})
+	b := r.Append(nil)
+	var got Response
+	if err := DecodeResponse(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != r || !got.IsReply() || got.ReplyTo() != Firmware {
+		t.Errorf("DecodeResponse(% x): %+v, want %+v", b, got, r)
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	for _, r := range []Request{
+		NewRequest(Query, Get, 0),
+		NewRequest(Cycle, Set, 5),
+		{Command: DeviceID, Mode: Set, Data: [11]byte{9: 0xBE, 10: 0xEF}, DeviceID: [2]byte{0xA1, 0x60}},
+	} {
+		var b [RequestSize]byte
+		r.Encode(&b)
+		var got Request
+		if err := DecodeRequest(b[:], &got); err != nil {
+			t.Errorf("DecodeRequest(% x): %v", b, err)
+			continue
+		}
+		if got != r {
+			t.Errorf("DecodeRequest(% x): %+v, want %+v", b, got, r)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	good := frame(NewMeasurement(12.3, 45.6, [2]byte{0xA1, 0x60}))
+	corrupt := append([]byte(nil), good...)
+	corrupt[len(corrupt)-2]++
+	for _, tc := range []struct {
+		b    []byte
+		want error
+	}{
+		{nil, ErrFrame},
+		{good[:len(good)-1], ErrFrame},
+		{append(append([]byte(nil), good[:len(good)-1]...), 0), ErrFrame},
+		{append([]byte{0}, good[1:]...), ErrFrame},
+		{corrupt, ErrChecksum},
+	} {
+		var r Response
+		if err := DecodeResponse(tc.b, &r); err != tc.want {
+			t.Errorf("DecodeResponse(% x): %v, want %v", tc.b, err, tc.want)
+		}
+	}
+
+	var b [RequestSize]byte
+	req := NewRequest(Query, Get, 0)
+	req.Encode(&b)
+	b[17]++
+	if err := DecodeRequest(b[:], &req); err != ErrChecksum {
+		t.Errorf("DecodeRequest(% x): %v, want %v", b, err, ErrChecksum)
+	}
+	if err := DecodeRequest(b[:10], &req); err != ErrFrame {
+		t.Errorf("DecodeRequest(% x): %v, want %v", b[:10], err, ErrFrame)
+	}
+}
+
+func TestSplitResponses(t *testing.T) {
+	id := [2]byte{0xA1, 0x60}
+	m := frame(NewMeasurement(1, 2, id))
+	e := frame(NewExtendedMeasurement(3, 4, 5, id))
+	r := frame(NewReply(WorkState, 1, 1, 0, id))
+	var in []byte
+	in = append(in, m[4:]...) // The end of a frame whose start was lost.
+	in = append(in, m...)
+	in = append(in, 0x00, Header, 0x13) // Noise, with a stray header.
+	in = append(in, e...)
+	in = append(in, r...)
+	in = append(in, m[:6]...) // The start of a frame, at EOF.
+	s := bufio.NewScanner(bytes.NewReader(in))
+	s.Split(SplitResponses)
+	var got [][]byte
+	for s.Scan() {
+		got = append(got, append([]byte(nil), s.Bytes()...))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{m, e, r}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitResponses: % x, want % x", got, want)
+	}
+}
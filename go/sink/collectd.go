@@ -0,0 +1,188 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("collectd", func(config json.RawMessage) (Sink, error) {
+		var c struct {
+			// Path is the file to write PUTVAL commands to, as the
+			// exec plugin expects them. "-" means standard output.
+			Path string `json:"path"`
+			// Socket is the socket of the unixsock plugin, to send
+			// them to instead.
+			Socket string `json:"socket"`
+			// Host is the host the values are reported for. It
+			// defaults to the hostname.
+			Host string `json:"host"`
+			// Interval, if set, is how often the values are
+			// reported, for collectd to tell when they're missing.
+			Interval string `json:"interval"`
+		}
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, err
+		}
+		if (c.Path == "") == (c.Socket == "") {
+			return nil, errors.New("exactly one of path and socket is required")
+		}
+		s := &collectdSink{host: c.Host, socket: c.Socket}
+		if s.host == "" {
+			var err error
+			if s.host, err = os.Hostname(); err != nil {
+				return nil, err
+			}
+		}
+		if c.Interval != "" {
+			interval, err := time.ParseDuration(c.Interval)
+			if err != nil || interval < time.Second {
+				return nil, fmt.Errorf("bad interval %q", c.Interval)
+			}
+			s.interval = int(interval.Seconds())
+		}
+		if c.Path != "" {
+			f, err := openFile(config)
+			if err != nil {
+				return nil, err
+			}
+			s.file = f
+		}
+		return s, nil
+	})
+}
+
+// collectdSink sends readings to collectd as PUTVAL commands, either
+// written to a file for the exec plugin or sent to the unixsock
+// plugin. Every level, and every field, is a gauge of the sds011
+// plugin, with the sensor as the plugin instance, like
+// "host/sds011-kitchen/gauge-pm25".
+type collectdSink struct {
+	host     string
+	interval int
+	// file is where the commands go, for the exec plugin.
+	*file
+	// socket is the path of the unixsock plugin's socket, and conn
+	// and replies the connection to it, if there is one.
+	socket  string
+	conn    net.Conn
+	replies *bufio.Reader
+}
+
+// collectdName replaces the characters that separate the parts of a
+// collectd identifier.
+var collectdName = strings.NewReplacer("/", "_", "\"", "_", " ", "_")
+
+// commands returns the PUTVAL commands for r.
+func (s *collectdSink) commands(r *Reading) []string {
+	values := map[string]float64{"pm25": r.PM25, "pm10": r.PM10}
+	if r.HasPM1 {
+		values["pm1"] = r.PM1
+	}
+	for name, v := range r.Fields {
+		values[name] = v
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	options := ""
+	if s.interval > 0 {
+		options = fmt.Sprintf(" interval=%d", s.interval)
+	}
+	t := r.Timestamp.Unix()
+	commands := make([]string, len(names))
+	for i, name := range names {
+		id := fmt.Sprintf("%v/sds011-%v/gauge-%v", collectdName.Replace(s.host), collectdName.Replace(r.Sensor), collectdName.Replace(name))
+		commands[i] = fmt.Sprintf("PUTVAL \"%v\"%v %d:%v\n", id, options, t, values[name])
+	}
+	return commands
+}
+
+func (s *collectdSink) Write(r *Reading) error {
+	for _, cmd := range s.commands(r) {
+		if s.file != nil {
+			if _, err := io.WriteString(s.w, cmd); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.send(cmd); err != nil {
+			s.disconnect()
+			return fmt.Errorf("collectd %v: %v", s.socket, err)
+		}
+	}
+	return nil
+}
+
+// send sends cmd to the unixsock plugin, connecting if need be, and
+// reads its reply, which starts with a negative number on failure.
+func (s *collectdSink) send(cmd string) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("unix", s.socket, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn, s.replies = conn, bufio.NewReader(conn)
+	}
+	s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(s.conn, cmd); err != nil {
+		return err
+	}
+	reply, err := s.replies.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	reply = strings.TrimSpace(reply)
+	status, _, _ := strings.Cut(reply, " ")
+	if n, err := strconv.Atoi(status); err != nil || n < 0 {
+		return fmt.Errorf("%v: %v", strings.TrimSpace(cmd), reply)
+	}
+	return nil
+}
+
+func (s *collectdSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.replies = nil, nil
+	}
+}
+
+func (s *collectdSink) Flush() error {
+	if s.file != nil {
+		return s.file.Flush()
+	}
+	return nil
+}
+
+func (s *collectdSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	s.disconnect()
+	return nil
+}
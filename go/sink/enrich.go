@@ -0,0 +1,178 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// An Enricher adds to readings before they reach the sinks, usually
+// Fields measured by other instruments: the temperature and humidity
+// from a weather sensor, or the position from a GPS receiver. Its
+// methods are never called concurrently.
+//
+// Enricher types are registered like sink types, and the file and
+// static ones are built in.
+type Enricher interface {
+	// Enrich adds to r. If it returns an error, r is passed on as
+	// it is.
+	Enrich(r *Reading) error
+	// Close releases the resources of the enricher.
+	Close() error
+}
+
+// An EnricherFactory creates an enricher from its config, like a
+// Factory does a sink.
+type EnricherFactory func(config json.RawMessage) (Enricher, error)
+
+var enrichers = make(map[string]EnricherFactory)
+
+func init() {
+	RegisterEnricher("static", func(config json.RawMessage) (Enricher, error) {
+		var c struct {
+			// Fields are added to every reading.
+			Fields map[string]float64 `json:"fields"`
+		}
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, err
+		}
+		if len(c.Fields) == 0 {
+			return nil, errors.New("fields are required")
+		}
+		return staticEnricher(c.Fields), nil
+	})
+	RegisterEnricher("file", func(config json.RawMessage) (Enricher, error) {
+		var c struct {
+			// Path is a file holding a JSON object of fields,
+			// kept up to date by another program.
+			Path string `json:"path"`
+			// MaxAge, if set, is how old the file may be for
+			// its fields to be added, like "5m".
+			MaxAge string `json:"max_age"`
+		}
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, err
+		}
+		if c.Path == "" {
+			return nil, errors.New("path is required")
+		}
+		e := &fileEnricher{path: c.Path}
+		if c.MaxAge != "" {
+			var err error
+			if e.maxAge, err = time.ParseDuration(c.MaxAge); err != nil || e.maxAge <= 0 {
+				return nil, fmt.Errorf("bad max_age %q", c.MaxAge)
+			}
+		}
+		return e, nil
+	})
+}
+
+// RegisterEnricher makes an enricher type available under name. It
+// panics if the name is already taken.
+func RegisterEnricher(name string, f EnricherFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := enrichers[name]; ok {
+		panic(fmt.Sprintf("sink: enricher type %q registered twice", name))
+	}
+	enrichers[name] = f
+}
+
+// EnricherRegistered returns whether there's an enricher type called
+// name.
+func EnricherRegistered(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := enrichers[name]
+	return ok
+}
+
+// EnricherTypes returns the names of the registered enricher types,
+// sorted.
+func EnricherTypes() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range enrichers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEnricher returns an enricher of the given type.
+func NewEnricher(name string, config json.RawMessage) (Enricher, error) {
+	mu.Lock()
+	f, ok := enrichers[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown enricher type %q", name)
+	}
+	return f(config)
+}
+
+// staticEnricher adds the same fields to every reading, like the
+// position of a sensor that doesn't move.
+type staticEnricher map[string]float64
+
+func (e staticEnricher) Enrich(r *Reading) error {
+	for name, v := range e {
+		r.SetField(name, v)
+	}
+	return nil
+}
+
+func (e staticEnricher) Close() error { return nil }
+
+// fileEnricher adds the fields in a JSON file, which it reads again
+// whenever it changes.
+type fileEnricher struct {
+	path    string
+	maxAge  time.Duration
+	modTime time.Time
+	fields  map[string]float64
+}
+
+func (e *fileEnricher) Enrich(r *Reading) error {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	if e.maxAge > 0 && time.Since(fi.ModTime()) > e.maxAge {
+		return fmt.Errorf("%v is stale: last modified at %v", e.path, fi.ModTime().Format(time.RFC3339))
+	}
+	if !fi.ModTime().Equal(e.modTime) {
+		b, err := os.ReadFile(e.path)
+		if err != nil {
+			return err
+		}
+		var fields map[string]float64
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return fmt.Errorf("%v: %v", e.path, err)
+		}
+		e.fields, e.modTime = fields, fi.ModTime()
+	}
+	for name, v := range e.fields {
+		r.SetField(name, v)
+	}
+	return nil
+}
+
+func (e *fileEnricher) Close() error { return nil }
@@ -0,0 +1,101 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+func init() {
+	Register("csv", func(config json.RawMessage) (Sink, error) {
+		f, err := openFile(config)
+		if err != nil {
+			return nil, err
+		}
+		return &csvSink{f}, nil
+	})
+	Register("jsonl", func(config json.RawMessage) (Sink, error) {
+		f, err := openFile(config)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonlSink{f, json.NewEncoder(f.w)}, nil
+	})
+}
+
+// file is a buffered output file.
+type file struct {
+	w *bufio.Writer
+	c io.Closer
+}
+
+// openFile opens the file named by the path field of config.
+func openFile(config json.RawMessage) (*file, error) {
+	var c struct {
+		// Path is the file to append to. "-" means standard
+		// output.
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	if c.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	wc, err := OpenOutput(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &file{bufio.NewWriter(wc), wc}, nil
+}
+
+func (f *file) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *file) Close() error {
+	err := f.w.Flush()
+	if closeErr := f.c.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// csvSink writes readings in the same format as the sds011 command,
+// with an additional column containing the sensor name: an RFC3339
+// timestamp, the sensor, the PM2.5 level, the PM10 level.
+type csvSink struct {
+	*file
+}
+
+func (s *csvSink) Write(r *Reading) error {
+	_, err := fmt.Fprintf(s.w, "%v,%v,%v,%v\n", r.Timestamp.Format(time.RFC3339), r.Sensor, r.PM25, r.PM10)
+	return err
+}
+
+// jsonlSink writes readings as JSON objects, one per line.
+type jsonlSink struct {
+	*file
+	enc *json.Encoder
+}
+
+func (s *jsonlSink) Write(r *Reading) error {
+	return s.enc.Encode(r)
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A Reader reads back what the csv and jsonl sinks write, and the CSV
+// output of the sds011 command, telling the formats apart line by
+// line. The fields of lines that aren't JSON may also be separated by
+// tabs.
+type Reader struct {
+	// Sensor is the name given to readings that don't have one,
+	// like those of the sds011 command.
+	Sensor string
+	// Columns, if set, are the columns of the lines that aren't
+	// JSON, named as the -columns flag of the sds011 command names
+	// them. Only timestamp, unix, pm25, pm10 and pm1 are read, and
+	// the others skipped. By default, lines are a timestamp, the
+	// PM2.5 level and the PM10 level, with the name of the sensor
+	// after the timestamp in those of the csv sink.
+	Columns []string
+
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+// Read returns the next reading, or io.EOF when there are no more.
+func (r *Reader) Read() (*Reading, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		reading, err := r.parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		return reading, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *Reader) parse(text string) (*Reading, error) {
+	reading := new(Reading)
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), reading); err != nil {
+			return nil, err
+		}
+	} else if r.Columns != nil {
+		p, err := parseColumns(r.Columns, splitFields(text))
+		if err != nil {
+			return nil, err
+		}
+		reading.Point = p
+	} else {
+		fields := splitFields(text)
+		switch len(fields) {
+		case 3:
+			// timestamp, PM2.5, PM10
+			fields = []string{fields[0], "", fields[1], fields[2]}
+		case 4:
+			// timestamp, sensor, PM2.5, PM10
+		default:
+			return nil, fmt.Errorf("%d fields, want 3 or 4", len(fields))
+		}
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		p := &sds011.Point{Timestamp: t}
+		if p.PM25, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, err
+		}
+		if p.PM10, err = strconv.ParseFloat(fields[3], 64); err != nil {
+			return nil, err
+		}
+		reading.Sensor, reading.Point = fields[1], p
+	}
+	if reading.Sensor == "" {
+		reading.Sensor = r.Sensor
+	}
+	return reading, nil
+}
+
+// splitFields splits a line of CSV or TSV.
+func splitFields(text string) []string {
+	if strings.Contains(text, "\t") {
+		return strings.Split(text, "\t")
+	}
+	return strings.Split(text, ",")
+}
+
+// parseColumns returns the point in fields, which are columns.
+func parseColumns(columns, fields []string) (*sds011.Point, error) {
+	if len(fields) != len(columns) {
+		return nil, fmt.Errorf("%d fields, want %d", len(fields), len(columns))
+	}
+	p := new(sds011.Point)
+	var stamped, pm25, pm10 bool
+	for i, c := range columns {
+		v := strings.TrimSpace(fields[i])
+		var err error
+		switch c {
+		case "timestamp":
+			p.Timestamp, err = time.Parse(time.RFC3339, v)
+			stamped = true
+		case "unix":
+			var sec int64
+			if sec, err = strconv.ParseInt(v, 10, 64); err == nil {
+				p.Timestamp = time.Unix(sec, 0)
+			}
+			stamped = true
+		case "pm25":
+			p.PM25, err = strconv.ParseFloat(v, 64)
+			pm25 = true
+		case "pm10":
+			p.PM10, err = strconv.ParseFloat(v, 64)
+			pm10 = true
+		case "pm1":
+			// Empty for sensors that don't measure it.
+			if v != "" {
+				p.PM1, err = strconv.ParseFloat(v, 64)
+				p.HasPM1 = true
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %v: %v", c, err)
+		}
+	}
+	if !stamped || !pm25 || !pm10 {
+		return nil, errors.New("columns lack the timestamp, pm25 or pm10")
+	}
+	return p, nil
+}
+
+// ReadFiles reads all the readings in the files at paths ("-" meaning
+// standard input), and returns them in time order. Readings without a
+// sensor name are given sensor.
+func ReadFiles(sensor string, paths ...string) ([]*Reading, error) {
+	var readings []*Reading
+	for _, path := range paths {
+		f := os.Stdin
+		if path != "-" {
+			var err error
+			if f, err = os.Open(path); err != nil {
+				return nil, err
+			}
+		}
+		r := NewReader(f)
+		r.Sensor = sensor
+		for {
+			reading, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%v: %v", path, err)
+			}
+			readings = append(readings, reading)
+		}
+		if path != "-" {
+			f.Close()
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].Timestamp.Before(readings[j].Timestamp) })
+	return readings, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sequenceReserve is how many sequence numbers a Sequencer saves ahead
+// of those it has handed out, so that it writes its file only every so
+// many readings. After a crash, the numbers skip what was left of it.
+const sequenceReserve = 100
+
+// A Sequencer numbers the readings of every sensor, 1, 2, 3 and so on,
+// so that consumers on lossy transports, like UDP or MQTT at QoS 0, can
+// tell when readings were dropped or delivered twice.
+//
+// If it has a file, the numbers carry on from it when the program is
+// restarted. The file is written ahead of the numbers, so that they
+// never repeat, even after a crash; then they skip up to
+// sequenceReserve. Close writes where they stopped.
+type Sequencer struct {
+	mu   sync.Mutex
+	path string
+	// next is the next number of every sensor, and saved what the
+	// file says it is.
+	next, saved map[string]uint64
+}
+
+// NewSequencer returns a sequencer keeping its numbers in the JSON
+// file at path, if it's not empty. The file doesn't have to exist.
+func NewSequencer(path string) (*Sequencer, error) {
+	s := &Sequencer{path: path, next: make(map[string]uint64), saved: make(map[string]uint64)}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.saved); err != nil {
+		return nil, err
+	}
+	for sensor, n := range s.saved {
+		s.next[sensor] = n
+	}
+	return s, nil
+}
+
+// Number sets the sequence number of r, the next one of its sensor. It
+// returns an error if the file can't be written, but numbers r anyway.
+func (s *Sequencer) Number(r *Reading) (err error) {
+	r.Seq, err = s.Next(r.Sensor)
+	return err
+}
+
+// Next returns the next sequence number of sensor. Like Number, it
+// returns one even with an error.
+func (s *Sequencer) Next(sensor string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.next[sensor]
+	if n == 0 {
+		n = 1
+	}
+	s.next[sensor] = n + 1
+	if s.path == "" || n < s.saved[sensor] {
+		return n, nil
+	}
+	s.saved[sensor] = n + 1 + sequenceReserve
+	return n, s.save()
+}
+
+// Close writes the next numbers to the file, so that they carry on
+// without a gap.
+func (s *Sequencer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return nil
+	}
+	for sensor, n := range s.next {
+		s.saved[sensor] = n
+	}
+	return s.save()
+}
+
+// save replaces the file with saved. It must be called with the lock
+// held.
+func (s *Sequencer) save() error {
+	b, err := json.Marshal(s.saved)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".sequence")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
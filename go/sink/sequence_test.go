@@ -0,0 +1,78 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// next returns the next numbers of sensors from s, in turn.
+func next(t *testing.T, s *Sequencer, sensors ...string) []uint64 {
+	t.Helper()
+	var got []uint64
+	for _, sensor := range sensors {
+		n, err := s.Next(sensor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	return got
+}
+
+func TestSequencer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequence.json")
+	for _, tc := range []struct {
+		name string
+		// crash is whether to leave the sequencer without closing
+		// it.
+		crash   bool
+		sensors []string
+		want    []uint64
+	}{
+		{"from 1", false, []string{"kitchen", "kitchen", "garden", "kitchen"}, []uint64{1, 2, 1, 3}},
+		{"after a restart", true, []string{"kitchen", "garden"}, []uint64{4, 2}},
+		// The numbers skip what was reserved, but never repeat.
+		{"after a crash", false, []string{"kitchen", "garden"}, []uint64{4 + sequenceReserve + 1, 2 + sequenceReserve + 1}},
+	} {
+		s, err := NewSequencer(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := next(t, s, tc.sensors...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: %v, want %v", tc.name, got, tc.want)
+		}
+		if !tc.crash {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Without a file, the numbers start over.
+	s, err := NewSequencer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reading{Sensor: "kitchen"}
+	if err := s.Number(r); err != nil || r.Seq != 1 {
+		t.Errorf("Number: %v, %v, want 1", r.Seq, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sink defines the outputs sds011d sends readings to, and a
+// registry of them. The csv, jsonl, webhook and collectd sinks are
+// built in. Other packages can add sink types by registering them in an
+// init function:
+//
+//	func init() {
+//		sink.Register("influxdb", func(config json.RawMessage) (sink.Sink, error) {
+//			var c struct {
+//				URL string `json:"url"`
+//			}
+//			if err := json.Unmarshal(config, &c); err != nil {
+//				return nil, err
+//			}
+//			return newInfluxSink(c.URL), nil
+//		})
+//	}
+//
+// and are then available to the daemon once it imports them, or,
+// built with -buildmode=plugin, once it loads them with LoadPlugin.
+// Enrichers, which add to readings before they reach the sinks, are
+// registered the same way, with RegisterEnricher.
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"plugin"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A Reading is a measurement taken by one of the sensors of the
+// daemon.
+type Reading struct {
+	Sensor string
+	*sds011.Point
+	// Seq is the sequence number of the reading among those of its
+	// sensor, counting from 1 (see Sequencer), or 0 if it isn't
+	// numbered.
+	Seq uint64
+	// Labels are the labels of the sensor from the config.
+	Labels map[string]string
+	// Fields are values measured along with the reading by other
+	// instruments, by name, added by enrichers.
+	Fields map[string]float64
+}
+
+// SetField sets a field of r.
+func (r *Reading) SetField(name string, v float64) {
+	if r.Fields == nil {
+		r.Fields = make(map[string]float64)
+	}
+	r.Fields[name] = v
+}
+
+// quality is the JSON of a sds011.Quality.
+type quality struct {
+	Resynced        bool `json:"resynced,omitempty"`
+	ChecksumRetries int  `json:"checksum_retries,omitempty"`
+	// SinceWake is in seconds.
+	SinceWake float64 `json:"since_wake,omitempty"`
+	InWarmup  bool    `json:"in_warmup,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. The PM1.0 reading is only
+// included if the sensor measures it, and the quality if there's
+// anything to say about it.
+func (r *Reading) MarshalJSON() ([]byte, error) {
+	var pm1 *float64
+	if r.HasPM1 {
+		pm1 = &r.PM1
+	}
+	var q *quality
+	if r.Quality != (sds011.Quality{}) {
+		q = &quality{r.Quality.Resynced, r.Quality.ChecksumRetries, r.Quality.SinceWake.Seconds(), r.Quality.InWarmup}
+	}
+	return json.Marshal(struct {
+		Sensor    string             `json:"sensor"`
+		Seq       uint64             `json:"seq,omitempty"`
+		Timestamp time.Time          `json:"timestamp"`
+		PM1       *float64           `json:"pm1,omitempty"`
+		PM25      float64            `json:"pm25"`
+		PM10      float64            `json:"pm10"`
+		Quality   *quality           `json:"quality,omitempty"`
+		Labels    map[string]string  `json:"labels,omitempty"`
+		Fields    map[string]float64 `json:"fields,omitempty"`
+	}{r.Sensor, r.Seq, r.Timestamp, pm1, r.PM25, r.PM10, q, r.Labels, r.Fields})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Reading) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Sensor    string             `json:"sensor"`
+		Seq       uint64             `json:"seq"`
+		Timestamp time.Time          `json:"timestamp"`
+		PM1       *float64           `json:"pm1"`
+		PM25      float64            `json:"pm25"`
+		PM10      float64            `json:"pm10"`
+		Quality   quality            `json:"quality"`
+		Labels    map[string]string  `json:"labels"`
+		Fields    map[string]float64 `json:"fields"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	q := v.Quality
+	r.Sensor, r.Seq, r.Labels, r.Fields = v.Sensor, v.Seq, v.Labels, v.Fields
+	r.Point = &sds011.Point{PM25: v.PM25, PM10: v.PM10, Timestamp: v.Timestamp, Quality: sds011.Quality{
+		Resynced:        q.Resynced,
+		ChecksumRetries: q.ChecksumRetries,
+		SinceWake:       time.Duration(q.SinceWake * float64(time.Second)),
+		InWarmup:        q.InWarmup,
+	}}
+	if v.PM1 != nil {
+		r.PM1, r.HasPM1 = *v.PM1, true
+	}
+	return nil
+}
+
+// A Sink is a destination for readings. Its methods are never called
+// concurrently.
+type Sink interface {
+	// Write sends r to the sink. It may buffer it.
+	Write(r *Reading) error
+	// Flush sends anything Write buffered.
+	Flush() error
+	// Close flushes the sink and releases its resources.
+	Close() error
+}
+
+// A Factory creates a sink from its config, the JSON object describing
+// it in the config file of the daemon. Factories should ignore the
+// fields they don't know about, as the daemon has some of its own.
+type Factory func(config json.RawMessage) (Sink, error)
+
+var (
+	mu        sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a sink type available under name. It panics if the
+// name is already taken.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("sink: type %q registered twice", name))
+	}
+	factories[name] = f
+}
+
+// Registered returns whether there's a sink type called name.
+func Registered(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Types returns the names of the registered sink types, sorted.
+func Types() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a sink of the given type.
+func New(name string, config json.RawMessage) (Sink, error) {
+	mu.Lock()
+	f, ok := factories[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type %q", name)
+	}
+	return f(config)
+}
+
+// LoadPlugin opens a Go plugin, which is expected to register its
+// sink types when it's initialized.
+func LoadPlugin(path string) error {
+	_, err := plugin.Open(path)
+	return err
+}
+
+// OpenOutput opens path for appending. "-" means standard output.
+func OpenOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
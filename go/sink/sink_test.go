@@ -0,0 +1,204 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var t0 = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestReadingJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		reading Reading
+		want    string
+	}{
+		{
+			name:    "plain",
+			reading: Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 12.3, PM10: 20.1, Timestamp: t0}},
+			want:    `{"sensor":"kitchen","timestamp":"2024-06-01T12:00:00Z","pm25":12.3,"pm10":20.1}`,
+		},
+		{
+			name: "everything",
+			reading: Reading{
+				Sensor: "kitchen",
+				Seq:    7,
+				Point: &sds011.Point{PM1: 4.5, HasPM1: true, PM25: 12.3, PM10: 20.1, Timestamp: t0,
+					Quality: sds011.Quality{Resynced: true, ChecksumRetries: 2, SinceWake: 10 * time.Second, InWarmup: true}},
+				Labels: map[string]string{"room": "kitchen"},
+				Fields: map[string]float64{"humidity": 55},
+			},
+			want: `{"sensor":"kitchen","seq":7,"timestamp":"2024-06-01T12:00:00Z","pm1":4.5,"pm25":12.3,"pm10":20.1,` +
+				`"quality":{"resynced":true,"checksum_retries":2,"since_wake":10,"in_warmup":true},"labels":{"room":"kitchen"},"fields":{"humidity":55}}`,
+		},
+		{
+			name: "a PM1.0 of zero",
+			// Zero is a level like any other, for sensors that
+			// measure it.
+			reading: Reading{Sensor: "kitchen", Point: &sds011.Point{HasPM1: true, PM25: 1, PM10: 2, Timestamp: t0}},
+			want:    `{"sensor":"kitchen","timestamp":"2024-06-01T12:00:00Z","pm1":0,"pm25":1,"pm10":2}`,
+		},
+	} {
+		b, err := json.Marshal(&tc.reading)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("%v: %s, want %s", tc.name, b, tc.want)
+		}
+		var back Reading
+		if err := json.Unmarshal(b, &back); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(back, tc.reading) {
+			t.Errorf("%v: read back %+v, want %+v", tc.name, back, tc.reading)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{"csv", "jsonl", "webhook", "collectd"} {
+		if !Registered(name) {
+			t.Errorf("%q isn't registered", name)
+		}
+	}
+	Register("test", func(config json.RawMessage) (Sink, error) { return nil, nil })
+	// Registering twice panics, so it's undone for -count.
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(factories, "test")
+	})
+	if !Registered("test") {
+		t.Error("a registered type isn't")
+	}
+	types := Types()
+	if !sort.StringsAreSorted(types) {
+		t.Errorf("Types: %v, not sorted", types)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering a type twice didn't panic")
+			}
+		}()
+		Register("test", func(config json.RawMessage) (Sink, error) { return nil, nil })
+	}()
+	if _, err := New("influxdb", nil); err == nil {
+		t.Error("New of an unknown type: no error")
+	}
+	for _, tc := range []struct {
+		name, config string
+	}{
+		{"csv", `{}`},
+		{"jsonl", `{"path": ""}`},
+		{"webhook", `{}`},
+		{"csv", `[]`},
+	} {
+		if _, err := New(tc.name, json.RawMessage(tc.config)); err == nil {
+			t.Errorf("New(%q, %s): no error", tc.name, tc.config)
+		}
+	}
+}
+
+func TestFileSinks(t *testing.T) {
+	readings := []*Reading{
+		{Sensor: "kitchen", Point: &sds011.Point{PM25: 12.3, PM10: 20.1, Timestamp: t0}},
+		{Sensor: "garden", Point: &sds011.Point{PM25: 1, PM10: 2, Timestamp: t0.Add(time.Minute)}},
+	}
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"csv", "2024-06-01T12:00:00Z,kitchen,12.3,20.1\n2024-06-01T12:01:00Z,garden,1,2\n"},
+		{"jsonl", `{"sensor":"kitchen","timestamp":"2024-06-01T12:00:00Z","pm25":12.3,"pm10":20.1}` + "\n" +
+			`{"sensor":"garden","timestamp":"2024-06-01T12:01:00Z","pm25":1,"pm10":2}` + "\n"},
+	} {
+		path := filepath.Join(t.TempDir(), "readings")
+		config, _ := json.Marshal(map[string]string{"path": path})
+		// The file is appended to.
+		for i, r := range readings {
+			s, err := New(tc.name, config)
+			if err != nil {
+				t.Fatalf("%v: %v", tc.name, err)
+			}
+			if err := s.Write(r); err != nil {
+				t.Fatalf("%v: Write %d: %v", tc.name, i, err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("%v: Close: %v", tc.name, err)
+			}
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("%v: wrote %q, want %q", tc.name, b, tc.want)
+		}
+
+		// And what's written can be read back.
+		got, err := ReadFiles("", path)
+		if err != nil {
+			t.Fatalf("%v: ReadFiles: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, readings) {
+			t.Errorf("%v: read back %v, want %v", tc.name, got, readings)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		got = append(got, req.Method+" "+req.Header.Get("Content-Type")+" "+string(b))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s, err := New("webhook", json.RawMessage(`{"url": "`+server.URL+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r := &Reading{Sensor: "kitchen", Point: &sds011.Point{PM25: 12.3, PM10: 20.1, Timestamp: t0}}
+	if err := s.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`POST application/json {"sensor":"kitchen","timestamp":"2024-06-01T12:00:00Z","pm25":12.3,"pm10":20.1}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests: %q, want %q", got, want)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := s.Write(r); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Write to a failing webhook: %v, want the status", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func init() {
+	Register("webhook", func(config json.RawMessage) (Sink, error) {
+		var c struct {
+			// URL is the address to POST readings to.
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, err
+		}
+		if c.URL == "" {
+			return nil, errors.New("url is required")
+		}
+		return &webhookSink{url: c.URL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	})
+}
+
+// webhookSink POSTs every reading as a JSON object to an URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Write(r *Reading) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %v: %v", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Flush() error {
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
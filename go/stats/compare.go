@@ -0,0 +1,154 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A Pair holds the mean levels of two series of points over the same
+// interval.
+type Pair struct {
+	// Start is the start of the interval.
+	Start time.Time
+	A, B  sds011.Point
+}
+
+// Align returns the pairs of the mean levels of a and b over the
+// intervals of the given length in which both have points, with the
+// points weighted as in Compute. The points must be in time order. If
+// interval isn't positive, there are no pairs.
+func Align(a, b []sds011.Point, interval, maxHold time.Duration) []Pair {
+	if len(a) == 0 || len(b) == 0 || interval <= 0 {
+		return nil
+	}
+	from, to := a[0].Timestamp, a[len(a)-1].Timestamp
+	if b[0].Timestamp.After(from) {
+		from = b[0].Timestamp
+	}
+	if b[len(b)-1].Timestamp.Before(to) {
+		to = b[len(b)-1].Timestamp
+	}
+	var pairs []Pair
+	for start := from.Truncate(interval); !start.After(to); start = start.Add(interval) {
+		// Compute takes (from, to], and the intervals are [start, end).
+		end := start.Add(interval)
+		sa := Compute(a, start.Add(-1), end.Add(-1), maxHold)
+		sb := Compute(b, start.Add(-1), end.Add(-1), maxHold)
+		if sa.Count == 0 || sb.Count == 0 {
+			continue
+		}
+		pairs = append(pairs, Pair{
+			Start: start,
+			A:     sds011.Point{PM25: sa.PM25.Mean, PM10: sa.PM10.Mean, Timestamp: start},
+			B:     sds011.Point{PM25: sb.PM25.Mean, PM10: sb.PM10.Mean, Timestamp: start},
+		})
+	}
+	return pairs
+}
+
+// Agreement describes how a level measured by one sensor, B, agrees
+// with the same level measured by another, A.
+type Agreement struct {
+	// N is the number of pairs.
+	N     int
+	MeanA float64
+	MeanB float64
+	// Bias is the mean of B - A.
+	Bias float64
+	// RMSD is the root mean square of B - A.
+	RMSD float64
+	// Correlation is Pearson's correlation coefficient of A and B.
+	Correlation float64
+	// Slope and Intercept are those of the least squares line
+	// through the pairs: B ≈ Slope * A + Intercept.
+	Slope     float64
+	Intercept float64
+	// Drift is how much B - A changes in a day, going by the least
+	// squares line through it.
+	Drift float64
+}
+
+// Comparison is the agreement of the levels of two series.
+type Comparison struct {
+	PM25 Agreement
+	PM10 Agreement
+}
+
+// Compare returns how the B levels of the pairs agree with the A
+// levels.
+func Compare(pairs []Pair) Comparison {
+	return Comparison{
+		PM25: agreement(pairs, func(p *sds011.Point) float64 { return p.PM25 }),
+		PM10: agreement(pairs, func(p *sds011.Point) float64 { return p.PM10 }),
+	}
+}
+
+func agreement(pairs []Pair, level func(*sds011.Point) float64) Agreement {
+	ag := Agreement{N: len(pairs)}
+	if ag.N == 0 {
+		return ag
+	}
+	a := make([]float64, len(pairs))
+	b := make([]float64, len(pairs))
+	days := make([]float64, len(pairs))
+	diffs := make([]float64, len(pairs))
+	var squares float64
+	for i := range pairs {
+		a[i], b[i] = level(&pairs[i].A), level(&pairs[i].B)
+		days[i] = pairs[i].Start.Sub(pairs[0].Start).Hours() / 24
+		diffs[i] = b[i] - a[i]
+		ag.MeanA += a[i]
+		ag.MeanB += b[i]
+		squares += diffs[i] * diffs[i]
+	}
+	n := float64(ag.N)
+	ag.MeanA /= n
+	ag.MeanB /= n
+	ag.Bias = ag.MeanB - ag.MeanA
+	ag.RMSD = math.Sqrt(squares / n)
+	ag.Slope, ag.Intercept, ag.Correlation = Fit(a, b)
+	ag.Drift, _, _ = Fit(days, diffs)
+	return ag
+}
+
+// Fit returns the slope and intercept of the least squares line
+// through the points (x[i], y[i]), and their correlation coefficient.
+// The results are NaN if there are fewer than two distinct xs.
+func Fit(x, y []float64) (slope, intercept, r float64) {
+	n := float64(len(x))
+	var mx, my float64
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= n
+	my /= n
+	var sxx, syy, sxy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	if sxx == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	slope = sxy / sxx
+	return slope, my - slope*mx, sxy / math.Sqrt(sxx*syy)
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// pair returns the pair of levels over the minute starting at t0
+// plus minutes.
+func pair(minutes int, a25, a10, b25, b10 float64) Pair {
+	start := t0.Add(time.Duration(minutes) * time.Minute)
+	return Pair{
+		Start: start,
+		A:     sds011.Point{PM25: a25, PM10: a10, Timestamp: start},
+		B:     sds011.Point{PM25: b25, PM10: b10, Timestamp: start},
+	}
+}
+
+// nearPair returns true if p and q are of the same interval, and
+// their levels are the same but for rounding.
+func nearPair(p, q Pair) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return p.Start.Equal(q.Start) && p.A.Timestamp.Equal(q.A.Timestamp) && p.B.Timestamp.Equal(q.B.Timestamp) &&
+		near(p.A.PM25, q.A.PM25) && near(p.A.PM10, q.A.PM10) && near(p.B.PM25, q.B.PM25) && near(p.B.PM10, q.B.PM10)
+}
+
+func TestAlign(t *testing.T) {
+	a := []sds011.Point{at(0, 10, 20, -1), at(1, 11, 21, -1), at(2, 12, 22, -1), at(3, 13, 23, -1), at(4, 14, 24, -1)}
+	for _, tc := range []struct {
+		name     string
+		a, b     []sds011.Point
+		interval time.Duration
+		want     []Pair
+	}{
+		{
+			name: "overlapping",
+			// Only the minutes from 1 to 4 have readings of both,
+			// and minute 3 has none of B.
+			a:        a,
+			b:        []sds011.Point{at(1, 12, 21, -1), at(2, 14, 22, -1), at(4, 18, 24, -1), at(5, 20, 25, -1)},
+			interval: time.Minute,
+			want:     []Pair{pair(1, 11, 21, 12, 21), pair(2, 12, 22, 14, 22), pair(4, 14, 24, 18, 24)},
+		},
+		{
+			name: "longer intervals",
+			// The last interval starts at the last readings.
+			a:        []sds011.Point{at(0, 10, 20, -1), at(1, 10, 20, -1), at(2, 12, 22, -1), at(3, 12, 22, -1), at(4, 14, 24, -1)},
+			b:        []sds011.Point{at(0, 20, 30, -1), at(1, 20, 30, -1), at(2, 40, 50, -1), at(3, 40, 50, -1), at(4, 0, 0, -1)},
+			interval: 2 * time.Minute,
+			want:     []Pair{pair(0, 10, 20, 20, 30), pair(2, 12, 22, 40, 50), pair(4, 14, 24, 0, 0)},
+		},
+		{
+			name:     "not overlapping",
+			a:        a[:2],
+			b:        []sds011.Point{at(3, 10, 20, -1), at(4, 10, 20, -1)},
+			interval: time.Minute,
+		},
+		{
+			name:     "empty",
+			a:        a,
+			interval: time.Minute,
+		},
+		{
+			name: "zero interval",
+			a:    a,
+			b:    a,
+		},
+		{
+			name:     "negative interval",
+			a:        a,
+			b:        a,
+			interval: -time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Align(tc.a, tc.b, tc.interval, 5*time.Minute)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Align: %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if !nearPair(got[i], tc.want[i]) {
+					t.Errorf("pair %d: %v, want %v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// B reads PM2.5 as 2A + 1, so it drifts away from A by a minute's
+	// difference in A a minute, and PM10 as A does.
+	var pairs []Pair
+	for i, a := range []float64{10, 11, 12, 13} {
+		pairs = append(pairs, pair(i, a, 20+a, 2*a+1, 20+a))
+	}
+	c := Compare(pairs)
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"PM2.5 mean A", c.PM25.MeanA, 11.5},
+		{"PM2.5 mean B", c.PM25.MeanB, 24},
+		{"PM2.5 bias", c.PM25.Bias, 12.5},
+		{"PM2.5 RMSD", c.PM25.RMSD, math.Sqrt((11*11 + 12*12 + 13*13 + 14*14) / 4.0)},
+		{"PM2.5 correlation", c.PM25.Correlation, 1},
+		{"PM2.5 slope", c.PM25.Slope, 2},
+		{"PM2.5 intercept", c.PM25.Intercept, 1},
+		{"PM2.5 drift", c.PM25.Drift, 24 * 60},
+		{"PM10 bias", c.PM10.Bias, 0},
+		{"PM10 RMSD", c.PM10.RMSD, 0},
+		{"PM10 slope", c.PM10.Slope, 1},
+		{"PM10 drift", c.PM10.Drift, 0},
+	} {
+		if math.Abs(tc.got-tc.want) > 1e-9 {
+			t.Errorf("%v: %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+	if c.PM25.N != 4 || c.PM10.N != 4 {
+		t.Errorf("N: %v and %v, want 4", c.PM25.N, c.PM10.N)
+	}
+
+	if got := Compare(nil); got != (Comparison{}) {
+		t.Errorf("Compare(nil): %+v, want nothing", got)
+	}
+}
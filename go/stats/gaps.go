@@ -0,0 +1,135 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// A Gap is a stretch of time without readings: the sensor was asleep,
+// or whatever was reading it was down.
+type Gap struct {
+	// Start is the time of the last reading before the gap, or the
+	// start of the period if there is none.
+	Start time.Time `json:"start"`
+	// End is the time of the first reading after the gap, or the end
+	// of the period if there is none.
+	End time.Time `json:"end"`
+}
+
+// Duration returns how long the gap is.
+func (g Gap) Duration() time.Duration {
+	return g.End.Sub(g.Start)
+}
+
+// Gaps returns the gaps between from and to: the stretches longer than
+// maxInterval without a reading. The points must be in time order.
+func Gaps(points []sds011.Point, from, to time.Time, maxInterval time.Duration) []Gap {
+	i := sort.Search(len(points), func(i int) bool { return !points[i].Timestamp.Before(from) })
+	j := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(to) })
+	points = points[i:j]
+
+	var gaps []Gap
+	last := from
+	for _, p := range points {
+		if p.Timestamp.Sub(last) > maxInterval {
+			gaps = append(gaps, Gap{Start: last, End: p.Timestamp})
+		}
+		last = p.Timestamp
+	}
+	if to.Sub(last) > maxInterval {
+		gaps = append(gaps, Gap{Start: last, End: to})
+	}
+	return gaps
+}
+
+// Coverage returns the fraction of the time between from and to that
+// isn't in a gap, as returned by Gaps.
+func Coverage(points []sds011.Point, from, to time.Time, maxInterval time.Duration) float64 {
+	total := to.Sub(from)
+	if total <= 0 {
+		return 0
+	}
+	var missing time.Duration
+	for _, g := range Gaps(points, from, to, maxInterval) {
+		missing += g.Duration()
+	}
+	return 1 - missing.Seconds()/total.Seconds()
+}
+
+// Interpolate returns the points with the gaps between them filled in
+// with a point every step, the levels going in a straight line from
+// the reading before the gap to the reading after. Gaps longer than
+// maxGap are left alone, as a line through them would be made up.
+// PM1.0 is filled in too if both readings have it. The points must be
+// in time order. If step isn't positive, they are returned as they
+// are.
+func Interpolate(points []sds011.Point, step, maxGap time.Duration) []sds011.Point {
+	if len(points) == 0 {
+		return nil
+	}
+	if step <= 0 {
+		return points
+	}
+	filled := make([]sds011.Point, 0, len(points))
+	for i, p := range points {
+		if i > 0 {
+			prev := points[i-1]
+			gap := p.Timestamp.Sub(prev.Timestamp)
+			if gap > step && gap <= maxGap {
+				for t := prev.Timestamp.Add(step); p.Timestamp.Sub(t) > 0; t = t.Add(step) {
+					f := t.Sub(prev.Timestamp).Seconds() / gap.Seconds()
+					point := sds011.Point{
+						PM25:      prev.PM25 + f*(p.PM25-prev.PM25),
+						PM10:      prev.PM10 + f*(p.PM10-prev.PM10),
+						Timestamp: t,
+					}
+					if prev.HasPM1 && p.HasPM1 {
+						point.PM1, point.HasPM1 = prev.PM1+f*(p.PM1-prev.PM1), true
+					}
+					filled = append(filled, point)
+				}
+			}
+		}
+		filled = append(filled, p)
+	}
+	return filled
+}
+
+// Mark returns the points with a point whose levels are NaN right
+// after the start of every gap longer than maxInterval, so that
+// whatever plots or exports them shows the gaps instead of drawing a
+// line through them. The points must be in time order.
+func Mark(points []sds011.Point, maxInterval time.Duration) []sds011.Point {
+	if len(points) == 0 {
+		return nil
+	}
+	marked := make([]sds011.Point, 0, len(points))
+	for i, p := range points {
+		if i > 0 && p.Timestamp.Sub(points[i-1].Timestamp) > maxInterval {
+			marked = append(marked, sds011.Point{
+				PM25:      math.NaN(),
+				PM10:      math.NaN(),
+				Timestamp: points[i-1].Timestamp.Add(maxInterval),
+			})
+		}
+		marked = append(marked, p)
+	}
+	return marked
+}
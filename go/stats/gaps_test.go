@@ -0,0 +1,239 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns a point at t0 plus minutes, with PM1.0 if pm1 isn't
+// negative.
+func at(minutes int, pm25, pm10, pm1 float64) sds011.Point {
+	p := sds011.Point{PM25: pm25, PM10: pm10, Timestamp: t0.Add(time.Duration(minutes) * time.Minute)}
+	if pm1 >= 0 {
+		p.PM1, p.HasPM1 = pm1, true
+	}
+	return p
+}
+
+// minute returns t0 plus minutes.
+func minute(minutes int) time.Time {
+	return t0.Add(time.Duration(minutes) * time.Minute)
+}
+
+func TestGaps(t *testing.T) {
+	points := []sds011.Point{at(10, 0, 0, -1), at(15, 0, 0, -1), at(30, 0, 0, -1), at(35, 0, 0, -1), at(50, 0, 0, -1)}
+	for _, tc := range []struct {
+		name     string
+		points   []sds011.Point
+		from, to int
+		want     []Gap
+	}{
+		{
+			name: "in the middle",
+			// 15 to 30 is a gap, 10 to 15 exactly the threshold
+			// isn't.
+			points: points,
+			from:   10, to: 50,
+			want: []Gap{{minute(15), minute(30)}, {minute(35), minute(50)}},
+		},
+		{
+			name:   "at the start and the end",
+			points: points,
+			from:   0, to: 60,
+			want: []Gap{{minute(0), minute(10)}, {minute(15), minute(30)}, {minute(35), minute(50)}, {minute(50), minute(60)}},
+		},
+		{
+			name:   "at the threshold",
+			points: points,
+			from:   5, to: 55,
+			want: []Gap{{minute(15), minute(30)}, {minute(35), minute(50)}},
+		},
+		{
+			name: "points outside the period",
+			// The readings at 10 and 50 aren't in it, so the gaps
+			// run to its ends.
+			points: points,
+			from:   11, to: 49,
+			want: []Gap{{minute(15), minute(30)}, {minute(35), minute(49)}},
+		},
+		{
+			name: "no points",
+			from: 0, to: 60,
+			want: []Gap{{minute(0), minute(60)}},
+		},
+		{
+			name: "no points in a short period",
+			from: 0, to: 5,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Gaps(tc.points, minute(tc.from), minute(tc.to), 5*time.Minute); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Gaps: %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGapDuration(t *testing.T) {
+	if got := (Gap{minute(15), minute(30)}).Duration(); got != 15*time.Minute {
+		t.Errorf("Duration: %v, want 15m", got)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	points := []sds011.Point{at(10, 0, 0, -1), at(15, 0, 0, -1), at(30, 0, 0, -1), at(35, 0, 0, -1), at(50, 0, 0, -1)}
+	for _, tc := range []struct {
+		name     string
+		points   []sds011.Point
+		from, to int
+		want     float64
+	}{
+		{"gaps of 15 minutes twice in 40", points, 10, 50, 0.25},
+		{"gaps at the ends", points, 0, 60, 1 - 50.0/60},
+		{"no gaps", points, 10, 15, 1},
+		{"no points", nil, 0, 60, 0},
+		{"an empty period", points, 30, 30, 0},
+		{"a backwards period", points, 60, 0, 0},
+	} {
+		if got := Coverage(tc.points, minute(tc.from), minute(tc.to), 5*time.Minute); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%v: Coverage: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMark(t *testing.T) {
+	gap := func(minutes int) sds011.Point {
+		return sds011.Point{PM25: math.NaN(), PM10: math.NaN(), Timestamp: minute(minutes)}
+	}
+	for _, tc := range []struct {
+		name   string
+		points []sds011.Point
+		want   []sds011.Point
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "gaps",
+			// The gap after 0 is exactly the threshold, so it's
+			// not marked.
+			points: []sds011.Point{at(0, 1, 2, -1), at(5, 3, 4, -1), at(20, 5, 6, -1), at(40, 7, 8, 1)},
+			want:   []sds011.Point{at(0, 1, 2, -1), at(5, 3, 4, -1), gap(10), at(20, 5, 6, -1), gap(25), at(40, 7, 8, 1)},
+		},
+		{
+			name:   "no gaps",
+			points: []sds011.Point{at(0, 1, 2, -1), at(1, 3, 4, -1)},
+			want:   []sds011.Point{at(0, 1, 2, -1), at(1, 3, 4, -1)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Mark(tc.points, 5*time.Minute)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Mark: %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				g, w := got[i], tc.want[i]
+				if math.IsNaN(w.PM25) {
+					if !math.IsNaN(g.PM25) || !math.IsNaN(g.PM10) || g.HasPM1 || !g.Timestamp.Equal(w.Timestamp) {
+						t.Errorf("point %d: %v at %v, want a gap marked at %v", i, &g, g.Timestamp, w.Timestamp)
+					}
+					continue
+				}
+				if !reflect.DeepEqual(g, w) {
+					t.Errorf("point %d: %v at %v, want %v at %v", i, &g, g.Timestamp, &w, w.Timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		points       []sds011.Point
+		step, maxGap time.Duration
+		want         []sds011.Point
+	}{
+		{
+			name:   "empty",
+			step:   time.Minute,
+			maxGap: time.Hour,
+		},
+		{
+			name:   "no gap",
+			points: []sds011.Point{at(0, 10, 20, -1), at(1, 12, 22, -1)},
+			step:   time.Minute,
+			maxGap: time.Hour,
+			want:   []sds011.Point{at(0, 10, 20, -1), at(1, 12, 22, -1)},
+		},
+		{
+			name:   "filled",
+			points: []sds011.Point{at(0, 10, 20, -1), at(4, 14, 12, -1)},
+			step:   time.Minute,
+			maxGap: time.Hour,
+			want: []sds011.Point{
+				at(0, 10, 20, -1), at(1, 11, 18, -1), at(2, 12, 16, -1), at(3, 13, 14, -1), at(4, 14, 12, -1),
+			},
+		},
+		{
+			name:   "PM1.0 on both ends",
+			points: []sds011.Point{at(0, 10, 20, 4), at(2, 12, 22, 8)},
+			step:   time.Minute,
+			maxGap: time.Hour,
+			want:   []sds011.Point{at(0, 10, 20, 4), at(1, 11, 21, 6), at(2, 12, 22, 8)},
+		},
+		{
+			name:   "PM1.0 on one end",
+			points: []sds011.Point{at(0, 10, 20, 4), at(2, 12, 22, -1)},
+			step:   time.Minute,
+			maxGap: time.Hour,
+			want:   []sds011.Point{at(0, 10, 20, 4), at(1, 11, 21, -1), at(2, 12, 22, -1)},
+		},
+		{
+			name:   "gap too long",
+			points: []sds011.Point{at(0, 10, 20, -1), at(90, 14, 12, -1)},
+			step:   time.Minute,
+			maxGap: time.Hour,
+			want:   []sds011.Point{at(0, 10, 20, -1), at(90, 14, 12, -1)},
+		},
+		{
+			name:   "zero step",
+			points: []sds011.Point{at(0, 10, 20, -1), at(4, 14, 12, -1)},
+			maxGap: time.Hour,
+			want:   []sds011.Point{at(0, 10, 20, -1), at(4, 14, 12, -1)},
+		},
+		{
+			name:   "negative step",
+			points: []sds011.Point{at(0, 10, 20, -1), at(4, 14, 12, -1)},
+			step:   -time.Minute,
+			maxGap: time.Hour,
+			want:   []sds011.Point{at(0, 10, 20, -1), at(4, 14, 12, -1)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Interpolate(tc.points, tc.step, tc.maxGap); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Interpolate(%v, %v, %v) = %v, want %v", tc.points, tc.step, tc.maxGap, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stats computes statistics of readings over windows of time.
+//
+// Readings don't come at regular intervals: a sensor may report every
+// second for a while, and then every few minutes, or not at all while
+// it sleeps. So that a burst of readings doesn't outweigh the rest of
+// a window, every reading is weighted by how long it stood for: the
+// time until the next reading, but no more than the MaxHold of the
+// window. The mean, median and 95th percentile are weighted; the
+// minimum and maximum aren't.
+//
+// For example, to keep the statistics of the last hour:
+//
+//	w := stats.NewWindow(time.Hour)
+//	for {
+//		point, err := sensor.Get()
+//		...
+//		w.Add(*point)
+//		s := w.Stats(time.Now(), time.Hour)
+//		fmt.Println(s.PM25.Mean, s.PM25.P95)
+//	}
+//
+// Gaps, Coverage, Interpolate and Mark deal with the stretches of time
+// without readings.
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+// Summary describes the distribution of a level.
+type Summary struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+}
+
+// Stats are the statistics of the readings in a period of time.
+type Stats struct {
+	// Count is the number of readings.
+	Count int `json:"count"`
+	// Covered is how much of the period the readings stand for.
+	Covered time.Duration `json:"-"`
+	PM25    Summary       `json:"pm25"`
+	PM10    Summary       `json:"pm10"`
+}
+
+// Compute returns the statistics of the points between from
+// (exclusive) and to (inclusive). The points must be in time order. A
+// point stands for the time until the next one, but no more than
+// maxHold, unless maxHold is 0.
+func Compute(points []sds011.Point, from, to time.Time, maxHold time.Duration) Stats {
+	i := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(from) })
+	j := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(to) })
+	points = points[i:j]
+	var s Stats
+	s.Count = len(points)
+	if s.Count == 0 {
+		return s
+	}
+	weights := make([]float64, len(points))
+	var total float64
+	for k, p := range points {
+		end := to
+		if k+1 < len(points) {
+			end = points[k+1].Timestamp
+		}
+		held := end.Sub(p.Timestamp)
+		if maxHold > 0 && held > maxHold {
+			held = maxHold
+		}
+		s.Covered += held
+		weights[k] = held.Seconds()
+		total += weights[k]
+	}
+	if total == 0 {
+		// All the points are at the same instant.
+		for k := range weights {
+			weights[k] = 1
+		}
+	}
+	s.PM25 = summarize(points, weights, func(p *sds011.Point) float64 { return p.PM25 })
+	s.PM10 = summarize(points, weights, func(p *sds011.Point) float64 { return p.PM10 })
+	return s
+}
+
+// weighted is a value with its weight.
+type weighted struct {
+	value, weight float64
+}
+
+func summarize(points []sds011.Point, weights []float64, level func(*sds011.Point) float64) Summary {
+	values := make([]weighted, len(points))
+	var total, sum float64
+	for i := range points {
+		values[i] = weighted{level(&points[i]), weights[i]}
+		total += weights[i]
+		sum += values[i].value * weights[i]
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].value < values[j].value })
+	return Summary{
+		Min:    values[0].value,
+		Max:    values[len(values)-1].value,
+		Mean:   sum / total,
+		Median: quantile(values, total, 0.5),
+		P95:    quantile(values, total, 0.95),
+	}
+}
+
+// quantile returns the q-quantile of sorted values: the smallest value
+// such that at least q of the total weight is at or below it.
+func quantile(values []weighted, total, q float64) float64 {
+	var cumulative float64
+	for _, v := range values {
+		cumulative += v.weight
+		if cumulative >= q*total {
+			return v.value
+		}
+	}
+	return values[len(values)-1].value
+}
+
+// A Window keeps the points of the last stretch of time, and computes
+// their statistics. It isn't safe for concurrent use.
+type Window struct {
+	// MaxHold is the longest a point stands for. If it's 0, a point
+	// stands for all the time until the next one.
+	MaxHold time.Duration
+
+	length time.Duration
+	points []sds011.Point
+}
+
+// NewWindow returns a window keeping the points of the last length of
+// time.
+func NewWindow(length time.Duration) *Window {
+	return &Window{length: length}
+}
+
+// Add adds a point. Points may come out of order.
+func (w *Window) Add(p sds011.Point) {
+	i := len(w.points)
+	for i > 0 && w.points[i-1].Timestamp.After(p.Timestamp) {
+		i--
+	}
+	w.points = append(w.points, sds011.Point{})
+	copy(w.points[i+1:], w.points[i:])
+	w.points[i] = p
+	w.expire(w.points[len(w.points)-1].Timestamp)
+}
+
+// expire drops the points that are out of the window as of now.
+func (w *Window) expire(now time.Time) {
+	var i int
+	for i < len(w.points) && now.Sub(w.points[i].Timestamp) >= w.length {
+		i++
+	}
+	if i > 0 {
+		w.points = append(w.points[:0], w.points[i:]...)
+	}
+}
+
+// Len returns the number of points in the window.
+func (w *Window) Len() int {
+	return len(w.points)
+}
+
+// Stats returns the statistics of the last d, as of now. d may be
+// shorter than the window.
+func (w *Window) Stats(now time.Time, d time.Duration) Stats {
+	return Compute(w.points, now.Add(-d), now, w.MaxHold)
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+)
+
+func TestCompute(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		points   []sds011.Point
+		from, to int
+		maxHold  time.Duration
+		want     Stats
+	}{
+		{
+			name:   "evenly spaced",
+			points: []sds011.Point{at(0, 10, 0, -1), at(10, 20, 0, -1), at(20, 30, 0, -1), at(30, 40, 0, -1), at(40, 50, 0, -1), at(50, 60, 0, -1)},
+			from:   -1, to: 60,
+			want: Stats{Count: 6, Covered: time.Hour, PM25: Summary{Min: 10, Max: 60, Mean: 35, Median: 30, P95: 60}},
+		},
+		{
+			name: "a burst",
+			// Ten readings in the last ten minutes don't outweigh
+			// the one that stood for the fifty before.
+			points: []sds011.Point{at(0, 10, 0, -1), at(50, 100, 0, -1), at(51, 100, 0, -1), at(52, 100, 0, -1), at(53, 100, 0, -1), at(54, 100, 0, -1), at(55, 100, 0, -1), at(56, 100, 0, -1), at(57, 100, 0, -1), at(58, 100, 0, -1), at(59, 100, 0, -1)},
+			from:   -1, to: 60,
+			want: Stats{Count: 11, Covered: time.Hour, PM25: Summary{Min: 10, Max: 100, Mean: 25, Median: 10, P95: 100}},
+		},
+		{
+			name:   "held for at most maxHold",
+			points: []sds011.Point{at(0, 10, 0, -1), at(50, 40, 0, -1)},
+			from:   -1, to: 60, maxHold: 10 * time.Minute,
+			want: Stats{Count: 2, Covered: 20 * time.Minute, PM25: Summary{Min: 10, Max: 40, Mean: 25, Median: 10, P95: 40}},
+		},
+		{
+			name: "from exclusive, to inclusive",
+			// The point at to stands for no time, so it only
+			// counts towards the minimum and maximum.
+			points: []sds011.Point{at(0, 1000, 0, -1), at(30, 10, 0, -1), at(60, 20, 0, -1), at(61, 1000, 0, -1)},
+			from:   0, to: 60,
+			want: Stats{Count: 2, Covered: 30 * time.Minute, PM25: Summary{Min: 10, Max: 20, Mean: 10, Median: 10, P95: 10}},
+		},
+		{
+			name: "at the same instant",
+			// With no time between them, the points weigh the
+			// same.
+			points: []sds011.Point{at(60, 1, 0, -1), at(60, 2, 0, -1), at(60, 3, 0, -1)},
+			from:   0, to: 60,
+			want: Stats{Count: 3, PM25: Summary{Min: 1, Max: 3, Mean: 2, Median: 2, P95: 3}},
+		},
+		{
+			name:   "no points",
+			points: []sds011.Point{at(0, 10, 0, -1)},
+			from:   0, to: 60,
+			want: Stats{},
+		},
+	} {
+		got := Compute(tc.points, t0.Add(time.Duration(tc.from)*time.Minute), t0.Add(time.Duration(tc.to)*time.Minute), tc.maxHold)
+		if got != tc.want {
+			t.Errorf("%v: %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	// The quantiles are those of the inverted empirical distribution
+	// function, definition 1 of Hyndman and Fan, "Sample Quantiles in
+	// Statistical Packages" (1996), as in R's quantile(1:20, type = 1).
+	var values []weighted
+	for v := 1.0; v <= 20; v++ {
+		values = append(values, weighted{v, 1})
+	}
+	for _, tc := range []struct {
+		q, want float64
+	}{
+		{0, 1},
+		{0.05, 1},
+		{0.25, 5},
+		{0.5, 10},
+		{0.9, 18},
+		{0.95, 19},
+		{1, 20},
+	} {
+		if got := quantile(values, 20, tc.q); got != tc.want {
+			t.Errorf("quantile %v of 1…20: %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	// Weights work like repeated values: 1 standing for 3 times as
+	// long as 2 is like 1, 1, 1, 2.
+	values = []weighted{{1, 3}, {2, 1}}
+	for _, tc := range []struct {
+		q, want float64
+	}{
+		{0.5, 1},
+		{0.75, 1},
+		{0.76, 2},
+	} {
+		if got := quantile(values, 4, tc.q); got != tc.want {
+			t.Errorf("quantile %v of 1, 1, 1, 2: %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	// The first of Anscombe's quartet, "Graphs in Statistical
+	// Analysis" (1973): y ≈ 0.5x + 3, with r ≈ 0.816.
+	x := []float64{10, 8, 13, 9, 11, 14, 6, 4, 12, 7, 5}
+	y := []float64{8.04, 6.95, 7.58, 8.81, 8.33, 9.96, 7.24, 4.26, 10.84, 4.82, 5.68}
+	slope, intercept, r := Fit(x, y)
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"slope", slope, 0.5001},
+		{"intercept", intercept, 3.0001},
+		{"r", r, 0.8164},
+	} {
+		if math.Abs(c.got-c.want) > 1e-4 {
+			t.Errorf("%v: %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	if slope, _, _ := Fit([]float64{1, 1}, []float64{1, 2}); !math.IsNaN(slope) {
+		t.Errorf("slope through a vertical line: %v, want NaN", slope)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(time.Hour)
+	for _, p := range []sds011.Point{at(0, 100, 0, -1), at(30, 10, 0, -1), at(50, 30, 0, -1), at(40, 20, 0, -1)} {
+		w.Add(p)
+	}
+	if got := w.Len(); got != 4 {
+		t.Errorf("Len: %v, want 4", got)
+	}
+	// Out of order points are put in place.
+	s := w.Stats(t0.Add(60*time.Minute), 30*time.Minute)
+	if want := (Summary{Min: 20, Max: 30, Mean: 25, Median: 20, P95: 30}); s.PM25 != want {
+		t.Errorf("Stats of the last 30m: %+v, want %+v", s.PM25, want)
+	}
+	// The point at 0 is an hour old by then.
+	w.Add(at(60, 40, 0, -1))
+	if got := w.Len(); got != 4 {
+		t.Errorf("Len after an hour: %v, want 4", got)
+	}
+}
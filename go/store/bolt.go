@@ -0,0 +1,154 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltScanBatch is how many readings the bbolt store passes to Scan at
+// a time.
+const boltScanBatch = 10000
+
+// boltStore is a Store in a bbolt database file. Every sensor gets a
+// bucket, keyed by the timestamp of the reading in nanoseconds since
+// the epoch, big endian so that the keys sort by time, with the levels
+// as two little endian float32s, as in the records of the file store.
+//
+// The file doesn't shrink when readings are pruned, but the pages they
+// took are reused, so it stops growing once it holds the retention
+// period.
+type boltStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+func openBoltStore(config *Config) (Store, error) {
+	db, err := bolt.Open(config.Path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db, retention: config.Retention}, nil
+}
+
+// boltKey returns the key of a reading taken at t. Keys are unsigned,
+// so times before the epoch all map to the first one.
+func boltKey(t time.Time) []byte {
+	n := unixNano(t)
+	if n < 0 {
+		n = 0
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(n))
+	return k
+}
+
+func boltReading(sensor string, k, v []byte) *sink.Reading {
+	return &sink.Reading{
+		Sensor: sensor,
+		Point: &sds011.Point{
+			Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(k))),
+			PM25:      roundFloat32(math.Float32frombits(binary.LittleEndian.Uint32(v[0:4]))),
+			PM10:      roundFloat32(math.Float32frombits(binary.LittleEndian.Uint32(v[4:8]))),
+		},
+	}
+}
+
+func (s *boltStore) Append(r *sink.Reading) error {
+	v := make([]byte, 8)
+	binary.LittleEndian.PutUint32(v[0:4], math.Float32bits(float32(r.PM25)))
+	binary.LittleEndian.PutUint32(v[4:8], math.Float32bits(float32(r.PM10)))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(r.Sensor))
+		if err != nil {
+			return err
+		}
+		return b.Put(boltKey(r.Timestamp), v)
+	})
+}
+
+// scan calls f with the readings of sensor taken in [from, to), at
+// most n of them (any number if n is negative), in one transaction. A
+// zero to means there is no upper limit.
+func (s *boltStore) scan(sensor string, from, to time.Time, n int, f func(*sink.Reading)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sensor))
+		if b == nil {
+			return nil
+		}
+		var end []byte
+		if !to.IsZero() {
+			end = boltKey(to)
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(boltKey(from)); k != nil && (end == nil || bytes.Compare(k, end) < 0); k, v = c.Next() {
+			f(boltReading(sensor, k, v))
+			if n--; n == 0 {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Query(sensor string, from, to time.Time, resolution time.Duration) ([]*Bucket, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	b := &bucketer{sensor: sensor, resolution: resolution}
+	err := s.scan(sensor, from, to, -1, func(r *sink.Reading) { b.add(r) })
+	return b.result(), err
+}
+
+func (s *boltStore) Scan(sensor string, from, to time.Time, f func([]*sink.Reading) error) error {
+	for {
+		var batch []*sink.Reading
+		err := s.scan(sensor, from, to, boltScanBatch, func(r *sink.Reading) { batch = append(batch, r) })
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := f(batch); err != nil {
+			return err
+		}
+		from = batch[len(batch)-1].Timestamp.Add(1)
+	}
+}
+
+func (s *boltStore) Prune(now time.Time) error {
+	cutoff := boltKey(now.Add(-s.retention))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (s *boltStore) Close() error { return s.db.Close() }
@@ -0,0 +1,444 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// The file store keeps the readings on disk, so that they survive
+// restarts. Every sensor gets a directory (named after the sensor,
+// escaped), and every day a segment file in it, named YYYY-MM-DD (in
+// UTC). A segment is a sequence of fixed size records:
+//
+//	bytes 0-7   timestamp, nanoseconds since the epoch
+//	bytes 8-11  PM2.5, float32
+//	bytes 12-15 PM10, float32
+//
+// all little endian. Segments are only ever appended to, and are
+// deleted whole when they fall out of the retention period. That
+// gives the space back to the file system immediately, so unlike a
+// B+tree file the store never needs to be compacted.
+//
+// The store can also keep the readings downsampled, in tiers of
+// coarser resolutions and longer retention periods. Once a day is
+// over, its readings are averaged into a segment for every tier, named
+// after the day and the resolution, like 2017-06-01.5m. The records
+// are the same, with the timestamp of the start of the bucket. When
+// reading a day, the finest segment available is used.
+
+const (
+	recordSize    = 16
+	segmentLayout = "2006-01-02"
+)
+
+// fileStore is a Store that persists readings in a directory.
+type fileStore struct {
+	dir       string
+	retention time.Duration
+	// tiers are the downsampling tiers, finest first.
+	tiers []Tier
+
+	mu sync.Mutex
+	// segments holds the open segment of every sensor.
+	segments map[string]*segment
+}
+
+// segment is the segment file currently being appended to.
+type segment struct {
+	day  string
+	file *os.File
+}
+
+// openFileStore opens the store in the directory config says,
+// creating it if necessary.
+func openFileStore(config *Config) (Store, error) {
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		return nil, err
+	}
+	return &fileStore{
+		dir:       config.Path,
+		retention: config.Retention,
+		tiers:     config.Downsample,
+		segments:  make(map[string]*segment),
+	}, nil
+}
+
+func (s *fileStore) sensorDir(sensor string) string {
+	return filepath.Join(s.dir, url.PathEscape(sensor))
+}
+
+func (s *fileStore) Append(r *sink.Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := r.Timestamp.UTC().Format(segmentLayout)
+	seg := s.segments[r.Sensor]
+	if seg == nil || seg.day != day {
+		if seg != nil {
+			seg.file.Close()
+			delete(s.segments, r.Sensor)
+		}
+		dir := s.sensorDir(r.Sensor)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(dir, day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		seg = &segment{day: day, file: f}
+		s.segments[r.Sensor] = seg
+	}
+	_, err := seg.file.Write(encodeRecord(r.Timestamp, r.PM25, r.PM10))
+	return err
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for sensor, seg := range s.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.segments, sensor)
+	}
+	return firstErr
+}
+
+// tierSuffix returns the suffix of the segments of a tier: the
+// resolution without zero units, like ".5m" or ".1h".
+func tierSuffix(tier Tier) string {
+	s := tier.Resolution.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return "." + s
+}
+
+// splitSegment splits the name of a segment into the day and the
+// suffix of its tier, which is empty for the raw readings.
+func splitSegment(name string) (day, suffix string) {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// finest returns the name of the finest segment of day among names,
+// or "" if there's none.
+func (s *fileStore) finest(names map[string]bool, day string) string {
+	if names[day] {
+		return day
+	}
+	for _, tier := range s.tiers {
+		if name := day + tierSuffix(tier); names[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// downsample writes the missing segments of the downsampling tiers
+// for the days that are over.
+func (s *fileStore) downsample(now time.Time) error {
+	if len(s.tiers) == 0 {
+		return nil
+	}
+	today := now.UTC().Format(segmentLayout)
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, dir.Name())
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		names, seen := make(map[string]bool), make(map[string]bool)
+		var days []string
+		for _, e := range entries {
+			names[e.Name()] = true
+			if day, _ := splitSegment(e.Name()); day < today && !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+		for _, day := range days {
+			// Every tier is averaged from the raw readings: an
+			// average of averages would give the buckets with
+			// few readings as much weight as the full ones. A
+			// tier added after the raw readings of a day were
+			// pruned doesn't get that day.
+			if !names[day] {
+				continue
+			}
+			for _, tier := range s.tiers {
+				name := day + tierSuffix(tier)
+				if names[name] {
+					continue
+				}
+				log.V(1).Infof("store: downsampling %v to %v", filepath.Join(path, day), name)
+				if err := writeDownsampled(filepath.Join(path, day), filepath.Join(path, name), tier.Resolution); err != nil {
+					return err
+				}
+				names[name] = true
+			}
+		}
+	}
+	return nil
+}
+
+// writeDownsampled averages the raw readings in the segment src into
+// buckets of the given resolution, and writes them to the segment dst.
+func writeDownsampled(src, dst string, resolution time.Duration) error {
+	var (
+		buckets []*Bucket
+		last    *Bucket
+	)
+	err := scanSegment("", src, time.Time{}, time.Time{}, func(r *sink.Reading) error {
+		start := r.Timestamp.Truncate(resolution)
+		if last == nil || !last.Start.Equal(start) {
+			last = &Bucket{Start: start}
+			buckets = append(buckets, last)
+		}
+		last.Add(r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".downsample")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, b := range buckets {
+		w.Write(encodeRecord(b.Start, b.SumPM25/float64(b.Count), b.SumPM10/float64(b.Count)))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+// encodeRecord returns the record of a reading.
+func encodeRecord(t time.Time, pm25, pm10 float64) []byte {
+	b := make([]byte, recordSize)
+	binary.LittleEndian.PutUint64(b[0:8], uint64(t.UnixNano()))
+	binary.LittleEndian.PutUint32(b[8:12], math.Float32bits(float32(pm25)))
+	binary.LittleEndian.PutUint32(b[12:16], math.Float32bits(float32(pm10)))
+	return b
+}
+
+// Prune downsamples the days that are over, and then deletes the
+// segments that are entirely older than the retention period of their
+// tier.
+func (s *fileStore) Prune(now time.Time) error {
+	if err := s.downsample(now); err != nil {
+		return err
+	}
+	return s.prune(now)
+}
+
+// prune deletes the segments that are entirely older than the
+// retention period of their tier.
+func (s *fileStore) prune(now time.Time) error {
+	cutoffs := map[string]string{"": now.Add(-s.retention).UTC().Format(segmentLayout)}
+	for _, tier := range s.tiers {
+		if tier.Retention > 0 {
+			cutoffs[tierSuffix(tier)] = now.Add(-tier.Retention).UTC().Format(segmentLayout)
+		}
+	}
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		days, err := os.ReadDir(filepath.Join(s.dir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, seg := range days {
+			day, suffix := splitSegment(seg.Name())
+			if cutoff, ok := cutoffs[suffix]; !ok || day >= cutoff {
+				continue
+			}
+			path := filepath.Join(s.dir, dir.Name(), seg.Name())
+			log.V(1).Infof("store: removing %v", path)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan calls f with every stored reading of sensor taken in [from,
+// to), in the order they were written.
+func (s *fileStore) scan(sensor string, from, to time.Time, f func(*sink.Reading) error) error {
+	paths, err := s.segmentPaths(sensor, from, to)
+	if err != nil {
+		return err
+	}
+	// Writes to the open segment happen under the lock, so holding it
+	// while reading guarantees we never see half a record.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, path := range paths {
+		if err := scanSegment(sensor, path, from, to, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan calls f with the readings of a day at a time, without holding
+// the lock.
+func (s *fileStore) Scan(sensor string, from, to time.Time, f func([]*sink.Reading) error) error {
+	paths, err := s.segmentPaths(sensor, from, to)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		var day []*sink.Reading
+		s.mu.Lock()
+		err := scanSegment(sensor, path, from, to, func(r *sink.Reading) error {
+			day = append(day, r)
+			return nil
+		})
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := f(day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentPaths returns the paths of the finest segments of sensor
+// holding the days between from and to, in order. A zero to means there is no
+// upper limit.
+func (s *fileStore) segmentPaths(sensor string, from, to time.Time) ([]string, error) {
+	dir := s.sensorDir(sensor)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var days []string
+	names, seen := make(map[string]bool), make(map[string]bool)
+	first, last := from.UTC().Format(segmentLayout), to.UTC().Format(segmentLayout)
+	for _, e := range entries {
+		names[e.Name()] = true
+		if day, _ := splitSegment(e.Name()); day >= first && (to.IsZero() || day <= last) && !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	paths := make([]string, len(days))
+	for i, day := range days {
+		paths[i] = filepath.Join(dir, s.finest(names, day))
+	}
+	return paths, nil
+}
+
+// scanSegment calls f with the readings in the segment at path taken
+// in [from, to). A zero to means there is no upper limit.
+func scanSegment(sensor, path string, from, to time.Time, f func(*sink.Reading) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	var b [recordSize]byte
+	for {
+		if _, err := io.ReadFull(br, b[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A truncated record at the end is what's left of
+			// a crash in the middle of a write.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		t := time.Unix(0, int64(binary.LittleEndian.Uint64(b[0:8])))
+		if t.Before(from) || (!to.IsZero() && !t.Before(to)) {
+			continue
+		}
+		r := &sink.Reading{
+			Sensor: sensor,
+			Point: &sds011.Point{
+				Timestamp: t,
+				PM25:      roundFloat32(math.Float32frombits(binary.LittleEndian.Uint32(b[8:12]))),
+				PM10:      roundFloat32(math.Float32frombits(binary.LittleEndian.Uint32(b[12:16]))),
+			},
+		}
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+}
+
+// roundFloat32 rounds v to 4 decimal places, getting rid of the noise
+// introduced by storing it as float32.
+func roundFloat32(v float32) float64 {
+	return math.Round(float64(v)*1e4) / 1e4
+}
+
+func (s *fileStore) Query(sensor string, from, to time.Time, resolution time.Duration) ([]*Bucket, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	b := &bucketer{sensor: sensor, resolution: resolution}
+	err := s.scan(sensor, from, to, b.add)
+	return b.result(), err
+}
@@ -0,0 +1,287 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// readingAt returns a reading of sensor taken at t.
+func readingAt(sensor string, t time.Time, pm25, pm10 float64) *sink.Reading {
+	return &sink.Reading{Sensor: sensor, Point: &sds011.Point{PM25: pm25, PM10: pm10, Timestamp: t}}
+}
+
+// date returns the time of day on a day of June 2024, in UTC.
+func date(day, hour, minute int) time.Time {
+	return time.Date(2024, 6, day, hour, minute, 0, 0, time.UTC)
+}
+
+// segments returns the names of the segments of sensor, sorted.
+func segments(t *testing.T, dir, sensor string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, sensor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// levels returns the timestamps and PM2.5 levels of buckets.
+func levels(buckets []*Bucket) map[time.Time]float64 {
+	got := make(map[time.Time]float64)
+	for _, b := range buckets {
+		got[b.Start.UTC()] = b.SumPM25 / float64(b.Count)
+	}
+	return got
+}
+
+func openTestFileStore(t *testing.T, config Config) *fileStore {
+	t.Helper()
+	st, err := openFileStore(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st.(*fileStore)
+}
+
+func TestFileStoreDays(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestFileStore(t, Config{Path: dir, Retention: 90 * 24 * time.Hour})
+	readings := []*sink.Reading{
+		readingAt("kitchen", date(1, 23, 30), 10, 20),
+		readingAt("kitchen", date(1, 23, 59), 11, 21),
+		readingAt("kitchen", date(2, 0, 0), 12, 22),
+		readingAt("kitchen", date(3, 10, 0), 13.5, 23.25),
+		readingAt("garden", date(2, 12, 0), 50, 60),
+	}
+	for _, r := range readings {
+		if err := st.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A segment a day, in UTC.
+	if got, want := segments(t, dir, "kitchen"), []string{"2024-06-01", "2024-06-02", "2024-06-03"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("segments: %v, want %v", got, want)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "kitchen", "2024-06-01")); err != nil || fi.Size() != 2*recordSize {
+		t.Errorf("2024-06-01: %v, want %d bytes", err, 2*recordSize)
+	}
+
+	want := map[time.Time]float64{date(1, 23, 30): 10, date(1, 23, 59): 11, date(2, 0, 0): 12, date(3, 10, 0): 13.5}
+	query := func(st Store) {
+		t.Helper()
+		buckets, err := st.Query("kitchen", date(1, 0, 0), date(4, 0, 0), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := levels(buckets); !reflect.DeepEqual(got, want) {
+			t.Errorf("Query: %v, want %v", got, want)
+		}
+	}
+	query(st)
+	// The readings survive a restart.
+	st.Close()
+	query(openTestFileStore(t, Config{Path: dir, Retention: 90 * 24 * time.Hour}))
+
+	// Scan passes a day at a time.
+	var days []int
+	err := st.Scan("kitchen", date(1, 0, 0), time.Time{}, func(readings []*sink.Reading) error {
+		days = append(days, len(readings))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 1, 1}; !reflect.DeepEqual(days, want) {
+		t.Errorf("Scan: batches of %v, want %v", days, want)
+	}
+}
+
+func TestFileStoreTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestFileStore(t, Config{Path: dir})
+	st.Append(readingAt("kitchen", date(1, 12, 0), 10, 20))
+	st.Close()
+	// What's left of a crash in the middle of a write.
+	f, err := os.OpenFile(filepath.Join(dir, "kitchen", "2024-06-01"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(date(1, 12, 1), 30, 40)[:recordSize/2])
+	f.Close()
+	buckets, err := st.Query("kitchen", date(1, 0, 0), date(2, 0, 0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := levels(buckets), map[time.Time]float64{date(1, 12, 0): 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Query: %v, want %v", got, want)
+	}
+}
+
+func TestFileStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestFileStore(t, Config{Path: dir, Retention: 48 * time.Hour})
+	for day := 1; day <= 5; day++ {
+		st.Append(readingAt("kitchen", date(day, 12, 0), float64(day), 0))
+	}
+	// Segments are deleted once all of their day is past retention.
+	if err := st.Prune(date(5, 13, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := segments(t, dir, "kitchen"), []string{"2024-06-03", "2024-06-04", "2024-06-05"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("segments: %v, want %v", got, want)
+	}
+	// The open segment is still appended to.
+	if err := st.Append(readingAt("kitchen", date(5, 12, 30), 6, 0)); err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := st.Query("kitchen", date(5, 0, 0), date(6, 0, 0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 {
+		t.Errorf("Query: %d readings, want 2", len(buckets))
+	}
+}
+
+func TestFileStoreDownsampleFromRaw(t *testing.T) {
+	dir := t.TempDir()
+	fiveMinutes, hourly := Tier{Resolution: 5 * time.Minute}, Tier{Resolution: time.Hour}
+	st := openTestFileStore(t, Config{Path: dir, Retention: 48 * time.Hour, Downsample: []Tier{fiveMinutes, hourly}})
+	// One reading at 12:00, and four at 12:55 to 12:58.
+	st.Append(readingAt("kitchen", date(1, 12, 0), 0, 0))
+	for i := 0; i < 4; i++ {
+		st.Append(readingAt("kitchen", date(1, 12, 55+i), 10, 0))
+	}
+	if err := st.Prune(date(2, 13, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// The hourly average is of the readings, not of the 5 minute
+	// averages.
+	var got []float64
+	err := scanSegment("kitchen", filepath.Join(dir, "kitchen", "2024-06-01.1h"), time.Time{}, time.Time{}, func(r *sink.Reading) error {
+		got = append(got, r.PM25)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hourly averages: %v, want %v", got, want)
+	}
+
+	// Once the raw readings are gone, a missing tier isn't averaged
+	// from the 5 minute one.
+	if err := st.Prune(date(4, 13, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "kitchen", "2024-06-01.1h")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Prune(date(4, 13, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := segments(t, dir, "kitchen"), []string{"2024-06-01.5m"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("segments: %v, want %v", got, want)
+	}
+}
+
+func TestFileStoreDownsample(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestFileStore(t, Config{
+		Path:      dir,
+		Retention: 48 * time.Hour,
+		Downsample: []Tier{
+			{Resolution: 5 * time.Minute, Retention: 7 * 24 * time.Hour},
+			{Resolution: time.Hour},
+		},
+	})
+	// A reading a minute from 12:00 to 13:09 on the 1st, and one on
+	// the 2nd.
+	for i := 0; i < 70; i++ {
+		st.Append(readingAt("kitchen", date(1, 12, i), float64(i), 0))
+	}
+	st.Append(readingAt("kitchen", date(2, 12, 0), 100, 0))
+	query := func() map[time.Time]float64 {
+		t.Helper()
+		buckets, err := st.Query("kitchen", date(1, 0, 0), date(2, 0, 0), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return levels(buckets)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		now      time.Time
+		segments []string
+		// readings are how many readings of the 1st are left, and
+		// first is the first of them.
+		readings int
+		first    float64
+	}{
+		{
+			// The days that are over are downsampled, and the raw
+			// readings kept for their retention.
+			name:     "downsampled",
+			now:      date(2, 13, 0),
+			segments: []string{"2024-06-01", "2024-06-01.1h", "2024-06-01.5m", "2024-06-02"},
+			readings: 70,
+			first:    0,
+		},
+		{
+			// The finest tier left takes over: the mean of
+			// 12:00 to 12:04.
+			name:     "raw readings pruned",
+			now:      date(4, 13, 0),
+			segments: []string{"2024-06-01.1h", "2024-06-01.5m", "2024-06-02", "2024-06-02.1h", "2024-06-02.5m"},
+			readings: 14,
+			first:    2,
+		},
+		{
+			// The hourly tier is kept forever.
+			name:     "5m pruned",
+			now:      date(30, 13, 0),
+			segments: []string{"2024-06-01.1h", "2024-06-02.1h"},
+			readings: 2,
+			first:    29.5,
+		},
+	} {
+		if err := st.Prune(tc.now); err != nil {
+			t.Fatal(err)
+		}
+		if got := segments(t, dir, "kitchen"); !reflect.DeepEqual(got, tc.segments) {
+			t.Errorf("%v: segments: %v, want %v", tc.name, got, tc.segments)
+		}
+		got := query()
+		if len(got) != tc.readings || got[date(1, 12, 0)] != tc.first {
+			t.Errorf("%v: %d readings, the first %v, want %d and %v", tc.name, len(got), got[date(1, 12, 0)], tc.readings, tc.first)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// memoryScanBatch is how many readings the memory store passes to Scan
+// at a time.
+const memoryScanBatch = 10000
+
+// memoryStore is a Store that keeps the readings in memory, as compact
+// records like those of the file store. Nothing survives a restart,
+// but nothing is written to disk either, which spares SD cards. A
+// reading a second takes about 1.4 MB a day.
+type memoryStore struct {
+	retention time.Duration
+
+	mu sync.Mutex
+	// records are the readings of every sensor, oldest first.
+	records map[string][]memoryRecord
+}
+
+type memoryRecord struct {
+	t          int64
+	pm25, pm10 float32
+}
+
+func (rec *memoryRecord) reading(sensor string) *sink.Reading {
+	return &sink.Reading{
+		Sensor: sensor,
+		Point: &sds011.Point{
+			Timestamp: time.Unix(0, rec.t),
+			PM25:      roundFloat32(rec.pm25),
+			PM10:      roundFloat32(rec.pm10),
+		},
+	}
+}
+
+func openMemoryStore(config *Config) (Store, error) {
+	return &memoryStore{retention: config.Retention, records: make(map[string][]memoryRecord)}, nil
+}
+
+func (s *memoryStore) Append(r *sink.Reading) error {
+	rec := memoryRecord{r.Timestamp.UnixNano(), float32(r.PM25), float32(r.PM10)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs := s.records[r.Sensor]
+	// Readings come in order, unless the clock was set back.
+	i := len(recs)
+	if i > 0 && recs[i-1].t > rec.t {
+		i = s.search(recs, rec.t+1)
+	}
+	recs = append(recs, memoryRecord{})
+	copy(recs[i+1:], recs[i:])
+	recs[i] = rec
+	s.records[r.Sensor] = recs
+	return nil
+}
+
+// search returns the index of the first of recs taken at t or later.
+func (s *memoryStore) search(recs []memoryRecord, t int64) int {
+	return sort.Search(len(recs), func(i int) bool { return recs[i].t >= t })
+}
+
+// window returns the index of the first of recs taken at from or
+// later, and of the first taken at to or later. A zero to means there
+// is no upper limit.
+func (s *memoryStore) window(recs []memoryRecord, from, to time.Time) (int, int) {
+	i, j := s.search(recs, unixNano(from)), len(recs)
+	if !to.IsZero() {
+		j = s.search(recs, unixNano(to))
+	}
+	return i, j
+}
+
+func (s *memoryStore) Query(sensor string, from, to time.Time, resolution time.Duration) ([]*Bucket, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	b := &bucketer{sensor: sensor, resolution: resolution}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs := s.records[sensor]
+	i, j := s.window(recs, from, to)
+	for k := i; k < j; k++ {
+		b.add(recs[k].reading(sensor))
+	}
+	return b.result(), nil
+}
+
+func (s *memoryStore) Scan(sensor string, from, to time.Time, f func([]*sink.Reading) error) error {
+	for {
+		s.mu.Lock()
+		recs := s.records[sensor]
+		i, j := s.window(recs, from, to)
+		if j > i+memoryScanBatch {
+			j = i + memoryScanBatch
+		}
+		batch := make([]*sink.Reading, 0, j-i)
+		for k := i; k < j; k++ {
+			batch = append(batch, recs[k].reading(sensor))
+		}
+		s.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := f(batch); err != nil {
+			return err
+		}
+		// Pick up after the last reading of the batch, wherever it
+		// is now.
+		from = batch[len(batch)-1].Timestamp.Add(1)
+	}
+}
+
+func (s *memoryStore) Prune(now time.Time) error {
+	cutoff := now.Add(-s.retention).UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sensor, recs := range s.records {
+		i := s.search(recs, cutoff)
+		// Copy, so that the pruned records are given back.
+		s.records[sensor] = append([]memoryRecord(nil), recs[i:]...)
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error { return nil }
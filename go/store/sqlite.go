@@ -0,0 +1,129 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ryszard/sds011/go/sds011"
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// sqliteScanBatch is how many readings the SQLite store passes to Scan
+// at a time.
+const sqliteScanBatch = 10000
+
+// sqliteSchema is the schema of the SQLite store. Timestamps are in
+// nanoseconds since the epoch. With incremental auto vacuum, the
+// space of pruned readings is given back to the file system.
+const sqliteSchema = `
+PRAGMA auto_vacuum = INCREMENTAL;
+CREATE TABLE IF NOT EXISTS readings (
+	sensor TEXT NOT NULL,
+	t INTEGER NOT NULL,
+	pm25 REAL NOT NULL,
+	pm10 REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS readings_sensor_t ON readings (sensor, t);
+`
+
+// sqliteStore is a Store in an SQLite database file, for deployments
+// that want to get at the readings with SQL too.
+type sqliteStore struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+func openSQLiteStore(config *Config) (Store, error) {
+	// WAL lets readers go on while a reading is appended.
+	db, err := sql.Open("sqlite3", "file:"+config.Path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db, retention: config.Retention}, nil
+}
+
+func (s *sqliteStore) Append(r *sink.Reading) error {
+	_, err := s.db.Exec("INSERT INTO readings (sensor, t, pm25, pm10) VALUES (?, ?, ?, ?)", r.Sensor, r.Timestamp.UnixNano(), r.PM25, r.PM10)
+	return err
+}
+
+// scan calls f with the readings of sensor taken in [from, to), at
+// most n of them (any number if n is negative). A zero to means there
+// is no upper limit.
+func (s *sqliteStore) scan(sensor string, from, to time.Time, n int, f func(*sink.Reading)) error {
+	end := int64(math.MaxInt64)
+	if !to.IsZero() {
+		end = unixNano(to)
+	}
+	rows, err := s.db.Query("SELECT t, pm25, pm10 FROM readings WHERE sensor = ? AND t >= ? AND t < ? ORDER BY t LIMIT ?", sensor, unixNano(from), end, n)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t int64
+		p := new(sds011.Point)
+		if err := rows.Scan(&t, &p.PM25, &p.PM10); err != nil {
+			return err
+		}
+		p.Timestamp = time.Unix(0, t)
+		f(&sink.Reading{Sensor: sensor, Point: p})
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) Query(sensor string, from, to time.Time, resolution time.Duration) ([]*Bucket, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	b := &bucketer{sensor: sensor, resolution: resolution}
+	err := s.scan(sensor, from, to, -1, func(r *sink.Reading) { b.add(r) })
+	return b.result(), err
+}
+
+func (s *sqliteStore) Scan(sensor string, from, to time.Time, f func([]*sink.Reading) error) error {
+	for {
+		var batch []*sink.Reading
+		err := s.scan(sensor, from, to, sqliteScanBatch, func(r *sink.Reading) { batch = append(batch, r) })
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := f(batch); err != nil {
+			return err
+		}
+		from = batch[len(batch)-1].Timestamp.Add(1)
+	}
+}
+
+func (s *sqliteStore) Prune(now time.Time) error {
+	if _, err := s.db.Exec("DELETE FROM readings WHERE t < ?", now.Add(-s.retention).UnixNano()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("PRAGMA incremental_vacuum")
+	return err
+}
+
+func (s *sqliteStore) Close() error { return s.db.Close() }
@@ -0,0 +1,194 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package store keeps the readings of sensors for a long time, at full
+// resolution, so that they can be queried and exported. sds011d keeps
+// its readings in one, and sds011import loads recorded readings into
+// it. There are several backends, described by Config.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// A Store keeps the readings of the sensors. Its methods may be called
+// concurrently.
+type Store interface {
+	// Append stores a reading.
+	Append(r *sink.Reading) error
+	// Query returns the readings of sensor taken in [from, to),
+	// averaged into buckets of the given resolution. A zero
+	// resolution returns every reading in its own bucket. A zero to
+	// means now.
+	Query(sensor string, from, to time.Time, resolution time.Duration) ([]*Bucket, error)
+	// Scan calls f with the readings of sensor taken in [from, to),
+	// in order, a batch at a time. f may take its time, without
+	// holding up Append. A zero to means there is no upper limit.
+	Scan(sensor string, from, to time.Time, f func([]*sink.Reading) error) error
+	// Prune deletes the readings that are past retention as of now.
+	Prune(now time.Time) error
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// Config describes a store.
+type Config struct {
+	// Backend is "files", which keeps the readings on disk, in
+	// segment files in the directory Path, "bbolt" or "sqlite",
+	// which keep them in a database file at Path, or "memory",
+	// which only keeps them until the process exits.
+	Backend string
+	// Path is the directory of the files backend, or the database
+	// file of bbolt and sqlite.
+	Path string
+	// Retention is how long readings are kept.
+	Retention time.Duration
+	// Downsample are tiers of averaged readings, kept for longer,
+	// in order of increasing resolution. Only the files backend
+	// has them.
+	Downsample []Tier
+}
+
+// Tier is a tier of averaged readings.
+type Tier struct {
+	// Resolution is the length of the buckets readings are averaged
+	// into. It should divide a day.
+	Resolution time.Duration
+	// Retention is how long the averages are kept. Zero means
+	// forever.
+	Retention time.Duration
+}
+
+// backends open the stores of the backends, by name.
+var backends = map[string]func(config *Config) (Store, error){
+	"files":  openFileStore,
+	"bbolt":  openBoltStore,
+	"sqlite": openSQLiteStore,
+	"memory": openMemoryStore,
+}
+
+// Backends returns the names of the backends, sorted.
+func Backends() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the store config describes. It doesn't prune it.
+func Open(config *Config) (Store, error) {
+	open, ok := backends[config.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, want one of %v", config.Backend, strings.Join(Backends(), ", "))
+	}
+	return open(config)
+}
+
+// Sink is a sink.Sink appending to a store. Closing it closes the
+// store.
+type Sink struct {
+	Store
+}
+
+func (s Sink) Write(r *sink.Reading) error { return s.Append(r) }
+func (s Sink) Flush() error                { return nil }
+
+// unixNano returns t in nanoseconds since the epoch, clamped to the
+// range of int64. Times out of it, like the zero time used as an open
+// lower bound, otherwise give nonsense.
+func unixNano(t time.Time) int64 {
+	switch {
+	case t.Before(time.Unix(0, math.MinInt64)):
+		return math.MinInt64
+	case t.After(time.Unix(0, math.MaxInt64)):
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+// A Bucket summarizes the readings of a sensor taken within a single
+// interval.
+type Bucket struct {
+	Sensor string
+	Start  time.Time
+	Count  int
+	// SumPM25 and SumPM10 are the sums of the readings in the
+	// bucket.
+	SumPM25 float64
+	SumPM10 float64
+}
+
+// Add adds a reading to the bucket.
+func (b *Bucket) Add(r *sink.Reading) {
+	b.Count++
+	b.SumPM25 += r.PM25
+	b.SumPM10 += r.PM10
+}
+
+// Merge adds the readings of other to the bucket.
+func (b *Bucket) Merge(other *Bucket) {
+	b.Count += other.Count
+	b.SumPM25 += other.SumPM25
+	b.SumPM10 += other.SumPM10
+}
+
+// MarshalJSON implements json.Marshaler. The bucket is represented by
+// the mean of its readings.
+func (b *Bucket) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Sensor    string    `json:"sensor"`
+		Timestamp time.Time `json:"timestamp"`
+		PM25      float64   `json:"pm25"`
+		PM10      float64   `json:"pm10"`
+		Samples   int       `json:"samples"`
+	}{b.Sensor, b.Start, b.SumPM25 / float64(b.Count), b.SumPM10 / float64(b.Count), b.Count})
+}
+
+// bucketer averages readings, in order, into buckets of a resolution,
+// or puts every one in its own bucket if the resolution is zero.
+type bucketer struct {
+	sensor     string
+	resolution time.Duration
+	buckets    []*Bucket
+}
+
+func (b *bucketer) add(r *sink.Reading) error {
+	start := r.Timestamp
+	if b.resolution > 0 {
+		start = start.Truncate(b.resolution)
+	}
+	if n := len(b.buckets); n == 0 || !b.buckets[n-1].Start.Equal(start) {
+		b.buckets = append(b.buckets, &Bucket{Sensor: b.sensor, Start: start})
+	}
+	b.buckets[len(b.buckets)-1].Add(r)
+	return nil
+}
+
+// result returns the buckets, which is never nil, so that no readings
+// are JSON encoded as an empty array.
+func (b *bucketer) result() []*Bucket {
+	if b.buckets == nil {
+		return []*Bucket{}
+	}
+	return b.buckets
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Ryszard Szopa <ryszard.szopa@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryszard/sds011/go/sink"
+)
+
+// The backends must behave the same.
+func forEachBackend(t *testing.T, retention time.Duration, f func(t *testing.T, st Store)) {
+	for _, backend := range []string{"files", "bbolt", "sqlite", "memory"} {
+		t.Run(backend, func(t *testing.T) {
+			config := &Config{Backend: backend, Retention: retention}
+			switch backend {
+			case "files":
+				config.Path = t.TempDir()
+			case "bbolt", "sqlite":
+				config.Path = filepath.Join(t.TempDir(), "store")
+			}
+			st, err := Open(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			f(t, st)
+		})
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	forEachBackend(t, 90*24*time.Hour, func(t *testing.T, st Store) {
+		for i, pm := range []float64{10, 20, 30, 40} {
+			if err := st.Append(readingAt("kitchen", date(1, 12, 10*i), pm, 2*pm)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		st.Append(readingAt("garden", date(1, 12, 0), 100, 100))
+		for _, tc := range []struct {
+			name       string
+			from, to   time.Time
+			resolution time.Duration
+			want       map[time.Time]float64
+		}{
+			{"every reading", date(1, 0, 0), date(2, 0, 0), 0, map[time.Time]float64{date(1, 12, 0): 10, date(1, 12, 10): 20, date(1, 12, 20): 30, date(1, 12, 30): 40}},
+			{"to is exclusive", date(1, 12, 10), date(1, 12, 30), 0, map[time.Time]float64{date(1, 12, 10): 20, date(1, 12, 20): 30}},
+			{"averaged", date(1, 0, 0), date(2, 0, 0), 20 * time.Minute, map[time.Time]float64{date(1, 12, 0): 15, date(1, 12, 20): 35}},
+			{"nothing", date(2, 0, 0), date(3, 0, 0), 0, map[time.Time]float64{}},
+		} {
+			buckets, err := st.Query("kitchen", tc.from, tc.to, tc.resolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := levels(buckets); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%v: %v, want %v", tc.name, got, tc.want)
+			}
+			// No readings are an empty array in JSON, not null.
+			if buckets == nil {
+				t.Errorf("%v: nil buckets", tc.name)
+			}
+		}
+	})
+}
+
+func TestStoreScan(t *testing.T) {
+	forEachBackend(t, 90*24*time.Hour, func(t *testing.T, st Store) {
+		var want []float64
+		for day := 1; day <= 3; day++ {
+			for i := 0; i < 3; i++ {
+				pm := float64(10*day + i)
+				st.Append(readingAt("kitchen", date(day, 12, i), pm, pm))
+				want = append(want, pm)
+			}
+		}
+		// The zero time is before every reading.
+		for _, from := range []time.Time{date(1, 0, 0), {}} {
+			var got []float64
+			err := st.Scan("kitchen", from, time.Time{}, func(readings []*sink.Reading) error {
+				for _, r := range readings {
+					if r.Sensor != "kitchen" {
+						t.Errorf("a reading of %q", r.Sensor)
+					}
+					got = append(got, r.PM25)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Scan from %v: %v, want %v", from, got, want)
+			}
+		}
+	})
+}
+
+func TestStorePrune(t *testing.T) {
+	forEachBackend(t, 48*time.Hour, func(t *testing.T, st Store) {
+		for day := 1; day <= 5; day++ {
+			st.Append(readingAt("kitchen", date(day, 12, 0), float64(day), 0))
+		}
+		if err := st.Prune(date(5, 13, 0)); err != nil {
+			t.Fatal(err)
+		}
+		buckets, err := st.Query("kitchen", date(1, 0, 0), date(6, 0, 0), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file store deletes whole days, so it may keep a
+		// little more.
+		got := levels(buckets)
+		if _, ok := got[date(2, 12, 0)]; ok || len(got) < 2 {
+			t.Errorf("after pruning: %v, want the readings of the 3rd on at least", got)
+		}
+		for day := 4; day <= 5; day++ {
+			if _, ok := got[date(day, 12, 0)]; !ok {
+				t.Errorf("after pruning: %v, lost the reading of the %dth", got, day)
+			}
+		}
+	})
+}
+
+func TestOpenUnknownBackend(t *testing.T) {
+	if _, err := Open(&Config{Backend: "influxdb"}); err == nil {
+		t.Error("Open with an unknown backend: no error")
+	}
+}